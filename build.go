@@ -31,6 +31,8 @@ var (
 	goarch    string
 	goos      string
 	noupgrade bool
+	withFuse  bool
+	headless  bool
 )
 
 const minGoVersion = 1.3
@@ -53,6 +55,8 @@ func main() {
 	flag.StringVar(&goarch, "goarch", runtime.GOARCH, "GOARCH")
 	flag.StringVar(&goos, "goos", runtime.GOOS, "GOOS")
 	flag.BoolVar(&noupgrade, "no-upgrade", false, "Disable upgrade functionality")
+	flag.BoolVar(&withFuse, "fuse", false, "Enable mounting folders with FUSE (requires bazil.org/fuse)")
+	flag.BoolVar(&headless, "headless", false, "Leave out the web GUI, keeping only the REST API")
 	flag.Parse()
 
 	switch goarch {
@@ -85,7 +89,13 @@ func main() {
 			pkg := "./cmd/syncthing"
 			var tags []string
 			if noupgrade {
-				tags = []string{"noupgrade"}
+				tags = append(tags, "noupgrade")
+			}
+			if withFuse {
+				tags = append(tags, "fuse")
+			}
+			if headless {
+				tags = append(tags, "headless")
 			}
 			build(pkg, tags)
 
@@ -233,7 +243,7 @@ func assets() {
 }
 
 func xdr() {
-	for _, f := range []string{"internal/discover/packets", "internal/files/leveldb", "internal/protocol/message"} {
+	for _, f := range []string{"internal/discover/packets", "internal/files/leveldb", "lib/protocol/message", "lib/relay/protocol/packets"} {
 		runPipe(f+"_xdr.go", "go", "run", "./Godeps/_workspace/src/github.com/calmh/xdr/cmd/genxdr/main.go", "--", f+".go")
 	}
 }
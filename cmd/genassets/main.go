@@ -18,7 +18,9 @@ import (
 	"text/template"
 )
 
-var tpl = template.Must(template.New("assets").Parse(`package auto
+var tpl = template.Must(template.New("assets").Parse(`// +build !headless
+
+package auto
 
 import (
 	"bytes"
@@ -27,6 +29,8 @@ import (
 	"io/ioutil"
 )
 
+const Headless = false
+
 func Assets() map[string][]byte {
 	var assets = make(map[string][]byte, {{.assets | len}})
 	var bs []byte
@@ -11,7 +11,7 @@ import (
 	"os"
 
 	"github.com/syncthing/syncthing/internal/files"
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/lib/protocol"
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
@@ -0,0 +1,245 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"sync"
+	"time"
+
+	syncthingprotocol "github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/relay/protocol"
+)
+
+var (
+	outboxesMut sync.RWMutex
+	outboxes    = make(map[syncthingprotocol.DeviceID]chan interface{})
+)
+
+// handleConnection sets up a new connection, which is either a device
+// joining the relay or a device joining a session.
+func handleConnection(conn *tls.Conn) {
+	conn.SetDeadline(time.Now().Add(messageTimeout))
+	if err := conn.Handshake(); err != nil {
+		if debug {
+			log.Println("TLS handshake with", conn.RemoteAddr(), "failed:", err)
+		}
+		conn.Close()
+		return
+	}
+
+	state := conn.ConnectionState()
+	if !state.NegotiatedProtocolIsMutual || state.NegotiatedProtocol != protocol.ProtocolName {
+		if debug {
+			log.Println("Protocol negotiation with", conn.RemoteAddr(), "failed")
+		}
+		conn.Close()
+		return
+	}
+
+	id, err := syncthingprotocol.PeerDeviceID(state)
+	if err != nil {
+		if debug {
+			log.Println("Connection from", conn.RemoteAddr(), "failed:", err)
+		}
+		conn.Close()
+		return
+	}
+
+	message, err := protocol.ReadMessage(conn)
+	if err != nil {
+		if debug {
+			log.Println("Reading message from", id, "failed:", err)
+		}
+		conn.Close()
+		return
+	}
+
+	switch msg := message.(type) {
+	case protocol.JoinRelayRequest:
+		if tokens != nil && !tokens[msg.Token] {
+			if debug {
+				log.Println(id, "presented an invalid join token")
+			}
+			protocol.WriteMessage(conn, protocol.ResponseForbidden)
+			conn.Close()
+			return
+		}
+
+		outbox := make(chan interface{}, 16)
+		outboxesMut.Lock()
+		_, joined := outboxes[id]
+		if !joined {
+			outboxes[id] = outbox
+		}
+		outboxesMut.Unlock()
+		if joined {
+			if debug {
+				log.Println(id, "is already joined")
+			}
+			protocol.WriteMessage(conn, protocol.ResponseAlreadyJoined)
+			conn.Close()
+			return
+		}
+
+		if err := protocol.WriteMessage(conn, protocol.ResponseSuccess); err != nil {
+			conn.Close()
+			dropOutbox(id, outbox)
+			return
+		}
+		if debug {
+			log.Println(id, "joined the relay from", conn.RemoteAddr())
+		}
+		serveProtocol(conn, id, outbox)
+
+	case protocol.JoinSessionRequest:
+		ses := findSession(msg.Key)
+		if ses == nil {
+			if debug {
+				log.Println(id, "asked to join an unknown session")
+			}
+			protocol.WriteMessage(conn, protocol.ResponseNotFound)
+			conn.Close()
+			return
+		}
+		if err := ses.join(msg.Key, id, conn); err != nil {
+			if debug {
+				log.Println(id, "failed to join session", ses, "-", err)
+			}
+			if err == errAlreadyJoined {
+				protocol.WriteMessage(conn, protocol.ResponseAlreadyJoined)
+			} else {
+				protocol.WriteMessage(conn, protocol.ResponseForbidden)
+			}
+			conn.Close()
+			return
+		}
+		if debug {
+			log.Println(id, "joined session", ses)
+		}
+
+	default:
+		if debug {
+			log.Printf("Unexpected %T from %v", message, id)
+		}
+		protocol.WriteMessage(conn, protocol.ResponseUnexpected)
+		conn.Close()
+	}
+}
+
+// serveProtocol serves a device that has joined the relay until it goes
+// away: it answers its messages, passes on the session invitations for it,
+// and pings it to make sure it is still there.
+func serveProtocol(conn *tls.Conn, id syncthingprotocol.DeviceID, outbox chan interface{}) {
+	defer dropOutbox(id, outbox)
+	defer conn.Close()
+
+	messages := make(chan interface{})
+	errors := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			conn.SetReadDeadline(time.Now().Add(networkTimeout))
+			message, err := protocol.ReadMessage(conn)
+			if err != nil {
+				errors <- err
+				return
+			}
+			select {
+			case messages <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	pingTicker := time.NewTicker(pingInterval)
+	defer pingTicker.Stop()
+
+	for {
+		var reply interface{}
+
+		select {
+		case message := <-messages:
+			switch msg := message.(type) {
+			case protocol.Ping:
+				reply = protocol.Pong{}
+
+			case protocol.Pong:
+				continue
+
+			case protocol.ConnectRequest:
+				reply = connectRequest(id, msg)
+
+			default:
+				if debug {
+					log.Printf("Unexpected %T from %v", message, id)
+				}
+				reply = protocol.ResponseUnexpected
+			}
+
+		case reply = <-outbox:
+
+		case <-pingTicker.C:
+			reply = protocol.Ping{}
+
+		case err := <-errors:
+			if debug {
+				log.Println(id, "left the relay:", err)
+			}
+			return
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(networkTimeout))
+		if err := protocol.WriteMessage(conn, reply); err != nil {
+			if debug {
+				log.Println("Writing to", id, "failed:", err)
+			}
+			return
+		}
+	}
+}
+
+// connectRequest sets up a session between the device asking to connect and
+// the device it asks for, and returns the invitation to send in reply.
+func connectRequest(from syncthingprotocol.DeviceID, msg protocol.ConnectRequest) interface{} {
+	if len(msg.ID) != len(syncthingprotocol.DeviceID{}) {
+		return protocol.ResponseNotFound
+	}
+	var to syncthingprotocol.DeviceID
+	copy(to[:], msg.ID)
+
+	outboxesMut.RLock()
+	outbox, ok := outboxes[to]
+	outboxesMut.RUnlock()
+	if !ok || to == from {
+		if debug {
+			log.Println(from, "asked to connect to", to, "which is not joined")
+		}
+		return protocol.ResponseNotFound
+	}
+
+	ses := newSession(to, from)
+	select {
+	case outbox <- ses.invitation(true):
+	default:
+		ses.close()
+		return protocol.ResponseNotFound
+	}
+	if debug {
+		log.Println(from, "asked to connect to", to, "in session", ses)
+	}
+	return ses.invitation(false)
+}
+
+func dropOutbox(id syncthingprotocol.DeviceID, outbox chan interface{}) {
+	outboxesMut.Lock()
+	if outboxes[id] == outbox {
+		delete(outboxes, id)
+	}
+	outboxesMut.Unlock()
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Command strelaysrv is a relay server, passing the traffic between devices
+// that cannot connect to each other directly. See the relay protocol package
+// for how devices use it.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/relay/protocol"
+)
+
+var (
+	listen         string
+	debug          bool
+	perDeviceKbps  int
+	messageTimeout = time.Minute
+	networkTimeout = 2 * time.Minute
+	pingInterval   = time.Minute
+
+	// The tokens a device must present to join the relay; anyone may join
+	// when there are none.
+	tokens map[string]bool
+)
+
+func main() {
+	log.SetFlags(log.Lshortfile | log.LstdFlags)
+
+	var dir, tokenList string
+	flag.StringVar(&listen, "listen", ":22067", "Protocol listen address")
+	flag.StringVar(&dir, "keys", ".", "Directory where cert.pem and key.pem is stored")
+	flag.StringVar(&tokenList, "tokens", "", "Comma separated list of tokens a device must present to join the relay (default none required)")
+	flag.IntVar(&perDeviceKbps, "per-device-rate", 0, "Rate limit for the traffic sent by each device, in KiB/s (default unlimited)")
+	flag.DurationVar(&messageTimeout, "message-timeout", messageTimeout, "Time to wait for the expected messages and for both devices to join a session")
+	flag.DurationVar(&networkTimeout, "network-timeout", networkTimeout, "Timeout for network operations")
+	flag.DurationVar(&pingInterval, "ping-interval", pingInterval, "How often pings are sent to joined devices")
+	flag.BoolVar(&debug, "debug", false, "Enable debug output")
+	flag.Parse()
+
+	for _, token := range strings.Split(tokenList, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			if tokens == nil {
+				tokens = make(map[string]bool)
+			}
+			tokens[token] = true
+		}
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if os.IsNotExist(err) {
+		log.Println("Generating key and certificate...")
+		if err = newCertificate(certFile, keyFile); err == nil {
+			cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+		}
+	}
+	if err != nil {
+		log.Fatalln("Failed to load keypair:", err)
+	}
+
+	tlsCfg := &tls.Config{
+		Certificates:           []tls.Certificate{cert},
+		NextProtos:             []string{protocol.ProtocolName},
+		ClientAuth:             tls.RequestClientCert,
+		SessionTicketsDisabled: true,
+		InsecureSkipVerify:     true,
+		MinVersion:             tls.VersionTLS12,
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Fatalln("Listen:", err)
+	}
+	log.Println("Listening for relay connections on", listener.Addr())
+	if tokens != nil {
+		log.Printf("Devices must present one of %d join tokens", len(tokens))
+	}
+	if perDeviceKbps > 0 {
+		log.Printf("Traffic sent by each device is limited to %d KiB/s", perDeviceKbps)
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if debug {
+				log.Println("Accept:", err)
+			}
+			continue
+		}
+		go handleConnection(tls.Server(conn, tlsCfg))
+	}
+}
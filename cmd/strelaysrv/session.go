@@ -0,0 +1,267 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/juju/ratelimit"
+	syncthingprotocol "github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/relay/protocol"
+)
+
+var (
+	errAlreadyJoined = errors.New("already joined")
+	errWrongDevice   = errors.New("invitation is for another device")
+)
+
+var (
+	sessionsMut sync.Mutex
+	sessions    = make(map[string]*session)
+)
+
+// A session passes the traffic between two devices. The device that was
+// asked for is the server side, the device that asked to connect is the
+// client side; each joins the session with the key from its own invitation.
+type session struct {
+	serverKey []byte
+	clientKey []byte
+	serverID  syncthingprotocol.DeviceID
+	clientID  syncthingprotocol.DeviceID
+
+	mut     sync.Mutex
+	server  net.Conn
+	client  net.Conn
+	expired bool
+}
+
+func newSession(serverID, clientID syncthingprotocol.DeviceID) *session {
+	s := &session{
+		serverKey: newKey(),
+		clientKey: newKey(),
+		serverID:  serverID,
+		clientID:  clientID,
+	}
+
+	sessionsMut.Lock()
+	sessions[string(s.serverKey)] = s
+	sessions[string(s.clientKey)] = s
+	sessionsMut.Unlock()
+
+	time.AfterFunc(messageTimeout, s.expire)
+	return s
+}
+
+func newKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func findSession(key []byte) *session {
+	sessionsMut.Lock()
+	defer sessionsMut.Unlock()
+	return sessions[string(key)]
+}
+
+func (s *session) String() string {
+	return hex.EncodeToString(s.serverKey[:4])
+}
+
+// invitation returns the invitation for the server or client side of the
+// session. It carries no address, meaning the session is joined at this
+// relay.
+func (s *session) invitation(server bool) protocol.SessionInvitation {
+	if server {
+		return protocol.SessionInvitation{
+			From:         s.clientID[:],
+			Key:          s.serverKey,
+			ServerSocket: true,
+		}
+	}
+	return protocol.SessionInvitation{
+		From: s.serverID[:],
+		Key:  s.clientKey,
+	}
+}
+
+// join adds the connection to the session as the side the key is for, and
+// starts passing the traffic once both sides have joined.
+func (s *session) join(key []byte, id syncthingprotocol.DeviceID, conn net.Conn) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	server := bytes.Equal(key, s.serverKey)
+	switch {
+	case s.expired:
+		return errAlreadyJoined
+	case server && id != s.serverID, !server && id != s.clientID:
+		return errWrongDevice
+	case server && s.server != nil, !server && s.client != nil:
+		return errAlreadyJoined
+	}
+
+	// The response must be on its way before any of the other side's
+	// traffic.
+	conn.SetWriteDeadline(time.Now().Add(networkTimeout))
+	if err := protocol.WriteMessage(conn, protocol.ResponseSuccess); err != nil {
+		return err
+	}
+
+	if server {
+		s.server = conn
+	} else {
+		s.client = conn
+	}
+	if s.server != nil && s.client != nil {
+		s.forget()
+		go s.proxy()
+	}
+	return nil
+}
+
+// expire closes the session if both sides have not joined it in time.
+func (s *session) expire() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.server != nil && s.client != nil {
+		return
+	}
+	if debug {
+		log.Println("Session", s, "expired")
+	}
+	s.expired = true
+	s.closeConns()
+	s.forget()
+}
+
+// close closes a session that will not be used.
+func (s *session) close() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.expired = true
+	s.closeConns()
+	s.forget()
+}
+
+func (s *session) closeConns() {
+	if s.server != nil {
+		s.server.Close()
+	}
+	if s.client != nil {
+		s.client.Close()
+	}
+}
+
+func (s *session) forget() {
+	sessionsMut.Lock()
+	delete(sessions, string(s.serverKey))
+	delete(sessions, string(s.clientKey))
+	sessionsMut.Unlock()
+}
+
+// proxy passes the traffic between the two sides until either goes away,
+// each limited to the rate allowed for the device sending it.
+func (s *session) proxy() {
+	if debug {
+		log.Println("Session", s, "between", s.serverID, "and", s.clientID, "started")
+	}
+
+	serverBucket := acquireLimiter(s.serverID)
+	defer releaseLimiter(s.serverID)
+	clientBucket := acquireLimiter(s.clientID)
+	defer releaseLimiter(s.clientID)
+
+	done := make(chan int64, 2)
+	go func() {
+		done <- relay(s.client, s.server, serverBucket)
+	}()
+	go func() {
+		done <- relay(s.server, s.client, clientBucket)
+	}()
+
+	n := <-done
+	s.mut.Lock()
+	s.closeConns()
+	s.mut.Unlock()
+	n += <-done
+
+	if debug {
+		log.Println("Session", s, "ended after relaying", n, "bytes")
+	}
+}
+
+// relay copies from src to dst until either fails or src is idle for too
+// long, and returns the number of bytes copied.
+func relay(dst, src net.Conn, bucket *ratelimit.Bucket) int64 {
+	buf := make([]byte, 32<<10)
+	var total int64
+	for {
+		src.SetReadDeadline(time.Now().Add(networkTimeout))
+		n, err := src.Read(buf)
+		if n > 0 {
+			if bucket != nil {
+				bucket.Wait(int64(n))
+			}
+			dst.SetWriteDeadline(time.Now().Add(networkTimeout))
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return total
+			}
+			total += int64(n)
+		}
+		if err != nil {
+			return total
+		}
+	}
+}
+
+var (
+	limitersMut sync.Mutex
+	limiters    = make(map[syncthingprotocol.DeviceID]*limiter)
+)
+
+// A limiter is the rate limit for the traffic sent by one device, shared by
+// all of its sessions.
+type limiter struct {
+	bucket *ratelimit.Bucket
+	refs   int
+}
+
+func acquireLimiter(id syncthingprotocol.DeviceID) *ratelimit.Bucket {
+	if perDeviceKbps <= 0 {
+		return nil
+	}
+
+	limitersMut.Lock()
+	defer limitersMut.Unlock()
+	lim, ok := limiters[id]
+	if !ok {
+		rate := int64(perDeviceKbps) << 10
+		lim = &limiter{bucket: ratelimit.NewBucketWithRate(float64(rate), rate)}
+		limiters[id] = lim
+	}
+	lim.refs++
+	return lim.bucket
+}
+
+func releaseLimiter(id syncthingprotocol.DeviceID) {
+	limitersMut.Lock()
+	defer limitersMut.Unlock()
+	if lim, ok := limiters[id]; ok {
+		lim.refs--
+		if lim.refs <= 0 {
+			delete(limiters, id)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	syncthingprotocol "github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/relay/protocol"
+)
+
+func newTestCert(t *testing.T, dir, name string) tls.Certificate {
+	certFile, keyFile := filepath.Join(dir, name+"-cert.pem"), filepath.Join(dir, name+"-key.pem")
+	if err := newCertificate(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func startTestRelay(t *testing.T, cert tls.Certificate) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{protocol.ProtocolName},
+		ClientAuth:   tls.RequestClientCert,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleConnection(tls.Server(conn, tlsCfg))
+		}
+	}()
+	return listener
+}
+
+func dialTestRelay(t *testing.T, addr net.Addr, cert tls.Certificate, message interface{}) (*tls.Conn, interface{}) {
+	conn, err := tls.Dial("tcp", addr.String(), &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		NextProtos:         []string{protocol.ProtocolName},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := protocol.WriteMessage(conn, message); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := protocol.ReadMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reply
+}
+
+func TestRelaySession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "strelaysrv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokens = map[string]bool{"secret": true}
+	defer func() { tokens = nil }()
+
+	listener := startTestRelay(t, newTestCert(t, dir, "relay"))
+	defer listener.Close()
+	addr := listener.Addr()
+
+	serverCert := newTestCert(t, dir, "server")
+	clientCert := newTestCert(t, dir, "client")
+	serverID := syncthingprotocol.NewDeviceID(serverCert.Certificate[0])
+
+	_, reply := dialTestRelay(t, addr, serverCert, protocol.JoinRelayRequest{Token: "wrong"})
+	if reply != protocol.ResponseForbidden {
+		t.Fatalf("Unexpected reply %v to invalid token", reply)
+	}
+
+	serverConn, reply := dialTestRelay(t, addr, serverCert, protocol.JoinRelayRequest{Token: "secret"})
+	if reply != protocol.ResponseSuccess {
+		t.Fatalf("Unexpected reply %v to join", reply)
+	}
+	clientConn, reply := dialTestRelay(t, addr, clientCert, protocol.JoinRelayRequest{Token: "secret"})
+	if reply != protocol.ResponseSuccess {
+		t.Fatalf("Unexpected reply %v to join", reply)
+	}
+
+	if err := protocol.WriteMessage(clientConn, protocol.ConnectRequest{ID: serverID[:]}); err != nil {
+		t.Fatal(err)
+	}
+	message, err := protocol.ReadMessage(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientInv, ok := message.(protocol.SessionInvitation)
+	if !ok || clientInv.ServerSocket {
+		t.Fatalf("Unexpected reply %#v to connect request", message)
+	}
+	message, err = protocol.ReadMessage(serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverInv, ok := message.(protocol.SessionInvitation)
+	if !ok || !serverInv.ServerSocket {
+		t.Fatalf("Unexpected invitation %#v", message)
+	}
+
+	// Joining with the other device's key is refused.
+	_, reply = dialTestRelay(t, addr, serverCert, protocol.JoinSessionRequest{Key: clientInv.Key})
+	if reply != protocol.ResponseForbidden {
+		t.Fatalf("Unexpected reply %v to joining with the wrong key", reply)
+	}
+
+	serverSes, reply := dialTestRelay(t, addr, serverCert, protocol.JoinSessionRequest{Key: serverInv.Key})
+	if reply != protocol.ResponseSuccess {
+		t.Fatalf("Unexpected reply %v to joining session", reply)
+	}
+	clientSes, reply := dialTestRelay(t, addr, clientCert, protocol.JoinSessionRequest{Key: clientInv.Key})
+	if reply != protocol.ResponseSuccess {
+		t.Fatalf("Unexpected reply %v to joining session", reply)
+	}
+
+	if _, err := clientSes.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(serverSes, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("Relayed %q, not %q", buf, "hello")
+	}
+
+	serverSes.Close()
+	if _, err := io.ReadFull(clientSes, buf); err == nil {
+		t.Error("Session still open after the other side went away")
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+const maxAuditLogSize = 10 << 20
+
+// An auditEntry records an administrative action performed via the REST
+// interface.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`            // remote IP address
+	Actor   string    `json:"actor"`             // "apikey", "user <name>", "session <id>"
+	Action  string    `json:"action"`            // the REST path or "config"
+	Details string    `json:"details,omitempty"` // query parameters or config changes
+	Status  int       `json:"status,omitempty"`  // HTTP status code
+}
+
+var auditMut sync.Mutex
+
+// These POST requests do not change any state worth auditing, or are
+// audited in more detail by the handler itself.
+var unauditedPaths = map[string]bool{
+	"/rest/ping":        true,
+	"/rest/error":       true,
+	"/rest/error/clear": true,
+	"/rest/config":      true,
+}
+
+func auditLogPath() string {
+	return filepath.Join(dataDir, "audit.log")
+}
+
+// auditMiddleware records all requests passing through it in the audit log.
+func auditMiddleware(apiKey string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unauditedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &statusResponseWriter{ResponseWriter: w, status: 200}
+		next.ServeHTTP(sw, r)
+
+		audit(r, apiKey, r.URL.Path, r.URL.RawQuery, sw.status)
+	})
+}
+
+// audit writes an entry to the audit log.
+func audit(r *http.Request, apiKey, action, details string, status int) {
+	e := auditEntry{
+		Time:    time.Now(),
+		Source:  remoteIP(r),
+		Actor:   auditActor(r, apiKey),
+		Action:  action,
+		Details: details,
+		Status:  status,
+	}
+
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	auditMut.Lock()
+	defer auditMut.Unlock()
+
+	path := auditLogPath()
+	if info, err := os.Stat(path); err == nil && info.Size() > maxAuditLogSize {
+		os.Rename(path, path+".old")
+	}
+
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		l.Warnln("Audit log:", err)
+		return
+	}
+	fd.Write(append(bs, '\n'))
+	fd.Close()
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// auditActor identifies who made the request without recording any secrets.
+func auditActor(r *http.Request, apiKey string) string {
+	if config.SecretMatches(apiKey, r.Header.Get("X-API-Key")) {
+		return "apikey"
+	}
+
+	if hdr := r.Header.Get("Authorization"); strings.HasPrefix(hdr, "Basic ") {
+		bs, err := base64.StdEncoding.DecodeString(hdr[6:])
+		if err == nil {
+			return "user " + strings.SplitN(string(bs), ":", 2)[0]
+		}
+	}
+
+	if cookie, err := r.Cookie("sessionid"); err == nil {
+		hash := sha256.Sum256([]byte(cookie.Value))
+		return fmt.Sprintf("session %x", hash[:4])
+	}
+
+	return "anonymous"
+}
+
+// configChanges describes the differences between two configurations that
+// are interesting from an audit perspective.
+func configChanges(from, to config.Configuration, requiresRestart bool) string {
+	var changes []string
+
+	fromDevices := make(map[string]bool)
+	for _, dev := range from.Devices {
+		fromDevices[dev.DeviceID.String()] = true
+	}
+	toDevices := make(map[string]bool)
+	for _, dev := range to.Devices {
+		id := dev.DeviceID.String()
+		toDevices[id] = true
+		if !fromDevices[id] {
+			changes = append(changes, "added device "+id)
+		}
+	}
+	for _, dev := range from.Devices {
+		if id := dev.DeviceID.String(); !toDevices[id] {
+			changes = append(changes, "removed device "+id)
+		}
+	}
+
+	fromFolders := make(map[string]bool)
+	for _, folder := range from.Folders {
+		fromFolders[folder.ID] = true
+	}
+	toFolders := make(map[string]bool)
+	for _, folder := range to.Folders {
+		toFolders[folder.ID] = true
+		if !fromFolders[folder.ID] {
+			changes = append(changes, "added folder "+folder.ID)
+		}
+	}
+	for _, folder := range from.Folders {
+		if !toFolders[folder.ID] {
+			changes = append(changes, "removed folder "+folder.ID)
+		}
+	}
+
+	if from.GUI.Password != to.GUI.Password {
+		changes = append(changes, "changed GUI password")
+	}
+	if from.GUI.APIKey != to.GUI.APIKey {
+		changes = append(changes, "changed API key")
+	}
+	if requiresRestart {
+		changes = append(changes, "requires restart")
+	}
+
+	return strings.Join(changes, ", ")
+}
+
+// readAuditLog returns the audit log entries newer than since, at most limit
+// of the most recent ones if limit is positive.
+func readAuditLog(since time.Time, limit int) ([]auditEntry, error) {
+	auditMut.Lock()
+	defer auditMut.Unlock()
+
+	fd, err := os.Open(auditLogPath())
+	if os.IsNotExist(err) {
+		return []auditEntry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	entries := []auditEntry{}
+	sc := bufio.NewScanner(fd)
+	for sc.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Time.After(since) {
+			entries = append(entries, e)
+		}
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, sc.Err()
+}
+
+func restGetAudit(w http.ResponseWriter, r *http.Request) {
+	since, err := logSince(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	entries, err := readAuditLog(since, limit)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// A statusResponseWriter remembers the status code written.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
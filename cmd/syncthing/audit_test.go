@@ -0,0 +1,124 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing-audit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { dataDir = d }(dataDir)
+	dataDir = dir
+
+	if entries, err := readAuditLog(time.Time{}, 0); err != nil || len(entries) != 0 {
+		t.Fatalf("empty audit log: %v, %v", entries, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/ping", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/rest/scan", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/rest/reset", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failed", 500)
+	})
+	handler := auditMiddleware("apiSecret", mux)
+
+	post := func(path string, set func(r *http.Request)) {
+		r, _ := http.NewRequest("POST", path, nil)
+		r.RemoteAddr = "192.0.2.1:12345"
+		set(r)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	post("/rest/ping", func(r *http.Request) {})
+	post("/rest/scan?folder=default", func(r *http.Request) {
+		r.Header.Set("X-API-Key", "apiSecret")
+	})
+	// Keep the entries apart from the boundary on systems with a coarse
+	// clock.
+	time.Sleep(20 * time.Millisecond)
+	before := time.Now()
+	time.Sleep(20 * time.Millisecond)
+	post("/rest/reset", func(r *http.Request) {
+		r.SetBasicAuth("alice", "passwordSecret")
+	})
+	post("/rest/scan", func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: "sessionid", Value: "sessionSecret"})
+	})
+
+	entries, err := readAuditLog(time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []auditEntry{
+		{Source: "192.0.2.1", Actor: "apikey", Action: "/rest/scan", Details: "folder=default", Status: 200},
+		{Source: "192.0.2.1", Actor: "user alice", Action: "/rest/reset", Status: 500},
+		{Source: "192.0.2.1", Action: "/rest/scan", Status: 200},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("got %d entries, expected %d: %+v", len(entries), len(expected), entries)
+	}
+	for i, e := range entries {
+		x := expected[i]
+		if e.Source != x.Source || e.Action != x.Action || e.Details != x.Details || e.Status != x.Status {
+			t.Errorf("%d: unexpected entry %+v", i, e)
+		}
+		if x.Actor != "" && e.Actor != x.Actor {
+			t.Errorf("%d: actor %q, expected %q", i, e.Actor, x.Actor)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("%d: entry without time", i)
+		}
+	}
+	if !strings.HasPrefix(entries[2].Actor, "session ") {
+		t.Errorf("session actor %q", entries[2].Actor)
+	}
+
+	bs, err := ioutil.ReadFile(auditLogPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"apiSecret", "passwordSecret", "sessionSecret"} {
+		if strings.Contains(string(bs), secret) {
+			t.Errorf("audit log contains %s", secret)
+		}
+	}
+
+	if entries, _ := readAuditLog(before, 0); len(entries) != 2 || entries[0].Action != "/rest/reset" {
+		t.Errorf("entries since %v: %+v", before, entries)
+	}
+	if entries, _ := readAuditLog(time.Time{}, 1); len(entries) != 1 || entries[0].Status != 200 || entries[0].Action != "/rest/scan" || entries[0].Details != "" {
+		t.Errorf("last entry: %+v", entries)
+	}
+
+	r, _ := http.NewRequest("GET", "/rest/system/audit?limit=2&since="+before.Add(-time.Second).Format(time.RFC3339Nano), nil)
+	w := httptest.NewRecorder()
+	restGetAudit(w, r)
+	var served []auditEntry
+	if err := json.NewDecoder(w.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 2 || served[0].Action != "/rest/reset" || served[1].Action != "/rest/scan" {
+		t.Errorf("served entries: %+v", served)
+	}
+
+	r, _ = http.NewRequest("GET", "/rest/system/audit?since=yesterday", nil)
+	w = httptest.NewRecorder()
+	restGetAudit(w, r)
+	if w.Code != 400 {
+		t.Errorf("bad since parameter: status %d", w.Code)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/osutil"
+)
+
+// checkConfig upgrades the configuration in the config directory to the
+// current version without starting up, keeping the old one next to it.
+func checkConfig() error {
+	path := filepath.Join(confDir, "config.xml")
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	out, from, err := config.Migrate(in)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if from == config.CurrentVersion {
+		l.Infof("%s is at the current version %d", path, from)
+		return nil
+	}
+
+	// Rewriting a signed configuration would invalidate the signature,
+	// which only syncthing itself can renew.
+	if _, err := os.Stat(path + ".sig"); err == nil {
+		return errors.New("the configuration is signed; start syncthing to upgrade it")
+	}
+
+	backup := fmt.Sprintf("%s.v%d", path, from)
+	if err := ioutil.WriteFile(backup, in, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", out, 0600); err != nil {
+		return err
+	}
+	if err := osutil.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	l.Okf("Upgraded %s from version %d to %d; the old one is kept as %s", path, from, config.CurrentVersion, backup)
+	return nil
+}
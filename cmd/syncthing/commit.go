@@ -0,0 +1,87 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/metrics"
+)
+
+// guiCommitter applies changes to the GUI configuration. The GUI is set up
+// with its address, certificate and credentials when it starts, so changing
+// those requires a restart; the password policy applies to the next
+// password set.
+type guiCommitter struct{}
+
+func (guiCommitter) VerifyConfiguration(from, to config.Configuration) error {
+	if to.GUI.Enabled && to.GUI.Address != from.GUI.Address {
+		if _, err := net.ResolveTCPAddr("tcp", to.GUI.Address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (guiCommitter) CommitConfiguration(from, to config.Configuration) bool {
+	fromGUI, toGUI := from.GUI, to.GUI
+	fromGUI.PasswordCost, toGUI.PasswordCost = 0, 0
+	fromGUI.PasswordMinLength, toGUI.PasswordMinLength = 0, 0
+
+	return fromGUI == toGUI &&
+		from.Options.GUITLSMinVersion == to.Options.GUITLSMinVersion &&
+		equalStrings(from.Options.GUITLSCipherSuites, to.Options.GUITLSCipherSuites)
+}
+
+func (guiCommitter) String() string {
+	return "GUI"
+}
+
+// optionsCommitter applies changes to the options handled by syncthing
+// itself rather than the model or the connections. The log target, device
+// profile and automatic upgrades are set up at startup.
+type optionsCommitter struct{}
+
+func (optionsCommitter) VerifyConfiguration(from, to config.Configuration) error {
+	return nil
+}
+
+func (optionsCommitter) CommitConfiguration(from, to config.Configuration) bool {
+	fromOpts, toOpts := from.Options, to.Options
+
+	metrics.SetSlowThreshold("scan", time.Duration(toOpts.SlowScanS)*time.Second)
+	metrics.SetSlowThreshold("pull", time.Duration(toOpts.SlowPullS)*time.Second)
+	metrics.SetSlowThreshold("index", time.Duration(toOpts.SlowIndexS)*time.Second)
+
+	if toOpts.UploadCrashReports && (!fromOpts.UploadCrashReports || toOpts.CrashReportURL != fromOpts.CrashReportURL) && toOpts.URAccepted >= usageReportVersion {
+		go uploadCrashReports(toOpts.CrashReportURL)
+	}
+
+	return fromOpts.LogTarget == toOpts.LogTarget &&
+		fromOpts.LogSyslogAddress == toOpts.LogSyslogAddress &&
+		fromOpts.DeviceProfile == toOpts.DeviceProfile &&
+		fromOpts.AutoUpgradeIntervalH == toOpts.AutoUpgradeIntervalH &&
+		fromOpts.UpgradeWindowStart == toOpts.UpgradeWindowStart &&
+		fromOpts.UpgradeWindowEnd == toOpts.UpgradeWindowEnd &&
+		fromOpts.UpgradeWindowDays == toOpts.UpgradeWindowDays
+}
+
+func (optionsCommitter) String() string {
+	return "options"
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// A crashReport is written to the config directory by the monitor process
+// when the syncthing process panics.
+type crashReport struct {
+	Time        time.Time `json:"time"`
+	Version     string    `json:"version"`
+	LongVersion string    `json:"longVersion"`
+	Platform    string    `json:"platform"`
+	Panic       string    `json:"panic"` // the panic message
+	Stack       string    `json:"stack"` // the full panic output, with goroutine stacks
+	LogTail     []string  `json:"logTail"`
+}
+
+// writeCrashReport saves a report for the panic output, together with the
+// last lines logged before it.
+func writeCrashReport(t time.Time, panicOutput string, logTail []string) {
+	msg := panicOutput
+	if idx := strings.IndexByte(msg, '\n'); idx >= 0 {
+		msg = msg[:idx]
+	}
+
+	tail := make([]string, len(logTail))
+	for i, line := range logTail {
+		tail[i] = strings.TrimRight(line, "\n")
+	}
+
+	r := crashReport{
+		Time:        t,
+		Version:     Version,
+		LongVersion: LongVersion,
+		Platform:    runtime.GOOS + "-" + runtime.GOARCH,
+		Panic:       msg,
+		Stack:       panicOutput,
+		LogTail:     tail,
+	}
+
+	bs, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		l.Warnln("Crash report:", err)
+		return
+	}
+
+	name := filepath.Join(dataDir, t.Format("crash-20060102-150405.json"))
+	if err := ioutil.WriteFile(name, bs, 0600); err != nil {
+		l.Warnln("Crash report:", err)
+		return
+	}
+	l.Infof("Crash report written to %q", name)
+}
+
+// uploadCrashReports sends crash reports that have not yet been sent to the
+// URL, if it is set. Sent reports are renamed with a ".sent" suffix.
+func uploadCrashReports(url string) {
+	if url == "" {
+		return
+	}
+
+	names, err := filepath.Glob(filepath.Join(dataDir, "crash-*.json"))
+	if err != nil {
+		return
+	}
+
+	for _, name := range names {
+		bs, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+
+		resp, err := http.Post(url, "application/json", bytes.NewReader(bs))
+		if err != nil {
+			l.Infoln("Crash report upload:", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode > 299 {
+			l.Infoln("Crash report upload:", fmt.Errorf("HTTP error: %s", resp.Status))
+			return
+		}
+
+		if debugNet() {
+			l.Debugln("uploaded crash report", name)
+		}
+		os.Rename(name, name+".sent")
+	}
+}
@@ -4,11 +4,8 @@
 
 package main
 
-import (
-	"os"
-	"strings"
-)
-
-var (
-	debugNet = strings.Contains(os.Getenv("STTRACE"), "net") || os.Getenv("STTRACE") == "all"
-)
+// The "net" debug facility itself is registered by lib/syncthing, which
+// handles the connections.
+func debugNet() bool {
+	return l.ShouldDebug("net")
+}
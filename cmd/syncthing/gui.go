@@ -7,11 +7,13 @@ package main
 import (
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -20,14 +22,17 @@ import (
 	"sync"
 	"time"
 
-	"code.google.com/p/go.crypto/bcrypt"
+	"github.com/syncthing/syncthing/internal/auth"
 	"github.com/syncthing/syncthing/internal/auto"
 	"github.com/syncthing/syncthing/internal/config"
 	"github.com/syncthing/syncthing/internal/events"
 	"github.com/syncthing/syncthing/internal/logger"
+	"github.com/syncthing/syncthing/internal/metrics"
 	"github.com/syncthing/syncthing/internal/model"
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/internal/osutil"
 	"github.com/syncthing/syncthing/internal/upgrade"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/syncthing"
 	"github.com/vitrun/qart/qr"
 )
 
@@ -37,40 +42,53 @@ type guiError struct {
 }
 
 var (
-	configInSync = true
 	guiErrors    = []guiError{}
 	guiErrorsMut sync.Mutex
 	modt         = time.Now().UTC().Format(http.TimeFormat)
 	eventSub     *events.BufferedSubscription
+	systemLog    *logger.Recorder
 )
 
 func init() {
 	l.AddHandler(logger.LevelWarn, showGuiError)
+	systemLog = logger.NewRecorder(l, logger.LevelDebug, 250)
 	sub := events.Default.Subscribe(events.AllEvents)
 	eventSub = events.NewBufferedSubscription(sub, 1000)
 }
 
-func startGUI(cfg config.GUIConfiguration, assetDir string, m *model.Model) error {
+// startGUI starts serving the GUI and REST API, and returns the address it
+// is listening on.
+func startGUI(cfg config.GUIConfiguration, opts config.OptionsConfiguration, assetDir string, m *model.Model) (net.Addr, error) {
 	var err error
 
 	cert, err := loadCert(confDir, "https-")
 	if err != nil {
 		l.Infoln("Loading HTTPS certificate:", err)
 		l.Infoln("Creating new HTTPS certificate")
-		newCertificate(confDir, "https-")
+		newCertificate(confDir, "https-", KeyTypeRSA)
 		cert, err = loadCert(confDir, "https-")
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 	tlsCfg := &tls.Config{
 		Certificates: []tls.Certificate{cert},
 		ServerName:   "syncthing",
 	}
+	if err := syncthing.ApplyTLSOptions(tlsCfg, opts.GUITLSMinVersion, opts.GUITLSCipherSuites); err != nil {
+		return nil, err
+	}
+
+	if cfg.UseTLS && cfg.ACMEDomain != "" {
+		mgr := newACMEManager(cfg, cert)
+		tlsCfg.Certificates = nil
+		tlsCfg.GetCertificate = mgr.GetCertificate
+		go mgr.Serve()
+	}
 
 	rawListener, err := net.Listen("tcp", cfg.Address)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	listener := &DowngradingListener{rawListener, tlsCfg}
 
@@ -81,6 +99,7 @@ func startGUI(cfg config.GUIConfiguration, assetDir string, m *model.Model) erro
 	getRestMux.HandleFunc("/rest/config", restGetConfig)
 	getRestMux.HandleFunc("/rest/config/sync", restGetConfigInSync)
 	getRestMux.HandleFunc("/rest/connections", withModel(m, restGetConnections))
+	getRestMux.HandleFunc("/rest/connections/paused", withModel(m, restGetRemotePaused))
 	getRestMux.HandleFunc("/rest/discovery", restGetDiscovery)
 	getRestMux.HandleFunc("/rest/errors", restGetErrors)
 	getRestMux.HandleFunc("/rest/events", restGetEvents)
@@ -88,12 +107,32 @@ func startGUI(cfg config.GUIConfiguration, assetDir string, m *model.Model) erro
 	getRestMux.HandleFunc("/rest/lang", restGetLang)
 	getRestMux.HandleFunc("/rest/model", withModel(m, restGetModel))
 	getRestMux.HandleFunc("/rest/need", withModel(m, restGetNeed))
+	getRestMux.HandleFunc("/rest/db/browse", withModel(m, restGetDBBrowse))
+	getRestMux.HandleFunc("/rest/db/need", withModel(m, restGetDBNeed))
+	getRestMux.HandleFunc("/rest/db/completion", withModel(m, restGetDBCompletion))
+	getRestMux.HandleFunc("/rest/db/localchanged", withModel(m, restGetDBLocalChanged))
+	getRestMux.HandleFunc("/rest/db/unsafe", withModel(m, restGetDBUnsafe))
+	getRestMux.HandleFunc("/rest/unsyncable", withModel(m, restGetUnsyncable))
+	getRestMux.HandleFunc("/rest/pending/devices", withModel(m, restGetPendingDevices))
+	getRestMux.HandleFunc("/rest/pending/unshares", withModel(m, restGetPendingUnshares))
+	getRestMux.HandleFunc("/rest/blocked/devices", restGetBlockedDevices)
 	getRestMux.HandleFunc("/rest/deviceid", restGetDeviceID)
 	getRestMux.HandleFunc("/rest/report", withModel(m, restGetReport))
+	getRestMux.HandleFunc("/rest/report/preview", withModel(m, restGetReport))
 	getRestMux.HandleFunc("/rest/system", restGetSystem)
+	getRestMux.HandleFunc("/rest/system/audit", restGetAudit)
+	getRestMux.HandleFunc("/rest/system/debug", restGetDebug)
+	getRestMux.HandleFunc("/rest/system/health", withModel(m, restGetHealth))
+	getRestMux.HandleFunc("/rest/system/log", restGetLog)
+	getRestMux.HandleFunc("/rest/system/log.txt", restGetLogTxt)
 	getRestMux.HandleFunc("/rest/upgrade", restGetUpgrade)
 	getRestMux.HandleFunc("/rest/version", restGetVersion)
 	getRestMux.HandleFunc("/rest/stats/device", withModel(m, restGetDeviceStats))
+	getRestMux.HandleFunc("/rest/stats/folder", withModel(m, restGetFolderStats))
+	getRestMux.HandleFunc("/rest/stats/transfers", withModel(m, restGetTransferHistory))
+	getRestMux.HandleFunc("/rest/stats/talkers", withModel(m, restGetTopTalkers))
+	getRestMux.HandleFunc("/rest/stats/perf", restGetPerfStats)
+	getRestMux.HandleFunc("/rest/stats/slow", restGetSlowOps)
 
 	// Debug endpoints, not for general use
 	getRestMux.HandleFunc("/rest/debug/peerCompletion", withModel(m, restGetPeerCompletion))
@@ -107,23 +146,50 @@ func startGUI(cfg config.GUIConfiguration, assetDir string, m *model.Model) erro
 	postRestMux.HandleFunc("/rest/error/clear", restClearErrors)
 	postRestMux.HandleFunc("/rest/ignores", withModel(m, restPostIgnores))
 	postRestMux.HandleFunc("/rest/model/override", withModel(m, restPostOverride))
+	postRestMux.HandleFunc("/rest/db/revert", withModel(m, restPostDBRevert))
+	postRestMux.HandleFunc("/rest/pending/devices/accept", withModel(m, restPostAcceptDevice))
+	postRestMux.HandleFunc("/rest/blocked/devices/add", withModel(m, restPostBlockDevice))
+	postRestMux.HandleFunc("/rest/blocked/devices/remove", restPostUnblockDevice)
+	postRestMux.HandleFunc("/rest/pending/devices/decline", withModel(m, restPostDeclineDevice))
+	postRestMux.HandleFunc("/rest/pending/unshares/accept", withModel(m, restPostAcceptUnshare))
+	postRestMux.HandleFunc("/rest/pending/unshares/decline", withModel(m, restPostDeclineUnshare))
+	postRestMux.HandleFunc("/rest/unshare", withModel(m, restPostUnshare))
 	postRestMux.HandleFunc("/rest/reset", restPostReset)
 	postRestMux.HandleFunc("/rest/restart", restPostRestart)
+	postRestMux.Handle("/rest/identity/regenerate", regenerateIdentityHandler(cfg.APIKey))
 	postRestMux.HandleFunc("/rest/shutdown", restPostShutdown)
 	postRestMux.HandleFunc("/rest/upgrade", restPostUpgrade)
 	postRestMux.HandleFunc("/rest/scan", withModel(m, restPostScan))
+	postRestMux.HandleFunc("/rest/fetch", withModel(m, restPostFetch))
+	postRestMux.HandleFunc("/rest/folder/pause", withModel(m, restPostFolderPause))
+	postRestMux.HandleFunc("/rest/folder/resume", withModel(m, restPostFolderResume))
+	postRestMux.HandleFunc("/rest/system/apikey", restPostAPIKey)
+	postRestMux.HandleFunc("/rest/system/debug", restPostDebug)
+	postRestMux.HandleFunc("/rest/system/pause", withModel(m, restPostPause))
+	postRestMux.HandleFunc("/rest/system/resume", restPostResume)
+	postRestMux.HandleFunc("/rest/stats/perf/reset", restPostPerfStatsReset)
+	postRestMux.HandleFunc("/rest/stats/slow/reset", restPostSlowOpsReset)
 
 	// A handler that splits requests between the two above and disables
 	// caching
-	restMux := noCacheMiddleware(getPostHandler(getRestMux, postRestMux))
+	restMux := noCacheMiddleware(getPostHandler(getRestMux, auditMiddleware(cfg.APIKey, postRestMux)))
 
 	// The main routing handler
 	mux := http.NewServeMux()
 	mux.Handle("/rest/", restMux)
-	mux.HandleFunc("/qr/", getQR)
 
-	// Serve compiled in assets unless an asset directory was set (for development)
-	mux.Handle("/", embeddedStatic(assetDir))
+	// Profiling and support data, requiring the API key
+	debugMux := debugHandler(cfg.APIKey)
+	mux.Handle("/rest/debug/pprof/", debugMux)
+	mux.Handle("/rest/debug/memstats", debugMux)
+	mux.Handle("/rest/debug/support", debugMux)
+
+	// Serve compiled in assets unless an asset directory was set (for
+	// development). Headless instances serve only the REST API.
+	if !headless {
+		mux.HandleFunc("/qr/", getQR)
+		mux.Handle("/", embeddedStatic(assetDir))
+	}
 
 	// Wrap everything in CSRF protection. The /rest prefix should be
 	// protected, other requests will grant cookies.
@@ -132,8 +198,9 @@ func startGUI(cfg config.GUIConfiguration, assetDir string, m *model.Model) erro
 	// Add our version as a header to responses
 	handler = withVersionMiddleware(handler)
 
-	// Wrap everything in basic auth, if user/password is set.
-	if len(cfg.User) > 0 && len(cfg.Password) > 0 {
+	// Wrap everything in basic auth, if user/password or an external
+	// authenticator is set.
+	if len(cfg.User) > 0 && len(cfg.Password) > 0 || cfg.AuthCommand != "" || cfg.AuthURL != "" {
 		handler = basicAuthAndSessionMiddleware(cfg, handler)
 	}
 
@@ -148,7 +215,7 @@ func startGUI(cfg config.GUIConfiguration, assetDir string, m *model.Model) erro
 			panic(err)
 		}
 	}()
-	return nil
+	return listener.Addr(), nil
 }
 
 func getPostHandler(get, post http.Handler) http.Handler {
@@ -237,6 +304,28 @@ func restGetCompletion(m *model.Model, w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+// restGetDBCompletion returns how much of the local data in the folder the
+// device has, from the index we hold from it.
+func restGetDBCompletion(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	var qs = r.URL.Query()
+	var folder = qs.Get("folder")
+
+	device, err := protocol.DeviceIDFromString(qs.Get("device"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	res, err := m.RemoteCompletion(device, folder)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(res)
+}
+
 func restGetModel(m *model.Model, w http.ResponseWriter, r *http.Request) {
 	var qs = r.URL.Query()
 	var folder = qs.Get("folder")
@@ -260,6 +349,18 @@ func restGetModel(m *model.Model, w http.ResponseWriter, r *http.Request) {
 
 	res["inSyncFiles"], res["inSyncBytes"] = globalFiles-needFiles, globalBytes-needBytes
 
+	needSpace := m.NeedSpace(folder)
+	res["needSpace"] = needSpace
+	if fcfg, ok := cfg.FolderMap()[folder]; ok {
+		if free, _, err := osutil.DiskUsage(fcfg.Path); err == nil {
+			res["freeSpace"] = free
+			res["insufficientSpace"] = needSpace > free
+		}
+	}
+	res["unsyncableFiles"] = len(m.Unsyncable(folder))
+	res["modeMismatches"] = m.ModeMismatches(folder)
+	res["unsafeFiles"] = len(m.UnsafeFiles(folder))
+
 	res["state"], res["stateChanged"] = m.State(folder)
 	res["version"] = m.CurrentLocalVersion(folder) + m.RemoteLocalVersion(folder)
 
@@ -273,6 +374,17 @@ func restPostOverride(m *model.Model, w http.ResponseWriter, r *http.Request) {
 	go m.Override(folder)
 }
 
+// restPostDBRevert makes the cluster take the local versions of the files
+// that differ in the send only folder.
+func restPostDBRevert(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	var qs = r.URL.Query()
+	var folder = qs.Get("folder")
+
+	if err := m.Revert(folder); err != nil {
+		http.Error(w, err.Error(), 400)
+	}
+}
+
 func restGetNeed(m *model.Model, w http.ResponseWriter, r *http.Request) {
 	var qs = r.URL.Query()
 	var folder = qs.Get("folder")
@@ -283,20 +395,372 @@ func restGetNeed(m *model.Model, w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(files)
 }
 
+// pageParams returns the "page" (from one) and "perpage" (100 by default)
+// query parameters.
+func pageParams(qs url.Values) (page, perpage int, err error) {
+	page, perpage = 1, 100
+	if s := qs.Get("page"); s != "" {
+		if page, err = strconv.Atoi(s); err != nil || page < 1 {
+			return 0, 0, errors.New("invalid page")
+		}
+	}
+	if s := qs.Get("perpage"); s != "" {
+		if perpage, err = strconv.Atoi(s); err != nil || perpage < 1 {
+			return 0, 0, errors.New("invalid perpage")
+		}
+	}
+	return page, perpage, nil
+}
+
+// restGetDBBrowse lists the directory at "prefix" in the cluster's version
+// of the folder, including files that aren't present locally.
+func restGetDBBrowse(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	var qs = r.URL.Query()
+	var folder = qs.Get("folder")
+	var prefix = qs.Get("prefix")
+
+	entries, err := m.Browse(folder, prefix)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// restGetDBNeed returns the files needed by the folder, with the queued ones
+// paged as by pageParams.
+func restGetDBNeed(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	var qs = r.URL.Query()
+	var folder = qs.Get("folder")
+
+	page, perpage, err := pageParams(qs)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	progress, queued, failed, more := m.NeedFolderFiles(folder, page, perpage)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"progress": progress,
+		"queued":   queued,
+		"failed":   failed,
+		"page":     page,
+		"perpage":  perpage,
+		"more":     more,
+	})
+}
+
+// restGetDBLocalChanged returns the files in the read only folder that
+// differ from the cluster, paged as by pageParams.
+func restGetDBLocalChanged(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	var qs = r.URL.Query()
+	var folder = qs.Get("folder")
+
+	page, perpage, err := pageParams(qs)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	files, more, err := m.LocallyChanged(folder, page, perpage)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files":   files,
+		"page":    page,
+		"perpage": perpage,
+		"more":    more,
+	})
+}
+
+// restGetDBUnsafe returns the files in the folder held by fewer devices than
+// the folder requires, with the number of devices holding each.
+func restGetDBUnsafe(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	var qs = r.URL.Query()
+	var folder = qs.Get("folder")
+
+	files := m.UnsafeFiles(folder)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(files)
+}
+
+func restGetUnsyncable(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	var qs = r.URL.Query()
+	var folder = qs.Get("folder")
+
+	files := m.Unsyncable(folder)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(files)
+}
+
 func restGetConnections(m *model.Model, w http.ResponseWriter, r *http.Request) {
 	var res = m.ConnectionStats()
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(w).Encode(res)
 }
 
+func restGetRemotePaused(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	var res = m.RemotePaused()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(res)
+}
+
 func restGetDeviceStats(m *model.Model, w http.ResponseWriter, r *http.Request) {
 	var res = m.DeviceStatistics()
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(w).Encode(res)
 }
 
+func restGetFolderStats(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	var res = m.FolderStatistics()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(res)
+}
+
+// restGetTransferHistory returns the transfer rates since the time given as
+// RFC 3339 in "since", the last 24 hours by default, averaged down to at most
+// "points" samples, 300 by default or all of them if zero.
+func restGetTransferHistory(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	since := time.Now().Add(-24 * time.Hour)
+	if s := qs.Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		since = t
+	}
+	points := 300
+	if s := qs.Get("points"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid points", 400)
+			return
+		}
+		points = n
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(m.TransferHistory(since, points))
+}
+
+// restGetTopTalkers returns the block data transferred per device and folder
+// during the window given as a duration in "window", an hour by default.
+func restGetTopTalkers(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	window := time.Hour
+	if s := r.URL.Query().Get("window"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid window", 400)
+			return
+		}
+		window = d
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(m.TopTalkers(window))
+}
+
+func restGetPendingDevices(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(m.PendingDevices())
+}
+
+func restPostAcceptDevice(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	id, err := protocol.DeviceIDFromString(r.URL.Query().Get("device"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := m.AcceptPendingDevice(id); err == model.ErrDeviceBlocked {
+		http.Error(w, err.Error(), 403)
+	} else if err != nil {
+		http.Error(w, err.Error(), 404)
+	}
+}
+
+func restPostDeclineDevice(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	id, err := protocol.DeviceIDFromString(r.URL.Query().Get("device"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := m.DeclinePendingDevice(id); err != nil {
+		http.Error(w, err.Error(), 404)
+	}
+}
+
+func restGetPendingUnshares(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(m.PendingUnshares())
+}
+
+func restPostAcceptUnshare(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	if err := m.AcceptUnshare(r.URL.Query().Get("folder")); err != nil {
+		http.Error(w, err.Error(), 404)
+	}
+}
+
+func restPostDeclineUnshare(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	if err := m.DeclineUnshare(r.URL.Query().Get("folder")); err != nil {
+		http.Error(w, err.Error(), 404)
+	}
+}
+
+// restPostUnshare asks the device to stop syncing the folder, and to delete
+// its copy of it if delete is "true".
+func restPostUnshare(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	var qs = r.URL.Query()
+	id, err := protocol.DeviceIDFromString(qs.Get("device"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := m.RequestUnshare(id, qs.Get("folder"), qs.Get("delete") == "true"); err != nil {
+		http.Error(w, err.Error(), 404)
+	}
+}
+
+func restGetBlockedDevices(w http.ResponseWriter, r *http.Request) {
+	blocked := cfg.BlockedDevices
+	if blocked == nil {
+		blocked = []protocol.DeviceID{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(blocked)
+}
+
+func restPostBlockDevice(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	id, err := protocol.DeviceIDFromString(r.URL.Query().Get("device"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if id == myID {
+		http.Error(w, "Cannot block ourselves", 400)
+		return
+	}
+
+	l.Infoln("Blocking device", id)
+	cfg.BlockDevice(id)
+	cfg.Save()
+	// Removing a device from the running model requires a restart
+	cfgWrapper.SetRequiresRestart()
+
+	if m.ConnectedTo(id) {
+		m.Close(id, errors.New("device blocked"))
+	}
+}
+
+func restPostUnblockDevice(w http.ResponseWriter, r *http.Request) {
+	id, err := protocol.DeviceIDFromString(r.URL.Query().Get("device"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	cfg.UnblockDevice(id)
+	cfg.Save()
+}
+
+// restPostPause pauses the device: the connection to it is closed, and it
+// is neither connected to nor accepted until it is resumed.
+func restPostPause(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	id, err := protocol.DeviceIDFromString(r.URL.Query().Get("device"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if id == myID {
+		http.Error(w, "Cannot pause ourselves", 400)
+		return
+	}
+	device := cfg.GetDeviceConfiguration(id)
+	if device == nil {
+		http.Error(w, "No such device", 404)
+		return
+	}
+
+	l.Infoln("Pausing device", id)
+	device.Paused = true
+	cfg.Save()
+
+	// It is told that it's paused when it next tries to connect
+	m.Disconnect(id)
+}
+
+// restPostResume resumes the paused device, which is connected to again at
+// the next reconnect interval, or when it connects to us.
+func restPostResume(w http.ResponseWriter, r *http.Request) {
+	id, err := protocol.DeviceIDFromString(r.URL.Query().Get("device"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	device := cfg.GetDeviceConfiguration(id)
+	if device == nil {
+		http.Error(w, "No such device", 404)
+		return
+	}
+
+	l.Infoln("Resuming device", id)
+	device.Paused = false
+	cfg.Save()
+}
+
+func restGetPerfStats(w http.ResponseWriter, r *http.Request) {
+	metrics, since := metrics.Default.Snapshot()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"since":   since,
+		"metrics": metrics,
+	})
+}
+
+func restPostPerfStatsReset(w http.ResponseWriter, r *http.Request) {
+	metrics.Default.Reset()
+}
+
+func restGetSlowOps(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(metrics.SlowOps())
+}
+
+func restPostSlowOpsReset(w http.ResponseWriter, r *http.Request) {
+	metrics.ClearSlowOps()
+}
+
+// restPostAPIKey generates a new API key. This is the only time the key is
+// shown; the config only contains its hash.
+func restPostAPIKey(w http.ResponseWriter, r *http.Request) {
+	key := config.NewSecret(32)
+	cfg.GUI.APIKey = config.HashSecret(key)
+	cfg.Save()
+	// The GUI checks the API key it was started with
+	cfgWrapper.SetRequiresRestart()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]string{
+		"apiKey": key,
+	})
+}
+
 func restGetConfig(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if requestRole(r) == auth.RoleReadOnly {
+		json.NewEncoder(w).Encode(sanitizedConfig(cfg))
+		return
+	}
 	json.NewEncoder(w).Encode(cfg)
 }
 
@@ -308,47 +772,78 @@ func restPostConfig(m *model.Model, w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 500)
 		return
 	} else {
+		// Commands to run are set in the config file only, lest access to
+		// the GUI be access to the shell
+		if changed := config.ChangedCommands(cfgWrapper.Raw(), newCfg); len(changed) > 0 {
+			http.Error(w, strings.Join(changed, ", ")+" may only be changed in the config file", 403)
+			return
+		}
+
 		if newCfg.GUI.Password != cfg.GUI.Password {
 			if newCfg.GUI.Password != "" {
-				hash, err := bcrypt.GenerateFromPassword([]byte(newCfg.GUI.Password), 0)
+				if err := config.CheckPasswordStrength(newCfg.GUI.Password, cfg.GUI.PasswordMinLength); err != nil {
+					http.Error(w, err.Error(), 400)
+					return
+				}
+				hash, err := config.HashPassword(newCfg.GUI.Password, newCfg.GUI.PasswordCost)
 				if err != nil {
 					l.Warnln("bcrypting password:", err)
 					http.Error(w, err.Error(), 500)
 					return
 				} else {
-					newCfg.GUI.Password = string(hash)
+					newCfg.GUI.Password = hash
 				}
 			}
 		}
 
-		// Start or stop usage reporting as appropriate
+		// The API key is stored hashed; a new one is in cleartext
+		newCfg.GUI.APIKey = config.HashSecret(newCfg.GUI.APIKey)
+
+		// Usage reporting is started or stopped as appropriate once the
+		// configuration has been accepted
 
-		if newCfg.Options.URAccepted > cfg.Options.URAccepted {
-			// UR was enabled
+		urEnabled := newCfg.Options.URAccepted > cfg.Options.URAccepted
+		urDisabled := newCfg.Options.URAccepted < cfg.Options.URAccepted
+		if urEnabled {
 			newCfg.Options.URAccepted = usageReportVersion
-			err := sendUsageReport(m)
+		} else if urDisabled {
+			newCfg.Options.URAccepted = -1
+		}
+
+		// Shares follow the device groups, as when loading the config
+		newCfg.ApplyDeviceGroups()
+
+		// Activate and save
+
+		from := cfgWrapper.Raw()
+		resp := cfgWrapper.Replace(newCfg)
+		if resp.ValidationError != nil {
+			http.Error(w, resp.ValidationError.Error(), 400)
+			return
+		}
+
+		audit(r, from.GUI.APIKey, "config", configChanges(from, newCfg, resp.RequiresRestart), 200)
+
+		// The user has seen and approved the config, so it can be signed
+		// even if it had been modified outside of syncthing.
+		config.SetSigningKey(configSigner)
+		cfgWrapper.Save()
+
+		if urEnabled {
+			err := sendUsageReport(m, newCfg.Options.URGranularity)
 			if err != nil {
 				l.Infoln("Usage report:", err)
 			}
 			go usageReportingLoop(m)
-		} else if newCfg.Options.URAccepted < cfg.Options.URAccepted {
-			// UR was disabled
-			newCfg.Options.URAccepted = -1
+		} else if urDisabled {
 			stopUsageReporting()
 		}
-
-		// Activate and save
-
-		configInSync = !config.ChangeRequiresRestart(cfg, newCfg)
-		newCfg.Location = cfg.Location
-		newCfg.Save()
-		cfg = newCfg
 	}
 }
 
 func restGetConfigInSync(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	json.NewEncoder(w).Encode(map[string]bool{"configInSync": configInSync})
+	json.NewEncoder(w).Encode(map[string]bool{"configInSync": !cfgWrapper.RequiresRestart()})
 }
 
 func restPostRestart(w http.ResponseWriter, r *http.Request) {
@@ -386,9 +881,14 @@ func restGetSystem(w http.ResponseWriter, r *http.Request) {
 	res["alloc"] = m.Alloc
 	res["sys"] = m.Sys - m.HeapReleased
 	res["tilde"] = expandTilde("~")
+	res["listenAddresses"] = listenAddrs
+	res["guiAddress"] = guiAddr
 	if cfg.Options.GlobalAnnEnabled && discoverer != nil {
 		res["extAnnounceOK"] = discoverer.ExtAnnounceOK()
 	}
+	if cfg.Options.RelaysEnabled && relayStatus != nil {
+		res["relays"] = relayStatus()
+	}
 	cpuUsageLock.RLock()
 	var cpusum float64
 	for _, p := range cpuUsagePercent {
@@ -408,6 +908,40 @@ func restGetErrors(w http.ResponseWriter, r *http.Request) {
 	guiErrorsMut.Unlock()
 }
 
+func restGetLog(w http.ResponseWriter, r *http.Request) {
+	since, err := logSince(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string][]logger.Line{
+		"messages": systemLog.Since(since),
+	})
+}
+
+func restGetLogTxt(w http.ResponseWriter, r *http.Request) {
+	since, err := logSince(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range systemLog.Since(since) {
+		fmt.Fprintf(w, "%s %s: %s\n", line.When.Format("2006-01-02 15:04:05.000"), line.Level, line.Message)
+	}
+}
+
+// logSince returns the time given in the "since" parameter, or the zero
+// time if there is none.
+func logSince(r *http.Request) (time.Time, error) {
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, since)
+}
+
 func restPostError(w http.ResponseWriter, r *http.Request) {
 	bs, _ := ioutil.ReadAll(r.Body)
 	r.Body.Close()
@@ -429,6 +963,31 @@ func showGuiError(l logger.LogLevel, err string) {
 	guiErrorsMut.Unlock()
 }
 
+func restGetDebug(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(l.Facilities())
+}
+
+// restPostDebug enables and disables debug facilities given as comma
+// separated lists in the "enable" and "disable" parameters.
+func restPostDebug(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	for _, param := range []string{"enable", "disable"} {
+		if qs.Get(param) == "" {
+			continue
+		}
+		for _, name := range strings.Split(qs.Get(param), ",") {
+			err := l.SetDebug(strings.TrimSpace(name), param == "enable")
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			l.Infof("Debug output for %q %sd", name, param)
+		}
+	}
+	restGetDebug(w, r)
+}
+
 func restPostDiscoveryHint(w http.ResponseWriter, r *http.Request) {
 	var qs = r.URL.Query()
 	var device = qs.Get("device")
@@ -442,9 +1001,15 @@ func restGetDiscovery(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(discoverer.All())
 }
 
+// restGetReport returns the usage report exactly as it would be sent, at the
+// configured granularity or the one given in "granularity".
 func restGetReport(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	granularity := cfg.Options.URGranularity
+	if g := r.URL.Query().Get("granularity"); g != "" {
+		granularity = g
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	json.NewEncoder(w).Encode(reportData(m))
+	w.Write(usageReport(m, granularity))
 }
 
 func restGetIgnores(m *model.Model, w http.ResponseWriter, r *http.Request) {
@@ -500,6 +1065,13 @@ func restGetEvents(w http.ResponseWriter, r *http.Request) {
 	if 0 < limit && limit < len(evs) {
 		evs = evs[len(evs)-limit:]
 	}
+	if requestRole(r) == auth.RoleReadOnly {
+		for i, ev := range evs {
+			if saved, ok := ev.Data.(*config.Configuration); ok {
+				evs[i].Data = sanitizedConfig(*saved)
+			}
+		}
+	}
 
 	json.NewEncoder(w).Encode(evs)
 }
@@ -514,6 +1086,9 @@ func restGetUpgrade(w http.ResponseWriter, r *http.Request) {
 	res["running"] = Version
 	res["latest"] = rel.Tag
 	res["newer"] = upgrade.CompareVersions(rel.Tag, Version) == 1
+	res["notes"] = rel.Notes
+	res["url"] = rel.URL
+	res["progress"] = upgrade.CurrentProgress()
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(w).Encode(res)
@@ -562,6 +1137,7 @@ func restPostUpgrade(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		upgrade.RestartPending(0)
 		flushResponse(`{"ok": "restarting"}`, w)
 		l.Infoln("Upgrading")
 		stop <- exitUpgrading
@@ -578,6 +1154,56 @@ func restPostScan(m *model.Model, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// restPostFetch fetches the contents of a placeholder file.
+func restPostFetch(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+	file := qs.Get("file")
+	err := m.FetchPlaceholder(folder, filepath.FromSlash(file))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// restPostFolderPause stops scanning and pulling the folder, keeping its
+// index. It returns when the scan or pull in progress is done.
+func restPostFolderPause(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	folder := r.URL.Query().Get("folder")
+	folderCfg := cfg.GetFolderConfiguration(folder)
+	if folderCfg == nil {
+		http.Error(w, "No such folder", 404)
+		return
+	}
+
+	l.Infoln("Pausing folder", folder)
+	folderCfg.Paused = true
+	cfg.Save()
+	if err := m.PauseFolder(folder); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// restPostFolderResume starts scanning and pulling the paused folder again.
+func restPostFolderResume(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	folder := r.URL.Query().Get("folder")
+	folderCfg := cfg.GetFolderConfiguration(folder)
+	if folderCfg == nil {
+		http.Error(w, "No such folder", 404)
+		return
+	}
+
+	l.Infoln("Resuming folder", folder)
+	folderCfg.Paused = false
+	cfg.Save()
+	if folderCfg.Invalid != "" {
+		// It isn't started until the problem is fixed and syncthing restarted
+		return
+	}
+	if err := m.ResumeFolder(folder); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
 func getQR(w http.ResponseWriter, r *http.Request) {
 	var qs = r.URL.Query()
 	var text = qs.Get("text")
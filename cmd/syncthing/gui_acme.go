@@ -0,0 +1,173 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/acme"
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+const (
+	acmeCertPrefix    = "https-acme-"
+	acmeAccountFile   = "acme-account.pem"
+	acmeRenewBefore   = 30 * 24 * time.Hour
+	acmeCheckInterval = 12 * time.Hour
+	acmeRetryInterval = time.Hour
+)
+
+// An acmeManager keeps a publicly trusted certificate for the GUI, obtained
+// via ACME, up to date. The self signed certificate is served until the
+// first one has been obtained.
+type acmeManager struct {
+	cfg  config.GUIConfiguration
+	cert *tls.Certificate
+	mut  sync.Mutex
+}
+
+func newACMEManager(cfg config.GUIConfiguration, fallback tls.Certificate) *acmeManager {
+	m := &acmeManager{
+		cfg:  cfg,
+		cert: &fallback,
+	}
+	if cert, err := loadCert(confDir, acmeCertPrefix); err == nil {
+		m.cert = &cert
+	}
+	return m
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (m *acmeManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.cert, nil
+}
+
+func (m *acmeManager) Serve() {
+	for {
+		interval := acmeCheckInterval
+		if m.needsRenewal() {
+			l.Infoln("Requesting GUI certificate for", m.cfg.ACMEDomain)
+			if err := m.renew(); err != nil {
+				l.Warnln("Obtaining GUI certificate:", err)
+				interval = acmeRetryInterval
+			} else {
+				l.Okln("Obtained GUI certificate for", m.cfg.ACMEDomain)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// needsRenewal returns true if we do not yet have a certificate for the
+// domain or it is about to expire.
+func (m *acmeManager) needsRenewal() bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	leaf, err := x509.ParseCertificate(m.cert.Certificate[0])
+	if err != nil || leaf.VerifyHostname(m.cfg.ACMEDomain) != nil {
+		return true
+	}
+	return time.Now().Add(acmeRenewBefore).After(leaf.NotAfter)
+}
+
+func (m *acmeManager) renew() error {
+	var solver acme.Solver
+	switch m.cfg.ACMEChallenge {
+	case "http-01":
+		solver = &acme.HTTPSolver{Address: m.cfg.ACMEHTTPAddress}
+	case "dns-01":
+		if m.cfg.ACMEDNSHook == "" {
+			return fmt.Errorf("dns-01 challenge requires a DNS hook command")
+		}
+		solver = &acme.DNSHookSolver{Command: m.cfg.ACMEDNSHook}
+	default:
+		return fmt.Errorf("unsupported challenge type %q", m.cfg.ACMEChallenge)
+	}
+
+	accountKey, err := loadOrCreateECKey(filepath.Join(confDir, acmeAccountFile))
+	if err != nil {
+		return err
+	}
+	client := acme.NewClient(m.cfg.ACMEDirectory, accountKey)
+	if err := client.Register(m.cfg.ACMEEmail); err != nil {
+		return err
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	chain, err := client.ObtainCertificate([]string{m.cfg.ACMEDomain}, certKey, solver)
+	if err != nil {
+		return err
+	}
+
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(confDir, acmeCertPrefix+"cert.pem"), certPEM, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(confDir, acmeCertPrefix+"key.pem"), keyPEM, 0600); err != nil {
+		return err
+	}
+
+	m.mut.Lock()
+	m.cert = &cert
+	m.mut.Unlock()
+	return nil
+}
+
+// loadOrCreateECKey loads the P-256 key in the given file, creating it if it
+// does not exist.
+func loadOrCreateECKey(path string) (*ecdsa.PrivateKey, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(bs)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM data", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	return key, err
+}
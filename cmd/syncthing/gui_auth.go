@@ -6,6 +6,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"math/rand"
 	"net/http"
@@ -14,17 +15,32 @@ import (
 	"time"
 
 	"code.google.com/p/go.crypto/bcrypt"
+	"github.com/syncthing/syncthing/internal/auth"
 	"github.com/syncthing/syncthing/internal/config"
 )
 
 var (
-	sessions    = make(map[string]bool)
+	sessions    = make(map[string]string) // session ID -> role
 	sessionsMut sync.Mutex
 )
 
+// externalAuthenticator returns the authenticator for users other than the
+// configured one, or nil if there is none. Users it denies are throttled.
+func externalAuthenticator(cfg config.GUIConfiguration) auth.Authenticator {
+	switch {
+	case cfg.AuthCommand != "":
+		return auth.NewThrottle(auth.Command{Command: cfg.AuthCommand})
+	case cfg.AuthURL != "":
+		return auth.NewThrottle(auth.URL{URL: cfg.AuthURL})
+	}
+	return nil
+}
+
 func basicAuthAndSessionMiddleware(cfg config.GUIConfiguration, next http.Handler) http.Handler {
+	external := externalAuthenticator(cfg)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if cfg.APIKey != "" && r.Header.Get("X-API-Key") == cfg.APIKey {
+		if config.SecretMatches(cfg.APIKey, r.Header.Get("X-API-Key")) {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -32,10 +48,10 @@ func basicAuthAndSessionMiddleware(cfg config.GUIConfiguration, next http.Handle
 		cookie, err := r.Cookie("sessionid")
 		if err == nil && cookie != nil {
 			sessionsMut.Lock()
-			_, ok := sessions[cookie.Value]
+			role, ok := sessions[cookie.Value]
 			sessionsMut.Unlock()
 			if ok {
-				next.ServeHTTP(w, r)
+				serveWithRole(role, next, w, r)
 				return
 			}
 		}
@@ -65,19 +81,25 @@ func basicAuthAndSessionMiddleware(cfg config.GUIConfiguration, next http.Handle
 			return
 		}
 
-		if string(fields[0]) != cfg.User {
-			error()
-			return
+		var role string
+		if cfg.User != "" && string(fields[0]) == cfg.User {
+			if err := bcrypt.CompareHashAndPassword([]byte(cfg.Password), fields[1]); err == nil {
+				role = auth.RoleAdmin
+			}
+		} else if external != nil {
+			role, err = external.Authenticate(string(fields[0]), string(fields[1]))
+			if err != nil {
+				l.Warnf("Authenticating GUI user %q: %v", fields[0], err)
+			}
 		}
-
-		if err := bcrypt.CompareHashAndPassword([]byte(cfg.Password), fields[1]); err != nil {
+		if role == "" {
 			error()
 			return
 		}
 
 		sessionid := randomString(32)
 		sessionsMut.Lock()
-		sessions[sessionid] = true
+		sessions[sessionid] = role
 		sessionsMut.Unlock()
 		http.SetCookie(w, &http.Cookie{
 			Name:   "sessionid",
@@ -85,6 +107,37 @@ func basicAuthAndSessionMiddleware(cfg config.GUIConfiguration, next http.Handle
 			MaxAge: 0,
 		})
 
-		next.ServeHTTP(w, r)
+		serveWithRole(role, next, w, r)
 	})
 }
+
+// Paths users with the read only role may not get, as they show more than
+// the state of the folders and devices.
+var readOnlyDenied = map[string]bool{
+	"/rest/system/audit":   true,
+	"/rest/system/log":     true,
+	"/rest/system/log.txt": true,
+}
+
+type roleKey struct{}
+
+// serveWithRole serves the request if the role allows it, with the role
+// available to the handlers through requestRole. Users with the read only
+// role may only make GET requests, and not for the paths in readOnlyDenied.
+func serveWithRole(role string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if role == auth.RoleReadOnly && (r.Method != "GET" && r.Method != "HEAD" || readOnlyDenied[r.URL.Path]) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), roleKey{}, role)))
+}
+
+// requestRole returns the role of the user making the request. Requests
+// made with the API key, or when no users are configured, are made as an
+// admin.
+func requestRole(r *http.Request) string {
+	if role, ok := r.Context().Value(roleKey{}).(string); ok {
+		return role
+	}
+	return auth.RoleAdmin
+}
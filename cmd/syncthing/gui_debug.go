@@ -0,0 +1,130 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+// debugHandler serves Go profiling data, memory statistics and support
+// bundles under /rest/debug/. These expose internals of the process and so
+// are only available to requests carrying the API key.
+func debugHandler(apiKey string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/memstats", getMemStats)
+	mux.HandleFunc("/debug/support", getSupportBundle)
+
+	// The pprof handlers expect to live under /debug/pprof/
+	handler := http.StripPrefix("/rest", mux)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.SecretMatches(apiKey, r.Header.Get("X-API-Key")) {
+			http.Error(w, "Debug endpoints require the API key", http.StatusForbidden)
+			return
+		}
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func getMemStats(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(m)
+}
+
+// getSupportBundle serves a zip file with the information usually needed to
+// debug a problem: version, sanitized configuration, recent log lines, and
+// heap and goroutine profiles.
+func getSupportBundle(w http.ResponseWriter, r *http.Request) {
+	name := fmt.Sprintf("syncthing-support-%s-%s.zip", myID.String()[:5], time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+
+	if fw, err := zw.Create("version.txt"); err == nil {
+		fmt.Fprintln(fw, LongVersion)
+	}
+
+	if fw, err := zw.Create("config.json"); err == nil {
+		bs, _ := json.MarshalIndent(sanitizedConfig(cfg), "", "  ")
+		fw.Write(bs)
+	}
+
+	if fw, err := zw.Create("log.txt"); err == nil {
+		for _, line := range systemLog.Since(time.Time{}) {
+			fmt.Fprintf(fw, "%s %s: %s\n", line.When.Format("2006-01-02 15:04:05.000"), line.Level, line.Message)
+		}
+	}
+
+	if fw, err := zw.Create("memstats.json"); err == nil {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		bs, _ := json.MarshalIndent(m, "", "  ")
+		fw.Write(bs)
+	}
+
+	if fw, err := zw.Create("heap.pprof"); err == nil {
+		rpprof.Lookup("heap").WriteTo(fw, 0)
+	}
+
+	if fw, err := zw.Create("goroutines.txt"); err == nil {
+		rpprof.Lookup("goroutine").WriteTo(fw, 2)
+	}
+}
+
+// sanitizedConfig returns a copy of the configuration with credentials
+// removed.
+func sanitizedConfig(c config.Configuration) config.Configuration {
+	c.GUI.User = ""
+	c.GUI.Password = ""
+	c.GUI.APIKey = ""
+	c.GUI.AuthURL = "" // may include credentials for the endpoint
+
+	// The folders are shared with the running configuration, so are copied
+	// before removing the passwords of encrypted folders.
+	folders := make([]config.FolderConfiguration, len(c.Folders))
+	for i, folder := range c.Folders {
+		devices := make([]config.FolderDeviceConfiguration, len(folder.Devices))
+		for j, device := range folder.Devices {
+			device.EncryptionPassword = ""
+			devices[j] = device
+		}
+		folder.Devices = devices
+		folders[i] = folder
+	}
+	c.Folders = folders
+
+	// Relay URIs may carry a join token in the query
+	relays := make([]string, len(c.Options.RelayServers))
+	for i, relay := range c.Options.RelayServers {
+		if uri, err := url.Parse(relay); err == nil {
+			uri.RawQuery = ""
+			relays[i] = uri.String()
+		}
+	}
+	c.Options.RelayServers = relays
+	return c
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/model"
+	"github.com/syncthing/syncthing/internal/osutil"
+)
+
+const (
+	healthOK   = "OK"
+	healthWarn = "WARN"
+	healthFail = "FAIL"
+
+	// Free disk space, in percent, below which a folder is a warning or a
+	// failure.
+	diskWarnPct = 5
+	diskFailPct = 1
+)
+
+type folderHealth struct {
+	Status    string    `json:"status"`
+	State     string    `json:"state"` // idle, scanning, syncing, cleaning or error
+	Since     time.Time `json:"since"`
+	Reason    string    `json:"reason,omitempty"`
+	FreeBytes int64     `json:"freeBytes"`
+	FreePct   float64   `json:"freePct"`
+}
+
+type deviceHealth struct {
+	Connected bool `json:"connected"`
+}
+
+type dbHealth struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type systemHealth struct {
+	Status  string                  `json:"status"`
+	Folders map[string]folderHealth `json:"folders"`
+	Devices map[string]deviceHealth `json:"devices"`
+	DB      dbHealth                `json:"db"`
+}
+
+// worse returns the worse of two health statuses.
+func worse(a, b string) string {
+	if a == healthFail || b == healthFail {
+		return healthFail
+	}
+	if a == healthWarn || b == healthWarn {
+		return healthWarn
+	}
+	return healthOK
+}
+
+func checkHealth(m *model.Model) systemHealth {
+	res := systemHealth{
+		Status:  healthOK,
+		Folders: make(map[string]folderHealth),
+		Devices: make(map[string]deviceHealth),
+		DB:      dbHealth{Status: healthOK},
+	}
+
+	for _, folder := range cfg.Folders {
+		fh := folderHealth{Status: healthOK}
+		fh.State, fh.Since = m.State(folder.ID)
+
+		if folder.Invalid != "" {
+			fh.Status = healthFail
+			fh.State = "error"
+			fh.Reason = folder.Invalid
+		} else if free, total, err := osutil.DiskUsage(folder.Path); err != nil {
+			fh.Status = healthWarn
+			fh.Reason = err.Error()
+		} else if total > 0 {
+			fh.FreeBytes = free
+			fh.FreePct = 100 * float64(free) / float64(total)
+			switch {
+			case fh.FreePct < diskFailPct:
+				fh.Status = healthFail
+				fh.Reason = "out of disk space"
+			case fh.FreePct < diskWarnPct:
+				fh.Status = healthWarn
+				fh.Reason = "low on disk space"
+			}
+		}
+
+		res.Folders[folder.ID] = fh
+		res.Status = worse(res.Status, fh.Status)
+	}
+
+	var remotes, connected int
+	for _, device := range cfg.Devices {
+		if device.DeviceID == myID {
+			continue
+		}
+		c := m.ConnectedTo(device.DeviceID)
+		res.Devices[device.DeviceID.String()] = deviceHealth{Connected: c}
+		remotes++
+		if c {
+			connected++
+		}
+	}
+	if remotes > 0 && connected == 0 {
+		// We can't sync anything without any connected devices
+		res.Status = worse(res.Status, healthWarn)
+	}
+
+	if err := m.CheckDB(); err != nil {
+		res.DB = dbHealth{Status: healthFail, Reason: err.Error()}
+		res.Status = healthFail
+	}
+
+	return res
+}
+
+// restGetHealth returns the overall health of the system. The response has
+// status 503 when the verdict is FAIL, so that it can be used directly by
+// load balancers and monitoring probes.
+func restGetHealth(m *model.Model, w http.ResponseWriter, r *http.Request) {
+	res := checkHealth(m)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if res.Status == healthFail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(res)
+}
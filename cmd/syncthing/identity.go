@@ -0,0 +1,165 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// Unlike a certificate rotation, regenerating the identity switches to a new
+// certificate and device ID at once, without announcing it to other devices
+// first. It is meant for when a device is decommissioned or provisioned
+// anew. The old certificate and key are kept as "previous-cert.pem" and
+// "previous-key.pem".
+
+// regenerateIdentity replaces our certificate and key with a new one of the
+// given key type, and moves our entries in the configuration over to the new
+// device ID. It returns the new device ID, and warnings about what the
+// change means for the other devices.
+func regenerateIdentity(cfg *config.Configuration, keyType string) (protocol.DeviceID, []string, error) {
+	if _, ok := loadSuccession(); ok {
+		return protocol.DeviceID{}, nil, errors.New("a certificate rotation is in progress")
+	}
+	if !validKeyType(keyType) {
+		return protocol.DeviceID{}, nil, fmt.Errorf("unsupported key type %q", keyType)
+	}
+
+	cur, err := loadCert(confDir, "")
+	if err != nil {
+		return protocol.DeviceID{}, nil, err
+	}
+	prev := protocol.NewDeviceID(cur.Certificate[0])
+
+	for _, name := range []string{"cert.pem", "key.pem"} {
+		if err := os.Rename(filepath.Join(confDir, name), filepath.Join(confDir, prevCertPrefix+name)); err != nil {
+			return protocol.DeviceID{}, nil, err
+		}
+	}
+	newCertificate(confDir, "", keyType)
+	next, err := loadCert(confDir, "")
+	if err != nil {
+		return protocol.DeviceID{}, nil, err
+	}
+	id := protocol.NewDeviceID(next.Certificate[0])
+
+	// The configuration is signed with the new key from now on.
+	if signer, ok := next.PrivateKey.(crypto.Signer); ok {
+		config.SetSigningKey(signer)
+	}
+	cfg.ReplaceDeviceID(prev, id)
+	if err := cfg.Save(); err != nil {
+		return id, nil, err
+	}
+
+	l.Infof("Regenerated identity; switched from device ID %v to %v", prev, id)
+
+	warnings := []string{
+		fmt.Sprintf("Device ID %v is no longer valid; devices that knew it will not connect to %v until they have added it", prev, id),
+	}
+	for _, device := range cfg.Devices {
+		if device.DeviceID == id {
+			continue
+		}
+		name := device.Name
+		if name == "" {
+			name = device.DeviceID.String()
+		}
+		warnings = append(warnings, fmt.Sprintf("Device %s must add device ID %v and share its folders with it again", name, id))
+	}
+	return id, warnings, nil
+}
+
+// regenerateIdentityOffline regenerates the identity of an instance that is
+// not running, for the -regenerate-identity option.
+func regenerateIdentityOffline(keyType string) error {
+	if guiURL := runningInstance(); guiURL != "" {
+		return fmt.Errorf("syncthing is running; use the REST API at %srest/identity/regenerate instead", guiURL)
+	}
+
+	cur, err := loadCert(confDir, "")
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(filepath.Join(confDir, "config.xml"), protocol.NewDeviceID(cur.Certificate[0]))
+	if err != nil {
+		return err
+	}
+
+	// Re-signing a configuration modified outside of syncthing would make
+	// it look untampered.
+	if signer, ok := cur.PrivateKey.(crypto.Signer); ok {
+		if err := cfg.VerifySignature(signer.Public()); err != nil {
+			return fmt.Errorf("%s: %v; start syncthing to review it first", cfg.Location, err)
+		}
+	}
+
+	id, warnings, err := regenerateIdentity(&cfg, keyType)
+	if err != nil {
+		return err
+	}
+	l.Infoln("New device ID:", id)
+	for _, warning := range warnings {
+		l.Warnln(warning)
+	}
+	return nil
+}
+
+// certKeyType returns the key type of the certificate, as given to
+// -keytype.
+func certKeyType(cert tls.Certificate) string {
+	if key, ok := cert.PrivateKey.(*ecdsa.PrivateKey); ok {
+		if key.Curve == elliptic.P384() {
+			return KeyTypeECDSAP384
+		}
+		return KeyTypeECDSAP256
+	}
+	return KeyTypeRSA
+}
+
+// regenerateIdentityHandler switches to a new certificate and device ID and
+// restarts. As this cuts us off from all other devices until they have added
+// the new device ID, it is only available to requests carrying the API key.
+// The key type defaults to that of the current certificate.
+func regenerateIdentityHandler(apiKey string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.SecretMatches(apiKey, r.Header.Get("X-API-Key")) {
+			http.Error(w, "Regenerating the device identity requires the API key", http.StatusForbidden)
+			return
+		}
+
+		keyType := r.URL.Query().Get("keytype")
+		if keyType == "" {
+			keyType = certKeyType(cert)
+		}
+
+		prev := myID
+		id, warnings, err := regenerateIdentity(&cfg, keyType)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"deviceID":         id.String(),
+			"previousDeviceID": prev.String(),
+			"warnings":         warnings,
+		})
+		w.(http.Flusher).Flush()
+		go restart()
+	})
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A running instance records where its GUI can be reached in the lock file
+// in its data directory. An instance about to start up asks the GUI given in
+// the lock file for a ping; if it answers, the data directory is in use, and
+// we hand over to the running instance rather than fail on the locked
+// database. A lock file left behind by an instance that crashed is simply
+// replaced.
+
+const (
+	lockFileName        = "syncthing.lock"
+	instancePingTimeout = 5 * time.Second
+)
+
+type instanceLock struct {
+	PID        int    `json:"pid"`
+	GUIAddress string `json:"guiAddress"`
+}
+
+func lockFilePath() string {
+	return filepath.Join(dataDir, lockFileName)
+}
+
+// runningInstance returns the GUI address of the instance using the data
+// directory, or the empty string if there is none.
+func runningInstance() string {
+	bs, err := ioutil.ReadFile(lockFilePath())
+	if err != nil {
+		return ""
+	}
+	var lock instanceLock
+	if err := json.Unmarshal(bs, &lock); err != nil || lock.GUIAddress == "" {
+		return ""
+	}
+
+	client := &http.Client{
+		Timeout: instancePingTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := client.Get(lock.GUIAddress + "rest/ping")
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+
+	// Our responses carry the version header, except when asking for
+	// authentication.
+	if resp.Header.Get("X-Syncthing-Version") == "" && resp.StatusCode != http.StatusUnauthorized {
+		return ""
+	}
+	return lock.GUIAddress
+}
+
+// handOverToRunningInstance exits, pointing the user to the GUI of the
+// instance already using the data directory, if there is one.
+func handOverToRunningInstance() {
+	guiURL := runningInstance()
+	if guiURL == "" {
+		return
+	}
+
+	l.Infoln("Syncthing is already running with data directory", dataDir)
+	l.Infoln("Its web GUI is at", guiURL)
+	if !headless && !noBrowser {
+		openURL(guiURL)
+	}
+	os.Exit(exitSuccess)
+}
+
+// writeLockFile records the GUI address of this instance in the lock file.
+func writeLockFile(guiURL string) {
+	bs, err := json.Marshal(instanceLock{
+		PID:        os.Getpid(),
+		GUIAddress: guiURL,
+	})
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(lockFilePath(), bs, 0600); err != nil {
+		l.Infoln("Writing lock file:", err)
+	}
+}
+
+func removeLockFile() {
+	os.Remove(lockFilePath())
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// The configuration directory holds what is worth backing up: config.xml and
+// the certificates and keys. The data directory holds the index database and
+// the state, logs and reports that can be recreated or thrown away. By
+// default they are separate directories following the XDG base directory
+// specification on Linux and the other Unixes, and the same directory on
+// Windows and Mac OS X as is the convention there.
+
+// dataFiles are the files and file patterns kept in the data directory, as
+// moved there from a configuration directory that used to hold them.
+var dataFiles = []string{
+	"index",
+	"csrftokens.txt",
+	"audit.log",
+	"upgrade-check",
+	"panic-*.log",
+	"crash-*.json",
+}
+
+// setupDirs sets confDir and dataDir from the -home, -config and -data
+// options. The -home option puts both in the same directory, as syncthing
+// has always done; otherwise each defaults to its platform location.
+func setupDirs(home, config, data string) {
+	if home != "" && (config != "" || data != "") {
+		l.Fatalln("The -home option cannot be combined with -config or -data")
+	}
+
+	switch {
+	case home != "":
+		confDir, dataDir = home, home
+	default:
+		confDir, dataDir = config, data
+		if confDir == "" {
+			confDir = getDefaultConfDir()
+		}
+		if dataDir == "" {
+			dataDir = getDefaultDataDir()
+		}
+	}
+
+	confDir = expandTilde(confDir)
+	dataDir = expandTilde(dataDir)
+
+	for _, dir := range []string{confDir, dataDir} {
+		if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+			l.Fatalln(dir, "is not a directory")
+		}
+	}
+}
+
+func getDefaultConfDir() string {
+	switch runtime.GOOS {
+	case "windows":
+		return filepath.Join(os.Getenv("LocalAppData"), "Syncthing")
+
+	case "darwin":
+		return expandTilde("~/Library/Application Support/Syncthing")
+
+	default:
+		if xdgCfg := os.Getenv("XDG_CONFIG_HOME"); xdgCfg != "" {
+			return filepath.Join(xdgCfg, "syncthing")
+		} else {
+			return expandTilde("~/.config/syncthing")
+		}
+	}
+}
+
+func getDefaultDataDir() string {
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		return getDefaultConfDir()
+
+	default:
+		if xdgData := os.Getenv("XDG_DATA_HOME"); xdgData != "" {
+			return filepath.Join(xdgData, "syncthing")
+		} else {
+			return expandTilde("~/.local/share/syncthing")
+		}
+	}
+}
+
+// migrateDataDir moves the data files out of the configuration directory
+// into a separate data directory that doesn't have an index database yet, as
+// when starting for the first time with a layout from before the two were
+// split. If the index database can't be moved, for example as the data
+// directory is on another file system, the data directory is set to the
+// configuration directory so that the existing database stays in use.
+func migrateDataDir() {
+	if confDir == dataDir {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(confDir, "index")); err != nil {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "index")); err == nil {
+		return
+	}
+
+	for _, pat := range dataFiles {
+		names, err := filepath.Glob(filepath.Join(confDir, pat))
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			dst := filepath.Join(dataDir, filepath.Base(name))
+			if err := os.Rename(name, dst); err != nil {
+				if filepath.Base(name) == "index" {
+					l.Warnf("Cannot move index database to %s: %v; keeping data in %s", dataDir, err, confDir)
+					dataDir = confDir
+					return
+				}
+				l.Infof("Cannot move %s to %s: %v", name, dataDir, err)
+				continue
+			}
+			l.Infoln("Moved", name, "to", dst)
+		}
+	}
+}
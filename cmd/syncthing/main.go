@@ -5,11 +5,13 @@
 package main
 
 import (
+	"crypto"
 	"crypto/sha1"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net"
@@ -25,17 +27,18 @@ import (
 	"strings"
 	"time"
 
-	"code.google.com/p/go.crypto/bcrypt"
-	"github.com/juju/ratelimit"
+	"github.com/syncthing/syncthing/internal/auto"
 	"github.com/syncthing/syncthing/internal/config"
 	"github.com/syncthing/syncthing/internal/discover"
 	"github.com/syncthing/syncthing/internal/events"
-	"github.com/syncthing/syncthing/internal/files"
+	"github.com/syncthing/syncthing/internal/globalfs"
 	"github.com/syncthing/syncthing/internal/logger"
-	"github.com/syncthing/syncthing/internal/model"
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/internal/metrics"
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/internal/scanner"
 	"github.com/syncthing/syncthing/internal/upgrade"
-	"github.com/syncthing/syncthing/internal/upnp"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/syncthing"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 )
@@ -83,16 +86,20 @@ func init() {
 }
 
 var (
-	cfg            config.Configuration
-	myID           protocol.DeviceID
-	confDir        string
-	logFlags       int = log.Ltime
-	writeRateLimit *ratelimit.Bucket
-	readRateLimit  *ratelimit.Bucket
-	stop           = make(chan int)
-	discoverer     *discover.Discoverer
-	externalPort   int
-	cert           tls.Certificate
+	cfg          config.Configuration
+	cfgWrapper   *config.Wrapper // through which changes to cfg are applied
+	myID         protocol.DeviceID
+	confDir      string
+	dataDir      string
+	logFlags     int = log.Ltime
+	logFormat    string
+	stop         = make(chan int)
+	discoverer   *discover.Discoverer
+	relayStatus  func() []syncthing.RelayStatus
+	listenAddrs  []string
+	guiAddr      string
+	cert         tls.Certificate
+	configSigner crypto.Signer
 )
 
 const (
@@ -109,6 +116,43 @@ I.e. to prefix each log line with date and time, set -logflags=3 (1 + 2 from
 above). The value 0 is used to disable all of the above. The default is to
 show time only (2).
 
+The -keytype option selects the kind of key generated for a new device
+certificate, either by -generate, -rotate-cert or on first startup. ECDSA
+keys make connection setup considerably faster on slow devices such as ARM
+boards. Devices using either kind of key can connect to each other. Changing
+the key type of an existing device means generating a new certificate and
+thereby a new device ID.
+
+The -rotate-cert option generates a new certificate, and thereby a new device
+ID, to replace the current one. For the number of days given by the
+certRotationDays option the current certificate stays in use while the new
+device ID is announced to all connecting devices, together with a signature
+proving that it belongs to the same device. These devices add the new device
+ID to their configuration, sharing the same folders. When the new device ID
+connects for the first time after the switch, the old one is removed.
+
+The -regenerate-identity option switches to a new certificate and device ID
+at once, without announcing it to other devices first, as when a device is
+decommissioned or provisioned anew. The other devices have to add the new
+device ID to connect to it again. A running instance does the same on a POST
+to /rest/identity/regenerate carrying the API key, and restarts.
+
+The -check-config option upgrades a configuration written by an earlier
+release to the current version without starting up, keeping the old one as
+config.xml.v<version>. Configurations are otherwise upgraded when loaded.
+
+The configuration (config.xml and the keys) and the data (the index database,
+logs and state) are kept in separate directories, given by the -config and
+-data options. They default to $XDG_CONFIG_HOME/syncthing and
+$XDG_DATA_HOME/syncthing on Linux and other Unixes, and to the same directory
+elsewhere. The -home option keeps both in the one directory given. An index
+database found in the configuration directory is moved to the data directory
+on startup.
+
+Setting -logformat=json makes each log line a JSON object with the fields
+"time", "level", "package", "caller", "prefix" and "message". The -logflags
+option does not apply to JSON output.
+
 The following enviroment variables are interpreted by syncthing:
 
  STGUIADDRESS  Override GUI listen address set in config. Expects protocol type
@@ -130,7 +174,11 @@ The following enviroment variables are interpreted by syncthing:
                - "discover" (the discover package)
                - "events"   (the events package)
                - "files"    (the files package)
+               - "nat"      (the nat package; port mapping on gateways)
                - "net"      (the main package; connections & network messages)
+               - "netwatch" (the netwatch package)
+               - "pmp"      (the pmp package; NAT-PMP and PCP port mapping)
+               - "relay"    (the relay client package)
                - "model"    (the model package)
                - "scanner"  (the scanner package)
                - "stats"    (the stats package)
@@ -161,29 +209,47 @@ func init() {
 
 // Command line options
 var (
+	homeDirOpt        string
+	confDirOpt        string
+	dataDirOpt        string
 	reset             bool
 	showVersion       bool
 	doUpgrade         bool
 	doUpgradeCheck    bool
 	noBrowser         bool
 	generateDir       string
+	keyType           string
+	rotateCert        bool
+	doCheckConfig     bool
+	regenIdentity     bool
 	guiAddress        string
 	guiAuthentication string
 	guiAPIKey         string
+	mountSpec         string
+	headless          bool
 )
 
 func main() {
-	flag.StringVar(&confDir, "home", getDefaultConfDir(), "Set configuration directory")
+	flag.StringVar(&homeDirOpt, "home", "", "Set configuration and data directory")
+	flag.StringVar(&confDirOpt, "config", "", "Set configuration directory")
+	flag.StringVar(&dataDirOpt, "data", "", "Set data directory (index database, logs and state)")
 	flag.BoolVar(&reset, "reset", false, "Prepare to resync from cluster")
 	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.BoolVar(&doUpgrade, "upgrade", false, "Perform upgrade")
 	flag.BoolVar(&doUpgradeCheck, "upgrade-check", false, "Check for available upgrade")
 	flag.BoolVar(&noBrowser, "no-browser", false, "Do not start browser")
+	flag.BoolVar(&headless, "headless", auto.Headless, "Serve only the REST API, without the web GUI")
 	flag.StringVar(&generateDir, "generate", "", "Generate key in specified dir")
+	flag.BoolVar(&rotateCert, "rotate-cert", false, "Generate a new certificate and device ID to switch to")
+	flag.BoolVar(&doCheckConfig, "check-config", false, "Upgrade the configuration to the current version and exit")
+	flag.BoolVar(&regenIdentity, "regenerate-identity", false, "Switch to a new certificate and device ID at once")
+	flag.StringVar(&keyType, "keytype", KeyTypeRSA, "Key type for generated certificates (\"rsa\", \"ecdsa-p256\" or \"ecdsa-p384\")")
 	flag.StringVar(&guiAddress, "gui-address", "", "Override GUI address")
 	flag.StringVar(&guiAuthentication, "gui-authentication", "", "Override GUI authentication. Expects 'username:password'")
 	flag.StringVar(&guiAPIKey, "gui-apikey", "", "Override GUI API key")
 	flag.IntVar(&logFlags, "logflags", logFlags, "Set log flags")
+	flag.StringVar(&logFormat, "logformat", "text", "Set log format (\"text\" or \"json\")")
+	flag.StringVar(&mountSpec, "mount", "", "Mount the global tree of a folder read only, as 'folder:directory' (requires FUSE support)")
 	flag.Usage = usageFor(flag.CommandLine, usage, extraUsage)
 	flag.Parse()
 
@@ -193,6 +259,15 @@ func main() {
 	}
 
 	l.SetFlags(logFlags)
+	if format, err := logger.ParseFormat(logFormat); err != nil {
+		l.Fatalln(err)
+	} else {
+		l.SetFormat(format)
+	}
+
+	if !validKeyType(keyType) {
+		l.Fatalf("Unsupported key type %q", keyType)
+	}
 
 	if generateDir != "" {
 		dir := expandTilde(generateDir)
@@ -212,7 +287,7 @@ func main() {
 			return
 		}
 
-		newCertificate(dir, "")
+		newCertificate(dir, "", keyType)
 		cert, err = loadCert(dir, "")
 		if err != nil {
 			l.Fatalln("load cert:", err)
@@ -223,14 +298,11 @@ func main() {
 		return
 	}
 
-	confDir = expandTilde(confDir)
-
-	if info, err := os.Stat(confDir); err == nil && !info.IsDir() {
-		l.Fatalln("Config directory", confDir, "is not a directory")
-	}
+	setupDirs(homeDirOpt, confDirOpt, dataDirOpt)
 
-	// Ensure that our home directory exists.
+	// Ensure that our configuration and data directories exist.
 	ensureDir(confDir, 0700)
+	ensureDir(dataDir, 0700)
 
 	if doUpgrade || doUpgradeCheck {
 		rel, err := upgrade.LatestRelease(strings.Contains(Version, "-beta"))
@@ -247,7 +319,7 @@ func main() {
 
 		if doUpgrade {
 			// Use leveldb database locks to protect against concurrent upgrades
-			_, err = leveldb.OpenFile(filepath.Join(confDir, "index"), &opt.Options{CachedOpenFiles: 100})
+			_, err = leveldb.OpenFile(filepath.Join(dataDir, "index"), &opt.Options{CachedOpenFiles: 100})
 			if err != nil {
 				l.Fatalln("Cannot upgrade, database seems to be locked. Is another copy of Syncthing already running?")
 			}
@@ -268,6 +340,31 @@ func main() {
 		return
 	}
 
+	if rotateCert {
+		if err := rotateCertificate(keyType); err != nil {
+			l.Fatalln("Certificate rotation:", err)
+		}
+		return
+	}
+
+	if doCheckConfig {
+		if err := checkConfig(); err != nil {
+			l.Fatalln("Check config:", err)
+		}
+		return
+	}
+
+	if regenIdentity {
+		if err := regenerateIdentityOffline(keyType); err != nil {
+			l.Fatalln("Regenerate identity:", err)
+		}
+		return
+	}
+
+	// Rather than fail on the locked database, point the user to the
+	// instance already running with the same data directory.
+	handOverToRunningInstance()
+
 	if os.Getenv("STNORESTART") != "" {
 		syncthingMain()
 	} else {
@@ -286,7 +383,7 @@ func syncthingMain() {
 		runtime.GOMAXPROCS(runtime.NumCPU())
 	}
 
-	events.Default.Log(events.Starting, map[string]string{"home": confDir})
+	events.Default.Log(events.Starting, map[string]string{"home": confDir, "data": dataDir})
 
 	if _, err = os.Stat(confDir); err != nil && confDir == getDefaultConfDir() {
 		// We are supposed to use the default configuration directory. It
@@ -309,10 +406,17 @@ func syncthingMain() {
 		}
 	}
 
+	// Move the index database and state out of the configuration directory
+	// if they are still kept there.
+	migrateDataDir()
+
+	// Switch to the next certificate if a rotation is due.
+	predecessor, switched := switchCertificate()
+
 	// Ensure that that we have a certificate and key.
 	cert, err = loadCert(confDir, "")
 	if err != nil {
-		newCertificate(confDir, "")
+		newCertificate(confDir, "", keyType)
 		cert, err = loadCert(confDir, "")
 		if err != nil {
 			l.Fatalln("load cert:", err)
@@ -322,9 +426,19 @@ func syncthingMain() {
 	myID = protocol.NewDeviceID(cert.Certificate[0])
 	l.SetPrefix(fmt.Sprintf("[%s] ", myID.String()[:5]))
 
+	configSigner, _ = cert.PrivateKey.(crypto.Signer)
+
 	l.Infoln(LongVersion)
 	l.Infoln("My ID:", myID)
 
+	// Select the fastest way of hashing files. Scanning may start in the
+	// meantime, using the default method until then.
+	go func() {
+		if rate, err := scanner.TuneHashing(); err == nil {
+			l.Infof("Hashing performance is %.02f MiB/s", rate/(1<<20))
+		}
+	}()
+
 	// Prepare to be able to save configuration
 
 	cfgFile := filepath.Join(confDir, "config.xml")
@@ -336,6 +450,18 @@ func syncthingMain() {
 
 	cfg, err = config.Load(cfgFile, myID)
 	if err == nil {
+		// Only sign the config once we know it is untampered, or the user
+		// has reviewed and saved it.
+		if configSigner != nil {
+			if err := cfg.VerifySignature(configSignerPublic(switched)); err != nil {
+				l.Warnf("%s: %v; it may have been modified outside of syncthing. Folders will not be started until the configuration has been reviewed and saved.", cfgFile, err)
+				for i := range cfg.Folders {
+					cfg.Folders[i].Invalid = "configuration modified outside of syncthing"
+				}
+			} else {
+				config.SetSigningKey(configSigner)
+			}
+		}
 		myCfg := cfg.GetDeviceConfiguration(myID)
 		if myCfg == nil || myCfg.Name == "" {
 			myName, _ = os.Hostname()
@@ -344,6 +470,7 @@ func syncthingMain() {
 		}
 	} else {
 		l.Infoln("No config file; starting with empty defaults")
+		config.SetSigningKey(configSigner)
 		myName, _ = os.Hostname()
 		defaultFolder := filepath.Join(getHomeDir(), "Sync")
 
@@ -380,6 +507,16 @@ func syncthingMain() {
 		l.Infof("Edit %s to taste or use the GUI\n", cfgFile)
 	}
 
+	if switched {
+		forgetPredecessor(&cfg, predecessor)
+	}
+
+	setupLogTarget()
+
+	metrics.SetSlowThreshold("scan", time.Duration(cfg.Options.SlowScanS)*time.Second)
+	metrics.SetSlowThreshold("pull", time.Duration(cfg.Options.SlowPullS)*time.Second)
+	metrics.SetSlowThreshold("index", time.Duration(cfg.Options.SlowIndexS)*time.Second)
+
 	if profiler := os.Getenv("STPROFILER"); len(profiler) > 0 {
 		go func() {
 			l.Debugln("Starting profiler on", profiler)
@@ -391,81 +528,36 @@ func syncthingMain() {
 		}()
 	}
 
-	// The TLS configuration is used for both the listening socket and outgoing
-	// connections.
-
-	tlsCfg := &tls.Config{
-		Certificates:           []tls.Certificate{cert},
-		NextProtos:             []string{"bep/1.0"},
-		ServerName:             myID.String(),
-		ClientAuth:             tls.RequestClientCert,
-		SessionTicketsDisabled: true,
-		InsecureSkipVerify:     true,
-		MinVersion:             tls.VersionTLS12,
-	}
-
-	// If the read or write rate should be limited, set up a rate limiter for it.
-	// This will be used on connections created in the connect and listen routines.
-
-	if cfg.Options.MaxSendKbps > 0 {
-		writeRateLimit = ratelimit.NewBucketWithRate(float64(1000*cfg.Options.MaxSendKbps), int64(5*1000*cfg.Options.MaxSendKbps))
-	}
-	if cfg.Options.MaxRecvKbps > 0 {
-		readRateLimit = ratelimit.NewBucketWithRate(float64(1000*cfg.Options.MaxRecvKbps), int64(5*1000*cfg.Options.MaxRecvKbps))
-	}
-
 	// If this is the first time the user runs v0.9, archive the old indexes and config.
 	archiveLegacyConfig()
 
-	db, err := leveldb.OpenFile(filepath.Join(confDir, "index"), &opt.Options{CachedOpenFiles: 100})
+	profileName := cfg.Options.DeviceProfile
+	if profileName == config.ProfileAuto {
+		mem, _ := memorySize()
+		profileName = config.DetectProfile(runtime.NumCPU(), mem)
+	}
+	l.Infof("Using the %s device profile", profileName)
+
+	app, err := syncthing.New(syncthing.Config{
+		DataDir:       dataDir,
+		Cert:          cert,
+		Configuration: &cfg,
+		DeviceName:    myName,
+		ClientName:    "syncthing",
+		ClientVersion: Version,
+		Profile:       config.Profiles[profileName],
+		Logger:        l,
+	})
 	if err != nil {
-		l.Fatalln("Cannot open database:", err, "- Is another copy of Syncthing already running?")
-	}
-
-	// Remove database entries for folders that no longer exist in the config
-	folderMap := cfg.FolderMap()
-	for _, folder := range files.ListFolders(db) {
-		if _, ok := folderMap[folder]; !ok {
-			l.Infof("Cleaning data for dropped folder %q", folder)
-			files.DropFolder(db, folder)
-		}
+		l.Fatalln(err)
 	}
 
-	m := model.NewModel(confDir, &cfg, myName, "syncthing", Version, db)
-
-nextFolder:
-	for i, folder := range cfg.Folders {
-		if folder.Invalid != "" {
-			continue
-		}
-		folder.Path = expandTilde(folder.Path)
-		m.AddFolder(folder)
-
-		fi, err := os.Stat(folder.Path)
-		if m.CurrentLocalVersion(folder.ID) > 0 {
-			// Safety check. If the cached index contains files but the
-			// folder doesn't exist, we have a problem. We would assume
-			// that all files have been deleted which might not be the case,
-			// so mark it as invalid instead.
-			if err != nil || !fi.IsDir() {
-				l.Warnf("Stopping folder %q - path does not exist, but has files in index", folder.ID)
-				cfg.Folders[i].Invalid = "folder path missing"
-				continue nextFolder
-			}
-		} else if os.IsNotExist(err) {
-			// If we don't have any files in the index, and the directory
-			// doesn't exist, try creating it.
-			err = os.MkdirAll(folder.Path, 0700)
-		}
+	m := app.Model()
+	announceSuccessor(m)
 
-		if err != nil {
-			// If there was another error or we could not create the
-			// path, the folder is invalid.
-			l.Warnf("Stopping folder %q - %v", err)
-			cfg.Folders[i].Invalid = err.Error()
-			continue nextFolder
-		}
-	}
+	cfgWrapper = app.Config()
+	cfgWrapper.Subscribe(guiCommitter{})
+	cfgWrapper.Subscribe(optionsCommitter{})
 
 	// GUI
 
@@ -476,6 +568,17 @@ nextFolder:
 		if err != nil {
 			l.Fatalf("Cannot start GUI on %q: %v", guiCfg.Address, err)
 		} else {
+			// The port may have been picked by the system, for an address
+			// with port zero.
+			boundAddr, err := startGUI(guiCfg, cfg.Options, os.Getenv("STGUIASSETS"), m)
+			if err != nil {
+				l.Fatalln("Cannot start GUI:", err)
+			}
+			guiAddr = boundAddr.String()
+			if tcpAddr, ok := boundAddr.(*net.TCPAddr); ok {
+				addr.Port = tcpAddr.Port
+			}
+
 			var hostOpen, hostShow string
 			switch {
 			case addr.IP == nil:
@@ -495,33 +598,19 @@ nextFolder:
 			}
 
 			urlShow := fmt.Sprintf("%s://%s/", proto, net.JoinHostPort(hostShow, strconv.Itoa(addr.Port)))
-			l.Infoln("Starting web GUI on", urlShow)
-			err := startGUI(guiCfg, os.Getenv("STGUIASSETS"), m)
-			if err != nil {
-				l.Fatalln("Cannot start GUI:", err)
+			if headless {
+				l.Infoln("Starting REST API on", urlShow)
+			} else {
+				l.Infoln("Starting web GUI on", urlShow)
 			}
-			if !noBrowser && cfg.Options.StartBrowser && len(os.Getenv("STRESTART")) == 0 {
-				urlOpen := fmt.Sprintf("%s://%s/", proto, net.JoinHostPort(hostOpen, strconv.Itoa(addr.Port)))
+			urlOpen := fmt.Sprintf("%s://%s/", proto, net.JoinHostPort(hostOpen, strconv.Itoa(addr.Port)))
+			writeLockFile(urlOpen)
+			if !headless && !noBrowser && cfg.Options.StartBrowser && len(os.Getenv("STRESTART")) == 0 {
 				openURL(urlOpen)
 			}
 		}
 	}
 
-	// Clear out old indexes for other devices. Otherwise we'll start up and
-	// start needing a bunch of files which are nowhere to be found. This
-	// needs to be changed when we correctly do persistent indexes.
-	for _, folderCfg := range cfg.Folders {
-		if folderCfg.Invalid != "" {
-			continue
-		}
-		for _, device := range folderCfg.DeviceIDs() {
-			if device == myID {
-				continue
-			}
-			m.Index(device, folderCfg.ID, nil)
-		}
-	}
-
 	// Remove all .idx* files that don't belong to an active folder.
 
 	validIndexes := make(map[string]bool)
@@ -531,7 +620,7 @@ nextFolder:
 		validIndexes[id] = true
 	}
 
-	allIndexes, err := filepath.Glob(filepath.Join(confDir, "*.idx*"))
+	allIndexes, err := filepath.Glob(filepath.Join(dataDir, "*.idx*"))
 	if err == nil {
 		for _, idx := range allIndexes {
 			bn := filepath.Base(idx)
@@ -545,38 +634,18 @@ nextFolder:
 		}
 	}
 
-	// The default port we announce, possibly modified by setupUPnP next.
+	// Start listening, connecting and synchronizing folders
 
-	addr, err := net.ResolveTCPAddr("tcp", cfg.Options.ListenAddress[0])
-	if err != nil {
-		l.Fatalln("Bad listen address:", err)
-	}
-	externalPort = addr.Port
-
-	// UPnP
-
-	if cfg.Options.UPnPEnabled {
-		setupUPnP()
+	if err := app.Start(); err != nil {
+		l.Fatalln(err)
 	}
+	discoverer = app.Discoverer()
+	relayStatus = app.RelayStatus
+	listenAddrs = app.ListenAddresses()
 
-	// Routine to connect out to configured devices
-	discoverer = discovery(externalPort)
-	go listenConnect(myID, m, tlsCfg)
-
-	for _, folder := range cfg.Folders {
-		if folder.Invalid != "" {
-			continue
-		}
-
-		// Routine to pull blocks from other devices to synchronize the local
-		// folder. Does not run when we are in read only (publish only) mode.
-		if folder.ReadOnly {
-			l.Okf("Ready to synchronize %s (read only; no external updates accepted)", folder.ID)
-			m.StartFolderRO(folder.ID)
-		} else {
-			l.Okf("Ready to synchronize %s (read-write)", folder.ID)
-			m.StartFolderRW(folder.ID)
-		}
+	var mountPoint string
+	if mountSpec != "" {
+		mountPoint = mountFolder(m, mountSpec)
 	}
 
 	if cpuprof := os.Getenv("STCPUPROFILE"); len(cpuprof) > 0 {
@@ -588,40 +657,42 @@ nextFolder:
 		defer pprof.StopCPUProfile()
 	}
 
-	for _, device := range cfg.Devices {
-		if len(device.Name) > 0 {
-			l.Infof("Device %s is %q at %v", device.DeviceID, device.Name, device.Addresses)
-		}
-	}
-
 	if cfg.Options.URAccepted > 0 && cfg.Options.URAccepted < usageReportVersion {
 		l.Infoln("Anonymous usage report has changed; revoking acceptance")
 		cfg.Options.URAccepted = 0
 	}
 	if cfg.Options.URAccepted >= usageReportVersion {
+		if cfg.Options.UploadCrashReports {
+			go uploadCrashReports(cfg.Options.CrashReportURL)
+		}
 		go usageReportingLoop(m)
 		go func() {
 			time.Sleep(10 * time.Minute)
-			err := sendUsageReport(m)
+			err := sendUsageReport(m, cfg.Options.URGranularity)
 			if err != nil {
 				l.Infoln("Usage report:", err)
 			}
 		}()
 	}
 
-	if cfg.Options.RestartOnWakeup {
-		go standbyMonitor()
-	}
-
 	if cfg.Options.AutoUpgradeIntervalH > 0 {
 		go autoUpgrade()
 	}
 
+	if healthFile := os.Getenv("STHEALTHFILE"); healthFile != "" {
+		go reportHealthy(healthFile)
+	}
+
 	events.Default.Log(events.StartupComplete, nil)
 	go generateEvents()
 
 	code := <-stop
 
+	if mountPoint != "" {
+		globalfs.Unmount(mountPoint)
+	}
+
+	removeLockFile()
 	l.Okln("Exiting")
 	os.Exit(code)
 }
@@ -633,84 +704,6 @@ func generateEvents() {
 	}
 }
 
-func setupUPnP() {
-	if len(cfg.Options.ListenAddress) == 1 {
-		_, portStr, err := net.SplitHostPort(cfg.Options.ListenAddress[0])
-		if err != nil {
-			l.Warnln("Bad listen address:", err)
-		} else {
-			// Set up incoming port forwarding, if necessary and possible
-			port, _ := strconv.Atoi(portStr)
-			igd, err := upnp.Discover()
-			if err == nil {
-				externalPort = setupExternalPort(igd, port)
-				if externalPort == 0 {
-					l.Warnln("Failed to create UPnP port mapping")
-				} else {
-					l.Infoln("Created UPnP port mapping - external port", externalPort)
-				}
-			} else {
-				l.Infof("No UPnP gateway detected")
-				if debugNet {
-					l.Debugf("UPnP: %v", err)
-				}
-			}
-			if cfg.Options.UPnPRenewal > 0 {
-				go renewUPnP(port)
-			}
-		}
-	} else {
-		l.Warnln("Multiple listening addresses; not attempting UPnP port mapping")
-	}
-}
-
-func setupExternalPort(igd *upnp.IGD, port int) int {
-	// We seed the random number generator with the device ID to get a
-	// repeatable sequence of random external ports.
-	rnd := rand.NewSource(certSeed(cert.Certificate[0]))
-	for i := 0; i < 10; i++ {
-		r := 1024 + int(rnd.Int63()%(65535-1024))
-		err := igd.AddPortMapping(upnp.TCP, r, port, "syncthing", cfg.Options.UPnPLease*60)
-		if err == nil {
-			return r
-		}
-	}
-	return 0
-}
-
-func renewUPnP(port int) {
-	for {
-		time.Sleep(time.Duration(cfg.Options.UPnPRenewal) * time.Minute)
-
-		igd, err := upnp.Discover()
-		if err != nil {
-			continue
-		}
-
-		// Just renew the same port that we already have
-		if externalPort != 0 {
-			err = igd.AddPortMapping(upnp.TCP, externalPort, port, "syncthing", cfg.Options.UPnPLease*60)
-			if err == nil {
-				l.Infoln("Renewed UPnP port mapping - external port", externalPort)
-				continue
-			}
-		}
-
-		// Something strange has happened. We didn't have an external port before?
-		// Or perhaps the gateway has changed?
-		// Retry the same port sequence from the beginning.
-		r := setupExternalPort(igd, port)
-		if r != 0 {
-			externalPort = r
-			l.Infoln("Updated UPnP port mapping - external port", externalPort)
-			discoverer.StopGlobal()
-			discoverer.StartGlobal(cfg.Options.GlobalAnnServer, uint16(r))
-			continue
-		}
-		l.Warnln("Failed to update UPnP port mapping - external port", externalPort)
-	}
-}
-
 func resetFolders() {
 	suffix := fmt.Sprintf(".syncthing-reset-%d", time.Now().UnixNano())
 	for _, folder := range cfg.Folders {
@@ -720,7 +713,7 @@ func resetFolders() {
 		}
 	}
 
-	idx := filepath.Join(confDir, "index")
+	idx := filepath.Join(dataDir, "index")
 	os.RemoveAll(idx)
 }
 
@@ -770,250 +763,6 @@ func shutdown() {
 	stop <- exitSuccess
 }
 
-func listenConnect(myID protocol.DeviceID, m *model.Model, tlsCfg *tls.Config) {
-	var conns = make(chan *tls.Conn)
-
-	// Listen
-	for _, addr := range cfg.Options.ListenAddress {
-		go listenTLS(conns, addr, tlsCfg)
-	}
-
-	// Connect
-	go dialTLS(m, conns, tlsCfg)
-
-next:
-	for conn := range conns {
-		certs := conn.ConnectionState().PeerCertificates
-		if cl := len(certs); cl != 1 {
-			l.Infof("Got peer certificate list of length %d != 1 from %s; protocol error", cl, conn.RemoteAddr())
-			conn.Close()
-			continue
-		}
-		remoteCert := certs[0]
-		remoteID := protocol.NewDeviceID(remoteCert.Raw)
-
-		if remoteID == myID {
-			l.Infof("Connected to myself (%s) - should not happen", remoteID)
-			conn.Close()
-			continue
-		}
-
-		if m.ConnectedTo(remoteID) {
-			l.Infof("Connected to already connected device (%s)", remoteID)
-			conn.Close()
-			continue
-		}
-
-		for _, deviceCfg := range cfg.Devices {
-			if deviceCfg.DeviceID == remoteID {
-				// Verify the name on the certificate. By default we set it to
-				// "syncthing" when generating, but the user may have replaced
-				// the certificate and used another name.
-				certName := deviceCfg.CertName
-				if certName == "" {
-					certName = "syncthing"
-				}
-				err := remoteCert.VerifyHostname(certName)
-				if err != nil {
-					// Incorrect certificate name is something the user most
-					// likely wants to know about, since it's an advanced
-					// config. Warn instead of Info.
-					l.Warnf("Bad certificate from %s (%v): %v", remoteID, conn.RemoteAddr(), err)
-					conn.Close()
-					continue next
-				}
-
-				// If rate limiting is set, we wrap the connection in a
-				// limiter.
-				var wr io.Writer = conn
-				if writeRateLimit != nil {
-					wr = &limitedWriter{conn, writeRateLimit}
-				}
-
-				var rd io.Reader = conn
-				if readRateLimit != nil {
-					rd = &limitedReader{conn, readRateLimit}
-				}
-
-				name := fmt.Sprintf("%s-%s", conn.LocalAddr(), conn.RemoteAddr())
-				protoConn := protocol.NewConnection(remoteID, rd, wr, m, name, deviceCfg.Compression)
-
-				l.Infof("Established secure connection to %s at %s", remoteID, name)
-				if debugNet {
-					l.Debugf("cipher suite %04X", conn.ConnectionState().CipherSuite)
-				}
-				events.Default.Log(events.DeviceConnected, map[string]string{
-					"id":   remoteID.String(),
-					"addr": conn.RemoteAddr().String(),
-				})
-
-				m.AddConnection(conn, protoConn)
-				continue next
-			}
-		}
-
-		events.Default.Log(events.DeviceRejected, map[string]string{
-			"device":  remoteID.String(),
-			"address": conn.RemoteAddr().String(),
-		})
-		l.Infof("Connection from %s with unknown device ID %s; ignoring", conn.RemoteAddr(), remoteID)
-		conn.Close()
-	}
-}
-
-func listenTLS(conns chan *tls.Conn, addr string, tlsCfg *tls.Config) {
-	if debugNet {
-		l.Debugln("listening on", addr)
-	}
-
-	tcaddr, err := net.ResolveTCPAddr("tcp", addr)
-	if err != nil {
-		l.Fatalln("listen (BEP):", err)
-	}
-	listener, err := net.ListenTCP("tcp", tcaddr)
-	if err != nil {
-		l.Fatalln("listen (BEP):", err)
-	}
-
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			l.Warnln("Accepting connection:", err)
-			continue
-		}
-
-		if debugNet {
-			l.Debugln("connect from", conn.RemoteAddr())
-		}
-
-		tcpConn := conn.(*net.TCPConn)
-		setTCPOptions(tcpConn)
-
-		tc := tls.Server(conn, tlsCfg)
-		err = tc.Handshake()
-		if err != nil {
-			l.Infoln("TLS handshake:", err)
-			tc.Close()
-			continue
-		}
-
-		conns <- tc
-	}
-
-}
-
-func dialTLS(m *model.Model, conns chan *tls.Conn, tlsCfg *tls.Config) {
-	var delay time.Duration = 1 * time.Second
-	for {
-	nextDevice:
-		for _, deviceCfg := range cfg.Devices {
-			if deviceCfg.DeviceID == myID {
-				continue
-			}
-
-			if m.ConnectedTo(deviceCfg.DeviceID) {
-				continue
-			}
-
-			var addrs []string
-			for _, addr := range deviceCfg.Addresses {
-				if addr == "dynamic" {
-					if discoverer != nil {
-						t := discoverer.Lookup(deviceCfg.DeviceID)
-						if len(t) == 0 {
-							continue
-						}
-						addrs = append(addrs, t...)
-					}
-				} else {
-					addrs = append(addrs, addr)
-				}
-			}
-
-			for _, addr := range addrs {
-				host, port, err := net.SplitHostPort(addr)
-				if err != nil && strings.HasPrefix(err.Error(), "missing port") {
-					// addr is on the form "1.2.3.4"
-					addr = net.JoinHostPort(addr, "22000")
-				} else if err == nil && port == "" {
-					// addr is on the form "1.2.3.4:"
-					addr = net.JoinHostPort(host, "22000")
-				}
-				if debugNet {
-					l.Debugln("dial", deviceCfg.DeviceID, addr)
-				}
-
-				raddr, err := net.ResolveTCPAddr("tcp", addr)
-				if err != nil {
-					if debugNet {
-						l.Debugln(err)
-					}
-					continue
-				}
-
-				conn, err := net.DialTCP("tcp", nil, raddr)
-				if err != nil {
-					if debugNet {
-						l.Debugln(err)
-					}
-					continue
-				}
-
-				setTCPOptions(conn)
-
-				tc := tls.Client(conn, tlsCfg)
-				err = tc.Handshake()
-				if err != nil {
-					l.Infoln("TLS handshake:", err)
-					tc.Close()
-					continue
-				}
-
-				conns <- tc
-				continue nextDevice
-			}
-		}
-
-		time.Sleep(delay)
-		delay *= 2
-		if maxD := time.Duration(cfg.Options.ReconnectIntervalS) * time.Second; delay > maxD {
-			delay = maxD
-		}
-	}
-}
-
-func setTCPOptions(conn *net.TCPConn) {
-	var err error
-	if err = conn.SetLinger(0); err != nil {
-		l.Infoln(err)
-	}
-	if err = conn.SetNoDelay(false); err != nil {
-		l.Infoln(err)
-	}
-	if err = conn.SetKeepAlivePeriod(60 * time.Second); err != nil {
-		l.Infoln(err)
-	}
-	if err = conn.SetKeepAlive(true); err != nil {
-		l.Infoln(err)
-	}
-}
-
-func discovery(extPort int) *discover.Discoverer {
-	disc := discover.NewDiscoverer(myID, cfg.Options.ListenAddress)
-
-	if cfg.Options.LocalAnnEnabled {
-		l.Infoln("Starting local discovery announcements")
-		disc.StartLocal(cfg.Options.LocalAnnPort, cfg.Options.LocalAnnMCAddr)
-	}
-
-	if cfg.Options.GlobalAnnEnabled {
-		l.Infoln("Starting global discovery announcements")
-		disc.StartGlobal(cfg.Options.GlobalAnnServer, uint16(extPort))
-	}
-
-	return disc
-}
-
 func ensureDir(dir string, mode int) {
 	fi, err := os.Stat(dir)
 	if os.IsNotExist(err) {
@@ -1030,53 +779,19 @@ func ensureDir(dir string, mode int) {
 	}
 }
 
-func getDefaultConfDir() string {
-	switch runtime.GOOS {
-	case "windows":
-		return filepath.Join(os.Getenv("LocalAppData"), "Syncthing")
-
-	case "darwin":
-		return expandTilde("~/Library/Application Support/Syncthing")
-
-	default:
-		if xdgCfg := os.Getenv("XDG_CONFIG_HOME"); xdgCfg != "" {
-			return filepath.Join(xdgCfg, "syncthing")
-		} else {
-			return expandTilde("~/.config/syncthing")
-		}
-	}
-}
-
 func expandTilde(p string) string {
-	if p == "~" {
-		return getHomeDir()
-	}
-
-	p = filepath.FromSlash(p)
-	if !strings.HasPrefix(p, fmt.Sprintf("~%c", os.PathSeparator)) {
-		return p
+	p, err := osutil.ExpandTilde(p)
+	if err != nil {
+		l.Fatalln(err)
 	}
-
-	return filepath.Join(getHomeDir(), p[2:])
+	return p
 }
 
 func getHomeDir() string {
-	var home string
-
-	switch runtime.GOOS {
-	case "windows":
-		home = filepath.Join(os.Getenv("HomeDrive"), os.Getenv("HomePath"))
-		if home == "" {
-			home = os.Getenv("UserProfile")
-		}
-	default:
-		home = os.Getenv("HOME")
-	}
-
-	if home == "" {
-		l.Fatalln("No home directory found - set $HOME (or the platform equivalent).")
+	home, err := osutil.HomeDir()
+	if err != nil {
+		l.Fatalln(err)
 	}
-
 	return home
 }
 
@@ -1131,13 +846,13 @@ func overrideGUIConfig(originalCfg config.GUIConfiguration, address, authenticat
 	if authentication != "" {
 		authenticationParts := strings.SplitN(authentication, ":", 2)
 
-		hash, err := bcrypt.GenerateFromPassword([]byte(authenticationParts[1]), 0)
+		hash, err := config.HashPassword(authenticationParts[1], cfg.PasswordCost)
 		if err != nil {
 			l.Fatalln("Invalid GUI password:", err)
 		}
 
 		cfg.User = authenticationParts[0]
-		cfg.Password = string(hash)
+		cfg.Password = hash
 	}
 
 	if apikey == "" {
@@ -1150,29 +865,51 @@ func overrideGUIConfig(originalCfg config.GUIConfiguration, address, authenticat
 	return cfg
 }
 
-func standbyMonitor() {
-	restartDelay := time.Duration(60 * time.Second)
-	now := time.Now()
-	for {
-		time.Sleep(10 * time.Second)
-		if time.Since(now) > 2*time.Minute {
-			l.Infof("Paused state detected, possibly woke up from standby. Restarting in %v.", restartDelay)
+// setupLogTarget starts sending log messages to the configured syslog or
+// journald target, in addition to standard output.
+func setupLogTarget() {
+	var h logger.MessageHandler
+	var err error
 
-			// We most likely just woke from standby. If we restart
-			// immediately chances are we won't have networking ready. Give
-			// things a moment to stabilize.
-			time.Sleep(restartDelay)
+	switch cfg.Options.LogTarget {
+	case "":
+		return
+	case "syslog":
+		h, err = logger.NewSyslogHandler(cfg.Options.LogSyslogAddress, "syncthing", nil)
+	case "journald":
+		h, err = logger.NewJournaldHandler("syncthing")
+	default:
+		err = fmt.Errorf("unknown log target %q", cfg.Options.LogTarget)
+	}
+	if err != nil {
+		l.Warnln("Log target:", err)
+		return
+	}
 
-			restart()
-			return
-		}
-		now = time.Now()
+	for level := logger.LevelDebug; level < logger.NumLevels; level++ {
+		l.AddHandler(level, h)
+	}
+}
+
+// reportHealthy tells the monitor process that we have started successfully
+// after an upgrade; the configuration and database have been loaded and the
+// listeners bound by now.
+func reportHealthy(healthFile string) {
+	err := ioutil.WriteFile(healthFile, []byte(Version+"\n"), 0600)
+	if err != nil {
+		l.Warnln("Reporting startup to monitor:", err)
 	}
 }
 
 func autoUpgrade() {
 	var skipped bool
+	var notified string
 	interval := time.Duration(cfg.Options.AutoUpgradeIntervalH) * time.Hour
+	window, err := upgrade.ParseWindow(cfg.Options.UpgradeWindowStart, cfg.Options.UpgradeWindowEnd, cfg.Options.UpgradeWindowDays)
+	if err != nil {
+		l.Warnln("Automatic upgrade: bad upgrade window:", err)
+		return
+	}
 	for {
 		if skipped {
 			time.Sleep(interval)
@@ -1192,14 +929,54 @@ func autoUpgrade() {
 			continue
 		}
 
+		if cfg.Options.UpgradeNotifyOnly {
+			// Announce each new release once and leave it to the user to
+			// upgrade via the REST interface.
+			if rel.Tag != notified {
+				l.Infof("Upgrade available (current %q < latest %q)", Version, rel.Tag)
+				events.Default.Log(events.UpgradeAvailable, map[string]interface{}{
+					"running": Version,
+					"latest":  rel.Tag,
+					"notes":   rel.Notes,
+					"url":     rel.URL,
+				})
+				notified = rel.Tag
+			}
+			continue
+		}
+
+		if !window.Contains(time.Now()) {
+			// The upgrade and the restart that follows must both happen
+			// within the window, so wait for it to open and then check
+			// again in case the release has changed in the meantime.
+			next := window.Next(time.Now())
+			if next.IsZero() {
+				l.Warnln("Automatic upgrade: upgrade window never opens; not upgrading")
+				return
+			}
+			l.Infof("Automatic upgrade to %q postponed until %v", rel.Tag, next.Format("2006-01-02 15:04"))
+			time.Sleep(next.Sub(time.Now()))
+			skipped = false
+			continue
+		}
+
 		l.Infof("Automatic upgrade (current %q < latest %q)", Version, rel.Tag)
 		err = upgrade.UpgradeTo(rel, GoArchExtra)
 		if err != nil {
 			l.Warnln("Automatic upgrade:", err)
 			continue
 		}
-		l.Warnf("Automatically upgraded to version %q. Restarting in 1 minute.", rel.Tag)
-		time.Sleep(time.Minute)
+		// The upgrade may have taken long enough for the window to be about
+		// to close, or to have closed already.
+		now := time.Now()
+		restart := window.Clamp(now, now.Add(time.Minute))
+		if restart.IsZero() {
+			l.Warnf("Automatically upgraded to version %q; upgrade window never opens, not restarting.", rel.Tag)
+			return
+		}
+		l.Warnf("Automatically upgraded to version %q. Restarting at %v.", rel.Tag, restart.Format("2006-01-02 15:04:05"))
+		upgrade.RestartPending(restart.Sub(now))
+		time.Sleep(restart.Sub(now))
 		stop <- exitUpgrading
 		return
 	}
@@ -6,6 +6,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 	"os"
 	"os/exec"
@@ -15,6 +16,8 @@ import (
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/calmh/osext"
 )
 
 var (
@@ -26,6 +29,10 @@ var (
 const (
 	countRestarts = 5
 	loopThreshold = 15 * time.Second
+
+	// The time a freshly upgraded binary gets to report a successful
+	// startup before the upgrade is reverted.
+	upgradeCheckTimeout = 2 * time.Minute
 )
 
 func monitorMain() {
@@ -35,6 +42,12 @@ func monitorMain() {
 	args := os.Args
 	var restarts [countRestarts]time.Time
 
+	// We were restarted after an upgrade; the new binary must prove that it
+	// is able to start before we let it keep running.
+	upgradeCheck := os.Getenv("STUPGRADED") != ""
+	os.Setenv("STUPGRADED", "")
+	healthFile := filepath.Join(dataDir, "upgrade-check")
+
 	sign := make(chan os.Signal, 1)
 	sigTerm := syscall.Signal(0xf)
 	signal.Notify(sign, os.Interrupt, sigTerm, os.Kill)
@@ -48,6 +61,11 @@ func monitorMain() {
 		copy(restarts[0:], restarts[1:])
 		restarts[len(restarts)-1] = time.Now()
 
+		if upgradeCheck {
+			os.Remove(healthFile)
+			os.Setenv("STHEALTHFILE", healthFile)
+		}
+
 		cmd := exec.Command(args[0], args[1:]...)
 
 		stderr, err := cmd.StderrPipe()
@@ -80,32 +98,67 @@ func monitorMain() {
 			exit <- cmd.Wait()
 		}()
 
-		select {
-		case s := <-sign:
-			l.Infof("Signal %d received; exiting", s)
-			cmd.Process.Kill()
-			<-exit
-			return
+		var healthy <-chan bool
+		if upgradeCheck {
+			healthy = awaitHealthy(healthFile, upgradeCheckTimeout)
+		}
+
+	wait:
+		for {
+			select {
+			case s := <-sign:
+				l.Infof("Signal %d received; exiting", s)
+				cmd.Process.Kill()
+				<-exit
+				return
 
-		case err = <-exit:
-			if err == nil {
-				// Successfull exit indicates an intentional shutdown
+			case ok := <-healthy:
+				healthy = nil
+				upgradeCheck = false
+				os.Setenv("STHEALTHFILE", "")
+				os.Remove(healthFile)
+				if ok {
+					l.Okln("Upgraded version started successfully")
+					continue
+				}
+
+				l.Warnf("Upgraded version did not start within %v; reverting", upgradeCheckTimeout)
+				cmd.Process.Kill()
+				<-exit
+				revertUpgrade(args)
 				return
-			} else if exiterr, ok := err.(*exec.ExitError); ok {
-				if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-					switch status.ExitStatus() {
-					case exitUpgrading:
-						// Restart the monitor process to release the .old
-						// binary as part of the upgrade process.
-						l.Infoln("Restarting monitor...")
-						os.Setenv("STNORESTART", "")
-						err := exec.Command(args[0], args[1:]...).Start()
-						if err != nil {
-							l.Warnln("restart:", err)
+
+			case err = <-exit:
+				if err == nil {
+					// Successfull exit indicates an intentional shutdown
+					return
+				}
+
+				if upgradeCheck {
+					l.Warnln("Upgraded version failed to start:", err)
+					os.Remove(healthFile)
+					revertUpgrade(args)
+					return
+				}
+
+				if exiterr, ok := err.(*exec.ExitError); ok {
+					if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+						switch status.ExitStatus() {
+						case exitUpgrading:
+							// Restart the monitor process to release the .old
+							// binary as part of the upgrade process.
+							l.Infoln("Restarting monitor...")
+							os.Setenv("STNORESTART", "")
+							os.Setenv("STUPGRADED", "yes")
+							err := exec.Command(args[0], args[1:]...).Start()
+							if err != nil {
+								l.Warnln("restart:", err)
+							}
+							return
 						}
-						return
 					}
 				}
+				break wait
 			}
 		}
 
@@ -118,13 +171,75 @@ func monitorMain() {
 	}
 }
 
+// awaitHealthy returns a channel that receives true once the child process
+// has created the health file, or false if it has not done so within the
+// timeout.
+func awaitHealthy(healthFile string, timeout time.Duration) <-chan bool {
+	res := make(chan bool, 1)
+	go func() {
+		t0 := time.Now()
+		for time.Since(t0) < timeout {
+			if _, err := os.Stat(healthFile); err == nil {
+				res <- true
+				return
+			}
+			time.Sleep(time.Second)
+		}
+		res <- false
+	}()
+	return res
+}
+
+// revertUpgrade puts the previous binary back in place, keeping the failed
+// one with a ".failed" extension, and restarts the monitor using it.
+func revertUpgrade(args []string) {
+	path, err := osext.Executable()
+	if err != nil {
+		l.Warnln("Revert upgrade:", err)
+		os.Exit(exitError)
+	}
+
+	old := path + ".old"
+	if _, err := os.Stat(old); err != nil {
+		l.Warnln("Revert upgrade: no previous version:", err)
+		os.Exit(exitError)
+	}
+
+	failed := path + ".failed"
+	os.Remove(failed)
+	if err := os.Rename(path, failed); err != nil {
+		l.Warnln("Revert upgrade:", err)
+		os.Exit(exitError)
+	}
+	if err := os.Rename(old, path); err != nil {
+		l.Warnln("Revert upgrade:", err)
+		os.Rename(failed, path)
+		os.Exit(exitError)
+	}
+
+	l.Infoln("Reverted to previous version; restarting monitor...")
+	os.Setenv("STNORESTART", "")
+	os.Setenv("STHEALTHFILE", "")
+	if err := exec.Command(args[0], args[1:]...).Start(); err != nil {
+		l.Warnln("restart:", err)
+	}
+}
+
 func copyStderr(stderr io.ReadCloser) {
 	br := bufio.NewReader(stderr)
 
 	var panicFd *os.File
+	var panicking bool
+	var panicBuf bytes.Buffer
+	var panicTime time.Time
+	var logTail []string
 	for {
 		line, err := br.ReadString('\n')
 		if err != nil {
+			if panicking {
+				// The process has exited and we have all of the panic output
+				writeCrashReport(panicTime, panicBuf.String(), logTail)
+			}
 			return
 		}
 
@@ -132,7 +247,13 @@ func copyStderr(stderr io.ReadCloser) {
 			os.Stderr.WriteString(line)
 
 			if strings.HasPrefix(line, "panic:") || strings.HasPrefix(line, "fatal error:") {
-				panicFd, err = os.Create(filepath.Join(confDir, time.Now().Format("panic-20060102-150405.log")))
+				panicking = true
+				panicTime = time.Now()
+				stdoutMut.Lock()
+				logTail = append([]string(nil), stdoutLastLines...)
+				stdoutMut.Unlock()
+
+				panicFd, err = os.Create(filepath.Join(dataDir, time.Now().Format("panic-20060102-150405.log")))
 				if err != nil {
 					l.Warnln("Create panic log:", err)
 					continue
@@ -156,6 +277,9 @@ func copyStderr(stderr io.ReadCloser) {
 		if panicFd != nil {
 			panicFd.WriteString(line)
 		}
+		if panicking && panicBuf.Len() < 1<<20 {
+			panicBuf.WriteString(line)
+		}
 	}
 }
 
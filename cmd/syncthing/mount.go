@@ -0,0 +1,35 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/syncthing/syncthing/internal/globalfs"
+	"github.com/syncthing/syncthing/internal/model"
+)
+
+// mountFolder mounts the global tree of a folder, given as "folder:dir", so
+// that it can be browsed without being synced. Pairing it with a read only
+// folder with an empty directory means nothing is pulled. It returns the
+// mount point so it can be unmounted when exiting.
+func mountFolder(m *model.Model, spec string) string {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		l.Fatalf("Cannot mount %q: expected folder:directory", spec)
+	}
+	folder, dir := parts[0], expandTilde(parts[1])
+	if _, ok := cfg.FolderMap()[folder]; !ok {
+		l.Fatalf("Cannot mount %q: no such folder", folder)
+	}
+
+	go func() {
+		l.Infof("Mounting folder %q at %s", folder, dir)
+		if err := globalfs.Mount(globalfs.New(folder, m), dir); err != nil {
+			l.Warnf("Mounting folder %q: %v", folder, err)
+		}
+	}()
+	return dir
+}
@@ -13,7 +13,7 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
 func init() {
@@ -0,0 +1,168 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/model"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// A certificate rotation is prepared by generating the next certificate as
+// "next-cert.pem" and "next-key.pem", and a succession file with the proof
+// that the new device ID is our successor. Until the switch time we keep
+// using the current certificate, announcing the successor to all devices
+// we connect to so that they can add it to their configuration. At the
+// switch time we restart using the new certificate.
+
+const (
+	successionFile = "succession.json"
+	nextCertPrefix = "next-"
+	prevCertPrefix = "previous-"
+)
+
+type succession struct {
+	Successor protocol.DeviceID `json:"successor"`
+	Signature []byte            `json:"signature"`
+	Switch    time.Time         `json:"switch"`
+}
+
+func loadSuccession() (succession, bool) {
+	var s succession
+	bs, err := ioutil.ReadFile(filepath.Join(confDir, successionFile))
+	if err != nil {
+		return s, false
+	}
+	if err := json.Unmarshal(bs, &s); err != nil {
+		l.Warnln("Certificate rotation:", err)
+		return s, false
+	}
+	return s, true
+}
+
+// rotateCertificate generates the next certificate and prepares for
+// switching to it.
+func rotateCertificate(keyType string) error {
+	if _, ok := loadSuccession(); ok {
+		return fmt.Errorf("a certificate rotation is already in progress")
+	}
+
+	cert, err := loadCert(confDir, "")
+	if err != nil {
+		return err
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported private key type %T", cert.PrivateKey)
+	}
+
+	days := 14
+	id := protocol.NewDeviceID(cert.Certificate[0])
+	if cfg, err := config.Load(filepath.Join(confDir, "config.xml"), id); err == nil {
+		days = cfg.Options.CertRotationDays
+	}
+
+	newCertificate(confDir, nextCertPrefix, keyType)
+	next, err := loadCert(confDir, nextCertPrefix)
+	if err != nil {
+		return err
+	}
+
+	s := succession{
+		Successor: protocol.NewDeviceID(next.Certificate[0]),
+		Switch:    time.Now().Add(time.Duration(days) * 24 * time.Hour),
+	}
+	s.Signature, err = protocol.SignSuccessor(signer, s.Successor)
+	if err != nil {
+		return err
+	}
+
+	bs, err := json.MarshalIndent(&s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(confDir, successionFile), bs, 0600); err != nil {
+		return err
+	}
+
+	l.Infoln("Current device ID:", id)
+	l.Infoln("Next device ID:", s.Successor)
+	l.Infoln("Switching to the next device ID at", s.Switch.Format(time.RFC1123))
+	return nil
+}
+
+// switchCertificate moves the next certificate into place if the switch
+// time has passed. It returns the previous device ID if so.
+func switchCertificate() (protocol.DeviceID, bool) {
+	s, ok := loadSuccession()
+	if !ok || time.Now().Before(s.Switch) {
+		return protocol.DeviceID{}, false
+	}
+
+	cur, err := loadCert(confDir, "")
+	if err != nil {
+		return protocol.DeviceID{}, false
+	}
+
+	for _, name := range []string{"cert.pem", "key.pem"} {
+		os.Rename(filepath.Join(confDir, name), filepath.Join(confDir, prevCertPrefix+name))
+		if err := os.Rename(filepath.Join(confDir, nextCertPrefix+name), filepath.Join(confDir, name)); err != nil {
+			l.Fatalln("Certificate rotation:", err)
+		}
+	}
+	os.Remove(filepath.Join(confDir, successionFile))
+
+	prev := protocol.NewDeviceID(cur.Certificate[0])
+	l.Infof("Switched from device ID %v to %v", prev, s.Successor)
+	return prev, true
+}
+
+// configSignerPublic returns the public key the config was last signed with;
+// the previous one if we just switched certificates.
+func configSignerPublic(switched bool) crypto.PublicKey {
+	if switched {
+		if prev, err := loadCert(confDir, prevCertPrefix); err == nil {
+			if signer, ok := prev.PrivateKey.(crypto.Signer); ok {
+				return signer.Public()
+			}
+		}
+	}
+	return configSigner.Public()
+}
+
+// forgetPredecessor removes our previous device ID from the configuration,
+// keeping the name we had.
+func forgetPredecessor(cfg *config.Configuration, prev protocol.DeviceID) {
+	if prevCfg := cfg.GetDeviceConfiguration(prev); prevCfg != nil {
+		if me := cfg.GetDeviceConfiguration(myID); me != nil && prevCfg.Name != "" {
+			me.Name = prevCfg.Name
+		}
+	}
+	cfg.RemoveDevice(prev)
+	cfg.Save()
+}
+
+// announceSuccessor makes the model announce the pending certificate
+// rotation, and restarts us at the switch time.
+func announceSuccessor(m *model.Model) {
+	s, ok := loadSuccession()
+	if !ok {
+		return
+	}
+	l.Infoln("Announcing successor device ID", s.Successor)
+	m.SetSuccessor(s.Successor, s.Signature)
+	time.AfterFunc(s.Switch.Sub(time.Now()), func() {
+		l.Infoln("Switching to the next certificate")
+		restart()
+	})
+}
@@ -6,14 +6,16 @@ package main
 
 import (
 	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/binary"
 	"encoding/pem"
+	"fmt"
 	"io"
 	"math/big"
 	mr "math/rand"
@@ -28,27 +30,74 @@ const (
 	tlsName    = "syncthing"
 )
 
+// The supported key types for newly generated certificates. ECDSA keys are
+// much cheaper than RSA keys in the TLS handshake, which matters on slow
+// devices. Peers with either kind of certificate can connect to each other.
+const (
+	KeyTypeRSA       = "rsa"
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+)
+
+func validKeyType(keyType string) bool {
+	switch keyType {
+	case KeyTypeRSA, KeyTypeECDSAP256, KeyTypeECDSAP384:
+		return true
+	}
+	return false
+}
+
 func loadCert(dir string, prefix string) (tls.Certificate, error) {
 	cf := filepath.Join(dir, prefix+"cert.pem")
 	kf := filepath.Join(dir, prefix+"key.pem")
 	return tls.LoadX509KeyPair(cf, kf)
 }
 
-func certSeed(bs []byte) int64 {
-	hf := sha256.New()
-	hf.Write(bs)
-	id := hf.Sum(nil)
-	return int64(binary.BigEndian.Uint64(id))
+// generateKey returns a new private key of the given type, its public key,
+// and the PEM block the private key should be stored as.
+func generateKey(keyType string) (crypto.PrivateKey, crypto.PublicKey, *pem.Block, error) {
+	switch keyType {
+	case KeyTypeRSA:
+		priv, err := rsa.GenerateKey(rand.Reader, tlsRSABits)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}
+		return priv, &priv.PublicKey, block, nil
+
+	case KeyTypeECDSAP256, KeyTypeECDSAP384:
+		curve := elliptic.P256()
+		if keyType == KeyTypeECDSAP384 {
+			curve = elliptic.P384()
+		}
+		priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		bs, err := x509.MarshalECPrivateKey(priv)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: bs}
+		return priv, &priv.PublicKey, block, nil
+	}
+
+	return nil, nil, nil, fmt.Errorf("unsupported key type %q", keyType)
 }
 
-func newCertificate(dir string, prefix string) {
-	l.Infoln("Generating RSA key and certificate...")
+func newCertificate(dir string, prefix string, keyType string) {
+	l.Infof("Generating %s key and certificate...", keyType)
 
-	priv, err := rsa.GenerateKey(rand.Reader, tlsRSABits)
+	priv, pub, keyBlock, err := generateKey(keyType)
 	if err != nil {
 		l.Fatalln("generate key:", err)
 	}
 
+	keyUsage := x509.KeyUsageDigitalSignature
+	if keyType == KeyTypeRSA {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
 	notBefore := time.Now()
 	notAfter := time.Date(2049, 12, 31, 23, 59, 59, 0, time.UTC)
 
@@ -57,15 +106,16 @@ func newCertificate(dir string, prefix string) {
 		Subject: pkix.Name{
 			CommonName: tlsName,
 		},
+		DNSNames:  []string{tlsName},
 		NotBefore: notBefore,
 		NotAfter:  notAfter,
 
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		KeyUsage:              keyUsage,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
 	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, pub, priv)
 	if err != nil {
 		l.Fatalln("create cert:", err)
 	}
@@ -87,7 +137,7 @@ func newCertificate(dir string, prefix string) {
 	if err != nil {
 		l.Fatalln("save key:", err)
 	}
-	err = pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	err = pem.Encode(keyOut, keyBlock)
 	if err != nil {
 		l.Fatalln("save key:", err)
 	}
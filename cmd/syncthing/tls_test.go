@@ -0,0 +1,81 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestNewCertificateKeyTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing-tls-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		keyType string
+		curve   elliptic.Curve // nil for RSA
+	}{
+		{KeyTypeRSA, nil},
+		{KeyTypeECDSAP256, elliptic.P256()},
+		{KeyTypeECDSAP384, elliptic.P384()},
+	}
+
+	for _, tc := range cases {
+		newCertificate(dir, tc.keyType+"-", tc.keyType)
+
+		cert, err := loadCert(dir, tc.keyType+"-")
+		if err != nil {
+			t.Errorf("%s: %v", tc.keyType, err)
+			continue
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Errorf("%s: %v", tc.keyType, err)
+			continue
+		}
+		if leaf.Subject.CommonName != tlsName {
+			t.Errorf("%s: unexpected common name %q", tc.keyType, leaf.Subject.CommonName)
+		}
+
+		switch pub := leaf.PublicKey.(type) {
+		case *rsa.PublicKey:
+			if tc.curve != nil {
+				t.Errorf("%s: got an RSA key", tc.keyType)
+			} else if pub.N.BitLen() != tlsRSABits {
+				t.Errorf("%s: key is %d bits, expected %d", tc.keyType, pub.N.BitLen(), tlsRSABits)
+			}
+			if _, ok := cert.PrivateKey.(*rsa.PrivateKey); !ok {
+				t.Errorf("%s: private key is %T", tc.keyType, cert.PrivateKey)
+			}
+		case *ecdsa.PublicKey:
+			if tc.curve == nil {
+				t.Errorf("%s: got an ECDSA key", tc.keyType)
+			} else if pub.Curve != tc.curve {
+				t.Errorf("%s: key uses curve %s, expected %s", tc.keyType, pub.Curve.Params().Name, tc.curve.Params().Name)
+			}
+			if _, ok := cert.PrivateKey.(*ecdsa.PrivateKey); !ok {
+				t.Errorf("%s: private key is %T", tc.keyType, cert.PrivateKey)
+			}
+		default:
+			t.Errorf("%s: unexpected public key type %T", tc.keyType, pub)
+		}
+	}
+}
+
+func TestGenerateKeyUnknownType(t *testing.T) {
+	for _, keyType := range []string{"", "dsa", "ecdsa", "ecdsa-p521", "RSA"} {
+		if _, _, _, err := generateKey(keyType); err == nil {
+			t.Errorf("unexpected success generating a %q key", keyType)
+		}
+	}
+}
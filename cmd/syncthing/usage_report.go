@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/syncthing/syncthing/internal/model"
@@ -23,13 +24,48 @@ import (
 // are prompted for acceptance of the new report.
 const usageReportVersion = 1
 
+// The granularities of the usage report, set by the urGranularity option.
+// The version report holds only the unique ID and version; any other value
+// means the full report.
+const (
+	urGranularityFull    = "full"
+	urGranularityVersion = "version"
+)
+
 var stopUsageReportingCh = make(chan struct{})
 
-func reportData(m *model.Model) map[string]interface{} {
+// The system properties in the report are measured once, so that the same
+// values are reported every time
+var (
+	systemOnce sync.Once
+	sha256Perf float64
+	memSizeMiB uint64
+	memSizeErr error
+)
+
+func measureSystem() {
+	for i := 0; i < 5; i++ {
+		p := cpuBench()
+		if p > sha256Perf {
+			sha256Perf = p
+		}
+	}
+	bytes, err := memorySize()
+	memSizeMiB, memSizeErr = bytes/1024/1024, err
+}
+
+// reportData returns the usage report at the given granularity. It depends
+// only on the configuration and the state of the model, apart from the
+// memory in use.
+func reportData(m *model.Model, granularity string) map[string]interface{} {
 	res := make(map[string]interface{})
 	res["uniqueID"] = strings.ToLower(myID.String()[:6])
 	res["version"] = Version
 	res["longVersion"] = LongVersion
+	if granularity == urGranularityVersion {
+		return res
+	}
+
 	res["platform"] = runtime.GOOS + "-" + runtime.GOARCH
 	res["numFolders"] = len(cfg.Folders)
 	res["numDevices"] = len(cfg.Devices)
@@ -57,27 +93,24 @@ func reportData(m *model.Model) map[string]interface{} {
 	runtime.ReadMemStats(&mem)
 	res["memoryUsageMiB"] = (mem.Sys - mem.HeapReleased) / 1024 / 1024
 
-	var perf float64
-	for i := 0; i < 5; i++ {
-		p := cpuBench()
-		if p > perf {
-			perf = p
-		}
-	}
-	res["sha256Perf"] = perf
-
-	bytes, err := memorySize()
-	if err == nil {
-		res["memorySize"] = bytes / 1024 / 1024
+	systemOnce.Do(measureSystem)
+	res["sha256Perf"] = sha256Perf
+	if memSizeErr == nil {
+		res["memorySize"] = memSizeMiB
 	}
 
 	return res
 }
 
-func sendUsageReport(m *model.Model) error {
-	d := reportData(m)
+// usageReport returns the usage report exactly as it is sent.
+func usageReport(m *model.Model, granularity string) []byte {
 	var b bytes.Buffer
-	json.NewEncoder(&b).Encode(d)
+	json.NewEncoder(&b).Encode(reportData(m, granularity))
+	return b.Bytes()
+}
+
+func sendUsageReport(m *model.Model, granularity string) error {
+	b := bytes.NewReader(usageReport(m, granularity))
 
 	var client = http.DefaultClient
 	if BuildEnv == "android" {
@@ -89,7 +122,7 @@ func sendUsageReport(m *model.Model) error {
 		}
 		client = &http.Client{Transport: tr}
 	}
-	_, err := client.Post("https://data.syncthing.net/newdata", "application/json", &b)
+	_, err := client.Post("https://data.syncthing.net/newdata", "application/json", b)
 	return err
 }
 
@@ -102,7 +135,7 @@ loop:
 		case <-stopUsageReportingCh:
 			break loop
 		case <-t.C:
-			err := sendUsageReport(m)
+			err := sendUsageReport(m, cfg.Options.URGranularity)
 			if err != nil {
 				l.Infoln("Usage report:", err)
 			}
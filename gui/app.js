@@ -80,6 +80,7 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
     $scope.config = {};
     $scope.configInSync = true;
     $scope.connections = {};
+    $scope.remotePaused = {};
     $scope.errors = [];
     $scope.model = {};
     $scope.myID = '';
@@ -203,10 +204,14 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
 
     $scope.$on('DeviceDisconnected', function (event, arg) {
         delete $scope.connections[arg.data.id];
+        if (arg.data.error === 'paused') {
+            $scope.remotePaused[arg.data.id] = true;
+        }
         refreshDeviceStats();
     });
 
     $scope.$on('DeviceConnected', function (event, arg) {
+        delete $scope.remotePaused[arg.data.id];
         if (!$scope.connections[arg.data.id]) {
             $scope.connections[arg.data.id] = {
                 inbps: 0,
@@ -353,6 +358,9 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
             $scope.connections = data;
             console.log("refreshConnections", data);
         });
+        $http.get(urlbase + '/connections/paused').success(function (data) {
+            $scope.remotePaused = data;
+        });
     }
 
     function refreshErrors() {
@@ -442,6 +450,12 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
         if (state == 'scanning') {
             return 'primary';
         }
+        if (state == 'paused') {
+            return 'default';
+        }
+        if (state == 'outOfSync') {
+            return 'warning';
+        }
         return 'info';
     };
 
@@ -470,6 +484,9 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
     };
 
     $scope.deviceClass = function (deviceCfg) {
+        if (deviceCfg.Paused) {
+            return 'default';
+        }
         if ($scope.connections[deviceCfg.DeviceID]) {
             if ($scope.completion[deviceCfg.DeviceID] && $scope.completion[deviceCfg.DeviceID]._total === 100) {
                 return 'success';
@@ -645,9 +662,10 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
     };
 
     $scope.addDevice = function () {
+        var defaults = $scope.config.Defaults;
         $scope.currentDevice = {
             AddressesStr: 'dynamic',
-            Compression: true,
+            Compression: defaults && defaults.Device.Compression !== null ? defaults.Device.Compression : true,
             Introducer: true
         };
         $scope.editingExisting = false;
@@ -721,6 +739,17 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
         }
     };
 
+    $scope.relayJoined = function () {
+        var i;
+
+        for (i = 0; i < $scope.system.relays.length; i++) {
+            if ($scope.system.relays[i].Joined) {
+                return true;
+            }
+        }
+        return false;
+    };
+
     $scope.allDevices = function () {
         var devices = $scope.otherDevices();
         devices.push($scope.thisDevice());
@@ -753,9 +782,12 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
     $scope.editFolder = function (deviceCfg) {
         $scope.currentFolder = angular.copy(deviceCfg);
         $scope.currentFolder.selectedDevices = {};
+        $scope.currentFolder.encryptionPasswords = {};
         $scope.currentFolder.Devices.forEach(function (n) {
             $scope.currentFolder.selectedDevices[n.DeviceID] = true;
+            $scope.currentFolder.encryptionPasswords[n.DeviceID] = n.EncryptionPassword || "";
         });
+        $scope.currentFolder.receiveEncrypted = $scope.currentFolder.Type === 'receiveencrypted';
         if ($scope.currentFolder.Versioning && $scope.currentFolder.Versioning.Type === "simple") {
             $scope.currentFolder.simpleFileVersioning = true;
             $scope.currentFolder.FileVersioningSelector = "simple";
@@ -766,12 +798,17 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
             $scope.currentFolder.staggeredMaxAge = Math.floor(+$scope.currentFolder.Versioning.Params.maxAge / 86400);
             $scope.currentFolder.staggeredCleanInterval = +$scope.currentFolder.Versioning.Params.cleanInterval;
             $scope.currentFolder.staggeredVersionsPath = $scope.currentFolder.Versioning.Params.versionsPath;
+        } else if ($scope.currentFolder.Versioning && $scope.currentFolder.Versioning.Type === "trashcan") {
+            $scope.currentFolder.trashcanFileVersioning = true;
+            $scope.currentFolder.FileVersioningSelector = "trashcan";
+            $scope.currentFolder.trashcanClean = +$scope.currentFolder.Versioning.Params.cleanoutDays;
         } else {
             $scope.currentFolder.FileVersioningSelector = "none";
         }
         $scope.currentFolder.simpleKeep = $scope.currentFolder.simpleKeep || 5;
         $scope.currentFolder.staggeredCleanInterval = $scope.currentFolder.staggeredCleanInterval || 3600;
         $scope.currentFolder.staggeredVersionsPath = $scope.currentFolder.staggeredVersionsPath || "";
+        $scope.currentFolder.trashcanClean = $scope.currentFolder.trashcanClean || 0;
 
         // staggeredMaxAge can validly be zero, which we should not replace
         // with the default value of 365. So only set the default if it's
@@ -787,14 +824,17 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
 
     $scope.addFolder = function () {
         $scope.currentFolder = {
-            selectedDevices: {}
+            selectedDevices: {},
+            encryptionPasswords: {}
         };
-        $scope.currentFolder.RescanIntervalS = 60;
+        var defaults = $scope.config.Defaults;
+        $scope.currentFolder.RescanIntervalS = defaults && defaults.Folder.RescanIntervalS > 0 ? defaults.Folder.RescanIntervalS : 60;
         $scope.currentFolder.FileVersioningSelector = "none";
         $scope.currentFolder.simpleKeep = 5;
         $scope.currentFolder.staggeredMaxAge = 365;
         $scope.currentFolder.staggeredCleanInterval = 3600;
         $scope.currentFolder.staggeredVersionsPath = "";
+        $scope.currentFolder.trashcanClean = 0;
         $scope.editingExisting = false;
         $scope.folderEditor.$setPristine();
         $('#editFolder').modal();
@@ -805,16 +845,35 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
 
         $('#editFolder').modal('hide');
         folderCfg = $scope.currentFolder;
+        // Devices still selected keep their attributes, such as the group
+        // or introducer they were shared with on account of
+        var previousDevices = {};
+        (folderCfg.Devices || []).forEach(function (n) {
+            previousDevices[n.DeviceID] = n;
+        });
         folderCfg.Devices = [];
         folderCfg.selectedDevices[$scope.myID] = true;
         for (var deviceID in folderCfg.selectedDevices) {
             if (folderCfg.selectedDevices[deviceID] === true) {
-                folderCfg.Devices.push({
+                folderCfg.Devices.push(previousDevices[deviceID] || {
                     DeviceID: deviceID
                 });
             }
         }
         delete folderCfg.selectedDevices;
+        // Devices given a password are untrusted, and get the folder
+        // encrypted; there is nothing to encrypt in an encrypted folder
+        folderCfg.Devices.forEach(function (n) {
+            n.EncryptionPassword = folderCfg.receiveEncrypted ? "" : folderCfg.encryptionPasswords[n.DeviceID] || "";
+        });
+        delete folderCfg.encryptionPasswords;
+        if (folderCfg.receiveEncrypted) {
+            folderCfg.Type = 'receiveencrypted';
+            folderCfg.ReadOnly = false;
+        } else {
+            folderCfg.Type = folderCfg.ReadOnly ? 'sendonly' : 'sendreceive';
+        }
+        delete folderCfg.receiveEncrypted;
 
         if (folderCfg.FileVersioningSelector === "simple") {
             folderCfg.Versioning = {
@@ -839,6 +898,15 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
             delete folderCfg.staggeredCleanInterval;
             delete folderCfg.staggeredVersionsPath;
 
+        } else if (folderCfg.FileVersioningSelector === "trashcan") {
+            folderCfg.Versioning = {
+                'Type': 'trashcan',
+                'Params': {
+                    'cleanoutDays': '' + folderCfg.trashcanClean,
+                }
+            };
+            delete folderCfg.trashcanFileVersioning;
+            delete folderCfg.trashcanClean;
         } else {
             delete folderCfg.Versioning;
         }
@@ -909,7 +977,17 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
     };
 
     $scope.setAPIKey = function (cfg) {
-        cfg.APIKey = randomString(30, 32);
+        // The config holds only the hash of the key, so this is the one
+        // time it can be shown. Posting it back in the config stores the
+        // same hash again.
+        $http.post(urlbase + '/system/apikey').success(function (data) {
+            cfg.APIKey = data.apiKey;
+            $scope.config.GUI.APIKey = data.apiKey;
+        });
+    };
+
+    $scope.isHashedSecret = function (secret) {
+        return secret.indexOf('sha256:') === 0;
     };
 
     $scope.showURPreview = function () {
@@ -956,7 +1034,7 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
     };
 
     $scope.override = function (folder) {
-        $http.post(urlbase + "/model/override?folder=" + encodeURIComponent(folder));
+        $http.post(urlbase + "/db/revert?folder=" + encodeURIComponent(folder));
     };
 
     $scope.about = function () {
@@ -967,10 +1045,26 @@ syncthing.controller('SyncthingCtrl', function ($scope, $http, $translate, $loca
         $scope.reportPreview = true;
     };
 
+    $scope.pauseDevice = function (deviceCfg) {
+        $http.post(urlbase + "/system/pause?device=" + encodeURIComponent(deviceCfg.DeviceID));
+    };
+
+    $scope.resumeDevice = function (deviceCfg) {
+        $http.post(urlbase + "/system/resume?device=" + encodeURIComponent(deviceCfg.DeviceID));
+    };
+
     $scope.rescanFolder = function (folder) {
         $http.post(urlbase + "/scan?folder=" + encodeURIComponent(folder));
     };
 
+    $scope.pauseFolder = function (folder) {
+        $http.post(urlbase + "/folder/pause?folder=" + encodeURIComponent(folder));
+    };
+
+    $scope.resumeFolder = function (folder) {
+        $http.post(urlbase + "/folder/resume?folder=" + encodeURIComponent(folder));
+    };
+
     $scope.init();
     setInterval($scope.refresh, 10000);
 });
@@ -1023,17 +1117,6 @@ function decimals(val, num) {
     return decs;
 }
 
-function randomString(len, bits) {
-    bits = bits || 36;
-    var outStr = "",
-        newStr;
-    while (outStr.length < len) {
-        newStr = Math.random().toString(bits).slice(2);
-        outStr += newStr.slice(0, Math.min(newStr.length, (len - outStr.length)));
-    }
-    return outStr.toLowerCase();
-}
-
 function isEmptyObject(obj) {
     var name;
     for (name in obj) {
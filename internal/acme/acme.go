@@ -0,0 +1,339 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package acme implements the parts of the ACME protocol (RFC 8555) needed
+// to obtain certificates from a certificate authority such as Let's Encrypt.
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+var (
+	ErrNoChallenge = errors.New("no supported challenge offered")
+	ErrTimeout     = errors.New("timeout waiting for the certificate authority")
+)
+
+// How often and how many times to poll for authorization and order status
+var (
+	pollInterval = 2 * time.Second
+	pollAttempts = 60
+)
+
+// A Problem is an error returned by the ACME server (RFC 7807).
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p Problem) Error() string {
+	return fmt.Sprintf("acme: %s: %s", p.Type, p.Detail)
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string       `json:"status"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *Problem     `json:"error"`
+}
+
+type authorization struct {
+	Status     string      `json:"status"`
+	Identifier identifier  `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Token  string   `json:"token"`
+	Status string   `json:"status"`
+	Error  *Problem `json:"error"`
+}
+
+// A Client talks to an ACME server on behalf of one account.
+type Client struct {
+	dirURL string
+	key    *ecdsa.PrivateKey
+	hc     *http.Client
+	dir    directory
+	kid    string
+	nonce  string
+}
+
+// NewClient returns a client for the ACME server with the given directory
+// URL, using the key to identify the account.
+func NewClient(dirURL string, key *ecdsa.PrivateKey) *Client {
+	return &Client{
+		dirURL: dirURL,
+		key:    key,
+		hc:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Register creates the account, or looks up the existing account for the
+// key, agreeing to the terms of service of the server.
+func (c *Client) Register(email string) error {
+	if err := c.discover(); err != nil {
+		return err
+	}
+
+	req := map[string]interface{}{
+		"termsOfServiceAgreed": true,
+	}
+	if email != "" {
+		req["contact"] = []string{"mailto:" + email}
+	}
+
+	resp, err := c.post(c.dir.NewAccount, req, nil)
+	if err != nil {
+		return err
+	}
+	c.kid = resp.Header.Get("Location")
+	if c.kid == "" {
+		return errors.New("acme: no account URL returned")
+	}
+	if debug() {
+		l.Debugln("acme: account", c.kid)
+	}
+	return nil
+}
+
+// ObtainCertificate requests a certificate for the given domains, using
+// the solver to prove control over them, and returns the DER encoded
+// certificate chain. The certificate is issued for the public part of
+// certKey. Register must have been called first.
+func (c *Client) ObtainCertificate(domains []string, certKey crypto.Signer, solver Solver) ([][]byte, error) {
+	var req struct {
+		Identifiers []identifier `json:"identifiers"`
+	}
+	for _, domain := range domains {
+		req.Identifiers = append(req.Identifiers, identifier{"dns", domain})
+	}
+
+	var o order
+	resp, err := c.post(c.dir.NewOrder, req, &o)
+	if err != nil {
+		return nil, err
+	}
+	orderURL := resp.Header.Get("Location")
+
+	for _, authzURL := range o.Authorizations {
+		if err := c.authorize(authzURL, solver); err != nil {
+			return nil, err
+		}
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, certKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.post(o.Finalize, map[string]string{"csr": b64(csr)}, &o); err != nil {
+		return nil, err
+	}
+
+	for i := 0; o.Status != "valid"; i++ {
+		if o.Status == "invalid" {
+			if o.Error != nil {
+				return nil, *o.Error
+			}
+			return nil, errors.New("acme: order failed")
+		}
+		if i == pollAttempts {
+			return nil, ErrTimeout
+		}
+		time.Sleep(pollInterval)
+		if _, err := c.post(orderURL, nil, &o); err != nil {
+			return nil, err
+		}
+	}
+
+	var bs []byte
+	if _, err := c.post(o.Certificate, nil, &bs); err != nil {
+		return nil, err
+	}
+
+	var chain [][]byte
+	for {
+		var block *pem.Block
+		block, bs = pem.Decode(bs)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("acme: no certificate returned")
+	}
+	return chain, nil
+}
+
+// authorize completes the authorization, unless it is already valid.
+func (c *Client) authorize(authzURL string, solver Solver) error {
+	var authz authorization
+	if _, err := c.post(authzURL, nil, &authz); err != nil {
+		return err
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	var chal *challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == solver.Type() {
+			chal = &authz.Challenges[i]
+			break
+		}
+	}
+	if chal == nil {
+		return ErrNoChallenge
+	}
+
+	domain := authz.Identifier.Value
+	keyAuth := chal.Token + "." + Thumbprint(c.key)
+	if debug() {
+		l.Debugf("acme: solving %s for %s", chal.Type, domain)
+	}
+	if err := solver.Present(domain, chal.Token, keyAuth); err != nil {
+		return err
+	}
+	defer solver.CleanUp(domain, chal.Token, keyAuth)
+
+	if _, err := c.post(chal.URL, struct{}{}, nil); err != nil {
+		return err
+	}
+
+	for i := 0; authz.Status != "valid"; i++ {
+		if authz.Status == "invalid" {
+			for _, ch := range authz.Challenges {
+				if ch.Error != nil {
+					return *ch.Error
+				}
+			}
+			return fmt.Errorf("acme: authorization for %s failed", domain)
+		}
+		if i == pollAttempts {
+			return ErrTimeout
+		}
+		time.Sleep(pollInterval)
+		if _, err := c.post(authzURL, nil, &authz); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) discover() error {
+	resp, err := c.hc.Get(c.dirURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("acme: directory: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(&c.dir)
+}
+
+func (c *Client) fetchNonce() (string, error) {
+	if c.nonce != "" {
+		nonce := c.nonce
+		c.nonce = ""
+		return nonce, nil
+	}
+	resp, err := c.hc.Head(c.dir.NewNonce)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("acme: no nonce returned")
+	}
+	return nonce, nil
+}
+
+// post sends a signed request and decodes the JSON response into result, if
+// it is not nil, or reads it as is into a *[]byte. The body of the response
+// returned is closed. A bad nonce is retried once, as the server may expire
+// nonces at any time.
+func (c *Client) post(url string, payload, result interface{}) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		nonce, err := c.fetchNonce()
+		if err != nil {
+			return nil, err
+		}
+		body, err := signJWS(c.key, c.kid, nonce, url, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.hc.Post(url, "application/jose+json", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		c.nonce = resp.Header.Get("Replay-Nonce")
+
+		if resp.StatusCode >= 400 {
+			var p Problem
+			json.NewDecoder(resp.Body).Decode(&p)
+			resp.Body.Close()
+			if p.Type == "urn:ietf:params:acme:error:badNonce" && attempt == 0 {
+				continue
+			}
+			if p.Type == "" {
+				p.Type = resp.Status
+			}
+			return nil, p
+		}
+
+		switch result := result.(type) {
+		case nil:
+		case *[]byte:
+			*result, err = ioutil.ReadAll(resp.Body)
+		default:
+			err = json.NewDecoder(resp.Body).Decode(result)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
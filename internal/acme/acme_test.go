@@ -0,0 +1,280 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeCA implements just enough of an ACME server to issue a certificate
+// after a challenge has been answered.
+type fakeCA struct {
+	t        *testing.T
+	srv      *httptest.Server
+	solver   Solver
+	keyAuth  string
+	answered bool
+	csr      *x509.CertificateRequest
+	nonces   int
+}
+
+func (f *fakeCA) url(path string) string {
+	return f.srv.URL + path
+}
+
+func (f *fakeCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.nonces++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", f.nonces))
+
+	if r.URL.Path == "/dir" {
+		json.NewEncoder(w).Encode(directory{
+			NewNonce:   f.url("/nonce"),
+			NewAccount: f.url("/account"),
+			NewOrder:   f.url("/order"),
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		f.t.Error(err)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+
+	switch r.URL.Path {
+	case "/account":
+		w.Header().Set("Location", f.url("/account/1"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+
+	case "/order":
+		var req struct {
+			Identifiers []identifier `json:"identifiers"`
+		}
+		json.Unmarshal(payload, &req)
+		if len(req.Identifiers) != 1 || req.Identifiers[0].Value != "example.com" {
+			f.t.Errorf("Unexpected identifiers %v", req.Identifiers)
+		}
+		w.Header().Set("Location", f.url("/order/1"))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(order{
+			Status:         "pending",
+			Authorizations: []string{f.url("/authz/1")},
+			Finalize:       f.url("/finalize/1"),
+		})
+
+	case "/authz/1":
+		status := "pending"
+		if f.answered {
+			status = "valid"
+		}
+		json.NewEncoder(w).Encode(authorization{
+			Status:     status,
+			Identifier: identifier{"dns", "example.com"},
+			Challenges: []challenge{
+				{Type: "http-01", URL: f.url("/chall/1"), Token: "token1"},
+				{Type: "dns-01", URL: f.url("/chall/2"), Token: "token2"},
+			},
+		})
+
+	case "/chall/1":
+		// Validate the challenge the way the CA would.
+		rec := httptest.NewRecorder()
+		f.solver.(*HTTPSolver).ServeHTTP(rec, httptest.NewRequest("GET", "/.well-known/acme-challenge/token1", nil))
+		if rec.Body.String() != f.keyAuth {
+			f.t.Errorf("Unexpected key authorization %q", rec.Body.String())
+		}
+		f.answered = true
+		w.Write([]byte("{}"))
+
+	case "/finalize/1":
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		f.csr = csr
+		json.NewEncoder(w).Encode(order{Status: "processing"})
+
+	case "/order/1":
+		json.NewEncoder(w).Encode(order{Status: "valid", Certificate: f.url("/cert/1")})
+
+	case "/cert/1":
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "example.com"},
+			DNSNames:     f.csr.DNSNames,
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, f.csr.PublicKey, key)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// openBodies counts the response bodies not yet closed.
+type openBodies struct {
+	next http.RoundTripper
+	open int32
+}
+
+func (o *openBodies) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := o.next.RoundTrip(req)
+	if err == nil {
+		atomic.AddInt32(&o.open, 1)
+		resp.Body = &countedBody{resp.Body, &o.open}
+	}
+	return resp, err
+}
+
+type countedBody struct {
+	io.ReadCloser
+	open *int32
+}
+
+func (b *countedBody) Close() error {
+	atomic.AddInt32(b.open, -1)
+	return b.ReadCloser.Close()
+}
+
+func TestObtainCertificate(t *testing.T) {
+	pollInterval = time.Millisecond
+
+	accountKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	certKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	solver := &HTTPSolver{Address: "127.0.0.1:0"}
+	ca := &fakeCA{t: t, solver: solver, keyAuth: "token1." + Thumbprint(accountKey)}
+	ca.srv = httptest.NewServer(ca)
+	defer ca.srv.Close()
+
+	c := NewClient(ca.url("/dir"), accountKey)
+	bodies := &openBodies{next: http.DefaultTransport}
+	c.hc.Transport = bodies
+	if err := c.Register("someone@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if c.kid != ca.url("/account/1") {
+		t.Errorf("Unexpected account URL %q", c.kid)
+	}
+
+	chain, err := c.ObtainCertificate([]string{"example.com"}, certKey, solver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 1 {
+		t.Fatalf("Unexpected chain length %d", len(chain))
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.VerifyHostname("example.com"); err != nil {
+		t.Error(err)
+	}
+	if solver.listener != nil {
+		t.Error("Challenge listener not closed")
+	}
+	if open := atomic.LoadInt32(&bodies.open); open != 0 {
+		t.Errorf("%d response bodies not closed", open)
+	}
+}
+
+func TestNoChallenge(t *testing.T) {
+	pollInterval = time.Millisecond
+
+	accountKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	certKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	ca := &fakeCA{t: t}
+	ca.srv = httptest.NewServer(ca)
+	defer ca.srv.Close()
+
+	c := NewClient(ca.url("/dir"), accountKey)
+	if err := c.Register(""); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.ObtainCertificate([]string{"example.com"}, certKey, tlsALPNSolver{})
+	if err != ErrNoChallenge {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+type tlsALPNSolver struct{}
+
+func (tlsALPNSolver) Type() string                 { return "tls-alpn-01" }
+func (tlsALPNSolver) Present(_, _, _ string) error { return nil }
+func (tlsALPNSolver) CleanUp(_, _, _ string) error { return nil }
+
+func TestDNS01Value(t *testing.T) {
+	// The value is the unpadded base64url encoded SHA-256 digest
+	v := dns01Value("token.thumbprint")
+	if len(v) != 43 || strings.ContainsAny(v, "=+/") {
+		t.Errorf("Unexpected DNS-01 value %q", v)
+	}
+}
+
+func TestSignJWS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bs, err := signJWS(key, "", "nonce", "https://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jws map[string]string
+	if err := json.Unmarshal(bs, &jws); err != nil {
+		t.Fatal(err)
+	}
+	if jws["payload"] != "" {
+		t.Errorf("Unexpected payload %q for POST-as-GET", jws["payload"])
+	}
+	hdr, _ := base64.RawURLEncoding.DecodeString(jws["protected"])
+	if !strings.Contains(string(hdr), `"jwk":{"crv":"P-256"`) {
+		t.Errorf("Missing JWK in header %s", hdr)
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(jws["signature"])
+	if len(sig) != 64 {
+		t.Errorf("Unexpected signature length %d", len(sig))
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	hash := sha256.Sum256([]byte(jws["protected"] + "." + jws["payload"]))
+	if !ecdsa.Verify(&key.PublicKey, hash[:], r, s) {
+		t.Error("Signature does not verify")
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+func b64(bs []byte) string {
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+// jwk returns the JSON Web Key for the public part of a P-256 key, with the
+// members in the lexical order required for thumbprints (RFC 7638).
+func jwk(key *ecdsa.PrivateKey) string {
+	return fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`,
+		b64(padBytes(key.X, 32)), b64(padBytes(key.Y, 32)))
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of the key, as used in key
+// authorizations.
+func Thumbprint(key *ecdsa.PrivateKey) string {
+	hash := sha256.Sum256([]byte(jwk(key)))
+	return b64(hash[:])
+}
+
+// signJWS returns the flattened JWS serialization of the payload, signed
+// with ES256. The key is identified by kid if set, otherwise by the full
+// public key. A nil payload results in an empty payload, as used for
+// POST-as-GET requests.
+func signJWS(key *ecdsa.PrivateKey, kid, nonce, url string, payload interface{}) ([]byte, error) {
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": nonce,
+		"url":   url,
+	}
+	if kid != "" {
+		protected["kid"] = kid
+	} else {
+		protected["jwk"] = json.RawMessage(jwk(key))
+	}
+	hdr, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	if payload != nil {
+		body, err = json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signed := b64(hdr) + "." + b64(body)
+	hash := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := append(padBytes(r, 32), padBytes(s, 32)...)
+
+	return json.Marshal(map[string]string{
+		"protected": b64(hdr),
+		"payload":   b64(body),
+		"signature": b64(sig),
+	})
+}
+
+func padBytes(n *big.Int, size int) []byte {
+	bs := n.Bytes()
+	if len(bs) >= size {
+		return bs
+	}
+	return append(make([]byte, size-len(bs)), bs...)
+}
+
+// dns01Value returns the TXT record value for a DNS-01 challenge.
+func dns01Value(keyAuth string) string {
+	hash := sha256.Sum256([]byte(keyAuth))
+	return b64(hash[:])
+}
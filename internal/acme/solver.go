@@ -0,0 +1,123 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package acme
+
+import (
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// A Solver proves control over a domain by fulfilling a challenge.
+type Solver interface {
+	// Type returns the challenge type, i.e. "http-01" or "dns-01".
+	Type() string
+	// Present makes the key authorization available for validation.
+	Present(domain, token, keyAuth string) error
+	// CleanUp removes what Present set up.
+	CleanUp(domain, token, keyAuth string) error
+}
+
+const challengePath = "/.well-known/acme-challenge/"
+
+// An HTTPSolver answers HTTP-01 challenges. It listens on the given address,
+// which must be reachable as port 80 of the domain, while a challenge is
+// pending.
+type HTTPSolver struct {
+	Address string
+
+	tokens   map[string]string
+	listener net.Listener
+	mut      sync.Mutex
+}
+
+func (s *HTTPSolver) Type() string {
+	return "http-01"
+}
+
+func (s *HTTPSolver) Present(domain, token, keyAuth string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.tokens == nil {
+		s.tokens = make(map[string]string)
+	}
+	s.tokens[token] = keyAuth
+
+	if s.listener == nil {
+		listener, err := net.Listen("tcp", s.Address)
+		if err != nil {
+			return err
+		}
+		s.listener = listener
+		go http.Serve(listener, s)
+	}
+	return nil
+}
+
+func (s *HTTPSolver) CleanUp(domain, token, keyAuth string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.tokens, token)
+	if len(s.tokens) == 0 && s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	return nil
+}
+
+// ServeHTTP responds to validation requests for pending challenges.
+func (s *HTTPSolver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, challengePath) {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mut.Lock()
+	keyAuth, ok := s.tokens[strings.TrimPrefix(r.URL.Path, challengePath)]
+	s.mut.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth))
+}
+
+// A DNSHookSolver answers DNS-01 challenges by running an external command
+// to create and remove the TXT record, as there are as many DNS update APIs
+// as there are DNS providers. The command is run as
+//
+//	command present _acme-challenge.<domain> <value>
+//	command cleanup _acme-challenge.<domain> <value>
+//
+// and should not return until the record is visible to the world.
+type DNSHookSolver struct {
+	Command string
+}
+
+func (s *DNSHookSolver) Type() string {
+	return "dns-01"
+}
+
+func (s *DNSHookSolver) Present(domain, token, keyAuth string) error {
+	return s.run("present", domain, keyAuth)
+}
+
+func (s *DNSHookSolver) CleanUp(domain, token, keyAuth string) error {
+	return s.run("cleanup", domain, keyAuth)
+}
+
+func (s *DNSHookSolver) run(action, domain, keyAuth string) error {
+	out, err := exec.Command(s.Command, action, "_acme-challenge."+domain, dns01Value(keyAuth)).CombinedOutput()
+	if err != nil && debug() {
+		l.Debugf("acme: %s %s: %v: %s", s.Command, action, err, out)
+	}
+	return err
+}
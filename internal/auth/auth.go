@@ -0,0 +1,136 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package auth delegates the authentication of GUI users to an external
+// command or HTTP endpoint, so that sites can use whatever single sign on
+// system they have.
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The roles a user can be given. Users with the read only role can look but
+// not change anything.
+const (
+	RoleAdmin    = "admin"
+	RoleReadOnly = "readonly"
+)
+
+// Timeout is how long to wait for the command or endpoint to answer.
+var Timeout = 10 * time.Second
+
+var errTimeout = errors.New("timed out")
+
+// An Authenticator decides whether a user is allowed in. It returns the role
+// of an allowed user, or "" and a nil error for a user that is denied.
+type Authenticator interface {
+	Authenticate(user, password string) (role string, err error)
+}
+
+// A Command authenticates users by running an external command as
+//
+//	command <user>
+//
+// with the password on standard input. An exit status of zero allows the
+// user in, with the role given on the first line of the output or the admin
+// role if there is none. Any other exit status denies the user.
+type Command struct {
+	Command string
+}
+
+func (c Command) Authenticate(user, password string) (string, error) {
+	cmd := exec.Command(c.Command, user)
+	cmd.Stdin = strings.NewReader(password + "\n")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if _, ok := err.(*exec.ExitError); ok {
+			if debug() {
+				l.Debugf("auth: %s %s: %v", c.Command, user, err)
+			}
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+	case <-time.After(Timeout):
+		cmd.Process.Kill()
+		return "", errTimeout
+	}
+
+	line, _ := bufio.NewReader(&out).ReadString('\n')
+	return checkRole(strings.TrimSpace(line))
+}
+
+// A URL authenticates users by posting the form values "user" and "password"
+// to an HTTP endpoint. A 200 response allows the user in, with the role
+// given by an optional JSON body such as {"role": "readonly"}, or the admin
+// role if there is none. A 401 or 403 response denies the user.
+type URL struct {
+	URL    string
+	Client *http.Client // nil for a default client
+}
+
+func (u URL) Authenticate(user, password string) (string, error) {
+	client := u.Client
+	if client == nil {
+		client = &http.Client{Timeout: Timeout}
+	}
+
+	resp, err := client.PostForm(u.URL, url.Values{"user": {user}, "password": {password}})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if debug() {
+			l.Debugf("auth: %s %s: %s", u.URL, user, resp.Status)
+		}
+		return "", nil
+	default:
+		return "", fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	var res struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil && err != io.EOF {
+		return "", err
+	}
+	return checkRole(res.Role)
+}
+
+func checkRole(role string) (string, error) {
+	switch role {
+	case "":
+		return RoleAdmin, nil
+	case RoleAdmin, RoleReadOnly:
+		return role, nil
+	default:
+		return "", fmt.Errorf("unknown role %q", role)
+	}
+}
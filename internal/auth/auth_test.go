@@ -0,0 +1,118 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// Allows alice with the password on stdin as admin, bob as read only
+	check := script("check", `read pw
+case "$1:$pw" in
+alice:secret) exit 0 ;;
+bob:secret) echo readonly ;;
+*) exit 1 ;;
+esac`)
+
+	cases := []struct {
+		user, password, role string
+	}{
+		{"alice", "secret", RoleAdmin},
+		{"bob", "secret", RoleReadOnly},
+		{"alice", "wrong", ""},
+		{"mallory", "secret", ""},
+	}
+	for _, tc := range cases {
+		role, err := Command{check}.Authenticate(tc.user, tc.password)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.user, err)
+		}
+		if role != tc.role {
+			t.Errorf("%s: role %q != %q", tc.user, role, tc.role)
+		}
+	}
+
+	if role, err := (Command{script("badrole", "echo root")}).Authenticate("alice", "secret"); err == nil {
+		t.Errorf("unexpected nil error for unknown role, role %q", role)
+	}
+	if _, err := (Command{filepath.Join(dir, "nonexistent")}).Authenticate("alice", "secret"); err == nil {
+		t.Error("unexpected nil error for nonexistent command")
+	}
+
+	defer func(d time.Duration) { Timeout = d }(Timeout)
+	Timeout = 100 * time.Millisecond
+	if role, err := (Command{script("slow", "exec sleep 10")}).Authenticate("alice", "secret"); err != errTimeout || role != "" {
+		t.Errorf("unexpected result for slow command, %q, %v", role, err)
+	}
+}
+
+func TestURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.FormValue("password") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.FormValue("user") {
+		case "alice":
+		case "bob":
+			fmt.Fprint(w, `{"role": "readonly"}`)
+		case "carol":
+			fmt.Fprint(w, `{"role": "root"}`)
+		case "dave":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		user, password, role string
+		err                  bool
+	}{
+		{"alice", "secret", RoleAdmin, false},
+		{"bob", "secret", RoleReadOnly, false},
+		{"alice", "wrong", "", false},
+		{"mallory", "secret", "", false},
+		{"carol", "secret", "", true},
+		{"dave", "secret", "", true},
+	}
+	for _, tc := range cases {
+		role, err := URL{URL: srv.URL}.Authenticate(tc.user, tc.password)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: unexpected error: %v", tc.user, err)
+		}
+		if role != tc.role {
+			t.Errorf("%s: role %q != %q", tc.user, role, tc.role)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// After a user is denied, further attempts for the same user are refused
+// without asking for ThrottleDelay, doubling with each further denial up to
+// MaxThrottleDelay.
+var (
+	ThrottleDelay    = time.Second
+	MaxThrottleDelay = 5 * time.Minute
+)
+
+// The number of users with failed attempts above which those no longer
+// throttled are forgotten.
+const maxThrottled = 1000
+
+// ErrThrottled is returned for attempts made too soon after a denial.
+var ErrThrottled = errors.New("too many failed attempts")
+
+// A Throttle wraps an Authenticator so that passwords can't be guessed
+// quickly, and a command or endpoint isn't asked about every guess.
+type Throttle struct {
+	Authenticator
+	failures map[string]throttled // user -> failures
+	mut      sync.Mutex
+}
+
+type throttled struct {
+	count int
+	until time.Time
+}
+
+func NewThrottle(a Authenticator) *Throttle {
+	return &Throttle{
+		Authenticator: a,
+		failures:      make(map[string]throttled),
+	}
+}
+
+func (t *Throttle) Authenticate(user, password string) (string, error) {
+	t.mut.Lock()
+	f := t.failures[user]
+	t.mut.Unlock()
+	if time.Now().Before(f.until) {
+		return "", ErrThrottled
+	}
+
+	role, err := t.Authenticator.Authenticate(user, password)
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if role != "" {
+		delete(t.failures, user)
+		return role, err
+	}
+
+	now := time.Now()
+	if len(t.failures) >= maxThrottled {
+		for u, f := range t.failures {
+			if now.After(f.until) {
+				delete(t.failures, u)
+			}
+		}
+	}
+	f = t.failures[user]
+	delay := MaxThrottleDelay
+	if f.count < 32 && ThrottleDelay<<uint(f.count) < MaxThrottleDelay {
+		delay = ThrottleDelay << uint(f.count)
+	}
+	f.count++
+	f.until = now.Add(delay)
+	t.failures[user] = f
+	return role, err
+}
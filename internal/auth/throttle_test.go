@@ -0,0 +1,77 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// countingAuth allows alice with the password "secret" and counts the
+// attempts it was asked about.
+type countingAuth struct {
+	attempts int
+}
+
+func (a *countingAuth) Authenticate(user, password string) (string, error) {
+	a.attempts++
+	if user == "alice" && password == "secret" {
+		return RoleAdmin, nil
+	}
+	return "", nil
+}
+
+func TestThrottle(t *testing.T) {
+	defer func(d, m time.Duration) { ThrottleDelay, MaxThrottleDelay = d, m }(ThrottleDelay, MaxThrottleDelay)
+	ThrottleDelay = 100 * time.Millisecond
+	MaxThrottleDelay = time.Second
+
+	a := &countingAuth{}
+	th := NewThrottle(a)
+
+	if role, err := th.Authenticate("alice", "wrong"); role != "" || err != nil {
+		t.Fatalf("unexpected result %q, %v", role, err)
+	}
+
+	// Refused without asking, even with the right password
+	if role, err := th.Authenticate("alice", "secret"); role != "" || err != ErrThrottled {
+		t.Errorf("unexpected result %q, %v", role, err)
+	}
+	if a.attempts != 1 {
+		t.Errorf("asked %d times, expected 1", a.attempts)
+	}
+
+	// Other users aren't affected
+	if role, err := th.Authenticate("bob", "secret"); role != "" || err != nil {
+		t.Errorf("unexpected result %q, %v", role, err)
+	}
+
+	time.Sleep(ThrottleDelay + 50*time.Millisecond)
+	if role, err := th.Authenticate("alice", "secret"); role != RoleAdmin || err != nil {
+		t.Errorf("unexpected result %q, %v", role, err)
+	}
+
+	// A success clears the failures
+	th.Authenticate("alice", "wrong")
+	if f := th.failures["alice"]; f.count != 1 {
+		t.Errorf("%d failures, expected 1", f.count)
+	}
+}
+
+func TestThrottleDoubling(t *testing.T) {
+	defer func(d, m time.Duration) { ThrottleDelay, MaxThrottleDelay = d, m }(ThrottleDelay, MaxThrottleDelay)
+	ThrottleDelay = time.Second
+	MaxThrottleDelay = 5 * time.Second
+
+	th := NewThrottle(&countingAuth{})
+	for i, expected := range []time.Duration{1, 2, 4, 5, 5} {
+		th.failures["alice"] = throttled{count: i}
+		t0 := time.Now()
+		th.Authenticate("alice", "wrong")
+		if d := th.failures["alice"].until.Sub(t0); d < expected*time.Second || d > expected*time.Second+time.Second/2 {
+			t.Errorf("after %d failures, throttled for %v, expected %v", i+1, d, expected*time.Second)
+		}
+	}
+}
@@ -2,6 +2,8 @@
 // All rights reserved. Use of this source code is governed by an MIT-style
 // license that can be found in the LICENSE file.
 
+// +build !headless
+
 package auto_test
 
 import (
@@ -0,0 +1,16 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build headless
+
+package auto
+
+// Headless is true in builds without the GUI assets.
+const Headless = true
+
+// Assets returns no assets in headless builds, which serve only the REST
+// API.
+func Assets() map[string][]byte {
+	return nil
+}
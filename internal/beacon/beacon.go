@@ -4,7 +4,19 @@
 
 package beacon
 
-import "net"
+import (
+	"net"
+	"time"
+)
+
+const (
+	// How often the network interfaces are looked at, to notice the ones
+	// that were added, removed or changed (VPN up or down, Wi-Fi roaming).
+	interfaceCheckInterval = 10 * time.Second
+
+	// How long to wait before reopening a socket that failed.
+	errorRetryInterval = 10 * time.Second
+)
 
 type recv struct {
 	data []byte
@@ -16,15 +28,16 @@ type Interface interface {
 	Recv() ([]byte, net.Addr)
 }
 
-func genericReader(conn *net.UDPConn, outbox chan<- recv) {
+// genericReader passes the packets read from the connection to the outbox
+// until reading fails, and returns the error.
+func genericReader(conn *net.UDPConn, outbox chan<- recv) error {
 	bs := make([]byte, 65536)
 	for {
 		n, addr, err := conn.ReadFrom(bs)
 		if err != nil {
-			l.Warnln("multicast read:", err)
-			return
+			return err
 		}
-		if debug {
+		if debug() {
 			l.Debugf("recv %d bytes from %s", n, addr)
 		}
 
@@ -33,7 +46,7 @@ func genericReader(conn *net.UDPConn, outbox chan<- recv) {
 		select {
 		case outbox <- recv{c, addr}:
 		default:
-			if debug {
+			if debug() {
 				l.Debugln("dropping message")
 			}
 		}
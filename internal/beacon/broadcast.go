@@ -4,28 +4,35 @@
 
 package beacon
 
-import "net"
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
 
 type Broadcast struct {
-	conn   *net.UDPConn
 	port   int
 	inbox  chan []byte
 	outbox chan recv
+	conn   *net.UDPConn // nil while the socket can't be opened
+	mut    sync.Mutex   // protects conn
 }
 
+// NewBroadcast returns a beacon sending to and receiving from the IPv4
+// broadcast addresses of all interfaces on the given port. The socket is
+// opened in the background, and reopened whenever it fails.
 func NewBroadcast(port int) (*Broadcast, error) {
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
-	if err != nil {
-		return nil, err
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid broadcast port %d", port)
 	}
 	b := &Broadcast{
-		conn:   conn,
 		port:   port,
 		inbox:  make(chan []byte),
 		outbox: make(chan recv, 16),
 	}
 
-	go genericReader(b.conn, b.outbox)
+	go b.reader()
 	go b.writer()
 
 	return b, nil
@@ -40,8 +47,52 @@ func (b *Broadcast) Recv() ([]byte, net.Addr) {
 	return recv.data, recv.src
 }
 
+func (b *Broadcast) reader() {
+	var failing bool
+	for {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: b.port})
+		if err != nil {
+			if !failing {
+				l.Infoln("Broadcast: listening:", err)
+				failing = true
+			}
+			time.Sleep(errorRetryInterval)
+			continue
+		}
+		if failing {
+			l.Infoln("Broadcast: listening on port", b.port)
+			failing = false
+		}
+
+		b.mut.Lock()
+		b.conn = conn
+		b.mut.Unlock()
+
+		err = genericReader(conn, b.outbox)
+
+		b.mut.Lock()
+		b.conn = nil
+		b.mut.Unlock()
+		conn.Close()
+
+		if debug() {
+			l.Debugln("broadcast read:", err, "; reopening")
+		}
+		time.Sleep(errorRetryInterval)
+	}
+}
+
 func (b *Broadcast) writer() {
 	for bs := range b.inbox {
+		b.mut.Lock()
+		conn := b.conn
+		b.mut.Unlock()
+		if conn == nil {
+			if debug() {
+				l.Debugln("not sending; no broadcast socket")
+			}
+			continue
+		}
 
 		addrs, err := net.InterfaceAddrs()
 		if err != nil {
@@ -62,19 +113,19 @@ func (b *Broadcast) writer() {
 			dsts = append(dsts, net.IP{0xff, 0xff, 0xff, 0xff})
 		}
 
-		if debug {
+		if debug() {
 			l.Debugln("addresses:", dsts)
 		}
 
 		for _, ip := range dsts {
 			dst := &net.UDPAddr{IP: ip, Port: b.port}
 
-			_, err := b.conn.WriteTo(bs, dst)
+			_, err := conn.WriteTo(bs, dst)
 			if err != nil {
-				if debug {
+				if debug() {
 					l.Debugln(err)
 				}
-			} else if debug {
+			} else if debug() {
 				l.Debugf("sent %d bytes to %s", len(bs), dst)
 			}
 		}
@@ -4,14 +4,14 @@
 
 package beacon
 
-import (
-	"os"
-	"strings"
+import "github.com/syncthing/syncthing/internal/logger"
 
-	"github.com/syncthing/syncthing/internal/logger"
-)
+var l = logger.DefaultLogger
 
-var (
-	debug = strings.Contains(os.Getenv("STTRACE"), "beacon") || os.Getenv("STTRACE") == "all"
-	l     = logger.DefaultLogger
-)
+func init() {
+	l.NewFacility("beacon", "Multicast and broadcast discovery")
+}
+
+func debug() bool {
+	return l.ShouldDebug("beacon")
+}
@@ -2,5 +2,5 @@
 // All rights reserved. Use of this source code is governed by an MIT-style
 // license that can be found in the LICENSE file.
 
-// Package beacon implements an UDP broadcast beacon
+// Package beacon implements UDP broadcast and multicast beacons
 package beacon
@@ -4,13 +4,30 @@
 
 package beacon
 
-import "net"
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
 
+// A Multicast beacon joins the group on every interface that is up and
+// capable of multicast, with a socket per interface. The interfaces are
+// looked at periodically; the group is joined on the ones that were added or
+// changed and left on the ones that are gone.
 type Multicast struct {
-	conn   *net.UDPConn
 	addr   *net.UDPAddr
 	inbox  chan []byte
 	outbox chan recv
+	conns  map[string]*multicastConn // interface key -> socket joined to the group on it
+	mut    sync.Mutex                // protects conns
+}
+
+type multicastConn struct {
+	intf net.Interface
+	conn *net.UDPConn
 }
 
 func NewMulticast(addr string) (*Multicast, error) {
@@ -18,18 +35,18 @@ func NewMulticast(addr string) (*Multicast, error) {
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.ListenMulticastUDP("udp", nil, gaddr)
-	if err != nil {
-		return nil, err
+	if !gaddr.IP.IsMulticast() {
+		return nil, fmt.Errorf("%s is not a multicast address", addr)
 	}
 	b := &Multicast{
-		conn:   conn,
 		addr:   gaddr,
 		inbox:  make(chan []byte),
 		outbox: make(chan recv, 16),
+		conns:  make(map[string]*multicastConn),
 	}
 
-	go genericReader(b.conn, b.outbox)
+	b.refresh()
+	go b.refresher()
 	go b.writer()
 
 	return b, nil
@@ -44,26 +61,122 @@ func (b *Multicast) Recv() ([]byte, net.Addr) {
 	return recv.data, recv.src
 }
 
-func (b *Multicast) writer() {
-	for bs := range b.inbox {
-		intfs, err := net.Interfaces()
+func (b *Multicast) refresher() {
+	for _ = range time.Tick(interfaceCheckInterval) {
+		b.refresh()
+	}
+}
+
+// refresh joins the group on the interfaces that were added or changed
+// since the last time, or where reading failed, and leaves it on the ones
+// that are gone.
+func (b *Multicast) refresh() {
+	intfs, err := net.Interfaces()
+	if err != nil {
+		l.Warnln("Multicast: interfaces:", err)
+		return
+	}
+	wanted := multicastInterfaces(intfs, func(intf net.Interface) ([]net.Addr, error) {
+		return intf.Addrs()
+	})
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for key, mc := range b.conns {
+		if _, ok := wanted[key]; !ok {
+			if debug() {
+				l.Debugln("leaving group on", mc.intf.Name)
+			}
+			delete(b.conns, key)
+			mc.conn.Close()
+		}
+	}
+
+	for key, intf := range wanted {
+		if _, ok := b.conns[key]; ok {
+			continue
+		}
+		intf := intf
+		conn, err := net.ListenMulticastUDP("udp", &intf, b.addr)
 		if err != nil {
-			l.Warnln("multicast interfaces:", err)
+			if debug() {
+				l.Debugln("joining group on", intf.Name+":", err)
+			}
 			continue
 		}
-		for _, intf := range intfs {
-			if intf.Flags&net.FlagUp != 0 && intf.Flags&net.FlagMulticast != 0 {
-				addr := *b.addr
-				addr.Zone = intf.Name
-				_, err = b.conn.WriteTo(bs, &addr)
-				if err != nil {
-					if debug {
-						l.Debugln(err, "on write to", addr)
-					}
-				} else if debug {
-					l.Debugf("sent %d bytes to %s", len(bs), addr.String())
+		if debug() {
+			l.Debugln("joined group on", intf.Name)
+		}
+		mc := &multicastConn{intf, conn}
+		b.conns[key] = mc
+		go b.reader(key, mc)
+	}
+}
+
+func (b *Multicast) reader(key string, mc *multicastConn) {
+	err := genericReader(mc.conn, b.outbox)
+
+	b.mut.Lock()
+	if b.conns[key] == mc {
+		// The socket failed rather than being closed by refresh, which will
+		// join the group on the interface again.
+		if debug() {
+			l.Debugln("multicast read on", mc.intf.Name+":", err)
+		}
+		delete(b.conns, key)
+		mc.conn.Close()
+	}
+	b.mut.Unlock()
+}
+
+func (b *Multicast) writer() {
+	for bs := range b.inbox {
+		b.mut.Lock()
+		conns := make([]*multicastConn, 0, len(b.conns))
+		for _, mc := range b.conns {
+			conns = append(conns, mc)
+		}
+		b.mut.Unlock()
+
+		if len(conns) == 0 && debug() {
+			l.Debugln("not sending; group not joined on any interface")
+		}
+
+		for _, mc := range conns {
+			addr := *b.addr
+			addr.Zone = mc.intf.Name
+			_, err := mc.conn.WriteTo(bs, &addr)
+			if err != nil {
+				if debug() {
+					l.Debugln(err, "on write to", addr)
 				}
+			} else if debug() {
+				l.Debugf("sent %d bytes to %s", len(bs), addr.String())
 			}
 		}
 	}
 }
+
+// multicastInterfaces returns the interfaces that are up and capable of
+// multicast, keyed by their name, index and addresses so that an interface
+// that changed gets a new key.
+func multicastInterfaces(intfs []net.Interface, addrs func(net.Interface) ([]net.Addr, error)) map[string]net.Interface {
+	res := make(map[string]net.Interface)
+	for _, intf := range intfs {
+		if intf.Flags&net.FlagUp == 0 || intf.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		ifAddrs, err := addrs(intf)
+		if err != nil {
+			continue
+		}
+		strs := make([]string, len(ifAddrs))
+		for i := range ifAddrs {
+			strs[i] = ifAddrs[i].String()
+		}
+		sort.Strings(strs)
+		res[fmt.Sprintf("%s/%d/%s", intf.Name, intf.Index, strings.Join(strs, ","))] = intf
+	}
+	return res
+}
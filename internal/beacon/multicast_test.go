@@ -0,0 +1,48 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package beacon
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMulticastInterfaces(t *testing.T) {
+	intfs := []net.Interface{
+		{Index: 1, Name: "lo", Flags: net.FlagUp | net.FlagLoopback},
+		{Index: 2, Name: "eth0", Flags: net.FlagUp | net.FlagMulticast},
+		{Index: 3, Name: "tun0", Flags: net.FlagMulticast},
+	}
+	ifAddrs := map[string][]net.Addr{
+		"eth0": {&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)}},
+	}
+	addrs := func(intf net.Interface) ([]net.Addr, error) {
+		return ifAddrs[intf.Name], nil
+	}
+
+	first := multicastInterfaces(intfs, addrs)
+	if len(first) != 1 {
+		t.Fatalf("Unexpected interfaces %v", first)
+	}
+	for _, intf := range first {
+		if intf.Name != "eth0" {
+			t.Errorf("Unexpected interface %v", intf)
+		}
+	}
+
+	// A changed address gives the interface a new key, so that the group
+	// is joined again; an interface coming up is added
+	ifAddrs["eth0"] = []net.Addr{&net.IPNet{IP: net.ParseIP("fe80::2"), Mask: net.CIDRMask(64, 128)}}
+	intfs[2].Flags |= net.FlagUp
+	second := multicastInterfaces(intfs, addrs)
+	if len(second) != 2 {
+		t.Fatalf("Unexpected interfaces %v", second)
+	}
+	for key := range first {
+		if _, ok := second[key]; ok {
+			t.Errorf("Changed interface kept key %q", key)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+// ChangedCommands returns the names of the settings holding commands to run
+// that differ between the configurations. Such settings are only to be
+// changed in the config file, as whoever can change them can run anything
+// as us.
+func ChangedCommands(from, to Configuration) []string {
+	var changed []string
+	if from.GUI.ACMEDNSHook != to.GUI.ACMEDNSHook {
+		changed = append(changed, "acmeDNSHook")
+	}
+	if from.GUI.AuthCommand != to.GUI.AuthCommand {
+		changed = append(changed, "authCommand")
+	}
+
+	hooks := make(map[string]FolderConfiguration)
+	for _, f := range from.Folders {
+		hooks[f.ID] = f
+	}
+	var preHook, postHook bool
+	for _, f := range to.Folders {
+		preHook = preHook || f.PreHook != hooks[f.ID].PreHook
+		postHook = postHook || f.PostHook != hooks[f.ID].PostHook
+	}
+	if preHook {
+		changed = append(changed, "preHook")
+	}
+	if postHook {
+		changed = append(changed, "postHook")
+	}
+	return changed
+}
@@ -8,20 +8,19 @@ package config
 import (
 	"encoding/xml"
 	"fmt"
+	"io"
 	"os"
 	"reflect"
 	"sort"
 	"strconv"
+	"time"
 
-	"code.google.com/p/go.crypto/bcrypt"
 	"github.com/syncthing/syncthing/internal/events"
 	"github.com/syncthing/syncthing/internal/logger"
 	"github.com/syncthing/syncthing/internal/osutil"
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
-var l = logger.DefaultLogger
-
 type Configuration struct {
 	Location string                `xml:"-" json:"-"`
 	Version  int                   `xml:"version,attr" default:"5"`
@@ -31,22 +30,71 @@ type Configuration struct {
 	Options  OptionsConfiguration  `xml:"options"`
 	XMLName  xml.Name              `xml:"configuration" json:"-"`
 
+	PendingDevices []PendingDeviceConfiguration `xml:"pendingDevice"`
+	BlockedDevices []protocol.DeviceID          `xml:"blockedDevice"`
+	DeviceGroups   []DeviceGroupConfiguration   `xml:"deviceGroup"`
+
+	// Requests from other devices to stop syncing a folder that await
+	// confirmation by the user.
+	PendingUnshares []PendingUnshareConfiguration `xml:"pendingUnshare"`
+
+	// Values for the folders and devices that leave them out; nil for none.
+	Defaults *DefaultsConfiguration `xml:"defaults"`
+
 	Deprecated_Repositories []FolderConfiguration `xml:"repository" json:"-"`
 	Deprecated_Nodes        []DeviceConfiguration `xml:"node" json:"-"`
+
+	log *logger.Logger // nil means the default logger
 }
 
+// The folder types. A send only folder announces its changes but refuses
+// those of other devices; the differences with the cluster are kept until
+// reverted. A receive encrypted folder is kept on a device untrusted by the
+// others, which send it the folder encrypted; it is never scanned, and the
+// data is stored and passed on without being verified.
+const (
+	FolderTypeSendReceive      = "sendreceive"
+	FolderTypeSendOnly         = "sendonly"
+	FolderTypeReceiveEncrypted = "receiveencrypted"
+)
+
 type FolderConfiguration struct {
 	ID              string                      `xml:"id,attr"`
 	Path            string                      `xml:"path,attr"`
 	Devices         []FolderDeviceConfiguration `xml:"device"`
-	ReadOnly        bool                        `xml:"ro,attr"`
+	Groups          []string                    `xml:"group"`     // device groups the folder is shared with, besides Devices
+	Type            string                      `xml:"type,attr"` // one of the FolderType constants; the default follows ReadOnly
+	ReadOnly        bool                        `xml:"ro,attr"`   // set from Type, which takes precedence
 	RescanIntervalS int                         `xml:"rescanIntervalS,attr" default:"60"`
 	IgnorePerms     bool                        `xml:"ignorePerms,attr"`
-	Invalid         string                      `xml:"-"` // Set at runtime when there is an error, not saved
+	TempDir         string                      `xml:"tempDir,attr"` // temporary files are created here rather than next to the file
+	SyncACLs        bool                        `xml:"syncACLs,attr"`
+	SyncOwnership   bool                        `xml:"syncOwnership,attr"`
+	OwnershipByName bool                        `xml:"ownershipByName,attr"` // map users and groups by name rather than by numeric ID
+	OwnershipMap    []OwnershipMapping          `xml:"ownershipMap"`
+	SymlinkPolicy   string                      `xml:"symlinkPolicy,attr"`  // "sync" (default), "skip", "follow" or "error"
+	JunctionPolicy  string                      `xml:"junctionPolicy,attr"` // "skip" (default), "follow" or "error", for NTFS junctions
+	ConflictPolicy  string                      `xml:"conflictPolicy,attr"` // "copy" (default) keeps concurrently modified local files as conflict copies; "overwrite" doesn't
+	Placeholders    bool                        `xml:"placeholders,attr"`   // create empty placeholders for new files, fetched on request
+	PreHook         string                      `xml:"preHook,attr"`        // command run before a file is applied, which may reject it
+	PostHook        string                      `xml:"postHook,attr"`       // command run after a file is applied
+	HookTimeoutS    int                         `xml:"hookTimeoutS,attr"`   // seconds a hook may run before it is killed; 0 for the default
+	Invalid         string                      `xml:"-"`                   // Set at runtime when there is an error, not saved
 	Versioning      VersioningConfiguration     `xml:"versioning"`
+	Storage         StorageConfiguration        `xml:"storage"`
+	SuggestedPath   string                      `xml:"suggestedPath,attr,omitempty"` // relative path offered to the devices the folder is shared with
+	MinCopies       int                         `xml:"minCopies,attr"`               // devices, this one included, that must hold a file for it to be safe; 0 for no policy
+	GateCleanup     bool                        `xml:"gateCleanup,attr"`             // keep all old versions of files that are not yet safe
+	FSWatcher       bool                        `xml:"fsWatcher,attr"`               // scan changed paths as the file system reports them
+	FSWatcherDelayS int                         `xml:"fsWatcherDelayS,attr"`         // seconds to collect changes before scanning; 0 for the default
+	Paused          bool                        `xml:"paused,attr"`                  // not scanned or pulled; the index is kept and exchanged as usual
 
 	deviceIDs []protocol.DeviceID
 
+	// The values are left out of the XML, to be taken from the defaults.
+	inheritRescanInterval bool
+	inheritVersioning     bool
+
 	Deprecated_Directory string                      `xml:"directory,omitempty,attr" json:"-"`
 	Deprecated_Nodes     []FolderDeviceConfiguration `xml:"node" json:"-"`
 }
@@ -92,6 +140,28 @@ func (c *VersioningConfiguration) UnmarshalXML(d *xml.Decoder, start xml.StartEl
 	return nil
 }
 
+// StorageConfiguration selects the storage backend of a folder, in the same
+// form as the versioning configuration. The local filesystem is used when no
+// type is given.
+type StorageConfiguration struct {
+	Type   string `xml:"type,attr"`
+	Params map[string]string
+}
+
+func (c *StorageConfiguration) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	vc := VersioningConfiguration(*c)
+	return vc.MarshalXML(e, start)
+}
+
+func (c *StorageConfiguration) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var vc VersioningConfiguration
+	if err := vc.UnmarshalXML(d, start); err != nil {
+		return err
+	}
+	*c = StorageConfiguration(vc)
+	return nil
+}
+
 func (r *FolderConfiguration) DeviceIDs() []protocol.DeviceID {
 	if r.deviceIDs == nil {
 		for _, n := range r.Devices {
@@ -101,17 +171,80 @@ func (r *FolderConfiguration) DeviceIDs() []protocol.DeviceID {
 	return r.deviceIDs
 }
 
+// EncryptionKey returns the key the folder is encrypted with for the device,
+// or nil if the device is trusted with its contents. Deriving the key is
+// deliberately slow, so callers should keep it.
+func (r *FolderConfiguration) EncryptionKey(device protocol.DeviceID) *protocol.EncryptionKey {
+	for _, n := range r.Devices {
+		if n.DeviceID == device && n.EncryptionPassword != "" {
+			return protocol.NewEncryptionKey(r.ID, n.EncryptionPassword)
+		}
+	}
+	return nil
+}
+
 type DeviceConfiguration struct {
-	DeviceID    protocol.DeviceID `xml:"id,attr"`
-	Name        string            `xml:"name,attr,omitempty"`
-	Addresses   []string          `xml:"address,omitempty"`
-	Compression bool              `xml:"compression,attr"`
-	CertName    string            `xml:"certName,attr,omitempty"`
-	Introducer  bool              `xml:"introducer,attr"`
+	DeviceID        protocol.DeviceID `xml:"id,attr"`
+	Name            string            `xml:"name,attr,omitempty"`
+	Addresses       []string          `xml:"address,omitempty"`
+	Compression     bool              `xml:"compression,attr"`
+	CertName        string            `xml:"certName,attr,omitempty"`
+	CertFingerprint string            `xml:"certFingerprint,attr,omitempty"` // SHA-256 of the certificate the device must present
+	Introducer      bool              `xml:"introducer,attr"`
+	Predecessor     string            `xml:"predecessor,attr,omitempty"`  // device ID this device is taking over from
+	IntroducedBy    string            `xml:"introducedBy,attr,omitempty"` // device ID of the introducer that added this device
+	Paused          bool              `xml:"paused,attr"`                 // not connected to, and connections from it are refused
+
+	// IntroductionRemovals, on an introducer, removes the devices and folder
+	// shares it added once it no longer announces them.
+	IntroductionRemovals bool `xml:"introductionRemovals,attr"`
+
+	// The device is only connected to within this window, given as for the
+	// upgrade window; all empty for any time.
+	SyncWindowStart string `xml:"syncWindowStart,attr,omitempty"` // "HH:MM"
+	SyncWindowEnd   string `xml:"syncWindowEnd,attr,omitempty"`   // "HH:MM"
+	SyncWindowDays  string `xml:"syncWindowDays,attr,omitempty"`  // "sat,sun"
+
+	inheritCompression bool // left out of the XML, to be taken from the defaults
+}
+
+// A PendingDeviceConfiguration is a device announced by an introducer that
+// awaits approval by the user.
+type PendingDeviceConfiguration struct {
+	DeviceID     protocol.DeviceID `xml:"id,attr"`
+	IntroducedBy protocol.DeviceID `xml:"introducedBy,attr"`
+	Introducer   bool              `xml:"introducer,attr"`
+	Folders      []string          `xml:"folder"`
+	Time         time.Time         `xml:"time,attr"`
+	Declined     bool              `xml:"declined,attr"`
+}
+
+// A PendingUnshareConfiguration is a request from a device to stop syncing
+// a folder that awaits confirmation by the user.
+type PendingUnshareConfiguration struct {
+	Folder   string            `xml:"folder,attr"`
+	DeviceID protocol.DeviceID `xml:"device,attr"`
+	Delete   bool              `xml:"delete,attr"` // delete the folder contents as well
+	Time     time.Time         `xml:"time,attr"`
+}
+
+// An OwnershipMapping maps a remote user or group to a local one. Both are
+// given as a numeric ID or a name.
+type OwnershipMapping struct {
+	Kind   string `xml:"kind,attr"` // "user" or "group"
+	Remote string `xml:"remote,attr"`
+	Local  string `xml:"local,attr"`
 }
 
 type FolderDeviceConfiguration struct {
-	DeviceID protocol.DeviceID `xml:"id,attr"`
+	DeviceID     protocol.DeviceID `xml:"id,attr"`
+	IntroducedBy string            `xml:"introducedBy,attr,omitempty"` // device ID of the introducer that added this share
+	Group        string            `xml:"group,attr,omitempty"`        // device group this share was added for
+	Unshare      string            `xml:"unshare,attr,omitempty"`      // "stop" or "delete" while the device is yet to be asked to stop syncing the folder
+
+	// The device is untrusted and gets the folder encrypted with this
+	// password. The devices it shares the folder with must use the same.
+	EncryptionPassword string `xml:"encryptionPassword,attr,omitempty"`
 
 	Deprecated_Name      string   `xml:"name,attr,omitempty" json:"-"`
 	Deprecated_Addresses []string `xml:"address,omitempty" json:"-"`
@@ -126,14 +259,44 @@ type OptionsConfiguration struct {
 	LocalAnnMCAddr       string   `xml:"localAnnounceMCAddr" default:"[ff32::5222]:21026"`
 	MaxSendKbps          int      `xml:"maxSendKbps"`
 	MaxRecvKbps          int      `xml:"maxRecvKbps"`
+	LimitBurstKiB        int      `xml:"limitBurstKiB" default:"64"` // how far limited transfers may run ahead of the rate
 	ReconnectIntervalS   int      `xml:"reconnectionIntervalS" default:"60"`
 	StartBrowser         bool     `xml:"startBrowser" default:"true"`
-	UPnPEnabled          bool     `xml:"upnpEnabled" default:"true"`
+	UPnPEnabled          bool     `xml:"upnpEnabled" default:"true"` // port mapping over UPnP, NAT-PMP or PCP
 	UPnPLease            int      `xml:"upnpLeaseMinutes" default:"0"`
 	UPnPRenewal          int      `xml:"upnpRenewalMinutes" default:"30"`
-	URAccepted           int      `xml:"urAccepted"` // Accepted usage reporting version; 0 for off (undecided), -1 for off (permanently)
-	RestartOnWakeup      bool     `xml:"restartOnWakeup" default:"true"`
+	URAccepted           int      `xml:"urAccepted"`                   // Accepted usage reporting version; 0 for off (undecided), -1 for off (permanently)
+	URGranularity        string   `xml:"urGranularity" default:"full"` // "full", or "version" for only the version and unique ID
+	ResumeOnWakeup       bool     `xml:"resumeOnWakeup" default:"true"`
 	AutoUpgradeIntervalH int      `xml:"autoUpgradeIntervalH" default:"12"` // 0 for off
+	UpgradeWindowStart   string   `xml:"upgradeWindowStart"`                // "HH:MM"; empty for no limit
+	UpgradeWindowEnd     string   `xml:"upgradeWindowEnd"`                  // "HH:MM"; empty for no limit
+	UpgradeWindowDays    string   `xml:"upgradeWindowDays"`                 // "sat,sun"; empty for every day
+	UpgradeNotifyOnly    bool     `xml:"upgradeNotifyOnly"`                 // announce new releases but don't install them
+	LogTarget            string   `xml:"logTarget"`                         // "syslog" or "journald"; empty for standard output only
+	LogSyslogAddress     string   `xml:"logSyslogAddress"`                  // "udp://host:514", "tcp://host:514", "tls://host:6514"; empty for local syslog
+	SlowScanS            int      `xml:"slowScanS" default:"300"`           // record scans taking longer than this; 0 for off
+	SlowPullS            int      `xml:"slowPullS" default:"60"`            // record file pulls taking longer than this; 0 for off
+	SlowIndexS           int      `xml:"slowIndexS" default:"10"`           // record index exchanges taking longer than this; 0 for off
+	UploadCrashReports   bool     `xml:"uploadCrashReports"`                // send crash reports along with usage reporting
+	CrashReportURL       string   `xml:"crashReportURL" default:"https://data.syncthing.net/newcrash"`
+	CertRotationDays     int      `xml:"certRotationDays" default:"14"`   // how long to announce a new certificate before switching to it
+	TLSMinVersion        string   `xml:"tlsMinVersion" default:"1.2"`     // "1.0", "1.1", "1.2" or "1.3"
+	TLSCipherSuites      []string `xml:"tlsCipherSuite"`                  // allowed cipher suites; empty for the defaults
+	GUITLSMinVersion     string   `xml:"guiTLSMinVersion"`                // as TLSMinVersion; empty for the default
+	GUITLSCipherSuites   []string `xml:"guiTLSCipherSuite"`               // as TLSCipherSuites
+	ApproveIntroduced    bool     `xml:"approveIntroducedDevices"`        // devices announced by introducers need manual approval
+	SignConfig           bool     `xml:"signConfig"`                      // sign the config file with the device key to detect modifications
+	ItemEventIntervalMs  int      `xml:"itemEventIntervalMs" default:"0"` // batch events about individual files over this long; 0 for off
+	DiskIOSlots          int      `xml:"diskIOSlots" default:"2"`         // files being hashed, copied or verified at the same time; 0 for no limit
+	NetworkIOSlots       int      `xml:"networkIOSlots" default:"64"`     // outstanding block requests across all folders; 0 for no limit
+	DeviceProfile        string   `xml:"deviceProfile" default:"auto"`    // "low-power", "default" or "server"; "auto" to detect from CPUs and memory
+	LANNetworks          []string `xml:"lanNetwork"`                      // networks in CIDR notation counted as LAN traffic, besides private and link local addresses
+	DeviceExpiryDays     int      `xml:"deviceExpiryDays"`                // expire devices not seen for this many days, except introducers; 0 for off
+	DeviceExpiryAction   string   `xml:"deviceExpiryAction"`              // "remove" to remove expired devices; otherwise they are paused
+	RemoteUnshare        string   `xml:"remoteUnshare" default:"confirm"` // "accept", "confirm" or "refuse" requests from other devices to stop syncing a folder
+	RelaysEnabled        bool     `xml:"relaysEnabled" default:"true"`    // connect through relays to devices that can't be reached directly
+	RelayServers         []string `xml:"relayServer"`                     // "relay://host:port", optionally with "?id=<device ID>&token=<join token>"
 
 	Deprecated_RescanIntervalS int    `xml:"rescanIntervalS,omitempty" json:"-"`
 	Deprecated_UREnabled       bool   `xml:"urEnabled,omitempty" json:"-"`
@@ -141,15 +304,26 @@ type OptionsConfiguration struct {
 	Deprecated_ReadOnly        bool   `xml:"readOnly,omitempty" json:"-"`
 	Deprecated_GUIEnabled      bool   `xml:"guiEnabled,omitempty" json:"-"`
 	Deprecated_GUIAddress      string `xml:"guiAddress,omitempty" json:"-"`
+	Deprecated_RestartOnWakeup string `xml:"restartOnWakeup,omitempty" json:"-"`
 }
 
 type GUIConfiguration struct {
-	Enabled  bool   `xml:"enabled,attr" default:"true"`
-	Address  string `xml:"address" default:"127.0.0.1:8080"`
-	User     string `xml:"user,omitempty"`
-	Password string `xml:"password,omitempty"`
-	UseTLS   bool   `xml:"tls,attr"`
-	APIKey   string `xml:"apikey,omitempty"`
+	Enabled           bool   `xml:"enabled,attr" default:"true"`
+	Address           string `xml:"address" default:"127.0.0.1:8080"`
+	User              string `xml:"user,omitempty"`
+	Password          string `xml:"password,omitempty"`
+	UseTLS            bool   `xml:"tls,attr"`
+	APIKey            string `xml:"apikey,omitempty"`
+	AuthCommand       string `xml:"authCommand,omitempty"`                                                  // command authenticating users other than User
+	AuthURL           string `xml:"authURL,omitempty"`                                                      // as AuthCommand, an HTTP endpoint
+	PasswordCost      int    `xml:"passwordCost" default:"10"`                                              // bcrypt cost when hashing the password
+	PasswordMinLength int    `xml:"passwordMinLength" default:"8"`                                          // when setting the password via the GUI; 0 for no policy
+	ACMEDomain        string `xml:"acmeDomain,omitempty"`                                                   // obtain a certificate for this host name; empty for self signed
+	ACMEEmail         string `xml:"acmeEmail,omitempty"`                                                    // contact address for the ACME account
+	ACMEDirectory     string `xml:"acmeDirectory" default:"https://acme-v02.api.letsencrypt.org/directory"` // ACME server directory URL
+	ACMEChallenge     string `xml:"acmeChallenge" default:"http-01"`                                        // "http-01" or "dns-01"
+	ACMEHTTPAddress   string `xml:"acmeHTTPAddress" default:":80"`                                          // where to answer HTTP-01 challenges
+	ACMEDNSHook       string `xml:"acmeDNSHook,omitempty"`                                                  // command setting up DNS-01 records
 }
 
 func (cfg *Configuration) DeviceMap() map[protocol.DeviceID]DeviceConfiguration {
@@ -169,6 +343,99 @@ func (cfg *Configuration) GetDeviceConfiguration(deviceID protocol.DeviceID) *De
 	return nil
 }
 
+// RemoveDevice removes the device from the list of devices and from all
+// folders it is shared with.
+func (cfg *Configuration) RemoveDevice(deviceID protocol.DeviceID) {
+	for i := 0; i < len(cfg.Devices); i++ {
+		if cfg.Devices[i].DeviceID == deviceID {
+			cfg.Devices = append(cfg.Devices[:i], cfg.Devices[i+1:]...)
+			i--
+		}
+	}
+	for i := range cfg.Folders {
+		devices := cfg.Folders[i].Devices
+		for j := 0; j < len(devices); j++ {
+			if devices[j].DeviceID == deviceID {
+				devices = append(devices[:j], devices[j+1:]...)
+				j--
+			}
+		}
+		cfg.Folders[i].Devices = devices
+	}
+	for i := range cfg.DeviceGroups {
+		cfg.DeviceGroups[i].removeDevice(deviceID)
+	}
+}
+
+// ReplaceDeviceID moves the configuration of a device, its folder shares and
+// group memberships over to a new device ID.
+func (cfg *Configuration) ReplaceDeviceID(old, new protocol.DeviceID) {
+	for i := range cfg.Devices {
+		if cfg.Devices[i].DeviceID == old {
+			cfg.Devices[i].DeviceID = new
+		}
+	}
+	for i := range cfg.Folders {
+		for j := range cfg.Folders[i].Devices {
+			if cfg.Folders[i].Devices[j].DeviceID == old {
+				cfg.Folders[i].Devices[j].DeviceID = new
+			}
+		}
+		cfg.Folders[i].deviceIDs = nil
+	}
+	for i := range cfg.DeviceGroups {
+		for j, id := range cfg.DeviceGroups[i].Devices {
+			if id == old {
+				cfg.DeviceGroups[i].Devices[j] = new
+			}
+		}
+	}
+}
+
+// IsBlocked returns true if the device is on the blocklist.
+func (cfg *Configuration) IsBlocked(deviceID protocol.DeviceID) bool {
+	for _, id := range cfg.BlockedDevices {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// BlockDevice adds the device to the blocklist and removes it from the list
+// of devices, pending devices and all folders.
+func (cfg *Configuration) BlockDevice(deviceID protocol.DeviceID) {
+	if !cfg.IsBlocked(deviceID) {
+		cfg.BlockedDevices = append(cfg.BlockedDevices, deviceID)
+	}
+	cfg.RemoveDevice(deviceID)
+	for i := 0; i < len(cfg.PendingDevices); i++ {
+		if cfg.PendingDevices[i].DeviceID == deviceID {
+			cfg.PendingDevices = append(cfg.PendingDevices[:i], cfg.PendingDevices[i+1:]...)
+			i--
+		}
+	}
+}
+
+// UnblockDevice removes the device from the blocklist.
+func (cfg *Configuration) UnblockDevice(deviceID protocol.DeviceID) {
+	for i := 0; i < len(cfg.BlockedDevices); i++ {
+		if cfg.BlockedDevices[i] == deviceID {
+			cfg.BlockedDevices = append(cfg.BlockedDevices[:i], cfg.BlockedDevices[i+1:]...)
+			i--
+		}
+	}
+}
+
+func (cfg *Configuration) GetPendingDevice(deviceID protocol.DeviceID) *PendingDeviceConfiguration {
+	for i, device := range cfg.PendingDevices {
+		if device.DeviceID == deviceID {
+			return &cfg.PendingDevices[i]
+		}
+	}
+	return nil
+}
+
 func (cfg *Configuration) GetFolderConfiguration(folderID string) *FolderConfiguration {
 	for i, folder := range cfg.Folders {
 		if folder.ID == folderID {
@@ -247,22 +514,22 @@ func fillNilSlices(data interface{}) error {
 	return nil
 }
 
+// SetLogger sets the logger used for problems with the configuration. A nil
+// logger means the default logger.
+func (cfg *Configuration) SetLogger(log *logger.Logger) {
+	cfg.log = log
+}
+
 func (cfg *Configuration) Save() error {
+	l := cfg.log.Or()
+
 	fd, err := os.Create(cfg.Location + ".tmp")
 	if err != nil {
 		l.Warnln("Saving config:", err)
 		return err
 	}
 
-	e := xml.NewEncoder(fd)
-	e.Indent("", "    ")
-	err = e.Encode(cfg)
-	if err != nil {
-		fd.Close()
-		return err
-	}
-	_, err = fd.Write([]byte("\n"))
-
+	err = cfg.writeXML(fd)
 	if err != nil {
 		l.Warnln("Saving config:", err)
 		fd.Close()
@@ -278,11 +545,25 @@ func (cfg *Configuration) Save() error {
 	err = osutil.Rename(cfg.Location+".tmp", cfg.Location)
 	if err != nil {
 		l.Warnln("Saving config:", err)
+	} else if err = cfg.writeSignature(); err != nil {
+		l.Warnln("Signing config:", err)
 	}
 	events.Default.Log(events.ConfigSaved, cfg)
 	return err
 }
 
+// writeXML writes the configuration in the form it is saved in.
+func (cfg *Configuration) writeXML(w io.Writer) error {
+	cfg.markInherited()
+	e := xml.NewEncoder(w)
+	e.Indent("", "    ")
+	if err := e.Encode(cfg); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte("\n"))
+	return err
+}
+
 func uniqueStrings(ss []string) []string {
 	var m = make(map[string]bool, len(ss))
 	for _, s := range ss {
@@ -298,6 +579,8 @@ func uniqueStrings(ss []string) []string {
 }
 
 func (cfg *Configuration) prepare(myID protocol.DeviceID) {
+	l := cfg.log.Or()
+
 	fillNilSlices(&cfg.Options)
 
 	cfg.Options.ListenAddress = uniqueStrings(cfg.Options.ListenAddress)
@@ -322,6 +605,19 @@ func (cfg *Configuration) prepare(myID protocol.DeviceID) {
 			folder.ID = "default"
 		}
 
+		if !validLinkPolicy(folder.SymlinkPolicy) {
+			l.Warnf("Folder %q: unknown symlink policy %q; skipping symlinks", folder.ID, folder.SymlinkPolicy)
+			folder.SymlinkPolicy = "skip"
+		}
+		if !validLinkPolicy(folder.JunctionPolicy) {
+			l.Warnf("Folder %q: unknown junction policy %q; skipping junctions", folder.ID, folder.JunctionPolicy)
+			folder.JunctionPolicy = "skip"
+		}
+		if folder.ConflictPolicy != "" && folder.ConflictPolicy != "copy" && folder.ConflictPolicy != "overwrite" {
+			l.Warnf("Folder %q: unknown conflict policy %q; keeping conflict copies", folder.ID, folder.ConflictPolicy)
+			folder.ConflictPolicy = "copy"
+		}
+
 		if seen, ok := seenFolders[folder.ID]; ok {
 			l.Warnf("Multiple folders with ID %q; disabling", folder.ID)
 
@@ -338,42 +634,58 @@ func (cfg *Configuration) prepare(myID protocol.DeviceID) {
 		}
 	}
 
-	if cfg.Options.Deprecated_URDeclined {
-		cfg.Options.URAccepted = -1
+	if !validProfile(cfg.Options.DeviceProfile) {
+		l.Warnf("Unknown device profile %q; detecting a suitable one", cfg.Options.DeviceProfile)
+		cfg.Options.DeviceProfile = ProfileAuto
 	}
-	cfg.Options.Deprecated_URDeclined = false
-	cfg.Options.Deprecated_UREnabled = false
 
-	// Upgrade to v2 configuration if appropriate
-	if cfg.Version == 1 {
-		convertV1V2(cfg)
-	}
+	// Upgrade the configuration from earlier versions
+	cfg.migrate()
 
-	// Upgrade to v3 configuration if appropriate
-	if cfg.Version == 2 {
-		convertV2V3(cfg)
-	}
-
-	// Upgrade to v4 configuration if appropriate
-	if cfg.Version == 3 {
-		convertV3V4(cfg)
+	// Folders without a type, as from earlier versions, are typed by their
+	// read only flag
+	for i := range cfg.Folders {
+		folder := &cfg.Folders[i]
+		switch folder.Type {
+		case FolderTypeSendReceive, FolderTypeSendOnly, FolderTypeReceiveEncrypted:
+		default:
+			if folder.Type != "" {
+				l.Warnf("Folder %q: unknown folder type %q", folder.ID, folder.Type)
+			}
+			folder.Type = FolderTypeSendReceive
+			if folder.ReadOnly {
+				folder.Type = FolderTypeSendOnly
+			}
+		}
+		folder.ReadOnly = folder.Type == FolderTypeSendOnly
+
+		// An encrypted folder has nothing to encrypt for others, and we
+		// trust ourselves
+		for j := range folder.Devices {
+			device := &folder.Devices[j]
+			if device.EncryptionPassword != "" && (device.DeviceID == myID || folder.Type == FolderTypeReceiveEncrypted) {
+				l.Warnf("Folder %q: ignoring the encryption password for device %v", folder.ID, device.DeviceID)
+				device.EncryptionPassword = ""
+			}
+		}
 	}
 
-	// Upgrade to v5 configuration if appropriate
-	if cfg.Version == 4 {
-		convertV4V5(cfg)
-	}
+	// Fill in the values folders and devices take from the defaults
+	cfg.applyDefaults()
 
 	// Hash old cleartext passwords
 	if len(cfg.GUI.Password) > 0 && cfg.GUI.Password[0] != '$' {
-		hash, err := bcrypt.GenerateFromPassword([]byte(cfg.GUI.Password), 0)
+		hash, err := HashPassword(cfg.GUI.Password, cfg.GUI.PasswordCost)
 		if err != nil {
 			l.Warnln("bcrypting password:", err)
 		} else {
-			cfg.GUI.Password = string(hash)
+			cfg.GUI.Password = hash
 		}
 	}
 
+	// Hash old cleartext API keys
+	cfg.GUI.APIKey = HashSecret(cfg.GUI.APIKey)
+
 	// Build a list of available devices
 	existingDevices := make(map[protocol.DeviceID]bool)
 	existingDevices[myID] = true
@@ -391,6 +703,7 @@ func (cfg *Configuration) prepare(myID protocol.DeviceID) {
 		})
 	}
 	sort.Sort(DeviceConfigurationList(cfg.Devices))
+	cfg.ApplyDeviceGroups()
 	// Ensure that any loose devices are not present in the wrong places
 	// Ensure that there are no duplicate devices
 	for i := range cfg.Folders {
@@ -410,9 +723,16 @@ func (cfg *Configuration) prepare(myID protocol.DeviceID) {
 }
 
 func New(location string, myID protocol.DeviceID) Configuration {
+	return NewWithLogger(location, myID, nil)
+}
+
+// NewWithLogger is like New, but problems with the configuration are logged
+// to the given logger instead of the default one.
+func NewWithLogger(location string, myID protocol.DeviceID, log *logger.Logger) Configuration {
 	var cfg Configuration
 
 	cfg.Location = location
+	cfg.log = log
 
 	setDefaults(&cfg)
 	setDefaults(&cfg.Options)
@@ -424,9 +744,16 @@ func New(location string, myID protocol.DeviceID) Configuration {
 }
 
 func Load(location string, myID protocol.DeviceID) (Configuration, error) {
+	return LoadWithLogger(location, myID, nil)
+}
+
+// LoadWithLogger is like Load, but problems with the configuration are
+// logged to the given logger instead of the default one.
+func LoadWithLogger(location string, myID protocol.DeviceID, log *logger.Logger) (Configuration, error) {
 	var cfg Configuration
 
 	cfg.Location = location
+	cfg.log = log
 
 	setDefaults(&cfg)
 	setDefaults(&cfg.Options)
@@ -444,144 +771,14 @@ func Load(location string, myID protocol.DeviceID) (Configuration, error) {
 	return cfg, err
 }
 
-// ChangeRequiresRestart returns true if updating the configuration requires a
-// complete restart.
-func ChangeRequiresRestart(from, to Configuration) bool {
-	// Adding, removing or changing folders requires restart
-	if len(from.Folders) != len(to.Folders) {
+func validLinkPolicy(policy string) bool {
+	switch policy {
+	case "", "sync", "skip", "follow", "error":
 		return true
 	}
-	fromFolders := from.FolderMap()
-	toFolders := to.FolderMap()
-	for id := range fromFolders {
-		if !reflect.DeepEqual(fromFolders[id], toFolders[id]) {
-			return true
-		}
-	}
-
-	// Removing a device requires a restart. Adding one does not. Changing
-	// address or name does not.
-	fromDevices := from.DeviceMap()
-	toDevices := to.DeviceMap()
-	for deviceID := range fromDevices {
-		if _, ok := toDevices[deviceID]; !ok {
-			return true
-		}
-	}
-
-	// All of the generic options require restart
-	if !reflect.DeepEqual(from.Options, to.Options) || !reflect.DeepEqual(from.GUI, to.GUI) {
-		return true
-	}
-
 	return false
 }
 
-func convertV4V5(cfg *Configuration) {
-	// Renamed a bunch of fields in the structs.
-	if cfg.Deprecated_Nodes == nil {
-		cfg.Deprecated_Nodes = []DeviceConfiguration{}
-	}
-
-	if cfg.Deprecated_Repositories == nil {
-		cfg.Deprecated_Repositories = []FolderConfiguration{}
-	}
-
-	cfg.Devices = cfg.Deprecated_Nodes
-	cfg.Folders = cfg.Deprecated_Repositories
-
-	for i := range cfg.Folders {
-		cfg.Folders[i].Path = cfg.Folders[i].Deprecated_Directory
-		cfg.Folders[i].Deprecated_Directory = ""
-		cfg.Folders[i].Devices = cfg.Folders[i].Deprecated_Nodes
-		cfg.Folders[i].Deprecated_Nodes = nil
-	}
-
-	cfg.Deprecated_Nodes = nil
-	cfg.Deprecated_Repositories = nil
-
-	cfg.Version = 5
-}
-
-func convertV3V4(cfg *Configuration) {
-	// In previous versions, rescan interval was common for each folder.
-	// From now, it can be set independently. We have to make sure, that after upgrade
-	// the individual rescan interval will be defined for every existing folder.
-	for i := range cfg.Deprecated_Repositories {
-		cfg.Deprecated_Repositories[i].RescanIntervalS = cfg.Options.Deprecated_RescanIntervalS
-	}
-
-	cfg.Options.Deprecated_RescanIntervalS = 0
-
-	// In previous versions, folders held full device configurations.
-	// Since that's the only place where device configs were in V1, we still have
-	// to define the deprecated fields to be able to upgrade from V1 to V4.
-	for i, folder := range cfg.Deprecated_Repositories {
-
-		for j := range folder.Deprecated_Nodes {
-			rncfg := cfg.Deprecated_Repositories[i].Deprecated_Nodes[j]
-			rncfg.Deprecated_Name = ""
-			rncfg.Deprecated_Addresses = nil
-		}
-	}
-
-	cfg.Version = 4
-}
-
-func convertV2V3(cfg *Configuration) {
-	// In previous versions, compression was always on. When upgrading, enable
-	// compression on all existing new. New devices will get compression on by
-	// default by the GUI.
-	for i := range cfg.Deprecated_Nodes {
-		cfg.Deprecated_Nodes[i].Compression = true
-	}
-
-	// The global discovery format and port number changed in v0.9. Having the
-	// default announce server but old port number is guaranteed to be legacy.
-	if cfg.Options.GlobalAnnServer == "announce.syncthing.net:22025" {
-		cfg.Options.GlobalAnnServer = "announce.syncthing.net:22026"
-	}
-
-	cfg.Version = 3
-}
-
-func convertV1V2(cfg *Configuration) {
-	// Collect the list of devices.
-	// Replace device configs inside folders with only a reference to the
-	// device ID. Set all folders to read only if the global read only flag is
-	// set.
-	var devices = map[string]FolderDeviceConfiguration{}
-	for i, folder := range cfg.Deprecated_Repositories {
-		cfg.Deprecated_Repositories[i].ReadOnly = cfg.Options.Deprecated_ReadOnly
-		for j, device := range folder.Deprecated_Nodes {
-			id := device.DeviceID.String()
-			if _, ok := devices[id]; !ok {
-				devices[id] = device
-			}
-			cfg.Deprecated_Repositories[i].Deprecated_Nodes[j] = FolderDeviceConfiguration{DeviceID: device.DeviceID}
-		}
-	}
-	cfg.Options.Deprecated_ReadOnly = false
-
-	// Set and sort the list of devices.
-	for _, device := range devices {
-		cfg.Deprecated_Nodes = append(cfg.Deprecated_Nodes, DeviceConfiguration{
-			DeviceID:  device.DeviceID,
-			Name:      device.Deprecated_Name,
-			Addresses: device.Deprecated_Addresses,
-		})
-	}
-	sort.Sort(DeviceConfigurationList(cfg.Deprecated_Nodes))
-
-	// GUI
-	cfg.GUI.Address = cfg.Options.Deprecated_GUIAddress
-	cfg.GUI.Enabled = cfg.Options.Deprecated_GUIEnabled
-	cfg.Options.Deprecated_GUIEnabled = false
-	cfg.Options.Deprecated_GUIAddress = ""
-
-	cfg.Version = 2
-}
-
 type DeviceConfigurationList []DeviceConfiguration
 
 func (l DeviceConfigurationList) Less(a, b int) bool {
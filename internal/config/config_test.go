@@ -5,11 +5,17 @@
 package config
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/internal/logger"
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
 var device1, device2, device3, device4 protocol.DeviceID
@@ -31,13 +37,27 @@ func TestDefaultValues(t *testing.T) {
 		LocalAnnMCAddr:       "[ff32::5222]:21026",
 		MaxSendKbps:          0,
 		MaxRecvKbps:          0,
+		LimitBurstKiB:        64,
 		ReconnectIntervalS:   60,
 		StartBrowser:         true,
 		UPnPEnabled:          true,
 		UPnPLease:            0,
 		UPnPRenewal:          30,
-		RestartOnWakeup:      true,
+		URGranularity:        "full",
+		ResumeOnWakeup:       true,
 		AutoUpgradeIntervalH: 12,
+		SlowScanS:            300,
+		SlowPullS:            60,
+		SlowIndexS:           10,
+		CrashReportURL:       "https://data.syncthing.net/newcrash",
+		CertRotationDays:     14,
+		TLSMinVersion:        "1.2",
+		ItemEventIntervalMs:  0,
+		DiskIOSlots:          2,
+		NetworkIOSlots:       64,
+		DeviceProfile:        "auto",
+		RemoteUnshare:        "confirm",
+		RelaysEnabled:        true,
 	}
 
 	cfg := New("test", device1)
@@ -59,6 +79,7 @@ func TestDeviceConfig(t *testing.T) {
 				ID:              "test",
 				Path:            "~/Sync",
 				Devices:         []FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device4}},
+				Type:            FolderTypeSendOnly,
 				ReadOnly:        true,
 				RescanIntervalS: 600,
 			},
@@ -101,6 +122,67 @@ func TestDeviceConfig(t *testing.T) {
 	}
 }
 
+func TestMigrations(t *testing.T) {
+	if last := migrations[len(migrations)-1]; last.from+1 != CurrentVersion {
+		t.Errorf("Migrations end at version %d, not %d", last.from+1, CurrentVersion)
+	}
+	if cfg := New("", device1); cfg.Version != CurrentVersion {
+		t.Errorf("New configuration at version %d, not %d", cfg.Version, CurrentVersion)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	in, err := ioutil.ReadFile("testdata/v1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, from, err := Migrate(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 1 {
+		t.Errorf("Migrated from version %d, not 1", from)
+	}
+
+	// The migrated configuration loads the same as the current one
+	path := "testdata/temp.xml"
+	defer os.Remove(path)
+	if err := ioutil.WriteFile(path, out, 0644); err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := Load(path, device1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := Load("testdata/v5.xml", device1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(migrated.Devices, current.Devices) {
+		t.Errorf("Migrated devices differ;\n  A: %#v\n  E: %#v", migrated.Devices, current.Devices)
+	}
+	for i, f := range migrated.Folders {
+		e := current.Folders[i]
+		if f.ID != e.ID || f.Path != e.Path || f.ReadOnly != e.ReadOnly || f.RescanIntervalS != e.RescanIntervalS || !reflect.DeepEqual(f.DeviceIDs(), e.DeviceIDs()) {
+			t.Errorf("Migrated folder differs;\n  A: %#v\n  E: %#v", f, e)
+		}
+	}
+
+	// and migrates to itself
+	again, from, err := Migrate(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != CurrentVersion || !bytes.Equal(again, out) {
+		t.Errorf("Current configuration changed by migrating from version %d", from)
+	}
+
+	in = []byte(fmt.Sprintf(`<configuration version="%d"></configuration>`, CurrentVersion+1))
+	if _, _, err := Migrate(in); err == nil {
+		t.Error("Unexpected nil error migrating a newer version")
+	}
+}
+
 func TestNoListenAddress(t *testing.T) {
 	cfg, err := Load("testdata/nolistenaddress.xml", device1)
 	if err != nil {
@@ -113,6 +195,20 @@ func TestNoListenAddress(t *testing.T) {
 	}
 }
 
+func TestRestartOnWakeup(t *testing.T) {
+	// Turning off restarting on wakeup turns off resuming
+	cfg, err := Load("testdata/restartonwakeup.xml", device1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Options.ResumeOnWakeup {
+		t.Error("Unexpected ResumeOnWakeup")
+	}
+	if cfg.Options.Deprecated_RestartOnWakeup != "" {
+		t.Errorf("Unexpected RestartOnWakeup %q", cfg.Options.Deprecated_RestartOnWakeup)
+	}
+}
+
 func TestOverriddenValues(t *testing.T) {
 	expected := OptionsConfiguration{
 		ListenAddress:        []string{":23000"},
@@ -123,13 +219,33 @@ func TestOverriddenValues(t *testing.T) {
 		LocalAnnMCAddr:       "quux:3232",
 		MaxSendKbps:          1234,
 		MaxRecvKbps:          2341,
+		LimitBurstKiB:        32,
 		ReconnectIntervalS:   6000,
 		StartBrowser:         false,
 		UPnPEnabled:          false,
 		UPnPLease:            60,
 		UPnPRenewal:          15,
-		RestartOnWakeup:      false,
+		URGranularity:        "version",
+		ResumeOnWakeup:       false,
 		AutoUpgradeIntervalH: 24,
+		SlowScanS:            600,
+		SlowPullS:            0,
+		SlowIndexS:           30,
+		CrashReportURL:       "",
+		CertRotationDays:     7,
+		TLSMinVersion:        "1.3",
+		TLSCipherSuites:      []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		GUITLSMinVersion:     "1.1",
+		GUITLSCipherSuites:   []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+		ItemEventIntervalMs:  100,
+		DiskIOSlots:          1,
+		NetworkIOSlots:       16,
+		DeviceProfile:        "low-power",
+		DeviceExpiryDays:     90,
+		DeviceExpiryAction:   "remove",
+		RemoteUnshare:        "refuse",
+		RelaysEnabled:        false,
+		RelayServers:         []string{"relay://relay1.example.com:22067", "relay://relay2.example.com?token=secret"},
 	}
 
 	cfg, err := Load("testdata/overridenvalues.xml", device1)
@@ -232,6 +348,76 @@ func TestVersioningConfig(t *testing.T) {
 	}
 }
 
+func TestStorageConfig(t *testing.T) {
+	cfg, err := Load("testdata/storageconfig.xml", device4)
+	if err != nil {
+		t.Error(err)
+	}
+
+	sc := cfg.Folders[0].Storage
+	if sc.Type != "archive" {
+		t.Errorf(`sc.Type %q != "archive"`, sc.Type)
+	}
+
+	expected := map[string]string{
+		"bucket": "backup",
+	}
+	if !reflect.DeepEqual(sc.Params, expected) {
+		t.Errorf("sc.Params differ;\n  E: %#v\n  A: %#v", expected, sc.Params)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	check := func(cfg Configuration) {
+		if f := cfg.Folders[0]; f.RescanIntervalS != 300 || f.Versioning.Type != "simple" || f.Versioning.Params["keep"] != "5" {
+			t.Errorf("Folder %q did not inherit the defaults: %+v", f.ID, f)
+		}
+		if f := cfg.Folders[1]; f.RescanIntervalS != 60 || f.Versioning.Type != "" {
+			t.Errorf("Folder %q did not override the defaults: %+v", f.ID, f)
+		}
+		if d := cfg.GetDeviceConfiguration(device1); !d.Compression {
+			t.Errorf("Device %v did not override the defaults", d.DeviceID)
+		}
+		if d := cfg.GetDeviceConfiguration(device2); d.Compression {
+			t.Errorf("Device %v did not inherit the defaults", d.DeviceID)
+		}
+	}
+
+	cfg, err := Load("testdata/defaults.xml", device1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(cfg)
+
+	// The inherited values are not written out per folder and device, and
+	// come back the same
+	path := "testdata/temp.xml"
+	defer os.Remove(path)
+	cfg.Location = path
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(bs, []byte(`rescanIntervalS="`)); n != 2 {
+		t.Errorf("Rescan interval written %d times, not 2", n)
+	}
+	if n := bytes.Count(bs, []byte(`<versioning type="simple"`)); n != 1 {
+		t.Errorf("Versioning written %d times, not 1", n)
+	}
+	if n := bytes.Count(bs, []byte(`compression="`)); n != 2 {
+		t.Errorf("Compression written %d times, not 2", n)
+	}
+
+	cfg, err = Load(path, device1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(cfg)
+}
+
 func TestNewSaveLoad(t *testing.T) {
 	path := "testdata/temp.xml"
 	os.Remove(path)
@@ -268,6 +454,7 @@ func TestNewSaveLoad(t *testing.T) {
 	}
 
 	cfg.GUI.User = "test"
+	cfg.BlockedDevices = []protocol.DeviceID{device2}
 	cfg.Save()
 
 	cfg2, err = Load(path, device1)
@@ -295,3 +482,307 @@ func TestPrepare(t *testing.T) {
 		t.Error("Unexpected nil")
 	}
 }
+
+func TestFolderType(t *testing.T) {
+	cfg := Configuration{Folders: []FolderConfiguration{
+		{ID: "ro", Path: "a", ReadOnly: true},
+		{ID: "rw", Path: "b"},
+		{ID: "sendonly", Path: "c", Type: FolderTypeSendOnly},
+		{ID: "sendreceive", Path: "d", Type: FolderTypeSendReceive, ReadOnly: true},
+		{ID: "unknown", Path: "e", Type: "receiveonly", ReadOnly: true},
+		{ID: "encrypted", Path: "f", Type: FolderTypeReceiveEncrypted},
+	}}
+	cfg.prepare(device1)
+
+	expected := map[string]string{
+		"ro":          FolderTypeSendOnly,
+		"rw":          FolderTypeSendReceive,
+		"sendonly":    FolderTypeSendOnly,
+		"sendreceive": FolderTypeSendReceive,
+		"unknown":     FolderTypeSendOnly,
+		"encrypted":   FolderTypeReceiveEncrypted,
+	}
+	for _, f := range cfg.Folders {
+		if f.Type != expected[f.ID] || f.ReadOnly != (f.Type == FolderTypeSendOnly) {
+			t.Errorf("folder %q: unexpected type %q, read only %v", f.ID, f.Type, f.ReadOnly)
+		}
+	}
+}
+
+func TestFolderEncryptionPassword(t *testing.T) {
+	cfg := Configuration{
+		Devices: []DeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}, {DeviceID: device3}},
+	}
+	cfg.Folders = []FolderConfiguration{
+		{ID: "plain", Path: "a", Devices: []FolderDeviceConfiguration{
+			{DeviceID: device1, EncryptionPassword: "mine"},
+			{DeviceID: device2, EncryptionPassword: "secret"},
+			{DeviceID: device3},
+		}},
+		{ID: "encrypted", Path: "b", Type: FolderTypeReceiveEncrypted, Devices: []FolderDeviceConfiguration{
+			{DeviceID: device2, EncryptionPassword: "secret"},
+		}},
+	}
+	cfg.prepare(device1)
+
+	plain := cfg.GetFolderConfiguration("plain")
+	if plain.EncryptionKey(device1) != nil {
+		t.Error("Encrypting for ourselves")
+	}
+	if plain.EncryptionKey(device3) != nil {
+		t.Error("Encrypting for a trusted device")
+	}
+	key := plain.EncryptionKey(device2)
+	if key == nil {
+		t.Fatal("Not encrypting for an untrusted device")
+	}
+	if name, err := key.DecryptName(protocol.NewEncryptionKey("plain", "secret").EncryptName("file")); err != nil || name != "file" {
+		t.Errorf("Unexpected key: %q, %v", name, err)
+	}
+
+	if cfg.GetFolderConfiguration("encrypted").EncryptionKey(device2) != nil {
+		t.Error("Encrypting an encrypted folder")
+	}
+}
+
+func TestRemoveDevice(t *testing.T) {
+	cfg := New("test", device1)
+	cfg.Devices = append(cfg.Devices, DeviceConfiguration{DeviceID: device2}, DeviceConfiguration{DeviceID: device3})
+	cfg.Folders = []FolderConfiguration{
+		{
+			ID:      "f1",
+			Devices: []FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}},
+		},
+		{
+			ID:      "f2",
+			Devices: []FolderDeviceConfiguration{{DeviceID: device2}, {DeviceID: device3}},
+		},
+	}
+
+	cfg.RemoveDevice(device2)
+
+	if cfg.GetDeviceConfiguration(device2) != nil {
+		t.Error("Device still present")
+	}
+	if cfg.GetDeviceConfiguration(device1) == nil || cfg.GetDeviceConfiguration(device3) == nil {
+		t.Error("Wrong device removed")
+	}
+	expected := []FolderDeviceConfiguration{{DeviceID: device1}}
+	if f := cfg.GetFolderConfiguration("f1"); !reflect.DeepEqual(f.Devices, expected) {
+		t.Errorf("Incorrect folder devices %v", f.Devices)
+	}
+	expected = []FolderDeviceConfiguration{{DeviceID: device3}}
+	if f := cfg.GetFolderConfiguration("f2"); !reflect.DeepEqual(f.Devices, expected) {
+		t.Errorf("Incorrect folder devices %v", f.Devices)
+	}
+}
+
+func TestReplaceDeviceID(t *testing.T) {
+	cfg := New("test", device1)
+	cfg.Devices = append(cfg.Devices, DeviceConfiguration{DeviceID: device2})
+	cfg.DeviceGroups = []DeviceGroupConfiguration{
+		{Name: "office", Devices: []protocol.DeviceID{device1, device2}},
+	}
+	cfg.Folders = []FolderConfiguration{
+		{
+			ID:      "f1",
+			Devices: []FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}},
+		},
+	}
+
+	cfg.ReplaceDeviceID(device1, device3)
+
+	if cfg.GetDeviceConfiguration(device1) != nil || cfg.GetDeviceConfiguration(device3) == nil {
+		t.Error("Device not replaced")
+	}
+	expected := []FolderDeviceConfiguration{{DeviceID: device3}, {DeviceID: device2}}
+	if f := cfg.GetFolderConfiguration("f1"); !reflect.DeepEqual(f.Devices, expected) {
+		t.Errorf("Incorrect folder devices %v", f.Devices)
+	}
+	if ids := cfg.GetFolderConfiguration("f1").DeviceIDs(); !reflect.DeepEqual(ids, []protocol.DeviceID{device3, device2}) {
+		t.Errorf("Incorrect folder device IDs %v", ids)
+	}
+	if g := cfg.GetDeviceGroup("office"); !reflect.DeepEqual(g.Devices, []protocol.DeviceID{device3, device2}) {
+		t.Errorf("Incorrect group devices %v", g.Devices)
+	}
+}
+
+func TestBlockDevice(t *testing.T) {
+	cfg := New("test", device1)
+	cfg.Devices = append(cfg.Devices, DeviceConfiguration{DeviceID: device2})
+	cfg.PendingDevices = []PendingDeviceConfiguration{{DeviceID: device3}}
+	cfg.Folders = []FolderConfiguration{
+		{
+			ID:      "f1",
+			Devices: []FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}},
+		},
+	}
+
+	cfg.BlockDevice(device2)
+	cfg.BlockDevice(device2)
+	cfg.BlockDevice(device3)
+
+	if !cfg.IsBlocked(device2) || !cfg.IsBlocked(device3) || cfg.IsBlocked(device1) {
+		t.Errorf("Incorrect blocklist %v", cfg.BlockedDevices)
+	}
+	if len(cfg.BlockedDevices) != 2 {
+		t.Errorf("Duplicate entries in blocklist %v", cfg.BlockedDevices)
+	}
+	if cfg.GetDeviceConfiguration(device2) != nil || len(cfg.Folders[0].Devices) != 1 {
+		t.Error("Blocked device still configured")
+	}
+	if cfg.GetPendingDevice(device3) != nil {
+		t.Error("Blocked device still pending")
+	}
+
+	cfg.UnblockDevice(device2)
+	if cfg.IsBlocked(device2) || !cfg.IsBlocked(device3) {
+		t.Errorf("Incorrect blocklist after unblock %v", cfg.BlockedDevices)
+	}
+}
+
+func TestDeviceGroups(t *testing.T) {
+	cfg := New("test", device1)
+	cfg.Devices = append(cfg.Devices, DeviceConfiguration{DeviceID: device2}, DeviceConfiguration{DeviceID: device3})
+	cfg.DeviceGroups = []DeviceGroupConfiguration{
+		{Name: "office", Devices: []protocol.DeviceID{device2, device3, device4}},
+	}
+	cfg.Folders = []FolderConfiguration{
+		{
+			ID:      "f1",
+			Groups:  []string{"office", "missing"},
+			Devices: []FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device3}},
+		},
+	}
+
+	// Unconfigured members are skipped, explicit shares are kept as they are
+	cfg.ApplyDeviceGroups()
+	expected := []FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device3}, {DeviceID: device2, Group: "office"}}
+	if f := cfg.GetFolderConfiguration("f1"); !reflect.DeepEqual(f.Devices, expected) {
+		t.Errorf("Incorrect folder devices %v", f.Devices)
+	}
+
+	// Future members are shared with as well
+	cfg.Devices = append(cfg.Devices, DeviceConfiguration{DeviceID: device4})
+	cfg.ApplyDeviceGroups()
+	expected = append(expected, FolderDeviceConfiguration{DeviceID: device4, Group: "office"})
+	if f := cfg.GetFolderConfiguration("f1"); !reflect.DeepEqual(f.Devices, expected) {
+		t.Errorf("Incorrect folder devices %v", f.Devices)
+	}
+
+	// Leaving the group removes the share, unless it was made explicitly
+	cfg.DeviceGroups[0].Devices = []protocol.DeviceID{device4}
+	cfg.ApplyDeviceGroups()
+	expected = []FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device3}, {DeviceID: device4, Group: "office"}}
+	if f := cfg.GetFolderConfiguration("f1"); !reflect.DeepEqual(f.Devices, expected) {
+		t.Errorf("Incorrect folder devices %v", f.Devices)
+	}
+
+	// Removing a device drops it from the groups too
+	cfg.RemoveDevice(device4)
+	if len(cfg.GetDeviceGroup("office").Devices) != 0 {
+		t.Errorf("Removed device still in group %v", cfg.GetDeviceGroup("office").Devices)
+	}
+
+	// Unsharing the group removes its shares
+	cfg.DeviceGroups[0].Devices = []protocol.DeviceID{device2}
+	cfg.ApplyDeviceGroups()
+	cfg.Folders[0].Groups = nil
+	cfg.ApplyDeviceGroups()
+	expected = []FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device3}}
+	if f := cfg.GetFolderConfiguration("f1"); !reflect.DeepEqual(f.Devices, expected) {
+		t.Errorf("Incorrect folder devices %v", f.Devices)
+	}
+}
+
+func TestDetectProfile(t *testing.T) {
+	cases := []struct {
+		cpus    int
+		memory  uint64
+		profile string
+	}{
+		{1, 0, ProfileLowPower},
+		{2, 1536 << 20, ProfileLowPower},
+		{2, 8 << 30, ProfileDefault},
+		{4, 512 << 20, ProfileLowPower},
+		{4, 0, ProfileDefault},
+		{4, 4 << 30, ProfileDefault},
+		{16, 0, ProfileDefault},
+		{16, 32 << 30, ProfileServer},
+	}
+
+	for _, tc := range cases {
+		if p := DetectProfile(tc.cpus, tc.memory); p != tc.profile {
+			t.Errorf("DetectProfile(%d, %d) = %q, expected %q", tc.cpus, tc.memory, p, tc.profile)
+		}
+		if _, ok := Profiles[tc.profile]; !ok {
+			t.Errorf("detected profile %q does not exist", tc.profile)
+		}
+	}
+}
+
+func TestChangedCommands(t *testing.T) {
+	from := New("test", device1)
+
+	to := from
+	to.Options.MaxSendKbps = 100
+	if changed := ChangedCommands(from, to); len(changed) != 0 {
+		t.Errorf("Unexpected changed commands %v", changed)
+	}
+
+	to.GUI.ACMEDNSHook = "/bin/sh -c id"
+	if changed := ChangedCommands(from, to); !reflect.DeepEqual(changed, []string{"acmeDNSHook"}) {
+		t.Errorf("Unexpected changed commands %v", changed)
+	}
+
+	to.GUI.AuthCommand = "/usr/bin/true"
+	if changed := ChangedCommands(from, to); !reflect.DeepEqual(changed, []string{"acmeDNSHook", "authCommand"}) {
+		t.Errorf("Unexpected changed commands %v", changed)
+	}
+
+	// A new folder with a hook, an existing one keeping its hook
+	from = New("test", device1)
+	from.Folders = []FolderConfiguration{{ID: "f1", PreHook: "/bin/pre"}}
+	to = from
+	to.Folders = []FolderConfiguration{{ID: "f1", PreHook: "/bin/pre"}, {ID: "f2", PostHook: "/bin/post"}}
+	if changed := ChangedCommands(from, to); !reflect.DeepEqual(changed, []string{"postHook"}) {
+		t.Errorf("Unexpected changed commands %v", changed)
+	}
+	to.Folders = []FolderConfiguration{{ID: "f1", PreHook: "/bin/sh"}}
+	if changed := ChangedCommands(from, to); !reflect.DeepEqual(changed, []string{"preHook"}) {
+		t.Errorf("Unexpected changed commands %v", changed)
+	}
+}
+
+func TestLoadWithLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.xml")
+	xml := `<configuration version="5"><options><deviceProfile>bogus</deviceProfile></options></configuration>`
+	if err := ioutil.WriteFile(path, []byte(xml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	cfg, err := LoadWithLogger(path, device1, logger.NewWriter(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Unknown device profile") {
+		t.Errorf("Expected a warning in the given logger, got %q", buf.String())
+	}
+
+	// The logger sticks with the configuration
+	buf.Reset()
+	cfg.Location = filepath.Join(dir, "missing", "config.xml")
+	if err := cfg.Save(); err == nil {
+		t.Error("Unexpected nil error saving to a missing directory")
+	}
+	if !strings.Contains(buf.String(), "Saving config") {
+		t.Errorf("Expected a warning in the given logger, got %q", buf.String())
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/xml"
+	"reflect"
+)
+
+// The defaults block gives values for the folders and devices that leave
+// them out. They are filled in by prepare, and a value that equals the
+// default is left out again when the configuration is saved, so that the
+// default stays in one place.
+
+type DefaultsConfiguration struct {
+	Folder FolderDefaultsConfiguration `xml:"folder"`
+	Device DeviceDefaultsConfiguration `xml:"device"`
+}
+
+type FolderDefaultsConfiguration struct {
+	RescanIntervalS int                      `xml:"rescanIntervalS,attr,omitempty"` // 0 for no default
+	Versioning      *VersioningConfiguration `xml:"versioning"`                     // nil for no default
+}
+
+type DeviceDefaultsConfiguration struct {
+	Compression *bool `xml:"compression,attr,omitempty"` // nil for no default
+}
+
+// The XML forms of folders and devices, telling whether the values that may
+// come from the defaults are given.
+
+type plainFolderConfiguration FolderConfiguration
+
+type folderXML struct {
+	*plainFolderConfiguration
+	RescanIntervalS *int                     `xml:"rescanIntervalS,attr"`
+	Versioning      *VersioningConfiguration `xml:"versioning"`
+}
+
+type plainDeviceConfiguration DeviceConfiguration
+
+type deviceXML struct {
+	*plainDeviceConfiguration
+	Compression *bool `xml:"compression,attr"`
+}
+
+func (f *FolderConfiguration) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if !f.inheritRescanInterval && !f.inheritVersioning {
+		return e.EncodeElement((*plainFolderConfiguration)(f), start)
+	}
+	tmp := folderXML{plainFolderConfiguration: (*plainFolderConfiguration)(f)}
+	if !f.inheritRescanInterval {
+		tmp.RescanIntervalS = &f.RescanIntervalS
+	}
+	if !f.inheritVersioning {
+		tmp.Versioning = &f.Versioning
+	}
+	return e.EncodeElement(tmp, start)
+}
+
+func (f *FolderConfiguration) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	tmp := folderXML{plainFolderConfiguration: (*plainFolderConfiguration)(f)}
+	if err := d.DecodeElement(&tmp, &start); err != nil {
+		return err
+	}
+	f.inheritRescanInterval = tmp.RescanIntervalS == nil
+	if tmp.RescanIntervalS != nil {
+		f.RescanIntervalS = *tmp.RescanIntervalS
+	}
+	f.inheritVersioning = tmp.Versioning == nil
+	if tmp.Versioning != nil {
+		f.Versioning = *tmp.Versioning
+	}
+	return nil
+}
+
+func (c *DeviceConfiguration) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if !c.inheritCompression {
+		return e.EncodeElement((*plainDeviceConfiguration)(c), start)
+	}
+	return e.EncodeElement(deviceXML{plainDeviceConfiguration: (*plainDeviceConfiguration)(c)}, start)
+}
+
+func (c *DeviceConfiguration) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	tmp := deviceXML{plainDeviceConfiguration: (*plainDeviceConfiguration)(c)}
+	if err := d.DecodeElement(&tmp, &start); err != nil {
+		return err
+	}
+	c.inheritCompression = tmp.Compression == nil
+	if tmp.Compression != nil {
+		c.Compression = *tmp.Compression
+	}
+	return nil
+}
+
+// applyDefaults fills in the default values for the folders and devices that
+// leave them out.
+func (cfg *Configuration) applyDefaults() {
+	var defaults DefaultsConfiguration
+	if cfg.Defaults != nil {
+		defaults = *cfg.Defaults
+	}
+
+	for i := range cfg.Folders {
+		folder := &cfg.Folders[i]
+		folder.inheritRescanInterval = folder.inheritRescanInterval && defaults.Folder.RescanIntervalS > 0
+		if folder.inheritRescanInterval {
+			folder.RescanIntervalS = defaults.Folder.RescanIntervalS
+		}
+		folder.inheritVersioning = folder.inheritVersioning && defaults.Folder.Versioning != nil
+		if folder.inheritVersioning {
+			folder.Versioning = copyVersioning(*defaults.Folder.Versioning)
+		}
+	}
+
+	for i := range cfg.Devices {
+		device := &cfg.Devices[i]
+		device.inheritCompression = device.inheritCompression && defaults.Device.Compression != nil
+		if device.inheritCompression {
+			device.Compression = *defaults.Device.Compression
+		}
+	}
+}
+
+// markInherited sets the folders and devices up to leave out the values
+// that equal the defaults when saved.
+func (cfg *Configuration) markInherited() {
+	var defaults DefaultsConfiguration
+	if cfg.Defaults != nil {
+		defaults = *cfg.Defaults
+	}
+
+	for i := range cfg.Folders {
+		folder := &cfg.Folders[i]
+		folder.inheritRescanInterval = defaults.Folder.RescanIntervalS > 0 && folder.RescanIntervalS == defaults.Folder.RescanIntervalS
+		folder.inheritVersioning = defaults.Folder.Versioning != nil && sameVersioning(folder.Versioning, *defaults.Folder.Versioning)
+	}
+
+	for i := range cfg.Devices {
+		device := &cfg.Devices[i]
+		device.inheritCompression = defaults.Device.Compression != nil && device.Compression == *defaults.Device.Compression
+	}
+}
+
+func copyVersioning(v VersioningConfiguration) VersioningConfiguration {
+	params := make(map[string]string, len(v.Params))
+	for k, val := range v.Params {
+		params[k] = val
+	}
+	return VersioningConfiguration{Type: v.Type, Params: params}
+}
+
+func sameVersioning(a, b VersioningConfiguration) bool {
+	if a.Type != b.Type || len(a.Params) != len(b.Params) {
+		return false
+	}
+	return len(a.Params) == 0 || reflect.DeepEqual(a.Params, b.Params)
+}
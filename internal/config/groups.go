@@ -0,0 +1,93 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import "github.com/syncthing/syncthing/lib/protocol"
+
+// A DeviceGroupConfiguration is a named set of devices. A folder shared with
+// the group is shared with each of its members, including the ones that join
+// the group later on.
+type DeviceGroupConfiguration struct {
+	Name    string              `xml:"name,attr"`
+	Devices []protocol.DeviceID `xml:"device"`
+}
+
+func (g *DeviceGroupConfiguration) removeDevice(deviceID protocol.DeviceID) {
+	for i := 0; i < len(g.Devices); i++ {
+		if g.Devices[i] == deviceID {
+			g.Devices = append(g.Devices[:i], g.Devices[i+1:]...)
+			i--
+		}
+	}
+}
+
+// GetDeviceGroup returns the device group with the given name, or nil if
+// there is none.
+func (cfg *Configuration) GetDeviceGroup(name string) *DeviceGroupConfiguration {
+	for i, group := range cfg.DeviceGroups {
+		if group.Name == name {
+			return &cfg.DeviceGroups[i]
+		}
+	}
+	return nil
+}
+
+// ApplyDeviceGroups shares each folder with the configured devices in the
+// groups it is shared with. Shares added for a group are removed again once
+// the device has left the group, or the folder is no longer shared with the
+// group; shares added any other way are left alone.
+func (cfg *Configuration) ApplyDeviceGroups() {
+	known := make(map[protocol.DeviceID]bool, len(cfg.Devices))
+	for _, device := range cfg.Devices {
+		known[device.DeviceID] = true
+	}
+
+	for i := range cfg.Folders {
+		folder := &cfg.Folders[i]
+
+		// A device in several of the folder's groups is shared with on
+		// account of the first one listed.
+		viaGroup := make(map[protocol.DeviceID]string)
+		var members []protocol.DeviceID
+		for _, name := range folder.Groups {
+			group := cfg.GetDeviceGroup(name)
+			if group == nil {
+				continue
+			}
+			for _, id := range group.Devices {
+				if _, ok := viaGroup[id]; ok || !known[id] {
+					continue
+				}
+				viaGroup[id] = name
+				members = append(members, id)
+			}
+		}
+
+		shared := make(map[protocol.DeviceID]bool)
+		kept := folder.Devices[:0]
+		for _, device := range folder.Devices {
+			if device.Group != "" {
+				group, ok := viaGroup[device.DeviceID]
+				if !ok {
+					continue
+				}
+				device.Group = group
+			}
+			kept = append(kept, device)
+			shared[device.DeviceID] = true
+		}
+		for _, id := range members {
+			if !shared[id] {
+				kept = append(kept, FolderDeviceConfiguration{
+					DeviceID: id,
+					Group:    viaGroup[id],
+				})
+			}
+		}
+
+		folder.Devices = kept
+		folder.deviceIDs = nil
+	}
+}
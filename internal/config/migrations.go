@@ -0,0 +1,194 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+)
+
+// CurrentVersion is the version of the configurations written by this
+// release.
+const CurrentVersion = 5
+
+// Configurations written by earlier releases are upgraded one version at a
+// time, by the migrations registered in order of the version they upgrade
+// from.
+
+type migration struct {
+	from    int
+	convert func(cfg *Configuration)
+}
+
+var migrations []migration
+
+func init() {
+	registerMigration(1, convertV1V2)
+	registerMigration(2, convertV2V3)
+	registerMigration(3, convertV3V4)
+	registerMigration(4, convertV4V5)
+}
+
+// registerMigration adds the migration from the given version to the next
+// one. Migrations must be registered in order.
+func registerMigration(from int, convert func(cfg *Configuration)) {
+	if n := len(migrations); n > 0 && migrations[n-1].from != from-1 {
+		panic(fmt.Sprintf("config: migration from version %d registered after the one from %d", from, migrations[n-1].from))
+	}
+	migrations = append(migrations, migration{from, convert})
+}
+
+// migrate upgrades the configuration to the current version, and moves the
+// values of deprecated options over to the ones that replaced them.
+func (cfg *Configuration) migrate() {
+	if cfg.Options.Deprecated_URDeclined {
+		cfg.Options.URAccepted = -1
+	}
+	cfg.Options.Deprecated_URDeclined = false
+	cfg.Options.Deprecated_UREnabled = false
+
+	// Restarting on wakeup gave way to resuming in place
+	if cfg.Options.Deprecated_RestartOnWakeup == "false" {
+		cfg.Options.ResumeOnWakeup = false
+	}
+	cfg.Options.Deprecated_RestartOnWakeup = ""
+
+	for _, m := range migrations {
+		if cfg.Version == m.from {
+			m.convert(cfg)
+			cfg.Version = m.from + 1
+		}
+	}
+}
+
+// Migrate upgrades a configuration in XML form to the current version, for
+// use without loading it. It returns the upgraded configuration, which is
+// unchanged apart from the options with default values being filled in if
+// it was current already, and the version it was at.
+func Migrate(in []byte) ([]byte, int, error) {
+	var cfg Configuration
+	setDefaults(&cfg)
+	setDefaults(&cfg.Options)
+	setDefaults(&cfg.GUI)
+
+	if err := xml.Unmarshal(in, &cfg); err != nil {
+		return nil, 0, err
+	}
+	from := cfg.Version
+	if from > CurrentVersion {
+		return nil, from, fmt.Errorf("version %d is newer than the supported version %d", from, CurrentVersion)
+	}
+
+	cfg.migrate()
+
+	var buf bytes.Buffer
+	if err := cfg.writeXML(&buf); err != nil {
+		return nil, from, err
+	}
+	return buf.Bytes(), from, nil
+}
+
+func convertV1V2(cfg *Configuration) {
+	// Collect the list of devices.
+	// Replace device configs inside folders with only a reference to the
+	// device ID. Set all folders to read only if the global read only flag is
+	// set.
+	var devices = map[string]FolderDeviceConfiguration{}
+	for i, folder := range cfg.Deprecated_Repositories {
+		cfg.Deprecated_Repositories[i].ReadOnly = cfg.Options.Deprecated_ReadOnly
+		for j, device := range folder.Deprecated_Nodes {
+			id := device.DeviceID.String()
+			if _, ok := devices[id]; !ok {
+				devices[id] = device
+			}
+			cfg.Deprecated_Repositories[i].Deprecated_Nodes[j] = FolderDeviceConfiguration{DeviceID: device.DeviceID}
+		}
+	}
+	cfg.Options.Deprecated_ReadOnly = false
+
+	// Set and sort the list of devices.
+	for _, device := range devices {
+		cfg.Deprecated_Nodes = append(cfg.Deprecated_Nodes, DeviceConfiguration{
+			DeviceID:  device.DeviceID,
+			Name:      device.Deprecated_Name,
+			Addresses: device.Deprecated_Addresses,
+		})
+	}
+	sort.Sort(DeviceConfigurationList(cfg.Deprecated_Nodes))
+
+	// GUI
+	cfg.GUI.Address = cfg.Options.Deprecated_GUIAddress
+	cfg.GUI.Enabled = cfg.Options.Deprecated_GUIEnabled
+	cfg.Options.Deprecated_GUIEnabled = false
+	cfg.Options.Deprecated_GUIAddress = ""
+
+}
+
+func convertV2V3(cfg *Configuration) {
+	// In previous versions, compression was always on. When upgrading, enable
+	// compression on all existing new. New devices will get compression on by
+	// default by the GUI.
+	for i := range cfg.Deprecated_Nodes {
+		cfg.Deprecated_Nodes[i].Compression = true
+	}
+
+	// The global discovery format and port number changed in v0.9. Having the
+	// default announce server but old port number is guaranteed to be legacy.
+	if cfg.Options.GlobalAnnServer == "announce.syncthing.net:22025" {
+		cfg.Options.GlobalAnnServer = "announce.syncthing.net:22026"
+	}
+
+}
+
+func convertV3V4(cfg *Configuration) {
+	// In previous versions, rescan interval was common for each folder.
+	// From now, it can be set independently. We have to make sure, that after upgrade
+	// the individual rescan interval will be defined for every existing folder.
+	for i := range cfg.Deprecated_Repositories {
+		cfg.Deprecated_Repositories[i].RescanIntervalS = cfg.Options.Deprecated_RescanIntervalS
+	}
+
+	cfg.Options.Deprecated_RescanIntervalS = 0
+
+	// In previous versions, folders held full device configurations.
+	// Since that's the only place where device configs were in V1, we still have
+	// to define the deprecated fields to be able to upgrade from V1 to V4.
+	for i, folder := range cfg.Deprecated_Repositories {
+
+		for j := range folder.Deprecated_Nodes {
+			rncfg := cfg.Deprecated_Repositories[i].Deprecated_Nodes[j]
+			rncfg.Deprecated_Name = ""
+			rncfg.Deprecated_Addresses = nil
+		}
+	}
+
+}
+
+func convertV4V5(cfg *Configuration) {
+	// Renamed a bunch of fields in the structs.
+	if cfg.Deprecated_Nodes == nil {
+		cfg.Deprecated_Nodes = []DeviceConfiguration{}
+	}
+
+	if cfg.Deprecated_Repositories == nil {
+		cfg.Deprecated_Repositories = []FolderConfiguration{}
+	}
+
+	cfg.Devices = cfg.Deprecated_Nodes
+	cfg.Folders = cfg.Deprecated_Repositories
+
+	for i := range cfg.Folders {
+		cfg.Folders[i].Path = cfg.Folders[i].Deprecated_Directory
+		cfg.Folders[i].Deprecated_Directory = ""
+		cfg.Folders[i].Devices = cfg.Folders[i].Deprecated_Nodes
+		cfg.Folders[i].Deprecated_Nodes = nil
+	}
+
+	cfg.Deprecated_Nodes = nil
+	cfg.Deprecated_Repositories = nil
+
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+
+	"code.google.com/p/go.crypto/bcrypt"
+)
+
+// Passwords at least this long are accepted regardless of which kinds of
+// characters they contain.
+const passphraseLength = 16
+
+var ErrWeakPassword = errors.New("password must contain at least two of lower case letters, upper case letters, digits and other characters")
+
+// HashPassword returns the bcrypt hash of the password, using the given
+// cost or the bcrypt default if the cost is zero.
+func HashPassword(password string, cost int) (string, error) {
+	if cost != 0 && (cost < bcrypt.MinCost || cost > bcrypt.MaxCost) {
+		return "", fmt.Errorf("bcrypt cost %d outside of allowed range %d-%d", cost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPasswordStrength returns an error if the password is shorter than
+// minLength, or is shorter than a passphrase and made up of only one kind of
+// characters. A minLength of zero disables the check.
+func CheckPasswordStrength(password string, minLength int) error {
+	if minLength <= 0 {
+		return nil
+	}
+
+	if len([]rune(password)) < minLength {
+		return fmt.Errorf("password must be at least %d characters long", minLength)
+	}
+	if len([]rune(password)) >= passphraseLength {
+		return nil
+	}
+
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if lower+upper+digit+other < 2 {
+		return ErrWeakPassword
+	}
+	return nil
+}
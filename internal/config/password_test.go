@@ -0,0 +1,57 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"testing"
+
+	"code.google.com/p/go.crypto/bcrypt"
+)
+
+func TestCheckPasswordStrength(t *testing.T) {
+	cases := []struct {
+		password string
+		ok       bool
+	}{
+		{"", false},
+		{"aB3", false},
+		{"abcdefgh", false},
+		{"12345678", false},
+		{"abcdefg1", true},
+		{"ABCDEFG!", true},
+		{"correcthorsebatterystaple", true},
+		{"åäöåäöåäöÅ", true},
+	}
+
+	for _, tc := range cases {
+		err := CheckPasswordStrength(tc.password, 8)
+		if tc.ok && err != nil {
+			t.Errorf("%q: unexpected error %v", tc.password, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("%q: unexpected success", tc.password)
+		}
+	}
+
+	if err := CheckPasswordStrength("a", 0); err != nil {
+		t.Error("Check should be disabled with zero length:", err)
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("secret", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, err := bcrypt.Cost([]byte(hash)); err != nil || cost != bcrypt.MinCost {
+		t.Errorf("Unexpected cost %d, %v", cost, err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte("secret")); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := HashPassword("secret", bcrypt.MaxCost+1); err == nil {
+		t.Error("Unexpected success with too high cost")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+const (
+	ProfileAuto     = "auto"
+	ProfileLowPower = "low-power"
+	ProfileDefault  = "default"
+	ProfileServer   = "server"
+)
+
+// A Profile is a set of performance settings suited to a class of devices,
+// so that users can pick one instead of tuning each setting separately.
+type Profile struct {
+	Hashers     int // hashing routines per folder scan; 0 for one per CPU
+	Copiers     int // copying routines per folder
+	Pullers     int // block requesting routines per folder
+	Finishers   int // verifying and renaming routines per folder
+	DBCacheMiB  int // size of the database block cache
+	DBOpenFiles int // number of database files kept open
+	ProgressS   int // how often transfer progress is sampled for the history
+}
+
+var Profiles = map[string]Profile{
+	ProfileLowPower: {
+		Hashers:     1,
+		Copiers:     1,
+		Pullers:     4,
+		Finishers:   1,
+		DBCacheMiB:  2,
+		DBOpenFiles: 32,
+		ProgressS:   120,
+	},
+	ProfileDefault: {
+		Hashers:     0,
+		Copiers:     1,
+		Pullers:     16,
+		Finishers:   2,
+		DBCacheMiB:  8,
+		DBOpenFiles: 100,
+		ProgressS:   30,
+	},
+	ProfileServer: {
+		Hashers:     0,
+		Copiers:     2,
+		Pullers:     32,
+		Finishers:   4,
+		DBCacheMiB:  64,
+		DBOpenFiles: 500,
+		ProgressS:   10,
+	},
+}
+
+// DetectProfile returns the name of the profile best suited to a device with
+// the given number of CPUs and bytes of memory. A memory size of zero means
+// that it is unknown. Few CPUs alone don't make a low-power device, as small
+// virtual machines often have plenty of memory.
+func DetectProfile(cpus int, memory uint64) string {
+	switch {
+	case memory > 0 && memory < 1<<30:
+		return ProfileLowPower
+	case cpus <= 2 && (memory == 0 || memory < 2<<30):
+		return ProfileLowPower
+	case cpus >= 8 && memory >= 8<<30:
+		return ProfileServer
+	default:
+		return ProfileDefault
+	}
+}
+
+func validProfile(name string) bool {
+	if name == ProfileAuto {
+		return true
+	}
+	_, ok := Profiles[name]
+	return ok
+}
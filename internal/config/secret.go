@@ -0,0 +1,73 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// Shared secrets such as the API key are stored hashed in the config file,
+// so that it can be shared without giving away credentials. Unlike
+// passwords these are long random strings, so a plain SHA-256 hash suffices
+// and they can be checked on every request without noticeable cost.
+
+const secretHashPrefix = "sha256:"
+
+const secretChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// NewSecret returns a new random secret of the given length.
+func NewSecret(length int) string {
+	// Random bytes above the largest multiple of len(secretChars) are
+	// discarded so that all characters are equally likely.
+	max := 256 - 256%len(secretChars)
+	secret := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(secret) < length {
+		if _, err := rand.Read(buf); err != nil {
+			panic(err)
+		}
+		for _, b := range buf {
+			if int(b) < max && len(secret) < length {
+				secret = append(secret, secretChars[int(b)%len(secretChars)])
+			}
+		}
+	}
+	return string(secret)
+}
+
+// HashSecret returns the hashed form of the secret for storing in the
+// config. Secrets that are already hashed are returned unchanged.
+func HashSecret(secret string) string {
+	if secret == "" || IsHashedSecret(secret) {
+		return secret
+	}
+	hash := sha256.Sum256([]byte(secret))
+	return secretHashPrefix + hex.EncodeToString(hash[:])
+}
+
+// IsHashedSecret returns true if the secret is in hashed form.
+func IsHashedSecret(secret string) bool {
+	return strings.HasPrefix(secret, secretHashPrefix)
+}
+
+// SecretMatches returns true if the given cleartext secret matches the
+// stored one, which may be hashed or, when given on the command line or in
+// the environment, in cleartext. An empty stored secret matches nothing.
+func SecretMatches(stored, given string) bool {
+	if stored == "" || given == "" {
+		return false
+	}
+	if IsHashedSecret(stored) {
+		// Always hash what we are given, so that knowing the hash is not
+		// enough to authenticate.
+		hash := sha256.Sum256([]byte(given))
+		given = secretHashPrefix + hex.EncodeToString(hash[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(given)) == 1
+}
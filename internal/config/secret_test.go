@@ -0,0 +1,49 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import "testing"
+
+func TestSecretMatches(t *testing.T) {
+	secret := NewSecret(32)
+	if len(secret) != 32 {
+		t.Fatalf("Unexpected secret length %d", len(secret))
+	}
+
+	hashed := HashSecret(secret)
+	if !IsHashedSecret(hashed) || hashed == secret {
+		t.Fatalf("Secret %q not hashed: %q", secret, hashed)
+	}
+	if HashSecret(hashed) != hashed {
+		t.Error("Hashing a hashed secret should be a no-op")
+	}
+
+	cases := []struct {
+		stored, given string
+		match         bool
+	}{
+		{hashed, secret, true},
+		{secret, secret, true},
+		{hashed, hashed, false},
+		{hashed, "wrong", false},
+		{secret, "wrong", false},
+		{"", "", false},
+		{hashed, "", false},
+	}
+	for i, tc := range cases {
+		if m := SecretMatches(tc.stored, tc.given); m != tc.match {
+			t.Errorf("%d: SecretMatches(%q, %q) = %v, expected %v", i, tc.stored, tc.given, m, tc.match)
+		}
+	}
+}
+
+func TestPrepareHashesAPIKey(t *testing.T) {
+	cfg := New("test", device1)
+	cfg.GUI.APIKey = "abc123"
+	cfg.prepare(device1)
+	if !SecretMatches(cfg.GUI.APIKey, "abc123") || !IsHashedSecret(cfg.GUI.APIKey) {
+		t.Errorf("API key not hashed: %q", cfg.GUI.APIKey)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// When signing is enabled, the config file is accompanied by a signature
+// made with the device key, stored in the same place with a ".sig" suffix.
+// A config file that does not match its signature has been modified outside
+// of syncthing. That signing is enabled is also recorded in a marker file
+// with a ".signed" suffix, so that disabling it in the config file and
+// removing the signature isn't a way around it.
+
+var (
+	ErrNoSignature  = errors.New("config signature missing")
+	ErrBadSignature = errors.New("config signature does not match")
+)
+
+var signingKey crypto.Signer
+
+// SetSigningKey sets the key used to sign the config file when saving, if
+// signing is enabled in the options.
+func SetSigningKey(key crypto.Signer) {
+	signingKey = key
+}
+
+func signaturePath(location string) string {
+	return location + ".sig"
+}
+
+func signedMarkerPath(location string) string {
+	return location + ".signed"
+}
+
+// writeSignature signs the saved config file, or removes any old signature
+// if signing is disabled. Without a signing key, which is withheld until the
+// config is known to be untampered, the signature is left as it is.
+func (cfg *Configuration) writeSignature() error {
+	if signingKey == nil {
+		return nil
+	}
+	if !cfg.Options.SignConfig {
+		os.Remove(signaturePath(cfg.Location))
+		os.Remove(signedMarkerPath(cfg.Location))
+		return nil
+	}
+
+	bs, err := ioutil.ReadFile(cfg.Location)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(bs)
+	sig, err := signingKey.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(signedMarkerPath(cfg.Location), nil, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(signaturePath(cfg.Location), []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0600)
+}
+
+// VerifySignature checks the signature of the config file against the
+// public key of the device. A config that has been signed before, or has
+// signing enabled, must have a valid signature; one without must have either
+// a valid signature or none at all.
+func (cfg *Configuration) VerifySignature(key crypto.PublicKey) error {
+	sigData, err := ioutil.ReadFile(signaturePath(cfg.Location))
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(signedMarkerPath(cfg.Location)); err == nil || cfg.Options.SignConfig {
+			return ErrNoSignature
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigData)))
+	if err != nil {
+		return ErrBadSignature
+	}
+	bs, err := ioutil.ReadFile(cfg.Location)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(bs)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, hash[:], sig) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
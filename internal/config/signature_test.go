@@ -0,0 +1,80 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestConfigSignature(t *testing.T) {
+	path := "testdata/temp-signed.xml"
+	defer os.Remove(path)
+	defer os.Remove(signaturePath(path))
+	defer os.Remove(signedMarkerPath(path))
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSigningKey(key)
+	defer SetSigningKey(nil)
+
+	cfg := New(path, device1)
+	cfg.Options.SignConfig = true
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg2, err := Load(path, device1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg2.VerifySignature(&key.PublicKey); err != nil {
+		t.Error("Unexpected error for untouched config:", err)
+	}
+
+	// Modify the config behind our back
+	bs, _ := ioutil.ReadFile(path)
+	bs = append(bs, []byte("<!-- tampered -->\n")...)
+	ioutil.WriteFile(path, bs, 0644)
+	if err := cfg2.VerifySignature(&key.PublicKey); err != ErrBadSignature {
+		t.Error("Unexpected error for modified config:", err)
+	}
+
+	// Remove the signature
+	os.Remove(signaturePath(path))
+	if err := cfg2.VerifySignature(&key.PublicKey); err != ErrNoSignature {
+		t.Error("Unexpected error for missing signature:", err)
+	}
+
+	// Disabling signing in the file doesn't make up for the signature
+	cfg2.Options.SignConfig = false
+	if err := cfg2.VerifySignature(&key.PublicKey); err != ErrNoSignature {
+		t.Error("Unexpected error for missing signature with signing disabled:", err)
+	}
+
+	// Nor does saving without the signing key
+	SetSigningKey(nil)
+	if err := cfg2.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg2.VerifySignature(&key.PublicKey); err != ErrNoSignature {
+		t.Error("Unexpected error for missing signature saved without key:", err)
+	}
+
+	// Disabling signing and saving with the key leaves nothing to verify
+	SetSigningKey(key)
+	if err := cfg2.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg2.VerifySignature(&key.PublicKey); err != nil {
+		t.Error("Unexpected error with signing disabled:", err)
+	}
+}
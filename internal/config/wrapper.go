@@ -0,0 +1,138 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A Committer is a subsystem that is told about changes to the
+// configuration, so that it can apply them while running.
+type Committer interface {
+	// VerifyConfiguration returns an error if the new configuration cannot
+	// be accepted. The configuration is then left unchanged.
+	VerifyConfiguration(from, to Configuration) error
+
+	// CommitConfiguration applies the new configuration, and returns false
+	// if some of the changes take effect only after a restart.
+	CommitConfiguration(from, to Configuration) (handled bool)
+
+	String() string
+}
+
+// CommitResponse is the outcome of replacing the configuration.
+type CommitResponse struct {
+	ValidationError error
+	RequiresRestart bool
+}
+
+// A Wrapper guards the configuration shared by the running subsystems, and
+// tells the committers subscribed to it about changes. Changes that are
+// not for a committer to apply, as they are read from the configuration
+// when needed, take effect at once.
+type Wrapper struct {
+	cfg             *Configuration
+	subs            []Committer
+	requiresRestart bool
+	mut             sync.Mutex
+
+	replaceMut sync.Mutex // serializes Replace
+}
+
+// Wrap returns a Wrapper around the configuration, which is updated in
+// place as it is replaced.
+func Wrap(cfg *Configuration) *Wrapper {
+	return &Wrapper{cfg: cfg}
+}
+
+// Subscribe registers the committer to be told about changes.
+func (w *Wrapper) Subscribe(c Committer) {
+	w.mut.Lock()
+	w.subs = append(w.subs, c)
+	w.mut.Unlock()
+}
+
+// Unsubscribe stops telling the committer about changes.
+func (w *Wrapper) Unsubscribe(c Committer) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	for i, s := range w.subs {
+		if s == c {
+			w.subs = append(w.subs[:i], w.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Raw returns a copy of the current configuration.
+func (w *Wrapper) Raw() Configuration {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return *w.cfg
+}
+
+// Save saves the current configuration.
+func (w *Wrapper) Save() error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.cfg.Save()
+}
+
+// Replace verifies the new configuration with all committers and, if none
+// of them objects, makes it the current one and has the committers apply
+// it. The new configuration keeps the location and logger of the current
+// one. It is not saved.
+func (w *Wrapper) Replace(to Configuration) CommitResponse {
+	w.replaceMut.Lock()
+	defer w.replaceMut.Unlock()
+
+	w.mut.Lock()
+	from := *w.cfg
+	subs := append([]Committer(nil), w.subs...)
+	w.mut.Unlock()
+
+	to.Location = from.Location
+	to.log = from.log
+
+	for _, sub := range subs {
+		if err := sub.VerifyConfiguration(from, to); err != nil {
+			return CommitResponse{ValidationError: fmt.Errorf("%v: %v", sub, err)}
+		}
+	}
+
+	w.mut.Lock()
+	*w.cfg = to
+	w.mut.Unlock()
+
+	var requiresRestart bool
+	for _, sub := range subs {
+		if !sub.CommitConfiguration(from, to) {
+			from.log.Or().Infof("Configuration change requires restart (%v)", sub)
+			requiresRestart = true
+		}
+	}
+
+	if requiresRestart {
+		w.SetRequiresRestart()
+	}
+	return CommitResponse{RequiresRestart: requiresRestart}
+}
+
+// RequiresRestart returns true if a change since startup takes effect only
+// after a restart.
+func (w *Wrapper) RequiresRestart() bool {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.requiresRestart
+}
+
+// SetRequiresRestart records that a change made to the configuration
+// directly, rather than by Replace, takes effect only after a restart.
+func (w *Wrapper) SetRequiresRestart() {
+	w.mut.Lock()
+	w.requiresRestart = true
+	w.mut.Unlock()
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+type fakeCommitter struct {
+	verifyErr error
+	handled   bool
+	commits   int
+}
+
+func (c *fakeCommitter) VerifyConfiguration(from, to Configuration) error {
+	return c.verifyErr
+}
+
+func (c *fakeCommitter) CommitConfiguration(from, to Configuration) bool {
+	c.commits++
+	return c.handled
+}
+
+func (c *fakeCommitter) String() string {
+	return "fake"
+}
+
+func TestWrapperReplace(t *testing.T) {
+	cfg := New("/tmp/test", device1)
+	w := Wrap(&cfg)
+
+	live := &fakeCommitter{handled: true}
+	w.Subscribe(live)
+
+	to := w.Raw()
+	to.Location = ""
+	to.Options.MaxSendKbps = 100
+	if resp := w.Replace(to); resp.ValidationError != nil || resp.RequiresRestart {
+		t.Fatalf("Unexpected response %+v", resp)
+	}
+	if cfg.Options.MaxSendKbps != 100 || cfg.Location != "/tmp/test" {
+		t.Errorf("Configuration not replaced in place: %+v", cfg)
+	}
+	if live.commits != 1 {
+		t.Errorf("Committer told about %d commits, not 1", live.commits)
+	}
+
+	// A committer refusing the change leaves the configuration as it is
+	refusing := &fakeCommitter{verifyErr: errors.New("no")}
+	w.Subscribe(refusing)
+	to.Options.MaxSendKbps = 200
+	if resp := w.Replace(to); resp.ValidationError == nil {
+		t.Error("Change not refused")
+	}
+	if cfg.Options.MaxSendKbps != 100 || live.commits != 1 {
+		t.Error("Refused change was committed")
+	}
+	w.Unsubscribe(refusing)
+
+	// A committer that cannot apply the change makes it require a restart
+	w.Subscribe(&fakeCommitter{})
+	if resp := w.Replace(to); !resp.RequiresRestart {
+		t.Error("Change should require a restart")
+	}
+	if !w.RequiresRestart() {
+		t.Error("Restart requirement not kept")
+	}
+
+	w.Unsubscribe(live)
+	w.Replace(to)
+	if live.commits != 2 {
+		t.Error("Unsubscribed committer told about commits")
+	}
+}
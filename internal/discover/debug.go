@@ -4,14 +4,14 @@
 
 package discover
 
-import (
-	"os"
-	"strings"
+import "github.com/syncthing/syncthing/internal/logger"
 
-	"github.com/syncthing/syncthing/internal/logger"
-)
+var l = logger.DefaultLogger
 
-var (
-	debug = strings.Contains(os.Getenv("STTRACE"), "discover") || os.Getenv("STTRACE") == "all"
-	l     = logger.DefaultLogger
-)
+func init() {
+	l.NewFacility("discover", "Remote device discovery")
+}
+
+func debug() bool {
+	return l.ShouldDebug("discover")
+}
@@ -16,7 +16,8 @@ import (
 
 	"github.com/syncthing/syncthing/internal/beacon"
 	"github.com/syncthing/syncthing/internal/events"
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/internal/logger"
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
 type Discoverer struct {
@@ -36,8 +37,10 @@ type Discoverer struct {
 	stopGlobal       chan struct{}
 	globalWG         sync.WaitGroup
 	forcedBcastTick  chan time.Time
+	forcedGlobal     chan struct{}
 	extAnnounceOK    bool
 	extAnnounceOKmut sync.Mutex
+	log              *logger.Logger
 }
 
 type cacheEntry struct {
@@ -49,15 +52,20 @@ var (
 	ErrIncorrectMagic = errors.New("incorrect magic number")
 )
 
-func NewDiscoverer(id protocol.DeviceID, addresses []string) *Discoverer {
+// NewDiscoverer returns a discoverer announcing the given listen addresses.
+// Messages are logged to the given logger, or the default logger if it is
+// nil.
+func NewDiscoverer(id protocol.DeviceID, addresses []string, log *logger.Logger) *Discoverer {
 	return &Discoverer{
 		myID:            id,
+		log:             log.Or(),
 		listenAddrs:     addresses,
 		localBcastIntv:  30 * time.Second,
 		globalBcastIntv: 1800 * time.Second,
 		errorRetryIntv:  60 * time.Second,
 		cacheLifetime:   5 * time.Minute,
 		registry:        make(map[protocol.DeviceID][]cacheEntry),
+		forcedGlobal:    make(chan struct{}, 1),
 	}
 }
 
@@ -65,10 +73,10 @@ func (d *Discoverer) StartLocal(localPort int, localMCAddr string) {
 	if localPort > 0 {
 		bb, err := beacon.NewBroadcast(localPort)
 		if err != nil {
-			if debug {
+			if debug() {
 				l.Debugln(err)
 			}
-			l.Infoln("Local discovery over IPv4 unavailable")
+			d.log.Infoln("Local discovery over IPv4 unavailable")
 		} else {
 			d.broadcastBeacon = bb
 			go d.recvAnnouncements(bb)
@@ -78,10 +86,10 @@ func (d *Discoverer) StartLocal(localPort int, localMCAddr string) {
 	if len(localMCAddr) > 0 {
 		mb, err := beacon.NewMulticast(localMCAddr)
 		if err != nil {
-			if debug {
+			if debug() {
 				l.Debugln(err)
 			}
-			l.Infoln("Local discovery over IPv6 unavailable")
+			d.log.Infoln("Local discovery over IPv6 unavailable")
 		} else {
 			d.multicastBeacon = mb
 			go d.recvAnnouncements(mb)
@@ -89,7 +97,7 @@ func (d *Discoverer) StartLocal(localPort int, localMCAddr string) {
 	}
 
 	if d.broadcastBeacon == nil && d.multicastBeacon == nil {
-		l.Warnln("Local discovery unavailable")
+		d.log.Warnln("Local discovery unavailable")
 	} else {
 		d.localBcastTick = time.Tick(d.localBcastIntv)
 		d.forcedBcastTick = make(chan time.Time)
@@ -104,12 +112,13 @@ func (d *Discoverer) StartGlobal(server string, extPort uint16) {
 	d.extPort = extPort
 	d.stopGlobal = make(chan struct{})
 	d.globalWG.Add(1)
-	go d.sendExternalAnnouncements()
+	go d.sendExternalAnnouncements(d.stopGlobal)
 }
 
 func (d *Discoverer) StopGlobal() {
 	if d.stopGlobal != nil {
 		close(d.stopGlobal)
+		d.stopGlobal = nil
 		d.globalWG.Wait()
 	}
 }
@@ -148,6 +157,26 @@ func (d *Discoverer) Lookup(device protocol.DeviceID) []string {
 	return nil
 }
 
+// Rediscover forgets the cached addresses and announces us again at once,
+// locally and globally. It is meant for when the network has changed, and
+// with it the addresses we and the other devices can be reached at.
+func (d *Discoverer) Rediscover() {
+	d.registryLock.Lock()
+	d.registry = make(map[protocol.DeviceID][]cacheEntry)
+	d.registryLock.Unlock()
+
+	if d.forcedBcastTick != nil {
+		select {
+		case d.forcedBcastTick <- time.Now():
+		default:
+		}
+	}
+	select {
+	case d.forcedGlobal <- struct{}{}:
+	default:
+	}
+}
+
 func (d *Discoverer) Hint(device string, addrs []string) {
 	resAddrs := resolveAddrs(addrs)
 	var id protocol.DeviceID
@@ -175,9 +204,9 @@ func (d *Discoverer) announcementPkt() []byte {
 	for _, astr := range d.listenAddrs {
 		addr, err := net.ResolveTCPAddr("tcp", astr)
 		if err != nil {
-			l.Warnln("%v: not announcing %s", err, astr)
+			d.log.Warnf("%v: not announcing %s", err, astr)
 			continue
-		} else if debug {
+		} else if debug() {
 			l.Debugf("discover: announcing %s: %#v", astr, addr)
 		}
 		if len(addr.IP) == 0 || addr.IP.IsUnspecified() {
@@ -219,19 +248,19 @@ func (d *Discoverer) sendLocalAnnouncements() {
 	}
 }
 
-func (d *Discoverer) sendExternalAnnouncements() {
+func (d *Discoverer) sendExternalAnnouncements(stop chan struct{}) {
 	defer d.globalWG.Done()
 
 	remote, err := net.ResolveUDPAddr("udp", d.extServer)
 	for err != nil {
-		l.Warnf("Global discovery: %v; trying again in %v", err, d.errorRetryIntv)
+		d.log.Warnf("Global discovery: %v; trying again in %v", err, d.errorRetryIntv)
 		time.Sleep(d.errorRetryIntv)
 		remote, err = net.ResolveUDPAddr("udp", d.extServer)
 	}
 
 	conn, err := net.ListenUDP("udp", nil)
 	for err != nil {
-		l.Warnf("Global discovery: %v; trying again in %v", err, d.errorRetryIntv)
+		d.log.Warnf("Global discovery: %v; trying again in %v", err, d.errorRetryIntv)
 		time.Sleep(d.errorRetryIntv)
 		conn, err = net.ListenUDP("udp", nil)
 	}
@@ -253,13 +282,13 @@ func (d *Discoverer) sendExternalAnnouncements() {
 	sendOneAnnouncement := func() {
 		var ok bool
 
-		if debug {
+		if debug() {
 			l.Debugf("discover: send announcement -> %v\n%s", remote, hex.Dump(buf))
 		}
 
 		_, err := conn.WriteTo(buf, remote)
 		if err != nil {
-			if debug {
+			if debug() {
 				l.Debugln("discover: warning:", err)
 			}
 			ok = false
@@ -268,7 +297,7 @@ func (d *Discoverer) sendExternalAnnouncements() {
 
 			time.Sleep(1 * time.Second)
 			res := d.externalLookup(d.myID)
-			if debug {
+			if debug() {
 				l.Debugln("discover: external lookup check:", res)
 			}
 			ok = len(res) > 0
@@ -291,7 +320,7 @@ func (d *Discoverer) sendExternalAnnouncements() {
 loop:
 	for {
 		select {
-		case <-d.stopGlobal:
+		case <-stop:
 			break loop
 
 		case <-errTick:
@@ -299,10 +328,13 @@ loop:
 
 		case <-bcastTick:
 			sendOneAnnouncement()
+
+		case <-d.forcedGlobal:
+			sendOneAnnouncement()
 		}
 	}
 
-	if debug {
+	if debug() {
 		l.Debugln("discover: stopping global")
 	}
 }
@@ -311,7 +343,7 @@ func (d *Discoverer) recvAnnouncements(b beacon.Interface) {
 	for {
 		buf, addr := b.Recv()
 
-		if debug {
+		if debug() {
 			l.Debugf("discover: read announcement from %s:\n%s", addr, hex.Dump(buf))
 		}
 
@@ -366,7 +398,7 @@ func (d *Discoverer) registerDevice(addr net.Addr, device Device) bool {
 	done:
 	}
 
-	if debug {
+	if debug() {
 		l.Debugf("discover: register: %v -> %v", id, current)
 	}
 
@@ -391,7 +423,7 @@ func (d *Discoverer) registerDevice(addr net.Addr, device Device) bool {
 func (d *Discoverer) externalLookup(device protocol.DeviceID) []string {
 	extIP, err := net.ResolveUDPAddr("udp", d.extServer)
 	if err != nil {
-		if debug {
+		if debug() {
 			l.Debugf("discover: %v; no external lookup", err)
 		}
 		return nil
@@ -399,7 +431,7 @@ func (d *Discoverer) externalLookup(device protocol.DeviceID) []string {
 
 	conn, err := net.DialUDP("udp", nil, extIP)
 	if err != nil {
-		if debug {
+		if debug() {
 			l.Debugf("discover: %v; no external lookup", err)
 		}
 		return nil
@@ -408,7 +440,7 @@ func (d *Discoverer) externalLookup(device protocol.DeviceID) []string {
 
 	err = conn.SetDeadline(time.Now().Add(5 * time.Second))
 	if err != nil {
-		if debug {
+		if debug() {
 			l.Debugf("discover: %v; no external lookup", err)
 		}
 		return nil
@@ -417,7 +449,7 @@ func (d *Discoverer) externalLookup(device protocol.DeviceID) []string {
 	buf := Query{QueryMagic, device[:]}.MarshalXDR()
 	_, err = conn.Write(buf)
 	if err != nil {
-		if debug {
+		if debug() {
 			l.Debugf("discover: %v; no external lookup", err)
 		}
 		return nil
@@ -430,20 +462,20 @@ func (d *Discoverer) externalLookup(device protocol.DeviceID) []string {
 			// Expected if the server doesn't know about requested device ID
 			return nil
 		}
-		if debug {
+		if debug() {
 			l.Debugf("discover: %v; no external lookup", err)
 		}
 		return nil
 	}
 
-	if debug {
+	if debug() {
 		l.Debugf("discover: read external:\n%s", hex.Dump(buf[:n]))
 	}
 
 	var pkt Announce
 	err = pkt.UnmarshalXDR(buf[:n])
 	if err != nil && err != io.EOF {
-		if debug {
+		if debug() {
 			l.Debugln("discover:", err)
 		}
 		return nil
@@ -460,7 +492,7 @@ func (d *Discoverer) externalLookup(device protocol.DeviceID) []string {
 func (d *Discoverer) filterCached(c []cacheEntry) []cacheEntry {
 	for i := 0; i < len(c); {
 		if ago := time.Since(c[i].seen); ago > d.cacheLifetime {
-			if debug {
+			if debug() {
 				l.Debugf("removing cached address %s: seen %v ago", c[i].addr, ago)
 			}
 			c[i] = c[len(c)-1]
@@ -0,0 +1,103 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// A Batcher aggregates events about individual items in a folder, such as
+// files being scanned or pulled, so that syncing many small files doesn't
+// flood the event subscribers. Events of each type and folder are logged at
+// most once per interval, as one event with the data of all items:
+//
+//	{"folder": "default", "items": [{...}, {...}]}
+//
+// When an item has several events of the same type within an interval only
+// the last one is kept, so each item's final state is always reported. With
+// a zero interval every event is logged as it happens.
+type Batcher struct {
+	logger   *Logger
+	interval time.Duration
+	pending  map[batchKey]*batch
+	mut      sync.Mutex
+}
+
+type batchKey struct {
+	eventType EventType
+	folder    string
+}
+
+type batch struct {
+	items []interface{}
+	index map[string]int // item name -> position in items
+}
+
+func NewBatcher(logger *Logger, interval time.Duration) *Batcher {
+	return &Batcher{
+		logger:   logger,
+		interval: interval,
+		pending:  make(map[batchKey]*batch),
+	}
+}
+
+// Log records an event of the given type for the named item in the folder.
+func (b *Batcher) Log(t EventType, folder, name string, data interface{}) {
+	if b.interval <= 0 {
+		b.logger.Log(t, data)
+		return
+	}
+
+	key := batchKey{t, folder}
+
+	b.mut.Lock()
+	bt, ok := b.pending[key]
+	if !ok {
+		bt = &batch{index: make(map[string]int)}
+		b.pending[key] = bt
+		time.AfterFunc(b.interval, func() {
+			b.flush(key)
+		})
+	}
+	if i, ok := bt.index[name]; ok {
+		bt.items[i] = data
+	} else {
+		bt.index[name] = len(bt.items)
+		bt.items = append(bt.items, data)
+	}
+	b.mut.Unlock()
+}
+
+// Flush logs all pending events immediately.
+func (b *Batcher) Flush() {
+	b.mut.Lock()
+	var keys []batchKey
+	for key := range b.pending {
+		keys = append(keys, key)
+	}
+	b.mut.Unlock()
+
+	for _, key := range keys {
+		b.flush(key)
+	}
+}
+
+func (b *Batcher) flush(key batchKey) {
+	b.mut.Lock()
+	bt, ok := b.pending[key]
+	delete(b.pending, key)
+	b.mut.Unlock()
+
+	if !ok {
+		// Already flushed
+		return
+	}
+
+	b.logger.Log(key.eventType, map[string]interface{}{
+		"folder": key.folder,
+		"items":  bt.items,
+	})
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package events_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/events"
+)
+
+func TestBatcherUnbatched(t *testing.T) {
+	l := events.NewLogger()
+	s := l.Subscribe(events.AllEvents)
+	defer l.Unsubscribe(s)
+
+	b := events.NewBatcher(l, 0)
+	b.Log(events.ItemStarted, "folder", "a", "data")
+
+	ev, err := s.Poll(timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Data != "data" {
+		t.Errorf("Unexpected event data %v", ev.Data)
+	}
+}
+
+func TestBatcherAggregates(t *testing.T) {
+	l := events.NewLogger()
+	s := l.Subscribe(events.AllEvents)
+	defer l.Unsubscribe(s)
+
+	b := events.NewBatcher(l, 50*time.Millisecond)
+	b.Log(events.ItemStarted, "folder", "a", "a1")
+	b.Log(events.ItemStarted, "folder", "b", "b1")
+	b.Log(events.ItemStarted, "folder", "a", "a2")
+	b.Log(events.ItemStarted, "other", "a", "o1")
+
+	seen := make(map[string][]interface{})
+	for i := 0; i < 2; i++ {
+		ev, err := s.Poll(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := ev.Data.(map[string]interface{})
+		seen[data["folder"].(string)] = data["items"].([]interface{})
+	}
+
+	if items := seen["folder"]; len(items) != 2 || items[0] != "a2" || items[1] != "b1" {
+		t.Errorf("Unexpected items %v", items)
+	}
+	if items := seen["other"]; len(items) != 1 || items[0] != "o1" {
+		t.Errorf("Unexpected items %v", items)
+	}
+
+	if _, err := s.Poll(100 * time.Millisecond); err != events.ErrTimeout {
+		t.Errorf("Unexpected extra event or error %v", err)
+	}
+}
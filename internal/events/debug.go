@@ -4,14 +4,14 @@
 
 package events
 
-import (
-	"os"
-	"strings"
+import "github.com/syncthing/syncthing/internal/logger"
 
-	"github.com/syncthing/syncthing/internal/logger"
-)
+var dl = logger.DefaultLogger
 
-var (
-	debug = strings.Contains(os.Getenv("STTRACE"), "events") || os.Getenv("STTRACE") == "all"
-	dl    = logger.DefaultLogger
-)
+func init() {
+	dl.NewFacility("events", "Event generation and subscription")
+}
+
+func debug() bool {
+	return dl.ShouldDebug("events")
+}
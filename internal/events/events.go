@@ -27,6 +27,15 @@ const (
 	StateChanged
 	FolderRejected
 	ConfigSaved
+	UpgradeProgress
+	UpgradeAvailable
+	DevicePending
+	LocalChangesUpdated
+	DeviceExpiring
+	FolderModeMismatch
+	UnsafeFilesUpdated
+	UnshareRequested
+	FolderCompletion
 
 	AllEvents = ^EventType(0)
 )
@@ -59,6 +68,24 @@ func (t EventType) String() string {
 		return "FolderRejected"
 	case ConfigSaved:
 		return "ConfigSaved"
+	case UpgradeProgress:
+		return "UpgradeProgress"
+	case UpgradeAvailable:
+		return "UpgradeAvailable"
+	case DevicePending:
+		return "DevicePending"
+	case LocalChangesUpdated:
+		return "LocalChangesUpdated"
+	case DeviceExpiring:
+		return "DeviceExpiring"
+	case FolderModeMismatch:
+		return "FolderModeMismatch"
+	case UnsafeFilesUpdated:
+		return "UnsafeFilesUpdated"
+	case UnshareRequested:
+		return "UnshareRequested"
+	case FolderCompletion:
+		return "FolderCompletion"
 	default:
 		return "Unknown"
 	}
@@ -105,7 +132,7 @@ func NewLogger() *Logger {
 
 func (l *Logger) Log(t EventType, data interface{}) {
 	l.mutex.Lock()
-	if debug {
+	if debug() {
 		dl.Debugln("log", l.nextId, t.String(), data)
 	}
 	e := Event{
@@ -129,7 +156,7 @@ func (l *Logger) Log(t EventType, data interface{}) {
 
 func (l *Logger) Subscribe(mask EventType) *Subscription {
 	l.mutex.Lock()
-	if debug {
+	if debug() {
 		dl.Debugln("subscribe", mask)
 	}
 	s := &Subscription{
@@ -145,7 +172,7 @@ func (l *Logger) Subscribe(mask EventType) *Subscription {
 
 func (l *Logger) Unsubscribe(s *Subscription) {
 	l.mutex.Lock()
-	if debug {
+	if debug() {
 		dl.Debugln("unsubsribe")
 	}
 	delete(l.subs, s.id)
@@ -157,7 +184,7 @@ func (s *Subscription) Poll(timeout time.Duration) (Event, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if debug {
+	if debug() {
 		dl.Debugln("poll", timeout)
 	}
 
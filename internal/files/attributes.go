@@ -0,0 +1,39 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package files
+
+import (
+	"bytes"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// marshalFile returns the database representation of a file; the XDR encoded
+// FileInfo followed by its attributes, if there are any. Readers not
+// interested in the attributes can ignore the trailing data.
+func marshalFile(f protocol.FileInfo) []byte {
+	bs := f.MarshalXDR()
+	if len(f.Attributes) > 0 {
+		bs = protocol.FileAttributes{Attributes: f.Attributes}.AppendXDR(bs)
+	}
+	return bs
+}
+
+func unmarshalFile(bs []byte) (protocol.FileInfo, error) {
+	var f protocol.FileInfo
+	br := bytes.NewReader(bs)
+	if err := f.DecodeXDR(br); err != nil {
+		return f, err
+	}
+	if br.Len() > 0 {
+		var fa protocol.FileAttributes
+		if err := fa.DecodeXDR(br); err != nil {
+			return f, err
+		}
+		f.Attributes = fa.Attributes
+	}
+	protocol.InternBlockHashes(f.Blocks)
+	return f, nil
+}
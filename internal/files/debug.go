@@ -4,14 +4,14 @@
 
 package files
 
-import (
-	"os"
-	"strings"
+import "github.com/syncthing/syncthing/internal/logger"
 
-	"github.com/syncthing/syncthing/internal/logger"
-)
+var l = logger.DefaultLogger
 
-var (
-	debug = strings.Contains(os.Getenv("STTRACE"), "files") || os.Getenv("STTRACE") == "all"
-	l     = logger.DefaultLogger
-)
+func init() {
+	l.NewFacility("files", "The file and index database")
+}
+
+func debug() bool {
+	return l.ShouldDebug("files")
+}
@@ -11,7 +11,7 @@ import (
 	"sync"
 
 	"github.com/syncthing/syncthing/internal/lamport"
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/lib/protocol"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
@@ -176,7 +176,7 @@ func ldbGenericReplace(db *leveldb.DB, folder, device []byte, fs []protocol.File
 
 		cmp := bytes.Compare(newName, oldName)
 
-		if debug {
+		if debug() {
 			l.Debugf("generic replace; folder=%q device=%v moreFs=%v moreDb=%v cmp=%d newName=%q oldName=%q", folder, protocol.DeviceIDFromBytes(device), moreFs, moreDb, cmp, newName, oldName)
 		}
 
@@ -235,7 +235,7 @@ func ldbReplace(db *leveldb.DB, folder, device []byte, fs []protocol.FileInfo) u
 	// TODO: Return the remaining maxLocalVer?
 	return ldbGenericReplace(db, folder, device, fs, func(db dbReader, batch dbWriter, folder, device, name []byte, dbi iterator.Iterator) uint64 {
 		// Disk has files that we are missing. Remove it.
-		if debug {
+		if debug() {
 			l.Debugf("delete; folder=%q device=%v name=%q", folder, protocol.DeviceIDFromBytes(device), name)
 		}
 		ldbRemoveFromGlobal(db, batch, folder, device, name)
@@ -252,7 +252,7 @@ func ldbReplaceWithDelete(db *leveldb.DB, folder, device []byte, fs []protocol.F
 			panic(err)
 		}
 		if !tf.IsDeleted() {
-			if debug {
+			if debug() {
 				l.Debugf("mark deleted; folder=%q device=%v name=%q", folder, protocol.DeviceIDFromBytes(device), name)
 			}
 			ts := clock(tf.LocalVersion)
@@ -326,7 +326,7 @@ func ldbUpdate(db *leveldb.DB, folder, device []byte, fs []protocol.FileInfo) ui
 }
 
 func ldbInsert(batch dbWriter, folder, device, name []byte, file protocol.FileInfo) uint64 {
-	if debug {
+	if debug() {
 		l.Debugf("insert; folder=%q device=%v %v", folder, protocol.DeviceIDFromBytes(device), file)
 	}
 
@@ -335,7 +335,7 @@ func ldbInsert(batch dbWriter, folder, device, name []byte, file protocol.FileIn
 	}
 
 	nk := deviceKey(folder, device, name)
-	batch.Put(nk, file.MarshalXDR())
+	batch.Put(nk, marshalFile(file))
 
 	return file.LocalVersion
 }
@@ -344,7 +344,7 @@ func ldbInsert(batch dbWriter, folder, device, name []byte, file protocol.FileIn
 // file. If the device is already present in the list, the version is updated.
 // If the file does not have an entry in the global list, it is created.
 func ldbUpdateGlobal(db dbReader, batch dbWriter, folder, device, file []byte, version uint64) bool {
-	if debug {
+	if debug() {
 		l.Debugf("update global; folder=%q device=%v file=%q version=%d", folder, protocol.DeviceIDFromBytes(device), file, version)
 	}
 	gk := globalKey(folder, file)
@@ -398,7 +398,7 @@ done:
 // given file. If the version list is empty after this, the file entry is
 // removed entirely.
 func ldbRemoveFromGlobal(db dbReader, batch dbWriter, folder, device, file []byte) {
-	if debug {
+	if debug() {
 		l.Debugf("remove from global; folder=%q device=%v file=%q", folder, protocol.DeviceIDFromBytes(device), file)
 	}
 
@@ -488,8 +488,7 @@ func ldbGet(db *leveldb.DB, folder, device, file []byte) protocol.FileInfo {
 		panic(err)
 	}
 
-	var f protocol.FileInfo
-	err = f.UnmarshalXDR(bs)
+	f, err := unmarshalFile(bs)
 	if err != nil {
 		panic(err)
 	}
@@ -528,8 +527,7 @@ func ldbGetGlobal(db *leveldb.DB, folder, file []byte) protocol.FileInfo {
 		panic(err)
 	}
 
-	var f protocol.FileInfo
-	err = f.UnmarshalXDR(bs)
+	f, err := unmarshalFile(bs)
 	if err != nil {
 		panic(err)
 	}
@@ -671,7 +669,7 @@ outer:
 					continue outer
 				}
 
-				if debug {
+				if debug() {
 					l.Debugf("need folder=%q device=%v name=%q need=%v have=%v haveV=%d globalV=%d", folder, protocol.DeviceIDFromBytes(device), name, need, have, haveVersion, vl.versions[0].version)
 				}
 
@@ -756,8 +754,6 @@ func unmarshalTrunc(bs []byte, truncate bool) (protocol.FileIntf, error) {
 		err := tf.UnmarshalXDR(bs)
 		return tf, err
 	} else {
-		var tf protocol.FileInfo
-		err := tf.UnmarshalXDR(bs)
-		return tf, err
+		return unmarshalFile(bs)
 	}
 }
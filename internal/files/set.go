@@ -12,9 +12,11 @@ package files
 
 import (
 	"sync"
+	"time"
 
 	"github.com/syncthing/syncthing/internal/lamport"
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/internal/metrics"
+	"github.com/syncthing/syncthing/lib/protocol"
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
@@ -49,7 +51,7 @@ func NewSet(folder string, db *leveldb.DB) *Set {
 		lamport.Default.Tick(f.Version)
 		return true
 	})
-	if debug {
+	if debug() {
 		l.Debugf("loaded localVersion for %q: %#v", folder, s.localVersion)
 	}
 	clock(s.localVersion[protocol.LocalDeviceID])
@@ -58,12 +60,13 @@ func NewSet(folder string, db *leveldb.DB) *Set {
 }
 
 func (s *Set) Replace(device protocol.DeviceID, fs []protocol.FileInfo) {
-	if debug {
+	if debug() {
 		l.Debugf("%s Replace(%v, [%d])", s.folder, device, len(fs))
 	}
 	normalizeFilenames(fs)
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	defer metrics.GetTimer("db.replace").UpdateSince(time.Now())
 	s.localVersion[device] = ldbReplace(s.db, []byte(s.folder), device[:], fs)
 	if len(fs) == 0 {
 		// Reset the local version if all files were removed.
@@ -72,78 +75,82 @@ func (s *Set) Replace(device protocol.DeviceID, fs []protocol.FileInfo) {
 }
 
 func (s *Set) ReplaceWithDelete(device protocol.DeviceID, fs []protocol.FileInfo) {
-	if debug {
+	if debug() {
 		l.Debugf("%s ReplaceWithDelete(%v, [%d])", s.folder, device, len(fs))
 	}
 	normalizeFilenames(fs)
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	defer metrics.GetTimer("db.replaceWithDelete").UpdateSince(time.Now())
 	if lv := ldbReplaceWithDelete(s.db, []byte(s.folder), device[:], fs); lv > s.localVersion[device] {
 		s.localVersion[device] = lv
 	}
 }
 
 func (s *Set) Update(device protocol.DeviceID, fs []protocol.FileInfo) {
-	if debug {
+	if debug() {
 		l.Debugf("%s Update(%v, [%d])", s.folder, device, len(fs))
 	}
 	normalizeFilenames(fs)
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	defer metrics.GetTimer("db.update").UpdateSince(time.Now())
 	if lv := ldbUpdate(s.db, []byte(s.folder), device[:], fs); lv > s.localVersion[device] {
 		s.localVersion[device] = lv
 	}
 }
 
 func (s *Set) WithNeed(device protocol.DeviceID, fn fileIterator) {
-	if debug {
+	if debug() {
 		l.Debugf("%s WithNeed(%v)", s.folder, device)
 	}
 	ldbWithNeed(s.db, []byte(s.folder), device[:], false, nativeFileIterator(fn))
 }
 
 func (s *Set) WithNeedTruncated(device protocol.DeviceID, fn fileIterator) {
-	if debug {
+	if debug() {
 		l.Debugf("%s WithNeedTruncated(%v)", s.folder, device)
 	}
 	ldbWithNeed(s.db, []byte(s.folder), device[:], true, nativeFileIterator(fn))
 }
 
 func (s *Set) WithHave(device protocol.DeviceID, fn fileIterator) {
-	if debug {
+	if debug() {
 		l.Debugf("%s WithHave(%v)", s.folder, device)
 	}
 	ldbWithHave(s.db, []byte(s.folder), device[:], false, nativeFileIterator(fn))
 }
 
 func (s *Set) WithHaveTruncated(device protocol.DeviceID, fn fileIterator) {
-	if debug {
+	if debug() {
 		l.Debugf("%s WithHaveTruncated(%v)", s.folder, device)
 	}
 	ldbWithHave(s.db, []byte(s.folder), device[:], true, nativeFileIterator(fn))
 }
 
 func (s *Set) WithGlobal(fn fileIterator) {
-	if debug {
+	if debug() {
 		l.Debugf("%s WithGlobal()", s.folder)
 	}
 	ldbWithGlobal(s.db, []byte(s.folder), false, nativeFileIterator(fn))
 }
 
 func (s *Set) WithGlobalTruncated(fn fileIterator) {
-	if debug {
+	if debug() {
 		l.Debugf("%s WithGlobalTruncated()", s.folder)
 	}
 	ldbWithGlobal(s.db, []byte(s.folder), true, nativeFileIterator(fn))
 }
 
 func (s *Set) Get(device protocol.DeviceID, file string) protocol.FileInfo {
+	defer metrics.GetTimer("db.get").UpdateSince(time.Now())
 	f := ldbGet(s.db, []byte(s.folder), device[:], []byte(normalizedFilename(file)))
 	f.Name = nativeFilename(f.Name)
 	return f
 }
 
 func (s *Set) GetGlobal(file string) protocol.FileInfo {
+	defer metrics.GetTimer("db.getGlobal").UpdateSince(time.Now())
 	f := ldbGetGlobal(s.db, []byte(s.folder), []byte(normalizedFilename(file)))
 	f.Name = nativeFilename(f.Name)
 	return f
@@ -13,7 +13,7 @@ import (
 
 	"github.com/syncthing/syncthing/internal/files"
 	"github.com/syncthing/syncthing/internal/lamport"
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/lib/protocol"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/storage"
 )
@@ -851,6 +851,36 @@ func TestLongPath(t *testing.T) {
 	}
 }
 
+func TestAttributes(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := files.NewSet("test", db)
+
+	attrs := []protocol.Option{{Key: "posix.acl_access", Value: "\x02\x00\x00\x00"}}
+	local := []protocol.FileInfo{
+		{Name: "a", Version: 1000, Attributes: attrs, Blocks: genBlocks(1)},
+		{Name: "b", Version: 1000, Blocks: genBlocks(1)},
+	}
+
+	s.ReplaceWithDelete(protocol.LocalDeviceID, local)
+
+	if f := s.Get(protocol.LocalDeviceID, "a"); !reflect.DeepEqual(f.Attributes, attrs) {
+		t.Errorf("Incorrect attributes %v != %v", f.Attributes, attrs)
+	}
+	if f := s.GetGlobal("a"); !reflect.DeepEqual(f.Attributes, attrs) {
+		t.Errorf("Incorrect global attributes %v != %v", f.Attributes, attrs)
+	}
+	if f := s.Get(protocol.LocalDeviceID, "b"); f.Attributes != nil {
+		t.Errorf("Unexpected attributes %v", f.Attributes)
+	}
+	if have := haveList(s, protocol.LocalDeviceID); !reflect.DeepEqual(have[0].Attributes, attrs) {
+		t.Errorf("Incorrect attributes in have list %v != %v", have[0].Attributes, attrs)
+	}
+}
+
 /*
 var gf protocol.FileInfo
 
@@ -19,11 +19,13 @@ const (
 
 func Convert(pattern string, flags int) (*regexp.Regexp, error) {
 	any := "."
+	sep := "/"
 
 	switch runtime.GOOS {
 	case "windows":
 		flags |= FNM_NOESCAPE | FNM_CASEFOLD
 		pattern = filepath.FromSlash(pattern)
+		sep = "\\\\"
 		if flags&FNM_PATHNAME != 0 {
 			any = "[^\\\\]"
 		}
@@ -44,6 +46,11 @@ func Convert(pattern string, flags int) (*regexp.Regexp, error) {
 		pattern = strings.Replace(pattern, "\\.", "[:escapeddot:]", -1)
 	}
 	pattern = strings.Replace(pattern, ".", "\\.", -1)
+	if flags&FNM_PATHNAME != 0 {
+		// A double star between separators also matches no directory at
+		// all, so that "a/**/b" matches "a/b".
+		pattern = strings.Replace(pattern, sep+"**"+sep, "[:doublestarsep:]", -1)
+	}
 	pattern = strings.Replace(pattern, "**", "[:doublestar:]", -1)
 	pattern = strings.Replace(pattern, "*", any+"*", -1)
 	pattern = strings.Replace(pattern, "[:doublestar:]", ".*", -1)
@@ -51,6 +58,7 @@ func Convert(pattern string, flags int) (*regexp.Regexp, error) {
 	pattern = strings.Replace(pattern, "[:escapedstar:]", "\\*", -1)
 	pattern = strings.Replace(pattern, "[:escapedques:]", "\\?", -1)
 	pattern = strings.Replace(pattern, "[:escapeddot:]", "\\.", -1)
+	pattern = strings.Replace(pattern, "[:doublestarsep:]", sep+"(.*"+sep+")?", -1)
 	pattern = "^" + pattern + "$"
 	if flags&FNM_CASEFOLD != 0 {
 		pattern = "(?i)" + pattern
@@ -49,6 +49,10 @@ var testcases = []testcase{
 	{"*/foo.txt", "bar/baz/foo.txt", FNM_PATHNAME, false},
 	{"**/foo.txt", "bar/baz/foo.txt", 0, true},
 	{"**/foo.txt", "bar/baz/foo.txt", FNM_PATHNAME, true},
+	{"bar/**/foo.txt", "bar/foo.txt", FNM_PATHNAME, true},
+	{"bar/**/foo.txt", "bar/baz/quux/foo.txt", FNM_PATHNAME, true},
+	{"bar/**/foo.txt", "barfoo.txt", FNM_PATHNAME, false},
+	{"bar/**/foo.txt", "baz/bar/foo.txt", FNM_PATHNAME, false},
 
 	{"foo.txt", "foo.TXT", FNM_CASEFOLD, true},
 }
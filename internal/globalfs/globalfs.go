@@ -0,0 +1,279 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package globalfs presents the global version of a folder, as announced by
+// all devices sharing it, as a read only file tree. File contents are not
+// stored locally but fetched from the other devices when read, so that a
+// large share can be browsed without syncing it.
+package globalfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+var (
+	ErrNotExist = errors.New("no such file")
+	ErrIsDir    = errors.New("is a directory")
+)
+
+// A Source provides the global files of a folder and the data in them. It
+// is implemented by the model.
+type Source interface {
+	WithGlobal(folder string, fn func(protocol.FileInfo) bool)
+	CurrentGlobalFile(folder, name string) protocol.FileInfo
+	RequestBlock(folder, name string, block protocol.BlockInfo) ([]byte, error)
+}
+
+// A Node is a file or directory in the tree.
+type Node struct {
+	Name     string // slash separated path within the folder, "" for the root
+	Flags    uint32
+	Modified int64
+	Size     int64
+	children map[string]*Node // nil for files
+}
+
+func (n *Node) IsDir() bool {
+	return n.children != nil
+}
+
+// Mode returns the permission and type bits of the node. The tree is read
+// only, so write permissions are never given.
+func (n *Node) Mode() os.FileMode {
+	perm := os.FileMode(0444)
+	if protocol.HasPermissionBits(n.Flags) {
+		perm = os.FileMode(n.Flags&0777) &^ 0222
+	}
+	if n.IsDir() {
+		return os.ModeDir | perm | 0111
+	}
+	return perm
+}
+
+func (n *Node) ModTime() time.Time {
+	return time.Unix(n.Modified, 0)
+}
+
+// Child returns the named entry of the directory, or nil.
+func (n *Node) Child(name string) *Node {
+	return n.children[name]
+}
+
+// Children returns the entries of the directory, sorted by name.
+func (n *Node) Children() []*Node {
+	res := make([]*Node, 0, len(n.children))
+	for _, c := range n.children {
+		res = append(res, c)
+	}
+	sort.Sort(nodesByName(res))
+	return res
+}
+
+type nodesByName []*Node
+
+func (l nodesByName) Len() int           { return len(l) }
+func (l nodesByName) Swap(a, b int)      { l[a], l[b] = l[b], l[a] }
+func (l nodesByName) Less(a, b int) bool { return l[a].Name < l[b].Name }
+
+// An FS is the tree of one folder.
+type FS struct {
+	folder string
+	src    Source
+	root   *Node
+	mut    sync.RWMutex
+}
+
+// New returns the tree of the given folder. It is empty until refreshed.
+func New(folder string, src Source) *FS {
+	return &FS{
+		folder: folder,
+		src:    src,
+		root:   &Node{children: make(map[string]*Node)},
+	}
+}
+
+// Refresh rebuilds the tree from the current global state of the folder.
+// Deleted and invalid files are left out.
+func (fs *FS) Refresh() {
+	root := &Node{children: make(map[string]*Node)}
+	fs.src.WithGlobal(fs.folder, func(f protocol.FileInfo) bool {
+		if f.IsDeleted() || f.IsInvalid() {
+			return true
+		}
+		n := mkdirAll(root, filepath.ToSlash(f.Name))
+		n.Flags = f.Flags
+		n.Modified = f.Modified
+		if protocol.IsDirectory(f.Flags) {
+			if n.children == nil {
+				n.children = make(map[string]*Node)
+			}
+		} else {
+			n.Size = f.Size()
+		}
+		return true
+	})
+
+	fs.mut.Lock()
+	fs.root = root
+	fs.mut.Unlock()
+}
+
+// mkdirAll returns the node for the given path, creating it and any
+// missing parent directories. Parents are created before the global entries
+// for them are seen, if they ever are.
+func mkdirAll(root *Node, name string) *Node {
+	n := root
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		c, ok := n.children[part]
+		if !ok {
+			c = &Node{Name: strings.Join(parts[:i+1], "/")}
+			if i < len(parts)-1 {
+				c.children = make(map[string]*Node)
+				c.Flags = protocol.FlagDirectory | protocol.FlagNoPermBits
+			}
+			n.children[part] = c
+		} else if c.children == nil && i < len(parts)-1 {
+			// A file with children; the global state is inconsistent
+			// during an update. Treat it as the directory it's about to
+			// become.
+			c.children = make(map[string]*Node)
+		}
+		n = c
+	}
+	return n
+}
+
+// Root returns the root directory of the tree.
+func (fs *FS) Root() *Node {
+	fs.mut.RLock()
+	defer fs.mut.RUnlock()
+	return fs.root
+}
+
+// Lookup returns the node at the given slash separated path, or nil.
+func (fs *FS) Lookup(name string) *Node {
+	n := fs.Root()
+	for _, part := range strings.Split(name, "/") {
+		if part == "" {
+			continue
+		}
+		if n = n.Child(part); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// Open returns the current global version of the named file for reading.
+func (fs *FS) Open(name string) (*File, error) {
+	f := fs.src.CurrentGlobalFile(fs.folder, filepath.FromSlash(name))
+	if f.Name == "" || f.IsDeleted() || f.IsInvalid() {
+		return nil, ErrNotExist
+	}
+	if protocol.IsDirectory(f.Flags) {
+		return nil, ErrIsDir
+	}
+
+	// Block offsets aren't stored, so set them on a copy of the block list
+	blocks := make([]protocol.BlockInfo, len(f.Blocks))
+	var offset int64
+	for i, b := range f.Blocks {
+		b.Offset = offset
+		blocks[i] = b
+		offset += int64(b.Size)
+	}
+	f.Blocks = blocks
+
+	return &File{
+		folder: fs.folder,
+		src:    fs.src,
+		info:   f,
+		cached: -1,
+	}, nil
+}
+
+// A File reads the data of one version of a file from other devices.
+type File struct {
+	folder string
+	src    Source
+	info   protocol.FileInfo
+
+	// The last block read, as the kernel usually reads a block in a few
+	// smaller pieces.
+	cached int
+	buf    []byte
+	mut    sync.Mutex
+}
+
+func (f *File) Size() int64 {
+	return f.info.Size()
+}
+
+// ReadAt reads from the blocks overlapping the given range, fetching each
+// from a device that has it.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		i := f.blockIndex(pos)
+		if i < 0 {
+			return n, io.EOF
+		}
+
+		if i != f.cached {
+			buf, err := f.src.RequestBlock(f.folder, f.info.Name, f.info.Blocks[i])
+			if err != nil {
+				return n, err
+			}
+			if f.buf != nil {
+				protocol.BufferPool.Put(f.buf)
+			}
+			f.buf, f.cached = buf, i
+		}
+
+		n += copy(p[n:], f.buf[pos-f.info.Blocks[i].Offset:])
+	}
+	return n, nil
+}
+
+// blockIndex returns the index of the block holding the byte at pos, or -1
+// if it's past the end of the file.
+func (f *File) blockIndex(pos int64) int {
+	blocks := f.info.Blocks
+	i := sort.Search(len(blocks), func(i int) bool {
+		return blocks[i].Offset+int64(blocks[i].Size) > pos
+	})
+	if i == len(blocks) || blocks[i].Size == 0 {
+		return -1
+	}
+	return i
+}
+
+// Close releases the cached block.
+func (f *File) Close() error {
+	f.mut.Lock()
+	if f.buf != nil {
+		protocol.BufferPool.Put(f.buf)
+		f.buf, f.cached = nil, -1
+	}
+	f.mut.Unlock()
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package globalfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+type fakeSource struct {
+	files    []protocol.FileInfo
+	data     map[string][]byte
+	requests int
+	fail     bool
+}
+
+func (s *fakeSource) add(name string, data []byte, blocksize int) {
+	f := protocol.FileInfo{Name: filepath.FromSlash(name), Flags: 0644, Modified: 1234}
+	for off := 0; off < len(data); off += blocksize {
+		end := off + blocksize
+		if end > len(data) {
+			end = len(data)
+		}
+		hash := sha256.Sum256(data[off:end])
+		f.Blocks = append(f.Blocks, protocol.BlockInfo{Size: uint32(end - off), Hash: hash[:]})
+	}
+	s.files = append(s.files, f)
+	s.data[name] = data
+}
+
+func (s *fakeSource) WithGlobal(folder string, fn func(protocol.FileInfo) bool) {
+	for _, f := range s.files {
+		if !fn(f) {
+			return
+		}
+	}
+}
+
+func (s *fakeSource) CurrentGlobalFile(folder, name string) protocol.FileInfo {
+	for _, f := range s.files {
+		if f.Name == name {
+			return f
+		}
+	}
+	return protocol.FileInfo{}
+}
+
+func (s *fakeSource) RequestBlock(folder, name string, block protocol.BlockInfo) ([]byte, error) {
+	s.requests++
+	if s.fail {
+		return nil, errors.New("no device")
+	}
+	data := s.data[filepath.ToSlash(name)]
+	return append([]byte(nil), data[block.Offset:block.Offset+int64(block.Size)]...), nil
+}
+
+func newFakeSource() *fakeSource {
+	s := &fakeSource{data: make(map[string][]byte)}
+	s.add("a/b/c.txt", []byte("0123456789"), 4)
+	s.add("top.txt", []byte("hello"), 4)
+	s.files = append(s.files,
+		protocol.FileInfo{Name: "a", Flags: protocol.FlagDirectory | 0755},
+		protocol.FileInfo{Name: "gone.txt", Flags: protocol.FlagDeleted},
+		protocol.FileInfo{Name: "bad.txt", Flags: protocol.FlagInvalid},
+	)
+	return s
+}
+
+func TestTree(t *testing.T) {
+	fs := New("default", newFakeSource())
+	if len(fs.Root().Children()) != 0 {
+		t.Fatal("tree should be empty before refresh")
+	}
+	fs.Refresh()
+
+	var names []string
+	for _, n := range fs.Root().Children() {
+		names = append(names, n.Name)
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "top.txt" {
+		t.Errorf("unexpected root entries %v", names)
+	}
+
+	a := fs.Lookup("a")
+	if a == nil || !a.IsDir() || a.Mode().Perm() != 0555 {
+		t.Errorf("unexpected node for a: %+v", a)
+	}
+
+	// The parent of c.txt has no entry of its own
+	b := fs.Lookup("a/b")
+	if b == nil || !b.IsDir() {
+		t.Errorf("unexpected node for a/b: %+v", b)
+	}
+
+	c := fs.Lookup("/a/b/c.txt")
+	if c == nil || c.IsDir() || c.Size != 10 || c.Mode().Perm() != 0444 || c.Modified != 1234 {
+		t.Errorf("unexpected node for a/b/c.txt: %+v", c)
+	}
+
+	for _, name := range []string{"gone.txt", "bad.txt", "a/nonexistent"} {
+		if n := fs.Lookup(name); n != nil {
+			t.Errorf("unexpected node for %s: %+v", name, n)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	src := newFakeSource()
+	fs := New("default", src)
+
+	fd, err := fs.Open("a/b/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	if fd.Size() != 10 {
+		t.Errorf("unexpected size %d", fd.Size())
+	}
+
+	// Spanning all three blocks
+	buf := make([]byte, 8)
+	n, err := fd.ReadAt(buf, 1)
+	if err != nil || n != 8 || string(buf) != "12345678" {
+		t.Errorf("unexpected read %d %q %v", n, buf[:n], err)
+	}
+
+	// Past the end
+	n, err = fd.ReadAt(buf, 6)
+	if err != io.EOF || n != 4 || string(buf[:n]) != "6789" {
+		t.Errorf("unexpected read %d %q %v", n, buf[:n], err)
+	}
+
+	// The last block is cached
+	reqs := src.requests
+	n, err = fd.ReadAt(buf[:1], 9)
+	if err != nil || n != 1 || buf[0] != '9' || src.requests != reqs {
+		t.Errorf("unexpected read %d %q %v, %d requests", n, buf[:n], err, src.requests-reqs)
+	}
+
+	n, err = fd.ReadAt(buf, 10)
+	if err != io.EOF || n != 0 {
+		t.Errorf("unexpected read %d %v", n, err)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	src := newFakeSource()
+	fs := New("default", src)
+
+	fd, err := fs.Open("top.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	src.fail = true
+	buf := make([]byte, 5)
+	if _, err := fd.ReadAt(buf, 0); err == nil {
+		t.Error("unexpected nil error")
+	}
+
+	src.fail = false
+	n, err := fd.ReadAt(buf, 0)
+	if err != nil || !bytes.Equal(buf[:n], []byte("hello")) {
+		t.Errorf("unexpected read %d %q %v", n, buf[:n], err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	fs := New("default", newFakeSource())
+
+	cases := map[string]error{
+		"a":           ErrIsDir,
+		"gone.txt":    ErrNotExist,
+		"bad.txt":     ErrNotExist,
+		"nonexisting": ErrNotExist,
+	}
+	for name, expected := range cases {
+		if _, err := fs.Open(name); err != expected {
+			t.Errorf("Open(%q): %v != %v", name, err, expected)
+		}
+	}
+}
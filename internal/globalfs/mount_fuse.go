@@ -0,0 +1,160 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build fuse
+// +build linux darwin freebsd
+
+package globalfs
+
+import (
+	"context"
+	"io"
+	"path"
+	"time"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+)
+
+const refreshInterval = time.Minute
+
+// Mount mounts the tree read only at the given directory and serves it
+// until it is unmounted. The tree is refreshed from the global state once
+// a minute while mounted.
+func Mount(fs *FS, dir string) error {
+	c, err := fuse.Mount(dir, fuse.ReadOnly(), fuse.FSName("syncthing"), fuse.Subtype("syncthing"), fuse.VolumeName(fs.folder))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	fs.Refresh()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		t := time.NewTicker(refreshInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				fs.Refresh()
+			}
+		}
+	}()
+
+	if err := fusefs.Serve(c, fuseFS{fs}); err != nil {
+		return err
+	}
+	<-c.Ready
+	return c.MountError
+}
+
+// Unmount unmounts the tree mounted at the given directory, which makes
+// Mount return.
+func Unmount(dir string) error {
+	return fuse.Unmount(dir)
+}
+
+type fuseFS struct {
+	fs *FS
+}
+
+func (f fuseFS) Root() (fusefs.Node, error) {
+	return fuseNode{f.fs, ""}, nil
+}
+
+// A fuseNode refers to its node by name, so that it sees the latest version
+// of the tree.
+type fuseNode struct {
+	fs   *FS
+	name string
+}
+
+func (n fuseNode) node() (*Node, error) {
+	node := n.fs.Lookup(n.name)
+	if node == nil {
+		return nil, fuse.ENOENT
+	}
+	return node, nil
+}
+
+func (n fuseNode) Attr(ctx context.Context, a *fuse.Attr) error {
+	node, err := n.node()
+	if err != nil {
+		return err
+	}
+	a.Mode = node.Mode()
+	a.Size = uint64(node.Size)
+	a.Mtime = node.ModTime()
+	a.Ctime = a.Mtime
+	return nil
+}
+
+func (n fuseNode) Lookup(ctx context.Context, name string) (fusefs.Node, error) {
+	node, err := n.node()
+	if err != nil {
+		return nil, err
+	}
+	child := node.Child(name)
+	if child == nil {
+		return nil, fuse.ENOENT
+	}
+	return fuseNode{n.fs, child.Name}, nil
+}
+
+func (n fuseNode) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	node, err := n.node()
+	if err != nil {
+		return nil, err
+	}
+	var ents []fuse.Dirent
+	for _, child := range node.Children() {
+		ent := fuse.Dirent{Name: path.Base(child.Name), Type: fuse.DT_File}
+		if child.IsDir() {
+			ent.Type = fuse.DT_Dir
+		}
+		ents = append(ents, ent)
+	}
+	return ents, nil
+}
+
+func (n fuseNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fusefs.Handle, error) {
+	node, err := n.node()
+	if err != nil {
+		return nil, err
+	}
+	if node.IsDir() {
+		return n, nil
+	}
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.EPERM
+	}
+	fd, err := n.fs.Open(n.name)
+	if err == ErrNotExist {
+		return nil, fuse.ENOENT
+	} else if err != nil {
+		return nil, err
+	}
+	return fuseHandle{fd}, nil
+}
+
+type fuseHandle struct {
+	fd *File
+}
+
+func (h fuseHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	buf := make([]byte, req.Size)
+	n, err := h.fd.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	resp.Data = buf[:n]
+	return nil
+}
+
+func (h fuseHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return h.fd.Close()
+}
@@ -0,0 +1,19 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !fuse !linux,!darwin,!freebsd
+
+package globalfs
+
+import "errors"
+
+var errNoFUSE = errors.New("mounting requires a build with the fuse tag on Linux, Mac OS X or FreeBSD")
+
+func Mount(fs *FS, dir string) error {
+	return errNoFUSE
+}
+
+func Unmount(dir string) error {
+	return errNoFUSE
+}
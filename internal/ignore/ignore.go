@@ -129,7 +129,8 @@ func parseIgnoreFile(fd io.Reader, currentFile string, seen map[string]bool) (Pa
 		case strings.HasSuffix(line, "/**"):
 			err = addPattern(line)
 		case strings.HasSuffix(line, "/"):
-			err = addPattern(line)
+			// The directory itself and everything in it
+			err = addPattern(strings.TrimSuffix(line, "/"))
 			if err == nil {
 				err = addPattern(line + "**")
 			}
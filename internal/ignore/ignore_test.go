@@ -151,3 +151,48 @@ func TestCommentsAndBlankLines(t *testing.T) {
 		t.Errorf("Expected no patterns")
 	}
 }
+
+func TestNestedPatterns(t *testing.T) {
+	stignore := `
+	// Rooted, anywhere and in named directories
+	/top/*.tmp
+	**/cache
+	!src/keep/generated/
+	src/*/generated/
+	docs/**/*.pdf
+	`
+	pats, err := ignore.Parse(bytes.NewBufferString(stignore), ".stignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		f string
+		r bool
+	}{
+		{filepath.Join("top", "a.tmp"), true},
+		{filepath.Join("top", "sub", "a.tmp"), false},
+		{filepath.Join("other", "top", "a.tmp"), false},
+
+		{"cache", true},
+		{filepath.Join("a", "b", "cache"), true},
+		{filepath.Join("a", "b", "cache", "file"), true},
+		{filepath.Join("a", "cached"), false},
+
+		{filepath.Join("src", "lib", "generated"), true},
+		{filepath.Join("src", "lib", "generated", "x.go"), true},
+		{filepath.Join("src", "lib", "x.go"), false},
+		{filepath.Join("src", "keep", "generated", "x.go"), false},
+		{filepath.Join("deep", "src", "lib", "generated", "x.go"), true},
+
+		{filepath.Join("docs", "a.pdf"), true},
+		{filepath.Join("docs", "a", "b", "c.pdf"), true},
+		{filepath.Join("docs", "a", "b", "c.txt"), false},
+	}
+
+	for _, tc := range tests {
+		if r := pats.Match(tc.f); r != tc.r {
+			t.Errorf("Incorrect match for %s: %v != %v", tc.f, r, tc.r)
+		}
+	}
+}
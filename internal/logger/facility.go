@@ -0,0 +1,68 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FacilityInfo describes a debug facility as returned by Facilities.
+type FacilityInfo struct {
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+}
+
+type facility struct {
+	description string
+	enabled     bool
+}
+
+// NewFacility registers a debug facility, typically a package. Its debug
+// output is initially enabled when STTRACE names it or is "all", and may be
+// changed by SetDebug.
+func (l *Logger) NewFacility(name, description string) {
+	trace := os.Getenv("STTRACE")
+	l.fmut.Lock()
+	defer l.fmut.Unlock()
+	if l.facilities == nil {
+		l.facilities = make(map[string]facility)
+	}
+	l.facilities[name] = facility{description, strings.Contains(trace, name) || trace == "all"}
+}
+
+// ShouldDebug returns whether debug output is enabled for the named
+// facility. It is checked each time, as it may change at any time.
+func (l *Logger) ShouldDebug(name string) bool {
+	l.fmut.Lock()
+	defer l.fmut.Unlock()
+	return l.facilities[name].enabled
+}
+
+// Facilities returns the registered debug facilities and their current
+// state.
+func (l *Logger) Facilities() map[string]FacilityInfo {
+	l.fmut.Lock()
+	defer l.fmut.Unlock()
+	res := make(map[string]FacilityInfo, len(l.facilities))
+	for name, f := range l.facilities {
+		res[name] = FacilityInfo{f.description, f.enabled}
+	}
+	return res
+}
+
+// SetDebug enables or disables debug output for the named facility.
+func (l *Logger) SetDebug(name string, enabled bool) error {
+	l.fmut.Lock()
+	defer l.fmut.Unlock()
+	f, ok := l.facilities[name]
+	if !ok {
+		return fmt.Errorf("unknown debug facility %q", name)
+	}
+	f.enabled = enabled
+	l.facilities[name] = f
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import "testing"
+
+func TestFacilities(t *testing.T) {
+	l := New()
+
+	l.NewFacility("test", "A test facility")
+	if l.ShouldDebug("test") {
+		t.Error("Facility unexpectedly enabled")
+	}
+
+	if err := l.SetDebug("test", true); err != nil {
+		t.Fatal(err)
+	}
+	if !l.ShouldDebug("test") {
+		t.Error("Facility not enabled")
+	}
+
+	fs := l.Facilities()
+	if f, ok := fs["test"]; !ok || !f.Enabled || f.Description != "A test facility" {
+		t.Errorf("Unexpected facility %+v", f)
+	}
+
+	if err := l.SetDebug("nonexistent", true); err == nil {
+		t.Error("Unexpected nil error for unknown facility")
+	}
+	if l.ShouldDebug("nonexistent") {
+		t.Error("Unknown facility enabled")
+	}
+}
+
+func TestFacilitiesConcurrent(t *testing.T) {
+	l := New()
+	l.NewFacility("test", "A test facility")
+
+	// Meant to be run with -race
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			l.SetDebug("test", i%2 == 0)
+		}
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		l.ShouldDebug("test")
+	}
+	<-done
+}
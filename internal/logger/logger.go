@@ -6,11 +6,16 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
 type LogLevel int
@@ -24,20 +29,78 @@ const (
 	NumLevels
 )
 
+var levelNames = [NumLevels]string{"DEBUG", "INFO", "OK", "WARNING", "FATAL"}
+
+func (l LogLevel) String() string {
+	if l < 0 || l >= NumLevels {
+		return "UNKNOWN"
+	}
+	return levelNames[l]
+}
+
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+type Format int
+
+const (
+	FormatText Format = iota // "15:04:05 INFO: message"
+	FormatJSON               // one JSON object per line
+)
+
+// ParseFormat returns the Format with the given name, "text" or "json".
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "", "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	default:
+		return FormatText, fmt.Errorf("unknown log format %q", s)
+	}
+}
+
 type MessageHandler func(l LogLevel, msg string)
 
 type Logger struct {
 	logger   *log.Logger
+	writer   io.Writer
+	prefix   string
+	format   Format
 	handlers [NumLevels][]MessageHandler
 	mut      sync.Mutex
+
+	facilities map[string]facility
+	fmut       sync.Mutex
 }
 
+// DefaultLogger is the process wide logger, used by components that
+// haven't been given a logger of their own.
 var DefaultLogger = New()
 
+// New returns a logger writing to standard output.
 func New() *Logger {
+	return NewWriter(os.Stdout)
+}
+
+// NewWriter returns a logger writing to w. Handlers are still called for
+// each message, so a logger writing to ioutil.Discard can be used to capture
+// messages without printing them.
+func NewWriter(w io.Writer) *Logger {
 	return &Logger{
-		logger: log.New(os.Stdout, "", log.Ltime),
+		logger: log.New(w, "", log.Ltime),
+		writer: w,
+	}
+}
+
+// Or returns l, or the default logger if l is nil. Components that accept
+// an optional logger use it to fall back to the default.
+func (l *Logger) Or() *Logger {
+	if l == nil {
+		return DefaultLogger
 	}
+	return l
 }
 
 func (l *Logger) AddHandler(level LogLevel, h MessageHandler) {
@@ -51,7 +114,50 @@ func (l *Logger) SetFlags(flag int) {
 }
 
 func (l *Logger) SetPrefix(prefix string) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
 	l.logger.SetPrefix(prefix)
+	l.prefix = prefix
+}
+
+// SetFormat selects between the traditional text output and JSON output.
+func (l *Logger) SetFormat(format Format) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.format = format
+}
+
+// A jsonEntry is a log line as output in FormatJSON.
+type jsonEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Package string    `json:"package,omitempty"`
+	Caller  string    `json:"caller,omitempty"`
+	Prefix  string    `json:"prefix,omitempty"`
+	Message string    `json:"message"`
+}
+
+// output writes the message in the selected format. It must be called
+// directly from the exported logging methods so that the call depth to the
+// original caller is correct.
+func (l *Logger) output(level LogLevel, s string) {
+	if l.format != FormatJSON {
+		l.logger.Output(3, level.String()+": "+s)
+		return
+	}
+
+	e := jsonEntry{
+		Time:    time.Now(),
+		Level:   level.String(),
+		Prefix:  strings.Trim(l.prefix, "[] "),
+		Message: strings.TrimSpace(s),
+	}
+	if _, file, line, ok := runtime.Caller(2); ok {
+		e.Package = filepath.Base(filepath.Dir(file))
+		e.Caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	bs, _ := json.Marshal(e)
+	l.writer.Write(append(bs, '\n'))
 }
 
 func (l *Logger) callHandlers(level LogLevel, s string) {
@@ -64,7 +170,7 @@ func (l *Logger) Debugln(vals ...interface{}) {
 	l.mut.Lock()
 	defer l.mut.Unlock()
 	s := fmt.Sprintln(vals...)
-	l.logger.Output(2, "DEBUG: "+s)
+	l.output(LevelDebug, s)
 	l.callHandlers(LevelDebug, s)
 }
 
@@ -72,14 +178,14 @@ func (l *Logger) Debugf(format string, vals ...interface{}) {
 	l.mut.Lock()
 	defer l.mut.Unlock()
 	s := fmt.Sprintf(format, vals...)
-	l.logger.Output(2, "DEBUG: "+s)
+	l.output(LevelDebug, s)
 	l.callHandlers(LevelDebug, s)
 }
 func (l *Logger) Infoln(vals ...interface{}) {
 	l.mut.Lock()
 	defer l.mut.Unlock()
 	s := fmt.Sprintln(vals...)
-	l.logger.Output(2, "INFO: "+s)
+	l.output(LevelInfo, s)
 	l.callHandlers(LevelInfo, s)
 }
 
@@ -87,7 +193,7 @@ func (l *Logger) Infof(format string, vals ...interface{}) {
 	l.mut.Lock()
 	defer l.mut.Unlock()
 	s := fmt.Sprintf(format, vals...)
-	l.logger.Output(2, "INFO: "+s)
+	l.output(LevelInfo, s)
 	l.callHandlers(LevelInfo, s)
 }
 
@@ -95,7 +201,7 @@ func (l *Logger) Okln(vals ...interface{}) {
 	l.mut.Lock()
 	defer l.mut.Unlock()
 	s := fmt.Sprintln(vals...)
-	l.logger.Output(2, "OK: "+s)
+	l.output(LevelOK, s)
 	l.callHandlers(LevelOK, s)
 }
 
@@ -103,7 +209,7 @@ func (l *Logger) Okf(format string, vals ...interface{}) {
 	l.mut.Lock()
 	defer l.mut.Unlock()
 	s := fmt.Sprintf(format, vals...)
-	l.logger.Output(2, "OK: "+s)
+	l.output(LevelOK, s)
 	l.callHandlers(LevelOK, s)
 }
 
@@ -111,7 +217,7 @@ func (l *Logger) Warnln(vals ...interface{}) {
 	l.mut.Lock()
 	defer l.mut.Unlock()
 	s := fmt.Sprintln(vals...)
-	l.logger.Output(2, "WARNING: "+s)
+	l.output(LevelWarn, s)
 	l.callHandlers(LevelWarn, s)
 }
 
@@ -119,7 +225,7 @@ func (l *Logger) Warnf(format string, vals ...interface{}) {
 	l.mut.Lock()
 	defer l.mut.Unlock()
 	s := fmt.Sprintf(format, vals...)
-	l.logger.Output(2, "WARNING: "+s)
+	l.output(LevelWarn, s)
 	l.callHandlers(LevelWarn, s)
 }
 
@@ -127,7 +233,7 @@ func (l *Logger) Fatalln(vals ...interface{}) {
 	l.mut.Lock()
 	defer l.mut.Unlock()
 	s := fmt.Sprintln(vals...)
-	l.logger.Output(2, "FATAL: "+s)
+	l.output(LevelFatal, s)
 	l.callHandlers(LevelFatal, s)
 	os.Exit(1)
 }
@@ -136,7 +242,7 @@ func (l *Logger) Fatalf(format string, vals ...interface{}) {
 	l.mut.Lock()
 	defer l.mut.Unlock()
 	s := fmt.Sprintf(format, vals...)
-	l.logger.Output(2, "FATAL: "+s)
+	l.output(LevelFatal, s)
 	l.callHandlers(LevelFatal, s)
 	os.Exit(1)
 }
@@ -5,6 +5,8 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
 	"strings"
 	"testing"
 )
@@ -57,3 +59,58 @@ func checkFunc(t *testing.T, expectl LogLevel, expectmsg string, counter *int) f
 		}
 	}
 }
+
+func TestJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewWriter(&buf)
+	l.SetPrefix("[ABCDE] ")
+	l.SetFormat(FormatJSON)
+
+	l.Infoln("test", 1)
+	l.Warnf("test %d", 2)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+
+	var e jsonEntry
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Level != "WARNING" || e.Message != "test 2" || e.Prefix != "ABCDE" || e.Package != "logger" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	if e.Time.IsZero() {
+		t.Error("Missing timestamp")
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	var b1, b2 bytes.Buffer
+	l1 := NewWriter(&b1)
+	l1.SetFlags(0)
+	l2 := NewWriter(&b2)
+	l2.SetFlags(0)
+
+	l1.Infoln("one")
+	l2.Warnln("two")
+
+	if s := b1.String(); s != "INFO: one\n" {
+		t.Errorf("Unexpected output %q", s)
+	}
+	if s := b2.String(); s != "WARNING: two\n" {
+		t.Errorf("Unexpected output %q", s)
+	}
+}
+
+func TestOr(t *testing.T) {
+	var l *Logger
+	if l.Or() != DefaultLogger {
+		t.Error("nil logger should fall back to the default")
+	}
+	l = New()
+	if l.Or() != l {
+		t.Error("non-nil logger should be returned as is")
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// A Recorder keeps the most recent log lines in memory.
+type Recorder struct {
+	lines []Line
+	size  int
+	mut   sync.Mutex
+}
+
+type Line struct {
+	When    time.Time `json:"when"`
+	Level   LogLevel  `json:"level"`
+	Message string    `json:"message"`
+}
+
+// NewRecorder returns a Recorder keeping the last size lines logged to l at
+// the given level or above.
+func NewRecorder(l *Logger, level LogLevel, size int) *Recorder {
+	r := &Recorder{
+		lines: make([]Line, 0, size),
+		size:  size,
+	}
+	for ; level < NumLevels; level++ {
+		l.AddHandler(level, r.append)
+	}
+	return r
+}
+
+func (r *Recorder) append(level LogLevel, msg string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if len(r.lines) == r.size {
+		copy(r.lines, r.lines[1:])
+		r.lines = r.lines[:r.size-1]
+	}
+	r.lines = append(r.lines, Line{time.Now(), level, msg})
+}
+
+// Since returns the recorded lines logged after the given time.
+func (r *Recorder) Since(t time.Time) []Line {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for i, line := range r.lines {
+		if line.When.After(t) {
+			res := make([]Line, len(r.lines)-i)
+			copy(res, r.lines[i:])
+			return res
+		}
+	}
+	return nil
+}
+
+// Clear removes all recorded lines.
+func (r *Recorder) Clear() {
+	r.mut.Lock()
+	r.lines = r.lines[:0]
+	r.mut.Unlock()
+}
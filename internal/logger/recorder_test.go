@@ -0,0 +1,45 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	l := New()
+	l.SetFlags(0)
+	r := NewRecorder(l, LevelInfo, 5)
+
+	t0 := time.Now()
+	l.Debugln("not recorded")
+	for i := 0; i < 8; i++ {
+		l.Infof("line %d", i)
+	}
+
+	lines := r.Since(t0.Add(-time.Second))
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 lines, got %d", len(lines))
+	}
+	for i, line := range lines {
+		if exp := fmt.Sprintf("line %d", i+3); line.Message != exp {
+			t.Errorf("Line %d is %q, expected %q", i, line.Message, exp)
+		}
+		if line.Level != LevelInfo {
+			t.Errorf("Line %d has level %v", i, line.Level)
+		}
+	}
+
+	if lines := r.Since(time.Now().Add(time.Second)); len(lines) != 0 {
+		t.Errorf("Expected no lines from the future, got %d", len(lines))
+	}
+
+	r.Clear()
+	if lines := r.Since(time.Time{}); len(lines) != 0 {
+		t.Errorf("Expected no lines after clear, got %d", len(lines))
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	facilityDaemon = 3
+	writeTimeout   = 10 * time.Second
+
+	// Messages queued while the syslog server is slow or unreachable;
+	// further messages are dropped rather than stall the caller.
+	syslogQueueSize = 1024
+)
+
+// Syslog severities for our log levels
+var severities = [NumLevels]int{
+	LevelDebug: 7, // debug
+	LevelInfo:  6, // info
+	LevelOK:    5, // notice
+	LevelWarn:  4, // warning
+	LevelFatal: 2, // critical
+}
+
+var localSyslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+type syslogWriter struct {
+	network  string // "unixgram", "unix", "udp", "tcp" or "tls"
+	addr     string
+	tag      string
+	hostname string
+	tlsCfg   *tls.Config
+	conn     net.Conn // used by serve only, once started
+	queue    chan string
+}
+
+// NewSyslogHandler returns a MessageHandler that sends messages to syslog.
+// The address is empty for the local syslog daemon, or an URL such as
+// "udp://host:514", "tcp://host:514" or "tls://host:6514" for a remote one.
+// The TLS configuration is used for "tls" addresses and may be nil. The
+// messages are sent in the background, as the handler is called with the
+// logger locked.
+func NewSyslogHandler(address, tag string, tlsCfg *tls.Config) (MessageHandler, error) {
+	w := &syslogWriter{
+		tag:    tag,
+		tlsCfg: tlsCfg,
+		queue:  make(chan string, syslogQueueSize),
+	}
+	w.hostname, _ = os.Hostname()
+
+	if address != "" {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "udp", "tcp", "tls":
+		default:
+			return nil, fmt.Errorf("unsupported syslog address %q", address)
+		}
+		w.network, w.addr = u.Scheme, u.Host
+	}
+
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	go w.serve()
+	return w.handle, nil
+}
+
+func (w *syslogWriter) connect() error {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	var err error
+	switch w.network {
+	case "":
+		for _, path := range localSyslogPaths {
+			for _, network := range []string{"unixgram", "unix"} {
+				w.conn, err = net.Dial(network, path)
+				if err == nil {
+					w.network, w.addr = network, path
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("no local syslog daemon found")
+	case "tls":
+		cfg := w.tlsCfg
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		w.conn, err = tls.DialWithDialer(&net.Dialer{Timeout: writeTimeout}, "tcp", w.addr, cfg)
+	default:
+		w.conn, err = net.DialTimeout(w.network, w.addr, writeTimeout)
+	}
+	return err
+}
+
+func (w *syslogWriter) handle(level LogLevel, msg string) {
+	pri := facilityDaemon*8 + severities[level]
+	var line string
+	if strings.HasPrefix(w.network, "unix") {
+		// The traditional local format
+		line = fmt.Sprintf("<%d>%s %s[%d]: %s", pri, time.Now().Format(time.Stamp), w.tag, os.Getpid(), msg)
+	} else {
+		// RFC 5424
+		line = fmt.Sprintf("<%d>1 %s %s %s %d - - %s", pri, time.Now().Format(time.RFC3339), w.hostname, w.tag, os.Getpid(), msg)
+	}
+	if w.network == "tcp" || w.network == "tls" {
+		// Octet counting framing, RFC 6587
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+
+	select {
+	case w.queue <- line:
+	default:
+		// The server isn't keeping up; drop the message
+	}
+}
+
+func (w *syslogWriter) serve() {
+	for line := range w.queue {
+		w.write(line)
+	}
+}
+
+func (w *syslogWriter) write(line string) {
+	// Reconnect and retry once if the write fails, as the connection may
+	// have been dropped by the remote side or the syslog daemon restarted.
+	for i := 0; i < 2; i++ {
+		if w.conn == nil {
+			if err := w.connect(); err != nil {
+				return
+			}
+		}
+		w.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := w.conn.Write([]byte(line)); err == nil {
+			return
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+const journaldSocket = "/run/systemd/journal/socket"
+
+type journaldWriter struct {
+	tag  string
+	conn net.Conn
+	mut  sync.Mutex
+}
+
+// NewJournaldHandler returns a MessageHandler that sends messages to the
+// systemd journal using its native protocol.
+func NewJournaldHandler(tag string) (MessageHandler, error) {
+	conn, err := net.Dial("unixgram", journaldSocket)
+	if err != nil {
+		return nil, err
+	}
+	w := &journaldWriter{
+		tag:  tag,
+		conn: conn,
+	}
+	return w.handle, nil
+}
+
+func (w *journaldWriter) handle(level LogLevel, msg string) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	var buf bytes.Buffer
+	journaldField(&buf, "PRIORITY", fmt.Sprint(severities[level]))
+	journaldField(&buf, "SYSLOG_IDENTIFIER", w.tag)
+	journaldField(&buf, "MESSAGE", msg)
+	w.conn.Write(buf.Bytes())
+}
+
+// journaldField writes a field in the journal export format, using the
+// binary variant for values containing newlines.
+func journaldField(buf *bytes.Buffer, key, val string) {
+	if !strings.Contains(val, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", key, val)
+		return
+	}
+	buf.WriteString(key)
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(val)))
+	buf.WriteString(val)
+	buf.WriteByte('\n')
+}
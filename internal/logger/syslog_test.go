@@ -0,0 +1,86 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	h, err := NewSyslogHandler("udp://"+conn.LocalAddr().String(), "syncthing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(LevelWarn, "test message")
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<28>1 ") {
+		t.Errorf("Incorrect priority or version in %q", msg)
+	}
+	if !strings.Contains(msg, " syncthing ") {
+		t.Errorf("Missing tag in %q", msg)
+	}
+	if !strings.HasSuffix(msg, " - - test message") {
+		t.Errorf("Incorrect message in %q", msg)
+	}
+}
+
+func TestSyslogQueueFull(t *testing.T) {
+	// Nothing drains the queue, as when the server is unreachable
+	w := &syslogWriter{network: "udp", queue: make(chan string, 1)}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			w.handle(LevelInfo, "test message")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler blocked on a full queue")
+	}
+	if len(w.queue) != 1 {
+		t.Errorf("%d messages queued, expected 1", len(w.queue))
+	}
+}
+
+func TestSyslogInvalidAddress(t *testing.T) {
+	if _, err := NewSyslogHandler("http://example.com/", "syncthing", nil); err == nil {
+		t.Error("Unexpected nil error")
+	}
+}
+
+func TestJournaldField(t *testing.T) {
+	var buf bytes.Buffer
+	journaldField(&buf, "MESSAGE", "simple")
+	if buf.String() != "MESSAGE=simple\n" {
+		t.Errorf("Incorrect simple field %q", buf.String())
+	}
+
+	buf.Reset()
+	journaldField(&buf, "MESSAGE", "two\nlines")
+	expected := "MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\n"
+	if buf.String() != expected {
+		t.Errorf("Incorrect binary field %q", buf.String())
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package metrics keeps internal performance counters such as operation
+// durations, throughput and queue depths.
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// A Registry holds named metrics.
+type Registry struct {
+	metrics map[string]metric
+	reset   time.Time
+	mut     sync.Mutex
+}
+
+type metric interface {
+	snapshot() interface{}
+	clear()
+}
+
+// Default is the registry used by the package level functions.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+		reset:   time.Now(),
+	}
+}
+
+func (r *Registry) get(name string, create func() metric) metric {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	m, ok := r.metrics[name]
+	if !ok {
+		m = create()
+		r.metrics[name] = m
+		if debug() {
+			l.Debugln("new metric", name)
+		}
+	}
+	return m
+}
+
+// Timer returns the named Timer, creating it if necessary.
+func (r *Registry) Timer(name string) *Timer {
+	return r.get(name, func() metric { return &Timer{} }).(*Timer)
+}
+
+// Meter returns the named Meter, creating it if necessary.
+func (r *Registry) Meter(name string) *Meter {
+	return r.get(name, func() metric { return &Meter{} }).(*Meter)
+}
+
+// Gauge returns the named Gauge, creating it if necessary.
+func (r *Registry) Gauge(name string) *Gauge {
+	return r.get(name, func() metric { return &Gauge{} }).(*Gauge)
+}
+
+// Snapshot returns the current value of all metrics, keyed by name, and the
+// time they were last reset.
+func (r *Registry) Snapshot() (map[string]interface{}, time.Time) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	res := make(map[string]interface{}, len(r.metrics))
+	for name, m := range r.metrics {
+		res[name] = m.snapshot()
+	}
+	return res, r.reset
+}
+
+// Reset clears all counters and timers. Gauges keep their current value,
+// since they describe present state.
+func (r *Registry) Reset() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for _, m := range r.metrics {
+		m.clear()
+	}
+	r.reset = time.Now()
+}
+
+func GetTimer(name string) *Timer {
+	return Default.Timer(name)
+}
+
+func GetMeter(name string) *Meter {
+	return Default.Meter(name)
+}
+
+func GetGauge(name string) *Gauge {
+	return Default.Gauge(name)
+}
+
+// A Timer records the number and duration of an operation.
+type Timer struct {
+	count int64
+	total time.Duration
+	min   time.Duration
+	max   time.Duration
+	mut   sync.Mutex
+}
+
+type TimerSnapshot struct {
+	Count  int64   `json:"count"`
+	TotalS float64 `json:"totalS"`
+	MeanMs float64 `json:"meanMs"`
+	MinMs  float64 `json:"minMs"`
+	MaxMs  float64 `json:"maxMs"`
+}
+
+// Update records an operation that took the given time.
+func (t *Timer) Update(d time.Duration) {
+	t.mut.Lock()
+	if t.count == 0 || d < t.min {
+		t.min = d
+	}
+	if d > t.max {
+		t.max = d
+	}
+	t.count++
+	t.total += d
+	t.mut.Unlock()
+}
+
+// UpdateSince records an operation that started at the given time and
+// finished now.
+func (t *Timer) UpdateSince(t0 time.Time) {
+	t.Update(time.Since(t0))
+}
+
+func (t *Timer) snapshot() interface{} {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	s := TimerSnapshot{
+		Count:  t.count,
+		TotalS: t.total.Seconds(),
+		MinMs:  ms(t.min),
+		MaxMs:  ms(t.max),
+	}
+	if t.count > 0 {
+		s.MeanMs = ms(t.total) / float64(t.count)
+	}
+	return s
+}
+
+func (t *Timer) clear() {
+	t.mut.Lock()
+	t.count, t.total, t.min, t.max = 0, 0, 0, 0
+	t.mut.Unlock()
+}
+
+// A Meter records an amount of data processed and the time spent processing
+// it, giving the throughput while busy.
+type Meter struct {
+	bytes int64
+	busy  time.Duration
+	mut   sync.Mutex
+}
+
+type MeterSnapshot struct {
+	Bytes       int64   `json:"bytes"`
+	BusyS       float64 `json:"busyS"`
+	BytesPerSec float64 `json:"bytesPerSec"`
+}
+
+// Mark records that the given number of bytes were processed in the given
+// time.
+func (m *Meter) Mark(bytes int64, d time.Duration) {
+	m.mut.Lock()
+	m.bytes += bytes
+	m.busy += d
+	m.mut.Unlock()
+}
+
+func (m *Meter) snapshot() interface{} {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	s := MeterSnapshot{
+		Bytes: m.bytes,
+		BusyS: m.busy.Seconds(),
+	}
+	if m.busy > 0 {
+		s.BytesPerSec = float64(m.bytes) / m.busy.Seconds()
+	}
+	return s
+}
+
+func (m *Meter) clear() {
+	m.mut.Lock()
+	m.bytes, m.busy = 0, 0
+	m.mut.Unlock()
+}
+
+// A Gauge holds a current value, such as a queue depth.
+type Gauge struct {
+	val int64
+	mut sync.Mutex
+}
+
+func (g *Gauge) Set(v int64) {
+	g.mut.Lock()
+	g.val = v
+	g.mut.Unlock()
+}
+
+func (g *Gauge) Add(d int64) {
+	g.mut.Lock()
+	g.val += d
+	g.mut.Unlock()
+}
+
+func (g *Gauge) snapshot() interface{} {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.val
+}
+
+func (g *Gauge) clear() {}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimer(t *testing.T) {
+	r := NewRegistry()
+	r.Timer("test").Update(10 * time.Millisecond)
+	r.Timer("test").Update(30 * time.Millisecond)
+
+	snap, _ := r.Snapshot()
+	s := snap["test"].(TimerSnapshot)
+	if s.Count != 2 || s.MinMs != 10 || s.MaxMs != 30 || s.MeanMs != 20 {
+		t.Errorf("Unexpected snapshot %+v", s)
+	}
+}
+
+func TestMeter(t *testing.T) {
+	r := NewRegistry()
+	r.Meter("test").Mark(1000, 500*time.Millisecond)
+	r.Meter("test").Mark(1000, 500*time.Millisecond)
+
+	snap, _ := r.Snapshot()
+	s := snap["test"].(MeterSnapshot)
+	if s.Bytes != 2000 || s.BytesPerSec != 2000 {
+		t.Errorf("Unexpected snapshot %+v", s)
+	}
+}
+
+func TestReset(t *testing.T) {
+	r := NewRegistry()
+	r.Timer("timer").Update(time.Second)
+	r.Gauge("gauge").Set(5)
+	r.Gauge("gauge").Add(-2)
+
+	_, t0 := r.Snapshot()
+	r.Reset()
+	snap, t1 := r.Snapshot()
+
+	if !t1.After(t0) {
+		t.Error("Reset time not updated")
+	}
+	if s := snap["timer"].(TimerSnapshot); s.Count != 0 {
+		t.Errorf("Timer not reset: %+v", s)
+	}
+	if v := snap["gauge"].(int64); v != 3 {
+		t.Errorf("Gauge changed by reset: %d", v)
+	}
+}
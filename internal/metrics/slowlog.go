@@ -0,0 +1,81 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+const slowLogSize = 100
+
+// A SlowOp is an operation that took longer than the threshold for its kind.
+type SlowOp struct {
+	Kind      string    `json:"kind"`    // "scan", "pull", "index" etc.
+	Subject   string    `json:"subject"` // the folder, file or device concerned
+	Started   time.Time `json:"started"`
+	DurationS float64   `json:"durationS"`
+}
+
+var (
+	slowThresholds = make(map[string]time.Duration)
+	slowOps        = make([]SlowOp, 0, slowLogSize)
+	slowMut        sync.Mutex
+)
+
+// SetSlowThreshold sets the duration above which operations of the given
+// kind are recorded in the slow log. A zero duration disables recording.
+func SetSlowThreshold(kind string, d time.Duration) {
+	slowMut.Lock()
+	slowThresholds[kind] = d
+	slowMut.Unlock()
+}
+
+// EndSpan records an operation of the given kind, which started at t0 and
+// has now finished, in the slow log if it took longer than the threshold.
+// It's suitable for calling as "defer metrics.EndSpan(kind, subject,
+// time.Now())".
+func EndSpan(kind, subject string, t0 time.Time) {
+	d := time.Since(t0)
+
+	slowMut.Lock()
+	defer slowMut.Unlock()
+
+	threshold := slowThresholds[kind]
+	if threshold == 0 || d < threshold {
+		return
+	}
+
+	if debug() {
+		l.Debugf("slow %s of %q: %v", kind, subject, d)
+	}
+
+	if len(slowOps) == slowLogSize {
+		copy(slowOps, slowOps[1:])
+		slowOps = slowOps[:slowLogSize-1]
+	}
+	slowOps = append(slowOps, SlowOp{
+		Kind:      kind,
+		Subject:   subject,
+		Started:   t0,
+		DurationS: d.Seconds(),
+	})
+}
+
+// SlowOps returns the recorded slow operations, oldest first.
+func SlowOps() []SlowOp {
+	slowMut.Lock()
+	defer slowMut.Unlock()
+	res := make([]SlowOp, len(slowOps))
+	copy(res, slowOps)
+	return res
+}
+
+// ClearSlowOps empties the slow log.
+func ClearSlowOps() {
+	slowMut.Lock()
+	slowOps = slowOps[:0]
+	slowMut.Unlock()
+}
@@ -0,0 +1,35 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowLog(t *testing.T) {
+	ClearSlowOps()
+	SetSlowThreshold("test", time.Second)
+	defer SetSlowThreshold("test", 0)
+
+	EndSpan("test", "fast", time.Now())
+	EndSpan("test", "slow", time.Now().Add(-2*time.Second))
+	EndSpan("untracked", "slow", time.Now().Add(-2*time.Second))
+
+	ops := SlowOps()
+	if len(ops) != 1 {
+		t.Fatalf("Expected 1 slow op, got %d", len(ops))
+	}
+	if ops[0].Kind != "test" || ops[0].Subject != "slow" || ops[0].DurationS < 2 {
+		t.Errorf("Unexpected slow op %+v", ops[0])
+	}
+
+	for i := 0; i < slowLogSize+10; i++ {
+		EndSpan("test", "slow", time.Now().Add(-2*time.Second))
+	}
+	if l := len(SlowOps()); l != slowLogSize {
+		t.Errorf("Slow log has %d entries, expected %d", l, slowLogSize)
+	}
+}
@@ -4,7 +4,7 @@
 
 package model
 
-import "github.com/syncthing/syncthing/internal/protocol"
+import "github.com/syncthing/syncthing/lib/protocol"
 
 type bqAdd struct {
 	file protocol.FileInfo
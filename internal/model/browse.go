@@ -0,0 +1,106 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+var errNotADirectory = errors.New("not a directory")
+
+// A BrowseEntry is a file or directory in the cluster's version of a
+// folder. Local is set when this device has that version of it.
+type BrowseEntry struct {
+	Name      string
+	Directory bool
+	Size      int64
+	Modified  time.Time
+	Local     bool
+}
+
+// Browse lists the entries of the directory at prefix, given with forward
+// slashes relative to the folder root, as they are in the cluster. Deleted
+// and invalid files are left out. An empty prefix lists the folder root.
+func (m *Model) Browse(folder, prefix string) ([]BrowseEntry, error) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return nil, errors.New("no such folder")
+	}
+
+	prefix = strings.Trim(filepath.Clean(filepath.FromSlash(prefix)), string(os.PathSeparator))
+	if prefix == "." {
+		prefix = ""
+	}
+	if prefix != "" {
+		gf := fs.GetGlobal(prefix)
+		if gf.Name != "" && !gf.IsDeleted() && !protocol.IsDirectory(gf.Flags) {
+			return nil, errNotADirectory
+		}
+		prefix += string(os.PathSeparator)
+	}
+
+	// The entries directly below the prefix, and directories that are only
+	// implied by the files in them
+	var names []string
+	implied := make(map[string]bool)
+	fs.WithGlobalTruncated(func(intf protocol.FileIntf) bool {
+		f := intf.(protocol.FileInfoTruncated)
+		if f.IsDeleted() || f.IsInvalid() || !strings.HasPrefix(f.Name, prefix) {
+			return true
+		}
+		rest := f.Name[len(prefix):]
+		if i := strings.IndexRune(rest, os.PathSeparator); i >= 0 {
+			implied[rest[:i]] = true
+		} else {
+			names = append(names, f.Name)
+		}
+		return true
+	})
+
+	res := make([]BrowseEntry, 0, len(names)+len(implied))
+	have := make(map[string]bool)
+	for _, name := range names {
+		gf := fs.GetGlobal(name)
+		lf := fs.Get(protocol.LocalDeviceID, name)
+		e := BrowseEntry{
+			Name:      name[len(prefix):],
+			Directory: protocol.IsDirectory(gf.Flags),
+			Size:      contentSize(gf),
+			Modified:  gf.ModTime(),
+			Local:     lf.Name != "" && !lf.IsDeleted() && !lf.IsInvalid() && lf.Version == gf.Version,
+		}
+		have[e.Name] = true
+		res = append(res, e)
+	}
+	for name := range implied {
+		if have[name] {
+			continue
+		}
+		lf := fs.Get(protocol.LocalDeviceID, prefix+name)
+		res = append(res, BrowseEntry{
+			Name:      name,
+			Directory: true,
+			Local:     lf.Name != "" && !lf.IsDeleted(),
+		})
+	}
+
+	sort.Sort(browseEntries(res))
+	return res, nil
+}
+
+type browseEntries []BrowseEntry
+
+func (l browseEntries) Len() int           { return len(l) }
+func (l browseEntries) Less(a, b int) bool { return l[a].Name < l[b].Name }
+func (l browseEntries) Swap(a, b int)      { l[a], l[b] = l[b], l[a] }
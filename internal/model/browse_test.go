@@ -0,0 +1,76 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestBrowse(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata"})
+
+	local := []protocol.FileInfo{
+		{Name: "dir", Flags: protocol.FlagDirectory | 0755, Version: 1},
+		{Name: "file", Version: 1, Modified: 1000, Blocks: []protocol.BlockInfo{{Size: 10}}},
+		{Name: filepath.Join("dir", "old"), Version: 1, Blocks: []protocol.BlockInfo{{Size: 10}}},
+	}
+	m.folderFiles["default"].Update(protocol.LocalDeviceID, local)
+	remote := append(local[:2:2],
+		protocol.FileInfo{Name: filepath.Join("dir", "old"), Version: 2, Blocks: []protocol.BlockInfo{{Size: 20}}},
+		protocol.FileInfo{Name: filepath.Join("dir", "sub", "remote"), Version: 1, Blocks: []protocol.BlockInfo{{Size: 30}}},
+		protocol.FileInfo{Name: "gone", Flags: protocol.FlagDeleted, Version: 1},
+	)
+	m.folderFiles["default"].Update(device1, remote)
+
+	entries, err := m.Browse("default", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected root entries %+v", entries)
+	}
+	if e := entries[0]; e.Name != "dir" || !e.Directory || !e.Local {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := entries[1]; e.Name != "file" || e.Directory || e.Size != 10 || e.Modified.Unix() != 1000 || !e.Local {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	// The directory holding "remote" is only implied by it, and neither is
+	// present locally
+	entries, err = m.Browse("default", "dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected dir entries %+v", entries)
+	}
+	if e := entries[0]; e.Name != "old" || e.Size != 20 || e.Local {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := entries[1]; e.Name != "sub" || !e.Directory || e.Local {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	entries, _ = m.Browse("default", "dir/sub")
+	if len(entries) != 1 || entries[0].Name != "remote" || entries[0].Size != 30 {
+		t.Errorf("unexpected sub entries %+v", entries)
+	}
+
+	if _, err := m.Browse("default", "file"); err != errNotADirectory {
+		t.Errorf("unexpected error browsing a file: %v", err)
+	}
+	if _, err := m.Browse("nonexistent", ""); err == nil {
+		t.Error("unexpected nil error for a nonexistent folder")
+	}
+}
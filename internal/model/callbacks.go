@@ -0,0 +1,94 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import "github.com/syncthing/syncthing/lib/protocol"
+
+// A Conflict is a file that can't be synced as announced because it clashes
+// with an existing file, such as two names differing only in case on a case
+// insensitive filesystem, or with a concurrent local change that was kept as
+// a conflict copy.
+type Conflict struct {
+	Folder   string
+	File     protocol.FileInfo // the version being synced
+	Existing string            // name of the file it clashes with, or of the conflict copy
+	Reason   string
+}
+
+// An ItemError is a file that failed to sync. The puller tries again on its
+// next pass.
+type ItemError struct {
+	Folder string
+	Name   string
+	Action string // what failed, for example "pull", "delete" or "final"
+	Err    error
+}
+
+// A FolderError is a folder that has been stopped, for example because its
+// directory has gone missing. It's not synced until restarted.
+type FolderError struct {
+	Folder string
+	Err    error
+}
+
+// OnConflict registers a function to call for each conflict. Callbacks are
+// called synchronously from the folder's puller, so they should return
+// quickly and must not call back into the model.
+func (m *Model) OnConflict(fn func(Conflict)) {
+	m.cbmut.Lock()
+	m.conflictFns = append(m.conflictFns, fn)
+	m.cbmut.Unlock()
+}
+
+// OnItemError registers a function to call for each file that fails to
+// sync. The same restrictions as for OnConflict apply.
+func (m *Model) OnItemError(fn func(ItemError)) {
+	m.cbmut.Lock()
+	m.itemErrorFns = append(m.itemErrorFns, fn)
+	m.cbmut.Unlock()
+}
+
+// OnFolderError registers a function to call when a folder is stopped due
+// to an error. The same restrictions as for OnConflict apply.
+func (m *Model) OnFolderError(fn func(FolderError)) {
+	m.cbmut.Lock()
+	m.folderErrorFns = append(m.folderErrorFns, fn)
+	m.cbmut.Unlock()
+}
+
+func (m *Model) conflict(c Conflict) {
+	m.cbmut.RLock()
+	defer m.cbmut.RUnlock()
+	for _, fn := range m.conflictFns {
+		fn(c)
+	}
+}
+
+func (m *Model) itemError(folder, name, action string, err error) {
+	m.recordFailure(folder, name, action+": "+err.Error())
+
+	m.cbmut.RLock()
+	defer m.cbmut.RUnlock()
+	for _, fn := range m.itemErrorFns {
+		fn(ItemError{folder, name, action, err})
+	}
+}
+
+// invalidateFolder stops syncing the folder by marking it invalid in the
+// configuration.
+func (m *Model) invalidateFolder(folder string, err error) {
+	for i := range m.cfg.Folders {
+		if f := &m.cfg.Folders[i]; f.ID == folder {
+			f.Invalid = err.Error()
+			break
+		}
+	}
+
+	m.cbmut.RLock()
+	defer m.cbmut.RUnlock()
+	for _, fn := range m.folderErrorFns {
+		fn(FolderError{folder, err})
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestFolderErrorCallback(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	cfg := config.Configuration{
+		Folders: []config.FolderConfiguration{{ID: "default", Path: "testdata"}},
+	}
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+
+	var errs []FolderError
+	m.OnFolderError(func(e FolderError) {
+		errs = append(errs, e)
+	})
+
+	err := errors.New("folder path missing")
+	m.invalidateFolder("default", err)
+
+	if cfg.Folders[0].Invalid != err.Error() {
+		t.Errorf("folder not invalidated: %q", cfg.Folders[0].Invalid)
+	}
+	if len(errs) != 1 || errs[0].Folder != "default" || errs[0].Err != err {
+		t.Errorf("unexpected folder errors %v", errs)
+	}
+}
+
+func TestItemErrorCallback(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db, nil)
+
+	var errs []ItemError
+	m.OnItemError(func(e ItemError) {
+		errs = append(errs, e)
+	})
+
+	s := sharedPullerState{
+		file:     protocol.FileInfo{Name: "foo"},
+		folder:   "default",
+		realName: "nonexistent",
+		onError: func(context string, err error) {
+			m.itemError("default", "foo", context, err)
+		},
+	}
+
+	// Only the first error is reported
+	s.sourceFile()
+	s.earlyClose("pull", errors.New("second error"))
+
+	if len(errs) != 1 {
+		t.Fatalf("unexpected item errors %v", errs)
+	}
+	if e := errs[0]; e.Folder != "default" || e.Name != "foo" || e.Action != "src open" || e.Err == nil {
+		t.Errorf("unexpected item error %+v", e)
+	}
+}
+
+func TestConflictCallback(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db, nil)
+
+	var n1, n2 int
+	m.OnConflict(func(c Conflict) { n1++ })
+	m.OnConflict(func(c Conflict) { n2++ })
+
+	m.conflict(Conflict{Folder: "default", Existing: "FOO"})
+
+	if n1 != 1 || n2 != 1 {
+		t.Errorf("callbacks called %d and %d times, expected once each", n1, n2)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+// The model reads most of its settings from the configuration as it needs
+// them. The folders and the I/O and event settings are set up when the
+// model starts, so changing those requires a restart.
+
+// VerifyConfiguration returns an error if the folders of the new
+// configuration cannot be used. Implements the config.Committer interface.
+func (m *Model) VerifyConfiguration(from, to config.Configuration) error {
+	seen := make(map[string]bool, len(to.Folders))
+	for _, folder := range to.Folders {
+		if folder.ID == "" {
+			return fmt.Errorf("folder with path %q has no ID", folder.Path)
+		}
+		if seen[folder.ID] {
+			return fmt.Errorf("duplicate folder ID %q", folder.ID)
+		}
+		seen[folder.ID] = true
+	}
+	return nil
+}
+
+// CommitConfiguration disconnects the devices that have been removed, and
+// returns false if the folders or the settings the model is set up with have
+// changed, other than by the model itself. Implements the config.Committer
+// interface.
+func (m *Model) CommitConfiguration(from, to config.Configuration) bool {
+	toDevices := to.DeviceMap()
+	for _, device := range from.Devices {
+		if _, ok := toDevices[device.DeviceID]; !ok && device.DeviceID != m.id {
+			m.Disconnect(device.DeviceID)
+		}
+	}
+
+	if !m.foldersApplied(from, to) {
+		return false
+	}
+
+	return from.Options.ItemEventIntervalMs == to.Options.ItemEventIntervalMs &&
+		from.Options.DiskIOSlots == to.Options.DiskIOSlots &&
+		from.Options.NetworkIOSlots == to.Options.NetworkIOSlots
+}
+
+// foldersApplied returns whether the folders of the new configuration are
+// those the model runs: the same as before, except for the shares the model
+// has stopped itself.
+func (m *Model) foldersApplied(from, to config.Configuration) bool {
+	fromFolders, toFolders := from.FolderMap(), to.FolderMap()
+	if len(fromFolders) != len(toFolders) {
+		return false
+	}
+
+	m.fmut.RLock()
+	defer m.fmut.RUnlock()
+	for id, toFolder := range toFolders {
+		fromFolder, ok := fromFolders[id]
+		if !ok {
+			return false
+		}
+		if reflect.DeepEqual(fromFolder, toFolder) {
+			continue
+		}
+
+		shares := toFolder.Devices
+		fromFolder.Devices, toFolder.Devices = nil, nil
+		if !reflect.DeepEqual(fromFolder, toFolder) || len(shares) != len(m.folderDevices[id]) {
+			return false
+		}
+		for _, share := range shares {
+			if !m.sharedWith(id, share.DeviceID) {
+				return false
+			}
+		}
+	}
+	return true
+}
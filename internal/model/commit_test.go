@@ -0,0 +1,60 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestCommitConfiguration(t *testing.T) {
+	cfg := config.New("/tmp/test", device1)
+	cfg.Devices = []config.DeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}}
+	cfg.Folders = []config.FolderConfiguration{
+		{ID: "default", Path: "testdata", Devices: []config.FolderDeviceConfiguration{{DeviceID: device1}}},
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(cfg.Folders[0])
+
+	w := config.Wrap(&cfg)
+	w.Subscribe(m)
+
+	// Devices and options the model reads as it goes are changed live
+	to := w.Raw()
+	to.Devices = []config.DeviceConfiguration{{DeviceID: device1}}
+	to.Options.DeviceExpiryDays = 30
+	if resp := w.Replace(to); resp.ValidationError != nil || resp.RequiresRestart {
+		t.Fatalf("Unexpected response %+v", resp)
+	}
+	if cfg.GetDeviceConfiguration(device2) != nil || cfg.Options.DeviceExpiryDays != 30 {
+		t.Error("Configuration not replaced")
+	}
+
+	// Duplicate folders are refused
+	to = w.Raw()
+	to.Folders = append([]config.FolderConfiguration{to.Folders[0]}, to.Folders...)
+	if resp := w.Replace(to); resp.ValidationError == nil {
+		t.Error("Duplicate folder not refused")
+	}
+	if len(cfg.Folders) != 1 {
+		t.Error("Refused configuration was applied")
+	}
+
+	// Changing a folder requires a restart
+	to = w.Raw()
+	to.Folders = []config.FolderConfiguration{to.Folders[0]}
+	to.Folders[0].RescanIntervalS = 10
+	if resp := w.Replace(to); !resp.RequiresRestart {
+		t.Error("Changing a folder should require a restart")
+	}
+	if !w.RequiresRestart() {
+		t.Error("Restart not recorded")
+	}
+}
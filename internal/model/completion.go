@@ -0,0 +1,159 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+var errFolderNotShared = errors.New("folder is not shared with the device")
+
+// Computing the completion walks the whole index of the folder, so the
+// changes to it are gathered for this long before recomputing, rather than
+// recomputing for each batch of updates.
+var remoteCompletionDelay = time.Second
+
+// A RemoteCompletion is how much of the local data of a folder a remote
+// device has, according to the index we hold from it.
+type RemoteCompletion struct {
+	Completion float64 // percent of the local bytes the device has
+	LocalFiles int
+	LocalBytes int64
+	NeedFiles  int // local files the device lacks the current version of
+	NeedBytes  int64
+}
+
+// RemoteCompletion returns how much of the local data in the folder the
+// device has. Directories and symlinks count as files without contents,
+// and the sizes of files are estimated from their number of blocks.
+func (m *Model) RemoteCompletion(device protocol.DeviceID, folder string) (RemoteCompletion, error) {
+	m.fmut.RLock()
+	rf, ok := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return RemoteCompletion{}, errors.New("no such folder")
+	}
+	if !m.folderSharedWith(folder, device) {
+		return RemoteCompletion{}, errFolderNotShared
+	}
+
+	// The versions the device has, skipping files it can't use
+	remote := make(map[string]uint64)
+	rf.WithHaveTruncated(device, func(fi protocol.FileIntf) bool {
+		f := fi.(protocol.FileInfoTruncated)
+		if !f.IsInvalid() && !f.IsDeleted() {
+			remote[f.Name] = f.Version
+		}
+		return true
+	})
+
+	var res RemoteCompletion
+	rf.WithHaveTruncated(protocol.LocalDeviceID, func(fi protocol.FileIntf) bool {
+		f := fi.(protocol.FileInfoTruncated)
+		if f.IsDeleted() || f.IsInvalid() {
+			return true
+		}
+		var size int64
+		if !protocol.IsDirectory(f.Flags) {
+			size = f.Size()
+		}
+		res.LocalFiles++
+		res.LocalBytes += size
+		if v, ok := remote[f.Name]; !ok || v < f.Version {
+			res.NeedFiles++
+			res.NeedBytes += size
+		}
+		return true
+	})
+
+	switch {
+	case res.LocalBytes > 0:
+		res.Completion = 100 * (1 - float64(res.NeedBytes)/float64(res.LocalBytes))
+	case res.NeedFiles > 0:
+		res.Completion = 100 * (1 - float64(res.NeedFiles)/float64(res.LocalFiles))
+	default:
+		res.Completion = 100
+	}
+
+	if debug() {
+		l.Debugf("%v RemoteCompletion(%s, %q): %+v", m, device, folder, res)
+	}
+	return res, nil
+}
+
+// updateRemoteCompletion has the completion of the folder on the given
+// devices, or on all devices sharing it if none are given, recomputed
+// after remoteCompletionDelay, together with any other updates asked for
+// in the meantime.
+func (m *Model) updateRemoteCompletion(folder string, devices ...protocol.DeviceID) {
+	if len(devices) == 0 {
+		m.fmut.RLock()
+		devices = append(devices, m.folderDevices[folder]...)
+		m.fmut.RUnlock()
+	}
+
+	m.smut.Lock()
+	defer m.smut.Unlock()
+	pending, ok := m.completionPending[folder]
+	if !ok {
+		pending = make(map[protocol.DeviceID]bool)
+		m.completionPending[folder] = pending
+		time.AfterFunc(remoteCompletionDelay, func() {
+			m.recomputeRemoteCompletion(folder)
+		})
+	}
+	for _, device := range devices {
+		pending[device] = true
+	}
+}
+
+// recomputeRemoteCompletion recomputes the completion of the folder on the
+// devices pending for it, and announces the ones that have changed. Devices
+// we hold no index from are skipped; that includes this device.
+func (m *Model) recomputeRemoteCompletion(folder string) {
+	m.smut.Lock()
+	pending := m.completionPending[folder]
+	delete(m.completionPending, folder)
+	m.smut.Unlock()
+
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return
+	}
+
+	for device := range pending {
+		if fs.LocalVersion(device) == 0 {
+			continue
+		}
+		c, err := m.RemoteCompletion(device, folder)
+		if err != nil {
+			continue
+		}
+
+		m.smut.Lock()
+		prev, seen := m.remoteCompletion[folder][device]
+		if m.remoteCompletion[folder] == nil {
+			m.remoteCompletion[folder] = make(map[protocol.DeviceID]RemoteCompletion)
+		}
+		m.remoteCompletion[folder][device] = c
+		m.smut.Unlock()
+
+		if !seen || prev != c {
+			events.Default.Log(events.FolderCompletion, map[string]interface{}{
+				"folder":     folder,
+				"device":     device.String(),
+				"completion": c.Completion,
+				"needBytes":  c.NeedBytes,
+				"needItems":  c.NeedFiles,
+			})
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// completionBlocks returns n full blocks. Completion is computed from the
+// truncated index, which estimates the size of a file from its number of
+// blocks as all but the last full and the last half full.
+func completionBlocks(n int) []protocol.BlockInfo {
+	blocks := make([]protocol.BlockInfo, n)
+	for i := range blocks {
+		blocks[i].Size = protocol.BlockSize
+	}
+	return blocks
+}
+
+func TestRemoteCompletion(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{
+		ID:      "default",
+		Path:    "testdata",
+		Devices: []config.FolderDeviceConfiguration{{DeviceID: device1}},
+	})
+
+	local := []protocol.FileInfo{
+		{Name: "a", Version: 2, Blocks: completionBlocks(4)},
+		{Name: "b", Version: 2, Blocks: completionBlocks(1)},
+		{Name: "c", Version: 2, Flags: protocol.FlagDeleted},
+		{Name: "d", Version: 2, Flags: protocol.FlagDirectory},
+	}
+	m.folderFiles["default"].Update(protocol.LocalDeviceID, local)
+
+	if c, err := m.RemoteCompletion(device1, "default"); err != nil || c.Completion != 0 || c.LocalFiles != 3 || c.LocalBytes != 4*protocol.BlockSize || c.NeedFiles != 3 {
+		t.Errorf("unexpected completion for empty remote %+v, %v", c, err)
+	}
+
+	// The device has the current version of a, an old version of b and a
+	// newer version of d
+	remote := []protocol.FileInfo{
+		{Name: "a", Version: 2, Blocks: completionBlocks(4)},
+		{Name: "b", Version: 1, Blocks: completionBlocks(1)},
+		{Name: "d", Version: 3, Flags: protocol.FlagDirectory},
+	}
+	m.folderFiles["default"].Replace(device1, remote)
+
+	c, err := m.RemoteCompletion(device1, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Completion != 87.5 || c.NeedFiles != 1 || c.NeedBytes != protocol.BlockSize/2 {
+		t.Errorf("unexpected completion %+v", c)
+	}
+
+	if _, err := m.RemoteCompletion(device2, "default"); err != errFolderNotShared {
+		t.Errorf("unexpected error for unshared folder: %v", err)
+	}
+}
+
+func TestRemoteCompletionEvents(t *testing.T) {
+	defer func(d time.Duration) { remoteCompletionDelay = d }(remoteCompletionDelay)
+	remoteCompletionDelay = 50 * time.Millisecond
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{
+		ID:      "default",
+		Path:    "testdata",
+		Devices: []config.FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}},
+	})
+	m.folderFiles["default"].Update(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "a", Version: 2, Blocks: completionBlocks(4)},
+		{Name: "b", Version: 2, Blocks: completionBlocks(1)},
+	})
+
+	sub := events.Default.Subscribe(events.FolderCompletion)
+	defer events.Default.Unsubscribe(sub)
+
+	m.IndexUpdate(device1, "default", []protocol.FileInfo{
+		{Name: "a", Version: 2, Blocks: completionBlocks(4)},
+	})
+	ev, err := sub.Poll(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := ev.Data.(map[string]interface{})
+	if data["folder"] != "default" || data["device"] != device1.String() || data["completion"] != 87.5 || data["needBytes"] != int64(protocol.BlockSize/2) || data["needItems"] != 1 {
+		t.Errorf("unexpected event data %v", data)
+	}
+
+	// Nothing changed for device1, and we have no index from device2
+	m.updateRemoteCompletion("default")
+	if ev, err := sub.Poll(200 * time.Millisecond); err != events.ErrTimeout {
+		t.Errorf("unexpected event %v", ev)
+	}
+
+	m.IndexUpdate(device1, "default", []protocol.FileInfo{
+		{Name: "b", Version: 2, Blocks: completionBlocks(1)},
+	})
+	ev, err = sub.Poll(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := ev.Data.(map[string]interface{}); data["completion"] != 100.0 || data["needItems"] != 0 {
+		t.Errorf("unexpected event data %v", data)
+	}
+}
+
+func TestRemoteCompletionCoalesced(t *testing.T) {
+	defer func(d time.Duration) { remoteCompletionDelay = d }(remoteCompletionDelay)
+	remoteCompletionDelay = 100 * time.Millisecond
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{
+		ID:      "default",
+		Path:    "testdata",
+		Devices: []config.FolderDeviceConfiguration{{DeviceID: device1}},
+	})
+	m.folderFiles["default"].Update(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "a", Version: 2, Blocks: completionBlocks(1)},
+		{Name: "b", Version: 2, Blocks: completionBlocks(1)},
+	})
+
+	sub := events.Default.Subscribe(events.FolderCompletion)
+	defer events.Default.Unsubscribe(sub)
+
+	// Updates within the delay give one event, for the final state
+	for _, name := range []string{"a", "b"} {
+		m.IndexUpdate(device1, "default", []protocol.FileInfo{
+			{Name: name, Version: 2, Blocks: completionBlocks(1)},
+		})
+	}
+	ev, err := sub.Poll(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := ev.Data.(map[string]interface{}); data["completion"] != 100.0 {
+		t.Errorf("unexpected event data %v", data)
+	}
+	if ev, err := sub.Poll(200 * time.Millisecond); err != events.ErrTimeout {
+		t.Errorf("unexpected event %v", ev)
+	}
+}
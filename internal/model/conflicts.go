@@ -0,0 +1,113 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// A file changed here and on another device before either had seen the
+// other's change has been modified concurrently. The newest version wins as
+// usual, but the local version is kept as a conflict copy next to it rather
+// than being overwritten or deleted. The conflict copy is then scanned and
+// synced like any other new file.
+//
+// Version numbers don't tell concurrent changes from consecutive ones, so
+// the model remembers the files changed by local scans until another device
+// announces the same version. Changes made before a restart are forgotten,
+// and the newest version simply wins for those.
+
+// recordLocalEdits remembers the scanned files as changed locally.
+func (m *Model) recordLocalEdits(folder string, fs []protocol.FileInfo) {
+	m.smut.Lock()
+	defer m.smut.Unlock()
+	edits, ok := m.localEdits[folder]
+	if !ok {
+		edits = make(map[string]uint64)
+		m.localEdits[folder] = edits
+	}
+	for _, f := range fs {
+		if f.IsDeleted() || f.IsInvalid() || protocol.IsDirectory(f.Flags) {
+			delete(edits, f.Name)
+		} else {
+			edits[f.Name] = f.Version
+		}
+	}
+}
+
+// forgetLocalEdits forgets the local changes that the given files, from
+// another device or pulled from one, show have been seen by the cluster.
+func (m *Model) forgetLocalEdits(folder string, fs []protocol.FileInfo, pulled bool) {
+	m.smut.Lock()
+	defer m.smut.Unlock()
+	edits := m.localEdits[folder]
+	if len(edits) == 0 {
+		return
+	}
+	for _, f := range fs {
+		if v, ok := edits[f.Name]; ok && (pulled || v == f.Version) {
+			delete(edits, f.Name)
+		}
+	}
+}
+
+// locallyEdited returns whether the given version of the file was changed
+// locally and has not been seen on any other device.
+func (m *Model) locallyEdited(folder, name string, version uint64) bool {
+	m.smut.RLock()
+	defer m.smut.RUnlock()
+	v, ok := m.localEdits[folder][name]
+	return ok && v == version
+}
+
+// inConflict returns whether replacing our version of the file with the new
+// one would lose a concurrent local change.
+func (p *Puller) inConflict(file protocol.FileInfo) bool {
+	if !p.conflicts {
+		return false
+	}
+	cur := p.model.CurrentFolderFile(p.folder, file.Name)
+	if cur.Name == "" || cur.IsDeleted() || cur.IsInvalid() || protocol.IsDirectory(cur.Flags) || cur.IsSymlink() || cur.Version == file.Version {
+		return false
+	}
+	return p.model.locallyEdited(p.folder, file.Name, cur.Version)
+}
+
+// keepConflict moves our version of the file aside as a conflict copy, if
+// the new version was made without seeing a local change. The file must not
+// be replaced if it returns an error.
+func (p *Puller) keepConflict(file protocol.FileInfo) error {
+	if !p.inConflict(file) {
+		return nil
+	}
+
+	name := conflictName(file.Name, time.Now())
+	err := osutil.InWritableDir(func(path string) error {
+		return osutil.Rename(filepath.Join(p.dir, file.Name), path)
+	}, filepath.Join(p.dir, name))
+	if err != nil {
+		return err
+	}
+
+	p.model.log.Infof("Puller (folder %q, file %q): modified concurrently; local version kept as %q", p.folder, file.Name, name)
+	p.model.conflict(Conflict{
+		Folder:   p.folder,
+		File:     file,
+		Existing: name,
+		Reason:   "modified concurrently",
+	})
+	return nil
+}
+
+// conflictName returns the name of the conflict copy of the file made at
+// the given time, such as "dir/file.sync-conflict-20141231-235959.txt".
+func conflictName(name string, t time.Time) string {
+	ext := filepath.Ext(name)
+	return name[:len(name)-len(ext)] + ".sync-conflict-" + t.Format("20060102-150405") + ext
+}
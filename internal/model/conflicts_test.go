@@ -0,0 +1,164 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestConflictName(t *testing.T) {
+	t0 := time.Date(2014, 12, 31, 23, 59, 58, 0, time.Local)
+	var tests = []struct {
+		name, conflict string
+	}{
+		{"file.txt", "file.sync-conflict-20141231-235958.txt"},
+		{"file", "file.sync-conflict-20141231-235958"},
+		{filepath.Join("dir.d", "file"), filepath.Join("dir.d", "file.sync-conflict-20141231-235958")},
+		{"archive.tar.gz", "archive.tar.sync-conflict-20141231-235958.gz"},
+	}
+	for _, tc := range tests {
+		if c := conflictName(tc.name, t0); c != tc.conflict {
+			t.Errorf("Conflict name for %q is %q, not %q", tc.name, c, tc.conflict)
+		}
+	}
+}
+
+func setupConflictTest(t *testing.T) (*Model, *Puller, string) {
+	dir, err := ioutil.TempDir("", "conflicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("local change"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.New("/tmp/test", device1)
+	cfg.Folders = []config.FolderConfiguration{
+		{ID: "default", Path: dir, Devices: []config.FolderDeviceConfiguration{{DeviceID: device1}}},
+	}
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(cfg.Folders[0])
+	if err := m.ScanFolder("default"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Puller{
+		folder:    "default",
+		dir:       dir,
+		conflicts: true,
+		model:     m,
+		storage:   m.folderStorage["default"],
+		cases:     osutil.NewCaseChecker(),
+	}
+	return m, p, dir
+}
+
+func conflictCopies(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "file.sync-conflict-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestConcurrentModification(t *testing.T) {
+	m, p, dir := setupConflictTest(t)
+	defer os.RemoveAll(dir)
+
+	local := m.CurrentFolderFile("default", "file")
+	remote := protocol.FileInfo{
+		Name:     "file",
+		Flags:    0644,
+		Modified: local.Modified + 1,
+		Version:  local.Version + 100,
+	}
+
+	// The remote version was made without seeing the local change
+	var conflicts []Conflict
+	m.OnConflict(func(c Conflict) {
+		conflicts = append(conflicts, c)
+	})
+	if err := p.keepConflict(remote); err != nil {
+		t.Fatal(err)
+	}
+	copies := conflictCopies(t, dir)
+	if len(copies) != 1 {
+		t.Fatalf("Expected one conflict copy, not %v", copies)
+	}
+	if bs, _ := ioutil.ReadFile(copies[0]); string(bs) != "local change" {
+		t.Errorf("Conflict copy has %q", bs)
+	}
+	if len(conflicts) != 1 || conflicts[0].Existing != filepath.Base(copies[0]) {
+		t.Errorf("Unexpected conflicts %v", conflicts)
+	}
+}
+
+func TestSequentialModification(t *testing.T) {
+	m, p, dir := setupConflictTest(t)
+	defer os.RemoveAll(dir)
+
+	local := m.CurrentFolderFile("default", "file")
+	remote := protocol.FileInfo{
+		Name:     "file",
+		Flags:    0644,
+		Modified: local.Modified + 1,
+		Version:  local.Version + 100,
+	}
+
+	// Without the policy the newest version wins
+	p.conflicts = false
+	if p.inConflict(remote) {
+		t.Error("Conflict with the overwrite policy")
+	}
+	p.conflicts = true
+
+	// Once the other device has announced our version, its later changes
+	// are made on top of ours
+	m.IndexUpdate(device1, "default", []protocol.FileInfo{local})
+	if p.inConflict(remote) {
+		t.Error("Conflict with a change made after seeing ours")
+	}
+	if err := p.keepConflict(remote); err != nil {
+		t.Fatal(err)
+	}
+	if copies := conflictCopies(t, dir); len(copies) != 0 {
+		t.Errorf("Unexpected conflict copies %v", copies)
+	}
+}
+
+func TestConcurrentDeletion(t *testing.T) {
+	m, p, dir := setupConflictTest(t)
+	defer os.RemoveAll(dir)
+
+	local := m.CurrentFolderFile("default", "file")
+	deleted := protocol.FileInfo{
+		Name:     "file",
+		Flags:    local.Flags | protocol.FlagDeleted,
+		Modified: local.Modified,
+		Version:  local.Version + 100,
+	}
+	p.deleteFile(deleted)
+
+	if copies := conflictCopies(t, dir); len(copies) != 1 {
+		t.Errorf("Local change not kept: %v", copies)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "file")); !os.IsNotExist(err) {
+		t.Error("File not deleted")
+	}
+	if cf := m.CurrentFolderFile("default", "file"); !cf.IsDeleted() {
+		t.Errorf("Deletion not recorded: %v", cf)
+	}
+}
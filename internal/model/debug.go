@@ -4,14 +4,14 @@
 
 package model
 
-import (
-	"os"
-	"strings"
+import "github.com/syncthing/syncthing/internal/logger"
 
-	"github.com/syncthing/syncthing/internal/logger"
-)
+var l = logger.DefaultLogger
 
-var (
-	debug = strings.Contains(os.Getenv("STTRACE"), "model") || os.Getenv("STTRACE") == "all"
-	l     = logger.DefaultLogger
-)
+func init() {
+	l.NewFacility("model", "The root hierarchy; folder and device state, the puller and the scanner service")
+}
+
+func debug() bool {
+	return l.ShouldDebug("model")
+}
@@ -7,7 +7,7 @@ package model
 import (
 	"sync"
 
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
 // deviceActivity tracks the number of outstanding requests per device and can
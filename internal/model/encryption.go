@@ -0,0 +1,154 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"path/filepath"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// A device given an encryption password for a folder is untrusted with its
+// contents. Its index is sent encrypted, its requests are for encrypted
+// names and blocks, and what it announces is decrypted on the way in, so
+// that the rest of the model deals with the plaintext as usual.
+//
+// The untrusted device keeps the folder as a receive encrypted folder. It
+// stores what it is sent and passes it on to the other devices, which
+// decrypt it in turn, but never scans or verifies the data itself.
+
+// encryptionKey returns the key the folder is encrypted with for the
+// device, or nil if the device is trusted.
+func (m *Model) encryptionKey(folder string, deviceID protocol.DeviceID) *protocol.EncryptionKey {
+	m.fmut.RLock()
+	defer m.fmut.RUnlock()
+	return m.folderKeys[folder][deviceID]
+}
+
+// sharedEncrypted returns whether the folder is shared with any untrusted
+// device. Devices are not added to such a folder without the user saying
+// whether to trust them, as they would otherwise get the plaintext. It must
+// be called with fmut held.
+func (m *Model) sharedEncrypted(folder string) bool {
+	return len(m.folderKeys[folder]) > 0
+}
+
+// folderEncrypted returns whether we keep the folder encrypted for others.
+func (m *Model) folderEncrypted(folder string) bool {
+	m.fmut.RLock()
+	defer m.fmut.RUnlock()
+	return m.folderCfgs[folder].Type == config.FolderTypeReceiveEncrypted
+}
+
+// decryptIndex returns the files announced by the device as we know them.
+// The encrypted files from an untrusted device are decrypted, leaving out
+// the directories their names are spread over. A folder we keep encrypted
+// takes only encrypted files, so that a device mistakenly trusting us
+// can't fill it with plaintext.
+func (m *Model) decryptIndex(folder string, deviceID protocol.DeviceID, fs []protocol.FileInfo) []protocol.FileInfo {
+	key := m.encryptionKey(folder, deviceID)
+	encrypted := m.folderEncrypted(folder)
+	if key == nil && !encrypted {
+		return fs
+	}
+
+	res := fs[:0]
+	var failed int
+	for _, f := range fs {
+		value, ok := f.GetAttribute(protocol.AttributeEncrypted)
+		switch {
+		case !ok:
+			failed++
+		case encrypted:
+			res = append(res, f)
+		case value == "":
+			// A directory of encrypted names
+		default:
+			dec, err := key.DecryptFileInfo(f)
+			if err != nil {
+				if debug() {
+					l.Debugf("%v decrypt: %s: %q / %q: %v", m, deviceID, folder, f.Name, err)
+				}
+				failed++
+				continue
+			}
+			res = append(res, dec)
+		}
+	}
+
+	if failed > 0 && encrypted {
+		m.log.Warnf("Folder %q: ignoring %d unencrypted files from device %v; it must be given an encryption password for this device.", folder, failed, deviceID)
+	} else if failed > 0 {
+		m.log.Warnf("Folder %q: ignoring %d files from untrusted device %v that could not be decrypted; check that all devices use the same encryption password for it.", folder, failed, deviceID)
+	}
+	return res
+}
+
+// requestEncrypted answers a request from an untrusted device, for a block
+// of the encrypted file.
+func (m *Model) requestEncrypted(key *protocol.EncryptionKey, deviceID protocol.DeviceID, folder, name string, offset int64, size int) ([]byte, error) {
+	plainName, err := key.DecryptName(name)
+	if err != nil || size < protocol.EncryptionOverhead {
+		if debug() {
+			l.Debugf("%v REQ(in; undecryptable): %s: %q / %q o=%d s=%d", m, deviceID, folder, name, offset, size)
+		}
+		return nil, ErrNoSuchFile
+	}
+
+	bs, err := m.request(deviceID, folder, plainName, protocol.PlaintextOffset(offset), size-protocol.EncryptionOverhead)
+	if err != nil {
+		return nil, err
+	}
+	enc := key.EncryptBlock(bs)
+	protocol.BufferPool.Put(bs)
+	return enc, nil
+}
+
+// An encryptedConnection sends the index of a folder to an untrusted device
+// encrypted. The directories the encrypted names are spread over are sent
+// ahead of the first file in them, so that the device can create them.
+type encryptedConnection struct {
+	protocol.Connection
+	key  *protocol.EncryptionKey
+	dirs map[string]bool // directories already sent
+}
+
+func newEncryptedConnection(conn protocol.Connection, key *protocol.EncryptionKey) *encryptedConnection {
+	return &encryptedConnection{
+		Connection: conn,
+		key:        key,
+		dirs:       make(map[string]bool),
+	}
+}
+
+func (c *encryptedConnection) Index(folder string, fs []protocol.FileInfo) error {
+	return c.Connection.Index(folder, c.encrypt(fs))
+}
+
+func (c *encryptedConnection) IndexUpdate(folder string, fs []protocol.FileInfo) error {
+	return c.Connection.IndexUpdate(folder, c.encrypt(fs))
+}
+
+func (c *encryptedConnection) encrypt(fs []protocol.FileInfo) []protocol.FileInfo {
+	res := make([]protocol.FileInfo, 0, len(fs))
+	for _, f := range fs {
+		enc := c.key.EncryptFileInfo(f)
+		res = c.appendDirs(res, filepath.Dir(enc.Name))
+		res = append(res, enc)
+	}
+	return res
+}
+
+// appendDirs appends the directory and its parents not yet sent, outermost
+// first.
+func (c *encryptedConnection) appendDirs(fs []protocol.FileInfo, dir string) []protocol.FileInfo {
+	if dir == "." || c.dirs[dir] {
+		return fs
+	}
+	fs = c.appendDirs(fs, filepath.Dir(dir))
+	c.dirs[dir] = true
+	return append(fs, protocol.EncryptedDirectory(dir))
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+type indexRecorder struct {
+	FakeConnection
+	indexes [][]protocol.FileInfo
+}
+
+func (r *indexRecorder) Index(folder string, fs []protocol.FileInfo) error {
+	r.indexes = append(r.indexes, fs)
+	return nil
+}
+
+func (r *indexRecorder) IndexUpdate(folder string, fs []protocol.FileInfo) error {
+	r.indexes = append(r.indexes, fs)
+	return nil
+}
+
+func TestEncryptedIndex(t *testing.T) {
+	key := protocol.NewEncryptionKey("default", "secret")
+	hash := sha256.Sum256([]byte("data"))
+	files := []protocol.FileInfo{
+		{Name: "dir", Flags: protocol.FlagDirectory | 0755, Modified: 1000, Version: 1},
+		{Name: filepath.Join("dir", "file"), Flags: 0644, Modified: 2000, Version: 2, Blocks: []protocol.BlockInfo{{Size: 4, Hash: hash[:]}}},
+	}
+
+	rec := &indexRecorder{FakeConnection: FakeConnection{id: device1}}
+	conn := newEncryptedConnection(rec, key)
+	conn.Index("default", files)
+	conn.IndexUpdate("default", files[1:])
+
+	// Each encrypted file comes after the directories it is in, which are
+	// sent only once
+	if len(rec.indexes) != 2 || len(rec.indexes[1]) != 1 {
+		t.Fatalf("unexpected indexes %v", rec.indexes)
+	}
+	seen := make(map[string]bool)
+	for _, f := range rec.indexes[0] {
+		if f.Name == files[0].Name || f.Name == files[1].Name {
+			t.Errorf("plaintext name sent: %v", f)
+		}
+		if dir := filepath.Dir(f.Name); dir != "." && !seen[dir] {
+			t.Errorf("%q sent before its directory", f.Name)
+		}
+		seen[f.Name] = true
+	}
+	if len(rec.indexes[0]) != 2+2*2 {
+		t.Errorf("unexpected number of entries %d", len(rec.indexes[0]))
+	}
+
+	// The trusted side decrypts what the untrusted device announces
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata", Devices: []config.FolderDeviceConfiguration{
+		{DeviceID: device1, EncryptionPassword: "secret"},
+	}})
+	m.Index(device1, "default", append([]protocol.FileInfo(nil), rec.indexes[0]...))
+
+	var global []protocol.FileInfo
+	m.WithGlobal("default", func(f protocol.FileInfo) bool {
+		global = append(global, f)
+		return true
+	})
+	if len(global) != 2 {
+		t.Fatalf("unexpected global files %v", global)
+	}
+	for i, f := range global {
+		if f.Name != files[i].Name || f.Flags != files[i].Flags || f.Modified != files[i].Modified || f.Version != files[i].Version {
+			t.Errorf("decrypted %v, expected %v", f, files[i])
+		}
+	}
+	if f := global[1]; len(f.Blocks) != 1 || f.Blocks[0].Size != 4 || !bytes.Equal(f.Blocks[0].Hash, hash[:]) {
+		t.Errorf("unexpected decrypted blocks %v", f.Blocks)
+	}
+
+	// The untrusted device keeps the encrypted files, but not plaintext
+	// ones from a device that should encrypt them
+	db, _ = leveldb.Open(storage.NewMemStorage(), nil)
+	m = NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata", Type: config.FolderTypeReceiveEncrypted, Devices: []config.FolderDeviceConfiguration{
+		{DeviceID: device2},
+	}})
+	m.Index(device2, "default", append(append([]protocol.FileInfo(nil), rec.indexes[0]...), files...))
+	if n := len(m.NeedFolderFilesLimited("default", 0, 0)); n != len(rec.indexes[0]) {
+		t.Errorf("unexpected number of needed files %d", n)
+	}
+	if f := m.CurrentGlobalFile("default", files[1].Name); f.Name != "" {
+		t.Errorf("plaintext file accepted: %v", f)
+	}
+}
+
+func TestEncryptedRequest(t *testing.T) {
+	key := protocol.NewEncryptionKey("default", "secret")
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata", Devices: []config.FolderDeviceConfiguration{
+		{DeviceID: device1, EncryptionPassword: "secret"},
+	}})
+	m.ScanFolder("default")
+
+	// The untrusted device asks for the encrypted block
+	bs, err := m.Request(device1, "default", key.EncryptName("foo"), 0, len(data)+protocol.EncryptionOverhead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec, err := key.DecryptBlock(bs); err != nil || !bytes.Equal(dec, data) {
+		t.Errorf("unexpected block %q, %v", dec, err)
+	}
+	if _, err := m.Request(device1, "default", "foo", 0, len(data)); err != ErrNoSuchFile {
+		t.Errorf("plaintext request answered: %v", err)
+	}
+
+	// Blocks requested from it are decrypted
+	fc := FakeConnection{
+		id:          device1,
+		requestData: key.EncryptBlock(data),
+	}
+	m.protoConn[device1] = fc
+	bs, err = m.requestGlobal(device1, "default", "foo", 0, len(data), nil)
+	if err != nil || !bytes.Equal(bs, data) {
+		t.Errorf("unexpected block %q, %v", bs, err)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"time"
+
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// DeviceExpiryInterval is how often the devices are checked for expiry.
+const DeviceExpiryInterval = time.Hour
+
+// ExpireDevices pauses, or removes when the DeviceExpiryAction option says
+// so, the devices that have not been seen for DeviceExpiryDays. Devices that
+// have never been seen count from when they were first checked. Our own
+// device, introducers and connected devices are never expired. A
+// DeviceExpiring event is emitted for each device before it is acted upon.
+// It returns the expired devices.
+func (m *Model) ExpireDevices(self protocol.DeviceID, now time.Time) []protocol.DeviceID {
+	days := m.cfg.Options.DeviceExpiryDays
+	if days <= 0 {
+		return nil
+	}
+	action := "pause"
+	if m.cfg.Options.DeviceExpiryAction == "remove" {
+		action = "remove"
+	}
+	limit := now.Add(-time.Duration(days) * 24 * time.Hour)
+
+	var expired []protocol.DeviceID
+	for _, device := range m.cfg.Devices {
+		if device.DeviceID == self || device.Introducer || m.ConnectedTo(device.DeviceID) {
+			continue
+		}
+		if action == "pause" && device.Paused {
+			continue
+		}
+
+		ref := m.deviceStatRef(device.DeviceID)
+		seen := ref.GetLastSeen()
+		if seen.Unix() == 0 {
+			seen = ref.KnownSince()
+		}
+		if seen.After(limit) {
+			continue
+		}
+
+		events.Default.Log(events.DeviceExpiring, map[string]interface{}{
+			"device":   device.DeviceID.String(),
+			"lastSeen": seen,
+			"action":   action,
+		})
+		expired = append(expired, device.DeviceID)
+	}
+
+	for _, id := range expired {
+		if action == "remove" {
+			m.log.Infof("Removing device %v from config (not seen for %d days)", id, days)
+			m.fmut.Lock()
+			for _, folder := range append([]string(nil), m.deviceFolders[id]...) {
+				m.unshare(folder, id)
+			}
+			delete(m.deviceFolders, id)
+			m.fmut.Unlock()
+			m.cfg.RemoveDevice(id)
+		} else {
+			m.log.Infof("Pausing device %v (not seen for %d days)", id, days)
+			m.cfg.GetDeviceConfiguration(id).Paused = true
+		}
+	}
+
+	if len(expired) > 0 {
+		m.cfg.Save()
+	}
+	return expired
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestExpireDevices(t *testing.T) {
+	device3, _ := protocol.DeviceIDFromString("LGFPDIT-7SKNNJL-VJZA4FC-7QNCRKA-CE753K7-2BW5QDK-2FOZ7FR-FEP57QJ")
+
+	cfg := config.New("/tmp/test", device1)
+	cfg.Options.DeviceExpiryDays = 30
+	cfg.Devices = []config.DeviceConfiguration{
+		{DeviceID: device1},
+		{DeviceID: device2, Introducer: true},
+		{DeviceID: device3},
+	}
+	cfg.Folders = []config.FolderConfiguration{
+		{
+			ID: "folder1",
+			Devices: []config.FolderDeviceConfiguration{
+				{DeviceID: device1},
+				{DeviceID: device2},
+				{DeviceID: device3},
+			},
+		},
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(cfg.Folders[0])
+
+	// Nothing has been unseen for long enough yet
+	if expired := m.ExpireDevices(device1, time.Now()); len(expired) != 0 {
+		t.Fatalf("Unexpected expired devices %v", expired)
+	}
+
+	sub := events.Default.Subscribe(events.DeviceExpiring)
+	defer events.Default.Unsubscribe(sub)
+
+	later := time.Now().Add(31 * 24 * time.Hour)
+	expired := m.ExpireDevices(device1, later)
+	if len(expired) != 1 || expired[0] != device3 {
+		t.Fatalf("Unexpected expired devices %v", expired)
+	}
+	if !cfg.GetDeviceConfiguration(device3).Paused {
+		t.Error("Expired device not paused")
+	}
+	if cfg.GetDeviceConfiguration(device1).Paused || cfg.GetDeviceConfiguration(device2).Paused {
+		t.Error("Own device or introducer paused")
+	}
+	ev, err := sub.Poll(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := ev.Data.(map[string]interface{}); data["device"] != device3.String() || data["action"] != "pause" {
+		t.Errorf("Unexpected event data %v", data)
+	}
+
+	// Already paused devices are left alone
+	if expired := m.ExpireDevices(device1, later); len(expired) != 0 {
+		t.Errorf("Paused device expired again: %v", expired)
+	}
+
+	cfg.Options.DeviceExpiryAction = "remove"
+	if expired := m.ExpireDevices(device1, later); len(expired) != 1 {
+		t.Fatalf("Unexpected expired devices %v", expired)
+	}
+	if cfg.GetDeviceConfiguration(device3) != nil {
+		t.Error("Expired device not removed")
+	}
+	if m.sharedWith("folder1", device3) || len(cfg.GetFolderConfiguration("folder1").Devices) != 2 {
+		t.Error("Expired device still shares the folder")
+	}
+}
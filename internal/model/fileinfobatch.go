@@ -0,0 +1,61 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import "github.com/syncthing/syncthing/lib/protocol"
+
+const (
+	maxBatchFiles = 1000    // commit after this many files...
+	maxBatchBytes = 4 << 20 // ...or this many bytes of file infos
+)
+
+// A fileInfoBatch collects file infos and commits them in batches, as each
+// database write has a cost regardless of its size. The batch is limited by
+// both the number of files and their estimated size, so that scanning many
+// small files needs few writes while large files don't make the batch grow
+// without bounds.
+type fileInfoBatch struct {
+	infos   []protocol.FileInfo
+	size    int
+	flushFn func([]protocol.FileInfo)
+}
+
+func newFileInfoBatch(fn func([]protocol.FileInfo)) *fileInfoBatch {
+	return &fileInfoBatch{
+		infos:   make([]protocol.FileInfo, 0, maxBatchFiles),
+		flushFn: fn,
+	}
+}
+
+func (b *fileInfoBatch) append(f protocol.FileInfo) {
+	b.infos = append(b.infos, f)
+	b.size += fileInfoSize(f)
+	if len(b.infos) >= maxBatchFiles || b.size >= maxBatchBytes {
+		b.flush()
+	}
+}
+
+// flush commits the files collected so far, if any.
+func (b *fileInfoBatch) flush() {
+	if len(b.infos) == 0 {
+		return
+	}
+	b.flushFn(b.infos)
+	b.infos = b.infos[:0]
+	b.size = 0
+}
+
+// fileInfoSize returns roughly the number of bytes the file info takes in
+// the database.
+func fileInfoSize(f protocol.FileInfo) int {
+	size := 64 + len(f.Name)
+	for _, b := range f.Blocks {
+		size += 16 + len(b.Hash)
+	}
+	for _, a := range f.Attributes {
+		size += 8 + len(a.Key) + len(a.Value)
+	}
+	return size
+}
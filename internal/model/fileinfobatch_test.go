@@ -0,0 +1,49 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestFileInfoBatch(t *testing.T) {
+	var flushed []int
+	b := newFileInfoBatch(func(fs []protocol.FileInfo) {
+		flushed = append(flushed, len(fs))
+	})
+
+	// Small files are committed by count
+	for i := 0; i < maxBatchFiles+10; i++ {
+		b.append(protocol.FileInfo{Name: fmt.Sprintf("file%d", i)})
+	}
+	if len(flushed) != 1 || flushed[0] != maxBatchFiles {
+		t.Fatalf("unexpected flushes %v", flushed)
+	}
+	b.flush()
+	if len(flushed) != 2 || flushed[1] != 10 {
+		t.Fatalf("unexpected flushes %v", flushed)
+	}
+
+	// Flushing an empty batch does nothing
+	b.flush()
+	if len(flushed) != 2 {
+		t.Fatalf("unexpected flushes %v", flushed)
+	}
+
+	// Large files are committed by size
+	big := protocol.FileInfo{Name: "big", Blocks: make([]protocol.BlockInfo, 10000)}
+	for i := range big.Blocks {
+		big.Blocks[i].Hash = make([]byte, 32)
+	}
+	for i := 0; i < 10; i++ {
+		b.append(big)
+	}
+	if len(flushed) < 3 || flushed[2] >= 10 {
+		t.Fatalf("large files not committed by size: %v", flushed)
+	}
+}
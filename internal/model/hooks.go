@@ -0,0 +1,146 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// The pre and post hooks of a folder are commands run before a pulled or
+// deleted file is applied and after it has been. They are run as
+//
+//	command <path>
+//
+// where path is the temporary file holding the new contents, or the file
+// about to be deleted, for the pre hook and the file itself for the post
+// hook. The folder, the name of the file within it, the action ("update" or
+// "delete") and the metadata of the file are given in the environment
+// variables SYNCTHING_FOLDER, SYNCTHING_FILE, SYNCTHING_ACTION,
+// SYNCTHING_SIZE, SYNCTHING_MODIFIED, SYNCTHING_PERMISSIONS and
+// SYNCTHING_VERSION. A non-zero exit status from the pre hook skips the file
+// until there is a new version of it. A hook still running after the
+// folder's hook timeout is killed, which counts as a failure rather than a
+// rejection.
+
+// defaultHookTimeout is how long a hook may run when the folder doesn't say.
+const defaultHookTimeout = time.Minute
+
+// hookKillWait is how long to wait for the output of a hook to be closed
+// once it has been killed.
+const hookKillWait = time.Second
+
+var (
+	errRejected    = errors.New("rejected by the pre hook")
+	errHookTimeout = errors.New("hook timed out")
+)
+
+func (p *Puller) runHook(command, action, path string, file protocol.FileInfo) error {
+	size := file.Size()
+	if file.IsDeleted() {
+		// Deleted files have a nominal size
+		size = 0
+	}
+
+	cmd := exec.Command(command, path)
+	cmd.Env = append(os.Environ(),
+		"SYNCTHING_FOLDER="+p.folder,
+		"SYNCTHING_FILE="+file.Name,
+		"SYNCTHING_ACTION="+action,
+		fmt.Sprintf("SYNCTHING_SIZE=%d", size),
+		fmt.Sprintf("SYNCTHING_MODIFIED=%d", file.Modified),
+		fmt.Sprintf("SYNCTHING_PERMISSIONS=%o", file.Flags&0777),
+		fmt.Sprintf("SYNCTHING_VERSION=%d", file.Version),
+	)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	newHookGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	timeout := p.hookTimeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		killHook(cmd)
+		// Wait returns once the output is closed, which processes started
+		// by the hook may hold open after it has been killed. The output
+		// may still be written to if we stop waiting.
+		select {
+		case <-done:
+		case <-time.After(hookKillWait):
+			if debug() {
+				l.Debugf("%v hook %s %s: %v", p, command, path, errHookTimeout)
+			}
+			return errHookTimeout
+		}
+		err = errHookTimeout
+	}
+	if err != nil && debug() {
+		l.Debugf("%v hook %s %s: %v: %s", p, command, path, err, out.Bytes())
+	}
+	return err
+}
+
+// preApply runs the pre hook, if there is one, and returns whether the file
+// may be applied.
+func (p *Puller) preApply(action, path string, file protocol.FileInfo) bool {
+	if p.preHook == "" {
+		return true
+	}
+
+	err := p.runHook(p.preHook, action, path, file)
+	if _, ok := err.(*exec.ExitError); ok {
+		p.rejectedMut.Lock()
+		if p.rejected == nil {
+			p.rejected = make(map[string]uint64)
+		}
+		p.rejected[file.Name] = file.Version
+		p.rejectedMut.Unlock()
+		p.failed(file.Name, "pre hook", errRejected)
+		return false
+	} else if err != nil {
+		p.failed(file.Name, "pre hook", err)
+		return false
+	}
+	return true
+}
+
+// postApply runs the post hook, if there is one.
+func (p *Puller) postApply(action, path string, file protocol.FileInfo) {
+	if p.postHook == "" {
+		return
+	}
+
+	if err := p.runHook(p.postHook, action, path, file); err != nil {
+		p.failed(file.Name, "post hook", err)
+	}
+}
+
+// isRejected returns whether this version of the file was rejected by the
+// pre hook.
+func (p *Puller) isRejected(file protocol.FileInfo) bool {
+	p.rejectedMut.Lock()
+	defer p.rejectedMut.Unlock()
+	v, ok := p.rejected[file.Name]
+	return ok && v == file.Version
+}
@@ -0,0 +1,18 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows plan9
+
+package model
+
+import "os/exec"
+
+// Without process groups only the hook itself is killed; runHook stops
+// waiting for processes it has started.
+
+func newHookGroup(cmd *exec.Cmd) {}
+
+func killHook(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,136 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The pre hook rejects files named "bad"; the post hook records its
+	// arguments and environment
+	pre := filepath.Join(dir, "pre")
+	ioutil.WriteFile(pre, []byte("#!/bin/sh\ntest \"$SYNCTHING_FILE\" != bad\n"), 0755)
+	post := filepath.Join(dir, "post")
+	log := filepath.Join(dir, "post.log")
+	ioutil.WriteFile(post, []byte("#!/bin/sh\necho \"$1 $SYNCTHING_FOLDER $SYNCTHING_FILE $SYNCTHING_ACTION $SYNCTHING_SIZE $SYNCTHING_MODIFIED $SYNCTHING_PERMISSIONS $SYNCTHING_VERSION\" >> "+log+"\n"), 0755)
+
+	folder := filepath.Join(dir, "folder")
+	os.Mkdir(folder, 0755)
+	ioutil.WriteFile(filepath.Join(folder, "good"), []byte("good"), 0644)
+	ioutil.WriteFile(filepath.Join(folder, "bad"), []byte("bad"), 0644)
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: folder})
+	if err := m.ScanFolder("default"); err != nil {
+		t.Fatal(err)
+	}
+
+	var rejected []ItemError
+	m.OnItemError(func(e ItemError) {
+		rejected = append(rejected, e)
+	})
+
+	p := &Puller{
+		folder:   "default",
+		dir:      folder,
+		model:    m,
+		storage:  m.folderStorage["default"],
+		cases:    osutil.NewCaseChecker(),
+		preHook:  pre,
+		postHook: post,
+	}
+
+	good := protocol.FileInfo{Name: "good", Flags: protocol.FlagDeleted | 0644, Modified: 1234, Version: 100}
+	bad := protocol.FileInfo{Name: "bad", Flags: protocol.FlagDeleted | 0644, Modified: 1234, Version: 100}
+	p.deleteFile(good)
+	p.deleteFile(bad)
+
+	if _, err := os.Stat(filepath.Join(folder, "good")); !os.IsNotExist(err) {
+		t.Error("accepted file not deleted")
+	}
+	if _, err := os.Stat(filepath.Join(folder, "bad")); err != nil {
+		t.Error("rejected file deleted")
+	}
+
+	if !p.isRejected(bad) || p.isRejected(good) {
+		t.Error("unexpected rejected state")
+	}
+	bad.Version++
+	if p.isRejected(bad) {
+		t.Error("new version should not be rejected")
+	}
+	if len(rejected) != 1 || rejected[0].Name != "bad" || rejected[0].Err != errRejected {
+		t.Errorf("unexpected item errors %v", rejected)
+	}
+
+	bs, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := filepath.Join(folder, "good") + " default good delete 0 1234 644 100"
+	if got := strings.TrimSpace(string(bs)); got != expected {
+		t.Errorf("unexpected post hook run %q != %q", got, expected)
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	slow := filepath.Join(dir, "slow")
+	ioutil.WriteFile(slow, []byte("#!/bin/sh\nexec sleep 10\n"), 0755)
+
+	p := &Puller{folder: "default", hookTimeout: 100 * time.Millisecond}
+	t0 := time.Now()
+	if err := p.runHook(slow, "update", filepath.Join(dir, "file"), protocol.FileInfo{Name: "file"}); err != errHookTimeout {
+		t.Errorf("unexpected error %v", err)
+	}
+	if d := time.Since(t0); d > 5*time.Second {
+		t.Errorf("hook ran for %v", d)
+	}
+
+	// A hook whose children hold its output open
+	forking := filepath.Join(dir, "forking")
+	ioutil.WriteFile(forking, []byte("#!/bin/sh\nsleep 10 &\nsleep 10\n"), 0755)
+
+	t0 = time.Now()
+	if err := p.runHook(forking, "update", filepath.Join(dir, "file"), protocol.FileInfo{Name: "file"}); err != errHookTimeout {
+		t.Errorf("unexpected error %v", err)
+	}
+	if d := time.Since(t0); d >= hookKillWait {
+		t.Errorf("forking hook's children not killed; ran for %v", d)
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !windows,!plan9
+
+package model
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// newHookGroup has the hook run in a process group of its own, so that
+// killHook reaches the processes it starts as well.
+func newHookGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killHook kills the hook and everything else in its process group.
+func killHook(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/syncthing/syncthing/internal/ignore"
+)
+
+// loadIgnores reloads the ignore patterns of the folder from the .stignore
+// file at its root, and returns them. If the file cannot be loaded, the
+// patterns loaded before stay in effect, so that a mistake in it doesn't get
+// ignored files scanned and pulled.
+func (m *Model) loadIgnores(folder, dir string) ignore.Patterns {
+	file := filepath.Join(dir, ".stignore")
+
+	var ignores ignore.Patterns
+	_, err := os.Stat(file)
+	if err == nil {
+		ignores, err = ignore.Load(file)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+
+	m.fmut.Lock()
+	defer m.fmut.Unlock()
+	if err != nil {
+		m.log.Warnf("Folder %q: %v; keeping the previous ignore patterns", folder, err)
+		return m.folderIgnores[folder]
+	}
+	m.folderIgnores[folder] = ignores
+	return ignores
+}
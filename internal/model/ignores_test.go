@@ -0,0 +1,92 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/ignore"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestLoadIgnores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ignores")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, ".stignore")
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: dir})
+
+	if ignores := m.loadIgnores("default", dir); len(ignores) != 0 {
+		t.Errorf("Unexpected patterns without a .stignore: %v", ignores)
+	}
+
+	ioutil.WriteFile(file, []byte("build/\n"), 0644)
+	if ignores := m.loadIgnores("default", dir); !ignores.Match("build") {
+		t.Error("Patterns not loaded")
+	}
+
+	// A mistake keeps the patterns in effect
+	ioutil.WriteFile(file, []byte("[\n"), 0644)
+	if ignores := m.loadIgnores("default", dir); !ignores.Match("build") {
+		t.Error("Previous patterns not kept")
+	}
+	if err := m.SetIgnores("default", []string{"["}); err == nil {
+		t.Error("Bad pattern saved")
+	}
+
+	os.Remove(file)
+	if ignores := m.loadIgnores("default", dir); ignores.Match("build") {
+		t.Error("Patterns kept after removing the .stignore")
+	}
+}
+
+func TestNeedExcludesIgnored(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata"})
+
+	files := genFiles(3)
+	for i := range files {
+		files[i].Version = 1
+	}
+	m.folderFiles["default"].Update(device1, files)
+
+	// Patterns added after the index was received
+	m.fmut.Lock()
+	m.folderIgnores["default"] = mustParseIgnores(t, "file1")
+	m.fmut.Unlock()
+
+	if n, _ := m.NeedSize("default"); n != 2 {
+		t.Errorf("Need size counts %d files, not 2", n)
+	}
+	for _, f := range m.NeedFolderFilesLimited("default", 0, 0) {
+		if f.Name == "file1" {
+			t.Error("Ignored file needed")
+		}
+	}
+	_, queued, _, _ := m.NeedFolderFiles("default", 1, 10)
+	if names := neededNames(queued); len(names) != 2 || names[0] != "file0" || names[1] != "file2" {
+		t.Errorf("Unexpected queued files %v", names)
+	}
+}
+
+func mustParseIgnores(t *testing.T, patterns string) ignore.Patterns {
+	ignores, err := ignore.Parse(strings.NewReader(patterns), ".stignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ignores
+}
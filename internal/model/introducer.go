@@ -0,0 +1,110 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// Devices and folder shares added because an introducer announced them
+// remember that introducer in their IntroducedBy attribute. When the
+// introducer has IntroductionRemovals set, the ones it no longer announces
+// are removed again: first the shares, then the devices that are left
+// sharing nothing with us. Devices and shares added by the user, or by
+// another introducer, are never touched.
+
+// removeUnannounced removes the shares and devices added by the introducer
+// that are missing from its cluster config, and returns whether the
+// configuration was changed.
+func (m *Model) removeUnannounced(introducer protocol.DeviceID, cm protocol.ClusterConfigMessage) bool {
+	announced := make(map[string]map[protocol.DeviceID]bool)
+	for _, folder := range cm.Folders {
+		devices := make(map[protocol.DeviceID]bool)
+		for _, device := range folder.Devices {
+			var id protocol.DeviceID
+			copy(id[:], device.ID)
+			devices[id] = true
+		}
+		announced[folder.ID] = devices
+	}
+
+	introducedBy := introducer.String()
+	var changed bool
+
+	m.fmut.Lock()
+	for i := range m.cfg.Folders {
+		folderCfg := &m.cfg.Folders[i]
+		if _, ok := m.folderDevices[folderCfg.ID]; !ok {
+			continue
+		}
+
+		kept := folderCfg.Devices[:0]
+		for _, device := range folderCfg.Devices {
+			if device.IntroducedBy != introducedBy || announced[folderCfg.ID][device.DeviceID] {
+				kept = append(kept, device)
+				continue
+			}
+
+			m.log.Infof("Removing device %v from share %q (no longer announced by introducer %v)", device.DeviceID, folderCfg.ID, introducer)
+			m.unshare(folderCfg.ID, device.DeviceID)
+			changed = true
+		}
+		folderCfg.Devices = kept
+	}
+	m.fmut.Unlock()
+
+	var removed []protocol.DeviceID
+	for _, device := range m.cfg.Devices {
+		if device.IntroducedBy == introducedBy && !sharesAnyFolder(m.cfg, device.DeviceID) {
+			removed = append(removed, device.DeviceID)
+		}
+	}
+	for _, id := range removed {
+		m.log.Infof("Removing device %v from config (no longer announced by introducer %v)", id, introducer)
+		m.cfg.RemoveDevice(id)
+		m.Disconnect(id)
+		changed = true
+	}
+
+	return changed
+}
+
+// unshare stops sharing the folder with the device and forgets its index
+// for it. Must be called with fmut held.
+func (m *Model) unshare(folder string, id protocol.DeviceID) {
+	folders := m.deviceFolders[id]
+	for i := range folders {
+		if folders[i] == folder {
+			m.deviceFolders[id] = append(folders[:i], folders[i+1:]...)
+			break
+		}
+	}
+
+	devices := m.folderDevices[folder]
+	for i := range devices {
+		if devices[i] == id {
+			m.folderDevices[folder] = append(devices[:i], devices[i+1:]...)
+			break
+		}
+	}
+
+	if fs, ok := m.folderFiles[folder]; ok {
+		fs.Replace(id, nil)
+	}
+}
+
+// sharesAnyFolder returns whether any folder in the configuration is shared
+// with the device.
+func sharesAnyFolder(cfg *config.Configuration, id protocol.DeviceID) bool {
+	for _, folder := range cfg.Folders {
+		for _, device := range folder.Devices {
+			if device.DeviceID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestIntroducerRemovals(t *testing.T) {
+	device3, _ := protocol.DeviceIDFromString("LGFPDIT-7SKNNJL-VJZA4FC-7QNCRKA-CE753K7-2BW5QDK-2FOZ7FR-FEP57QJ")
+	device4, err := protocol.DeviceIDFromString("P56IOI7-MZJNU2Y-IQGDREY-DM2MGTI-MGL3BXN-PQ6W5BM-TBBZ4TJ-XZWICQ2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.New("/tmp/test", device1)
+	cfg.Devices = []config.DeviceConfiguration{
+		{DeviceID: device1},
+		{DeviceID: device2, Introducer: true},
+		{DeviceID: device4},
+	}
+	cfg.Folders = []config.FolderConfiguration{
+		{
+			ID: "folder1",
+			Devices: []config.FolderDeviceConfiguration{
+				{DeviceID: device1},
+				{DeviceID: device2},
+				{DeviceID: device4},
+			},
+		},
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(cfg.Folders[0])
+
+	announce := func(devices ...protocol.DeviceID) {
+		folder := protocol.Folder{ID: "folder1"}
+		for _, id := range devices {
+			folder.Devices = append(folder.Devices, protocol.Device{ID: id[:]})
+		}
+		m.ClusterConfig(device2, protocol.ClusterConfigMessage{
+			Folders: []protocol.Folder{folder},
+		})
+	}
+
+	announce(device1, device2, device3)
+
+	dev := cfg.GetDeviceConfiguration(device3)
+	if dev == nil || dev.IntroducedBy != device2.String() {
+		t.Fatalf("Introduced device not recorded: %+v", dev)
+	}
+	folderDevices := cfg.GetFolderConfiguration("folder1").Devices
+	if l := len(folderDevices); l != 4 || folderDevices[3].IntroducedBy != device2.String() {
+		t.Fatalf("Introduced share not recorded: %+v", folderDevices)
+	}
+
+	// Without removals enabled, devices stay when no longer announced
+	announce(device1, device2)
+	if cfg.GetDeviceConfiguration(device3) == nil {
+		t.Fatal("Introduced device removed without opting in")
+	}
+
+	cfg.GetDeviceConfiguration(device2).IntroductionRemovals = true
+	announce(device1, device2)
+
+	if cfg.GetDeviceConfiguration(device3) != nil {
+		t.Error("Introduced device not removed")
+	}
+	if m.sharedWith("folder1", device3) {
+		t.Error("Introduced device still shares the folder")
+	}
+	// device4 was added by the user and is kept, although the introducer
+	// doesn't announce it
+	if cfg.GetDeviceConfiguration(device4) == nil || !m.sharedWith("folder1", device4) {
+		t.Error("User added device removed")
+	}
+	if l := len(cfg.GetFolderConfiguration("folder1").Devices); l != 3 {
+		t.Errorf("Unexpected number of devices sharing the folder, %d", l)
+	}
+}
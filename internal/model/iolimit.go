@@ -0,0 +1,30 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+// An ioLimiter limits the number of concurrent operations across all
+// folders, such as scans of folders on the same disk. A nil ioLimiter
+// imposes no limit.
+type ioLimiter chan struct{}
+
+func newIOLimiter(slots int) ioLimiter {
+	if slots <= 0 {
+		return nil
+	}
+	return make(ioLimiter, slots)
+}
+
+// take waits for a free slot. It must be followed by a call to give.
+func (l ioLimiter) take() {
+	if l != nil {
+		l <- struct{}{}
+	}
+}
+
+func (l ioLimiter) give() {
+	if l != nil {
+		<-l
+	}
+}
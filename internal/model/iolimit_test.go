@@ -0,0 +1,45 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIOLimiter(t *testing.T) {
+	l := newIOLimiter(2)
+	l.take()
+	l.take()
+
+	taken := make(chan struct{})
+	go func() {
+		l.take()
+		close(taken)
+	}()
+
+	select {
+	case <-taken:
+		t.Fatal("third slot taken while limit is two")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.give()
+	select {
+	case <-taken:
+	case <-time.After(time.Second):
+		t.Fatal("slot not taken after one was given back")
+	}
+}
+
+func TestIOLimiterUnlimited(t *testing.T) {
+	l := newIOLimiter(0)
+	for i := 0; i < 100; i++ {
+		l.take()
+	}
+	for i := 0; i < 100; i++ {
+		l.give()
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import "net"
+
+type remoteAddrer interface {
+	RemoteAddr() net.Addr
+}
+
+// The networks that are always on the LAN: loopback, private, link local
+// and IPv6 unique local addresses.
+var lanNetworks = parseNetworks([]string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+})
+
+func parseNetworks(cidrs []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, n)
+		} else if debug() {
+			l.Debugf("parsing LAN network %q: %v", cidr, err)
+		}
+	}
+	return nets
+}
+
+// isLAN returns whether the address is on the LAN, either by being in one of
+// the standard local networks or in one of the given extra networks.
+func isLAN(addr net.Addr, extra []string) bool {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.IPAddr:
+		ip = addr.IP
+	default:
+		return false
+	}
+
+	for _, nets := range [][]*net.IPNet{lanNetworks, parseNetworks(extra)} {
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
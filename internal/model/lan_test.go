@@ -0,0 +1,37 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsLAN(t *testing.T) {
+	extra := []string{"100.64.0.0/10", "bogus"}
+	for _, tc := range []struct {
+		addr string
+		lan  bool
+	}{
+		{"127.0.0.1", true},
+		{"192.168.1.20", true},
+		{"172.20.0.1", true},
+		{"172.32.0.1", false},
+		{"100.64.3.4", true},
+		{"8.8.8.8", false},
+		{"fe80::1", true},
+		{"fd12:3456::1", true},
+		{"2001:db8::1", false},
+	} {
+		addr := &net.TCPAddr{IP: net.ParseIP(tc.addr), Port: 22000}
+		if lan := isLAN(addr, extra); lan != tc.lan {
+			t.Errorf("isLAN(%s) = %v, expected %v", tc.addr, lan, tc.lan)
+		}
+	}
+
+	if isLAN(nil, nil) {
+		t.Error("unknown address should not be on the LAN")
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// In a read only folder the local files are kept when the rest of the
+// cluster changes them, so the folder can differ from the cluster. These
+// files are what Override would force on the cluster, and what would be
+// lost by taking the cluster's versions instead. While there are any, the
+// idle folder is in the FolderOutOfSync state.
+
+var errNotReadOnly = errors.New("folder is not read only")
+
+// A LocalChange is a file in a read only folder that differs from the
+// cluster. The global fields describe the cluster's version.
+type LocalChange struct {
+	Name           string
+	Size           int64
+	Modified       time.Time
+	Deleted        bool // the file is deleted or missing locally
+	GlobalSize     int64
+	GlobalModified time.Time
+	GlobalDeleted  bool
+}
+
+// LocallyChanged returns a page of the files in the read only folder that
+// differ from the cluster, and whether there are further pages. Pages are
+// numbered from one.
+func (m *Model) LocallyChanged(folder string, page, perpage int) ([]LocalChange, bool, error) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	cfg := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return nil, false, errors.New("no such folder")
+	}
+	if !cfg.ReadOnly {
+		return nil, false, errNotReadOnly
+	}
+
+	skip := (page - 1) * perpage
+	var names []string
+	more := false
+	fs.WithNeedTruncated(protocol.LocalDeviceID, func(f protocol.FileIntf) bool {
+		if skip > 0 {
+			skip--
+			return true
+		}
+		if len(names) == perpage {
+			more = true
+			return false
+		}
+		names = append(names, f.(protocol.FileInfoTruncated).Name)
+		return true
+	})
+
+	res := make([]LocalChange, 0, len(names))
+	for _, name := range names {
+		lf := fs.Get(protocol.LocalDeviceID, name)
+		gf := fs.GetGlobal(name)
+		c := LocalChange{
+			Name:           name,
+			Deleted:        lf.Name == "" || lf.IsDeleted(),
+			GlobalSize:     contentSize(gf),
+			GlobalModified: gf.ModTime(),
+			GlobalDeleted:  gf.IsDeleted(),
+		}
+		if !c.Deleted {
+			c.Size = contentSize(lf)
+			c.Modified = lf.ModTime()
+		}
+		res = append(res, c)
+	}
+	return res, more, nil
+}
+
+// Revert makes the cluster take the local versions of the files that differ
+// in the read only folder, as Override does.
+func (m *Model) Revert(folder string) error {
+	m.fmut.RLock()
+	cfg, ok := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return errors.New("no such folder")
+	}
+	if !cfg.ReadOnly {
+		return errNotReadOnly
+	}
+	m.Override(folder)
+	return nil
+}
+
+func contentSize(f protocol.FileInfo) int64 {
+	if f.IsDeleted() || protocol.IsDirectory(f.Flags) {
+		return 0
+	}
+	return f.Size()
+}
+
+// updateLocallyChanged recounts the files in the read only folder that
+// differ from the cluster, and announces the count when it has changed.
+func (m *Model) updateLocallyChanged(folder string) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	ro := m.folderCfgs[folder].ReadOnly
+	m.fmut.RUnlock()
+	if !ok || !ro {
+		return
+	}
+
+	versions := make(map[string]uint64)
+	fs.WithNeedTruncated(protocol.LocalDeviceID, func(f protocol.FileIntf) bool {
+		tf := f.(protocol.FileInfoTruncated)
+		versions[tf.Name] = tf.Version
+		return true
+	})
+
+	m.smut.Lock()
+	prev := m.localChanges[folder]
+	changed := len(prev) != len(versions)
+	for name, v := range versions {
+		if changed {
+			break
+		}
+		changed = prev[name] != v
+	}
+	m.localChanges[folder] = versions
+	state := m.folderState[folder]
+	m.smut.Unlock()
+
+	if state == FolderIdle || state == FolderOutOfSync {
+		// Idle is shown as out of sync while there are local changes
+		m.setState(folder, FolderIdle)
+	}
+
+	if changed {
+		events.Default.Log(events.LocalChangesUpdated, map[string]interface{}{
+			"folder": folder,
+			"items":  len(versions),
+		})
+	}
+}
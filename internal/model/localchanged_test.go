@@ -0,0 +1,88 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestLocallyChanged(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{
+		ID:       "ro",
+		Path:     "testdata",
+		ReadOnly: true,
+		Devices:  []config.FolderDeviceConfiguration{{DeviceID: device1}},
+	})
+	m.AddFolder(config.FolderConfiguration{ID: "rw", Path: "testdata"})
+
+	m.folderFiles["ro"].Update(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "changed", Version: 1, Modified: 1000, Blocks: []protocol.BlockInfo{{Size: 10}}},
+		{Name: "same", Version: 1, Blocks: []protocol.BlockInfo{{Size: 10}}},
+	})
+
+	sub := events.Default.Subscribe(events.LocalChangesUpdated)
+	defer events.Default.Unsubscribe(sub)
+
+	m.IndexUpdate(device1, "ro", []protocol.FileInfo{
+		{Name: "changed", Version: 2, Modified: 2000, Blocks: []protocol.BlockInfo{{Size: 20}}},
+		{Name: "missing", Version: 2, Blocks: []protocol.BlockInfo{{Size: 30}}},
+		{Name: "same", Version: 1, Blocks: []protocol.BlockInfo{{Size: 10}}},
+	})
+
+	ev, err := sub.Poll(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := ev.Data.(map[string]interface{}); data["folder"] != "ro" || data["items"] != 2 {
+		t.Errorf("unexpected event data %v", data)
+	}
+
+	if state, _ := m.State("ro"); state != "outOfSync" {
+		t.Errorf("unexpected state %q with local changes", state)
+	}
+
+	files, more, err := m.LocallyChanged("ro", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || !more {
+		t.Fatalf("unexpected first page %v, more %v", files, more)
+	}
+	if c := files[0]; c.Name != "changed" || c.Size != 10 || c.Modified.Unix() != 1000 || c.GlobalSize != 20 || c.GlobalModified.Unix() != 2000 || c.Deleted {
+		t.Errorf("unexpected change %+v", c)
+	}
+
+	files, more, _ = m.LocallyChanged("ro", 2, 1)
+	if len(files) != 1 || more || files[0].Name != "missing" || !files[0].Deleted || files[0].GlobalSize != 30 {
+		t.Errorf("unexpected second page %+v, more %v", files, more)
+	}
+
+	// Reverting makes the cluster take the local versions
+	if err := m.Revert("ro"); err != nil {
+		t.Fatal(err)
+	}
+	if files, _, _ := m.LocallyChanged("ro", 1, 10); len(files) != 0 {
+		t.Errorf("unexpected changes after revert %+v", files)
+	}
+	if state, _ := m.State("ro"); state != "idle" {
+		t.Errorf("unexpected state %q after revert", state)
+	}
+
+	if _, _, err := m.LocallyChanged("rw", 1, 10); err != errNotReadOnly {
+		t.Errorf("unexpected error for read write folder: %v", err)
+	}
+	if err := m.Revert("rw"); err != errNotReadOnly {
+		t.Errorf("unexpected error reverting read write folder: %v", err)
+	}
+}
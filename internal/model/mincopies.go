@@ -0,0 +1,105 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/internal/files"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// A folder with MinCopies set counts a file as safe once that many devices,
+// this one included, hold its current version. The files we have that are
+// not safe yet are reported by UnsafeFiles and the UnsafeFilesUpdated event.
+// With GateCleanup set as well, the versioner keeps every old version of a
+// file until its current version is safe.
+
+// updateUnsafe recounts the files in the folder that are held by fewer
+// devices than required, and announces them when they have changed.
+func (m *Model) updateUnsafe(folder string) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	minCopies := m.folderCfgs[folder].MinCopies
+	m.fmut.RUnlock()
+	if !ok || minCopies <= 0 {
+		return
+	}
+
+	unsafe := make(map[string]int)
+	fs.WithHaveTruncated(protocol.LocalDeviceID, func(f protocol.FileIntf) bool {
+		tf := f.(protocol.FileInfoTruncated)
+		if tf.IsDeleted() || tf.IsInvalid() || protocol.IsDirectory(tf.Flags) {
+			return true
+		}
+		// Files we are behind on are the concern of the devices that have
+		// the current version.
+		if copies, current := fileCopies(fs, tf.Name); current && copies < minCopies {
+			unsafe[tf.Name] = copies
+		}
+		return true
+	})
+
+	m.smut.Lock()
+	prev := m.unsafeFiles[folder]
+	changed := len(prev) != len(unsafe)
+	for name, copies := range unsafe {
+		if changed {
+			break
+		}
+		prevCopies, ok := prev[name]
+		changed = !ok || prevCopies != copies
+	}
+	m.unsafeFiles[folder] = unsafe
+	m.smut.Unlock()
+
+	if changed {
+		events.Default.Log(events.UnsafeFilesUpdated, map[string]interface{}{
+			"folder":    folder,
+			"items":     len(unsafe),
+			"minCopies": minCopies,
+		})
+	}
+}
+
+// fileCopies returns the number of devices holding the current version of
+// the file, and whether we are one of them.
+func fileCopies(fs *files.Set, name string) (int, bool) {
+	devices := fs.Availability(name)
+	for _, id := range devices {
+		if id == protocol.LocalDeviceID {
+			return len(devices), true
+		}
+	}
+	return len(devices), false
+}
+
+// UnsafeFiles returns the files in the folder held by fewer devices than
+// its MinCopies, with the number of devices holding each.
+func (m *Model) UnsafeFiles(folder string) map[string]int {
+	m.smut.RLock()
+	defer m.smut.RUnlock()
+
+	res := make(map[string]int, len(m.unsafeFiles[folder]))
+	for name, copies := range m.unsafeFiles[folder] {
+		res[name] = copies
+	}
+	return res
+}
+
+// mayRemoveVersions returns the versioner cleanup gate of the folder, which
+// lets old versions of a file go once its current version is held by at
+// least minCopies devices.
+func (m *Model) mayRemoveVersions(folder string, minCopies int) func(name string) bool {
+	return func(name string) bool {
+		m.fmut.RLock()
+		fs, ok := m.folderFiles[folder]
+		m.fmut.RUnlock()
+		if !ok {
+			return true
+		}
+		copies, _ := fileCopies(fs, name)
+		return copies >= minCopies
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestUnsafeFiles(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{
+		ID:        "backup",
+		Path:      "testdata",
+		MinCopies: 3,
+		Devices:   []config.FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}},
+	})
+
+	m.folderFiles["backup"].Update(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "copied", Version: 1},
+		{Name: "lonely", Version: 1},
+		{Name: "dir", Version: 1, Flags: protocol.FlagDirectory},
+		{Name: "deleted", Version: 1, Flags: protocol.FlagDeleted},
+	})
+
+	sub := events.Default.Subscribe(events.UnsafeFilesUpdated)
+	defer events.Default.Unsubscribe(sub)
+
+	m.IndexUpdate(device1, "backup", []protocol.FileInfo{
+		{Name: "copied", Version: 1},
+		{Name: "lonely", Version: 1},
+	})
+	m.IndexUpdate(device2, "backup", []protocol.FileInfo{
+		{Name: "copied", Version: 1},
+	})
+
+	expected := map[string]int{"lonely": 2}
+	if unsafe := m.UnsafeFiles("backup"); !reflect.DeepEqual(unsafe, expected) {
+		t.Errorf("Unexpected unsafe files %v", unsafe)
+	}
+
+	ev, err := sub.Poll(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := ev.Data.(map[string]interface{}); data["folder"] != "backup" || data["minCopies"] != 3 {
+		t.Errorf("Unexpected event data %v", data)
+	}
+
+	gate := m.mayRemoveVersions("backup", 3)
+	if !gate("copied") || gate("lonely") {
+		t.Error("Incorrect cleanup gate")
+	}
+
+	// Files we are behind on are not ours to worry about
+	m.IndexUpdate(device1, "backup", []protocol.FileInfo{
+		{Name: "lonely", Version: 2},
+	})
+	if unsafe := m.UnsafeFiles("backup"); len(unsafe) != 0 {
+		t.Errorf("Unexpected unsafe files %v", unsafe)
+	}
+}
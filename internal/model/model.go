@@ -6,6 +6,8 @@ package model
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -24,11 +26,14 @@ import (
 	"github.com/syncthing/syncthing/internal/files"
 	"github.com/syncthing/syncthing/internal/ignore"
 	"github.com/syncthing/syncthing/internal/lamport"
+	"github.com/syncthing/syncthing/internal/logger"
+	"github.com/syncthing/syncthing/internal/metrics"
 	"github.com/syncthing/syncthing/internal/osutil"
-	"github.com/syncthing/syncthing/internal/protocol"
 	"github.com/syncthing/syncthing/internal/scanner"
 	"github.com/syncthing/syncthing/internal/stats"
+	"github.com/syncthing/syncthing/internal/storage"
 	"github.com/syncthing/syncthing/internal/versioner"
+	"github.com/syncthing/syncthing/lib/protocol"
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
@@ -39,6 +44,8 @@ const (
 	FolderScanning
 	FolderSyncing
 	FolderCleaning
+	FolderPaused
+	FolderOutOfSync // idle, but a read only folder that differs from the cluster
 )
 
 func (s folderState) String() string {
@@ -51,6 +58,10 @@ func (s folderState) String() string {
 		return "cleaning"
 	case FolderSyncing:
 		return "syncing"
+	case FolderPaused:
+		return "paused"
+	case FolderOutOfSync:
+		return "outOfSync"
 	default:
 		return "unknown"
 	}
@@ -67,34 +78,71 @@ const (
 type service interface {
 	Serve()
 	Stop()
+	Stopped() <-chan struct{} // closed when Serve has returned
 }
 
 type Model struct {
 	indexDir string
 	cfg      *config.Configuration
+	cfgw     *config.Wrapper // through which the model changes cfg itself
 	db       *leveldb.DB
 
+	id            protocol.DeviceID // our own device, as announced to peers
 	deviceName    string
 	clientName    string
 	clientVersion string
-
-	folderCfgs     map[string]config.FolderConfiguration                  // folder -> cfg
-	folderFiles    map[string]*files.Set                                  // folder -> files
-	folderDevices  map[string][]protocol.DeviceID                         // folder -> deviceIDs
-	deviceFolders  map[protocol.DeviceID][]string                         // deviceID -> folders
-	deviceStatRefs map[protocol.DeviceID]*stats.DeviceStatisticsReference // deviceID -> statsRef
-	folderIgnores  map[string]ignore.Patterns                             // folder -> list of ignore patterns
-	folderRunners  map[string]service                                     // folder -> puller or scanner
-	fmut           sync.RWMutex                                           // protects the above
-
-	folderState        map[string]folderState // folder -> state
-	folderStateChanged map[string]time.Time   // folder -> time when state changed
+	log           *logger.Logger // for everything but debug output
+
+	itemEvents *events.Batcher // for events about individual files
+	profile    config.Profile  // concurrency settings for scanning and pulling
+	diskIO     ioLimiter       // shared by scans, copiers and finishers of all folders
+	netIO      ioLimiter       // shared by pullers of all folders
+
+	folderCfgs     map[string]config.FolderConfiguration                    // folder -> cfg
+	folderFiles    map[string]*files.Set                                    // folder -> files
+	folderStorage  map[string]storage.Backend                               // folder -> storage backend
+	folderDevices  map[string][]protocol.DeviceID                           // folder -> deviceIDs
+	deviceFolders  map[protocol.DeviceID][]string                           // deviceID -> folders
+	deviceStatRefs map[protocol.DeviceID]*stats.DeviceStatisticsReference   // deviceID -> statsRef
+	folderStatRefs map[string]*stats.FolderStatisticsReference              // folder -> statsRef
+	folderIgnores  map[string]ignore.Patterns                               // folder -> list of ignore patterns
+	folderRunners  map[string]service                                       // folder -> puller or scanner
+	folderKeys     map[string]map[protocol.DeviceID]*protocol.EncryptionKey // folder -> untrusted device -> key
+	fmut           sync.RWMutex                                             // protects the above
+
+	folderState        map[string]folderState                            // folder -> state
+	folderStateChanged map[string]time.Time                              // folder -> time when state changed
+	unsyncable         map[string]map[string]string                      // folder -> file -> reason
+	pulling            map[string]map[string]bool                        // folder -> files being pulled
+	failures           map[string]map[string]string                      // folder -> file -> last error
+	localChanges       map[string]map[string]uint64                      // read only folder -> file differing from the cluster -> global version
+	localEdits         map[string]map[string]uint64                      // folder -> file changed locally and not yet seen elsewhere -> version
+	modeMismatches     map[string]map[string]string                      // folder -> connected device ID -> mismatch of folder modes
+	unsafeFiles        map[string]map[string]int                         // folder -> file held by fewer than MinCopies devices -> devices holding it
+	remoteCompletion   map[string]map[protocol.DeviceID]RemoteCompletion // folder -> device -> completion as last announced
+	completionPending  map[string]map[protocol.DeviceID]bool             // folder -> devices to recompute the completion of
+	pausedFolders      map[string]bool                                   // folders not being scanned or pulled
 	smut               sync.RWMutex
 
-	protoConn map[protocol.DeviceID]protocol.Connection
-	rawConn   map[protocol.DeviceID]io.Closer
-	deviceVer map[protocol.DeviceID]string
-	pmut      sync.RWMutex // protects protoConn and rawConn
+	protoConn    map[protocol.DeviceID]protocol.Connection
+	rawConn      map[protocol.DeviceID]io.Closer
+	deviceVer    map[protocol.DeviceID]string
+	connStats    map[protocol.DeviceID]protocol.Statistics // as last added to the device statistics
+	pathHints    map[protocol.DeviceID]map[string]string   // folder -> path suggested by the device
+	remotePaused map[protocol.DeviceID]time.Time           // when the device last refused us for being paused
+	successor    protocol.DeviceID                         // announced to peers if successorSig is set
+	successorSig []byte
+	pmut         sync.RWMutex // protects protoConn, rawConn, connStats and successor
+
+	conflictFns    []func(Conflict)
+	itemErrorFns   []func(ItemError)
+	folderErrorFns []func(FolderError)
+	cbmut          sync.RWMutex // protects the callbacks
+
+	transfers         *stats.TransferHistory
+	talkers           *talkerTally
+	lastTransferStats map[string]ConnectionInfo // as last sampled
+	tmut              sync.Mutex                // protects lastTransferStats
 
 	addedFolder bool
 	started     bool
@@ -107,8 +155,10 @@ var (
 
 // NewModel creates and starts a new model. The model starts in read-only mode,
 // where it sends index information to connected peers and responds to requests
-// for file data without altering the local folder in any way.
-func NewModel(indexDir string, cfg *config.Configuration, deviceName, clientName, clientVersion string, db *leveldb.DB) *Model {
+// for file data without altering the local folder in any way. Messages are
+// logged to the given logger, or the default logger if it is nil; debug
+// output always goes to the default logger.
+func NewModel(indexDir string, cfg *config.Configuration, deviceName, clientName, clientVersion string, db *leveldb.DB, log *logger.Logger) *Model {
 	m := &Model{
 		indexDir:           indexDir,
 		cfg:                cfg,
@@ -116,19 +166,48 @@ func NewModel(indexDir string, cfg *config.Configuration, deviceName, clientName
 		deviceName:         deviceName,
 		clientName:         clientName,
 		clientVersion:      clientVersion,
+		log:                log.Or(),
 		folderCfgs:         make(map[string]config.FolderConfiguration),
 		folderFiles:        make(map[string]*files.Set),
+		folderStorage:      make(map[string]storage.Backend),
 		folderDevices:      make(map[string][]protocol.DeviceID),
 		deviceFolders:      make(map[protocol.DeviceID][]string),
 		deviceStatRefs:     make(map[protocol.DeviceID]*stats.DeviceStatisticsReference),
+		folderStatRefs:     make(map[string]*stats.FolderStatisticsReference),
+		transfers:          stats.NewTransferHistory(db),
+		talkers:            newTalkerTally(),
 		folderIgnores:      make(map[string]ignore.Patterns),
 		folderRunners:      make(map[string]service),
+		folderKeys:         make(map[string]map[protocol.DeviceID]*protocol.EncryptionKey),
 		folderState:        make(map[string]folderState),
 		folderStateChanged: make(map[string]time.Time),
+		unsyncable:         make(map[string]map[string]string),
+		pulling:            make(map[string]map[string]bool),
+		failures:           make(map[string]map[string]string),
+		localChanges:       make(map[string]map[string]uint64),
+		localEdits:         make(map[string]map[string]uint64),
+		modeMismatches:     make(map[string]map[string]string),
+		unsafeFiles:        make(map[string]map[string]int),
+		remoteCompletion:   make(map[string]map[protocol.DeviceID]RemoteCompletion),
+		completionPending:  make(map[string]map[protocol.DeviceID]bool),
+		pausedFolders:      make(map[string]bool),
 		protoConn:          make(map[protocol.DeviceID]protocol.Connection),
 		rawConn:            make(map[protocol.DeviceID]io.Closer),
 		deviceVer:          make(map[protocol.DeviceID]string),
+		connStats:          make(map[protocol.DeviceID]protocol.Statistics),
+		pathHints:          make(map[protocol.DeviceID]map[string]string),
+		remotePaused:       make(map[protocol.DeviceID]time.Time),
+	}
+
+	var itemInterval time.Duration
+	if cfg != nil {
+		m.cfgw = config.Wrap(cfg)
+		itemInterval = time.Duration(cfg.Options.ItemEventIntervalMs) * time.Millisecond
+		m.diskIO = newIOLimiter(cfg.Options.DiskIOSlots)
+		m.netIO = newIOLimiter(cfg.Options.NetworkIOSlots)
 	}
+	m.itemEvents = events.NewBatcher(events.Default, itemInterval)
+	m.profile = config.Profiles[config.ProfileDefault]
 
 	var timeout = 20 * 60 // seconds
 	if t := os.Getenv("STDEADLOCKTIMEOUT"); len(t) > 0 {
@@ -143,6 +222,25 @@ func NewModel(indexDir string, cfg *config.Configuration, deviceName, clientName
 	return m
 }
 
+// SetConfigWrapper sets the wrapper around the configuration given to
+// NewModel that the rest of the program changes it through, so that the
+// changes the model makes itself are seen by everyone subscribed to it.
+func (m *Model) SetConfigWrapper(w *config.Wrapper) {
+	m.cfgw = w
+}
+
+// SetProfile sets the concurrency settings used for scanning and pulling.
+// It must be called before any folders are started.
+func (m *Model) SetProfile(profile config.Profile) {
+	m.profile = profile
+}
+
+// SetDeviceID sets the ID of our own device. It must be called before any
+// devices are connected.
+func (m *Model) SetDeviceID(id protocol.DeviceID) {
+	m.id = id
+}
+
 // StartRW starts read/write processing on the current model. When in
 // read/write mode the model will attempt to keep in sync with the cluster by
 // pulling needed files from peer devices.
@@ -158,25 +256,76 @@ func (m *Model) StartFolderRW(folder string) {
 		panic("cannot start already running folder " + folder)
 	}
 	p := &Puller{
-		folder:   folder,
-		dir:      cfg.Path,
-		scanIntv: time.Duration(cfg.RescanIntervalS) * time.Second,
-		model:    m,
+		folder:       folder,
+		dir:          cfg.Path,
+		scanIntv:     time.Duration(cfg.RescanIntervalS) * time.Second,
+		acls:         cfg.SyncACLs,
+		placeholders: cfg.Placeholders,
+		conflicts:    cfg.ConflictPolicy != "overwrite",
+		encrypted:    cfg.Type == config.FolderTypeReceiveEncrypted,
+		preHook:      cfg.PreHook,
+		postHook:     cfg.PostHook,
+		hookTimeout:  time.Duration(cfg.HookTimeoutS) * time.Second,
+		model:        m,
+		stop:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		storage:      m.folderStorage[folder],
+		copiers:      m.profile.Copiers,
+		pullers:      m.profile.Pullers,
+		finishers:    m.profile.Finishers,
+	}
+	if cfg.SyncOwnership {
+		if !osutil.CanChown() {
+			m.log.Warnf("Folder %q: syncing ownership requires running as root or with the CAP_CHOWN capability; file owners will not be changed.", folder)
+		} else if owners, err := newOwnerMapper(cfg); err != nil {
+			m.log.Warnf("Folder %q: ownership mapping: %v; file owners will not be changed.", folder, err)
+		} else {
+			p.owners = owners
+		}
 	}
+	p.watcher = m.watchFolder(cfg)
 	m.folderRunners[folder] = p
 	m.fmut.Unlock()
 
 	if len(cfg.Versioning.Type) > 0 {
+		if cfg.GateCleanup && cfg.MinCopies > 0 {
+			versioner.SetCleanupGate(folder, m.mayRemoveVersions(folder, cfg.MinCopies))
+		}
 		factory, ok := versioner.Factories[cfg.Versioning.Type]
 		if !ok {
-			l.Fatalf("Requested versioning type %q that does not exist", cfg.Versioning.Type)
+			m.log.Fatalf("Requested versioning type %q that does not exist", cfg.Versioning.Type)
 		}
 		p.versioner = factory(folder, cfg.Path, cfg.Versioning.Params)
 	}
 
+	if cfg.TempDir != "" {
+		if rel, err := filepath.Rel(cfg.Path, cfg.TempDir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			m.log.Warnf("Folder %q: the temporary directory must be outside the folder; keeping temporary files in the folder itself.", folder)
+		} else {
+			p.tempDir = cfg.TempDir
+		}
+	}
+
 	go p.Serve()
 }
 
+// Stop stops all folders and closes the connections to all devices.
+func (m *Model) Stop() {
+	m.fmut.RLock()
+	for _, runner := range m.folderRunners {
+		runner.Stop()
+	}
+	m.fmut.RUnlock()
+
+	m.recordAllConnectionStats()
+
+	m.pmut.RLock()
+	for _, conn := range m.rawConn {
+		conn.Close()
+	}
+	m.pmut.RUnlock()
+}
+
 // StartRO starts read only processing on the current model. When in
 // read only mode the model will announce files to the cluster but not
 // pull in any external changes.
@@ -192,10 +341,13 @@ func (m *Model) StartFolderRO(folder string) {
 		panic("cannot start already running folder " + folder)
 	}
 	s := &Scanner{
-		folder: folder,
-		intv:   time.Duration(cfg.RescanIntervalS) * time.Second,
-		model:  m,
+		folder:  folder,
+		intv:    time.Duration(cfg.RescanIntervalS) * time.Second,
+		model:   m,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
 	}
+	s.watcher = m.watchFolder(cfg)
 	m.folderRunners[folder] = s
 	m.fmut.Unlock()
 
@@ -210,10 +362,6 @@ type ConnectionInfo struct {
 
 // ConnectionStats returns a map with connection statistics for each connected device.
 func (m *Model) ConnectionStats() map[string]ConnectionInfo {
-	type remoteAddrer interface {
-		RemoteAddr() net.Addr
-	}
-
 	m.pmut.RLock()
 	m.fmut.RLock()
 
@@ -245,12 +393,25 @@ func (m *Model) ConnectionStats() map[string]ConnectionInfo {
 	return res
 }
 
-// Returns statistics about each device
+// Returns statistics about each device, and the traffic totals of all
+// devices as "total"
 func (m *Model) DeviceStatistics() map[string]stats.DeviceStatistics {
+	m.recordAllConnectionStats()
+
 	var res = make(map[string]stats.DeviceStatistics)
 	for _, device := range m.cfg.Devices {
 		res[device.DeviceID.String()] = m.deviceStatRef(device.DeviceID).GetStatistics()
 	}
+	res["total"] = m.deviceStatRef(protocol.LocalDeviceID).GetStatistics()
+	return res
+}
+
+// Returns statistics about each folder
+func (m *Model) FolderStatistics() map[string]stats.FolderStatistics {
+	var res = make(map[string]stats.FolderStatistics)
+	for _, folder := range m.cfg.Folders {
+		res[folder.ID] = m.folderStatRef(folder.ID).GetStatistics()
+	}
 	return res
 }
 
@@ -285,7 +446,7 @@ func (m *Model) Completion(device protocol.DeviceID, folder string) float64 {
 	})
 
 	res := 100 * (1 - float64(need)/float64(tot))
-	if debug {
+	if debug() {
 		l.Debugf("%v Completion(%s, %q): %f (%d / %d)", m, device, folder, res, need, tot)
 	}
 
@@ -354,14 +515,18 @@ func (m *Model) NeedSize(folder string) (files int, bytes int64) {
 	m.fmut.RLock()
 	defer m.fmut.RUnlock()
 	if rf, ok := m.folderFiles[folder]; ok {
+		ignores := m.folderIgnores[folder]
 		rf.WithNeedTruncated(protocol.LocalDeviceID, func(f protocol.FileIntf) bool {
+			if ignores.Match(f.(protocol.FileInfoTruncated).Name) {
+				return true
+			}
 			fs, de, by := sizeOfFile(f)
 			files += fs + de
 			bytes += by
 			return true
 		})
 	}
-	if debug {
+	if debug() {
 		l.Debugf("%v NeedSize(%q): %d %d", m, folder, files, bytes)
 	}
 	return
@@ -374,9 +539,13 @@ func (m *Model) NeedFolderFilesLimited(folder string, maxFiles, maxBlocks int) [
 	defer m.fmut.RUnlock()
 	nblocks := 0
 	if rf, ok := m.folderFiles[folder]; ok {
+		ignores := m.folderIgnores[folder]
 		fs := make([]protocol.FileInfo, 0, maxFiles)
 		rf.WithNeed(protocol.LocalDeviceID, func(f protocol.FileIntf) bool {
 			fi := f.(protocol.FileInfo)
+			if ignores.Match(fi.Name) {
+				return true
+			}
 			fs = append(fs, fi)
 			nblocks += len(fi.Blocks)
 			return (maxFiles <= 0 || len(fs) < maxFiles) && (maxBlocks <= 0 || nblocks < maxBlocks)
@@ -389,16 +558,18 @@ func (m *Model) NeedFolderFilesLimited(folder string, maxFiles, maxBlocks int) [
 // Index is called when a new device is connected and we receive their full index.
 // Implements the protocol.Model interface.
 func (m *Model) Index(deviceID protocol.DeviceID, folder string, fs []protocol.FileInfo) {
-	if debug {
+	if debug() {
 		l.Debugf("IDX(in): %s %q: %d files", deviceID, folder, len(fs))
 	}
+	defer metrics.EndSpan("index", folder+" from "+deviceID.String(), time.Now())
 
 	if !m.folderSharedWith(folder, deviceID) {
 		events.Default.Log(events.FolderRejected, map[string]string{
-			"folder": folder,
-			"device": deviceID.String(),
+			"folder":        folder,
+			"device":        deviceID.String(),
+			"suggestedPath": m.SuggestedPath(deviceID, folder),
 		})
-		l.Warnf("Unexpected folder ID %q sent from device %q; ensure that the folder exists and that this device is selected under \"Share With\" in the folder configuration.", folder, deviceID)
+		m.log.Warnf("Unexpected folder ID %q sent from device %q; ensure that the folder exists and that this device is selected under \"Share With\" in the folder configuration.", folder, deviceID)
 		return
 	}
 
@@ -408,20 +579,23 @@ func (m *Model) Index(deviceID protocol.DeviceID, folder string, fs []protocol.F
 	m.fmut.RUnlock()
 
 	if !ok {
-		l.Fatalf("Index for nonexistant folder %q", folder)
+		m.log.Fatalf("Index for nonexistant folder %q", folder)
 	}
 
+	fs = m.decryptIndex(folder, deviceID, fs)
 	for i := 0; i < len(fs); {
 		lamport.Default.Tick(fs[i].Version)
 		if ignores.Match(fs[i].Name) {
 			fs[i] = fs[len(fs)-1]
 			fs = fs[:len(fs)-1]
 		} else {
+			m.checkSyncable(folder, &fs[i])
 			i++
 		}
 	}
 
 	files.Replace(deviceID, fs)
+	m.forgetLocalEdits(folder, fs, false)
 
 	events.Default.Log(events.RemoteIndexUpdated, map[string]interface{}{
 		"device":  deviceID.String(),
@@ -429,17 +603,21 @@ func (m *Model) Index(deviceID protocol.DeviceID, folder string, fs []protocol.F
 		"items":   len(fs),
 		"version": files.LocalVersion(deviceID),
 	})
+	m.updateLocallyChanged(folder)
+	m.updateUnsafe(folder)
+	m.updateRemoteCompletion(folder, deviceID)
 }
 
 // IndexUpdate is called for incremental updates to connected devices' indexes.
 // Implements the protocol.Model interface.
 func (m *Model) IndexUpdate(deviceID protocol.DeviceID, folder string, fs []protocol.FileInfo) {
-	if debug {
+	if debug() {
 		l.Debugf("%v IDXUP(in): %s / %q: %d files", m, deviceID, folder, len(fs))
 	}
+	defer metrics.EndSpan("index", folder+" from "+deviceID.String(), time.Now())
 
 	if !m.folderSharedWith(folder, deviceID) {
-		l.Infof("Update for unexpected folder ID %q sent from device %q; ensure that the folder exists and that this device is selected under \"Share With\" in the folder configuration.", folder, deviceID)
+		m.log.Infof("Update for unexpected folder ID %q sent from device %q; ensure that the folder exists and that this device is selected under \"Share With\" in the folder configuration.", folder, deviceID)
 		return
 	}
 
@@ -449,20 +627,23 @@ func (m *Model) IndexUpdate(deviceID protocol.DeviceID, folder string, fs []prot
 	m.fmut.RUnlock()
 
 	if !ok {
-		l.Fatalf("IndexUpdate for nonexistant folder %q", folder)
+		m.log.Fatalf("IndexUpdate for nonexistant folder %q", folder)
 	}
 
+	fs = m.decryptIndex(folder, deviceID, fs)
 	for i := 0; i < len(fs); {
 		lamport.Default.Tick(fs[i].Version)
 		if ignores.Match(fs[i].Name) {
 			fs[i] = fs[len(fs)-1]
 			fs = fs[:len(fs)-1]
 		} else {
+			m.checkSyncable(folder, &fs[i])
 			i++
 		}
 	}
 
 	files.Update(deviceID, fs)
+	m.forgetLocalEdits(folder, fs, false)
 
 	events.Default.Log(events.RemoteIndexUpdated, map[string]interface{}{
 		"device":  deviceID.String(),
@@ -470,6 +651,9 @@ func (m *Model) IndexUpdate(deviceID protocol.DeviceID, folder string, fs []prot
 		"items":   len(fs),
 		"version": files.LocalVersion(deviceID),
 	})
+	m.updateLocallyChanged(folder)
+	m.updateUnsafe(folder)
+	m.updateRemoteCompletion(folder, deviceID)
 }
 
 func (m *Model) folderSharedWith(folder string, deviceID protocol.DeviceID) bool {
@@ -490,12 +674,14 @@ func (m *Model) ClusterConfig(deviceID protocol.DeviceID, cm protocol.ClusterCon
 	} else {
 		m.deviceVer[deviceID] = cm.ClientName + " " + cm.ClientVersion
 	}
+	m.pathHints[deviceID] = cm.PathHints()
+	delete(m.remotePaused, deviceID)
 	m.pmut.Unlock()
 
-	l.Infof(`Device %s client is "%s %s"`, deviceID, cm.ClientName, cm.ClientVersion)
+	m.log.Infof(`Device %s client is "%s %s"`, deviceID, cm.ClientName, cm.ClientVersion)
 
-	if name := cm.GetOption("name"); name != "" {
-		l.Infof("Device %s name is %q", deviceID, name)
+	if name := cm.GetOption(protocol.OptionName); name != "" {
+		m.log.Infof("Device %s name is %q", deviceID, name)
 		device := m.cfg.GetDeviceConfiguration(deviceID)
 		if device != nil && device.Name == "" {
 			device.Name = name
@@ -503,6 +689,10 @@ func (m *Model) ClusterConfig(deviceID protocol.DeviceID, cm protocol.ClusterCon
 		}
 	}
 
+	m.handleSuccession(deviceID, cm)
+	m.checkFolderModes(deviceID, cm)
+	m.deliverUnshares(deviceID)
+
 	if m.cfg.GetDeviceConfiguration(deviceID).Introducer {
 		// This device is an introducer. Go through the announced lists of folders
 		// and devices and add what we are missing.
@@ -521,17 +711,31 @@ func (m *Model) ClusterConfig(deviceID protocol.DeviceID, cm protocol.ClusterCon
 				var id protocol.DeviceID
 				copy(id[:], device.ID)
 
+				if m.cfg.IsBlocked(id) {
+					continue nextDevice
+				}
+
+				if m.cfg.GetDeviceConfiguration(id) == nil && m.cfg.Options.ApproveIntroduced {
+					// The device is currently unknown and the user wants to
+					// approve it before trusting it.
+					if m.addPendingDevice(id, deviceID, folder.ID, device.Flags&protocol.FlagIntroducer != 0) {
+						changed = true
+					}
+					continue nextDevice
+				}
+
 				if m.cfg.GetDeviceConfiguration(id) == nil {
 					// The device is currently unknown. Add it to the config.
 
-					l.Infof("Adding device %v to config (vouched for by introducer %v)", id, deviceID)
+					m.log.Infof("Adding device %v to config (vouched for by introducer %v)", id, deviceID)
 					newDeviceCfg := config.DeviceConfiguration{
-						DeviceID: id,
+						DeviceID:     id,
+						IntroducedBy: deviceID.String(),
 					}
 
 					// The introducers' introducers are also our introducers.
 					if device.Flags&protocol.FlagIntroducer != 0 {
-						l.Infof("Device %v is now also an introducer", id)
+						m.log.Infof("Device %v is now also an introducer", id)
 						newDeviceCfg.Introducer = true
 					}
 
@@ -551,20 +755,37 @@ func (m *Model) ClusterConfig(deviceID protocol.DeviceID, cm protocol.ClusterCon
 				// We don't yet share this folder with this device. Add the device
 				// to sharing list of the folder.
 
-				l.Infof("Adding device %v to share %q (vouched for by introducer %v)", id, folder.ID, deviceID)
+				m.fmut.RLock()
+				encrypted := m.sharedEncrypted(folder.ID)
+				m.fmut.RUnlock()
+				if encrypted {
+					m.log.Warnf("Not sharing folder %q with device %v (vouched for by introducer %v), as the folder is encrypted for some devices; share it manually, with an encryption password if the device is not to be trusted", folder.ID, id, deviceID)
+					continue nextDevice
+				}
+
+				m.log.Infof("Adding device %v to share %q (vouched for by introducer %v)", id, folder.ID, deviceID)
 
 				m.deviceFolders[id] = append(m.deviceFolders[id], folder.ID)
 				m.folderDevices[folder.ID] = append(m.folderDevices[folder.ID], id)
 
 				folderCfg := m.cfg.GetFolderConfiguration(folder.ID)
 				folderCfg.Devices = append(folderCfg.Devices, config.FolderDeviceConfiguration{
-					DeviceID: id,
+					DeviceID:     id,
+					IntroducedBy: deviceID.String(),
 				})
 
 				changed = true
 			}
 		}
 
+		if m.cfg.GetDeviceConfiguration(deviceID).IntroductionRemovals && m.removeUnannounced(deviceID, cm) {
+			changed = true
+		}
+
+		if m.applyDeviceNames(deviceID, cm) {
+			changed = true
+		}
+
 		if changed {
 			m.cfg.Save()
 		}
@@ -574,12 +795,22 @@ func (m *Model) ClusterConfig(deviceID protocol.DeviceID, cm protocol.ClusterCon
 // Close removes the peer from the model and closes the underlying connection if possible.
 // Implements the protocol.Model interface.
 func (m *Model) Close(device protocol.DeviceID, err error) {
-	l.Infof("Connection to %s closed: %v", device, err)
+	if err != nil && err.Error() == protocol.ReasonPaused {
+		m.log.Infof("Connection to %s closed: the device has paused us", device)
+		m.pmut.Lock()
+		m.remotePaused[device] = time.Now()
+		m.pmut.Unlock()
+	} else {
+		m.log.Infof("Connection to %s closed: %v", device, err)
+	}
 	events.Default.Log(events.DeviceDisconnected, map[string]string{
 		"id":    device.String(),
 		"error": err.Error(),
 	})
 
+	m.recordConnectionStats(device)
+	m.clearFolderModes(device)
+
 	m.pmut.Lock()
 	m.fmut.RLock()
 	for _, folder := range m.deviceFolders[device] {
@@ -601,56 +832,84 @@ func (m *Model) Close(device protocol.DeviceID, err error) {
 	delete(m.protoConn, device)
 	delete(m.rawConn, device)
 	delete(m.deviceVer, device)
+	delete(m.connStats, device)
+	delete(m.pathHints, device)
 	m.pmut.Unlock()
 }
 
+// Disconnect closes the connection to the device, if there is one. The model
+// forgets about the device when the connection reports being closed.
+func (m *Model) Disconnect(device protocol.DeviceID) {
+	m.pmut.RLock()
+	if conn, ok := m.rawConn[device]; ok {
+		conn.Close()
+	}
+	m.pmut.RUnlock()
+}
+
 // Request returns the specified data segment by reading it from local disk.
 // Implements the protocol.Model interface.
 func (m *Model) Request(deviceID protocol.DeviceID, folder, name string, offset int64, size int) ([]byte, error) {
+	if key := m.encryptionKey(folder, deviceID); key != nil {
+		return m.requestEncrypted(key, deviceID, folder, name, offset, size)
+	}
+	return m.request(deviceID, folder, name, offset, size)
+}
+
+func (m *Model) request(deviceID protocol.DeviceID, folder, name string, offset int64, size int) ([]byte, error) {
 	// Verify that the requested file exists in the local model.
 	m.fmut.RLock()
 	r, ok := m.folderFiles[folder]
 	m.fmut.RUnlock()
 
 	if !ok {
-		l.Warnf("Request from %s for file %s in nonexistent folder %q", deviceID, name, folder)
+		m.log.Warnf("Request from %s for file %s in nonexistent folder %q", deviceID, name, folder)
 		return nil, ErrNoSuchFile
 	}
 
 	lf := r.Get(protocol.LocalDeviceID, name)
 	if protocol.IsInvalid(lf.Flags) || protocol.IsDeleted(lf.Flags) {
-		if debug {
+		if debug() {
 			l.Debugf("%v REQ(in): %s: %q / %q o=%d s=%d; invalid: %v", m, deviceID, folder, name, offset, size, lf)
 		}
 		return nil, ErrInvalid
 	}
 
 	if offset > lf.Size() {
-		if debug {
+		if debug() {
 			l.Debugf("%v REQ(in; nonexistent): %s: %q o=%d s=%d", m, deviceID, name, offset, size)
 		}
 		return nil, ErrNoSuchFile
 	}
 
-	if debug && deviceID != protocol.LocalDeviceID {
+	if debug() && deviceID != protocol.LocalDeviceID {
 		l.Debugf("%v REQ(in): %s: %q / %q o=%d s=%d", m, deviceID, folder, name, offset, size)
 	}
 	m.fmut.RLock()
-	fn := filepath.Join(m.folderCfgs[folder].Path, name)
+	cfg := m.folderCfgs[folder]
+	st := m.folderStorage[folder]
 	m.fmut.RUnlock()
-	fd, err := os.Open(fn) // XXX: Inefficient, should cache fd?
-	if err != nil {
-		return nil, err
-	}
-	defer fd.Close()
 
-	buf := make([]byte, size)
-	_, err = fd.ReadAt(buf, offset)
-	if err != nil {
-		return nil, err
+	if cfg.Placeholders && isPlaceholder(filepath.Join(cfg.Path, name), lf) {
+		if debug() {
+			l.Debugf("%v REQ(in; placeholder): %s: %q o=%d s=%d", m, deviceID, name, offset, size)
+		}
+		return nil, ErrNoSuchFile
 	}
 
-	return buf, nil
+	// The buffer is returned to the pool by the connection once the
+	// response is sent
+	var bs []byte
+	var err error
+	if lf.IsSymlink() {
+		bs, err = readSymlinkBlock(filepath.Join(cfg.Path, name), offset, size)
+	} else {
+		bs, err = st.ReadBlock(name, offset, size)
+	}
+	if err == nil {
+		m.talkers.add(deviceID, folder, 0, len(bs))
+	}
+	return bs, err
 }
 
 // ReplaceLocal replaces the local folder index with the given list of files.
@@ -674,6 +933,51 @@ func (m *Model) CurrentGlobalFile(folder string, file string) protocol.FileInfo
 	return f
 }
 
+// WithGlobal calls fn for the global version of each file in the folder,
+// until it returns false.
+func (m *Model) WithGlobal(folder string, fn func(protocol.FileInfo) bool) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return
+	}
+	fs.WithGlobal(func(f protocol.FileIntf) bool {
+		return fn(f.(protocol.FileInfo))
+	})
+}
+
+// RequestBlock fetches a block of the global version of the named file from
+// the least busy connected device that has it. This lets files be read
+// without being synced; the data is verified against the block hash.
+func (m *Model) RequestBlock(folder, name string, block protocol.BlockInfo) ([]byte, error) {
+	var potentialDevices []protocol.DeviceID
+	for _, device := range m.availability(folder, name) {
+		if m.ConnectedTo(device) {
+			potentialDevices = append(potentialDevices, device)
+		}
+	}
+	selected := activity.leastBusy(potentialDevices)
+	if selected == (protocol.DeviceID{}) {
+		return nil, errNoDevice
+	}
+
+	m.netIO.take()
+	activity.using(selected)
+	buf, err := m.requestGlobal(selected, folder, name, block.Offset, int(block.Size), block.Hash)
+	activity.done(selected)
+	m.netIO.give()
+	if err != nil {
+		return nil, err
+	}
+
+	if hash := sha256.Sum256(buf); !bytes.Equal(hash[:], block.Hash) {
+		protocol.BufferPool.Put(buf)
+		return nil, fmt.Errorf("%s: block at offset %d has the wrong hash", name, block.Offset)
+	}
+	return buf, nil
+}
+
 type cFiler struct {
 	m *Model
 	r string
@@ -684,6 +988,19 @@ func (cf cFiler) CurrentFile(file string) protocol.FileInfo {
 	return cf.m.CurrentFolderFile(cf.r, file)
 }
 
+type scanLimiter struct {
+	l ioLimiter
+}
+
+// Implements scanner.IOLimiter
+func (sl scanLimiter) Take() {
+	sl.l.take()
+}
+
+func (sl scanLimiter) Give() {
+	sl.l.give()
+}
+
 // ConnectedTo returns true if we are connected to the named device.
 func (m *Model) ConnectedTo(deviceID protocol.DeviceID) bool {
 	m.pmut.RLock()
@@ -711,7 +1028,7 @@ func (m *Model) GetIgnores(folder string) ([]string, error) {
 		if os.IsNotExist(err) {
 			return lines, nil
 		}
-		l.Warnln("Loading .stignore:", err)
+		m.log.Warnln("Loading .stignore:", err)
 		return lines, err
 	}
 	defer fd.Close()
@@ -730,9 +1047,16 @@ func (m *Model) SetIgnores(folder string, content []string) error {
 		return fmt.Errorf("Folder %s does not exist", folder)
 	}
 
+	// A mistake in the patterns is reported rather than saved, as the
+	// patterns loaded before would stay in effect
+	file := filepath.Join(cfg.Path, ".stignore")
+	if _, err := ignore.Parse(strings.NewReader(strings.Join(content, "\n")), file); err != nil {
+		return err
+	}
+
 	fd, err := ioutil.TempFile(cfg.Path, ".syncthing.stignore-"+folder)
 	if err != nil {
-		l.Warnln("Saving .stignore:", err)
+		m.log.Warnln("Saving .stignore:", err)
 		return err
 	}
 	defer os.Remove(fd.Name())
@@ -740,21 +1064,20 @@ func (m *Model) SetIgnores(folder string, content []string) error {
 	for _, line := range content {
 		_, err = fmt.Fprintln(fd, line)
 		if err != nil {
-			l.Warnln("Saving .stignore:", err)
+			m.log.Warnln("Saving .stignore:", err)
 			return err
 		}
 	}
 
 	err = fd.Close()
 	if err != nil {
-		l.Warnln("Saving .stignore:", err)
+		m.log.Warnln("Saving .stignore:", err)
 		return err
 	}
 
-	file := filepath.Join(cfg.Path, ".stignore")
 	err = osutil.Rename(fd.Name(), file)
 	if err != nil {
-		l.Warnln("Saving .stignore:", err)
+		m.log.Warnln("Saving .stignore:", err)
 		return err
 	}
 
@@ -776,6 +1099,7 @@ func (m *Model) AddConnection(rawConn io.Closer, protoConn protocol.Connection)
 		panic("add existing device")
 	}
 	m.rawConn[deviceID] = rawConn
+	m.connStats[deviceID] = protocol.Statistics{At: time.Now()}
 
 	cm := m.clusterConfig(deviceID)
 	protoConn.ClusterConfig(cm)
@@ -783,7 +1107,11 @@ func (m *Model) AddConnection(rawConn io.Closer, protoConn protocol.Connection)
 	m.fmut.RLock()
 	for _, folder := range m.deviceFolders[deviceID] {
 		fs := m.folderFiles[folder]
-		go sendIndexes(protoConn, folder, fs, m.folderIgnores[folder])
+		conn := protoConn
+		if key := m.folderKeys[folder][deviceID]; key != nil {
+			conn = newEncryptedConnection(protoConn, key)
+		}
+		go sendIndexes(conn, folder, fs, m.folderIgnores[folder])
 	}
 	m.fmut.RUnlock()
 	m.pmut.Unlock()
@@ -804,16 +1132,75 @@ func (m *Model) deviceStatRef(deviceID protocol.DeviceID) *stats.DeviceStatistic
 	}
 }
 
+func (m *Model) folderStatRef(folder string) *stats.FolderStatisticsReference {
+	m.fmut.Lock()
+	defer m.fmut.Unlock()
+
+	sr, ok := m.folderStatRefs[folder]
+	if !ok {
+		sr = stats.NewFolderStatisticsReference(m.db, folder)
+		m.folderStatRefs[folder] = sr
+	}
+	return sr
+}
+
+// receivedFile records the file as the last one received in the folder,
+// from the first device that has it.
+func (m *Model) receivedFile(folder, name string) {
+	var device protocol.DeviceID
+	if devs := m.availability(folder, name); len(devs) > 0 {
+		device = devs[0]
+	}
+	m.folderStatRef(folder).ReceivedFile(name, device)
+}
+
 func (m *Model) deviceWasSeen(deviceID protocol.DeviceID) {
 	m.deviceStatRef(deviceID).WasSeen()
 }
 
+// recordConnectionStats adds the traffic and time since last recorded for
+// the connection to the device, if any, to the device statistics.
+func (m *Model) recordConnectionStats(deviceID protocol.DeviceID) {
+	m.pmut.Lock()
+	conn, ok := m.protoConn[deviceID]
+	if !ok {
+		m.pmut.Unlock()
+		return
+	}
+	last := m.connStats[deviceID]
+	cur := conn.Statistics()
+	m.connStats[deviceID] = cur
+	var addr net.Addr
+	if nc, ok := m.rawConn[deviceID].(remoteAddrer); ok {
+		addr = nc.RemoteAddr()
+	}
+	m.pmut.Unlock()
+
+	in, out := cur.InBytesTotal-last.InBytesTotal, cur.OutBytesTotal-last.OutBytesTotal
+	lan := isLAN(addr, m.cfg.Options.LANNetworks)
+	m.deviceStatRef(deviceID).AddConnection(in, out, cur.At.Sub(last.At), lan)
+	m.deviceStatRef(protocol.LocalDeviceID).AddConnection(in, out, 0, lan)
+}
+
+func (m *Model) recordAllConnectionStats() {
+	m.pmut.RLock()
+	devices := make([]protocol.DeviceID, 0, len(m.protoConn))
+	for deviceID := range m.protoConn {
+		devices = append(devices, deviceID)
+	}
+	m.pmut.RUnlock()
+
+	for _, deviceID := range devices {
+		m.recordConnectionStats(deviceID)
+	}
+}
+
 func sendIndexes(conn protocol.Connection, folder string, fs *files.Set, ignores ignore.Patterns) {
 	deviceID := conn.ID()
 	name := conn.Name()
 	var err error
 
-	if debug {
+	if debug() {
 		l.Debugf("sendIndexes for %s-%s/%q starting", deviceID, name, folder)
 	}
 
@@ -828,7 +1215,7 @@ func sendIndexes(conn protocol.Connection, folder string, fs *files.Set, ignores
 		minLocalVer, err = sendIndexTo(false, minLocalVer, conn, folder, fs, ignores)
 	}
 
-	if debug {
+	if debug() {
 		l.Debugf("sendIndexes for %s-%s/%q exiting: %v", deviceID, name, folder, err)
 	}
 }
@@ -836,6 +1223,7 @@ func sendIndexes(conn protocol.Connection, folder string, fs *files.Set, ignores
 func sendIndexTo(initial bool, minLocalVer uint64, conn protocol.Connection, folder string, fs *files.Set, ignores ignore.Patterns) (uint64, error) {
 	deviceID := conn.ID()
 	name := conn.Name()
+	defer metrics.EndSpan("index", folder+" to "+deviceID.String(), time.Now())
 	batch := make([]protocol.FileInfo, 0, indexBatchSize)
 	currentBatchSize := 0
 	maxLocalVer := uint64(0)
@@ -860,7 +1248,7 @@ func sendIndexTo(initial bool, minLocalVer uint64, conn protocol.Connection, fol
 				if err = conn.Index(folder, batch); err != nil {
 					return false
 				}
-				if debug {
+				if debug() {
 					l.Debugf("sendIndexes for %s-%s/%q: %d files (<%d bytes) (initial index)", deviceID, name, folder, len(batch), currentBatchSize)
 				}
 				initial = false
@@ -868,7 +1256,7 @@ func sendIndexTo(initial bool, minLocalVer uint64, conn protocol.Connection, fol
 				if err = conn.IndexUpdate(folder, batch); err != nil {
 					return false
 				}
-				if debug {
+				if debug() {
 					l.Debugf("sendIndexes for %s-%s/%q: %d files (<%d bytes) (batched update)", deviceID, name, folder, len(batch), currentBatchSize)
 				}
 			}
@@ -884,12 +1272,12 @@ func sendIndexTo(initial bool, minLocalVer uint64, conn protocol.Connection, fol
 
 	if initial && err == nil {
 		err = conn.Index(folder, batch)
-		if debug && err == nil {
+		if debug() && err == nil {
 			l.Debugf("sendIndexes for %s-%s/%q: %d files (small initial index)", deviceID, name, folder, len(batch))
 		}
 	} else if len(batch) > 0 && err == nil {
 		err = conn.IndexUpdate(folder, batch)
-		if debug && err == nil {
+		if debug() && err == nil {
 			l.Debugf("sendIndexes for %s-%s/%q: %d files (last batch)", deviceID, name, folder, len(batch))
 		}
 	}
@@ -902,10 +1290,12 @@ func (m *Model) updateLocal(folder string, f protocol.FileInfo) {
 	m.fmut.RLock()
 	m.folderFiles[folder].Update(protocol.LocalDeviceID, []protocol.FileInfo{f})
 	m.fmut.RUnlock()
-	events.Default.Log(events.LocalIndexUpdated, map[string]interface{}{
+	m.forgetLocalEdits(folder, []protocol.FileInfo{f}, true)
+	m.clearFailure(folder, f.Name)
+	m.itemEvents.Log(events.LocalIndexUpdated, folder, f.Name, map[string]interface{}{
 		"folder":   folder,
 		"name":     f.Name,
-		"modified": time.Unix(f.Modified, 0),
+		"modified": f.ModTime(),
 		"flags":    fmt.Sprintf("0%o", f.Flags),
 		"size":     f.Size(),
 	})
@@ -920,11 +1310,30 @@ func (m *Model) requestGlobal(deviceID protocol.DeviceID, folder, name string, o
 		return nil, fmt.Errorf("requestGlobal: no such device: %s", deviceID)
 	}
 
-	if debug {
+	// An untrusted device only has the encrypted file
+	key := m.encryptionKey(folder, deviceID)
+	if key != nil {
+		name = key.EncryptName(name)
+		offset = protocol.EncryptedOffset(offset)
+		size += protocol.EncryptionOverhead
+	}
+
+	if debug() {
 		l.Debugf("%v REQ(out): %s: %q / %q o=%d s=%d h=%x", m, deviceID, folder, name, offset, size, hash)
 	}
 
-	return nc.Request(folder, name, offset, size)
+	bs, err := nc.Request(folder, name, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	m.talkers.add(deviceID, folder, len(bs), 0)
+
+	if key != nil {
+		enc := bs
+		bs, err = key.DecryptBlock(enc)
+		protocol.BufferPool.Put(enc)
+	}
+	return bs, err
 }
 
 func (m *Model) AddFolder(cfg config.FolderConfiguration) {
@@ -935,14 +1344,38 @@ func (m *Model) AddFolder(cfg config.FolderConfiguration) {
 		panic("cannot add empty folder id")
 	}
 
+	storageType := cfg.Storage.Type
+	if storageType == "" {
+		storageType = "local"
+	}
+	factory, ok := storage.Factories[storageType]
+	if !ok {
+		m.log.Fatalf("Requested storage type %q that does not exist", storageType)
+	}
+	st, err := factory(cfg.ID, cfg.Path, cfg.Storage.Params)
+	if err != nil {
+		m.log.Fatalf("Folder %q: storage: %v", cfg.ID, err)
+	}
+
+	if cfg.MinCopies > len(cfg.Devices) {
+		m.log.Warnf("Folder %q requires %d copies of each file but is shared with %d devices; no file will be counted as safe.", cfg.ID, cfg.MinCopies, len(cfg.Devices))
+	}
+
 	m.fmut.Lock()
 	m.folderCfgs[cfg.ID] = cfg
 	m.folderFiles[cfg.ID] = files.NewSet(cfg.ID, m.db)
+	m.folderStorage[cfg.ID] = st
 
 	m.folderDevices[cfg.ID] = make([]protocol.DeviceID, len(cfg.Devices))
 	for i, device := range cfg.Devices {
 		m.folderDevices[cfg.ID][i] = device.DeviceID
 		m.deviceFolders[device.DeviceID] = append(m.deviceFolders[device.DeviceID], cfg.ID)
+		if key := cfg.EncryptionKey(device.DeviceID); key != nil {
+			if m.folderKeys[cfg.ID] == nil {
+				m.folderKeys[cfg.ID] = make(map[protocol.DeviceID]*protocol.EncryptionKey)
+			}
+			m.folderKeys[cfg.ID][device.DeviceID] = key
+		}
 	}
 
 	m.addedFolder = true
@@ -964,7 +1397,7 @@ func (m *Model) ScanFolders() {
 		go func() {
 			err := m.ScanFolder(folder)
 			if err != nil {
-				invalidateFolder(m.cfg, folder, err)
+				m.invalidateFolder(folder, err)
 			}
 			wg.Done()
 		}()
@@ -980,13 +1413,28 @@ func (m *Model) ScanFolderSub(folder, sub string) error {
 	if p := filepath.Clean(filepath.Join(folder, sub)); !strings.HasPrefix(p, folder) {
 		return errors.New("invalid subpath")
 	}
+	if m.folderPaused(folder) {
+		return errFolderPaused
+	}
+	if m.folderEncrypted(folder) {
+		// The contents are what other devices sent, which we can't read
+		return nil
+	}
 
 	m.fmut.RLock()
-	fs, ok := m.folderFiles[folder]
 	dir := m.folderCfgs[folder].Path
+	m.fmut.RUnlock()
+	ignores := m.loadIgnores(folder, dir)
 
-	ignores, _ := ignore.Load(filepath.Join(dir, ".stignore"))
-	m.folderIgnores[folder] = ignores
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	placeholders := m.folderCfgs[folder].Placeholders
+	ownership := m.folderCfgs[folder].SyncOwnership
+	if p, ok := m.folderRunners[folder].(*Puller); ok {
+		// Owners we can't set locally are neither compared nor announced,
+		// or every pull would be followed by a local change undoing it
+		ownership = p.owners != nil
+	}
 
 	w := &scanner.Walker{
 		Dir:          dir,
@@ -996,6 +1444,13 @@ func (m *Model) ScanFolderSub(folder, sub string) error {
 		TempNamer:    defTempNamer,
 		CurrentFiler: cFiler{m, folder},
 		IgnorePerms:  m.folderCfgs[folder].IgnorePerms,
+		ACLs:         m.folderCfgs[folder].SyncACLs,
+		Ownership:    ownership,
+		Symlinks:     scanner.LinkPolicy(m.folderCfgs[folder].SymlinkPolicy),
+		Junctions:    scanner.LinkPolicy(m.folderCfgs[folder].JunctionPolicy),
+		Hashers:      m.profile.Hashers,
+		DiskIO:       scanLimiter{m.diskIO},
+		Logger:       m.log,
 	}
 	m.fmut.RUnlock()
 	if !ok {
@@ -1003,34 +1458,44 @@ func (m *Model) ScanFolderSub(folder, sub string) error {
 	}
 
 	m.setState(folder, FolderScanning)
+	defer metrics.GetTimer("scan." + folder).UpdateSince(time.Now())
+	defer metrics.EndSpan("scan", folder, time.Now())
+	started := time.Now()
 	fchan, err := w.Walk()
 
 	if err != nil {
 		return err
 	}
-	batchSize := 100
-	batch := make([]protocol.FileInfo, 0, 00)
+	batch := newFileInfoBatch(func(infos []protocol.FileInfo) {
+		fs.Update(protocol.LocalDeviceID, infos)
+		m.recordLocalEdits(folder, infos)
+	})
 	for f := range fchan {
-		events.Default.Log(events.LocalIndexUpdated, map[string]interface{}{
+		if placeholders && f.Size() == 0 && isPlaceholder(filepath.Join(dir, f.Name), fs.Get(protocol.LocalDeviceID, f.Name)) {
+			// A placeholder that was touched must not replace the real
+			// file with an empty one
+			continue
+		}
+		m.itemEvents.Log(events.LocalIndexUpdated, folder, f.Name, map[string]interface{}{
 			"folder":   folder,
 			"name":     f.Name,
-			"modified": time.Unix(f.Modified, 0),
+			"modified": f.ModTime(),
 			"flags":    fmt.Sprintf("0%o", f.Flags),
 			"size":     f.Size(),
 		})
-		if len(batch) == batchSize {
-			fs.Update(protocol.LocalDeviceID, batch)
-			batch = batch[:0]
-		}
-		batch = append(batch, f)
+		batch.append(f)
 	}
-	if len(batch) > 0 {
-		fs.Update(protocol.LocalDeviceID, batch)
+	batch.flush()
+
+	// A walk that was cut short must not cause the files it didn't get to
+	// to be marked as deleted
+	if err := w.Err(); err != nil {
+		return err
 	}
 
-	batch = batch[:0]
 	// TODO: We should limit the Have scanning to start at sub
 	seenPrefix := false
+	cases := osutil.NewCaseChecker()
 	fs.WithHaveTruncated(protocol.LocalDeviceID, func(fi protocol.FileIntf) bool {
 		f := fi.(protocol.FileInfoTruncated)
 		if !strings.HasPrefix(f.Name, sub) {
@@ -1046,11 +1511,6 @@ func (m *Model) ScanFolderSub(folder, sub string) error {
 				return true
 			}
 
-			if len(batch) == batchSize {
-				fs.Update(protocol.LocalDeviceID, batch)
-				batch = batch[:0]
-			}
-
 			if ignores.Match(f.Name) {
 				// File has been ignored. Set invalid bit.
 				nf := protocol.FileInfo{
@@ -1059,56 +1519,65 @@ func (m *Model) ScanFolderSub(folder, sub string) error {
 					Modified: f.Modified,
 					Version:  f.Version, // The file is still the same, so don't bump version
 				}
-				events.Default.Log(events.LocalIndexUpdated, map[string]interface{}{
+				m.itemEvents.Log(events.LocalIndexUpdated, folder, f.Name, map[string]interface{}{
 					"folder":   folder,
 					"name":     f.Name,
 					"modified": time.Unix(f.Modified, 0),
 					"flags":    fmt.Sprintf("0%o", f.Flags),
 					"size":     f.Size(),
 				})
-				batch = append(batch, nf)
-			} else if _, err := os.Stat(filepath.Join(dir, f.Name)); err != nil && os.IsNotExist(err) {
-				// File has been deleted
+				batch.append(nf)
+			} else if real, err := cases.RealCase(dir, f.Name); os.IsNotExist(err) || err == nil && real != f.Name {
+				// File has been deleted, or renamed to a name differing only
+				// in case which the walker has picked up as a new file
 				nf := protocol.FileInfo{
 					Name:     f.Name,
 					Flags:    f.Flags | protocol.FlagDeleted,
 					Modified: f.Modified,
 					Version:  lamport.Default.Tick(f.Version),
 				}
-				events.Default.Log(events.LocalIndexUpdated, map[string]interface{}{
+				m.itemEvents.Log(events.LocalIndexUpdated, folder, f.Name, map[string]interface{}{
 					"folder":   folder,
 					"name":     f.Name,
 					"modified": time.Unix(f.Modified, 0),
 					"flags":    fmt.Sprintf("0%o", f.Flags),
 					"size":     f.Size(),
 				})
-				batch = append(batch, nf)
+				batch.append(nf)
 			}
 		}
 		return true
 	})
-	if len(batch) > 0 {
-		fs.Update(protocol.LocalDeviceID, batch)
-	}
+	batch.flush()
 
+	if sub == "" {
+		m.folderStatRef(folder).ScanCompleted(started)
+	}
 	m.setState(folder, FolderIdle)
 	return nil
 }
 
 // clusterConfig returns a ClusterConfigMessage that is correct for the given peer device
+// maxClusterConfigOptions is the protocol maximum of options in a cluster
+// config, less the one added by the connection.
+const maxClusterConfigOptions = 63
+
 func (m *Model) clusterConfig(device protocol.DeviceID) protocol.ClusterConfigMessage {
 	cm := protocol.ClusterConfigMessage{
 		ClientName:    m.clientName,
 		ClientVersion: m.clientVersion,
 		Options: []protocol.Option{
 			{
-				Key:   "name",
+				Key:   protocol.OptionName,
 				Value: m.deviceName,
 			},
 		},
 	}
+	cm.Options = append(cm.Options, m.successorOptions()...)
 
 	m.fmut.RLock()
+	cm.Options = append(cm.Options, m.pathHintOptions(device, maxClusterConfigOptions-len(cm.Options))...)
+	cm.Options = append(cm.Options, m.deviceNameOptions(device, maxClusterConfigOptions-len(cm.Options))...)
 	for _, folder := range m.deviceFolders[device] {
 		cr := protocol.Folder{
 			ID: folder,
@@ -1117,11 +1586,13 @@ func (m *Model) clusterConfig(device protocol.DeviceID) protocol.ClusterConfigMe
 			// DeviceID is a value type, but with an underlying array. Copy it
 			// so we don't grab aliases to the same array later on in device[:]
 			device := device
-			// TODO: Set read only bit when relevant
 			cn := protocol.Device{
 				ID:    device[:],
 				Flags: protocol.FlagShareTrusted,
 			}
+			if device == m.id && m.folderCfgs[folder].ReadOnly {
+				cn.Flags |= protocol.FlagShareReadOnly
+			}
 			if deviceCfg := m.cfg.GetDeviceConfiguration(device); deviceCfg.Introducer {
 				cn.Flags |= protocol.FlagIntroducer
 			}
@@ -1138,6 +1609,11 @@ func (m *Model) setState(folder string, state folderState) {
 	m.smut.Lock()
 	oldState := m.folderState[folder]
 	changed, ok := m.folderStateChanged[folder]
+	if m.pausedFolders[folder] {
+		state = FolderPaused
+	} else if state == FolderIdle && len(m.localChanges[folder]) > 0 {
+		state = FolderOutOfSync
+	}
 	if state != oldState {
 		m.folderState[folder] = state
 		m.folderStateChanged[folder] = time.Now()
@@ -1154,6 +1630,12 @@ func (m *Model) setState(folder string, state folderState) {
 	m.smut.Unlock()
 }
 
+// CheckDB returns an error if the database is not usable.
+func (m *Model) CheckDB() error {
+	_, err := m.db.GetProperty("leveldb.stats")
+	return err
+}
+
 func (m *Model) State(folder string) (string, time.Time) {
 	m.smut.RLock()
 	state := m.folderState[folder]
@@ -1193,6 +1675,7 @@ func (m *Model) Override(folder string) {
 	if len(batch) > 0 {
 		fs.Update(protocol.LocalDeviceID, batch)
 	}
+	m.updateLocallyChanged(folder)
 	m.setState(folder, FolderIdle)
 }
 
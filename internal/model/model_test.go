@@ -12,7 +12,7 @@ import (
 	"time"
 
 	"github.com/syncthing/syncthing/internal/config"
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/lib/protocol"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/storage"
 )
@@ -57,7 +57,7 @@ func init() {
 
 func TestRequest(t *testing.T) {
 	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
-	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
 	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata"})
 	m.ScanFolder("default")
 
@@ -94,7 +94,7 @@ func genFiles(n int) []protocol.FileInfo {
 
 func BenchmarkIndex10000(b *testing.B) {
 	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
-	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db)
+	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db, nil)
 	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata"})
 	m.ScanFolder("default")
 	files := genFiles(10000)
@@ -107,7 +107,7 @@ func BenchmarkIndex10000(b *testing.B) {
 
 func BenchmarkIndex00100(b *testing.B) {
 	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
-	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db)
+	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db, nil)
 	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata"})
 	m.ScanFolder("default")
 	files := genFiles(100)
@@ -120,7 +120,7 @@ func BenchmarkIndex00100(b *testing.B) {
 
 func BenchmarkIndexUpdate10000f10000(b *testing.B) {
 	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
-	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db)
+	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db, nil)
 	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata"})
 	m.ScanFolder("default")
 	files := genFiles(10000)
@@ -134,7 +134,7 @@ func BenchmarkIndexUpdate10000f10000(b *testing.B) {
 
 func BenchmarkIndexUpdate10000f00100(b *testing.B) {
 	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
-	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db)
+	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db, nil)
 	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata"})
 	m.ScanFolder("default")
 	files := genFiles(10000)
@@ -149,7 +149,7 @@ func BenchmarkIndexUpdate10000f00100(b *testing.B) {
 
 func BenchmarkIndexUpdate10000f00001(b *testing.B) {
 	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
-	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db)
+	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db, nil)
 	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata"})
 	m.ScanFolder("default")
 	files := genFiles(10000)
@@ -197,6 +197,10 @@ func (f FakeConnection) Request(folder, name string, offset int64, size int) ([]
 
 func (FakeConnection) ClusterConfig(protocol.ClusterConfigMessage) {}
 
+func (FakeConnection) Unshare(string, bool) error {
+	return nil
+}
+
 func (FakeConnection) Ping() bool {
 	return true
 }
@@ -207,7 +211,7 @@ func (FakeConnection) Statistics() protocol.Statistics {
 
 func BenchmarkRequest(b *testing.B) {
 	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
-	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db)
+	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db, nil)
 	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata"})
 	m.ScanFolder("default")
 
@@ -255,7 +259,7 @@ func TestDeviceRename(t *testing.T) {
 	}
 
 	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
-	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
 	if cfg.Devices[0].Name != "" {
 		t.Errorf("Device already has a name")
 	}
@@ -313,7 +317,7 @@ func TestClusterConfig(t *testing.T) {
 
 	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
 
-	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
 	m.AddFolder(cfg.Folders[0])
 	m.AddFolder(cfg.Folders[1])
 
@@ -379,7 +383,7 @@ func TestIgnores(t *testing.T) {
 	}
 
 	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
-	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db)
+	m := NewModel("/tmp", nil, "device", "syncthing", "dev", db, nil)
 	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata"})
 
 	expected := []string{
@@ -449,3 +453,222 @@ func TestIgnores(t *testing.T) {
 		t.Errorf("Expected no ignores, got: %v", ignores)
 	}
 }
+
+func TestIntroducedDeviceApproval(t *testing.T) {
+	device3, _ := protocol.DeviceIDFromString("LGFPDIT-7SKNNJL-VJZA4FC-7QNCRKA-CE753K7-2BW5QDK-2FOZ7FR-FEP57QJ")
+
+	cfg := config.New("/tmp/test", device1)
+	cfg.Options.ApproveIntroduced = true
+	cfg.Devices = []config.DeviceConfiguration{
+		{
+			DeviceID: device1,
+		},
+		{
+			DeviceID:   device2,
+			Introducer: true,
+		},
+	}
+	cfg.Folders = []config.FolderConfiguration{
+		{
+			ID: "folder1",
+			Devices: []config.FolderDeviceConfiguration{
+				{DeviceID: device1},
+				{DeviceID: device2},
+			},
+		},
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(cfg.Folders[0])
+
+	m.ClusterConfig(device2, protocol.ClusterConfigMessage{
+		Folders: []protocol.Folder{
+			{
+				ID: "folder1",
+				Devices: []protocol.Device{
+					{ID: device2[:], Flags: protocol.FlagIntroducer},
+					{ID: device3[:]},
+				},
+			},
+		},
+	})
+
+	if cfg.GetDeviceConfiguration(device3) != nil {
+		t.Fatal("Introduced device added without approval")
+	}
+	pending := m.PendingDevices()
+	if len(pending) != 1 || pending[0].DeviceID != device3 || pending[0].IntroducedBy != device2 {
+		t.Fatalf("Unexpected pending devices %v", pending)
+	}
+
+	// A device blocked while pending can't be accepted
+	cfg.BlockedDevices = []protocol.DeviceID{device3}
+	if err := m.AcceptPendingDevice(device3); err != ErrDeviceBlocked {
+		t.Errorf("Unexpected error %v accepting a blocked device", err)
+	}
+	if cfg.GetDeviceConfiguration(device3) != nil {
+		t.Fatal("Blocked device added")
+	}
+	cfg.BlockedDevices = nil
+
+	if err := m.AcceptPendingDevice(device3); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GetDeviceConfiguration(device3) == nil {
+		t.Error("Accepted device not added")
+	}
+	if l := len(cfg.GetFolderConfiguration("folder1").Devices); l != 3 {
+		t.Errorf("Accepted device not sharing folder; %d devices", l)
+	}
+	if len(m.PendingDevices()) != 0 {
+		t.Error("Accepted device still pending")
+	}
+	if err := m.DeclinePendingDevice(device3); err != ErrNoSuchPendingDevice {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestIntroducedDeviceEncryptedFolder(t *testing.T) {
+	device3, _ := protocol.DeviceIDFromString("LGFPDIT-7SKNNJL-VJZA4FC-7QNCRKA-CE753K7-2BW5QDK-2FOZ7FR-FEP57QJ")
+	device4 := protocol.NewDeviceID([]byte("device4"))
+
+	for _, approve := range []bool{false, true} {
+		cfg := config.New("/tmp/test", device1)
+		cfg.Options.ApproveIntroduced = approve
+		cfg.Devices = []config.DeviceConfiguration{
+			{DeviceID: device1},
+			{DeviceID: device2, Introducer: true},
+			{DeviceID: device4},
+		}
+		cfg.Folders = []config.FolderConfiguration{
+			{
+				ID: "folder1",
+				Devices: []config.FolderDeviceConfiguration{
+					{DeviceID: device1},
+					{DeviceID: device2},
+					{DeviceID: device4, EncryptionPassword: "secret"},
+				},
+			},
+		}
+
+		db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+		m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+		m.AddFolder(cfg.Folders[0])
+
+		m.ClusterConfig(device2, protocol.ClusterConfigMessage{
+			Folders: []protocol.Folder{
+				{
+					ID: "folder1",
+					Devices: []protocol.Device{
+						{ID: device2[:], Flags: protocol.FlagIntroducer},
+						{ID: device3[:]},
+					},
+				},
+			},
+		})
+		if approve {
+			if err := m.AcceptPendingDevice(device3); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if cfg.GetDeviceConfiguration(device3) == nil {
+			t.Errorf("approve %v: introduced device not added", approve)
+		}
+		if l := len(cfg.GetFolderConfiguration("folder1").Devices); l != 3 {
+			t.Errorf("approve %v: introduced device sharing an encrypted folder; %d devices", approve, l)
+		}
+		if m.folderSharedWith("folder1", device3) {
+			t.Errorf("approve %v: folder shared with introduced device", approve)
+		}
+	}
+}
+
+func TestSuccessorEncryption(t *testing.T) {
+	device3, _ := protocol.DeviceIDFromString("LGFPDIT-7SKNNJL-VJZA4FC-7QNCRKA-CE753K7-2BW5QDK-2FOZ7FR-FEP57QJ")
+
+	cfg := config.New("/tmp/test", device1)
+	cfg.Devices = []config.DeviceConfiguration{
+		{DeviceID: device1},
+		{DeviceID: device2},
+	}
+	cfg.Folders = []config.FolderConfiguration{
+		{
+			ID: "folder1",
+			Devices: []config.FolderDeviceConfiguration{
+				{DeviceID: device1},
+				{DeviceID: device2, EncryptionPassword: "secret"},
+			},
+		},
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(cfg.Folders[0])
+	m.addSuccessor(device2, device3)
+
+	var found bool
+	for _, device := range cfg.GetFolderConfiguration("folder1").Devices {
+		if device.DeviceID == device3 {
+			found = true
+			if device.EncryptionPassword != "secret" {
+				t.Errorf("successor has encryption password %q", device.EncryptionPassword)
+			}
+		}
+	}
+	if !found {
+		t.Error("folder not shared with successor")
+	}
+	if key := m.encryptionKey("folder1", device3); key == nil || key != m.encryptionKey("folder1", device2) {
+		t.Error("successor does not have the key of its predecessor")
+	}
+}
+
+func TestRemovePredecessor(t *testing.T) {
+	cfg := config.New("/tmp/test", device1)
+	cfg.Devices = []config.DeviceConfiguration{
+		{DeviceID: device1},
+		{DeviceID: device2},
+		{DeviceID: device3, Predecessor: device2.String()},
+	}
+	cfg.Folders = []config.FolderConfiguration{
+		{
+			ID: "folder1",
+			Devices: []config.FolderDeviceConfiguration{
+				{DeviceID: device1},
+				{DeviceID: device2, EncryptionPassword: "secret"},
+				{DeviceID: device3, EncryptionPassword: "secret"},
+			},
+		},
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(cfg.Folders[0])
+	m.removePredecessor(device3)
+
+	if cfg.GetDeviceConfiguration(device2) != nil {
+		t.Error("predecessor still in config")
+	}
+	if p := cfg.GetDeviceConfiguration(device3).Predecessor; p != "" {
+		t.Errorf("successor still has predecessor %q", p)
+	}
+	if m.folderSharedWith("folder1", device2) {
+		t.Error("folder still shared with predecessor")
+	}
+	if !m.folderSharedWith("folder1", device3) {
+		t.Error("folder no longer shared with successor")
+	}
+	if folders := m.deviceFolders[device2]; len(folders) != 0 {
+		t.Errorf("predecessor still has folders %v", folders)
+	}
+	for _, device := range m.folderDevices["folder1"] {
+		if device == device2 {
+			t.Error("predecessor still among the folder devices")
+		}
+	}
+	if m.encryptionKey("folder1", device2) != nil {
+		t.Error("predecessor still has an encryption key")
+	}
+}
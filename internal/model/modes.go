@@ -0,0 +1,129 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// Each device announces the folders it has in read only (master) mode by
+// setting FlagShareReadOnly on its own entry in the cluster config. A folder
+// that is read only on both sides never syncs, and a read only peer ignores
+// the changes we make; both are reported by a FolderModeMismatch event and
+// by ModeMismatches while the device is connected. Devices that don't
+// announce their mode are taken to be read-write.
+
+const (
+	// The folder is read only here as well as on the peer, so neither side
+	// pulls changes from the other.
+	MismatchBothReadOnly = "bothReadOnly"
+
+	// The folder is read only on the peer, which will not accept our
+	// changes.
+	MismatchRemoteReadOnly = "remoteReadOnly"
+)
+
+// A ModeMismatch is a connected device whose folder mode doesn't go well
+// with ours.
+type ModeMismatch struct {
+	Device string
+	Reason string
+}
+
+// checkFolderModes records the modes the device announces for the folders we
+// share, and reports the mismatches with ours that are new.
+func (m *Model) checkFolderModes(deviceID protocol.DeviceID, cm protocol.ClusterConfigMessage) {
+	for _, folder := range cm.Folders {
+		m.fmut.RLock()
+		cfg, ok := m.folderCfgs[folder.ID]
+		m.fmut.RUnlock()
+		if !ok {
+			continue
+		}
+
+		var remoteReadOnly bool
+		for _, device := range folder.Devices {
+			if bytes.Equal(device.ID, deviceID[:]) {
+				remoteReadOnly = device.Flags&protocol.FlagShareReadOnly != 0
+				break
+			}
+		}
+
+		var reason string
+		switch {
+		case remoteReadOnly && cfg.ReadOnly:
+			reason = MismatchBothReadOnly
+		case remoteReadOnly:
+			reason = MismatchRemoteReadOnly
+		}
+		m.setModeMismatch(folder.ID, deviceID, reason)
+	}
+}
+
+func (m *Model) setModeMismatch(folder string, deviceID protocol.DeviceID, reason string) {
+	id := deviceID.String()
+
+	m.smut.Lock()
+	old := m.modeMismatches[folder][id]
+	if reason == "" {
+		delete(m.modeMismatches[folder], id)
+	} else {
+		if m.modeMismatches[folder] == nil {
+			m.modeMismatches[folder] = make(map[string]string)
+		}
+		m.modeMismatches[folder][id] = reason
+	}
+	m.smut.Unlock()
+
+	if reason == old || reason == "" {
+		return
+	}
+
+	switch reason {
+	case MismatchBothReadOnly:
+		m.log.Warnf("Folder %q is read only both here and on device %v; neither will pull changes from the other", folder, deviceID)
+	case MismatchRemoteReadOnly:
+		m.log.Infof("Folder %q is read only on device %v; changes made here will not be accepted there", folder, deviceID)
+	}
+	events.Default.Log(events.FolderModeMismatch, map[string]string{
+		"folder": folder,
+		"device": id,
+		"reason": reason,
+	})
+}
+
+// clearFolderModes forgets the folder modes of a device that disconnected.
+func (m *Model) clearFolderModes(deviceID protocol.DeviceID) {
+	id := deviceID.String()
+	m.smut.Lock()
+	for _, devices := range m.modeMismatches {
+		delete(devices, id)
+	}
+	m.smut.Unlock()
+}
+
+// ModeMismatches returns the connected devices whose mode for the folder
+// doesn't go well with ours, sorted by device ID.
+func (m *Model) ModeMismatches(folder string) []ModeMismatch {
+	m.smut.RLock()
+	defer m.smut.RUnlock()
+
+	res := []ModeMismatch{}
+	for id, reason := range m.modeMismatches[folder] {
+		res = append(res, ModeMismatch{Device: id, Reason: reason})
+	}
+	sort.Sort(modeMismatchList(res))
+	return res
+}
+
+type modeMismatchList []ModeMismatch
+
+func (l modeMismatchList) Len() int           { return len(l) }
+func (l modeMismatchList) Swap(a, b int)      { l[a], l[b] = l[b], l[a] }
+func (l modeMismatchList) Less(a, b int) bool { return l[a].Device < l[b].Device }
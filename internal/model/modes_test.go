@@ -0,0 +1,88 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestFolderModes(t *testing.T) {
+	cfg := config.New("/tmp/test", device1)
+	cfg.Devices = []config.DeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}}
+	cfg.Folders = []config.FolderConfiguration{
+		{
+			ID:       "ro",
+			ReadOnly: true,
+			Devices:  []config.FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}},
+		},
+		{
+			ID:      "rw",
+			Devices: []config.FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}},
+		},
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+	m.SetDeviceID(device1)
+	m.AddFolder(cfg.Folders[0])
+	m.AddFolder(cfg.Folders[1])
+
+	// We announce our read only folder as such
+	for _, folder := range m.clusterConfig(device2).Folders {
+		for _, device := range folder.Devices {
+			readOnly := device.Flags&protocol.FlagShareReadOnly != 0
+			if readOnly != (folder.ID == "ro" && string(device.ID) == string(device1[:])) {
+				t.Errorf("Unexpected flags %x for %x in %q", device.Flags, device.ID, folder.ID)
+			}
+		}
+	}
+
+	sub := events.Default.Subscribe(events.FolderModeMismatch)
+	defer events.Default.Unsubscribe(sub)
+
+	// The peer has both folders read only
+	cm := protocol.ClusterConfigMessage{}
+	for _, folder := range []string{"ro", "rw"} {
+		cm.Folders = append(cm.Folders, protocol.Folder{
+			ID: folder,
+			Devices: []protocol.Device{
+				{ID: device1[:], Flags: protocol.FlagShareTrusted},
+				{ID: device2[:], Flags: protocol.FlagShareTrusted | protocol.FlagShareReadOnly},
+			},
+		})
+	}
+	m.ClusterConfig(device2, cm)
+
+	if mm := m.ModeMismatches("ro"); len(mm) != 1 || mm[0].Device != device2.String() || mm[0].Reason != MismatchBothReadOnly {
+		t.Errorf("Unexpected mismatches %v", mm)
+	}
+	if mm := m.ModeMismatches("rw"); len(mm) != 1 || mm[0].Reason != MismatchRemoteReadOnly {
+		t.Errorf("Unexpected mismatches %v", mm)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := sub.Poll(time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The same announcement again is nothing new
+	m.ClusterConfig(device2, cm)
+	if _, err := sub.Poll(10 * time.Millisecond); err != events.ErrTimeout {
+		t.Error("Unexpected repeated event")
+	}
+
+	m.Close(device2, errors.New("test"))
+	if mm := m.ModeMismatches("ro"); len(mm) != 0 {
+		t.Errorf("Mismatches remain after disconnect: %v", mm)
+	}
+}
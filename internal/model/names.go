@@ -0,0 +1,49 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import "github.com/syncthing/syncthing/lib/protocol"
+
+// Besides its own name, a device announces the names it knows the devices
+// it shares folders with by, so that devices added by an introducer don't
+// show up as a bare device ID. Names are only ever filled in where there is
+// none, and are taken from introducers only.
+
+// deviceNameOptions returns at most max options announcing the names of the
+// devices sharing folders with the given device. Must be called with fmut
+// held.
+func (m *Model) deviceNameOptions(device protocol.DeviceID, max int) []protocol.Option {
+	var options []protocol.Option
+	seen := map[protocol.DeviceID]bool{device: true}
+	for _, folder := range m.deviceFolders[device] {
+		for _, id := range m.folderDevices[folder] {
+			if seen[id] || len(options) >= max {
+				continue
+			}
+			seen[id] = true
+			if deviceCfg := m.cfg.GetDeviceConfiguration(id); deviceCfg != nil && deviceCfg.Name != "" {
+				options = append(options, protocol.DeviceNameOption(id, deviceCfg.Name))
+			}
+		}
+	}
+	return options
+}
+
+// applyDeviceNames names the unnamed devices after the names announced by
+// the introducer, and returns whether the configuration was changed.
+func (m *Model) applyDeviceNames(introducer protocol.DeviceID, cm protocol.ClusterConfigMessage) bool {
+	var changed bool
+	for id, name := range cm.DeviceNames() {
+		if id == introducer {
+			continue
+		}
+		if device := m.cfg.GetDeviceConfiguration(id); device != nil && device.Name == "" {
+			m.log.Infof("Device %s name is %q (vouched for by introducer %v)", id, name, introducer)
+			device.Name = name
+			changed = true
+		}
+	}
+	return changed
+}
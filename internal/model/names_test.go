@@ -0,0 +1,66 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestDeviceNamePropagation(t *testing.T) {
+	device3, _ := protocol.DeviceIDFromString("LGFPDIT-7SKNNJL-VJZA4FC-7QNCRKA-CE753K7-2BW5QDK-2FOZ7FR-FEP57QJ")
+
+	cfg := config.New("/tmp/test", device1)
+	cfg.Devices = []config.DeviceConfiguration{
+		{DeviceID: device1, Name: "me"},
+		{DeviceID: device2, Introducer: true},
+		{DeviceID: device3, Name: "laptop"},
+	}
+	cfg.Folders = []config.FolderConfiguration{
+		{
+			ID: "folder1",
+			Devices: []config.FolderDeviceConfiguration{
+				{DeviceID: device1},
+				{DeviceID: device2},
+				{DeviceID: device3},
+			},
+		},
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(cfg.Folders[0])
+
+	// We tell device2 the names of the other devices, but not its own
+	cm := m.clusterConfig(device2)
+	names := cm.DeviceNames()
+	if len(names) != 2 || names[device1] != "me" || names[device3] != "laptop" {
+		t.Errorf("Unexpected announced names %v", names)
+	}
+
+	// Names from the introducer only fill in where there is none
+	cfg.GetDeviceConfiguration(device3).Name = ""
+	m.ClusterConfig(device2, protocol.ClusterConfigMessage{
+		Options: []protocol.Option{
+			{Key: protocol.OptionName, Value: "introducer"},
+			protocol.DeviceNameOption(device1, "someone else"),
+			protocol.DeviceNameOption(device2, "not me"),
+			protocol.DeviceNameOption(device3, "their laptop"),
+		},
+	})
+	if name := cfg.GetDeviceConfiguration(device1).Name; name != "me" {
+		t.Errorf("Named device renamed to %q", name)
+	}
+	if name := cfg.GetDeviceConfiguration(device2).Name; name != "introducer" {
+		t.Errorf("Unexpected introducer name %q", name)
+	}
+	if name := cfg.GetDeviceConfiguration(device3).Name; name != "their laptop" {
+		t.Errorf("Unexpected introduced name %q", name)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"sort"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/files"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// A NeededFile is a file the folder needs, as listed by NeedFolderFiles.
+type NeededFile struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+	Deleted  bool
+	Reason   string `json:",omitempty"` // why the last attempt failed
+}
+
+func neededFile(f protocol.FileInfo) NeededFile {
+	nf := NeededFile{
+		Name:     f.Name,
+		Modified: f.ModTime(),
+		Deleted:  f.IsDeleted(),
+	}
+	if !f.IsDeleted() && !protocol.IsDirectory(f.Flags) {
+		nf.Size = f.Size()
+	}
+	return nf
+}
+
+// NeedFolderFiles returns the files the folder needs, split into those being
+// pulled right now, those that failed the last time they were tried, and
+// the rest, which are queued. The queued files are returned a page at a
+// time, in the order the puller handles them, and more is whether there are
+// further pages. Pages are numbered from one.
+func (m *Model) NeedFolderFiles(folder string, page, perpage int) (progress, queued, failed []NeededFile, more bool) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	ignores := m.folderIgnores[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return
+	}
+
+	m.smut.RLock()
+	pulling := make(map[string]bool, len(m.pulling[folder]))
+	for name := range m.pulling[folder] {
+		pulling[name] = true
+	}
+	failures := make(map[string]string, len(m.failures[folder]))
+	for name, reason := range m.failures[folder] {
+		failures[name] = reason
+	}
+	m.smut.RUnlock()
+
+	progress = []NeededFile{}
+	for name := range pulling {
+		if f, ok := neededGlobal(fs, name); ok {
+			progress = append(progress, neededFile(f))
+		}
+	}
+	failed = []NeededFile{}
+	for name, reason := range failures {
+		if pulling[name] {
+			continue
+		}
+		if f, ok := neededGlobal(fs, name); ok {
+			nf := neededFile(f)
+			nf.Reason = reason
+			failed = append(failed, nf)
+		}
+	}
+	sort.Sort(neededFileList(progress))
+	sort.Sort(neededFileList(failed))
+
+	// Page through the truncated index, which is cheap, and look up the
+	// full entries only for the files on the page
+	skip := (page - 1) * perpage
+	names := make([]string, 0, perpage)
+	fs.WithNeedTruncated(protocol.LocalDeviceID, func(f protocol.FileIntf) bool {
+		name := f.(protocol.FileInfoTruncated).Name
+		if _, ok := failures[name]; ok || pulling[name] || ignores.Match(name) {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		if len(names) == perpage {
+			more = true
+			return false
+		}
+		names = append(names, name)
+		return true
+	})
+
+	queued = make([]NeededFile, 0, len(names))
+	for _, name := range names {
+		if f, ok := neededGlobal(fs, name); ok {
+			queued = append(queued, neededFile(f))
+		}
+	}
+	return
+}
+
+// neededGlobal returns the global version of the file, if the local device
+// needs it.
+func neededGlobal(fs *files.Set, name string) (protocol.FileInfo, bool) {
+	gf := fs.GetGlobal(name)
+	if gf.Name == "" || gf.IsInvalid() {
+		return gf, false
+	}
+	lf := fs.Get(protocol.LocalDeviceID, name)
+	if lf.Name == "" && gf.IsDeleted() || lf.Version >= gf.Version {
+		return gf, false
+	}
+	return gf, true
+}
+
+type neededFileList []NeededFile
+
+func (l neededFileList) Len() int           { return len(l) }
+func (l neededFileList) Swap(a, b int)      { l[a], l[b] = l[b], l[a] }
+func (l neededFileList) Less(a, b int) bool { return l[a].Name < l[b].Name }
+
+func (m *Model) pullStarted(folder, name string) {
+	m.smut.Lock()
+	if m.pulling[folder] == nil {
+		m.pulling[folder] = make(map[string]bool)
+	}
+	m.pulling[folder][name] = true
+	m.smut.Unlock()
+}
+
+func (m *Model) pullDone(folder, name string) {
+	m.smut.Lock()
+	delete(m.pulling[folder], name)
+	m.smut.Unlock()
+}
+
+func (m *Model) recordFailure(folder, name, reason string) {
+	m.smut.Lock()
+	if m.failures[folder] == nil {
+		m.failures[folder] = make(map[string]string)
+	}
+	m.failures[folder][name] = reason
+	m.smut.Unlock()
+}
+
+func (m *Model) clearFailure(folder, name string) {
+	m.smut.Lock()
+	delete(m.failures[folder], name)
+	m.smut.Unlock()
+}
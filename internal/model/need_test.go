@@ -0,0 +1,64 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func neededNames(fs []NeededFile) []string {
+	var names []string
+	for _, f := range fs {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestNeedFolderFiles(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: "testdata"})
+
+	files := genFiles(5)
+	for i := range files {
+		files[i].Version = 1
+	}
+	m.folderFiles["default"].Update(device1, files)
+
+	m.pullStarted("default", "file1")
+	m.itemError("default", "file3", "pull", errors.New("no connected device has the required version of this file"))
+
+	progress, queued, failed, more := m.NeedFolderFiles("default", 1, 2)
+	if len(progress) != 1 || progress[0].Name != "file1" || progress[0].Size != 100 {
+		t.Errorf("unexpected files in progress %v", progress)
+	}
+	if len(failed) != 1 || failed[0].Name != "file3" || failed[0].Reason != "pull: no connected device has the required version of this file" {
+		t.Errorf("unexpected failed files %v", failed)
+	}
+	if names := neededNames(queued); len(names) != 2 || names[0] != "file0" || names[1] != "file2" || !more {
+		t.Errorf("unexpected first page %v, more %v", names, more)
+	}
+
+	_, queued, _, more = m.NeedFolderFiles("default", 2, 2)
+	if names := neededNames(queued); len(names) != 1 || names[0] != "file4" || more {
+		t.Errorf("unexpected second page %v, more %v", names, more)
+	}
+
+	// Files that are done are no longer listed
+	m.pullDone("default", "file1")
+	m.updateLocal("default", files[3])
+	progress, queued, failed, _ = m.NeedFolderFiles("default", 1, 10)
+	if len(progress) != 0 || len(failed) != 0 {
+		t.Errorf("unexpected files in progress %v or failed %v", progress, failed)
+	}
+	if names := neededNames(queued); len(names) != 4 || names[1] != "file1" {
+		t.Errorf("unexpected queued files %v", names)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// An ownerMapper decides the local owner of files pulled from other devices.
+// A remote user or group is mapped by an explicit mapping of its numeric ID
+// or name if there is one, otherwise to the local one with the same name when
+// mapping by name, and otherwise to the same numeric ID.
+type ownerMapper struct {
+	byName bool
+	users  map[string]int // remote uid or user name -> local uid
+	groups map[string]int // remote gid or group name -> local gid
+}
+
+func newOwnerMapper(cfg config.FolderConfiguration) (*ownerMapper, error) {
+	m := &ownerMapper{
+		byName: cfg.OwnershipByName,
+		users:  make(map[string]int),
+		groups: make(map[string]int),
+	}
+
+	for _, om := range cfg.OwnershipMap {
+		switch om.Kind {
+		case "user":
+			uid, err := lookupUser(om.Local)
+			if err != nil {
+				return nil, err
+			}
+			m.users[om.Remote] = uid
+		case "group":
+			gid, err := lookupGroup(om.Local)
+			if err != nil {
+				return nil, err
+			}
+			m.groups[om.Remote] = gid
+		default:
+			return nil, fmt.Errorf("unknown ownership mapping kind %q", om.Kind)
+		}
+	}
+
+	return m, nil
+}
+
+// owner returns the local user and group that should own the file, or -1
+// for those that should be left alone. When mapping by name, a user or
+// group without a local counterpart is an error rather than being mapped
+// to the same numeric ID, which belongs to someone else entirely.
+func (m *ownerMapper) owner(f protocol.FileInfo) (uid, gid int, err error) {
+	uid, err = m.resolve(f, "user", scanner.AttrOwnerUID, scanner.AttrOwnerUser, m.users, lookupUser)
+	if err != nil {
+		return -1, -1, err
+	}
+	gid, err = m.resolve(f, "group", scanner.AttrOwnerGID, scanner.AttrOwnerGroup, m.groups, lookupGroup)
+	if err != nil {
+		return -1, -1, err
+	}
+	return uid, gid, nil
+}
+
+func (m *ownerMapper) resolve(f protocol.FileInfo, kind, idKey, nameKey string, mapping map[string]int, lookup func(string) (int, error)) (int, error) {
+	remoteID, ok := f.GetAttribute(idKey)
+	if !ok {
+		// The other device doesn't sync ownership
+		return -1, nil
+	}
+	name, hasName := f.GetAttribute(nameKey)
+
+	if id, ok := mapping[remoteID]; ok {
+		return id, nil
+	}
+	if hasName {
+		if id, ok := mapping[name]; ok {
+			return id, nil
+		}
+	}
+	if m.byName {
+		if !hasName {
+			return -1, fmt.Errorf("remote %s %s has no name to map", kind, remoteID)
+		}
+		id, err := lookup(name)
+		if err != nil {
+			return -1, fmt.Errorf("remote %s %q: %v", kind, name, err)
+		}
+		return id, nil
+	}
+	if id, err := strconv.Atoi(remoteID); err == nil {
+		return id, nil
+	}
+	return -1, nil
+}
+
+// lookupUser returns the uid of the user given by name or numeric ID.
+func lookupUser(s string) (int, error) {
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	u, err := user.Lookup(s)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGroup returns the gid of the group given by name or numeric ID.
+func lookupGroup(s string) (int, error) {
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	g, err := user.LookupGroup(s)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(g.Gid)
+}
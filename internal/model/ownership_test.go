@@ -0,0 +1,114 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func ownedFile(attrs ...string) protocol.FileInfo {
+	var f protocol.FileInfo
+	for i := 0; i < len(attrs); i += 2 {
+		f.SetAttribute(attrs[i], attrs[i+1])
+	}
+	return f
+}
+
+func TestOwnerMapper(t *testing.T) {
+	m, err := newOwnerMapper(config.FolderConfiguration{
+		OwnershipByName: true,
+		OwnershipMap: []config.OwnershipMapping{
+			{Kind: "user", Remote: "1001", Local: "2001"},
+			{Kind: "user", Remote: "alice", Local: "2002"},
+			{Kind: "group", Remote: "100", Local: "0"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// There is no root user on Windows
+	rootUID, err := lookupUser("root")
+	noRoot := err != nil
+
+	cases := []struct {
+		f        protocol.FileInfo
+		uid, gid int
+		err      bool
+	}{
+		// Not syncing ownership
+		{ownedFile(), -1, -1, false},
+		// Explicitly mapped by ID
+		{ownedFile(scanner.AttrOwnerUID, "1001", scanner.AttrOwnerGID, "100"), 2001, 0, false},
+		// Explicitly mapped by name
+		{ownedFile(scanner.AttrOwnerUID, "1005", scanner.AttrOwnerUser, "alice", scanner.AttrOwnerGID, "100"), 2002, 0, false},
+		// Mapped by name to the local user
+		{ownedFile(scanner.AttrOwnerUID, "1006", scanner.AttrOwnerUser, "root", scanner.AttrOwnerGID, "100"), rootUID, 0, noRoot},
+		// Unknown name, which must not fall back to the ID
+		{ownedFile(scanner.AttrOwnerUID, "1007", scanner.AttrOwnerUser, "nonexistent-user-xyz", scanner.AttrOwnerGID, "100"), -1, -1, true},
+		// No name at all
+		{ownedFile(scanner.AttrOwnerUID, "1001", scanner.AttrOwnerGID, "7"), -1, -1, true},
+	}
+
+	for i, tc := range cases {
+		uid, gid, err := m.owner(tc.f)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%d: unexpected nil error, owner %d:%d", i, uid, gid)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: unexpected error %v", i, err)
+		} else if uid != tc.uid || gid != tc.gid {
+			t.Errorf("%d: incorrect owner %d:%d != %d:%d", i, uid, gid, tc.uid, tc.gid)
+		}
+	}
+}
+
+func TestOwnerMapperByID(t *testing.T) {
+	m, err := newOwnerMapper(config.FolderConfiguration{
+		OwnershipMap: []config.OwnershipMapping{
+			{Kind: "user", Remote: "alice", Local: "2002"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		f        protocol.FileInfo
+		uid, gid int
+	}{
+		// Explicitly mapped by name
+		{ownedFile(scanner.AttrOwnerUID, "1005", scanner.AttrOwnerUser, "alice", scanner.AttrOwnerGID, "5"), 2002, 5},
+		// Unknown name, the same ID
+		{ownedFile(scanner.AttrOwnerUID, "1007", scanner.AttrOwnerUser, "nonexistent-user-xyz", scanner.AttrOwnerGID, "7"), 1007, 7},
+	}
+
+	for i, tc := range cases {
+		uid, gid, err := m.owner(tc.f)
+		if err != nil {
+			t.Errorf("%d: unexpected error %v", i, err)
+		} else if uid != tc.uid || gid != tc.gid {
+			t.Errorf("%d: incorrect owner %d:%d != %d:%d", i, uid, gid, tc.uid, tc.gid)
+		}
+	}
+}
+
+func TestOwnerMapperInvalid(t *testing.T) {
+	_, err := newOwnerMapper(config.FolderConfiguration{
+		OwnershipMap: []config.OwnershipMapping{
+			{Kind: "something", Remote: "1", Local: "2"},
+		},
+	})
+	if err == nil {
+		t.Error("Unexpected nil error for unknown mapping kind")
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"path/filepath"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// pathHintOptions returns at most max options suggesting paths for the
+// folders shared with the device that have one configured. Must be called
+// with fmut held.
+func (m *Model) pathHintOptions(device protocol.DeviceID, max int) []protocol.Option {
+	var options []protocol.Option
+	for _, folder := range m.deviceFolders[device] {
+		hint := m.folderCfgs[folder].SuggestedPath
+		if hint == "" || len(options) >= max {
+			continue
+		}
+		if opt, ok := protocol.PathHintOption(folder, filepath.ToSlash(hint)); ok {
+			options = append(options, opt)
+		} else {
+			m.log.Infof("Not suggesting the path for folder %q; the folder ID or path is too long", folder)
+		}
+	}
+	return options
+}
+
+// SuggestedPath returns the relative path the connected device suggests for
+// the folder, or an empty string if it doesn't suggest any. The path is
+// slash separated and doesn't lead outside the directory it's relative to.
+func (m *Model) SuggestedPath(device protocol.DeviceID, folder string) string {
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+	return m.pathHints[device][folder]
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestPathHints(t *testing.T) {
+	cfg := config.New("/tmp/test", device1)
+	cfg.Devices = []config.DeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}}
+	cfg.Folders = []config.FolderConfiguration{
+		{
+			ID:            "photos",
+			SuggestedPath: "Shared/Photos",
+			Devices:       []config.FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}},
+		},
+		{
+			ID:      "docs",
+			Devices: []config.FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}},
+		},
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(cfg.Folders[0])
+	m.AddFolder(cfg.Folders[1])
+
+	// We suggest the configured path to the peer
+	cm := m.clusterConfig(device2)
+	if hints := cm.PathHints(); len(hints) != 1 || hints["photos"] != "Shared/Photos" {
+		t.Errorf("Unexpected announced hints %v", hints)
+	}
+
+	// The peer suggests a path for a folder we don't have
+	opt, _ := protocol.PathHintOption("music", "Shared/Music")
+	m.ClusterConfig(device2, protocol.ClusterConfigMessage{
+		Options: []protocol.Option{opt},
+	})
+	if hint := m.SuggestedPath(device2, "music"); hint != "Shared/Music" {
+		t.Errorf("Unexpected suggested path %q", hint)
+	}
+
+	m.Close(device2, errors.New("test"))
+	if hint := m.SuggestedPath(device2, "music"); hint != "" {
+		t.Errorf("Suggested path remains after disconnect: %q", hint)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+)
+
+// A paused folder is neither scanned nor pulled. Its index is kept, and is
+// still exchanged with the devices it is shared with, so that it picks up
+// where it left off when resumed.
+
+var errFolderPaused = errors.New("folder is paused")
+
+// PauseFolder stops scanning and pulling the folder. It returns once the
+// scan or pull in progress, if any, has finished.
+func (m *Model) PauseFolder(folder string) error {
+	m.fmut.Lock()
+	if _, ok := m.folderCfgs[folder]; !ok {
+		m.fmut.Unlock()
+		return errors.New("no such folder")
+	}
+	runner := m.folderRunners[folder]
+	delete(m.folderRunners, folder)
+	m.fmut.Unlock()
+
+	if runner != nil {
+		runner.Stop()
+		<-runner.Stopped()
+	}
+
+	m.smut.Lock()
+	m.pausedFolders[folder] = true
+	m.smut.Unlock()
+	m.setState(folder, FolderPaused)
+	return nil
+}
+
+// ResumeFolder starts scanning and pulling the paused folder again, as read
+// only or read/write according to its configuration.
+func (m *Model) ResumeFolder(folder string) error {
+	m.fmut.RLock()
+	cfg, ok := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return errors.New("no such folder")
+	}
+
+	m.smut.Lock()
+	paused := m.pausedFolders[folder]
+	delete(m.pausedFolders, folder)
+	m.smut.Unlock()
+	if !paused {
+		return nil
+	}
+	m.setState(folder, FolderIdle)
+
+	if cfg.ReadOnly {
+		m.StartFolderRO(folder)
+	} else {
+		m.StartFolderRW(folder)
+	}
+	return nil
+}
+
+// folderPaused returns whether the folder is paused.
+func (m *Model) folderPaused(folder string) bool {
+	m.smut.RLock()
+	defer m.smut.RUnlock()
+	return m.pausedFolders[folder]
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestPauseFolder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: dir, ReadOnly: true, RescanIntervalS: 60})
+	m.StartFolderRO("default")
+	defer m.Stop()
+
+	if err := m.PauseFolder("default"); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := m.State("default"); state != "paused" {
+		t.Errorf("unexpected state %q after pausing", state)
+	}
+	if _, ok := m.folderRunners["default"]; ok {
+		t.Error("runner left after pausing")
+	}
+	if err := m.ScanFolder("default"); err != errFolderPaused {
+		t.Errorf("unexpected error scanning paused folder: %v", err)
+	}
+
+	// The scanner stays paused until resumed
+	m.setState("default", FolderIdle)
+	if state, _ := m.State("default"); state != "paused" {
+		t.Errorf("unexpected state %q", state)
+	}
+
+	if err := m.ResumeFolder("default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.folderRunners["default"]; !ok {
+		t.Error("no runner after resuming")
+	}
+	if err := m.ScanFolder("default"); err != nil {
+		t.Errorf("unexpected error scanning resumed folder: %v", err)
+	}
+
+	if err := m.PauseFolder("nonexistent"); err == nil {
+		t.Error("unexpected nil error pausing nonexistent folder")
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+var (
+	ErrNoSuchPendingDevice = errors.New("no such pending device")
+	ErrDeviceBlocked       = errors.New("device is blocked")
+)
+
+// addPendingDevice records that the introducer announced the device as
+// sharing the folder. It returns true if the configuration was changed.
+func (m *Model) addPendingDevice(id, introducer protocol.DeviceID, folder string, isIntroducer bool) bool {
+	pending := m.cfg.GetPendingDevice(id)
+	if pending == nil {
+		m.log.Infof("Device %v announced by introducer %v awaits approval", id, introducer)
+		m.cfg.PendingDevices = append(m.cfg.PendingDevices, config.PendingDeviceConfiguration{
+			DeviceID:     id,
+			IntroducedBy: introducer,
+			Introducer:   isIntroducer,
+			Folders:      []string{folder},
+			Time:         time.Now(),
+		})
+		events.Default.Log(events.DevicePending, map[string]string{
+			"device":       id.String(),
+			"introducedBy": introducer.String(),
+			"folder":       folder,
+		})
+		return true
+	}
+
+	for _, f := range pending.Folders {
+		if f == folder {
+			return false
+		}
+	}
+	pending.Folders = append(pending.Folders, folder)
+	return true
+}
+
+// PendingDevices returns the devices announced by introducers that await
+// approval and have not been declined.
+func (m *Model) PendingDevices() []config.PendingDeviceConfiguration {
+	pending := []config.PendingDeviceConfiguration{}
+	for _, device := range m.cfg.PendingDevices {
+		if !device.Declined {
+			pending = append(pending, device)
+		}
+	}
+	return pending
+}
+
+// AcceptPendingDevice adds the pending device to the configuration and
+// shares the folders it was announced with.
+func (m *Model) AcceptPendingDevice(id protocol.DeviceID) error {
+	pending := m.cfg.GetPendingDevice(id)
+	if pending == nil {
+		return ErrNoSuchPendingDevice
+	}
+	if m.cfg.IsBlocked(id) {
+		return ErrDeviceBlocked
+	}
+
+	m.log.Infof("Adding device %v to config (vouched for by introducer %v, approved)", id, pending.IntroducedBy)
+	if m.cfg.GetDeviceConfiguration(id) == nil {
+		m.cfg.Devices = append(m.cfg.Devices, config.DeviceConfiguration{
+			DeviceID:     id,
+			Introducer:   pending.Introducer,
+			IntroducedBy: pending.IntroducedBy.String(),
+		})
+	}
+
+	m.fmut.Lock()
+	for _, folder := range pending.Folders {
+		folderCfg := m.cfg.GetFolderConfiguration(folder)
+		if folderCfg == nil || m.sharedWith(folder, id) {
+			continue
+		}
+		if m.sharedEncrypted(folder) {
+			m.log.Warnf("Not sharing folder %q with device %v, as the folder is encrypted for some devices; share it manually, with an encryption password if the device is not to be trusted", folder, id)
+			continue
+		}
+
+		m.deviceFolders[id] = append(m.deviceFolders[id], folder)
+		m.folderDevices[folder] = append(m.folderDevices[folder], id)
+		folderCfg.Devices = append(folderCfg.Devices, config.FolderDeviceConfiguration{
+			DeviceID:     id,
+			IntroducedBy: pending.IntroducedBy.String(),
+		})
+	}
+	m.fmut.Unlock()
+
+	m.removePendingDevice(id)
+	return m.cfg.Save()
+}
+
+// DeclinePendingDevice rejects the pending device. It will not be suggested
+// again by introducers.
+func (m *Model) DeclinePendingDevice(id protocol.DeviceID) error {
+	pending := m.cfg.GetPendingDevice(id)
+	if pending == nil {
+		return ErrNoSuchPendingDevice
+	}
+	m.log.Infof("Declined device %v announced by introducer %v", id, pending.IntroducedBy)
+	pending.Declined = true
+	return m.cfg.Save()
+}
+
+func (m *Model) removePendingDevice(id protocol.DeviceID) {
+	for i := range m.cfg.PendingDevices {
+		if m.cfg.PendingDevices[i].DeviceID == id {
+			m.cfg.PendingDevices = append(m.cfg.PendingDevices[:i], m.cfg.PendingDevices[i+1:]...)
+			return
+		}
+	}
+}
+
+// sharedWith returns true if the folder is shared with the device. Must be
+// called with fmut held.
+func (m *Model) sharedWith(folder string, id protocol.DeviceID) bool {
+	for _, f := range m.deviceFolders[id] {
+		if f == folder {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// In folders with placeholders enabled, new files are created empty, with
+// the modification time and permissions of the real file, and recorded in
+// the index as up to date. Their contents are fetched by FetchPlaceholder.
+// Files that have been fetched are pulled as usual when they change. As an
+// empty file can't be told from a placeholder, emptying a file in such a
+// folder is not synced.
+
+var errChangedWhileFetching = errors.New("file changed while fetching")
+
+// isPlaceholder returns whether the file at path is a placeholder for f,
+// that is an empty regular file where f has contents.
+func isPlaceholder(path string, f protocol.FileInfo) bool {
+	if f.Size() == 0 || f.IsDeleted() || protocol.IsDirectory(f.Flags) {
+		return false
+	}
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode().IsRegular() && info.Size() == 0
+}
+
+// wantsPlaceholder returns whether the file should get a placeholder rather
+// than being pulled, that is when we don't have the contents of any version
+// of it.
+func (p *Puller) wantsPlaceholder(file, curFile protocol.FileInfo) bool {
+	if !p.placeholders || file.Size() == 0 || file.IsSymlink() {
+		return false
+	}
+	if curFile.Name == "" || curFile.IsDeleted() {
+		return true
+	}
+	return isPlaceholder(filepath.Join(p.dir, curFile.Name), curFile)
+}
+
+// placeholderFile creates or replaces the placeholder for the file.
+func (p *Puller) placeholderFile(file protocol.FileInfo) {
+	tempName := defTempNamer.TempName(file.Name)
+	fd, err := p.storage.CreateTemp(tempName)
+	if err != nil {
+		p.failed(file.Name, "placeholder", err)
+		return
+	}
+	fd.Close()
+
+	// Set the same metadata as the finisher would, so that the placeholder
+	// isn't seen as changed by the next scan
+	path := filepath.Join(p.dir, tempName)
+	file, err = p.applyOwnership(path, file)
+	if err == nil {
+		err = os.Chmod(path, os.FileMode(file.Flags&0777))
+	}
+	if err == nil {
+		err = p.applyACLs(path, file)
+	}
+	if err == nil {
+		t := file.ModTime()
+		err = os.Chtimes(path, t, t)
+	}
+	if err == nil {
+		err = p.storage.Rename(tempName, file.Name)
+	}
+	if err != nil {
+		p.storage.Remove(tempName)
+		p.failed(file.Name, "placeholder", err)
+		return
+	}
+
+	p.model.updateLocal(p.folder, file)
+}
+
+// FetchPlaceholder replaces the placeholder for the named file with the
+// contents of the file, requested from the devices that have it. It is not
+// an error if the contents are already there.
+func (m *Model) FetchPlaceholder(folder, name string) error {
+	m.fmut.RLock()
+	cfg, ok := m.folderCfgs[folder]
+	st := m.folderStorage[folder]
+	p, _ := m.folderRunners[folder].(*Puller)
+	m.fmut.RUnlock()
+	if !ok {
+		return errors.New("no such folder")
+	}
+	if m.folderPaused(folder) {
+		return errFolderPaused
+	}
+
+	f := m.CurrentFolderFile(folder, name)
+	if f.Name == "" || f.IsDeleted() {
+		return ErrNoSuchFile
+	}
+	if f.IsInvalid() {
+		return ErrInvalid
+	}
+	if !isPlaceholder(filepath.Join(cfg.Path, name), f) {
+		return nil
+	}
+
+	tempName := defTempNamer.TempName(name)
+	fd, err := st.CreateTemp(tempName)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	for _, block := range f.Blocks {
+		block.Offset = offset
+		offset += int64(block.Size)
+
+		buf, err := m.RequestBlock(folder, name, block)
+		if err == nil {
+			_, err = fd.WriteAt(buf, block.Offset)
+			protocol.BufferPool.Put(buf)
+		}
+		if err != nil {
+			fd.Close()
+			st.Remove(tempName)
+			return err
+		}
+	}
+	if err := fd.Close(); err != nil {
+		st.Remove(tempName)
+		return err
+	}
+
+	path := filepath.Join(cfg.Path, tempName)
+	if p != nil {
+		_, err = p.applyOwnership(path, f)
+	}
+	if err == nil {
+		err = os.Chmod(path, os.FileMode(f.Flags&0777))
+	}
+	if err == nil && p != nil {
+		err = p.applyACLs(path, f)
+	}
+	if err == nil {
+		t := f.ModTime()
+		err = os.Chtimes(path, t, t)
+	}
+	if err == nil && m.CurrentFolderFile(folder, name).Version != f.Version {
+		// The puller got there first with a newer version
+		err = errChangedWhileFetching
+	}
+	if err == nil {
+		err = st.Rename(tempName, name)
+	}
+	if err != nil {
+		st.Remove(tempName)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestPlaceholders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "placeholders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: dir, Placeholders: true})
+
+	p := &Puller{
+		folder:       "default",
+		dir:          dir,
+		placeholders: true,
+		model:        m,
+		storage:      m.folderStorage["default"],
+		cases:        osutil.NewCaseChecker(),
+	}
+
+	file := protocol.FileInfo{
+		Name:     "file",
+		Flags:    0640,
+		Modified: 1234567890,
+		Version:  1,
+		Blocks:   []protocol.BlockInfo{{Size: 6, Hash: []byte("hash")}},
+	}
+	p.handleFile(file, nil, nil)
+
+	info, err := os.Stat(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 || info.Mode().Perm() != 0640 || info.ModTime().Unix() != file.Modified {
+		t.Errorf("unexpected placeholder size %d, mode %o, modified %d", info.Size(), info.Mode().Perm(), info.ModTime().Unix())
+	}
+	if cf := m.CurrentFolderFile("default", "file"); cf.Version != file.Version {
+		t.Errorf("placeholder not in the index, version %d", cf.Version)
+	}
+
+	// A new version gets a new placeholder
+	file.Version = 2
+	file.Modified++
+	p.handleFile(file, nil, nil)
+	if cf := m.CurrentFolderFile("default", "file"); cf.Version != file.Version {
+		t.Errorf("placeholder not updated, version %d", cf.Version)
+	}
+
+	// The placeholder is not served to other devices
+	if _, err := m.Request(device1, "default", "file", 0, 6); err != ErrNoSuchFile {
+		t.Errorf("unexpected error requesting placeholder: %v", err)
+	}
+
+	// Touching the placeholder doesn't announce it as an empty file
+	t0 := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "file"), t0, t0)
+	if err := m.ScanFolder("default"); err != nil {
+		t.Fatal(err)
+	}
+	if cf := m.CurrentFolderFile("default", "file"); cf.Version != file.Version {
+		t.Errorf("placeholder rescanned, version %d", cf.Version)
+	}
+
+	// Fetching fails without any device to fetch from, leaving the
+	// placeholder in place
+	if err := m.FetchPlaceholder("default", "file"); err != errNoDevice {
+		t.Errorf("unexpected error fetching: %v", err)
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(names) != 1 || filepath.Base(names[0]) != "file" {
+		t.Errorf("unexpected files after failed fetch: %v", names)
+	}
+}
+
+func TestPlaceholderFetched(t *testing.T) {
+	dir, err := ioutil.TempDir("", "placeholders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: dir, Placeholders: true})
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("foobar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ScanFolder("default"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Puller{
+		folder:       "default",
+		dir:          dir,
+		placeholders: true,
+		model:        m,
+		storage:      m.folderStorage["default"],
+		cases:        osutil.NewCaseChecker(),
+	}
+
+	// Files with contents are pulled as usual
+	cf := m.CurrentFolderFile("default", "file")
+	file := cf
+	file.Version++
+	file.Blocks = []protocol.BlockInfo{{Size: 7, Hash: []byte("hash")}}
+	if p.wantsPlaceholder(file, cf) {
+		t.Error("unexpected placeholder for a file with contents")
+	}
+
+	// Fetching is a no-op
+	if err := m.FetchPlaceholder("default", "file"); err != nil {
+		t.Error(err)
+	}
+	if err := m.FetchPlaceholder("default", "nonexistent"); err != ErrNoSuchFile {
+		t.Errorf("unexpected error fetching nonexistent file: %v", err)
+	}
+
+	bs, err := m.Request(device1, "default", "file", 0, 6)
+	if err != nil || string(bs) != "foobar" {
+		t.Errorf("unexpected request result %q, %v", bs, err)
+	}
+}
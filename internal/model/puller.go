@@ -5,30 +5,30 @@
 package model
 
 import (
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 
-	"github.com/syncthing/syncthing/internal/config"
 	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/internal/metrics"
 	"github.com/syncthing/syncthing/internal/osutil"
-	"github.com/syncthing/syncthing/internal/protocol"
 	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/internal/storage"
 	"github.com/syncthing/syncthing/internal/versioner"
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
 // TODO: Stop on errors
 
 const (
-	copiersPerFolder   = 1
-	pullersPerFolder   = 16
-	finishersPerFolder = 2
-	pauseIntv          = 60 * time.Second
-	nextPullIntv       = 10 * time.Second
-	checkPullIntv      = 1 * time.Second
+	pauseIntv     = 60 * time.Second
+	nextPullIntv  = 10 * time.Second
+	checkPullIntv = 1 * time.Second
 )
 
 // A pullBlockState is passed to the puller routine for each block that needs
@@ -46,29 +46,52 @@ type copyBlocksState struct {
 }
 
 var (
-	activity    = newDeviceActivity()
-	errNoDevice = errors.New("no available source device")
+	activity         = newDeviceActivity()
+	errNoDevice      = errors.New("no available source device")
+	errNotDir        = errors.New("should be dir, but is not")
+	errLinkNotInSync = errors.New("link target is not in sync")
 )
 
 type Puller struct {
-	folder    string
-	dir       string
-	scanIntv  time.Duration
-	model     *Model
-	stop      chan struct{}
-	versioner versioner.Versioner
+	folder       string
+	dir          string
+	tempDir      string // empty to keep temporary files next to the target
+	scanIntv     time.Duration
+	acls         bool
+	placeholders bool
+	conflicts    bool         // keep local changes overwritten by concurrent ones as conflict copies
+	encrypted    bool         // the folder is kept encrypted for others, so the data can't be verified
+	owners       *ownerMapper // nil unless syncing ownership
+	model        *Model
+	stop         chan struct{}
+	stopped      chan struct{}
+	watcher      *folderWatcher
+	versioner    versioner.Versioner
+	storage      storage.Backend
+	cases        *osutil.CaseChecker
+	sources      renameSources // files to be deleted in this iteration
+	copiers      int
+	pullers      int
+	finishers    int
+
+	unlinkable map[string]uint64 // file -> version that could not be hard linked
+	lowSpace   bool              // warned that there isn't space to get in sync
+
+	preHook     string
+	postHook    string
+	hookTimeout time.Duration     // 0 for defaultHookTimeout
+	rejected    map[string]uint64 // file -> version rejected by the pre hook
+	rejectedMut sync.Mutex
 }
 
 // Serve will run scans and pulls. It will return when Stop()ed or on a
 // critical error.
 func (p *Puller) Serve() {
-	if debug {
+	if debug() {
 		l.Debugln(p, "starting")
 		defer l.Debugln(p, "exiting")
 	}
 
-	p.stop = make(chan struct{})
-
 	pullTimer := time.NewTimer(checkPullIntv)
 	scanTimer := time.NewTimer(time.Millisecond) // The first scan should be done immediately.
 
@@ -77,10 +100,18 @@ func (p *Puller) Serve() {
 		scanTimer.Stop()
 		// TODO: Should there be an actual FolderStopped state?
 		p.model.setState(p.folder, FolderIdle)
+		close(p.stopped)
 	}()
 
 	var prevVer uint64
 
+	if p.tempDir != "" {
+		if err := os.MkdirAll(p.tempDir, 0700); err != nil {
+			p.model.log.Warnf("Folder %q: creating temporary directory: %v; using the folder itself.", p.folder, err)
+			p.tempDir = ""
+		}
+	}
+
 	// Clean out old temporaries before we start pulling
 	p.clean()
 
@@ -110,15 +141,16 @@ loop:
 				continue
 			}
 
-			if debug {
+			if debug() {
 				l.Debugln(p, "pulling", prevVer, curVer)
 			}
 			p.model.setState(p.folder, FolderSyncing)
+			p.checkSpace()
 			tries := 0
 			for {
 				tries++
-				changed := p.pullerIteration(copiersPerFolder, pullersPerFolder, finishersPerFolder)
-				if debug {
+				changed := p.pullerIteration(p.copiers, p.pullers, p.finishers)
+				if debug() {
 					l.Debugln(p, "changed", changed)
 				}
 
@@ -140,6 +172,7 @@ loop:
 						curVer = lv
 					}
 					prevVer = curVer
+					p.model.folderStatRef(p.folder).SyncCompleted()
 					pullTimer.Reset(nextPullIntv)
 					break
 				}
@@ -149,37 +182,74 @@ loop:
 					// we're not making it. Probably there are write
 					// errors preventing us. Flag this with a warning and
 					// wait a bit longer before retrying.
-					l.Warnf("Folder %q isn't making progress - check logs for possible root cause. Pausing puller for %v.", p.folder, pauseIntv)
+					p.model.log.Warnf("Folder %q isn't making progress - check logs for possible root cause. Pausing puller for %v.", p.folder, pauseIntv)
 					pullTimer.Reset(pauseIntv)
 					break
 				}
 			}
+			p.model.updateUnsafe(p.folder)
+			p.model.updateRemoteCompletion(p.folder)
 			p.model.setState(p.folder, FolderIdle)
 
 		// The reason for running the scanner from within the puller is that
 		// this is the easiest way to make sure we are not doing both at the
 		// same time.
 		case <-scanTimer.C:
-			if debug {
+			if debug() {
 				l.Debugln(p, "rescan")
 			}
 			p.model.setState(p.folder, FolderScanning)
 			if err := p.model.ScanFolder(p.folder); err != nil {
-				invalidateFolder(p.model.cfg, p.folder, err)
+				p.model.invalidateFolder(p.folder, err)
 				break loop
 			}
+			p.model.updateUnsafe(p.folder)
+			p.model.updateRemoteCompletion(p.folder)
 			p.model.setState(p.folder, FolderIdle)
 			scanTimer.Reset(p.scanIntv)
 			if !initialScanCompleted {
-				l.Infoln("Completed initial scan (rw) of folder", p.folder)
+				p.model.log.Infoln("Completed initial scan (rw) of folder", p.folder)
 				initialScanCompleted = true
 			}
+
+		case subs := <-p.watcher.C():
+			if !initialScanCompleted {
+				continue
+			}
+			if debug() {
+				l.Debugln(p, "scan changes", subs)
+			}
+			if err := p.model.scanChanges(p.folder, subs); err != nil {
+				p.model.invalidateFolder(p.folder, err)
+				break loop
+			}
+			p.model.updateUnsafe(p.folder)
+			p.model.updateRemoteCompletion(p.folder)
+			p.model.setState(p.folder, FolderIdle)
 		}
 	}
 }
 
 func (p *Puller) Stop() {
 	close(p.stop)
+	p.watcher.Stop()
+}
+
+func (p *Puller) Stopped() <-chan struct{} {
+	return p.stopped
+}
+
+// failed logs and reports a file that could not be synced.
+func (p *Puller) failed(name, action string, err error) {
+	p.model.log.Infof("Puller (folder %q, file %q): %s: %v", p.folder, name, action, err)
+	p.model.itemError(p.folder, name, action, err)
+}
+
+// finalFailed logs and reports a file that could not be finished after its
+// data was fetched.
+func (p *Puller) finalFailed(state *sharedPullerState, err error) {
+	p.model.log.Warnln("puller: final:", state.file.Name, err)
+	p.model.itemError(p.folder, state.file.Name, "final", err)
 }
 
 func (p *Puller) String() string {
@@ -230,6 +300,7 @@ func (p *Puller) pullerIteration(ncopiers, npullers, nfinishers int) int {
 
 	p.model.fmut.RLock()
 	files := p.model.folderFiles[p.folder]
+	ignores := p.model.folderIgnores[p.folder]
 	p.model.fmut.RUnlock()
 
 	// !!!
@@ -238,7 +309,12 @@ func (p *Puller) pullerIteration(ncopiers, npullers, nfinishers int) int {
 	// be attempting to sync with an old version of a file...
 	// !!!
 
+	p.cases = osutil.NewCaseChecker()
+	p.sources = p.renameSources(files, ignores)
+
 	changed := 0
+	needed := make(map[string]bool)
+	var links, deletions []protocol.FileInfo
 	files.WithNeed(protocol.LocalDeviceID, func(intf protocol.FileIntf) bool {
 
 		// Needed items are delivered sorted lexicographically. This isn't
@@ -250,31 +326,50 @@ func (p *Puller) pullerIteration(ncopiers, npullers, nfinishers int) int {
 
 		file := intf.(protocol.FileInfo)
 
-		events.Default.Log(events.ItemStarted, map[string]string{
+		if p.isRejected(file) {
+			// Skipped until there is a new version
+			return true
+		}
+
+		if ignores.Match(file.Name) {
+			// Ignored since the index entry was received
+			return true
+		}
+
+		p.model.itemEvents.Log(events.ItemStarted, p.folder, file.Name, map[string]string{
 			"folder": p.folder,
 			"item":   file.Name,
 		})
 
-		if debug {
+		if debug() {
 			l.Debugln(p, "handling", file.Name)
 		}
 
 		switch {
-		case protocol.IsDirectory(file.Flags) && protocol.IsDeleted(file.Flags):
-			// A deleted directory
-			p.deleteDir(file)
+		case protocol.IsDeleted(file.Flags):
+			// A deleted file or directory. These are handled last, so that
+			// directories are emptied before they are removed and files
+			// renamed only in case are not removed under their new name.
+			deletions = append(deletions, file)
 		case protocol.IsDirectory(file.Flags):
 			// A new or changed directory
 			p.handleDir(file)
-		case protocol.IsDeleted(file.Flags):
-			// A deleted file
-			p.deleteFile(file)
 		default:
 			// A new or changed file. This is the only case where we do stuff
 			// in the background; the other three are done synchronously.
-			p.handleFile(file, copyChan, pullChan)
+			target := p.linkTarget(file)
+			if target != "" && needed[target] {
+				// A hard link to a file that is being pulled right now. We
+				// link it once that is done.
+				links = append(links, file)
+			} else if target == "" || p.linkFile(file, target) != nil {
+				if !p.renameFile(file) {
+					p.handleFile(file, copyChan, pullChan)
+				}
+			}
 		}
 
+		needed[file.Name] = true
 		changed++
 		return true
 	})
@@ -292,15 +387,36 @@ func (p *Puller) pullerIteration(ncopiers, npullers, nfinishers int) int {
 	// Wait for the finisherChan to finish.
 	doneWg.Wait()
 
+	// Files that can't be linked now are pulled as usual in the next
+	// iteration.
+	for _, file := range links {
+		if err := p.linkFile(file, p.linkTarget(file)); err != nil && debug() {
+			l.Debugln(p, "link", file.Name, err)
+		}
+	}
+
+	// Deletions in reverse order, so that the contents of a directory are
+	// removed before the directory itself.
+	p.cases.Invalidate()
+	for i := len(deletions) - 1; i >= 0; i-- {
+		file := deletions[i]
+		if protocol.IsDirectory(file.Flags) {
+			p.deleteDir(file)
+		} else {
+			p.deleteFile(file)
+		}
+	}
+
 	return changed
 }
 
 // handleDir creates or updates the given directory
 func (p *Puller) handleDir(file protocol.FileInfo) {
+	p.fixCase(file)
 	realName := filepath.Join(p.dir, file.Name)
 	mode := os.FileMode(file.Flags & 0777)
 
-	if debug {
+	if debug() {
 		curFile := p.model.CurrentFolderFile(p.folder, file.Name)
 		l.Debugf("need dir\n\t%v\n\t%v", file, curFile)
 	}
@@ -314,23 +430,30 @@ func (p *Puller) handleDir(file protocol.FileInfo) {
 				// We declare a function that acts on only the path name, so
 				// we can pass it to InWritableDir. We use a regular Mkdir and
 				// not MkdirAll because the parent should already exist.
-				return os.Mkdir(path, mode)
+				if err := os.Mkdir(path, mode); err != nil {
+					return err
+				}
+				var err error
+				if file, err = p.applyOwnership(path, file); err != nil {
+					return err
+				}
+				return p.applyACLs(path, file)
 			}
 
 			if err = osutil.InWritableDir(mkdir, realName); err == nil {
 				p.model.updateLocal(p.folder, file)
 			} else {
-				l.Infof("Puller (folder %q, file %q): %v", p.folder, file.Name, err)
+				p.failed(file.Name, "dir", err)
 			}
 			return
 		}
 
 		// Weird error when stat()'ing the dir. Probably won't work to do
 		// anything else with it if we can't even stat() it.
-		l.Infof("Puller (folder %q, file %q): %v", p.folder, file.Name, err)
+		p.failed(file.Name, "dir", err)
 		return
 	} else if !info.IsDir() {
-		l.Infof("Puller (folder %q, file %q): should be dir, but is not", p.folder, file.Name)
+		p.failed(file.Name, "dir", errNotDir)
 		return
 	}
 
@@ -338,17 +461,28 @@ func (p *Puller) handleDir(file protocol.FileInfo) {
 	// don't handle modification times on directories, because that sucks...)
 	// It's OK to change mode bits on stuff within non-writable directories.
 
-	if err := os.Chmod(realName, mode); err == nil {
+	file, err := p.applyOwnership(realName, file)
+	if err == nil {
+		err = os.Chmod(realName, mode)
+	}
+	if err == nil {
+		err = p.applyACLs(realName, file)
+	}
+	if err == nil {
 		p.model.updateLocal(p.folder, file)
 	} else {
-		l.Infof("Puller (folder %q, file %q): %v", p.folder, file.Name, err)
+		p.failed(file.Name, "dir", err)
 	}
 }
 
 // deleteDir attempts to delete the given directory
 func (p *Puller) deleteDir(file protocol.FileInfo) {
-	realName := filepath.Join(p.dir, file.Name)
-	err := osutil.InWritableDir(os.Remove, realName)
+	if !p.existsInCase(file) {
+		p.model.updateLocal(p.folder, file)
+		return
+	}
+
+	err := p.storage.Remove(file.Name)
 	if err == nil || os.IsNotExist(err) {
 		p.model.updateLocal(p.folder, file)
 	}
@@ -356,19 +490,39 @@ func (p *Puller) deleteDir(file protocol.FileInfo) {
 
 // deleteFile attempts to delete the given file
 func (p *Puller) deleteFile(file protocol.FileInfo) {
+	if !p.existsInCase(file) {
+		p.model.updateLocal(p.folder, file)
+		return
+	}
+
 	realName := filepath.Join(p.dir, file.Name)
+	if !p.preApply("delete", realName, file) {
+		return
+	}
+
+	if p.inConflict(file) {
+		// Deleted elsewhere without seeing our change; keep the change as a
+		// conflict copy rather than deleting it
+		if err := p.keepConflict(file); err != nil {
+			p.failed(file.Name, "conflict", err)
+		} else {
+			p.model.updateLocal(p.folder, file)
+		}
+		return
+	}
 
 	var err error
 	if p.versioner != nil {
 		err = osutil.InWritableDir(p.versioner.Archive, realName)
 	} else {
-		err = osutil.InWritableDir(os.Remove, realName)
+		err = p.storage.Remove(file.Name)
 	}
 
 	if err != nil {
-		l.Infof("Puller (folder %q, file %q): delete: %v", p.folder, file.Name, err)
+		p.failed(file.Name, "delete", err)
 	} else {
 		p.model.updateLocal(p.folder, file)
+		p.postApply("delete", realName, file)
 	}
 }
 
@@ -376,13 +530,32 @@ func (p *Puller) deleteFile(file protocol.FileInfo) {
 // changed file.
 func (p *Puller) handleFile(file protocol.FileInfo, copyChan chan<- copyBlocksState, pullChan chan<- pullBlockState) {
 	curFile := p.model.CurrentFolderFile(p.folder, file.Name)
+	if old := p.fixCase(file); old != "" {
+		// The file was renamed in case; its blocks are now available
+		// under the new name.
+		curFile = p.model.CurrentFolderFile(p.folder, old)
+	}
+	if p.wantsPlaceholder(file, curFile) {
+		p.placeholderFile(file)
+		return
+	}
+	if sameSymlink(file, curFile) {
+		p.model.updateLocal(p.folder, file)
+		return
+	}
+	if file.IsSymlink() || curFile.IsSymlink() {
+		// Reading the existing file would read the target of the link
+		// rather than the link, so nothing can be copied from it
+		curFile.Blocks = nil
+	}
+
 	copyBlocks, pullBlocks := scanner.BlockDiff(curFile.Blocks, file.Blocks)
 
 	if len(copyBlocks) == len(curFile.Blocks) && len(pullBlocks) == 0 {
 		// We are supposed to copy the entire file, and then fetch nothing. We
 		// are only updating metadata, so we don't actually *need* to make the
 		// copy.
-		if debug {
+		if debug() {
 			l.Debugln(p, "taking shortcut on", file.Name)
 		}
 		p.shortcutFile(file)
@@ -390,21 +563,51 @@ func (p *Puller) handleFile(file protocol.FileInfo, copyChan chan<- copyBlocksSt
 	}
 
 	// Figure out the absolute filenames we need once and for all
-	tempName := filepath.Join(p.dir, defTempNamer.TempName(file.Name))
+	tempName := p.tempName(file.Name)
 	realName := filepath.Join(p.dir, file.Name)
 
+	// With nothing to copy from the existing file, copy what we can from a
+	// file that is about to be deleted
+	var sourceName string
+	if len(copyBlocks) == 0 && !file.IsSymlink() {
+		if source, have := p.sources.similar(file); source != nil {
+			source.copied = true
+			sourceName = filepath.Join(p.dir, source.file.Name)
+			copyBlocks, pullBlocks = scanner.BlockDiff(source.file.Blocks, file.Blocks)
+			if debug() {
+				l.Debugf("%v copying %d blocks of %s from %s", p, len(have), file.Name, source.file.Name)
+			}
+		}
+	}
+
+	// The temporary file is created in the folder's storage, unless there
+	// is a separate temporary directory
+	tempStorage, tempStorageName := p.storage, defTempNamer.TempName(file.Name)
+	if p.tempDir != "" {
+		tempStorage, tempStorageName = storage.NewLocal(p.tempDir), filepath.Base(tempName)
+	}
+
 	s := sharedPullerState{
-		file:       file,
-		folder:     p.folder,
-		tempName:   tempName,
-		realName:   realName,
+		file:            file,
+		folder:          p.folder,
+		tempName:        tempName,
+		realName:        realName,
+		sourceName:      sourceName,
+		tempStorage:     tempStorage,
+		tempStorageName: tempStorageName,
+		started:         time.Now(),
+		log:             p.model.log,
+		onError: func(context string, err error) {
+			p.model.itemError(p.folder, file.Name, context, err)
+		},
 		pullNeeded: len(pullBlocks),
 	}
 	if len(copyBlocks) > 0 {
 		s.copyNeeded = 1
 	}
+	p.model.pullStarted(p.folder, file.Name)
 
-	if debug {
+	if debug() {
 		l.Debugf("%v need file %s; copy %d, pull %d", p, file.Name, len(copyBlocks), len(pullBlocks))
 	}
 
@@ -427,20 +630,135 @@ func (p *Puller) handleFile(file protocol.FileInfo, copyChan chan<- copyBlocksSt
 	}
 }
 
+// fixCase renames an existing file or directory whose name differs from the
+// given one only in case, when the old name is being deleted, and returns
+// the old name. Otherwise a file renamed only in case would be written to
+// under its old name on case insensitive filesystems, and then removed as
+// the old name is deleted.
+func (p *Puller) fixCase(file protocol.FileInfo) string {
+	real, err := p.cases.RealCase(p.dir, file.Name)
+	if err != nil || real == file.Name {
+		return ""
+	}
+
+	if old := p.model.CurrentGlobalFile(p.folder, real); old.Name != "" && !protocol.IsDeleted(old.Flags) {
+		// Both names are in use in the cluster, which we can't represent
+		// here.
+		if debug() {
+			l.Debugf("%v case conflict %q %q", p, file.Name, real)
+		}
+		p.model.conflict(Conflict{
+			Folder:   p.folder,
+			File:     file,
+			Existing: real,
+			Reason:   "names differ only in case",
+		})
+		return ""
+	}
+
+	err = osutil.InWritableDir(func(path string) error {
+		return osutil.RenameCase(filepath.Join(p.dir, real), path)
+	}, filepath.Join(p.dir, file.Name))
+	if err != nil {
+		p.model.log.Infof("Puller (folder %q, file %q): rename from %q: %v", p.folder, file.Name, real, err)
+		return ""
+	}
+	p.cases.Invalidate()
+	return real
+}
+
+// existsInCase returns true unless the given file is known not to exist
+// with exactly the case of its name.
+func (p *Puller) existsInCase(file protocol.FileInfo) bool {
+	real, err := p.cases.RealCase(p.dir, file.Name)
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	return real == file.Name
+}
+
+// linkTarget returns the name of the file that the given file should be a
+// hard link to, or the empty string if it should be pulled as usual.
+func (p *Puller) linkTarget(file protocol.FileInfo) string {
+	target, ok := file.GetAttribute(scanner.AttrHardLink)
+	if !ok || p.unlinkable[file.Name] == file.Version {
+		return ""
+	}
+	return filepath.FromSlash(target)
+}
+
+// linkFile creates the file as a hard link to the target, if the target is
+// in sync and has the same contents.
+func (p *Puller) linkFile(file protocol.FileInfo, target string) error {
+	cur := p.model.CurrentFolderFile(p.folder, target)
+	if cur.IsDeleted() || cur.IsInvalid() || !scanner.BlocksEqual(cur.Blocks, file.Blocks) {
+		return errLinkNotInSync
+	}
+
+	tempName := filepath.Join(p.dir, defTempNamer.TempName(file.Name))
+	realName := filepath.Join(p.dir, file.Name)
+
+	os.Remove(tempName)
+	if err := os.Link(filepath.Join(p.dir, target), tempName); err != nil {
+		// Probably not supported by the file system. Pull the file as a
+		// copy instead.
+		if p.unlinkable == nil {
+			p.unlinkable = make(map[string]uint64)
+		}
+		p.unlinkable[file.Name] = file.Version
+		p.model.log.Infof("Puller (folder %q, file %q): link: %v", p.folder, file.Name, err)
+		return err
+	}
+
+	if err := p.keepConflict(file); err != nil {
+		os.Remove(tempName)
+		p.model.log.Infof("Puller (folder %q, file %q): link: %v", p.folder, file.Name, err)
+		return err
+	}
+
+	if p.versioner != nil {
+		if err := p.versioner.Archive(realName); err != nil {
+			os.Remove(tempName)
+			p.model.log.Infof("Puller (folder %q, file %q): link: %v", p.folder, file.Name, err)
+			return err
+		}
+	}
+
+	if err := osutil.Rename(tempName, realName); err != nil {
+		p.model.log.Infof("Puller (folder %q, file %q): link: %v", p.folder, file.Name, err)
+		return err
+	}
+
+	p.model.updateLocal(p.folder, file)
+	return nil
+}
+
 // shortcutFile sets file mode and modification time, when that's the only
 // thing that has changed.
 func (p *Puller) shortcutFile(file protocol.FileInfo) {
 	realName := filepath.Join(p.dir, file.Name)
-	err := os.Chmod(realName, os.FileMode(file.Flags&0777))
+	file, err := p.applyOwnership(realName, file)
 	if err != nil {
-		l.Infof("Puller (folder %q, file %q): shortcut: %v", p.folder, file.Name, err)
+		p.failed(file.Name, "shortcut", err)
 		return
 	}
 
-	t := time.Unix(file.Modified, 0)
+	err = os.Chmod(realName, os.FileMode(file.Flags&0777))
+	if err != nil {
+		p.failed(file.Name, "shortcut", err)
+		return
+	}
+
+	err = p.applyACLs(realName, file)
+	if err != nil {
+		p.failed(file.Name, "shortcut", err)
+		return
+	}
+
+	t := file.ModTime()
 	err = os.Chtimes(realName, t, t)
 	if err != nil {
-		l.Infof("Puller (folder %q, file %q): shortcut: %v", p.folder, file.Name, err)
+		p.failed(file.Name, "shortcut", err)
 		return
 	}
 
@@ -448,9 +766,14 @@ func (p *Puller) shortcutFile(file protocol.FileInfo) {
 }
 
 // copierRoutine reads pullerStates until the in channel closes and performs
-// the relevant copy.
+// the relevant copy. Each file is copied while holding a disk slot, which is
+// given back before the state is passed on, as the finisher needs one too.
 func (p *Puller) copierRoutine(in <-chan copyBlocksState, out chan<- *sharedPullerState) {
-	buf := make([]byte, scanner.StandardBlockSize)
+	bufSize := scanner.StandardBlockSize
+	if p.encrypted {
+		bufSize += protocol.EncryptionOverhead
+	}
+	buf := make([]byte, bufSize)
 
 nextFile:
 	for state := range in {
@@ -467,31 +790,35 @@ nextFile:
 			continue nextFile
 		}
 
+		p.model.diskIO.take()
 		for _, block := range state.blocks {
 			buf = buf[:int(block.Size)]
 
 			_, err = srcFd.ReadAt(buf, block.Offset)
 			if err != nil {
 				state.earlyClose("src read", err)
-				srcFd.Close()
-				continue nextFile
+				break
 			}
 
 			_, err = dstFd.WriteAt(buf, block.Offset)
 			if err != nil {
 				state.earlyClose("dst write", err)
-				srcFd.Close()
-				continue nextFile
+				break
 			}
 		}
+		p.model.diskIO.give()
 
 		srcFd.Close()
+		if err != nil {
+			continue nextFile
+		}
 		state.copyDone()
 		out <- state.sharedPullerState
 	}
 }
 
 func (p *Puller) pullerRoutine(in <-chan pullBlockState, out chan<- *sharedPullerState) {
+	queueGauge := metrics.GetGauge("pull." + p.folder + ".requests")
 nextBlock:
 	for state := range in {
 		if state.failed() != nil {
@@ -517,17 +844,27 @@ nextBlock:
 		}
 
 		// Fetch the block, while marking the selected device as in use so that
-		// leastBusy can select another device when someone else asks.
+		// leastBusy can select another device when someone else asks. The
+		// number of outstanding requests across all folders is limited by
+		// the network slots.
+		p.model.netIO.take()
 		activity.using(selected)
+		queueGauge.Add(1)
+		t0 := time.Now()
 		buf, err := p.model.requestGlobal(selected, p.folder, state.file.Name, state.block.Offset, int(state.block.Size), state.block.Hash)
+		queueGauge.Add(-1)
 		activity.done(selected)
+		p.model.netIO.give()
 		if err != nil {
 			state.earlyClose("pull", err)
 			continue nextBlock
 		}
 
+		metrics.GetMeter("pull."+selected.String()).Mark(int64(len(buf)), time.Since(t0))
+
 		// Save the block data we got from the cluster
 		_, err = fd.WriteAt(buf, state.block.Offset)
+		protocol.BufferPool.Put(buf)
 		if err != nil {
 			state.earlyClose("save", err)
 			continue nextBlock
@@ -541,24 +878,64 @@ nextBlock:
 func (p *Puller) finisherRoutine(in <-chan *sharedPullerState) {
 	for state := range in {
 		if closed, err := state.finalClose(); closed {
-			if debug {
+			if debug() {
 				l.Debugln(p, "closing", state.file.Name)
 			}
+			p.model.pullDone(p.folder, state.file.Name)
 			if err != nil {
-				l.Warnln("puller: final:", err)
+				p.finalFailed(state, err)
 				continue
 			}
 
-			// Verify the file against expected hashes
-			fd, err := os.Open(state.tempName)
-			if err != nil {
-				l.Warnln("puller: final:", err)
+			// Verify the file against expected hashes, which are
+			// encrypted along with the data of an encrypted folder
+			if !p.encrypted {
+				fd, err := os.Open(state.tempName)
+				if err != nil {
+					p.finalFailed(state, err)
+					continue
+				}
+				p.model.diskIO.take()
+				err = scanner.Verify(fd, scanner.StandardBlockSize, state.file.Blocks)
+				p.model.diskIO.give()
+				fd.Close()
+				if err != nil {
+					p.finalFailed(state, err)
+					continue
+				}
+			}
+
+			if state.file.IsSymlink() {
+				if err := p.finishSymlink(state); err != nil {
+					p.finalFailed(state, err)
+				}
 				continue
 			}
-			err = scanner.Verify(fd, scanner.StandardBlockSize, state.file.Blocks)
-			fd.Close()
+
+			// Bring a file from the temporary directory next to its final
+			// location, so that the final rename is atomic
+			if p.tempDir != "" {
+				localName := filepath.Join(p.dir, defTempNamer.TempName(state.file.Name))
+				p.model.diskIO.take()
+				err = osutil.InWritableDir(func(path string) error {
+					return moveFile(state.tempName, path)
+				}, localName)
+				p.model.diskIO.give()
+				if err != nil {
+					os.Remove(state.tempName)
+					p.finalFailed(state, err)
+					continue
+				}
+				state.tempName = localName
+			}
+
+			// Set the owner before the permission bits, as changing owner
+			// may clear the setuid and setgid bits. The file is recorded
+			// with the owner it ends up with.
+			owned, err := p.applyOwnership(state.tempName, state.file)
 			if err != nil {
-				l.Warnln("puller: final:", state.file.Name, err)
+				os.Remove(state.tempName)
+				p.finalFailed(state, err)
 				continue
 			}
 
@@ -566,16 +943,38 @@ func (p *Puller) finisherRoutine(in <-chan *sharedPullerState) {
 			err = os.Chmod(state.tempName, os.FileMode(state.file.Flags&0777))
 			if err != nil {
 				os.Remove(state.tempName)
-				l.Warnln("puller: final:", err)
+				p.finalFailed(state, err)
+				continue
+			}
+
+			// Set the ACLs, which also adjusts the group permission bits
+			err = p.applyACLs(state.tempName, state.file)
+			if err != nil {
+				os.Remove(state.tempName)
+				p.finalFailed(state, err)
 				continue
 			}
 
 			// Set the correct timestamp on the new file
-			t := time.Unix(state.file.Modified, 0)
+			t := state.file.ModTime()
 			err = os.Chtimes(state.tempName, t, t)
 			if err != nil {
 				os.Remove(state.tempName)
-				l.Warnln("puller: final:", err)
+				p.finalFailed(state, err)
+				continue
+			}
+
+			// Let the pre hook check the new file before it's applied
+			if !p.preApply("update", state.tempName, state.file) {
+				os.Remove(state.tempName)
+				continue
+			}
+
+			// Keep a concurrent local change as a conflict copy
+			err = p.keepConflict(state.file)
+			if err != nil {
+				os.Remove(state.tempName)
+				p.finalFailed(state, err)
 				continue
 			}
 
@@ -586,23 +985,82 @@ func (p *Puller) finisherRoutine(in <-chan *sharedPullerState) {
 				err = p.versioner.Archive(state.realName)
 				if err != nil {
 					os.Remove(state.tempName)
-					l.Warnln("puller: final:", err)
+					p.finalFailed(state, err)
 					continue
 				}
 			}
 
 			// Replace the original file with the new one
-			err = osutil.Rename(state.tempName, state.realName)
+			err = p.storage.Rename(defTempNamer.TempName(state.file.Name), state.file.Name)
 			if err != nil {
 				os.Remove(state.tempName)
-				l.Warnln("puller: final:", err)
+				p.finalFailed(state, err)
 				continue
 			}
 
 			// Record the updated file in the index
-			p.model.updateLocal(p.folder, state.file)
+			p.model.updateLocal(p.folder, owned)
+			p.model.receivedFile(p.folder, state.file.Name)
+			p.postApply("update", state.realName, state.file)
+			metrics.EndSpan("pull", p.folder+"/"+state.file.Name, state.started)
+		}
+	}
+}
+
+// applyACLs sets the POSIX ACLs of the file, when they are synced for this
+// folder.
+func (p *Puller) applyACLs(path string, file protocol.FileInfo) error {
+	if !p.acls {
+		return nil
+	}
+	return scanner.ApplyACLAttributes(path, file)
+}
+
+// applyOwnership sets the owner of the file, when ownership is synced for
+// this folder. It returns the file with the owner it actually has now, as
+// the next scan will see it, so that an owner mapped to something else
+// locally isn't taken for a local change.
+func (p *Puller) applyOwnership(path string, file protocol.FileInfo) (protocol.FileInfo, error) {
+	if p.owners == nil {
+		return file, nil
+	}
+	uid, gid, err := p.owners.owner(file)
+	if err != nil {
+		return file, err
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return file, err
 		}
 	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return file, err
+	}
+	for _, key := range []string{scanner.AttrOwnerUID, scanner.AttrOwnerGID, scanner.AttrOwnerUser, scanner.AttrOwnerGroup} {
+		file.RemoveAttribute(key)
+	}
+	for _, a := range scanner.OwnerAttributes(info) {
+		file.SetAttribute(a.Key, a.Value)
+	}
+	return file, nil
+}
+
+// tempName returns the name of the temporary file used while pulling the
+// given file. Files in a separate temporary directory are named by a hash of
+// the folder and file name, prefixed per folder so that several folders can
+// share the directory.
+func (p *Puller) tempName(name string) string {
+	if p.tempDir == "" {
+		return filepath.Join(p.dir, defTempNamer.TempName(name))
+	}
+	return filepath.Join(p.tempDir, fmt.Sprintf("%s%x.tmp", p.tempPrefix(), sha256.Sum256([]byte(name))))
+}
+
+func (p *Puller) tempPrefix() string {
+	hash := sha256.Sum256([]byte(p.folder))
+	return fmt.Sprintf("syncthing-%x-", hash[:8])
 }
 
 // clean deletes orphaned temporary files
@@ -618,14 +1076,44 @@ func (p *Puller) clean() {
 
 		return nil
 	})
-}
 
-func invalidateFolder(cfg *config.Configuration, folderID string, err error) {
-	for i := range cfg.Folders {
-		folder := &cfg.Folders[i]
-		if folder.ID == folderID {
-			folder.Invalid = err.Error()
-			return
+	if p.tempDir != "" {
+		matches, _ := filepath.Glob(filepath.Join(p.tempDir, p.tempPrefix()+"*.tmp"))
+		for _, path := range matches {
+			os.Remove(path)
 		}
 	}
 }
+
+// moveFile renames from to to, falling back to copying the contents when
+// they are on different filesystems. The source is removed in either case.
+func moveFile(from, to string) error {
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(to)
+		return err
+	}
+
+	src.Close()
+	return os.Remove(from)
+}
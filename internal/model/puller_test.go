@@ -0,0 +1,82 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTempDirNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing-tempdir-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p1 := &Puller{folder: "one", dir: "testdata", tempDir: dir}
+	p2 := &Puller{folder: "two", dir: "testdata", tempDir: dir}
+
+	n1 := p1.tempName("foo/bar")
+	n2 := p2.tempName("foo/bar")
+	if filepath.Dir(n1) != dir {
+		t.Errorf("Temporary file %q not in %q", n1, dir)
+	}
+	if n1 == n2 {
+		t.Errorf("Same temporary name %q for different folders", n1)
+	}
+	if n := p1.tempName("foo/baz"); n == n1 {
+		t.Errorf("Same temporary name %q for different files", n)
+	}
+	if defTempNamer.IsTemporary(n1) {
+		t.Errorf("Temporary name %q in a separate directory should not be a dot file", n1)
+	}
+
+	for _, name := range []string{n1, n2} {
+		if err := ioutil.WriteFile(name, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p1.clean()
+
+	if _, err := os.Stat(n1); !os.IsNotExist(err) {
+		t.Errorf("Temporary file %q not cleaned", n1)
+	}
+	if _, err := os.Stat(n2); err != nil {
+		t.Errorf("Temporary file %q of another folder was cleaned: %v", n2, err)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing-movefile-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	from := filepath.Join(dir, "from")
+	to := filepath.Join(dir, "to")
+	if err := ioutil.WriteFile(from, []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := moveFile(from, to); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Error("Source file still exists")
+	}
+	bs, err := ioutil.ReadFile(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "contents" {
+		t.Errorf("Unexpected contents %q", bs)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// A paused device refuses our connections with protocol.ReasonPaused, which
+// we remember until it next connects properly, to show it as paused rather
+// than disconnected and to dial it less often.
+
+// PausedRetryInterval is how long to wait before dialing a device that has
+// paused us again. It connects to us by itself when it resumes, if it can.
+const PausedRetryInterval = 10 * time.Minute
+
+// PausedByRemote returns when the device last refused us for having paused
+// us, and false if it hasn't done so since it was last connected.
+func (m *Model) PausedByRemote(device protocol.DeviceID) (time.Time, bool) {
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+	t, ok := m.remotePaused[device]
+	return t, ok
+}
+
+// RemotePaused returns the devices that have paused us, and since when.
+func (m *Model) RemotePaused() map[string]time.Time {
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+	res := make(map[string]time.Time, len(m.remotePaused))
+	for device, t := range m.remotePaused {
+		res[device.String()] = t
+	}
+	return res
+}
@@ -0,0 +1,42 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestRemotePaused(t *testing.T) {
+	cfg := config.New("/tmp/test", device1)
+	cfg.Devices = []config.DeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+
+	m.Close(device1, errors.New("connection reset"))
+	if _, ok := m.PausedByRemote(device1); ok {
+		t.Error("Device paused us after a network error")
+	}
+
+	m.Close(device2, errors.New(protocol.ReasonPaused))
+	if _, ok := m.PausedByRemote(device2); !ok {
+		t.Error("Device didn't pause us")
+	}
+	if p := m.RemotePaused(); len(p) != 1 || p[device2.String()].IsZero() {
+		t.Errorf("Unexpected paused devices %v", p)
+	}
+
+	// Connecting properly again means it resumed
+	m.ClusterConfig(device2, protocol.ClusterConfigMessage{})
+	if _, ok := m.PausedByRemote(device2); ok {
+		t.Error("Device still paused after connecting")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/syncthing/syncthing/internal/files"
+	"github.com/syncthing/syncthing/internal/ignore"
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// A file renamed or moved on another device shows up as the old name being
+// deleted and a new file appearing. Rather than pulling the new file over
+// the network, the puller renames the old file into place when the contents
+// are the same, or copies the blocks they have in common when they are not.
+// The files to be deleted are collected before any new files are handled,
+// as deletions are only carried out at the end of the iteration.
+
+// A renameSource is a local file that is about to be deleted.
+type renameSource struct {
+	file   protocol.FileInfo // our current version of the file
+	copied bool              // blocks are being copied from it, so it can't be renamed
+}
+
+type renameSources []*renameSource
+
+// renameSources returns our current versions of the files that will be
+// deleted in this iteration.
+func (p *Puller) renameSources(fs *files.Set, ignores ignore.Patterns) renameSources {
+	var sources renameSources
+	fs.WithNeedTruncated(protocol.LocalDeviceID, func(intf protocol.FileIntf) bool {
+		f := intf.(protocol.FileInfoTruncated)
+		if !f.IsDeleted() || protocol.IsDirectory(f.Flags) || ignores.Match(f.Name) {
+			return true
+		}
+		cur := p.model.CurrentFolderFile(p.folder, f.Name)
+		if cur.Name == "" || cur.IsDeleted() || cur.IsInvalid() || protocol.IsDirectory(cur.Flags) || cur.IsSymlink() || cur.Size() == 0 {
+			return true
+		}
+		sources = append(sources, &renameSource{file: cur})
+		return true
+	})
+	return sources
+}
+
+// identical returns a source with the same contents as the file, which is
+// removed from the list, or nil if there is none. Sources differing from the
+// file only in case are left to fixCase.
+func (r *renameSources) identical(file protocol.FileInfo) *renameSource {
+	if file.IsSymlink() {
+		return nil
+	}
+	for i, s := range *r {
+		if s.copied || strings.EqualFold(s.file.Name, file.Name) {
+			continue
+		}
+		if scanner.BlocksEqual(s.file.Blocks, file.Blocks) {
+			*r = append((*r)[:i], (*r)[i+1:]...)
+			return s
+		}
+	}
+	return nil
+}
+
+// similar returns the source that has the most blocks in common with the
+// file, and the blocks of the file that can be copied from it, or nil if no
+// source has any.
+func (r renameSources) similar(file protocol.FileInfo) (*renameSource, []protocol.BlockInfo) {
+	var best *renameSource
+	var bestHave []protocol.BlockInfo
+	for _, s := range r {
+		have, _ := scanner.BlockDiff(s.file.Blocks, file.Blocks)
+		if len(have) > len(bestHave) {
+			best, bestHave = s, have
+		}
+	}
+	return best, bestHave
+}
+
+// renameFile renames the identical file that is about to be deleted into
+// place, and returns whether it did. The old name is recorded as deleted
+// with the rest of the deletions.
+func (p *Puller) renameFile(file protocol.FileInfo) bool {
+	source := p.sources.identical(file)
+	if source == nil {
+		return false
+	}
+
+	oldName := filepath.Join(p.dir, source.file.Name)
+	realName := filepath.Join(p.dir, file.Name)
+
+	// The file may have changed since it was last scanned
+	info, err := os.Lstat(oldName)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != source.file.Size() || info.ModTime().Unix() != source.file.Modified {
+		return false
+	}
+
+	if !p.preApply("update", oldName, file) {
+		return true
+	}
+
+	if err := p.keepConflict(file); err != nil {
+		p.failed(file.Name, "rename", err)
+		return true
+	}
+
+	if p.versioner != nil {
+		if err := p.versioner.Archive(realName); err != nil {
+			p.failed(file.Name, "rename", err)
+			return true
+		}
+	}
+
+	err = osutil.InWritableDir(func(path string) error {
+		return osutil.Rename(oldName, path)
+	}, realName)
+	if err != nil {
+		// Pull it as usual instead
+		p.model.log.Infof("Puller (folder %q, file %q): rename from %q: %v", p.folder, file.Name, source.file.Name, err)
+		return false
+	}
+	if debug() {
+		l.Debugf("%v renamed %q to %q", p, source.file.Name, file.Name)
+	}
+
+	// The contents are in place; the metadata is set as for any other file
+	// where only that changed
+	p.shortcutFile(file)
+	p.postApply("update", realName, file)
+	return true
+}
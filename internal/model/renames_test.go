@@ -0,0 +1,165 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func setupRenameTest(t *testing.T, contents map[string][]byte) (*Model, *Puller, string) {
+	dir, err := ioutil.TempDir("", "renames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range contents {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: dir})
+	if err := m.ScanFolder("default"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Puller{
+		folder:  "default",
+		dir:     dir,
+		model:   m,
+		storage: m.folderStorage["default"],
+		cases:   osutil.NewCaseChecker(),
+	}
+	return m, p, dir
+}
+
+// renamed returns the remote index entries for renaming the local file from
+// to the name to.
+func renamed(m *Model, from, to string) []protocol.FileInfo {
+	old := m.CurrentFolderFile("default", from)
+	deleted := protocol.FileInfo{
+		Name:     old.Name,
+		Flags:    old.Flags | protocol.FlagDeleted,
+		Modified: old.Modified,
+		Version:  old.Version + 100,
+	}
+	moved := old
+	moved.Name = to
+	moved.Version = old.Version + 100
+	return []protocol.FileInfo{deleted, moved}
+}
+
+func TestRenameDetection(t *testing.T) {
+	m, p, dir := setupRenameTest(t, map[string][]byte{
+		"old1": []byte("renamed in place"),
+		"old2": []byte("moved to another directory"),
+	})
+	defer os.RemoveAll(dir)
+
+	var remote []protocol.FileInfo
+	remote = append(remote, renamed(m, "old1", "renamed")...)
+	remote = append(remote, protocol.FileInfo{Name: "sub", Flags: protocol.FlagDirectory | 0755, Version: 1})
+	remote = append(remote, renamed(m, "old2", filepath.Join("sub", "moved"))...)
+	m.folderFiles["default"].Update(device1, remote)
+
+	// There is no device to pull from, so the files can only get there by
+	// being renamed
+	p.pullerIteration(1, 1, 1)
+
+	for name, data := range map[string]string{
+		"renamed":                     "renamed in place",
+		filepath.Join("sub", "moved"): "moved to another directory",
+	} {
+		bs, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(bs) != data {
+			t.Errorf("Incorrect %s: %q, %v", name, bs, err)
+		}
+		if cf := m.CurrentFolderFile("default", name); cf.Version < 100 {
+			t.Errorf("%s not in the index: %v", name, cf)
+		}
+	}
+	for _, name := range []string{"old1", "old2"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", name)
+		}
+		if cf := m.CurrentFolderFile("default", name); !cf.IsDeleted() {
+			t.Errorf("%s not deleted in the index: %v", name, cf)
+		}
+	}
+}
+
+func TestRenameDetectionModified(t *testing.T) {
+	m, p, dir := setupRenameTest(t, map[string][]byte{
+		"old": []byte("original contents"),
+	})
+	defer os.RemoveAll(dir)
+
+	m.folderFiles["default"].Update(device1, renamed(m, "old", "new"))
+	p.sources = p.renameSources(m.folderFiles["default"], nil)
+
+	// A file changed since it was scanned is not renamed
+	ioutil.WriteFile(filepath.Join(dir, "old"), []byte("changed"), 0644)
+	if p.renameFile(m.CurrentGlobalFile("default", "new")) {
+		t.Error("Changed file renamed")
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Error("New file created")
+	}
+}
+
+func TestRenameDetectionPartial(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), scanner.StandardBlockSize/16*2)
+	m, p, dir := setupRenameTest(t, map[string][]byte{
+		"old": data,
+	})
+	defer os.RemoveAll(dir)
+
+	// The new file has the first block of the old one, and a different
+	// second block
+	remote := renamed(m, "old", "new")
+	remote[1].Blocks = []protocol.BlockInfo{
+		remote[1].Blocks[0],
+		{Size: 16, Hash: bytes.Repeat([]byte{1}, 32)},
+	}
+	m.folderFiles["default"].Update(device1, remote)
+	p.sources = p.renameSources(m.folderFiles["default"], nil)
+
+	file := m.CurrentGlobalFile("default", "new")
+	if p.renameFile(file) {
+		t.Fatal("Partially matching file renamed")
+	}
+
+	copyChan := make(chan copyBlocksState, 1)
+	pullChan := make(chan pullBlockState, 2)
+	p.handleFile(file, copyChan, pullChan)
+	close(copyChan)
+	close(pullChan)
+
+	var copied, pulled int
+	for cs := range copyChan {
+		if cs.sourceName != filepath.Join(dir, "old") {
+			t.Errorf("Copying from %q", cs.sourceName)
+		}
+		copied += len(cs.blocks)
+	}
+	for _ = range pullChan {
+		pulled++
+	}
+	if copied != 1 || pulled != 1 {
+		t.Errorf("Copying %d and pulling %d blocks, not one each", copied, pulled)
+	}
+}
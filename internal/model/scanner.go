@@ -10,18 +10,22 @@ import (
 )
 
 type Scanner struct {
-	folder string
-	intv   time.Duration
-	model  *Model
-	stop   chan struct{}
+	folder  string
+	intv    time.Duration
+	model   *Model
+	stop    chan struct{}
+	stopped chan struct{}
+	watcher *folderWatcher // nil unless watching for changes
 }
 
 func (s *Scanner) Serve() {
-	if debug {
+	if debug() {
 		l.Debugln(s, "starting")
 		defer l.Debugln(s, "exiting")
 	}
 
+	defer close(s.stopped)
+
 	timer := time.NewTimer(time.Millisecond)
 	defer timer.Stop()
 
@@ -32,29 +36,54 @@ func (s *Scanner) Serve() {
 			return
 
 		case <-timer.C:
-			if debug {
+			if debug() {
 				l.Debugln(s, "rescan")
 			}
 
 			s.model.setState(s.folder, FolderScanning)
 			if err := s.model.ScanFolder(s.folder); err != nil {
-				invalidateFolder(s.model.cfg, s.folder, err)
+				s.model.invalidateFolder(s.folder, err)
 				return
 			}
+			s.model.updateLocallyChanged(s.folder)
+			s.model.updateUnsafe(s.folder)
+			s.model.updateRemoteCompletion(s.folder)
 			s.model.setState(s.folder, FolderIdle)
 
 			if !initialScanCompleted {
-				l.Infoln("Completed initial scan (ro) of folder", s.folder)
+				s.model.log.Infoln("Completed initial scan (ro) of folder", s.folder)
 				initialScanCompleted = true
 			}
 
 			timer.Reset(s.intv)
+
+		case subs := <-s.watcher.C():
+			if !initialScanCompleted {
+				continue
+			}
+			if debug() {
+				l.Debugln(s, "scan changes", subs)
+			}
+
+			if err := s.model.scanChanges(s.folder, subs); err != nil {
+				s.model.invalidateFolder(s.folder, err)
+				return
+			}
+			s.model.updateLocallyChanged(s.folder)
+			s.model.updateUnsafe(s.folder)
+			s.model.updateRemoteCompletion(s.folder)
+			s.model.setState(s.folder, FolderIdle)
 		}
 	}
 }
 
 func (s *Scanner) Stop() {
 	close(s.stop)
+	s.watcher.Stop()
+}
+
+func (s *Scanner) Stopped() <-chan struct{} {
+	return s.stopped
 }
 
 func (s *Scanner) String() string {
@@ -6,33 +6,41 @@ package model
 
 import (
 	"os"
-	"path/filepath"
 	"sync"
+	"time"
 
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/internal/logger"
+	"github.com/syncthing/syncthing/internal/storage"
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
 // A sharedPullerState is kept for each file that is being synced and is kept
 // updated along the way.
 type sharedPullerState struct {
 	// Immutable, does not require locking
-	file     protocol.FileInfo
-	folder   string
-	tempName string
-	realName string
+	file            protocol.FileInfo
+	folder          string
+	tempName        string
+	realName        string
+	sourceName      string          // The existing file blocks are copied from, if not realName
+	tempStorage     storage.Backend // Where the temp file is created
+	tempStorageName string          // The name of the temp file in tempStorage
+	started         time.Time
+	log             *logger.Logger                  // nil means the default logger
+	onError         func(context string, err error) // called on the first error, if set
 
 	// Mutable, must be locked for access
-	err        error      // The first error we hit
-	fd         *os.File   // The fd of the temp file
-	copyNeeded int        // Number of copy actions we expect to happen
-	pullNeeded int        // Number of block pulls we expect to happen
-	closed     bool       // Set when the file has been closed
-	mut        sync.Mutex // Protects the above
+	err        error            // The first error we hit
+	fd         storage.TempFile // The temp file
+	copyNeeded int              // Number of copy actions we expect to happen
+	pullNeeded int              // Number of block pulls we expect to happen
+	closed     bool             // Set when the file has been closed
+	mut        sync.Mutex       // Protects the above
 }
 
 // tempFile returns the fd for the temporary file, reusing an open fd
 // or creating the file as necessary.
-func (s *sharedPullerState) tempFile() (*os.File, error) {
+func (s *sharedPullerState) tempFile() (storage.TempFile, error) {
 	s.mut.Lock()
 	defer s.mut.Unlock()
 
@@ -46,27 +54,8 @@ func (s *sharedPullerState) tempFile() (*os.File, error) {
 		return s.fd, nil
 	}
 
-	// Ensure that the parent directory is writable. This is
-	// osutil.InWritableDir except we need to do more stuff so we duplicate it
-	// here.
-	dir := filepath.Dir(s.tempName)
-	if info, err := os.Stat(dir); err != nil {
-		s.earlyCloseLocked("dst stat dir", err)
-		return nil, err
-	} else if info.Mode()&04 == 0 {
-		err := os.Chmod(dir, 0755)
-		if err == nil {
-			defer func() {
-				err := os.Chmod(dir, info.Mode().Perm())
-				if err != nil {
-					panic(err)
-				}
-			}()
-		}
-	}
-
 	// Attempt to create the temp file
-	fd, err := os.OpenFile(s.tempName, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	fd, err := s.tempStorage.CreateTemp(s.tempStorageName)
 	if err != nil {
 		s.earlyCloseLocked("dst create", err)
 		return nil, err
@@ -89,7 +78,11 @@ func (s *sharedPullerState) sourceFile() (*os.File, error) {
 	}
 
 	// Attempt to open the existing file
-	fd, err := os.Open(s.realName)
+	name := s.sourceName
+	if name == "" {
+		name = s.realName
+	}
+	fd, err := os.Open(name)
 	if err != nil {
 		s.earlyCloseLocked("src open", err)
 		return nil, err
@@ -113,11 +106,14 @@ func (s *sharedPullerState) earlyCloseLocked(context string, err error) {
 		return
 	}
 
-	l.Infof("Puller (folder %q, file %q): %s: %v", s.folder, s.file.Name, context, err)
+	s.log.Or().Infof("Puller (folder %q, file %q): %s: %v", s.folder, s.file.Name, context, err)
+	if s.onError != nil {
+		s.onError(context, err)
+	}
 	s.err = err
 	if s.fd != nil {
 		s.fd.Close()
-		os.Remove(s.tempName)
+		s.tempStorage.Remove(s.tempStorageName)
 	}
 	s.closed = true
 }
@@ -132,7 +128,7 @@ func (s *sharedPullerState) failed() error {
 func (s *sharedPullerState) copyDone() {
 	s.mut.Lock()
 	s.copyNeeded--
-	if debug {
+	if debug() {
 		l.Debugln("sharedPullerState", s.folder, s.file.Name, "copyNeeded ->", s.pullNeeded)
 	}
 	s.mut.Unlock()
@@ -141,7 +137,7 @@ func (s *sharedPullerState) copyDone() {
 func (s *sharedPullerState) pullDone() {
 	s.mut.Lock()
 	s.pullNeeded--
-	if debug {
+	if debug() {
 		l.Debugln("sharedPullerState", s.folder, s.file.Name, "pullNeeded ->", s.pullNeeded)
 	}
 	s.mut.Unlock()
@@ -0,0 +1,72 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"path/filepath"
+
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// NeedSpace returns the additional disk space needed to finish syncing the
+// folder: the size of the needed files less that of the local versions they
+// replace, which are kept when versioning is enabled. Deletions are done
+// last, so the space they free doesn't count, and new placeholders take no
+// space.
+func (m *Model) NeedSpace(folder string) int64 {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	cfg := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return 0
+	}
+
+	versioned := cfg.Versioning.Type != ""
+
+	var need int64
+	fs.WithNeed(protocol.LocalDeviceID, func(fi protocol.FileIntf) bool {
+		f := fi.(protocol.FileInfo)
+		if f.IsDeleted() || protocol.IsDirectory(f.Flags) {
+			return true
+		}
+
+		lf := fs.Get(protocol.LocalDeviceID, f.Name)
+		hasLocal := lf.Name != "" && !lf.IsDeleted() && !protocol.IsDirectory(lf.Flags)
+		if cfg.Placeholders && (!hasLocal || isPlaceholder(filepath.Join(cfg.Path, lf.Name), lf)) {
+			return true
+		}
+
+		need += f.Size()
+		if hasLocal && !versioned {
+			need -= lf.Size()
+		}
+		return true
+	})
+
+	if need < 0 {
+		return 0
+	}
+	return need
+}
+
+// checkSpace warns once when the folder needs more space than is free on
+// its disk, and again when there is enough once more.
+func (p *Puller) checkSpace() {
+	free, _, err := osutil.DiskUsage(p.dir)
+	if err != nil {
+		return
+	}
+	need := p.model.NeedSpace(p.folder)
+	switch {
+	case need > free && !p.lowSpace:
+		p.model.log.Warnf("Folder %q needs %d MiB more to be in sync, but only %d MiB are free", p.folder, need>>20, free>>20)
+		p.lowSpace = true
+	case need <= free && p.lowSpace:
+		p.model.log.Infof("Folder %q has enough free space to be in sync", p.folder)
+		p.lowSpace = false
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestNeedSpace(t *testing.T) {
+	local := []protocol.FileInfo{
+		{Name: "changed", Version: 1, Blocks: []protocol.BlockInfo{{Size: 100}}},
+		{Name: "deleted", Version: 1, Blocks: []protocol.BlockInfo{{Size: 1000}}},
+	}
+	remote := []protocol.FileInfo{
+		{Name: "changed", Version: 2, Blocks: []protocol.BlockInfo{{Size: 100}, {Size: 50}}},
+		{Name: "deleted", Version: 2, Flags: protocol.FlagDeleted},
+		{Name: "dir", Version: 2, Flags: protocol.FlagDirectory},
+		{Name: "new", Version: 2, Blocks: []protocol.BlockInfo{{Size: 30}}},
+	}
+
+	for _, tc := range []struct {
+		cfg  config.FolderConfiguration
+		need int64
+	}{
+		// The changed file grows by 50 and the new file takes 30; the
+		// deletion frees space only at the end
+		{config.FolderConfiguration{ID: "default", Path: "testdata"}, 80},
+		// The old version of the changed file is kept
+		{config.FolderConfiguration{ID: "default", Path: "testdata", Versioning: config.VersioningConfiguration{Type: "simple"}}, 180},
+	} {
+		db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+		m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+		m.AddFolder(tc.cfg)
+		m.folderFiles["default"].Update(protocol.LocalDeviceID, local)
+		m.folderFiles["default"].Update(device1, remote)
+
+		if need := m.NeedSpace("default"); need != tc.need {
+			t.Errorf("unexpected need %d != %d for %+v", need, tc.need, tc.cfg.Versioning)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// SetSuccessor makes us announce to all devices connecting from now on that
+// we will be switching to the certificate with the given device ID. The
+// signature is the proof of succession as created by protocol.SignSuccessor.
+func (m *Model) SetSuccessor(successor protocol.DeviceID, sig []byte) {
+	m.pmut.Lock()
+	m.successor = successor
+	m.successorSig = sig
+	m.pmut.Unlock()
+}
+
+// successorOptions returns the cluster config options announcing our
+// successor, if any. Must be called with pmut held.
+func (m *Model) successorOptions() []protocol.Option {
+	if m.successorSig == nil {
+		return nil
+	}
+	return []protocol.Option{
+		{
+			Key:   protocol.OptionSuccessor,
+			Value: m.successor.String(),
+		},
+		{
+			Key:   protocol.OptionSuccessorSignature,
+			Value: base64.StdEncoding.EncodeToString(m.successorSig),
+		},
+	}
+}
+
+// handleSuccession adds the announced successor of a device to the
+// configuration, sharing the same folders, once the announcement has been
+// verified against the certificate the device is currently using. Devices
+// with a pinned certificate are not replaced. When the successor later
+// connects the predecessor is removed.
+func (m *Model) handleSuccession(deviceID protocol.DeviceID, cm protocol.ClusterConfigMessage) {
+	m.removePredecessor(deviceID)
+
+	str := cm.GetOption(protocol.OptionSuccessor)
+	if str == "" {
+		return
+	}
+	successor, err := protocol.DeviceIDFromString(str)
+	if err != nil {
+		m.log.Infof("Device %v announced invalid successor: %v", deviceID, err)
+		return
+	}
+	if m.cfg.GetDeviceConfiguration(successor) != nil {
+		// Already known, nothing to do.
+		return
+	}
+	if m.cfg.IsBlocked(successor) {
+		m.log.Warnf("Device %v announced successor %v, which is blocked; ignoring", deviceID, successor)
+		return
+	}
+	if m.cfg.GetDeviceConfiguration(deviceID).CertFingerprint != "" {
+		// The user has pinned the certificate of this device and does not
+		// want it replaced behind their back.
+		m.log.Warnf("Device %v announced successor %v, but its certificate is pinned; ignoring", deviceID, successor)
+		return
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(cm.GetOption(protocol.OptionSuccessorSignature))
+	if err != nil {
+		m.log.Infof("Device %v announced successor with invalid signature: %v", deviceID, err)
+		return
+	}
+
+	m.pmut.RLock()
+	conn, ok := m.rawConn[deviceID].(*tls.Conn)
+	m.pmut.RUnlock()
+	if !ok {
+		return
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) != 1 {
+		return
+	}
+	if err := protocol.VerifySuccessor(certs[0].PublicKey, successor, sig); err != nil {
+		m.log.Warnf("Device %v announced successor %v: %v", deviceID, successor, err)
+		return
+	}
+
+	m.log.Infof("Adding device %v to config (successor of %v)", successor, deviceID)
+	m.addSuccessor(deviceID, successor)
+	m.cfg.Save()
+}
+
+// removePredecessor removes the device that the given device has taken over
+// from, if any, from the configuration and stops sharing folders with it.
+func (m *Model) removePredecessor(deviceID protocol.DeviceID) {
+	device := m.cfg.GetDeviceConfiguration(deviceID)
+	if device == nil || device.Predecessor == "" {
+		return
+	}
+
+	predecessor, err := protocol.DeviceIDFromString(device.Predecessor)
+	device.Predecessor = ""
+	if err == nil {
+		m.log.Infof("Device %v has taken over from %v; removing the latter from config", deviceID, predecessor)
+
+		m.fmut.Lock()
+		for _, folder := range append([]string(nil), m.deviceFolders[predecessor]...) {
+			m.unshare(folder, predecessor)
+			delete(m.folderKeys[folder], predecessor)
+		}
+		delete(m.deviceFolders, predecessor)
+		m.fmut.Unlock()
+
+		m.cfg.RemoveDevice(predecessor)
+		m.Disconnect(predecessor)
+	}
+	m.cfg.Save()
+}
+
+// addSuccessor adds the successor to the configuration as a copy of the
+// device, sharing the same folders on the same terms. A folder the device is
+// untrusted with stays encrypted for the successor.
+func (m *Model) addSuccessor(deviceID, successor protocol.DeviceID) {
+	newDeviceCfg := *m.cfg.GetDeviceConfiguration(deviceID)
+	newDeviceCfg.DeviceID = successor
+	newDeviceCfg.Predecessor = deviceID.String()
+	newDeviceCfg.Addresses = append([]string(nil), newDeviceCfg.Addresses...)
+	m.cfg.Devices = append(m.cfg.Devices, newDeviceCfg)
+
+	m.fmut.Lock()
+	for _, folder := range m.deviceFolders[deviceID] {
+		m.deviceFolders[successor] = append(m.deviceFolders[successor], folder)
+		m.folderDevices[folder] = append(m.folderDevices[folder], successor)
+		if key := m.folderKeys[folder][deviceID]; key != nil {
+			m.folderKeys[folder][successor] = key
+		}
+
+		folderCfg := m.cfg.GetFolderConfiguration(folder)
+		var password string
+		for _, device := range folderCfg.Devices {
+			if device.DeviceID == deviceID {
+				password = device.EncryptionPassword
+			}
+		}
+		folderCfg.Devices = append(folderCfg.Devices, config.FolderDeviceConfiguration{
+			DeviceID:           successor,
+			EncryptionPassword: password,
+		})
+	}
+	m.fmut.Unlock()
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/syncthing/syncthing/internal/metrics"
+	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// Symbolic links are synced as files whose contents is the link target,
+// with forward slashes as separators. The target is pulled like any other
+// data, and the link is created in place of the temporary file once it has
+// been verified. On systems that can't create links, such as Windows XP or
+// Windows without the privilege to, remote links are marked invalid by
+// checkSyncable and never pulled.
+
+var errSymlinksUnsupported = errors.New("symbolic links are not supported")
+
+// sameSymlink returns whether the file is a symbolic link with the same
+// target as curFile, in which case there is nothing to do but record it.
+// Links have no metadata of their own that we sync.
+func sameSymlink(file, curFile protocol.FileInfo) bool {
+	return file.IsSymlink() && curFile.IsSymlink() && !curFile.IsDeleted() &&
+		scanner.BlocksEqual(file.Blocks, curFile.Blocks)
+}
+
+// finishSymlink replaces the file with a symbolic link to the target pulled
+// into the temporary file.
+func (p *Puller) finishSymlink(state *sharedPullerState) error {
+	target, err := ioutil.ReadFile(state.tempName)
+	os.Remove(state.tempName)
+	if err != nil {
+		return err
+	}
+
+	tempName := defTempNamer.TempName(state.file.Name)
+	linkName := filepath.Join(p.dir, tempName)
+	os.Remove(linkName)
+	if err := os.Symlink(filepath.FromSlash(string(target)), linkName); err != nil {
+		return err
+	}
+
+	if !p.preApply("update", linkName, state.file) {
+		os.Remove(linkName)
+		return nil
+	}
+
+	if p.versioner != nil {
+		if err := p.versioner.Archive(state.realName); err != nil {
+			os.Remove(linkName)
+			return err
+		}
+	}
+
+	if err := p.storage.Rename(tempName, state.file.Name); err != nil {
+		os.Remove(linkName)
+		return err
+	}
+
+	p.model.updateLocal(p.folder, state.file)
+	p.model.receivedFile(p.folder, state.file.Name)
+	p.postApply("update", state.realName, state.file)
+	metrics.EndSpan("pull", p.folder+"/"+state.file.Name, state.started)
+	return nil
+}
+
+// readSymlinkBlock returns the requested part of the target of the symbolic
+// link at path.
+func readSymlinkBlock(path string, offset int64, size int) ([]byte, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil, err
+	}
+	target = filepath.ToSlash(target)
+	if offset < 0 || offset+int64(size) > int64(len(target)) {
+		return nil, ErrNoSuchFile
+	}
+
+	buf := protocol.BufferPool.Get(size)
+	copy(buf, target[offset:])
+	return buf, nil
+}
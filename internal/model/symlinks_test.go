@@ -0,0 +1,99 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/internal/scanner"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestPullSymlink(t *testing.T) {
+	if !osutil.SymlinksSupported() {
+		t.Skip("symbolic links not supported")
+	}
+
+	dir, err := ioutil.TempDir("", "symlinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &config.Configuration{}, "device", "syncthing", "dev", db, nil)
+	m.AddFolder(config.FolderConfiguration{ID: "default", Path: dir})
+
+	p := &Puller{
+		folder:  "default",
+		dir:     dir,
+		model:   m,
+		storage: m.folderStorage["default"],
+		cases:   osutil.NewCaseChecker(),
+	}
+
+	blocks, _ := scanner.Blocks(strings.NewReader("../target"), scanner.StandardBlockSize, -1)
+	file := protocol.FileInfo{
+		Name:     "link",
+		Flags:    protocol.FlagSymlink | protocol.FlagNoPermBits | 0666,
+		Modified: 1234567890,
+		Version:  1,
+		Blocks:   blocks,
+	}
+
+	// The target has been pulled into the temporary file
+	state := &sharedPullerState{
+		file:     file,
+		folder:   "default",
+		tempName: p.tempName(file.Name),
+		realName: filepath.Join(dir, file.Name),
+		started:  time.Now(),
+	}
+	if err := ioutil.WriteFile(state.tempName, []byte("../target"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.finishSymlink(state); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, err := os.Readlink(state.realName); err != nil || target != filepath.FromSlash("../target") {
+		t.Errorf("Incorrect link target %q, %v", target, err)
+	}
+	if _, err := os.Lstat(state.tempName); !os.IsNotExist(err) {
+		t.Error("Temporary file not removed")
+	}
+	if cf := m.CurrentFolderFile("default", "link"); !cf.IsSymlink() || cf.Version != 1 {
+		t.Errorf("Link not in the index: %v", cf)
+	}
+
+	// The target is served as the data of the link
+	if bs, err := m.Request(device1, "default", "link", 0, len("../target")); err != nil || string(bs) != "../target" {
+		t.Errorf("Unexpected response %q, %v", bs, err)
+	}
+
+	// The link is not rescanned, as it still has the same target
+	if err := m.ScanFolder("default"); err != nil {
+		t.Fatal(err)
+	}
+	if cf := m.CurrentFolderFile("default", "link"); cf.Version != 1 {
+		t.Errorf("Link rescanned, version %d", cf.Version)
+	}
+
+	// A new version with the same target only needs to be recorded
+	file.Version = 2
+	p.handleFile(file, nil, nil)
+	if cf := m.CurrentFolderFile("default", "link"); cf.Version != 2 {
+		t.Errorf("Link not updated, version %d", cf.Version)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// MaxTalkersWindow is how far back TopTalkers can look.
+const MaxTalkersWindow = 24 * time.Hour
+
+// A Talker is the block data transferred with a device for a folder.
+type Talker struct {
+	Device   string
+	Folder   string
+	InBytes  int64
+	OutBytes int64
+}
+
+type talkerKey struct {
+	device protocol.DeviceID
+	folder string
+}
+
+type talkerBucket struct {
+	minute int64 // minutes since the epoch
+	bytes  map[talkerKey]*Talker
+}
+
+// A talkerTally counts the block data transferred per device and folder in
+// buckets of a minute, for the last MaxTalkersWindow.
+type talkerTally struct {
+	buckets []talkerBucket // oldest first
+	mut     sync.Mutex
+}
+
+func newTalkerTally() *talkerTally {
+	return &talkerTally{}
+}
+
+func (t *talkerTally) add(device protocol.DeviceID, folder string, in, out int) {
+	now := time.Now().Unix() / 60
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if n := len(t.buckets); n == 0 || t.buckets[n-1].minute != now {
+		t.buckets = append(t.buckets, talkerBucket{
+			minute: now,
+			bytes:  make(map[talkerKey]*Talker),
+		})
+		// Drop the buckets that have passed the window
+		oldest := now - int64(MaxTalkersWindow/time.Minute)
+		for len(t.buckets) > 0 && t.buckets[0].minute <= oldest {
+			t.buckets = t.buckets[1:]
+		}
+	}
+
+	b := t.buckets[len(t.buckets)-1]
+	k := talkerKey{device, folder}
+	tk, ok := b.bytes[k]
+	if !ok {
+		tk = &Talker{Device: device.String(), Folder: folder}
+		b.bytes[k] = tk
+	}
+	tk.InBytes += int64(in)
+	tk.OutBytes += int64(out)
+}
+
+// top returns the totals of the buckets in the window, most traffic first.
+func (t *talkerTally) top(window time.Duration) []Talker {
+	since := time.Now().Add(-window).Unix() / 60
+
+	t.mut.Lock()
+	totals := make(map[talkerKey]*Talker)
+	for _, b := range t.buckets {
+		if b.minute < since {
+			continue
+		}
+		for k, tk := range b.bytes {
+			tot, ok := totals[k]
+			if !ok {
+				tot = &Talker{Device: tk.Device, Folder: tk.Folder}
+				totals[k] = tot
+			}
+			tot.InBytes += tk.InBytes
+			tot.OutBytes += tk.OutBytes
+		}
+	}
+	t.mut.Unlock()
+
+	res := make([]Talker, 0, len(totals))
+	for _, tot := range totals {
+		res = append(res, *tot)
+	}
+	sort.Sort(talkerList(res))
+	return res
+}
+
+type talkerList []Talker
+
+func (l talkerList) Len() int      { return len(l) }
+func (l talkerList) Swap(a, b int) { l[a], l[b] = l[b], l[a] }
+func (l talkerList) Less(a, b int) bool {
+	ta, tb := l[a].InBytes+l[a].OutBytes, l[b].InBytes+l[b].OutBytes
+	if ta != tb {
+		return ta > tb
+	}
+	if l[a].Device != l[b].Device {
+		return l[a].Device < l[b].Device
+	}
+	return l[a].Folder < l[b].Folder
+}
+
+// TopTalkers returns the block data transferred with each device for each
+// folder during the window, at most MaxTalkersWindow back, with the most
+// traffic first.
+func (m *Model) TopTalkers(window time.Duration) []Talker {
+	if window > MaxTalkersWindow {
+		window = MaxTalkersWindow
+	}
+	return m.talkers.top(window)
+}
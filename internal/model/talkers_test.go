@@ -0,0 +1,45 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTalkerTally(t *testing.T) {
+	tt := newTalkerTally()
+	tt.add(device1, "default", 100, 0)
+	tt.add(device2, "default", 0, 300)
+	tt.add(device1, "other", 10, 20)
+	tt.add(device1, "default", 50, 50)
+
+	// Traffic from two hours ago
+	tt.buckets = append([]talkerBucket{{
+		minute: time.Now().Add(-2*time.Hour).Unix() / 60,
+		bytes: map[talkerKey]*Talker{
+			{device1, "other"}: {Device: device1.String(), Folder: "other", InBytes: 1000},
+		},
+	}}, tt.buckets...)
+
+	top := tt.top(time.Hour)
+	if len(top) != 3 {
+		t.Fatalf("unexpected number of talkers %d", len(top))
+	}
+	if top[0].Device != device2.String() || top[0].OutBytes != 300 {
+		t.Errorf("unexpected top talker %+v", top[0])
+	}
+	if top[1].Device != device1.String() || top[1].Folder != "default" || top[1].InBytes != 150 || top[1].OutBytes != 50 {
+		t.Errorf("unexpected second talker %+v", top[1])
+	}
+	if top[2].Folder != "other" || top[2].InBytes != 10 {
+		t.Errorf("unexpected third talker %+v", top[2])
+	}
+
+	top = tt.top(3 * time.Hour)
+	if top[0].Folder != "other" || top[0].InBytes != 1010 {
+		t.Errorf("unexpected top talker over three hours %+v", top[0])
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"time"
+
+	"github.com/syncthing/syncthing/internal/stats"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// SampleTransfers records the transfer rates of each connected device, and
+// the total, since the previous call in the transfer history. It should be
+// called every ProgressS seconds of the device profile.
+func (m *Model) SampleTransfers() {
+	cur := m.ConnectionStats()
+
+	m.tmut.Lock()
+	last := m.lastTransferStats
+	m.lastTransferStats = cur
+	m.tmut.Unlock()
+
+	if last == nil {
+		// Nothing to compare with yet
+		return
+	}
+
+	s := stats.TransferSample{
+		At:    time.Now(),
+		Rates: make(map[string]stats.TransferRate),
+	}
+	for id, ci := range cur {
+		prev, ok := last[id]
+		if !ok || ci.InBytesTotal < prev.InBytesTotal || ci.OutBytesTotal < prev.OutBytesTotal {
+			// A connection made since the last sample; it transferred
+			// nothing before that
+			prev = ConnectionInfo{Statistics: protocol.Statistics{At: last["total"].At}}
+		}
+		secs := ci.At.Sub(prev.At).Seconds()
+		if secs <= 0 {
+			continue
+		}
+		s.Rates[id] = stats.TransferRate{
+			InBytesPerS:  float64(ci.InBytesTotal-prev.InBytesTotal) / secs,
+			OutBytesPerS: float64(ci.OutBytesTotal-prev.OutBytesTotal) / secs,
+		}
+	}
+	m.transfers.Add(s)
+}
+
+// TransferHistory returns the transfer samples taken since the given time,
+// averaged down to at most maxSamples, or all of them if maxSamples is zero.
+func (m *Model) TransferHistory(since time.Time, maxSamples int) []stats.TransferSample {
+	return m.transfers.Samples(since, maxSamples)
+}
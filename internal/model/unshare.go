@@ -0,0 +1,330 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/internal/versioner"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// A device can be asked to stop syncing a folder, for example when it has
+// been lost or retired. The request is remembered in the Unshare attribute
+// of the share until the device is next connected, and the folder is no
+// longer shared with the device once it has been sent. The receiving device
+// acts on it as its RemoteUnshare option says: at once, once the user has
+// confirmed it, or not at all. It then stops syncing the folder with the
+// device that asked, and if also asked to delete it, removes the folder and
+// deletes the files it has in its index, through the versioner if there is
+// one. Files it doesn't know of are left alone, and send only folders are
+// never deleted.
+
+const (
+	UnshareStop   = "stop"
+	UnshareDelete = "delete"
+)
+
+var (
+	ErrNotShared            = errors.New("folder is not shared with the device")
+	ErrNoSuchPendingUnshare = errors.New("no such pending unshare request")
+)
+
+// RequestUnshare asks the device to stop syncing the folder, and to delete
+// its copy of it if deleteFiles is set.
+func (m *Model) RequestUnshare(device protocol.DeviceID, folder string, deleteFiles bool) error {
+	folderCfg := m.cfg.GetFolderConfiguration(folder)
+	if folderCfg == nil {
+		return errors.New("no such folder")
+	}
+	if device == m.id {
+		return ErrNotShared
+	}
+
+	var share *config.FolderDeviceConfiguration
+	for i := range folderCfg.Devices {
+		if folderCfg.Devices[i].DeviceID == device {
+			share = &folderCfg.Devices[i]
+			break
+		}
+	}
+	if share == nil {
+		return ErrNotShared
+	}
+
+	share.Unshare = UnshareStop
+	if deleteFiles {
+		share.Unshare = UnshareDelete
+	}
+	if err := m.cfg.Save(); err != nil {
+		return err
+	}
+	m.log.Infof("Device %v will be asked to stop syncing folder %q when connected", device, folder)
+
+	m.pmut.RLock()
+	_, connected := m.protoConn[device]
+	m.pmut.RUnlock()
+	if connected {
+		go m.deliverUnshares(device)
+	}
+	return nil
+}
+
+// deliverUnshares sends the connected device the unshare requests waiting
+// for it, and stops sharing the folders with it.
+func (m *Model) deliverUnshares(device protocol.DeviceID) {
+	m.pmut.RLock()
+	conn, ok := m.protoConn[device]
+	m.pmut.RUnlock()
+	if !ok {
+		return
+	}
+
+	var changed bool
+	for _, folderCfg := range m.cfgw.Raw().Folders {
+		for _, share := range folderCfg.Devices {
+			if share.DeviceID != device || share.Unshare == "" {
+				continue
+			}
+
+			if err := conn.Unshare(folderCfg.ID, share.Unshare == UnshareDelete); err != nil {
+				m.log.Infof("Asking device %v to stop syncing folder %q: %v", device, folderCfg.ID, err)
+				break
+			}
+			m.log.Infof("Asked device %v to stop syncing folder %q; no longer sharing it", device, folderCfg.ID)
+
+			m.fmut.Lock()
+			m.unshare(folderCfg.ID, device)
+			m.fmut.Unlock()
+			if err := m.removeShare(folderCfg.ID, device, false); err != nil {
+				m.log.Warnf("Stopping sharing folder %q with device %v: %v", folderCfg.ID, device, err)
+			}
+			changed = true
+			break
+		}
+	}
+
+	if changed {
+		m.cfg.Save()
+	}
+}
+
+// Unshare handles a request from the device to stop syncing the folder.
+// Implements the protocol.Model interface.
+func (m *Model) Unshare(deviceID protocol.DeviceID, folder string, deleteFiles bool) {
+	m.fmut.RLock()
+	shared := m.sharedWith(folder, deviceID)
+	m.fmut.RUnlock()
+	if !shared {
+		m.log.Infof("Device %v asked us to stop syncing folder %q, which is not shared with it; ignoring", deviceID, folder)
+		return
+	}
+
+	switch m.cfg.Options.RemoteUnshare {
+	case "accept":
+		m.log.Infof("Device %v asked us to stop syncing folder %q", deviceID, folder)
+		m.ceaseFolder(folder, deviceID, deleteFiles)
+		m.cfg.Save()
+
+	case "refuse":
+		m.log.Infof("Device %v asked us to stop syncing folder %q; refused", deviceID, folder)
+
+	default:
+		m.log.Warnf("Device %v asks us to stop syncing folder %q; awaiting confirmation", deviceID, folder)
+		m.removePendingUnshare(folder)
+		m.cfg.PendingUnshares = append(m.cfg.PendingUnshares, config.PendingUnshareConfiguration{
+			Folder:   folder,
+			DeviceID: deviceID,
+			Delete:   deleteFiles,
+			Time:     time.Now(),
+		})
+		m.cfg.Save()
+		events.Default.Log(events.UnshareRequested, map[string]interface{}{
+			"folder": folder,
+			"device": deviceID.String(),
+			"delete": deleteFiles,
+		})
+	}
+}
+
+// PendingUnshares returns the requests to stop syncing a folder that await
+// confirmation.
+func (m *Model) PendingUnshares() []config.PendingUnshareConfiguration {
+	pending := make([]config.PendingUnshareConfiguration, len(m.cfg.PendingUnshares))
+	copy(pending, m.cfg.PendingUnshares)
+	return pending
+}
+
+// AcceptUnshare stops syncing the folder as requested by the device that
+// asked for it.
+func (m *Model) AcceptUnshare(folder string) error {
+	for _, pending := range m.cfg.PendingUnshares {
+		if pending.Folder == folder {
+			m.log.Infof("Stopping syncing folder %q as requested by device %v (approved)", folder, pending.DeviceID)
+			m.removePendingUnshare(folder)
+			m.ceaseFolder(folder, pending.DeviceID, pending.Delete)
+			return m.cfg.Save()
+		}
+	}
+	return ErrNoSuchPendingUnshare
+}
+
+// DeclineUnshare forgets the request to stop syncing the folder.
+func (m *Model) DeclineUnshare(folder string) error {
+	if !m.removePendingUnshare(folder) {
+		return ErrNoSuchPendingUnshare
+	}
+	m.log.Infof("Declined request to stop syncing folder %q", folder)
+	return m.cfg.Save()
+}
+
+func (m *Model) removePendingUnshare(folder string) bool {
+	for i := range m.cfg.PendingUnshares {
+		if m.cfg.PendingUnshares[i].Folder == folder {
+			m.cfg.PendingUnshares = append(m.cfg.PendingUnshares[:i], m.cfg.PendingUnshares[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ceaseFolder stops sharing the folder with the device that asked for it.
+// With deleteFiles set, the folder is also stopped, removed from the
+// configuration and the files in its index deleted.
+func (m *Model) ceaseFolder(folder string, by protocol.DeviceID, deleteFiles bool) {
+	m.fmut.Lock()
+	folderCfg, ok := m.folderCfgs[folder]
+	if !ok {
+		m.fmut.Unlock()
+		return
+	}
+	if deleteFiles {
+		if err := mayDeleteFolder(folderCfg); err != nil {
+			m.log.Warnf("Device %v asked us to delete folder %q: %v; only no longer sharing it", by, folder, err)
+			deleteFiles = false
+		}
+	}
+	m.unshare(folder, by)
+	var runner service
+	if deleteFiles {
+		runner = m.folderRunners[folder]
+		delete(m.folderRunners, folder)
+	}
+	m.fmut.Unlock()
+
+	if err := m.removeShare(folder, by, deleteFiles); err != nil {
+		m.log.Warnf("Stopping sharing folder %q with device %v: %v", folder, by, err)
+		return
+	}
+
+	if !deleteFiles {
+		return
+	}
+
+	if runner != nil {
+		runner.Stop()
+	}
+	m.log.Warnf("Deleting folder %q at %q as requested by device %v", folder, folderCfg.Path, by)
+	m.deleteFolderFiles(folderCfg)
+	m.invalidateFolder(folder, fmt.Errorf("stopped syncing on request of device %v", by))
+}
+
+// mayDeleteFolder returns an error if the folder must not be deleted on
+// request: if it is send only, and so the original of the files in it, or
+// if its root is not the directory we have been syncing, such as when it is
+// on a disk that is no longer mounted.
+func mayDeleteFolder(cfg config.FolderConfiguration) error {
+	if cfg.Type == config.FolderTypeSendOnly {
+		return errors.New("folder is send only")
+	}
+	if fi, err := os.Stat(cfg.Path); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return errors.New("folder path is not a directory")
+	}
+	return nil
+}
+
+// deleteFolderFiles deletes the files of the folder that are in its index,
+// through its versioner if there is one, and then the directories in the
+// index that are left empty.
+func (m *Model) deleteFolderFiles(cfg config.FolderConfiguration) {
+	m.fmut.RLock()
+	fs := m.folderFiles[cfg.ID]
+	st := m.folderStorage[cfg.ID]
+	m.fmut.RUnlock()
+
+	var ver versioner.Versioner
+	if len(cfg.Versioning.Type) > 0 {
+		if factory, ok := versioner.Factories[cfg.Versioning.Type]; ok {
+			ver = factory(cfg.ID, cfg.Path, cfg.Versioning.Params)
+		}
+	}
+
+	var files, dirs []string
+	fs.WithHaveTruncated(protocol.LocalDeviceID, func(fi protocol.FileIntf) bool {
+		f := fi.(protocol.FileInfoTruncated)
+		switch {
+		case f.IsDeleted() || f.IsInvalid():
+		case f.Flags&protocol.FlagDirectory != 0:
+			dirs = append(dirs, f.Name)
+		default:
+			files = append(files, f.Name)
+		}
+		return true
+	})
+
+	for _, name := range files {
+		var err error
+		if ver != nil {
+			err = osutil.InWritableDir(ver.Archive, filepath.Join(cfg.Path, name))
+		} else {
+			err = st.Remove(name)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			m.log.Infof("Deleting folder %q: %v", cfg.ID, err)
+		}
+	}
+
+	// Deepest first, so that each is empty unless it holds files we don't
+	// know of, which are kept
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, name := range dirs {
+		st.Remove(name)
+	}
+}
+
+// removeShare stops sharing the folder with the device in the
+// configuration, or with removeFolder set removes the folder altogether.
+// The change is made through the wrapper, on a copy of the configuration.
+func (m *Model) removeShare(folder string, device protocol.DeviceID, removeFolder bool) error {
+	to := m.cfgw.Raw()
+	folders := make([]config.FolderConfiguration, 0, len(to.Folders))
+	for _, folderCfg := range to.Folders {
+		if folderCfg.ID == folder {
+			if removeFolder {
+				continue
+			}
+			shares := make([]config.FolderDeviceConfiguration, 0, len(folderCfg.Devices))
+			for _, share := range folderCfg.Devices {
+				if share.DeviceID != device {
+					shares = append(shares, share)
+				}
+			}
+			folderCfg.Devices = shares
+		}
+		folders = append(folders, folderCfg)
+	}
+	to.Folders = folders
+	return m.cfgw.Replace(to).ValidationError
+}
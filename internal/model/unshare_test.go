@@ -0,0 +1,189 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+var device3 = protocol.NewDeviceID([]byte("device3"))
+
+func newUnshareModel(path string) (*Model, *config.Configuration) {
+	cfg := config.New("/tmp/test", device1)
+	cfg.Devices = []config.DeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}, {DeviceID: device3}}
+	cfg.Folders = []config.FolderConfiguration{
+		{
+			ID:      "default",
+			Path:    path,
+			Devices: []config.FolderDeviceConfiguration{{DeviceID: device1}, {DeviceID: device2}, {DeviceID: device3}},
+		},
+	}
+
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	m := NewModel("/tmp", &cfg, "device", "syncthing", "dev", db, nil)
+	m.SetDeviceID(device1)
+	m.AddFolder(cfg.Folders[0])
+	return m, &cfg
+}
+
+func TestRequestUnshare(t *testing.T) {
+	m, cfg := newUnshareModel("testdata")
+
+	if err := m.RequestUnshare(device1, "default", false); err != ErrNotShared {
+		t.Errorf("Unexpected error unsharing with ourselves: %v", err)
+	}
+	if err := m.RequestUnshare(device2, "default", true); err != nil {
+		t.Fatal(err)
+	}
+	if share := cfg.Folders[0].Devices[1]; share.DeviceID != device2 || share.Unshare != UnshareDelete {
+		t.Fatalf("Unshare request not recorded: %+v", share)
+	}
+
+	// The request is delivered once the device is connected
+	m.AddConnection(FakeConnection{id: device2}, FakeConnection{id: device2})
+	m.deliverUnshares(device2)
+	if len(cfg.Folders[0].Devices) != 2 || m.folderSharedWith("default", device2) {
+		t.Errorf("Folder still shared after unshare request: %+v", cfg.Folders[0].Devices)
+	}
+	if !m.folderSharedWith("default", device3) {
+		t.Error("Folder no longer shared with other devices")
+	}
+}
+
+func TestRemoteUnshare(t *testing.T) {
+	m, cfg := newUnshareModel("testdata")
+
+	cfg.Options.RemoteUnshare = "refuse"
+	m.Unshare(device2, "default", false)
+	if !m.folderSharedWith("default", device2) || len(m.PendingUnshares()) != 0 {
+		t.Error("Refused unshare request acted upon")
+	}
+
+	cfg.Options.RemoteUnshare = "confirm"
+	m.Unshare(device2, "default", false)
+	if pending := m.PendingUnshares(); len(pending) != 1 || pending[0].DeviceID != device2 || pending[0].Delete {
+		t.Fatalf("Unexpected pending unshares %+v", pending)
+	}
+	if !m.folderSharedWith("default", device2) {
+		t.Error("Unconfirmed unshare request acted upon")
+	}
+	if err := m.AcceptUnshare("default"); err != nil {
+		t.Fatal(err)
+	}
+	if m.folderSharedWith("default", device2) || len(cfg.Folders[0].Devices) != 2 || len(m.PendingUnshares()) != 0 {
+		t.Errorf("Folder still shared after accepted unshare request: %+v", cfg.Folders[0].Devices)
+	}
+	if !m.folderSharedWith("default", device3) || cfg.Folders[0].Devices[1].DeviceID != device3 {
+		t.Errorf("Folder no longer shared with other devices: %+v", cfg.Folders[0].Devices)
+	}
+	if err := m.DeclineUnshare("default"); err != ErrNoSuchPendingUnshare {
+		t.Errorf("Unexpected error declining a missing request: %v", err)
+	}
+
+	// Requests from devices the folder is not shared with are ignored
+	m.Unshare(device2, "default", false)
+	if len(m.PendingUnshares()) != 0 {
+		t.Error("Unshare request from unrelated device recorded")
+	}
+}
+
+func TestRemoteUnshareDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unshare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "file"), []byte("data"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "unknown"), []byte("data"), 0644)
+
+	m, cfg := newUnshareModel(dir)
+	m.folderFiles["default"].Update(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "sub", Flags: protocol.FlagDirectory | 0755},
+		{Name: filepath.Join("sub", "file"), Flags: 0644},
+	})
+	cfg.Options.RemoteUnshare = "accept"
+	m.Unshare(device2, "default", true)
+
+	// Only the files in the index are deleted
+	if _, err := os.Stat(filepath.Join(dir, "sub")); !os.IsNotExist(err) {
+		t.Errorf("Indexed files not deleted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "unknown")); err != nil {
+		t.Errorf("Unknown file deleted: %v", err)
+	}
+	if cfg.GetFolderConfiguration("default") != nil {
+		t.Error("Folder still configured")
+	}
+	if m.folderSharedWith("default", device2) {
+		t.Error("Folder still shared")
+	}
+}
+
+func TestRemoteUnshareDeleteVersioned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unshare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644)
+
+	m, cfg := newUnshareModel(dir)
+	folderCfg := m.folderCfgs["default"]
+	folderCfg.Versioning = config.VersioningConfiguration{Type: "simple", Params: map[string]string{"keep": "5"}}
+	m.folderCfgs["default"] = folderCfg
+	m.folderFiles["default"].Update(protocol.LocalDeviceID, []protocol.FileInfo{{Name: "file", Flags: 0644}})
+	cfg.Options.RemoteUnshare = "accept"
+	m.Unshare(device2, "default", true)
+
+	if _, err := os.Stat(filepath.Join(dir, "file")); !os.IsNotExist(err) {
+		t.Errorf("File not deleted: %v", err)
+	}
+	if versions, _ := filepath.Glob(filepath.Join(dir, ".stversions", "file*")); len(versions) != 1 {
+		t.Errorf("File not archived: %v", versions)
+	}
+}
+
+func TestRemoteUnshareDeleteRefused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unshare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644)
+
+	missing := filepath.Join(dir, "missing")
+	for _, folderCfg := range []config.FolderConfiguration{
+		{Type: config.FolderTypeSendOnly, Path: dir},
+		{Type: config.FolderTypeSendReceive, Path: missing},
+	} {
+		m, cfg := newUnshareModel(folderCfg.Path)
+		cfg.Folders[0].Type = folderCfg.Type
+		current := m.folderCfgs["default"]
+		current.Type = folderCfg.Type
+		m.folderCfgs["default"] = current
+		m.folderFiles["default"].Update(protocol.LocalDeviceID, []protocol.FileInfo{{Name: "file", Flags: 0644}})
+		cfg.Options.RemoteUnshare = "accept"
+		m.Unshare(device2, "default", true)
+
+		if _, err := os.Stat(filepath.Join(dir, "file")); err != nil {
+			t.Errorf("%s folder at %q deleted: %v", folderCfg.Type, folderCfg.Path, err)
+		}
+		if cfg.GetFolderConfiguration("default") == nil {
+			t.Errorf("%s folder at %q removed", folderCfg.Type, folderCfg.Path)
+		}
+		if m.folderSharedWith("default", device2) || !m.folderSharedWith("default", device3) {
+			t.Errorf("%s folder at %q: not unshared from only the requesting device", folderCfg.Type, folderCfg.Path)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// checkSyncable marks a remote file as invalid if its name cannot be used on
+// this system, so that we never try to pull it. The reason is remembered and
+// logged once, instead of failing on every puller iteration.
+func (m *Model) checkSyncable(folder string, f *protocol.FileInfo) {
+	err := osutil.CheckFilename(f.Name)
+	if err == nil && f.IsSymlink() && !osutil.SymlinksSupported() {
+		err = errSymlinksUnsupported
+	}
+	if err == nil {
+		return
+	}
+
+	if f.IsDeleted() {
+		// It can't possibly exist here, so there's nothing to complain about.
+		m.smut.Lock()
+		delete(m.unsyncable[folder], f.Name)
+		m.smut.Unlock()
+		return
+	}
+
+	f.Flags |= protocol.FlagInvalid
+
+	reason := err.Error()
+	m.smut.Lock()
+	files, ok := m.unsyncable[folder]
+	if !ok {
+		files = make(map[string]string)
+		m.unsyncable[folder] = files
+	}
+	prev, seen := files[f.Name]
+	files[f.Name] = reason
+	m.smut.Unlock()
+
+	if !seen || prev != reason {
+		m.log.Warnf("Folder %q, file %q cannot be synced on this system: %s", folder, f.Name, reason)
+		m.itemError(folder, f.Name, "check", err)
+	}
+}
+
+// Unsyncable returns the files in the folder that cannot be synced on this
+// system, mapped to the reason why.
+func (m *Model) Unsyncable(folder string) map[string]string {
+	m.smut.RLock()
+	defer m.smut.RUnlock()
+
+	res := make(map[string]string, len(m.unsyncable[folder]))
+	for name, reason := range m.unsyncable[folder] {
+		res[name] = reason
+	}
+	return res
+}
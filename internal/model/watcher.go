@@ -0,0 +1,212 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+// A folder with FSWatcher set is watched for changes by the file system
+// notification mechanism of the platform. The changed paths are collected
+// for a while, so that a burst of changes results in one scan, and handed
+// to the folder runner, which scans just those paths. The full rescans at
+// the folder's rescan interval continue to catch anything the watcher
+// misses.
+
+const (
+	defaultWatchDelay = 10 * time.Second
+
+	// More changed paths than this in one batch, and the whole folder is
+	// scanned instead.
+	maxWatchedChanges = 256
+)
+
+var errWatchNotSupported = errors.New("watching for changes is not supported on " + runtime.GOOS)
+
+// A changeWatcher reports the paths that change in a directory tree,
+// relative to its root. It reports the empty path when it may have missed
+// changes, as when its event queue overflowed.
+type changeWatcher interface {
+	Changes() <-chan string
+	Close()
+}
+
+// A folderWatcher collects the changes in a folder and hands them on in
+// batches of paths to scan.
+type folderWatcher struct {
+	folder string
+	delay  time.Duration
+	raw    changeWatcher
+	scans  chan []string
+	stop   chan struct{}
+}
+
+func newFolderWatcher(folder, dir string, delay time.Duration) (*folderWatcher, error) {
+	raw, err := watchDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if delay <= 0 {
+		delay = defaultWatchDelay
+	}
+	w := &folderWatcher{
+		folder: folder,
+		delay:  delay,
+		raw:    raw,
+		scans:  make(chan []string),
+		stop:   make(chan struct{}),
+	}
+	go w.serve()
+	return w, nil
+}
+
+// watchFolder starts watching the folder for changes, if it is set to be
+// watched, and returns nil otherwise.
+func (m *Model) watchFolder(cfg config.FolderConfiguration) *folderWatcher {
+	if !cfg.FSWatcher || cfg.Type == config.FolderTypeReceiveEncrypted {
+		return nil
+	}
+	w, err := newFolderWatcher(cfg.ID, cfg.Path, time.Duration(cfg.FSWatcherDelayS)*time.Second)
+	if err != nil {
+		m.log.Warnf("Folder %q: watching for changes: %v; relying on rescans every %ds.", cfg.ID, err, cfg.RescanIntervalS)
+		return nil
+	}
+	return w
+}
+
+// C returns the channel the batches of paths to scan are sent on. It is nil,
+// and so never ready, for a nil watcher.
+func (w *folderWatcher) C() <-chan []string {
+	if w == nil {
+		return nil
+	}
+	return w.scans
+}
+
+func (w *folderWatcher) Stop() {
+	if w == nil {
+		return
+	}
+	close(w.stop)
+	w.raw.Close()
+}
+
+func (w *folderWatcher) serve() {
+	changed := make(map[string]bool)
+	var timer <-chan time.Time
+	var ready bool
+
+	for {
+		// Once the delay has passed the batch is offered until the runner
+		// takes it, collecting further changes in the meantime.
+		var out chan []string
+		var batch []string
+		if ready {
+			out = w.scans
+			batch = scanPaths(changed)
+		}
+
+		select {
+		case name, ok := <-w.raw.Changes():
+			if !ok {
+				return
+			}
+			name, ok = watchedPath(name)
+			if !ok {
+				continue
+			}
+			if debug() {
+				l.Debugf("watcher/%s: changed %q", w.folder, name)
+			}
+			changed[name] = true
+			if timer == nil && !ready {
+				timer = time.After(w.delay)
+			}
+
+		case <-timer:
+			timer = nil
+			ready = true
+
+		case out <- batch:
+			if debug() {
+				l.Debugf("watcher/%s: scanning %v", w.folder, batch)
+			}
+			changed = make(map[string]bool)
+			ready = false
+
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// watchedPath returns the path to scan for a change to the given path, and
+// whether there is anything to scan at all.
+func watchedPath(name string) (string, bool) {
+	name = filepath.Clean(name)
+	if name == "." {
+		return "", true
+	}
+
+	base := filepath.Base(name)
+	switch {
+	case defTempNamer.IsTemporary(base):
+		return "", false
+	case name == ".stversions" || strings.HasPrefix(name, ".stversions"+string(filepath.Separator)):
+		return "", false
+	case base == ".stignore":
+		// What is ignored may have changed anywhere.
+		return "", true
+	}
+	return name, true
+}
+
+// scanPaths returns the paths to scan for the changed paths: those that are
+// not within another changed path, or only the folder root if there are too
+// many of them.
+func scanPaths(changed map[string]bool) []string {
+	if changed[""] || len(changed) > maxWatchedChanges {
+		return []string{""}
+	}
+
+	var paths []string
+	for name := range changed {
+		if !changedParent(changed, name) {
+			paths = append(paths, name)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func changedParent(changed map[string]bool, name string) bool {
+	for {
+		parent := filepath.Dir(name)
+		if parent == "." || parent == name {
+			return false
+		}
+		if changed[parent] {
+			return true
+		}
+		name = parent
+	}
+}
+
+// scanChanges scans the changed paths of the folder.
+func (m *Model) scanChanges(folder string, subs []string) error {
+	for _, sub := range subs {
+		if err := m.ScanFolderSub(folder, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// inotify watches single directories, so each directory in the tree is
+// watched, and directories that appear are added as they are reported.
+
+const inotifyMask = syscall.IN_ATTRIB | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_DELETE_SELF | syscall.IN_MODIFY | syscall.IN_MOVE_SELF |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+type inotifyWatcher struct {
+	dir     string
+	fd      int
+	file    *os.File
+	changes chan string
+	done    chan struct{}
+
+	mut   sync.Mutex
+	paths map[int32]string // watch descriptor -> directory, relative to dir
+}
+
+func watchDir(dir string) (changeWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		dir:     dir,
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		changes: make(chan string),
+		done:    make(chan struct{}),
+		paths:   make(map[int32]string),
+	}
+	if err := w.addTree(""); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	go w.read()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *inotifyWatcher) Close() {
+	close(w.done)
+	w.file.Close()
+}
+
+// addTree watches the directory and all directories below it.
+func (w *inotifyWatcher) addTree(rel string) error {
+	return filepath.Walk(filepath.Join(w.dir, rel), func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(w.dir, path)
+		if err != nil {
+			return nil
+		}
+		if name == "." {
+			name = ""
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err == syscall.ENOSPC {
+			return fmt.Errorf("too many directories to watch; raise fs.inotify.max_user_watches")
+		} else if err != nil {
+			// The directory may have gone again, or be unreadable; its
+			// changes are picked up by the rescans.
+			return nil
+		}
+		w.mut.Lock()
+		w.paths[int32(wd)] = name
+		w.mut.Unlock()
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) read() {
+	defer close(w.changes)
+
+	var buf [64 << 10]byte
+	for {
+		n, err := w.file.Read(buf[:])
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+			offset = nameEnd
+
+			if !w.handle(event.Wd, event.Mask, name) {
+				return
+			}
+		}
+	}
+}
+
+// handle reports the change described by an event, and returns false once
+// the watcher is closed.
+func (w *inotifyWatcher) handle(wd int32, mask uint32, name string) bool {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return w.report("")
+	}
+
+	w.mut.Lock()
+	dir, ok := w.paths[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+	}
+	w.mut.Unlock()
+	if !ok || mask&syscall.IN_IGNORED != 0 {
+		return true
+	}
+
+	rel := filepath.Join(dir, name)
+	if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		if err := w.addTree(rel); err != nil {
+			l.Infoln("Watching", filepath.Join(w.dir, rel)+":", err)
+		}
+	}
+
+	return w.report(rel)
+}
+
+func (w *inotifyWatcher) report(rel string) bool {
+	select {
+	case w.changes <- rel:
+		return true
+	case <-w.done:
+		return false
+	}
+}
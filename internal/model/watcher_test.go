@@ -0,0 +1,118 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchedPath(t *testing.T) {
+	cases := []struct {
+		name string
+		scan string
+		ok   bool
+	}{
+		{".", "", true},
+		{"foo", "foo", true},
+		{filepath.Join("foo", "bar"), filepath.Join("foo", "bar"), true},
+		{filepath.Join("foo", defTempNamer.TempName("bar")), "", false},
+		{".stversions", "", false},
+		{filepath.Join(".stversions", "foo"), "", false},
+		{".stversionsfoo", ".stversionsfoo", true},
+		{filepath.Join("foo", ".stignore"), "", true},
+	}
+
+	for _, tc := range cases {
+		scan, ok := watchedPath(tc.name)
+		if scan != tc.scan || ok != tc.ok {
+			t.Errorf("watchedPath(%q) = %q, %v; expected %q, %v", tc.name, scan, ok, tc.scan, tc.ok)
+		}
+	}
+}
+
+func TestScanPaths(t *testing.T) {
+	changed := map[string]bool{
+		"b":                                 true,
+		filepath.Join("a", "b"):             true,
+		filepath.Join("a", "b", "c"):        true,
+		filepath.Join("a", "b", "c", "d"):   true,
+		filepath.Join("a", "bc"):            true,
+		filepath.Join("c", "d", "e", "f"):   true,
+		filepath.Join("c", "d", "e", "f.g"): true,
+	}
+	expected := []string{
+		filepath.Join("a", "b"),
+		filepath.Join("a", "bc"),
+		"b",
+		filepath.Join("c", "d", "e", "f"),
+		filepath.Join("c", "d", "e", "f.g"),
+	}
+	if paths := scanPaths(changed); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Incorrect paths to scan %q", paths)
+	}
+
+	changed[""] = true
+	if paths := scanPaths(changed); !reflect.DeepEqual(paths, []string{""}) {
+		t.Errorf("Incorrect paths to scan %q with the root changed", paths)
+	}
+
+	changed = make(map[string]bool)
+	for i := 0; i <= maxWatchedChanges; i++ {
+		changed[string(rune('a'+i%26))+string(rune('a'+i/26))] = true
+	}
+	if paths := scanPaths(changed); !reflect.DeepEqual(paths, []string{""}) {
+		t.Errorf("Incorrect paths to scan %q for many changes", paths)
+	}
+}
+
+func TestFolderWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "existing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := newFolderWatcher("default", dir, 50*time.Millisecond)
+	if err == errWatchNotSupported {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "existing", "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectScan(t, w, []string{filepath.Join("existing", "file")})
+
+	// Files in new directories are reported too
+	if err := os.Mkdir(filepath.Join(dir, "new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	expectScan(t, w, []string{"new"})
+	if err := ioutil.WriteFile(filepath.Join(dir, "new", "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectScan(t, w, []string{filepath.Join("new", "file")})
+}
+
+func expectScan(t *testing.T, w *folderWatcher, expected []string) {
+	select {
+	case paths := <-w.C():
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("Incorrect paths to scan %q, expected %q", paths, expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for %q to be scanned", expected)
+	}
+}
@@ -2,5 +2,10 @@
 // All rights reserved. Use of this source code is governed by an MIT-style
 // license that can be found in the LICENSE file.
 
-// Package protocol implements the Block Exchange Protocol.
-package protocol
+// +build !linux,!windows
+
+package model
+
+func watchDir(dir string) (changeWatcher, error) {
+	return nil, errWatchNotSupported
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// ReadDirectoryChangesW watches a whole tree at once. The reads are
+// overlapped and completed through a completion port, so that the watcher
+// can be closed while waiting for a change.
+
+const (
+	notifyMask = syscall.FILE_NOTIFY_CHANGE_FILE_NAME | syscall.FILE_NOTIFY_CHANGE_DIR_NAME |
+		syscall.FILE_NOTIFY_CHANGE_ATTRIBUTES | syscall.FILE_NOTIFY_CHANGE_SIZE |
+		syscall.FILE_NOTIFY_CHANGE_LAST_WRITE | syscall.FILE_NOTIFY_CHANGE_CREATION
+
+	keyChange = 1
+	keyClose  = 2
+)
+
+type readDirWatcher struct {
+	handle  syscall.Handle
+	port    syscall.Handle
+	changes chan string
+	done    chan struct{}
+}
+
+func watchDir(dir string) (changeWatcher, error) {
+	name, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(name, syscall.FILE_LIST_DIRECTORY,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS|syscall.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return nil, err
+	}
+	port, err := syscall.CreateIoCompletionPort(handle, 0, keyChange, 0)
+	if err != nil {
+		syscall.CloseHandle(handle)
+		return nil, err
+	}
+
+	w := &readDirWatcher{
+		handle:  handle,
+		port:    port,
+		changes: make(chan string),
+		done:    make(chan struct{}),
+	}
+	go w.read()
+	return w, nil
+}
+
+func (w *readDirWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *readDirWatcher) Close() {
+	close(w.done)
+	syscall.PostQueuedCompletionStatus(w.port, 0, keyClose, nil)
+}
+
+func (w *readDirWatcher) read() {
+	defer close(w.changes)
+	defer syscall.CloseHandle(w.port)
+	defer syscall.CloseHandle(w.handle)
+
+	var buf [64 << 10]byte
+	for {
+		var ov syscall.Overlapped
+		if err := syscall.ReadDirectoryChanges(w.handle, &buf[0], uint32(len(buf)), true, notifyMask, nil, &ov, 0); err != nil {
+			return
+		}
+
+		var n, key uint32
+		var done *syscall.Overlapped
+		err := syscall.GetQueuedCompletionStatus(w.port, &n, &key, &done, syscall.INFINITE)
+		if key == keyClose {
+			// Wait for the cancelled read to complete, so that nothing is
+			// written to the buffer after we are gone.
+			if syscall.CancelIoEx(w.handle, &ov) == nil {
+				syscall.GetQueuedCompletionStatus(w.port, &n, &key, &done, syscall.INFINITE)
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		if n == 0 {
+			// The buffer overflowed and the changes were lost.
+			if !w.report("") {
+				return
+			}
+			continue
+		}
+
+		for offset := uint32(0); ; {
+			info := (*syscall.FileNotifyInformation)(unsafe.Pointer(&buf[offset]))
+			chars := info.FileNameLength / 2
+			name := syscall.UTF16ToString((*[1 << 15]uint16)(unsafe.Pointer(&info.FileName))[:chars:chars])
+			if !w.report(name) {
+				return
+			}
+			if info.NextEntryOffset == 0 {
+				break
+			}
+			offset += info.NextEntryOffset
+		}
+	}
+}
+
+func (w *readDirWatcher) report(rel string) bool {
+	select {
+	case w.changes <- rel:
+		return true
+	case <-w.done:
+		return false
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package nat maps ports on the gateways between us and the internet, so
+// that devices outside can connect to us. The port mapping protocols, such
+// as UPnP and NAT-PMP, register themselves in Discoverers.
+package nat
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Protocol string
+
+const (
+	TCP Protocol = "TCP"
+	UDP Protocol = "UDP"
+)
+
+// A Device is a gateway that maps ports.
+type Device interface {
+	// String describes the gateway, for the logs.
+	String() string
+
+	// AddPortMapping asks the gateway to forward the external port to the
+	// internal port on this host, for the given duration or, if zero, for
+	// as long as the gateway allows. It returns the external port mapped,
+	// which may differ from the one asked for.
+	AddPortMapping(protocol Protocol, internalPort, externalPort int, description string, duration time.Duration) (int, error)
+
+	// DeletePortMapping removes a mapping made by AddPortMapping.
+	DeletePortMapping(protocol Protocol, internalPort, externalPort int) error
+}
+
+// Discoverers holds the functions looking for the gateways speaking each
+// port mapping protocol. They return the gateways found within the timeout.
+var Discoverers = map[string]func(timeout time.Duration) []Device{}
+
+// Discover looks for gateways with all the registered protocols at the
+// same time, and returns those found within the timeout, ordered by the
+// name of the protocol.
+func Discover(timeout time.Duration) []Device {
+	var names []string
+	for name := range Discoverers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	found := make([][]Device, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, discover func(time.Duration) []Device) {
+			defer wg.Done()
+			found[i] = discover(timeout)
+		}(i, Discoverers[name])
+	}
+	wg.Wait()
+
+	var devices []Device
+	for i, ds := range found {
+		if debug() {
+			l.Debugf("%s: found %d gateways", names[i], len(ds))
+		}
+		devices = append(devices, ds...)
+	}
+	return devices
+}
@@ -0,0 +1,53 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeDevice string
+
+func (d fakeDevice) String() string {
+	return string(d)
+}
+
+func (d fakeDevice) AddPortMapping(protocol Protocol, internalPort, externalPort int, description string, duration time.Duration) (int, error) {
+	return externalPort, nil
+}
+
+func (d fakeDevice) DeletePortMapping(protocol Protocol, internalPort, externalPort int) error {
+	return nil
+}
+
+func TestDiscover(t *testing.T) {
+	defer func() {
+		delete(Discoverers, "b")
+		delete(Discoverers, "a")
+		delete(Discoverers, "none")
+	}()
+
+	Discoverers["b"] = func(time.Duration) []Device {
+		return []Device{fakeDevice("b1"), fakeDevice("b2")}
+	}
+	Discoverers["a"] = func(timeout time.Duration) []Device {
+		time.Sleep(timeout / 2)
+		return []Device{fakeDevice("a1")}
+	}
+	Discoverers["none"] = func(time.Duration) []Device {
+		return nil
+	}
+
+	devices := Discover(10 * time.Millisecond)
+	if len(devices) != 3 {
+		t.Fatalf("unexpected devices %v", devices)
+	}
+	for i, name := range []string{"a1", "b1", "b2"} {
+		if devices[i].String() != name {
+			t.Errorf("device %d is %v, expected %s", i, devices[i], name)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package netwatch
+
+import "github.com/syncthing/syncthing/internal/logger"
+
+var l = logger.DefaultLogger
+
+func init() {
+	l.NewFacility("netwatch", "Network change notifications")
+}
+
+func debug() bool {
+	return l.ShouldDebug("netwatch")
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package netwatch reports changes to the network interfaces, addresses and
+// routes of the host, such as a VPN coming up or a laptop moving from Wi-Fi
+// to Ethernet.
+package netwatch
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Changes tend to come in bursts; they are reported once there have
+	// been none for this long.
+	settleDelay = 2 * time.Second
+
+	// How often the addresses are compared where the operating system
+	// doesn't tell us about changes.
+	pollInterval = 10 * time.Second
+)
+
+// Watch returns a channel that receives a value after the network has
+// changed. Changes that happen before the previous one has been received
+// are merged into it.
+func Watch() <-chan struct{} {
+	events := make(chan struct{}, 1)
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		err := watchOS(events)
+		if debug() {
+			l.Debugf("netwatch: no change notifications (%v); polling instead", err)
+		}
+		poll(events, pollInterval)
+	}()
+	go settle(events, changes, settleDelay)
+
+	return changes
+}
+
+// notify sends on the channel unless a value is already waiting in it.
+func notify(c chan<- struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// settle passes the events on to out once no more have arrived for delay.
+func settle(in <-chan struct{}, out chan<- struct{}, delay time.Duration) {
+	for _ = range in {
+		timeout := time.After(delay)
+	wait:
+		for {
+			select {
+			case <-in:
+			case <-timeout:
+				break wait
+			}
+		}
+		if debug() {
+			l.Debugln("netwatch: network changed")
+		}
+		notify(out)
+	}
+}
+
+// poll sends an event each time the set of interface addresses differs from
+// the one seen the previous time.
+func poll(events chan<- struct{}, interval time.Duration) {
+	prev := addrKey()
+	for _ = range time.Tick(interval) {
+		if cur := addrKey(); cur != prev {
+			prev = cur
+			notify(events)
+		}
+	}
+}
+
+func addrKey() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	strs := make([]string, len(addrs))
+	for i := range addrs {
+		strs[i] = addrs[i].String()
+	}
+	sort.Strings(strs)
+	return strings.Join(strs, ",")
+}
@@ -0,0 +1,43 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package netwatch
+
+import (
+	"os"
+	"syscall"
+)
+
+// watchOS sends an event for each message about interfaces, addresses and
+// routes on a routing socket. It returns only on failure.
+func watchOS(events chan<- struct{}) error {
+	fd, err := syscall.Socket(syscall.AF_ROUTE, syscall.SOCK_RAW, syscall.AF_UNSPEC)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		// The message type follows the length and version of the header.
+		// Misses and lookups are not changes.
+		if n < 4 {
+			continue
+		}
+		switch buf[3] {
+		case syscall.RTM_ADD, syscall.RTM_DELETE, syscall.RTM_CHANGE,
+			syscall.RTM_NEWADDR, syscall.RTM_DELADDR, syscall.RTM_IFINFO:
+			notify(events)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package netwatch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSettle(t *testing.T) {
+	in := make(chan struct{}, 1)
+	out := make(chan struct{}, 1)
+	go settle(in, out, 100*time.Millisecond)
+
+	// A burst of changes is reported once, after it has settled
+	for i := 0; i < 5; i++ {
+		notify(in)
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case <-out:
+		t.Fatal("Change reported before settling")
+	default:
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-out:
+	default:
+		t.Fatal("Change not reported")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-out:
+		t.Fatal("Burst reported more than once")
+	default:
+	}
+}
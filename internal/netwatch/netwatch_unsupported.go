@@ -0,0 +1,13 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package netwatch
+
+import "errors"
+
+func watchOS(events chan<- struct{}) error {
+	return errors.New("not supported")
+}
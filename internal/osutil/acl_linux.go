@@ -0,0 +1,70 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package osutil
+
+import "syscall"
+
+const (
+	xattrACLAccess  = "system.posix_acl_access"
+	xattrACLDefault = "system.posix_acl_default"
+)
+
+// GetACL returns the access and default POSIX ACLs of the file, in the
+// kernel's extended attribute representation. ACLs that are not set, or not
+// supported by the file system, are returned as nil.
+func GetACL(path string) (access, dflt []byte, err error) {
+	if access, err = getxattr(path, xattrACLAccess); err != nil {
+		return nil, nil, err
+	}
+	if dflt, err = getxattr(path, xattrACLDefault); err != nil {
+		return nil, nil, err
+	}
+	return access, dflt, nil
+}
+
+// SetACL sets the access and default POSIX ACLs of the file, as returned by
+// GetACL. A nil ACL is removed from the file.
+func SetACL(path string, access, dflt []byte) error {
+	if err := setxattr(path, xattrACLAccess, access); err != nil {
+		return err
+	}
+	return setxattr(path, xattrACLDefault, dflt)
+}
+
+func getxattr(path, attr string) ([]byte, error) {
+	for {
+		n, err := syscall.Getxattr(path, attr, nil)
+		if err == syscall.ENODATA || err == syscall.ENOTSUP {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, n)
+		n, err = syscall.Getxattr(path, attr, buf)
+		if err == syscall.ERANGE {
+			// Grew since we asked for the size
+			continue
+		} else if err == syscall.ENODATA || err == syscall.ENOTSUP {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+func setxattr(path, attr string, data []byte) error {
+	if data == nil {
+		err := syscall.Removexattr(path, attr)
+		if err == syscall.ENODATA || err == syscall.ENOTSUP {
+			return nil
+		}
+		return err
+	}
+	return syscall.Setxattr(path, attr, data, 0)
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package osutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestSetGetACL(t *testing.T) {
+	fd, err := ioutil.TempFile("", "acltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+	defer os.Remove(fd.Name())
+
+	// user::rw-, user:1000:r--, group::r--, mask::r--, other::r--
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(2))
+	for _, e := range []struct {
+		tag, perm uint16
+		id        uint32
+	}{{0x01, 6, 0xffffffff}, {0x02, 4, 1000}, {0x04, 4, 0xffffffff}, {0x10, 4, 0xffffffff}, {0x20, 4, 0xffffffff}} {
+		binary.Write(&buf, binary.LittleEndian, e)
+	}
+	acl := buf.Bytes()
+
+	if err := SetACL(fd.Name(), acl, nil); err == syscall.ENOTSUP {
+		t.Skip("ACLs not supported on temp directory")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	access, dflt, err := GetACL(fd.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(access, acl) {
+		t.Errorf("Incorrect access ACL %x != %x", access, acl)
+	}
+	if dflt != nil {
+		t.Errorf("Unexpected default ACL %x", dflt)
+	}
+
+	if err := SetACL(fd.Name(), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	access, _, err = GetACL(fd.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access != nil {
+		t.Errorf("Unexpected access ACL %x after removal", access)
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package osutil
+
+// GetACL returns the access and default POSIX ACLs of the file. They are
+// only supported on Linux, so this always returns nil ACLs.
+func GetACL(path string) (access, dflt []byte, err error) {
+	return nil, nil, nil
+}
+
+// SetACL sets the access and default POSIX ACLs of the file. They are only
+// supported on Linux, so this does nothing.
+func SetACL(path string, access, dflt []byte) error {
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The number of directory listings a CaseChecker keeps before starting over.
+const maxCachedDirs = 1000
+
+// A CaseChecker finds the case that files have on disk. On case insensitive
+// filesystems a file can be opened by a name that differs from the one it
+// is stored under, so the existence of a file is not enough to tell whether
+// a name refers to it. Directory listings are cached, so a CaseChecker
+// should only be used for a short time and is not safe for concurrent use.
+type CaseChecker struct {
+	dirs map[string]map[string]struct{}
+}
+
+func NewCaseChecker() *CaseChecker {
+	return &CaseChecker{
+		dirs: make(map[string]map[string]struct{}),
+	}
+}
+
+// RealCase returns name, which is relative to root, with each path element
+// in the case it has on disk. An error satisfying os.IsNotExist is returned
+// when no such file exists in any case.
+func (c *CaseChecker) RealCase(root, name string) (string, error) {
+	dir := root
+	parts := strings.Split(filepath.Clean(name), string(os.PathSeparator))
+	for i, part := range parts {
+		names, err := c.listDir(dir)
+		if err != nil {
+			return "", err
+		}
+
+		if _, ok := names[part]; !ok {
+			found := false
+			for n := range names {
+				if strings.EqualFold(n, part) {
+					parts[i] = n
+					found = true
+					break
+				}
+			}
+			if !found {
+				// The name may still refer to an existing file when the
+				// filesystem normalizes names in some other way.
+				if _, err := os.Lstat(filepath.Join(dir, part)); err != nil {
+					return "", err
+				}
+			}
+		}
+
+		dir = filepath.Join(dir, parts[i])
+	}
+	return filepath.Join(parts...), nil
+}
+
+// Invalidate forgets all cached directory listings. It must be called after
+// renaming or removing files.
+func (c *CaseChecker) Invalidate() {
+	c.dirs = make(map[string]map[string]struct{})
+}
+
+func (c *CaseChecker) listDir(dir string) (map[string]struct{}, error) {
+	if names, ok := c.dirs[dir]; ok {
+		return names, nil
+	}
+
+	fd, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	list, err := fd.Readdirnames(-1)
+	fd.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{}, len(list))
+	for _, n := range list {
+		names[n] = struct{}{}
+	}
+
+	if len(c.dirs) >= maxCachedDirs {
+		c.Invalidate()
+	}
+	c.dirs[dir] = names
+	return names, nil
+}
+
+// RenameCase renames from to to, where the two names differ only in case.
+// Some case insensitive filesystems ignore such a rename, so it is done in
+// two steps via an intermediate name.
+func RenameCase(from, to string) error {
+	tmp := filepath.Join(filepath.Dir(to), ".syncthing-case."+filepath.Base(to))
+	if err := os.Rename(from, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, to); err != nil {
+		os.Rename(tmp, from)
+		return err
+	}
+	return nil
+}
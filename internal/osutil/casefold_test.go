@@ -0,0 +1,77 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osutil_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncthing/syncthing/internal/osutil"
+)
+
+func TestRealCase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing-case-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "Foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "Foo", "bar.TXT"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := osutil.NewCaseChecker()
+
+	var tests = []struct {
+		name string
+		real string
+	}{
+		{"Foo", "Foo"},
+		{"foo", "Foo"},
+		{filepath.Join("Foo", "bar.TXT"), filepath.Join("Foo", "bar.TXT")},
+		{filepath.Join("FOO", "Bar.txt"), filepath.Join("Foo", "bar.TXT")},
+	}
+
+	for _, tc := range tests {
+		real, err := cases.RealCase(dir, tc.name)
+		if err != nil {
+			t.Errorf("%q: %v", tc.name, err)
+		} else if real != tc.real {
+			t.Errorf("%q: got %q, expected %q", tc.name, real, tc.real)
+		}
+	}
+
+	if _, err := cases.RealCase(dir, filepath.Join("foo", "baz")); !os.IsNotExist(err) {
+		t.Errorf("Unexpected error for nonexistent file: %v", err)
+	}
+}
+
+func TestRenameCase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing-case-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	from := filepath.Join(dir, "readme.md")
+	to := filepath.Join(dir, "README.md")
+	if err := ioutil.WriteFile(from, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := osutil.RenameCase(from, to); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := osutil.NewCaseChecker()
+	if real, err := cases.RealCase(dir, "readme.md"); err != nil || real != "README.md" {
+		t.Errorf("Unexpected name after rename, %q, %v", real, err)
+	}
+}
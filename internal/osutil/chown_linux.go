@@ -0,0 +1,39 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package osutil
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const capChown = 0
+
+// CanChown returns whether we are allowed to give files away to other users;
+// either by being root or by having the CAP_CHOWN capability.
+func CanChown() bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+
+	fd, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+
+	sc := bufio.NewScanner(fd)
+	for sc.Scan() {
+		if fields := strings.Fields(sc.Text()); len(fields) == 2 && fields[0] == "CapEff:" {
+			caps, err := strconv.ParseUint(fields[1], 16, 64)
+			return err == nil && caps&(1<<capChown) != 0
+		}
+	}
+	return false
+}
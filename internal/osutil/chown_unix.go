@@ -0,0 +1,14 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !windows,!linux
+
+package osutil
+
+import "os"
+
+// CanChown returns whether we are allowed to give files away to other users.
+func CanChown() bool {
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,15 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!freebsd,!windows
+
+package osutil
+
+import "errors"
+
+// DiskUsage returns the number of bytes available to us and the total size
+// of the file system containing the given path.
+func DiskUsage(path string) (free, total int64, err error) {
+	return 0, 0, errors.New("disk usage not supported on this platform")
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+var windowsDisallowedCharacters = string([]rune{
+	'<', '>', ':', '"', '|', '?', '*',
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10,
+	11, 12, 13, 14, 15, 16, 17, 18, 19, 20,
+	21, 22, 23, 24, 25, 26, 27, 28, 29, 30,
+	31,
+})
+
+// These are device names on Windows, with or without an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// checkWindowsName returns an error describing why the given relative path
+// cannot be created on Windows, or nil if it can.
+func checkWindowsName(name string) error {
+	for _, part := range strings.FieldsFunc(name, isWindowsSeparator) {
+		if idx := strings.IndexAny(part, windowsDisallowedCharacters); idx >= 0 {
+			return fmt.Errorf("name %q contains the character %q, which is not allowed on Windows", part, part[idx])
+		}
+
+		base := part
+		if idx := strings.IndexByte(base, '.'); idx >= 0 {
+			base = base[:idx]
+		}
+		if windowsReservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+			return fmt.Errorf("name %q is reserved on Windows", part)
+		}
+
+		if part != "." && part != ".." && strings.TrimRight(part, ". ") != part {
+			return fmt.Errorf("name %q ends with a space or period, which is not allowed on Windows", part)
+		}
+	}
+	return nil
+}
+
+func isWindowsSeparator(r rune) bool {
+	return r == '\\' || r == '/'
+}
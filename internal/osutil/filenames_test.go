@@ -0,0 +1,47 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osutil
+
+import "testing"
+
+func TestCheckWindowsName(t *testing.T) {
+	valid := []string{
+		"foo",
+		"foo.txt",
+		`dir\sub\file.txt`,
+		"dir/sub/file.txt",
+		"CONSOLE",
+		"com10",
+		".hidden",
+		"..foo",
+	}
+	for _, name := range valid {
+		if err := checkWindowsName(name); err != nil {
+			t.Errorf("unexpected error for %q: %v", name, err)
+		}
+	}
+
+	invalid := []string{
+		"CON",
+		"con",
+		"nul.txt",
+		"Lpt1.tar.gz",
+		`dir\aux\file.txt`,
+		"AUX /file",
+		"foo.",
+		"foo ",
+		`dir\foo.\file`,
+		"foo:bar",
+		"what?",
+		"a<b>c",
+		`say "hi"`,
+		"tab\there",
+	}
+	for _, name := range invalid {
+		if err := checkWindowsName(name); err == nil {
+			t.Errorf("unexpected nil error for %q", name)
+		}
+	}
+}
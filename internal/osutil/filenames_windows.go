@@ -0,0 +1,13 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package osutil
+
+// CheckFilename returns an error if the given path, relative to a folder
+// root, cannot be created on this system.
+func CheckFilename(name string) error {
+	return checkWindowsName(name)
+}
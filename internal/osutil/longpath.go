@@ -0,0 +1,26 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osutil
+
+import "strings"
+
+// longPathPrefix returns the extended-length form of an absolute Windows
+// path, which is not subject to the MAX_PATH (260 character) limit. Such
+// paths are passed to the file system unmodified, so they must be clean,
+// use backslashes and contain no relative components.
+func longPathPrefix(abs string) string {
+	switch {
+	case strings.HasPrefix(abs, `\\?\`):
+		// Already in extended-length form
+		return abs
+	case strings.HasPrefix(abs, `\\`):
+		// UNC path, \\server\share\... becomes \\?\UNC\server\share\...
+		return `\\?\UNC\` + abs[2:]
+	case len(abs) >= 2 && abs[1] == ':':
+		// Drive letter path
+		return `\\?\` + abs
+	}
+	return abs
+}
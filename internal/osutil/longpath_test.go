@@ -0,0 +1,23 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osutil
+
+import "testing"
+
+func TestLongPathPrefix(t *testing.T) {
+	cases := [][2]string{
+		{`C:\Users\jb\Sync`, `\\?\C:\Users\jb\Sync`},
+		{`\\?\C:\Users\jb\Sync`, `\\?\C:\Users\jb\Sync`},
+		{`\\server\share\dir`, `\\?\UNC\server\share\dir`},
+		{`\\?\UNC\server\share\dir`, `\\?\UNC\server\share\dir`},
+		{`/home/jb/Sync`, `/home/jb/Sync`},
+	}
+
+	for _, tc := range cases {
+		if res := longPathPrefix(tc[0]); res != tc[1] {
+			t.Errorf("longPathPrefix(%q) = %q, expected %q", tc[0], res, tc[1])
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package osutil
+
+// LongPath returns a form of the path that all file operations can use
+// regardless of how long the resulting path is. Only Windows has a path
+// length limit that needs working around, so this is the path itself.
+func LongPath(path string) string {
+	return path
+}
@@ -0,0 +1,22 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package osutil
+
+import "path/filepath"
+
+// LongPath returns a form of the path that all file operations can use
+// regardless of how long the resulting path is. On Windows this is an
+// absolute, extended-length ("\\?\"-prefixed) path. Paths joined onto the
+// result are of the same form, so it's enough to call this on the root of a
+// tree.
+func LongPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return longPathPrefix(abs)
+}
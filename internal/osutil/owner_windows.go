@@ -0,0 +1,20 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package osutil
+
+import "os"
+
+// Owner returns the numeric user and group ID owning the file. There are no
+// such things on Windows.
+func Owner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// CanChown returns whether we are allowed to give files away to other users.
+func CanChown() bool {
+	return false
+}
@@ -0,0 +1,12 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package osutil
+
+// SymlinksSupported returns whether symbolic links can be created.
+func SymlinksSupported() bool {
+	return true
+}
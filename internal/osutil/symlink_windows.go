@@ -0,0 +1,34 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package osutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	symlinksOnce      sync.Once
+	symlinksSupported bool
+)
+
+// SymlinksSupported returns whether symbolic links can be created. Windows
+// XP has no symbolic links, and later versions only let privileged users
+// create them, so we find out by creating one.
+func SymlinksSupported() bool {
+	symlinksOnce.Do(func() {
+		dir, err := ioutil.TempDir("", "syncthing")
+		if err != nil {
+			return
+		}
+		defer os.RemoveAll(dir)
+		symlinksSupported = os.Symlink(dir, filepath.Join(dir, "link")) == nil
+	})
+	return symlinksSupported
+}
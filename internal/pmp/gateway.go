@@ -0,0 +1,103 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pmp
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var privateNetworks = []*net.IPNet{
+	{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+	{IP: net.IP{172, 16, 0, 0}, Mask: net.CIDRMask(12, 32)},
+	{IP: net.IP{192, 168, 0, 0}, Mask: net.CIDRMask(16, 32)},
+}
+
+// gateways returns the addresses our gateways may be at: the default
+// gateways in the routing table, where we can read it, and by convention
+// the first address of each private IPv4 network we are on.
+func gateways() []net.IP {
+	var ips []net.IP
+	seen := make(map[string]bool)
+	add := func(ip net.IP) {
+		if !seen[ip.String()] {
+			seen[ip.String()] = true
+			ips = append(ips, ip)
+		}
+	}
+
+	if bs, err := ioutil.ReadFile("/proc/net/route"); err == nil {
+		for _, ip := range parseRoutes(bs) {
+			add(ip)
+		}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		if debug() {
+			l.Debugln(err)
+		}
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			if ip := firstAddress(ipnet); ip != nil {
+				add(ip)
+			}
+		}
+	}
+	return ips
+}
+
+// parseRoutes returns the gateways of the default routes in a Linux
+// routing table, as in /proc/net/route.
+func parseRoutes(bs []byte) []net.IP {
+	var ips []net.IP
+	sc := bufio.NewScanner(bytes.NewReader(bs))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		// The addresses are hex in host byte order, little endian on
+		// anything we run on.
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil || gw == 0 {
+			continue
+		}
+		ips = append(ips, net.IPv4(byte(gw), byte(gw>>8), byte(gw>>16), byte(gw>>24)).To4())
+	}
+	return ips
+}
+
+// firstAddress returns the first host address of the network, if it is a
+// private IPv4 network, and not our own.
+func firstAddress(ipnet *net.IPNet) net.IP {
+	ip := ipnet.IP.To4()
+	if ip == nil {
+		return nil
+	}
+	private := false
+	for _, n := range privateNetworks {
+		private = private || n.Contains(ip)
+	}
+	if !private {
+		return nil
+	}
+
+	first := ip.Mask(ipnet.Mask)
+	if first == nil {
+		return nil
+	}
+	first[3]++
+	if first.Equal(ip) {
+		return nil
+	}
+	return first
+}
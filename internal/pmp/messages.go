@@ -0,0 +1,175 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/syncthing/syncthing/internal/nat"
+)
+
+const (
+	pcpVersion    = 2
+	natpmpVersion = 0
+
+	pcpOpAnnounce = 0
+	pcpOpMap      = 1
+
+	natpmpOpExternalAddress = 0
+	natpmpOpMapUDP          = 1
+	natpmpOpMapTCP          = 2
+
+	responseBit = 0x80
+
+	pcpHeaderSize = 24
+	pcpMapSize    = pcpHeaderSize + 36
+)
+
+var errUnexpectedResponse = errors.New("unexpected response")
+
+var pcpResults = []string{
+	"success", "unsupported version", "not authorized", "malformed request",
+	"unsupported opcode", "unsupported option", "malformed option",
+	"network failure", "no resources", "unsupported protocol",
+	"user exceeded quota", "cannot provide external", "address mismatch",
+	"excessive remote peers",
+}
+
+var natpmpResults = []string{
+	"success", "unsupported version", "not authorized", "network failure",
+	"out of resources", "unsupported opcode",
+}
+
+// A resultError is a nonzero result code from the gateway.
+type resultError struct {
+	protocol string
+	code     int
+	names    []string
+}
+
+func (e resultError) Error() string {
+	if e.code < len(e.names) {
+		return fmt.Sprintf("%s: %s", e.protocol, e.names[e.code])
+	}
+	return fmt.Sprintf("%s: result code %d", e.protocol, e.code)
+}
+
+// A mapping is the port mapping the gateway made.
+type mapping struct {
+	internalPort int
+	externalPort int
+	lifetime     uint32
+}
+
+// pcpRequest returns the common PCP request header, which is the whole of
+// an ANNOUNCE request.
+func pcpRequest(op byte, lifetime uint32, ourIP net.IP) []byte {
+	bs := make([]byte, pcpHeaderSize)
+	bs[0] = pcpVersion
+	bs[1] = op
+	binary.BigEndian.PutUint32(bs[4:], lifetime)
+	copy(bs[8:24], ourIP.To16())
+	return bs
+}
+
+// pcpMapRequest returns a MAP request, suggesting no particular external
+// address.
+func pcpMapRequest(nonce [12]byte, ourIP net.IP, protocol nat.Protocol, internalPort, externalPort int, lifetime uint32) []byte {
+	bs := append(pcpRequest(pcpOpMap, lifetime, ourIP), make([]byte, pcpMapSize-pcpHeaderSize)...)
+	copy(bs[24:36], nonce[:])
+	bs[36] = ipProtocol(protocol)
+	binary.BigEndian.PutUint16(bs[40:], uint16(internalPort))
+	binary.BigEndian.PutUint16(bs[42:], uint16(externalPort))
+	copy(bs[44:60], net.IPv4zero.To16())
+	return bs
+}
+
+// parsePCPResponse checks the response to the request with the opcode, and
+// returns the mapping made if it was a MAP request.
+func parsePCPResponse(bs []byte, op byte, nonce [12]byte) (mapping, error) {
+	if len(bs) < pcpHeaderSize || bs[0] != pcpVersion || bs[1] != op|responseBit {
+		return mapping{}, errUnexpectedResponse
+	}
+	if code := int(bs[3]); code != 0 {
+		return mapping{}, resultError{"PCP", code, pcpResults}
+	}
+	if op != pcpOpMap {
+		return mapping{}, nil
+	}
+
+	if len(bs) < pcpMapSize || !bytes.Equal(bs[24:36], nonce[:]) {
+		return mapping{}, errUnexpectedResponse
+	}
+	return mapping{
+		internalPort: int(binary.BigEndian.Uint16(bs[40:])),
+		externalPort: int(binary.BigEndian.Uint16(bs[42:])),
+		lifetime:     binary.BigEndian.Uint32(bs[4:]),
+	}, nil
+}
+
+func natpmpExternalAddressRequest() []byte {
+	return []byte{natpmpVersion, natpmpOpExternalAddress}
+}
+
+// parseNATPMPExternalAddress returns the external address of the gateway.
+func parseNATPMPExternalAddress(bs []byte) (net.IP, error) {
+	if err := checkNATPMPResponse(bs, natpmpOpExternalAddress, 12); err != nil {
+		return nil, err
+	}
+	return net.IP(bs[8:12]), nil
+}
+
+func natpmpMapRequest(protocol nat.Protocol, internalPort, externalPort int, lifetime uint32) []byte {
+	bs := make([]byte, 12)
+	bs[0] = natpmpVersion
+	bs[1] = natpmpMapOp(protocol)
+	binary.BigEndian.PutUint16(bs[4:], uint16(internalPort))
+	binary.BigEndian.PutUint16(bs[6:], uint16(externalPort))
+	binary.BigEndian.PutUint32(bs[8:], lifetime)
+	return bs
+}
+
+func parseNATPMPMapResponse(bs []byte, protocol nat.Protocol) (mapping, error) {
+	if err := checkNATPMPResponse(bs, natpmpMapOp(protocol), 16); err != nil {
+		return mapping{}, err
+	}
+	return mapping{
+		internalPort: int(binary.BigEndian.Uint16(bs[8:])),
+		externalPort: int(binary.BigEndian.Uint16(bs[10:])),
+		lifetime:     binary.BigEndian.Uint32(bs[12:]),
+	}, nil
+}
+
+func checkNATPMPResponse(bs []byte, op byte, size int) error {
+	if len(bs) < 4 || bs[0] != natpmpVersion || bs[1] != op|responseBit {
+		return errUnexpectedResponse
+	}
+	if code := int(binary.BigEndian.Uint16(bs[2:])); code != 0 {
+		return resultError{"NAT-PMP", code, natpmpResults}
+	}
+	if len(bs) < size {
+		return errUnexpectedResponse
+	}
+	return nil
+}
+
+func natpmpMapOp(protocol nat.Protocol) byte {
+	if protocol == nat.UDP {
+		return natpmpOpMapUDP
+	}
+	return natpmpOpMapTCP
+}
+
+// ipProtocol returns the IANA protocol number, as used by PCP.
+func ipProtocol(protocol nat.Protocol) byte {
+	if protocol == nat.UDP {
+		return 17
+	}
+	return 6
+}
@@ -0,0 +1,224 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package pmp implements port mappings over the Port Control Protocol (RFC
+// 6887) and its predecessor NAT-PMP (RFC 6886), spoken by Apple routers
+// among others. Gateways speaking PCP are asked over PCP, the others over
+// NAT-PMP.
+package pmp
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/nat"
+)
+
+const (
+	serverPort = 5351
+
+	// The interval at which requests are first sent again, doubling with
+	// each try, as both protocols ask for.
+	initialInterval = 250 * time.Millisecond
+
+	// Neither protocol has permanent mappings; this is asked for when no
+	// duration is given, and the mapping must be renewed before it ends.
+	defaultLifetime = 2 * time.Hour
+)
+
+var errTimeout = errors.New("no response from gateway")
+
+// The nonce identifying our PCP mappings. The gateways are discovered again
+// for each renewal, and a gateway only renews a mapping asked for with the
+// nonce it was made with, so it is the same for the life of the process.
+var nonce [12]byte
+
+// A Gateway maps ports over PCP or NAT-PMP.
+type Gateway struct {
+	addr  *net.UDPAddr // the gateway's server port
+	ourIP net.IP       // our address on the network used to reach it
+	pcp   bool         // whether it speaks PCP; otherwise NAT-PMP
+	nonce [12]byte     // identifies our PCP mappings; see nonce
+
+	timeout time.Duration // for each request
+}
+
+func init() {
+	if _, err := rand.Read(nonce[:]); err != nil {
+		panic(err)
+	}
+
+	nat.Discoverers["pmp"] = func(timeout time.Duration) []nat.Device {
+		var devices []nat.Device
+		for _, gw := range Discover(timeout) {
+			devices = append(devices, gw)
+		}
+		return devices
+	}
+}
+
+// Discover asks the gateways we may be behind whether they speak PCP or
+// NAT-PMP, and returns those answering within the timeout.
+func Discover(timeout time.Duration) []*Gateway {
+	candidates := gateways()
+	found := make([]*Gateway, len(candidates))
+	var wg sync.WaitGroup
+	for i, ip := range candidates {
+		wg.Add(1)
+		go func(i int, ip net.IP) {
+			defer wg.Done()
+			gw, err := probe(&net.UDPAddr{IP: ip, Port: serverPort}, timeout)
+			if err != nil {
+				if debug() {
+					l.Debugf("%v: %v", ip, err)
+				}
+				return
+			}
+			found[i] = gw
+		}(i, ip)
+	}
+	wg.Wait()
+
+	var gws []*Gateway
+	for _, gw := range found {
+		if gw != nil {
+			gws = append(gws, gw)
+		}
+	}
+	return gws
+}
+
+// probe returns the gateway at the address if it answers PCP or NAT-PMP.
+// A gateway speaking only NAT-PMP answers the PCP request with an error
+// of its own version.
+func probe(addr *net.UDPAddr, timeout time.Duration) (*Gateway, error) {
+	ourIP, err := localIP(addr)
+	if err != nil {
+		return nil, err
+	}
+	gw := &Gateway{
+		addr:    addr,
+		ourIP:   ourIP,
+		nonce:   nonce,
+		timeout: timeout,
+	}
+
+	resp, err := gw.request(pcpRequest(pcpOpAnnounce, 0, ourIP))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) > 0 && resp[0] == pcpVersion {
+		if _, err := parsePCPResponse(resp, pcpOpAnnounce, gw.nonce); err != nil {
+			return nil, err
+		}
+		gw.pcp = true
+		return gw, nil
+	}
+
+	resp, err = gw.request(natpmpExternalAddressRequest())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseNATPMPExternalAddress(resp); err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+func (g *Gateway) String() string {
+	if g.pcp {
+		return fmt.Sprintf("PCP gateway at %v", g.addr.IP)
+	}
+	return fmt.Sprintf("NAT-PMP gateway at %v", g.addr.IP)
+}
+
+// AddPortMapping implements nat.Device. A zero duration asks for
+// defaultLifetime.
+func (g *Gateway) AddPortMapping(protocol nat.Protocol, internalPort, externalPort int, description string, duration time.Duration) (int, error) {
+	if duration <= 0 {
+		duration = defaultLifetime
+	}
+	return g.mapPort(protocol, internalPort, externalPort, uint32(duration/time.Second))
+}
+
+// DeletePortMapping implements nat.Device.
+func (g *Gateway) DeletePortMapping(protocol nat.Protocol, internalPort, externalPort int) error {
+	_, err := g.mapPort(protocol, internalPort, 0, 0)
+	return err
+}
+
+// mapPort maps the port for the lifetime in seconds, or deletes the
+// mapping if it is zero, and returns the external port mapped.
+func (g *Gateway) mapPort(protocol nat.Protocol, internalPort, externalPort int, lifetime uint32) (int, error) {
+	if g.pcp {
+		resp, err := g.request(pcpMapRequest(g.nonce, g.ourIP, protocol, internalPort, externalPort, lifetime))
+		if err != nil {
+			return 0, err
+		}
+		m, err := parsePCPResponse(resp, pcpOpMap, g.nonce)
+		if err != nil {
+			return 0, err
+		}
+		return m.externalPort, nil
+	}
+
+	resp, err := g.request(natpmpMapRequest(protocol, internalPort, externalPort, lifetime))
+	if err != nil {
+		return 0, err
+	}
+	m, err := parseNATPMPMapResponse(resp, protocol)
+	if err != nil {
+		return 0, err
+	}
+	return m.externalPort, nil
+}
+
+// request sends the request to the gateway and returns its reply, sending
+// it again at a doubling interval until the timeout.
+func (g *Gateway) request(req []byte) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, g.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(g.timeout)
+	buf := make([]byte, 1100) // the largest PCP message
+	for interval := initialInterval; ; interval *= 2 {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+
+		wait := time.Now().Add(interval)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		conn.SetReadDeadline(wait)
+		n, err := conn.Read(buf)
+		if err == nil {
+			return buf[:n], nil
+		}
+		if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+			// Such as the port being unreachable; nobody is there
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			return nil, errTimeout
+		}
+	}
+}
+
+// localIP returns our address on the network used to reach the gateway.
+func localIP(addr *net.UDPAddr) (net.IP, error) {
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,189 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/nat"
+)
+
+// startTestGateway answers PCP requests, or only NAT-PMP ones, mapping the
+// ports asked for one higher. The requests are passed to seen, if given.
+func startTestGateway(t *testing.T, pcp bool, seen func([]byte)) (*net.UDPAddr, func()) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 1100)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if seen != nil {
+				seen(append([]byte(nil), buf[:n]...))
+			}
+			if resp := testResponse(buf[:n], pcp); resp != nil {
+				conn.WriteToUDP(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr), func() { conn.Close() }
+}
+
+func testResponse(req []byte, pcp bool) []byte {
+	switch {
+	case req[0] == pcpVersion && !pcp:
+		return []byte{natpmpVersion, req[1] | responseBit, 0, 1, 0, 0, 0, 0}
+
+	case req[0] == pcpVersion:
+		resp := make([]byte, len(req))
+		copy(resp, req)
+		resp[1] |= responseBit
+		resp[2], resp[3] = 0, 0
+		if req[1] == pcpOpMap {
+			port := binary.BigEndian.Uint16(req[42:])
+			binary.BigEndian.PutUint16(resp[42:], port+1)
+		}
+		return resp
+
+	case req[1] == natpmpOpExternalAddress:
+		return []byte{natpmpVersion, responseBit, 0, 0, 0, 0, 0, 0, 192, 0, 2, 1}
+
+	default:
+		resp := make([]byte, 16)
+		resp[1] = req[1] | responseBit
+		copy(resp[8:12], req[4:8])
+		port := binary.BigEndian.Uint16(req[6:])
+		binary.BigEndian.PutUint16(resp[10:], port+1)
+		copy(resp[12:], req[8:12])
+		return resp
+	}
+}
+
+func TestGateway(t *testing.T) {
+	for _, pcp := range []bool{true, false} {
+		addr, stop := startTestGateway(t, pcp, nil)
+
+		gw, err := probe(addr, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gw.pcp != pcp {
+			t.Errorf("%v: PCP %v, expected %v", gw, gw.pcp, pcp)
+		}
+
+		port, err := gw.AddPortMapping(nat.TCP, 22000, 23456, "syncthing", 0)
+		if err != nil || port != 23457 {
+			t.Errorf("%v: mapped port %d, %v", gw, port, err)
+		}
+		if err := gw.DeletePortMapping(nat.TCP, 22000, port); err != nil {
+			t.Errorf("%v: %v", gw, err)
+		}
+		stop()
+	}
+}
+
+func TestRenewalNonce(t *testing.T) {
+	var mut sync.Mutex
+	var nonces [][]byte
+	addr, stop := startTestGateway(t, true, func(req []byte) {
+		if req[1] == pcpOpMap {
+			mut.Lock()
+			nonces = append(nonces, req[24:36])
+			mut.Unlock()
+		}
+	})
+	defer stop()
+
+	// The gateway is discovered again for each renewal
+	for i := 0; i < 2; i++ {
+		gw, err := probe(addr, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gw.AddPortMapping(nat.TCP, 22000, 23456, "syncthing", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mut.Lock()
+	defer mut.Unlock()
+	if len(nonces) != 2 || !bytes.Equal(nonces[0], nonces[1]) {
+		t.Errorf("renewal nonces differ: %x", nonces)
+	}
+}
+
+func TestGatewayTimeout(t *testing.T) {
+	// Someone that never answers
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t0 := time.Now()
+	if _, err := probe(conn.LocalAddr().(*net.UDPAddr), 100*time.Millisecond); err != errTimeout {
+		t.Errorf("unexpected error %v", err)
+	}
+	if d := time.Since(t0); d > time.Second {
+		t.Errorf("probe took %v", d)
+	}
+}
+
+func TestResultError(t *testing.T) {
+	resp := make([]byte, pcpMapSize)
+	resp[0], resp[1], resp[3] = pcpVersion, pcpOpMap|responseBit, 8
+	_, err := parsePCPResponse(resp, pcpOpMap, [12]byte{})
+	if err == nil || err.Error() != "PCP: no resources" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	resp = []byte{natpmpVersion, natpmpOpMapTCP | responseBit, 0, 2}
+	_, err = parseNATPMPMapResponse(resp, nat.TCP)
+	if err == nil || err.Error() != "NAT-PMP: not authorized" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes := []byte(`Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0102A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0002A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+wlan0	00000000	FE01000A	0003	0	0	600	00000000	0	0	0
+`)
+	ips := parseRoutes(routes)
+	if len(ips) != 2 || ips[0].String() != "192.168.2.1" || ips[1].String() != "10.0.1.254" {
+		t.Errorf("unexpected gateways %v", ips)
+	}
+}
+
+func TestFirstAddress(t *testing.T) {
+	cases := []struct {
+		addr  string
+		first string
+	}{
+		{"192.168.1.23/24", "192.168.1.1"},
+		{"10.1.2.3/8", "10.0.0.1"},
+		{"192.168.1.1/24", ""},
+		{"198.51.100.7/24", ""},
+		{"fd00::1/64", ""},
+	}
+	for _, tc := range cases {
+		ip, ipnet, _ := net.ParseCIDR(tc.addr)
+		ipnet.IP = ip
+		first := firstAddress(ipnet)
+		if first == nil && tc.first != "" || first != nil && first.String() != tc.first {
+			t.Errorf("%s: first address %v, expected %q", tc.addr, first, tc.first)
+		}
+	}
+}
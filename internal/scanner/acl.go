@@ -0,0 +1,79 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// File attributes holding POSIX ACLs, in the Linux extended attribute
+// representation.
+const (
+	AttrACLAccess  = "posix.acl_access"
+	AttrACLDefault = "posix.acl_default"
+)
+
+// Attribute values are limited in size by the protocol.
+const maxAttributeLen = 1024
+
+// ACLAttributes returns the POSIX ACLs of the file as attributes.
+func ACLAttributes(path string) ([]protocol.Option, error) {
+	access, dflt, err := osutil.GetACL(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var attrs []protocol.Option
+	for _, acl := range []struct {
+		key string
+		val []byte
+	}{{AttrACLAccess, access}, {AttrACLDefault, dflt}} {
+		if acl.val == nil {
+			continue
+		}
+		if len(acl.val) > maxAttributeLen {
+			l.Infof("%s: ACL is too large to be synced (%d entries)", path, len(acl.val)/8)
+			continue
+		}
+		attrs = append(attrs, protocol.Option{Key: acl.key, Value: string(acl.val)})
+	}
+	return attrs, nil
+}
+
+// ApplyACLAttributes sets the POSIX ACLs of the file to those in the
+// attributes, removing any ACL not present.
+func ApplyACLAttributes(path string, f protocol.FileInfo) error {
+	var access, dflt []byte
+	if v, ok := f.GetAttribute(AttrACLAccess); ok {
+		access = []byte(v)
+	}
+	if v, ok := f.GetAttribute(AttrACLDefault); ok {
+		dflt = []byte(v)
+	}
+	return osutil.SetACL(path, access, dflt)
+}
+
+// indexedACLAttributes returns the ACL attributes of the file as indexed.
+func indexedACLAttributes(f protocol.FileInfo) []protocol.Option {
+	var attrs []protocol.Option
+	for _, key := range []string{AttrACLAccess, AttrACLDefault} {
+		if v, ok := f.GetAttribute(key); ok {
+			attrs = append(attrs, protocol.Option{Key: key, Value: v})
+		}
+	}
+	return attrs
+}
+
+func aclsEqual(a, b protocol.FileInfo) bool {
+	for _, key := range []string{AttrACLAccess, AttrACLDefault} {
+		av, aok := a.GetAttribute(key)
+		bv, bok := b.GetAttribute(key)
+		if aok != bok || av != bv {
+			return false
+		}
+	}
+	return true
+}
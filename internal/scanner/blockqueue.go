@@ -8,22 +8,25 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/internal/metrics"
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
 // The parallell hasher reads FileInfo structures from the inbox, hashes the
 // file to populate the Blocks element and sends it to the outbox. A number of
-// workers are used in parallel. The outbox will become closed when the inbox
+// workers are used in parallel, each holding a slot of the limiter, if
+// any, while hashing a file. The outbox will become closed when the inbox
 // is closed and all items handled.
 
-func newParallelHasher(dir string, blockSize, workers int, outbox, inbox chan protocol.FileInfo) {
+func newParallelHasher(dir string, blockSize, workers int, limiter IOLimiter, outbox, inbox chan protocol.FileInfo) {
 	var wg sync.WaitGroup
 	wg.Add(workers)
 
 	for i := 0; i < workers; i++ {
 		go func() {
-			hashFile(dir, blockSize, outbox, inbox)
+			hashFile(dir, blockSize, limiter, outbox, inbox)
 			wg.Done()
 		}()
 	}
@@ -34,16 +37,20 @@ func newParallelHasher(dir string, blockSize, workers int, outbox, inbox chan pr
 	}()
 }
 
-func hashFile(dir string, blockSize int, outbox, inbox chan protocol.FileInfo) {
+func hashFile(dir string, blockSize int, limiter IOLimiter, outbox, inbox chan protocol.FileInfo) {
+	hashMeter := metrics.GetMeter("hash")
+	queueGauge := metrics.GetGauge("hash.queue")
 	for f := range inbox {
-		if protocol.IsDirectory(f.Flags) || protocol.IsDeleted(f.Flags) {
+		queueGauge.Set(int64(len(inbox)))
+
+		if protocol.IsDirectory(f.Flags) || protocol.IsDeleted(f.Flags) || protocol.IsSymlink(f.Flags) {
 			outbox <- f
 			continue
 		}
 
 		fd, err := os.Open(filepath.Join(dir, f.Name))
 		if err != nil {
-			if debug {
+			if debug() {
 				l.Debugln("open:", err)
 			}
 			continue
@@ -52,16 +59,24 @@ func hashFile(dir string, blockSize int, outbox, inbox chan protocol.FileInfo) {
 		fi, err := fd.Stat()
 		if err != nil {
 			fd.Close()
-			if debug {
+			if debug() {
 				l.Debugln("stat:", err)
 			}
 			continue
 		}
-		blocks, err := Blocks(fd, blockSize, fi.Size())
+		if limiter != nil {
+			limiter.Take()
+		}
+		t0 := time.Now()
+		blocks, err := hashFileBlocks(fd, blockSize, fi.Size())
+		hashMeter.Mark(fi.Size(), time.Since(t0))
+		if limiter != nil {
+			limiter.Give()
+		}
 		fd.Close()
 
 		if err != nil {
-			if debug {
+			if debug() {
 				l.Debugln("hash error:", f.Name, err)
 			}
 			continue
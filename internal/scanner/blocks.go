@@ -10,7 +10,7 @@ import (
 	"fmt"
 	"io"
 
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
 const StandardBlockSize = 128 * 1024
@@ -19,44 +19,7 @@ var sha256OfNothing = []uint8{0xe3, 0xb0, 0xc4, 0x42, 0x98, 0xfc, 0x1c, 0x14, 0x
 
 // Blocks returns the blockwise hash of the reader.
 func Blocks(r io.Reader, blocksize int, sizehint int64) ([]protocol.BlockInfo, error) {
-	var blocks []protocol.BlockInfo
-	if sizehint > 0 {
-		blocks = make([]protocol.BlockInfo, 0, int(sizehint/int64(blocksize)))
-	}
-	var offset int64
-	hf := sha256.New()
-	for {
-		lr := &io.LimitedReader{R: r, N: int64(blocksize)}
-		n, err := io.Copy(hf, lr)
-		if err != nil {
-			return nil, err
-		}
-
-		if n == 0 {
-			break
-		}
-
-		b := protocol.BlockInfo{
-			Size:   uint32(n),
-			Offset: offset,
-			Hash:   hf.Sum(nil),
-		}
-		blocks = append(blocks, b)
-		offset += int64(n)
-
-		hf.Reset()
-	}
-
-	if len(blocks) == 0 {
-		// Empty file
-		blocks = append(blocks, protocol.BlockInfo{
-			Offset: 0,
-			Size:   0,
-			Hash:   sha256OfNothing,
-		})
-	}
-
-	return blocks, nil
+	return bufferedBlocks(r, blocksize, sizehint, blocksize)
 }
 
 // BlockDiff returns lists of common and missing (to transform src into tgt)
@@ -90,6 +53,19 @@ func BlockDiff(src, tgt []protocol.BlockInfo) (have, need []protocol.BlockInfo)
 	return have, need
 }
 
+// BlocksEqual returns whether the two block lists describe the same data.
+func BlocksEqual(a, b []protocol.BlockInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Size != b[i].Size || bytes.Compare(a[i].Hash, b[i].Hash) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // Verify returns nil or an error describing the mismatch between the block
 // list and actual reader contents
 func Verify(r io.Reader, blocksize int, blocks []protocol.BlockInfo) error {
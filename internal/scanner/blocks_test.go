@@ -9,7 +9,7 @@ import (
 	"fmt"
 	"testing"
 
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
 var blocksTestData = []struct {
@@ -4,14 +4,14 @@
 
 package scanner
 
-import (
-	"os"
-	"strings"
+import "github.com/syncthing/syncthing/internal/logger"
 
-	"github.com/syncthing/syncthing/internal/logger"
-)
+var l = logger.DefaultLogger
 
-var (
-	debug = strings.Contains(os.Getenv("STTRACE"), "scanner") || os.Getenv("STTRACE") == "all"
-	l     = logger.DefaultLogger
-)
+func init() {
+	l.NewFacility("scanner", "File change detection and hashing")
+}
+
+func debug() bool {
+	return l.ShouldDebug("scanner")
+}
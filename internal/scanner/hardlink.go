@@ -0,0 +1,17 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+// AttrHardLink is set on a file that is a hard link to another file in the
+// same folder. The value is the name of the other file, with forward slashes
+// as separator. Of a set of linked files, the first one in walk order has no
+// attribute and the others point to it.
+const AttrHardLink = "hardlink"
+
+// A fileID identifies a file on a device, for the purpose of detecting hard
+// links.
+type fileID struct {
+	dev, ino uint64
+}
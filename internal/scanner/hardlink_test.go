@@ -0,0 +1,58 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package scanner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalkHardLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hardlinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "a"), filepath.Join(dir, "sub", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "c"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := Walker{
+		Dir:       dir,
+		BlockSize: 128 * 1024,
+	}
+	fchan, err := w.Walk()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets := make(map[string]string)
+	for f := range fchan {
+		if v, ok := f.GetAttribute(AttrHardLink); ok {
+			targets[f.Name] = v
+		}
+	}
+
+	if len(targets) != 1 {
+		t.Fatalf("Incorrect number of links %d != 1: %v", len(targets), targets)
+	}
+	if v := targets[filepath.Join("sub", "b")]; v != "a" {
+		t.Errorf("Incorrect link target %q != %q", v, "a")
+	}
+}
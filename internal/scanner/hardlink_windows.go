@@ -0,0 +1,16 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package scanner
+
+import "os"
+
+// linkedFileID returns the identity of the file, if it has more than one
+// link. The link count isn't available from a directory walk on Windows, so
+// hard links are not detected.
+func linkedFileID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,193 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+var errNoMmap = errors.New("mmap not supported")
+
+// A hashMethod decides how files are read for hashing: using read calls of
+// bufferSize bytes, or by mapping the file into memory.
+type hashMethod struct {
+	bufferSize int
+	mmap       bool
+}
+
+func (m hashMethod) String() string {
+	if m.mmap {
+		return "mmap"
+	}
+	return fmt.Sprintf("read %d KiB", m.bufferSize>>10)
+}
+
+var hashMethods = []hashMethod{
+	{bufferSize: StandardBlockSize},
+	{bufferSize: 1 << 20},
+	{bufferSize: 4 << 20},
+	{mmap: true},
+}
+
+// The amount of data hashed with each method by TuneHashing.
+const tuneSize = 8 << 20
+
+// The method used by the parallel hasher. It is only changed by TuneHashing,
+// which may run while files are being scanned.
+var (
+	currentHashMethod = hashMethods[1]
+	hashMethodMut     sync.Mutex
+)
+
+// hashFileBlocks returns the blockwise hash of the open file using the
+// current hash method.
+func hashFileBlocks(fd *os.File, blocksize int, size int64) ([]protocol.BlockInfo, error) {
+	hashMethodMut.Lock()
+	m := currentHashMethod
+	hashMethodMut.Unlock()
+	return m.blocks(fd, blocksize, size)
+}
+
+func (m hashMethod) blocks(fd *os.File, blocksize int, size int64) ([]protocol.BlockInfo, error) {
+	if m.mmap {
+		blocks, err := mmapBlocks(fd, blocksize, size)
+		if err != errNoMmap {
+			return blocks, err
+		}
+		// Fall back to reading the file
+		m.bufferSize = 1 << 20
+	}
+	return bufferedBlocks(fd, blocksize, size, m.bufferSize)
+}
+
+// bufferedBlocks hashes the reader using reads of a multiple of the block
+// size.
+func bufferedBlocks(r io.Reader, blocksize int, sizehint int64, bufsize int) ([]protocol.BlockInfo, error) {
+	if bufsize < blocksize {
+		bufsize = blocksize
+	}
+	bufsize -= bufsize % blocksize
+	if sizehint >= 0 && sizehint < int64(bufsize) {
+		// No point in a buffer larger than the file, but keep a whole
+		// block so that we notice if the file has grown.
+		bufsize = int(sizehint) + blocksize - int(sizehint)%blocksize
+	}
+
+	var blocks []protocol.BlockInfo
+	if sizehint > 0 {
+		blocks = make([]protocol.BlockInfo, 0, int(sizehint/int64(blocksize))+1)
+	}
+
+	buf := make([]byte, bufsize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		blocks, offset = appendBlockHashes(blocks, buf[:n], blocksize, offset)
+		if err != nil {
+			break
+		}
+	}
+
+	if len(blocks) == 0 {
+		// Empty file
+		blocks = append(blocks, protocol.BlockInfo{
+			Offset: 0,
+			Size:   0,
+			Hash:   sha256OfNothing,
+		})
+	}
+
+	return blocks, nil
+}
+
+// appendBlockHashes hashes data, which starts at the given offset in the
+// file, in blocks of blocksize bytes and appends them to blocks.
+func appendBlockHashes(blocks []protocol.BlockInfo, data []byte, blocksize int, offset int64) ([]protocol.BlockInfo, int64) {
+	for len(data) > 0 {
+		n := blocksize
+		if n > len(data) {
+			n = len(data)
+		}
+		hash := sha256.Sum256(data[:n])
+		blocks = append(blocks, protocol.BlockInfo{
+			Size:   uint32(n),
+			Offset: offset,
+			Hash:   hash[:],
+		})
+		offset += int64(n)
+		data = data[n:]
+	}
+	return blocks, offset
+}
+
+// TuneHashing selects the fastest way of hashing by hashing a temporary file
+// of tuneSize random bytes, which was just written and so is in the cache,
+// twice with each method. It returns the hash rate of the selected method in
+// bytes per second. It takes a while, and may be run in the background.
+func TuneHashing() (float64, error) {
+	fd, err := ioutil.TempFile("", "syncthing-hashing")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+
+	data := make([]byte, tuneSize)
+	rand.Read(data)
+	if _, err := fd.Write(data); err != nil {
+		return 0, err
+	}
+
+	best := hashMethods[1]
+	var bestRate float64
+	for _, m := range hashMethods {
+		// Each method gets two runs, the first of which may pay for
+		// anything not yet in the cache
+		var rate float64
+		for i := 0; i < 2; i++ {
+			if _, err := fd.Seek(0, os.SEEK_SET); err != nil {
+				return 0, err
+			}
+			t0 := time.Now()
+			_, err := m.blocks(fd, StandardBlockSize, tuneSize)
+			if err == errNoMmap {
+				break
+			} else if err != nil {
+				return 0, err
+			}
+			if r := tuneSize / time.Since(t0).Seconds(); r > rate {
+				rate = r
+			}
+		}
+
+		if debug() {
+			l.Debugf("hash method %v: %.01f MiB/s", m, rate/(1<<20))
+		}
+		if rate > bestRate {
+			best, bestRate = m, rate
+		}
+	}
+
+	hashMethodMut.Lock()
+	currentHashMethod = best
+	hashMethodMut.Unlock()
+	if debug() {
+		l.Debugln("selected hash method", best)
+	}
+	return bestRate, nil
+}
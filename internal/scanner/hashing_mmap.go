@@ -0,0 +1,62 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin freebsd openbsd netbsd
+
+package scanner
+
+import (
+	"fmt"
+	"os"
+	rdebug "runtime/debug"
+	"syscall"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// Files are mapped this much at a time, to not run out of address space on
+// 32 bit systems.
+const mmapWindowSize = 64 << 20
+
+// mmapBlocks hashes the file by mapping it into memory, which saves copying
+// the data from the kernel.
+func mmapBlocks(fd *os.File, blocksize int, size int64) (blocks []protocol.BlockInfo, err error) {
+	if size == 0 || blocksize%os.Getpagesize() != 0 {
+		return nil, errNoMmap
+	}
+
+	// Accessing a mapping beyond the end of a file that was truncated
+	// while we hash it causes a fault, which is an error rather than a
+	// crash thanks to this.
+	var data []byte
+	defer rdebug.SetPanicOnFault(rdebug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			syscall.Munmap(data)
+			blocks, err = nil, fmt.Errorf("mmap: %v", r)
+		}
+	}()
+
+	window := mmapWindowSize - mmapWindowSize%blocksize
+	blocks = make([]protocol.BlockInfo, 0, int(size/int64(blocksize))+1)
+	var offset int64
+	for offset < size {
+		n := int64(window)
+		if rest := size - offset; rest < n {
+			n = rest
+		}
+
+		data, err = syscall.Mmap(int(fd.Fd()), offset, int(n), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			if offset == 0 {
+				return nil, errNoMmap
+			}
+			return nil, err
+		}
+		blocks, offset = appendBlockHashes(blocks, data, blocksize, offset)
+		syscall.Munmap(data)
+	}
+
+	return blocks, nil
+}
@@ -0,0 +1,17 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd
+
+package scanner
+
+import (
+	"os"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func mmapBlocks(fd *os.File, blocksize int, size int64) ([]protocol.BlockInfo, error) {
+	return nil, errNoMmap
+}
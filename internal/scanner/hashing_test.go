@@ -0,0 +1,75 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestHashMethods(t *testing.T) {
+	for _, size := range []int{0, 1, StandardBlockSize, 3*StandardBlockSize + 17, 9 << 20} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		expected, err := Blocks(bytes.NewReader(data), StandardBlockSize, int64(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fd, err := ioutil.TempFile("", "hashing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(fd.Name())
+		defer fd.Close()
+		if _, err := fd.Write(data); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, m := range hashMethods {
+			fd.Seek(0, os.SEEK_SET)
+			blocks, err := m.blocks(fd, StandardBlockSize, int64(size))
+			if err != nil {
+				t.Errorf("%v, %d bytes: %v", m, size, err)
+				continue
+			}
+			if !reflect.DeepEqual(blocks, expected) {
+				t.Errorf("%v, %d bytes: blocks differ", m, size)
+			}
+		}
+	}
+}
+
+func TestTuneHashing(t *testing.T) {
+	rate, err := TuneHashing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate <= 0 {
+		t.Errorf("unexpected hash rate %f", rate)
+	}
+}
+
+func BenchmarkHashFile(b *testing.B) {
+	fd, err := ioutil.TempFile("", "hashing")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+	fd.Write(make([]byte, 16<<20))
+
+	b.SetBytes(16 << 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fd.Seek(0, os.SEEK_SET)
+		hashFileBlocks(fd, StandardBlockSize, 16<<20)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/syncthing/syncthing/internal/lamport"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// A LinkPolicy decides how the walker treats symbolic links and junctions.
+type LinkPolicy string
+
+const (
+	LinkSync   LinkPolicy = "sync"   // the link itself is synced; the default for symbolic links
+	LinkSkip   LinkPolicy = "skip"   // the link is ignored; the default for junctions
+	LinkFollow LinkPolicy = "follow" // the target is scanned in place of the link
+	LinkError  LinkPolicy = "error"  // the scan fails
+)
+
+type linkKind int
+
+const (
+	notLink linkKind = iota
+	symlinkLink
+	junctionLink
+)
+
+func (k linkKind) String() string {
+	if k == junctionLink {
+		return "junction"
+	}
+	return "symbolic link"
+}
+
+// walkLink handles a symbolic link or junction according to the policy for
+// its kind. Synced symbolic links are sent on fchan. Followed links are
+// walked with walkFn under the name of the link. Each target directory is
+// only followed once, which also stops links pointing back into the tree
+// from looping.
+func (w *Walker) walkLink(p, rn string, info os.FileInfo, kind linkKind, walkFn filepath.WalkFunc, fchan chan protocol.FileInfo) error {
+	policy := w.Symlinks
+	if policy == "" {
+		policy = LinkSync
+	}
+	if kind == junctionLink {
+		policy = w.Junctions
+	}
+
+	switch policy {
+	case LinkSync:
+		if kind == symlinkLink {
+			w.syncSymlink(p, rn, info, fchan)
+			return nil
+		}
+		// Junctions can't be recreated elsewhere
+		if debug() {
+			l.Debugln("skipping", kind, rn)
+		}
+		return nil
+	case LinkFollow:
+	case LinkError:
+		w.err = fmt.Errorf("%v %q not allowed by folder policy", kind, rn)
+		return w.err
+	default:
+		if debug() {
+			l.Debugln("skipping", kind, rn)
+		}
+		return nil
+	}
+
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		w.Logger.Or().Infof("Not following %v %q: %v", kind, rn, err)
+		return nil
+	}
+	info, err = os.Stat(target)
+	if err != nil {
+		w.Logger.Or().Infof("Not following %v %q: %v", kind, rn, err)
+		return nil
+	}
+	if !info.IsDir() {
+		return walkFn(p, info, nil)
+	}
+
+	if w.followed[target] {
+		if debug() {
+			l.Debugf("not following %v %q: %q is already scanned", kind, rn, target)
+		}
+		return nil
+	}
+	w.followed[target] = true
+
+	return filepath.Walk(target, func(tp string, info os.FileInfo, err error) error {
+		rel, rerr := filepath.Rel(target, tp)
+		if rerr != nil {
+			return nil
+		}
+		return walkFn(filepath.Join(p, rel), info, err)
+	})
+}
+
+// syncSymlink sends the symbolic link on fchan, unless it has the same
+// target as at the last scan. The target is the contents of the file, so
+// that it is transferred and verified like any other data.
+func (w *Walker) syncSymlink(p, rn string, info os.FileInfo, fchan chan protocol.FileInfo) {
+	target, err := os.Readlink(p)
+	if err != nil {
+		w.Logger.Or().Infof("Not syncing symbolic link %q: %v", rn, err)
+		return
+	}
+	target = filepath.ToSlash(target)
+
+	blocks, _ := Blocks(strings.NewReader(target), w.BlockSize, int64(len(target)))
+
+	if w.CurrentFiler != nil {
+		cf := w.CurrentFiler.CurrentFile(rn)
+		if !cf.IsDeleted() && cf.IsSymlink() && BlocksEqual(cf.Blocks, blocks) {
+			return
+		}
+	}
+
+	f := protocol.FileInfo{
+		Name:    rn,
+		Version: lamport.Default.Tick(0),
+		Flags:   protocol.FlagSymlink | protocol.FlagNoPermBits | 0666,
+		Blocks:  blocks,
+	}
+	f.SetModTime(info.ModTime())
+	if debug() {
+		l.Debugln("symlink:", f, target)
+	}
+	fchan <- f
+}
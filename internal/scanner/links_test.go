@@ -0,0 +1,147 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package scanner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestWalkSymlinkPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symlinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "real", "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", filepath.Join(dir, "real", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		policy LinkPolicy
+		names  []string
+	}{
+		{"", []string{"link", "real", "real/file", "real/loop"}},
+		{LinkSync, []string{"link", "real", "real/file", "real/loop"}},
+		{LinkSkip, []string{"real", "real/file"}},
+		// "loop" points back at the root, which is not walked again
+		{LinkFollow, []string{"link", "link/file", "real", "real/file"}},
+	}
+
+	for _, tc := range tests {
+		w := Walker{
+			Dir:       dir,
+			BlockSize: 128 * 1024,
+			Symlinks:  tc.policy,
+		}
+		fchan, err := w.Walk()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for f := range fchan {
+			names = append(names, filepath.ToSlash(f.Name))
+		}
+		if err := w.Err(); err != nil {
+			t.Errorf("%q: unexpected error %v", tc.policy, err)
+		}
+		sort.Strings(names)
+		if len(names) != len(tc.names) {
+			t.Errorf("%q: incorrect files %v != %v", tc.policy, names, tc.names)
+			continue
+		}
+		for i := range names {
+			if names[i] != tc.names[i] {
+				t.Errorf("%q: incorrect files %v != %v", tc.policy, names, tc.names)
+				break
+			}
+		}
+	}
+
+	w := Walker{
+		Dir:       dir,
+		BlockSize: 128 * 1024,
+		Symlinks:  LinkError,
+	}
+	fchan, err := w.Walk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _ = range fchan {
+	}
+	if w.Err() == nil {
+		t.Error("Expected an error for a symlink with the error policy")
+	}
+}
+
+type fakeCurrentFiler map[string]protocol.FileInfo
+
+func (f fakeCurrentFiler) CurrentFile(name string) protocol.FileInfo {
+	return f[name]
+}
+
+func TestWalkSyncedSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symlinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Symlink("../target", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	w := Walker{
+		Dir:       dir,
+		BlockSize: 128 * 1024,
+	}
+	fchan, err := w.Walk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []protocol.FileInfo
+	for f := range fchan {
+		files = append(files, f)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected one file, not %v", files)
+	}
+	f := files[0]
+	if f.Name != "link" || !f.IsSymlink() || protocol.IsDirectory(f.Flags) || protocol.HasPermissionBits(f.Flags) {
+		t.Errorf("Incorrect link %v", f)
+	}
+	blocks, _ := Blocks(strings.NewReader("../target"), 128*1024, -1)
+	if !BlocksEqual(f.Blocks, blocks) {
+		t.Errorf("Link target not hashed as data: %v", f.Blocks)
+	}
+
+	// An unchanged link is not reported again
+	w.CurrentFiler = fakeCurrentFiler{"link": f}
+	fchan, err = w.Walk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for f := range fchan {
+		t.Errorf("Unexpected rescan of %v", f)
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package scanner
+
+import "os"
+
+func fileLinkKind(path string, info os.FileInfo) linkKind {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return symlinkLink
+	}
+	return notLink
+}
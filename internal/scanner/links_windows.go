@@ -0,0 +1,46 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package scanner
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	ioReparseTagMountPoint = 0xA0000003
+	ioReparseTagSymlink    = 0xA000000C
+)
+
+// fileLinkKind looks at the reparse tag of reparse points, as junctions are
+// reported as plain directories by some versions of os.Lstat. Other kinds
+// of reparse points, such as deduplicated or cloud files, are regular files.
+func fileLinkKind(path string, info os.FileInfo) linkKind {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok || data.FileAttributes&syscall.FILE_ATTRIBUTE_REPARSE_POINT == 0 {
+		return notLink
+	}
+
+	pathp, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return notLink
+	}
+	var fd syscall.Win32finddata
+	h, err := syscall.FindFirstFile(pathp, &fd)
+	if err != nil {
+		return notLink
+	}
+	syscall.FindClose(h)
+
+	switch fd.Reserved0 {
+	case ioReparseTagMountPoint:
+		return junctionLink
+	case ioReparseTagSymlink:
+		return symlinkLink
+	}
+	return notLink
+}
@@ -0,0 +1,95 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// File attributes holding the ownership of a file. The names are set when
+// they can be looked up, so that the receiving device can map by name.
+const (
+	AttrOwnerUID   = "owner.uid"
+	AttrOwnerGID   = "owner.gid"
+	AttrOwnerUser  = "owner.user"
+	AttrOwnerGroup = "owner.group"
+)
+
+// ownerNames caches user and group name lookups during a walk.
+type ownerNames struct {
+	users  map[int]string
+	groups map[int]string
+}
+
+func newOwnerNames() *ownerNames {
+	return &ownerNames{
+		users:  make(map[int]string),
+		groups: make(map[int]string),
+	}
+}
+
+func (n *ownerNames) user(uid int) string {
+	name, ok := n.users[uid]
+	if !ok {
+		if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+			name = u.Username
+		}
+		n.users[uid] = name
+	}
+	return name
+}
+
+func (n *ownerNames) group(gid int) string {
+	name, ok := n.groups[gid]
+	if !ok {
+		if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+			name = g.Name
+		}
+		n.groups[gid] = name
+	}
+	return name
+}
+
+// attributes returns the ownership of the file as attributes.
+func (n *ownerNames) attributes(info os.FileInfo) []protocol.Option {
+	uid, gid, ok := osutil.Owner(info)
+	if !ok {
+		return nil
+	}
+
+	attrs := []protocol.Option{
+		{Key: AttrOwnerUID, Value: strconv.Itoa(uid)},
+		{Key: AttrOwnerGID, Value: strconv.Itoa(gid)},
+	}
+	if name := n.user(uid); name != "" {
+		attrs = append(attrs, protocol.Option{Key: AttrOwnerUser, Value: name})
+	}
+	if name := n.group(gid); name != "" {
+		attrs = append(attrs, protocol.Option{Key: AttrOwnerGroup, Value: name})
+	}
+	return attrs
+}
+
+// OwnerAttributes returns the ownership of the file as attributes, as a
+// walk syncing ownership would record it.
+func OwnerAttributes(info os.FileInfo) []protocol.Option {
+	return newOwnerNames().attributes(info)
+}
+
+func ownerEqual(a, b protocol.FileInfo) bool {
+	for _, key := range []string{AttrOwnerUID, AttrOwnerGID} {
+		av, aok := a.GetAttribute(key)
+		bv, bok := b.GetAttribute(key)
+		if aok != bok || av != bv {
+			return false
+		}
+	}
+	return true
+}
@@ -9,12 +9,14 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"code.google.com/p/go.text/unicode/norm"
 
 	"github.com/syncthing/syncthing/internal/ignore"
 	"github.com/syncthing/syncthing/internal/lamport"
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/internal/logger"
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
 type Walker struct {
@@ -34,6 +36,30 @@ type Walker struct {
 	// detected. Scanned files will get zero permission bits and the
 	// NoPermissionBits flag set.
 	IgnorePerms bool
+	// If ACLs is true, the POSIX ACLs of files and directories are scanned
+	// and changes to them detected.
+	ACLs bool
+	// If Ownership is true, the owning user and group of files and
+	// directories are scanned and changes to them detected.
+	Ownership bool
+	// Symlinks and Junctions decide how symbolic links and NTFS junctions
+	// are handled. Symbolic links are synced and junctions skipped by
+	// default.
+	Symlinks  LinkPolicy
+	Junctions LinkPolicy
+	// Hashers is the number of files hashed in parallel, or one per CPU
+	// if zero.
+	Hashers int
+	// If DiskIO is not nil, a slot is taken from it while hashing each
+	// file, sharing the disk with other work.
+	DiskIO IOLimiter
+	// Logger receives messages about files that cannot be scanned. Nil
+	// means the default logger.
+	Logger *logger.Logger
+
+	owners   *ownerNames
+	followed map[string]bool // link targets walked
+	err      error
 }
 
 type TempNamer interface {
@@ -48,10 +74,17 @@ type CurrentFiler interface {
 	CurrentFile(name string) protocol.FileInfo
 }
 
+type IOLimiter interface {
+	// Take waits for a free slot. It must be followed by a call to Give.
+	Take()
+	// Give returns the slot.
+	Give()
+}
+
 // Walk returns the list of files found in the local folder by scanning the
 // file system. Files are blockwise hashed.
 func (w *Walker) Walk() (chan protocol.FileInfo, error) {
-	if debug {
+	if debug() {
 		l.Debugln("Walk", w.Dir, w.Sub, w.BlockSize, w.Ignores)
 	}
 
@@ -60,12 +93,25 @@ func (w *Walker) Walk() (chan protocol.FileInfo, error) {
 		return nil, err
 	}
 
+	if w.Ownership {
+		w.owners = newOwnerNames()
+	}
+
+	w.followed = make(map[string]bool)
+	if root, err := filepath.EvalSymlinks(w.Dir); err == nil {
+		w.followed[root] = true
+	}
+
 	files := make(chan protocol.FileInfo)
 	hashedFiles := make(chan protocol.FileInfo)
-	newParallelHasher(w.Dir, w.BlockSize, runtime.NumCPU(), hashedFiles, files)
+	hashers := w.Hashers
+	if hashers <= 0 {
+		hashers = runtime.NumCPU()
+	}
+	newParallelHasher(w.Dir, w.BlockSize, hashers, w.DiskIO, hashedFiles, files)
 
 	go func() {
-		hashFiles := w.walkAndHashFiles(files)
+		hashFiles := w.walkAndHashFiles(files, make(map[fileID]string))
 		filepath.Walk(filepath.Join(w.Dir, w.Sub), hashFiles)
 		close(files)
 	}()
@@ -73,10 +119,17 @@ func (w *Walker) Walk() (chan protocol.FileInfo, error) {
 	return hashedFiles, nil
 }
 
-func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFunc {
-	return func(p string, info os.FileInfo, err error) error {
+// Err returns the error that stopped the walk, if any. It must only be
+// called after the channel returned by Walk has been closed.
+func (w *Walker) Err() error {
+	return w.err
+}
+
+func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo, links map[fileID]string) filepath.WalkFunc {
+	var walkFn filepath.WalkFunc
+	walkFn = func(p string, info os.FileInfo, err error) error {
 		if err != nil {
-			if debug {
+			if debug() {
 				l.Debugln("error:", p, info, err)
 			}
 			return nil
@@ -84,7 +137,7 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 
 		rn, err := filepath.Rel(w.Dir, p)
 		if err != nil {
-			if debug {
+			if debug() {
 				l.Debugln("rel error:", p, err)
 			}
 			return nil
@@ -96,7 +149,7 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 
 		if w.TempNamer != nil && w.TempNamer.IsTemporary(rn) {
 			// A temporary file
-			if debug {
+			if debug() {
 				l.Debugln("temporary:", rn)
 			}
 			return nil
@@ -104,7 +157,7 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 
 		if sn := filepath.Base(rn); sn == ".stignore" || sn == ".stversions" || w.Ignores.Match(rn) {
 			// An ignored file
-			if debug {
+			if debug() {
 				l.Debugln("ignored:", rn)
 			}
 			if info.IsDir() {
@@ -114,15 +167,46 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 		}
 
 		if (runtime.GOOS == "linux" || runtime.GOOS == "windows") && !norm.NFC.IsNormalString(rn) {
-			l.Warnf("File %q contains non-NFC UTF-8 sequences and cannot be synced. Consider renaming.", rn)
+			w.Logger.Or().Warnf("File %q contains non-NFC UTF-8 sequences and cannot be synced. Consider renaming.", rn)
+			return nil
+		}
+
+		if kind := fileLinkKind(p, info); kind != notLink {
+			if err := w.walkLink(p, rn, info, kind, walkFn, fchan); err != nil {
+				return err
+			}
+			if info.IsDir() {
+				// Junctions look like directories to filepath.Walk on some
+				// versions of Go
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
+		var attrs []protocol.Option
+		if w.ACLs {
+			if attrs, err = ACLAttributes(p); err != nil {
+				if debug() {
+					l.Debugln("acl error:", p, err)
+				}
+				// Not being able to read the ACLs is no reason to announce
+				// that they have been removed
+				if w.CurrentFiler != nil {
+					attrs = indexedACLAttributes(w.CurrentFiler.CurrentFile(rn))
+				}
+			}
+		}
+		if w.Ownership {
+			attrs = append(attrs, w.owners.attributes(info)...)
+		}
+
 		if info.Mode().IsDir() {
 			if w.CurrentFiler != nil {
 				cf := w.CurrentFiler.CurrentFile(rn)
 				permUnchanged := w.IgnorePerms || !protocol.HasPermissionBits(cf.Flags) || PermsEqual(cf.Flags, uint32(info.Mode()))
-				if !protocol.IsDeleted(cf.Flags) && protocol.IsDirectory(cf.Flags) && permUnchanged {
+				aclUnchanged := !w.ACLs || aclsEqual(cf, protocol.FileInfo{Attributes: attrs})
+				ownerUnchanged := !w.Ownership || ownerEqual(cf, protocol.FileInfo{Attributes: attrs})
+				if !protocol.IsDeleted(cf.Flags) && protocol.IsDirectory(cf.Flags) && permUnchanged && aclUnchanged && ownerUnchanged {
 					return nil
 				}
 			}
@@ -134,12 +218,13 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 				flags |= uint32(info.Mode() & os.ModePerm)
 			}
 			f := protocol.FileInfo{
-				Name:     rn,
-				Version:  lamport.Default.Tick(0),
-				Flags:    flags,
-				Modified: info.ModTime().Unix(),
+				Name:       rn,
+				Version:    lamport.Default.Tick(0),
+				Flags:      flags,
+				Attributes: attrs,
 			}
-			if debug {
+			f.SetModTime(info.ModTime())
+			if debug() {
 				l.Debugln("dir:", f)
 			}
 			fchan <- f
@@ -147,14 +232,28 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 		}
 
 		if info.Mode().IsRegular() {
+			// Hard links are recorded when the file is scanned for some other
+			// reason, but don't in themselves cause a rescan. Devices that
+			// can't recreate the link would otherwise have a different
+			// opinion about the file than we do.
+			if id, ok := linkedFileID(info); ok {
+				if target, ok := links[id]; ok {
+					attrs = append(attrs, protocol.Option{Key: AttrHardLink, Value: target})
+				} else {
+					links[id] = filepath.ToSlash(rn)
+				}
+			}
+
 			if w.CurrentFiler != nil {
 				cf := w.CurrentFiler.CurrentFile(rn)
 				permUnchanged := w.IgnorePerms || !protocol.HasPermissionBits(cf.Flags) || PermsEqual(cf.Flags, uint32(info.Mode()))
-				if !protocol.IsDeleted(cf.Flags) && cf.Modified == info.ModTime().Unix() && permUnchanged {
+				aclUnchanged := !w.ACLs || aclsEqual(cf, protocol.FileInfo{Attributes: attrs})
+				ownerUnchanged := !w.Ownership || ownerEqual(cf, protocol.FileInfo{Attributes: attrs})
+				if !protocol.IsDeleted(cf.Flags) && !cf.IsSymlink() && modTimeEqual(cf.ModTime(), info.ModTime()) && permUnchanged && aclUnchanged && ownerUnchanged {
 					return nil
 				}
 
-				if debug {
+				if debug() {
 					l.Debugln("rescan:", cf, info.ModTime().Unix(), info.Mode()&os.ModePerm)
 				}
 			}
@@ -164,16 +263,19 @@ func (w *Walker) walkAndHashFiles(fchan chan protocol.FileInfo) filepath.WalkFun
 				flags = protocol.FlagNoPermBits | 0666
 			}
 
-			fchan <- protocol.FileInfo{
-				Name:     rn,
-				Version:  lamport.Default.Tick(0),
-				Flags:    flags,
-				Modified: info.ModTime().Unix(),
+			f := protocol.FileInfo{
+				Name:       rn,
+				Version:    lamport.Default.Tick(0),
+				Flags:      flags,
+				Attributes: attrs,
 			}
+			f.SetModTime(info.ModTime())
+			fchan <- f
 		}
 
 		return nil
 	}
+	return walkFn
 }
 
 func checkDir(dir string) error {
@@ -181,12 +283,29 @@ func checkDir(dir string) error {
 		return err
 	} else if !info.IsDir() {
 		return errors.New(dir + ": not a directory")
-	} else if debug {
+	} else if debug() {
 		l.Debugln("checkDir", dir, info)
 	}
 	return nil
 }
 
+// modTimeEqual compares the modification time from the index with the one
+// on disk. Only whole seconds are compared if either has no sub-second part,
+// as is the case for files indexed by older versions or stored on file
+// systems with less precision. Otherwise they are compared at the precision
+// the time on disk appears to have, as a time set from another device is
+// truncated by file systems keeping microseconds or less.
+func modTimeEqual(indexed, disk time.Time) bool {
+	if indexed.Nanosecond() == 0 || disk.Nanosecond() == 0 {
+		return indexed.Unix() == disk.Unix()
+	}
+	precision := time.Nanosecond
+	for ns := disk.Nanosecond(); ns%10 == 0; ns /= 10 {
+		precision *= 10
+	}
+	return indexed.Truncate(precision).Equal(disk)
+}
+
 func PermsEqual(a, b uint32) bool {
 	switch runtime.GOOS {
 	case "windows":
@@ -12,9 +12,10 @@ import (
 	rdebug "runtime/debug"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/syncthing/syncthing/internal/ignore"
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
 type testfile struct {
@@ -214,3 +215,32 @@ func (l testfileList) String() string {
 	b.WriteString("}")
 	return b.String()
 }
+
+func TestModTimeEqual(t *testing.T) {
+	at := func(sec, nsec int64) time.Time {
+		return time.Unix(1400000000+sec, nsec)
+	}
+
+	cases := []struct {
+		indexed, disk time.Time
+		equal         bool
+	}{
+		{at(0, 123456789), at(0, 123456789), true},
+		{at(0, 123456789), at(0, 123456788), false},
+		// Truncated to whole seconds, microseconds and milliseconds
+		{at(0, 123456789), at(0, 0), true},
+		{at(0, 123456789), at(0, 123456000), true},
+		{at(0, 123456789), at(0, 123000000), true},
+		{at(0, 0), at(0, 123456789), true},
+		// But not to something else
+		{at(0, 123456789), at(0, 124000000), false},
+		{at(0, 123456789), at(1, 123456000), false},
+		{at(0, 0), at(1, 0), false},
+	}
+
+	for i, tc := range cases {
+		if eq := modTimeEqual(tc.indexed, tc.disk); eq != tc.equal {
+			t.Errorf("%d: modTimeEqual(%v, %v) = %v, expected %v", i, tc.indexed, tc.disk, eq, tc.equal)
+		}
+	}
+}
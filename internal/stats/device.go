@@ -5,27 +5,55 @@
 package stats
 
 import (
+	"encoding/binary"
+	"sync"
 	"time"
 
-	"github.com/syncthing/syncthing/internal/protocol"
+	"github.com/syncthing/syncthing/lib/protocol"
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
 const (
 	deviceStatisticTypeLastSeen = iota
+	deviceStatisticTypeInBytes
+	deviceStatisticTypeOutBytes
+	deviceStatisticTypeConnected
+	deviceStatisticTypeLANInBytes
+	deviceStatisticTypeLANOutBytes
+	deviceStatisticTypeWANInBytes
+	deviceStatisticTypeWANOutBytes
+	deviceStatisticTypeKnownSince
 )
 
 var deviceStatisticsTypes = []byte{
 	deviceStatisticTypeLastSeen,
+	deviceStatisticTypeInBytes,
+	deviceStatisticTypeOutBytes,
+	deviceStatisticTypeConnected,
+	deviceStatisticTypeLANInBytes,
+	deviceStatisticTypeLANOutBytes,
+	deviceStatisticTypeWANInBytes,
+	deviceStatisticTypeWANOutBytes,
+	deviceStatisticTypeKnownSince,
 }
 
 type DeviceStatistics struct {
-	LastSeen time.Time
+	LastSeen            time.Time
+	InBytesTotal        uint64
+	OutBytesTotal       uint64
+	ConnectionDurationS float64 // total time connected
+
+	// The traffic over connections on the local network and elsewhere
+	LANInBytesTotal  uint64
+	LANOutBytesTotal uint64
+	WANInBytesTotal  uint64
+	WANOutBytesTotal uint64
 }
 
 type DeviceStatisticsReference struct {
 	db     *leveldb.DB
 	device protocol.DeviceID
+	mut    sync.Mutex // serializes updates of the totals
 }
 
 func NewDeviceStatisticsReference(db *leveldb.DB, device protocol.DeviceID) *DeviceStatisticsReference {
@@ -58,14 +86,14 @@ func (s *DeviceStatisticsReference) GetLastSeen() time.Time {
 		l.Warnln("DeviceStatisticsReference: Failed parsing last seen value for", s.device, ":", err)
 		return time.Unix(0, 0)
 	}
-	if debug {
+	if debug() {
 		l.Debugln("stats.DeviceStatisticsReference.GetLastSeen:", s.device, rtime)
 	}
 	return rtime
 }
 
 func (s *DeviceStatisticsReference) WasSeen() {
-	if debug {
+	if debug() {
 		l.Debugln("stats.DeviceStatisticsReference.WasSeen:", s.device)
 	}
 	value, err := time.Now().MarshalBinary()
@@ -80,12 +108,87 @@ func (s *DeviceStatisticsReference) WasSeen() {
 	}
 }
 
+// KnownSince returns when the device was first asked about, to tell how long
+// a device that has never been seen has been waiting.
+func (s *DeviceStatisticsReference) KnownSince() time.Time {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	var t time.Time
+	value, err := s.db.Get(s.key(deviceStatisticTypeKnownSince), nil)
+	if err == nil && t.UnmarshalBinary(value) == nil {
+		return t
+	}
+	if err != nil && err != leveldb.ErrNotFound {
+		l.Warnln("DeviceStatisticsReference: Failed loading known since value for", s.device, ":", err)
+	}
+
+	t = time.Now()
+	value, _ = t.MarshalBinary()
+	if err := s.db.Put(s.key(deviceStatisticTypeKnownSince), value, nil); err != nil {
+		l.Warnln("DeviceStatisticsReference: Failed storing known since value for", s.device, ":", err)
+	}
+	return t
+}
+
+// AddConnection adds the traffic and duration of (a part of) a connection
+// to the totals for the device, and the traffic to the LAN or WAN totals.
+func (s *DeviceStatisticsReference) AddConnection(inBytes, outBytes uint64, d time.Duration, lan bool) {
+	if debug() {
+		l.Debugln("stats.DeviceStatisticsReference.AddConnection:", s.device, inBytes, outBytes, d, lan)
+	}
+	if d < 0 {
+		d = 0
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	stats := map[byte]uint64{
+		deviceStatisticTypeInBytes:   inBytes,
+		deviceStatisticTypeOutBytes:  outBytes,
+		deviceStatisticTypeConnected: uint64(d),
+	}
+	if lan {
+		stats[deviceStatisticTypeLANInBytes] = inBytes
+		stats[deviceStatisticTypeLANOutBytes] = outBytes
+	} else {
+		stats[deviceStatisticTypeWANInBytes] = inBytes
+		stats[deviceStatisticTypeWANOutBytes] = outBytes
+	}
+
+	batch := new(leveldb.Batch)
+	for stat, v := range stats {
+		var bs [8]byte
+		binary.BigEndian.PutUint64(bs[:], s.getUint64(stat)+v)
+		batch.Put(s.key(stat), bs[:])
+	}
+	if err := s.db.Write(batch, nil); err != nil {
+		l.Warnln("DeviceStatisticsReference: Failed storing connection totals for", s.device, ":", err)
+	}
+}
+
+func (s *DeviceStatisticsReference) getUint64(stat byte) uint64 {
+	value, err := s.db.Get(s.key(stat), nil)
+	if err != nil {
+		if err != leveldb.ErrNotFound {
+			l.Warnln("DeviceStatisticsReference: Failed loading value for", s.device, ":", err)
+		}
+		return 0
+	}
+	if len(value) != 8 {
+		l.Warnln("DeviceStatisticsReference: Failed parsing value for", s.device)
+		return 0
+	}
+	return binary.BigEndian.Uint64(value)
+}
+
 // Never called, maybe because it's worth while to keep the data
 // or maybe because we have no easy way of knowing that a device has been removed.
 func (s *DeviceStatisticsReference) Delete() error {
 	for _, stype := range deviceStatisticsTypes {
 		err := s.db.Delete(s.key(stype), nil)
-		if debug && err == nil {
+		if debug() && err == nil {
 			l.Debugln("stats.DeviceStatisticsReference.Delete:", s.device, stype)
 		}
 		if err != nil && err != leveldb.ErrNotFound {
@@ -97,6 +200,13 @@ func (s *DeviceStatisticsReference) Delete() error {
 
 func (s *DeviceStatisticsReference) GetStatistics() DeviceStatistics {
 	return DeviceStatistics{
-		LastSeen: s.GetLastSeen(),
+		LastSeen:            s.GetLastSeen(),
+		InBytesTotal:        s.getUint64(deviceStatisticTypeInBytes),
+		OutBytesTotal:       s.getUint64(deviceStatisticTypeOutBytes),
+		ConnectionDurationS: time.Duration(s.getUint64(deviceStatisticTypeConnected)).Seconds(),
+		LANInBytesTotal:     s.getUint64(deviceStatisticTypeLANInBytes),
+		LANOutBytesTotal:    s.getUint64(deviceStatisticTypeLANOutBytes),
+		WANInBytesTotal:     s.getUint64(deviceStatisticTypeWANInBytes),
+		WANOutBytesTotal:    s.getUint64(deviceStatisticTypeWANOutBytes),
 	}
 }
@@ -0,0 +1,51 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+var device1, _ = protocol.DeviceIDFromString("AIR6LPZ-7K4PTTV-UXQSMUU-CPQ5YWH-OEDFIIQ-JUG777G-2YQXXR5-YD6AWQR")
+
+func TestDeviceStatistics(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+
+	s := NewDeviceStatisticsReference(db, device1).GetStatistics()
+	if s.LastSeen.Unix() != 0 || s.InBytesTotal != 0 || s.OutBytesTotal != 0 || s.ConnectionDurationS != 0 {
+		t.Errorf("unexpected initial statistics %+v", s)
+	}
+
+	ref := NewDeviceStatisticsReference(db, device1)
+	ref.WasSeen()
+	ref.AddConnection(100, 200, 3*time.Second, true)
+	ref.AddConnection(1, 2, 2*time.Second, false)
+	ref.AddConnection(0, 0, -time.Second, false)
+
+	// A new reference sees the persisted values
+	s = NewDeviceStatisticsReference(db, device1).GetStatistics()
+	if time.Since(s.LastSeen) > time.Minute {
+		t.Errorf("unexpected last seen %v", s.LastSeen)
+	}
+	if s.InBytesTotal != 101 || s.OutBytesTotal != 202 || s.ConnectionDurationS != 5 {
+		t.Errorf("unexpected statistics %+v", s)
+	}
+	if s.LANInBytesTotal != 100 || s.LANOutBytesTotal != 200 || s.WANInBytesTotal != 1 || s.WANOutBytesTotal != 2 {
+		t.Errorf("unexpected LAN/WAN statistics %+v", s)
+	}
+
+	if err := ref.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	s = ref.GetStatistics()
+	if s.InBytesTotal != 0 || s.ConnectionDurationS != 0 {
+		t.Errorf("unexpected statistics after delete %+v", s)
+	}
+}
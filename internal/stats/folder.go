@@ -0,0 +1,171 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package stats
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	folderStatisticTypeLastScan = iota
+	folderStatisticTypeLastScanDuration
+	folderStatisticTypeLastSync
+	folderStatisticTypeLastFileName
+	folderStatisticTypeLastFileAt
+	folderStatisticTypeLastFileDevice
+)
+
+var folderStatisticsTypes = []byte{
+	folderStatisticTypeLastScan,
+	folderStatisticTypeLastScanDuration,
+	folderStatisticTypeLastSync,
+	folderStatisticTypeLastFileName,
+	folderStatisticTypeLastFileAt,
+	folderStatisticTypeLastFileDevice,
+}
+
+type FolderStatistics struct {
+	LastScan          time.Time
+	LastScanDurationS float64
+	LastSync          time.Time // when the folder was last brought in sync
+	LastFile          LastFile  // the last file received from another device
+}
+
+type LastFile struct {
+	Filename string
+	At       time.Time
+	Device   string // ID of a device that had the file
+}
+
+type FolderStatisticsReference struct {
+	db     *leveldb.DB
+	folder string
+}
+
+func NewFolderStatisticsReference(db *leveldb.DB, folder string) *FolderStatisticsReference {
+	return &FolderStatisticsReference{
+		db:     db,
+		folder: folder,
+	}
+}
+
+func (s *FolderStatisticsReference) key(stat byte) []byte {
+	k := make([]byte, 1+1+len(s.folder))
+	k[0] = keyTypeFolderStatistic
+	k[1] = stat
+	copy(k[1+1:], s.folder)
+	return k
+}
+
+// ScanCompleted records a scan that started at the given time.
+func (s *FolderStatisticsReference) ScanCompleted(started time.Time) {
+	if debug() {
+		l.Debugln("stats.FolderStatisticsReference.ScanCompleted:", s.folder, started)
+	}
+	var d [8]byte
+	binary.BigEndian.PutUint64(d[:], uint64(time.Since(started)))
+
+	batch := new(leveldb.Batch)
+	s.putTime(batch, folderStatisticTypeLastScan, started)
+	batch.Put(s.key(folderStatisticTypeLastScanDuration), d[:])
+	s.write(batch)
+}
+
+// SyncCompleted records that the folder is in sync.
+func (s *FolderStatisticsReference) SyncCompleted() {
+	if debug() {
+		l.Debugln("stats.FolderStatisticsReference.SyncCompleted:", s.folder)
+	}
+	batch := new(leveldb.Batch)
+	s.putTime(batch, folderStatisticTypeLastSync, time.Now())
+	s.write(batch)
+}
+
+// ReceivedFile records a file received from the given device.
+func (s *FolderStatisticsReference) ReceivedFile(name string, device protocol.DeviceID) {
+	if debug() {
+		l.Debugln("stats.FolderStatisticsReference.ReceivedFile:", s.folder, name, device)
+	}
+	batch := new(leveldb.Batch)
+	batch.Put(s.key(folderStatisticTypeLastFileName), []byte(name))
+	s.putTime(batch, folderStatisticTypeLastFileAt, time.Now())
+	batch.Put(s.key(folderStatisticTypeLastFileDevice), device[:])
+	s.write(batch)
+}
+
+func (s *FolderStatisticsReference) putTime(batch *leveldb.Batch, stat byte, t time.Time) {
+	value, err := t.MarshalBinary()
+	if err != nil {
+		l.Warnln("FolderStatisticsReference: Failed serializing time for", s.folder, ":", err)
+		return
+	}
+	batch.Put(s.key(stat), value)
+}
+
+func (s *FolderStatisticsReference) write(batch *leveldb.Batch) {
+	if err := s.db.Write(batch, nil); err != nil {
+		l.Warnln("FolderStatisticsReference: Failed storing statistics for", s.folder, ":", err)
+	}
+}
+
+func (s *FolderStatisticsReference) get(stat byte) []byte {
+	value, err := s.db.Get(s.key(stat), nil)
+	if err != nil {
+		if err != leveldb.ErrNotFound {
+			l.Warnln("FolderStatisticsReference: Failed loading value for", s.folder, ":", err)
+		}
+		return nil
+	}
+	return value
+}
+
+func (s *FolderStatisticsReference) getTime(stat byte) time.Time {
+	value := s.get(stat)
+	if value == nil {
+		return time.Unix(0, 0)
+	}
+	rtime := time.Time{}
+	if err := rtime.UnmarshalBinary(value); err != nil {
+		l.Warnln("FolderStatisticsReference: Failed parsing time for", s.folder, ":", err)
+		return time.Unix(0, 0)
+	}
+	return rtime
+}
+
+// Delete removes the statistics of the folder.
+func (s *FolderStatisticsReference) Delete() error {
+	for _, stype := range folderStatisticsTypes {
+		err := s.db.Delete(s.key(stype), nil)
+		if debug() && err == nil {
+			l.Debugln("stats.FolderStatisticsReference.Delete:", s.folder, stype)
+		}
+		if err != nil && err != leveldb.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FolderStatisticsReference) GetStatistics() FolderStatistics {
+	res := FolderStatistics{
+		LastScan: s.getTime(folderStatisticTypeLastScan),
+		LastSync: s.getTime(folderStatisticTypeLastSync),
+		LastFile: LastFile{
+			Filename: string(s.get(folderStatisticTypeLastFileName)),
+			At:       s.getTime(folderStatisticTypeLastFileAt),
+		},
+	}
+	if value := s.get(folderStatisticTypeLastScanDuration); len(value) == 8 {
+		res.LastScanDurationS = time.Duration(binary.BigEndian.Uint64(value)).Seconds()
+	}
+	if value := s.get(folderStatisticTypeLastFileDevice); len(value) == 32 {
+		res.LastFile.Device = protocol.DeviceIDFromBytes(value).String()
+	}
+	return res
+}
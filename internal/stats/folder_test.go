@@ -0,0 +1,54 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestFolderStatistics(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+
+	s := NewFolderStatisticsReference(db, "default").GetStatistics()
+	if s.LastScan.Unix() != 0 || s.LastSync.Unix() != 0 || s.LastScanDurationS != 0 || s.LastFile.Filename != "" {
+		t.Errorf("unexpected initial statistics %+v", s)
+	}
+
+	ref := NewFolderStatisticsReference(db, "default")
+	ref.ScanCompleted(time.Now().Add(-2 * time.Second))
+	ref.SyncCompleted()
+	ref.ReceivedFile("foo/bar", device1)
+
+	// Statistics are kept per folder
+	other := NewFolderStatisticsReference(db, "other")
+	other.ReceivedFile("baz", device1)
+
+	// A new reference sees the persisted values
+	s = NewFolderStatisticsReference(db, "default").GetStatistics()
+	if time.Since(s.LastScan) > time.Minute || time.Since(s.LastSync) > time.Minute {
+		t.Errorf("unexpected times %+v", s)
+	}
+	if s.LastScanDurationS < 2 || s.LastScanDurationS > 60 {
+		t.Errorf("unexpected scan duration %v", s.LastScanDurationS)
+	}
+	if s.LastFile.Filename != "foo/bar" || s.LastFile.Device != device1.String() || time.Since(s.LastFile.At) > time.Minute {
+		t.Errorf("unexpected last file %+v", s.LastFile)
+	}
+
+	if err := ref.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	s = ref.GetStatistics()
+	if s.LastFile.Filename != "" || s.LastScanDurationS != 0 {
+		t.Errorf("unexpected statistics after delete %+v", s)
+	}
+	if s = other.GetStatistics(); s.LastFile.Filename != "baz" {
+		t.Errorf("unexpected statistics for other folder %+v", s)
+	}
+}
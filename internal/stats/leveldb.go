@@ -7,4 +7,6 @@ package stats
 // Same key space as files/leveldb.go keyType* constants
 const (
 	keyTypeDeviceStatistic = iota + 30
+	keyTypeFolderStatistic
+	keyTypeTransferSample
 )
@@ -0,0 +1,182 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package stats
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// TransferHistoryRetention is how long transfer samples are kept.
+var TransferHistoryRetention = 24 * time.Hour
+
+// A TransferSample holds the transfer rates at a point in time, keyed by
+// device ID or "total".
+type TransferSample struct {
+	At    time.Time
+	Rates map[string]TransferRate
+}
+
+type TransferRate struct {
+	InBytesPerS  float64
+	OutBytesPerS float64
+}
+
+// TransferHistory is the series of transfer samples of the last
+// TransferHistoryRetention, kept in the database so that it survives
+// restarts.
+type TransferHistory struct {
+	db  *leveldb.DB
+	mut sync.Mutex // serializes adding and pruning
+}
+
+func NewTransferHistory(db *leveldb.DB) *TransferHistory {
+	return &TransferHistory{
+		db: db,
+	}
+}
+
+func transferSampleKey(t time.Time) []byte {
+	k := make([]byte, 1+8)
+	k[0] = keyTypeTransferSample
+	binary.BigEndian.PutUint64(k[1:], uint64(t.UnixNano()))
+	return k
+}
+
+// Add stores the sample and drops the samples that have passed the
+// retention time.
+func (h *TransferHistory) Add(s TransferSample) {
+	if debug() {
+		l.Debugln("stats.TransferHistory.Add:", s.At, len(s.Rates))
+	}
+
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	batch := new(leveldb.Batch)
+	batch.Put(transferSampleKey(s.At), marshalRates(s.Rates))
+
+	dbi := h.db.NewIterator(&util.Range{
+		Start: transferSampleKey(time.Unix(0, 0)),
+		Limit: transferSampleKey(s.At.Add(-TransferHistoryRetention)),
+	}, nil)
+	for dbi.Next() {
+		batch.Delete(dbi.Key())
+	}
+	dbi.Release()
+
+	if err := h.db.Write(batch, nil); err != nil {
+		l.Warnln("TransferHistory: Failed storing sample:", err)
+	}
+}
+
+// Samples returns the samples taken since the given time, oldest first. If
+// there are more than maxSamples of them they are averaged down to
+// maxSamples; zero means no limit.
+func (h *TransferHistory) Samples(since time.Time, maxSamples int) []TransferSample {
+	if since.Before(time.Unix(0, 0)) {
+		since = time.Unix(0, 0)
+	}
+
+	res := []TransferSample{}
+	dbi := h.db.NewIterator(&util.Range{
+		Start: transferSampleKey(since),
+		Limit: []byte{keyTypeTransferSample + 1},
+	}, nil)
+	for dbi.Next() {
+		key := dbi.Key()
+		if len(key) != 1+8 {
+			continue
+		}
+		rates, ok := unmarshalRates(dbi.Value())
+		if !ok {
+			l.Warnln("TransferHistory: Skipping corrupt sample")
+			continue
+		}
+		res = append(res, TransferSample{
+			At:    time.Unix(0, int64(binary.BigEndian.Uint64(key[1:]))),
+			Rates: rates,
+		})
+	}
+	dbi.Release()
+
+	if maxSamples > 0 && len(res) > maxSamples {
+		res = downsample(res, maxSamples)
+	}
+	return res
+}
+
+// downsample averages runs of consecutive samples into n samples, each
+// timestamped with the last sample of its run. A device missing from a
+// sample counts as transferring nothing.
+func downsample(samples []TransferSample, n int) []TransferSample {
+	res := make([]TransferSample, 0, n)
+	for i := 0; i < n; i++ {
+		run := samples[i*len(samples)/n : (i+1)*len(samples)/n]
+		avg := TransferSample{
+			At:    run[len(run)-1].At,
+			Rates: make(map[string]TransferRate),
+		}
+		for _, s := range run {
+			for id, r := range s.Rates {
+				a := avg.Rates[id]
+				a.InBytesPerS += r.InBytesPerS / float64(len(run))
+				a.OutBytesPerS += r.OutBytesPerS / float64(len(run))
+				avg.Rates[id] = a
+			}
+		}
+		res = append(res, avg)
+	}
+	return res
+}
+
+// The rates are stored as a sequence of entries, each the length of the ID
+// (one byte), the ID, and the in and out rates as float64 bits.
+
+func marshalRates(rates map[string]TransferRate) []byte {
+	ids := make([]string, 0, len(rates))
+	for id := range rates {
+		if len(id) <= math.MaxUint8 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var bs []byte
+	var buf [8]byte
+	for _, id := range ids {
+		bs = append(bs, byte(len(id)))
+		bs = append(bs, id...)
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(rates[id].InBytesPerS))
+		bs = append(bs, buf[:]...)
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(rates[id].OutBytesPerS))
+		bs = append(bs, buf[:]...)
+	}
+	return bs
+}
+
+func unmarshalRates(bs []byte) (map[string]TransferRate, bool) {
+	rates := make(map[string]TransferRate)
+	for len(bs) > 0 {
+		n := int(bs[0])
+		if len(bs) < 1+n+16 {
+			return nil, false
+		}
+		id := string(bs[1 : 1+n])
+		bs = bs[1+n:]
+		rates[id] = TransferRate{
+			InBytesPerS:  math.Float64frombits(binary.BigEndian.Uint64(bs)),
+			OutBytesPerS: math.Float64frombits(binary.BigEndian.Uint64(bs[8:])),
+		}
+		bs = bs[16:]
+	}
+	return rates, true
+}
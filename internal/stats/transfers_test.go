@@ -0,0 +1,55 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestTransferHistory(t *testing.T) {
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	h := NewTransferHistory(db)
+
+	if s := h.Samples(time.Time{}, 0); len(s) != 0 {
+		t.Errorf("unexpected initial samples %v", s)
+	}
+
+	t0 := time.Now().Add(-48 * time.Hour)
+	h.Add(TransferSample{At: t0, Rates: map[string]TransferRate{"total": {1, 1}}})
+	for i := 0; i < 4; i++ {
+		h.Add(TransferSample{
+			At: t0.Add(25*time.Hour + time.Duration(i)*time.Minute),
+			Rates: map[string]TransferRate{
+				"total":          {float64(10 * i), 100},
+				device1.String(): {float64(i), 0},
+			},
+		})
+	}
+
+	// The first sample has passed the retention time
+	s := NewTransferHistory(db).Samples(time.Time{}, 0)
+	if len(s) != 4 {
+		t.Fatalf("unexpected number of samples %d", len(s))
+	}
+	if !s[0].At.Equal(t0.Add(25*time.Hour)) || s[3].Rates["total"].InBytesPerS != 30 || s[3].Rates[device1.String()].InBytesPerS != 3 {
+		t.Errorf("unexpected samples %v", s)
+	}
+
+	if s := h.Samples(t0.Add(25*time.Hour+90*time.Second), 0); len(s) != 2 {
+		t.Errorf("unexpected number of samples since %d", len(s))
+	}
+
+	s = h.Samples(time.Time{}, 2)
+	if len(s) != 2 {
+		t.Fatalf("unexpected number of downsampled samples %d", len(s))
+	}
+	if !s[1].At.Equal(t0.Add(25*time.Hour+3*time.Minute)) || s[0].Rates["total"].InBytesPerS != 5 || s[1].Rates["total"].InBytesPerS != 25 || s[1].Rates["total"].OutBytesPerS != 100 {
+		t.Errorf("unexpected downsampled samples %v", s)
+	}
+}
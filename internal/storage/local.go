@@ -0,0 +1,70 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func init() {
+	// Register the constructor for this type of backend with the name "local"
+	Factories["local"] = func(folderID, folderDir string, params map[string]string) (Backend, error) {
+		return NewLocal(folderDir), nil
+	}
+}
+
+// Local stores files in a directory on the local filesystem.
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+func (s *Local) ReadBlock(name string, offset int64, size int) ([]byte, error) {
+	fd, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	buf := protocol.BufferPool.Get(size)
+	_, err = fd.ReadAt(buf, offset)
+	if err != nil {
+		protocol.BufferPool.Put(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// CreateTemp creates the file even when its directory isn't writable for
+// us, by temporarily changing the permissions of the directory.
+func (s *Local) CreateTemp(name string) (TempFile, error) {
+	var fd *os.File
+	err := osutil.InWritableDir(func(path string) error {
+		var err error
+		fd, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+		return err
+	}, filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return fd, nil
+}
+
+func (s *Local) Rename(from, to string) error {
+	return osutil.InWritableDir(func(path string) error {
+		return osutil.Rename(filepath.Join(s.dir, from), path)
+	}, filepath.Join(s.dir, to))
+}
+
+func (s *Local) Remove(name string) error {
+	return osutil.InWritableDir(os.Remove, filepath.Join(s.dir, name))
+}
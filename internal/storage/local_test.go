@@ -0,0 +1,103 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	factory, ok := Factories["local"]
+	if !ok {
+		t.Fatal("local backend not registered")
+	}
+	s, err := factory("default", dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := s.CreateTemp("temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.WriteAt([]byte("barbaz"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.WriteAt([]byte("foo"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := fd.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.CreateTemp("temp"); err == nil {
+		t.Error("unexpected nil error creating existing temp file")
+	}
+
+	if err := s.Rename("temp", "file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "temp")); !os.IsNotExist(err) {
+		t.Errorf("temp file remains after rename: %v", err)
+	}
+
+	buf, err := s.ReadBlock("file", 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "bar" {
+		t.Errorf("unexpected block %q", buf)
+	}
+	if _, err := s.ReadBlock("file", 6, 6); err == nil {
+		t.Error("unexpected nil error reading past the end")
+	}
+
+	if err := s.Remove("file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadBlock("file", 0, 3); !os.IsNotExist(err) {
+		t.Errorf("unexpected error reading removed file: %v", err)
+	}
+}
+
+func TestLocalReadOnlyDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0500); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewLocal(dir)
+	fd, err := s.CreateTemp(filepath.Join("sub", "temp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+	if err := s.Remove(filepath.Join("sub", "temp")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0500 {
+		t.Errorf("directory permissions not restored, %o", info.Mode().Perm())
+	}
+}
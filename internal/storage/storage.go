@@ -0,0 +1,41 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package storage implements common interfaces for storing the contents of a
+// folder, and the local filesystem as the default backend. Other backends,
+// such as object storage for nodes that only archive data, register
+// themselves in Factories. Only file contents go through the backend;
+// directories, permissions and modification times are still handled on the
+// local filesystem.
+package storage
+
+import "io"
+
+// A Backend stores the files of a folder. Names are relative to the root of
+// the folder and use the native path separator.
+type Backend interface {
+	// ReadBlock returns size bytes from the named file, starting at offset.
+	// The buffer is taken from protocol.BufferPool.
+	ReadBlock(name string, offset int64, size int) ([]byte, error)
+
+	// CreateTemp creates the named temporary file for writing. It is an
+	// error if the file already exists.
+	CreateTemp(name string) (TempFile, error)
+
+	// Rename moves a file to a new name, replacing any existing file.
+	Rename(from, to string) error
+
+	// Remove removes the named file or empty directory.
+	Remove(name string) error
+}
+
+// A TempFile is a file being written by the puller.
+type TempFile interface {
+	io.WriterAt
+	io.Closer
+}
+
+// Factories holds the constructors for each type of backend. The local
+// backend is used when no type is given.
+var Factories = map[string]func(folderID string, folderDir string, params map[string]string) (Backend, error){}
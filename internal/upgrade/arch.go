@@ -0,0 +1,147 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package upgrade
+
+import (
+	"bufio"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+var ErrIncompatibleRelease = errors.New("no compatible release asset")
+
+// releaseAssetPrefix returns the beginning of the name of the release asset
+// matching the running system, i.e. "syncthing-linux-armv6-v0.10.5.".
+func releaseAssetPrefix(tag, archExtra string) (string, error) {
+	arch, err := assetArch(runtime.GOARCH, archExtra, cpuARMVariant())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("syncthing-%s-%s-%s.", assetOS(runtime.GOOS), arch, tag), nil
+}
+
+// assetOS returns the operating system part of the release asset name for
+// the GOOS.
+func assetOS(goos string) string {
+	if goos == "darwin" {
+		// We call the darwin release bundles macosx because that makes more
+		// sense for people downloading them
+		return "macosx"
+	}
+	return goos
+}
+
+// assetArch returns the architecture part of the release asset name for the
+// GOARCH. On ARM the variant is the one we were built for, or the one the
+// CPU reports when that is unknown. An asset requiring a newer variant than
+// the CPU supports is refused.
+func assetArch(goarch, archExtra, cpu string) (string, error) {
+	if goarch != "arm" {
+		return goarch + archExtra, nil
+	}
+
+	if archExtra == "" {
+		archExtra = cpu
+	}
+	if archExtra == "" {
+		return "", fmt.Errorf("%v: unknown ARM variant", ErrIncompatibleRelease)
+	}
+	if cpu != "" && archExtra > cpu {
+		return "", fmt.Errorf("%v: arm%s binary on arm%s CPU", ErrIncompatibleRelease, archExtra, cpu)
+	}
+	return "arm" + archExtra, nil
+}
+
+// cpuARMVariant returns the ARM architecture variant ("v5", "v6", "v7") of
+// the CPU, or the empty string if it cannot be determined.
+func cpuARMVariant() string {
+	if runtime.GOARCH != "arm" || runtime.GOOS != "linux" {
+		return ""
+	}
+	fd, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer fd.Close()
+	return parseCPUInfo(fd)
+}
+
+func parseCPUInfo(r io.Reader) string {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), ":", 2)
+		if len(fields) != 2 || strings.TrimSpace(fields[0]) != "CPU architecture" {
+			continue
+		}
+		v := strings.TrimSpace(fields[1])
+		switch {
+		case v == "":
+			return ""
+		case v[0] >= '7' && v[0] <= '9' || strings.HasPrefix(v, "AArch64"):
+			// ARMv8 CPUs run ARMv7 binaries
+			return "v7"
+		case v[0] == '6':
+			return "v6"
+		case v[0] == '5':
+			return "v5"
+		}
+		return ""
+	}
+	return ""
+}
+
+var elfMachines = map[string]elf.Machine{
+	"386":   elf.EM_386,
+	"amd64": elf.EM_X86_64,
+	"arm":   elf.EM_ARM,
+}
+
+// checkBinaryArch verifies that an ELF binary matches the word size, byte
+// order and machine type of the running system. Binaries in other formats
+// are not inspected.
+func checkBinaryArch(path string) error {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		return nil
+	}
+
+	f, err := elf.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	class := elf.ELFCLASS32
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		class = elf.ELFCLASS64
+	}
+	if f.Class != class {
+		return fmt.Errorf("%v: binary is %v", ErrIncompatibleRelease, f.Class)
+	}
+
+	if f.ByteOrder != nativeByteOrder() {
+		return fmt.Errorf("%v: binary is %v", ErrIncompatibleRelease, f.Data)
+	}
+
+	if m, ok := elfMachines[runtime.GOARCH]; ok && f.Machine != m {
+		return fmt.Errorf("%v: binary is %v", ErrIncompatibleRelease, f.Machine)
+	}
+
+	return nil
+}
+
+func nativeByteOrder() binary.ByteOrder {
+	var x uint16 = 1
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
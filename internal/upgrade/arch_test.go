@@ -0,0 +1,80 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package upgrade
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/calmh/osext"
+)
+
+func TestParseCPUInfo(t *testing.T) {
+	cases := []struct {
+		info string
+		v    string
+	}{
+		{"Processor\t: ARMv6-compatible processor rev 7 (v6l)\nCPU architecture: 7\n", "v7"},
+		{"Processor\t: ARMv6-compatible processor rev 7 (v6l)\nCPU architecture: 6\n", "v6"},
+		{"Processor\t: Feroceon 88FR131 rev 1 (v5l)\nCPU architecture: 5TE\n", "v5"},
+		{"CPU architecture: 8\n", "v7"},
+		{"CPU architecture: AArch64\n", "v7"},
+		{"model name\t: Intel(R) Core(TM) i7\n", ""},
+	}
+
+	for _, tc := range cases {
+		if v := parseCPUInfo(strings.NewReader(tc.info)); v != tc.v {
+			t.Errorf("parseCPUInfo(%q) = %q, expected %q", tc.info, v, tc.v)
+		}
+	}
+}
+
+func TestAssetName(t *testing.T) {
+	cases := []struct {
+		goos, goarch, extra, cpu string
+		os, arch                 string
+		ok                       bool
+	}{
+		{"linux", "amd64", "", "", "linux", "amd64", true},
+		{"linux", "386", "", "v7", "linux", "386", true},
+		{"darwin", "amd64", "", "", "macosx", "amd64", true},
+		{"windows", "386", "", "", "windows", "386", true},
+		{"linux", "arm", "v6", "v7", "linux", "armv6", true},
+		{"linux", "arm", "v7", "v7", "linux", "armv7", true},
+		{"linux", "arm", "", "v7", "linux", "armv7", true},
+		{"linux", "arm", "", "v6", "linux", "armv6", true},
+		{"linux", "arm", "", "v5", "linux", "armv5", true},
+		{"linux", "arm", "v5", "", "linux", "armv5", true},
+		{"freebsd", "arm", "v6", "", "freebsd", "armv6", true},
+		{"linux", "arm", "v7", "v6", "linux", "", false},
+		{"linux", "arm", "v6", "v5", "linux", "", false},
+		{"linux", "arm", "", "", "linux", "", false},
+	}
+
+	for _, tc := range cases {
+		if os := assetOS(tc.goos); os != tc.os {
+			t.Errorf("assetOS(%q) = %q, expected %q", tc.goos, os, tc.os)
+		}
+		a, err := assetArch(tc.goarch, tc.extra, tc.cpu)
+		if (err == nil) != tc.ok || a != tc.arch {
+			t.Errorf("assetArch(%q, %q, %q) = %q, %v", tc.goarch, tc.extra, tc.cpu, a, err)
+		}
+	}
+}
+
+func TestCheckBinaryArch(t *testing.T) {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		t.Skip("not an ELF platform")
+	}
+
+	exe, err := osext.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBinaryArch(exe); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package upgrade
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/events"
+)
+
+// Upgrade states, as reported in Progress.State
+const (
+	StateIdle        = "idle"
+	StateDownloading = "downloading"
+	StateVerifying   = "verifying"
+	StateInstalling  = "installing"
+	StateRestarting  = "restarting"
+	StateFailed      = "failed"
+)
+
+// Progress describes the state of an ongoing or finished upgrade.
+type Progress struct {
+	State   string    `json:"state"`
+	Release string    `json:"release,omitempty"`
+	Bytes   int64     `json:"bytes"`
+	Total   int64     `json:"total"` // -1 when unknown
+	Restart time.Time `json:"restart"`
+	Error   string    `json:"error,omitempty"`
+}
+
+var (
+	progress    = Progress{State: StateIdle}
+	progressMut sync.Mutex
+)
+
+// CurrentProgress returns the state of the latest upgrade attempt.
+func CurrentProgress() Progress {
+	progressMut.Lock()
+	defer progressMut.Unlock()
+	return progress
+}
+
+// RestartPending records that the upgrade has been installed and that the
+// process will restart into the new binary after the given delay.
+func RestartPending(delay time.Duration) {
+	setProgress(func(p *Progress) {
+		p.State = StateRestarting
+		p.Restart = time.Now().Add(delay)
+	})
+}
+
+func setProgress(fn func(p *Progress)) {
+	progressMut.Lock()
+	fn(&progress)
+	p := progress
+	progressMut.Unlock()
+
+	data := map[string]interface{}{
+		"state":   p.State,
+		"release": p.Release,
+		"bytes":   p.Bytes,
+		"total":   p.Total,
+	}
+	if !p.Restart.IsZero() {
+		data["restart"] = p.Restart
+	}
+	if p.Error != "" {
+		data["error"] = p.Error
+	}
+	events.Default.Log(events.UpgradeProgress, data)
+}
+
+func startProgress(rel Release) {
+	setProgress(func(p *Progress) {
+		*p = Progress{State: StateDownloading, Release: rel.Tag, Total: -1}
+	})
+}
+
+func failProgress(err error) {
+	setProgress(func(p *Progress) {
+		p.State = StateFailed
+		p.Error = err.Error()
+	})
+}
+
+func setState(state string) {
+	setProgress(func(p *Progress) {
+		p.State = state
+	})
+}
+
+// A progressReader counts the bytes read through it and reports download
+// progress at most every progressInterval.
+type progressReader struct {
+	r     io.Reader
+	bytes int64
+	total int64
+	last  time.Time
+}
+
+const progressInterval = 250 * time.Millisecond
+
+func newProgressReader(r io.Reader, total int64) *progressReader {
+	setProgress(func(p *Progress) {
+		p.Bytes = 0
+		p.Total = total
+	})
+	return &progressReader{r: r, total: total, last: time.Now()}
+}
+
+func (p *progressReader) Read(bs []byte) (int, error) {
+	n, err := p.r.Read(bs)
+	p.bytes += int64(n)
+	if err != nil || time.Since(p.last) > progressInterval {
+		p.last = time.Now()
+		bytes := p.bytes
+		setProgress(func(p *Progress) {
+			p.Bytes = bytes
+		})
+	}
+	return n, err
+}
+
+// verifyBinary runs the newly downloaded binary and makes sure it reports
+// the expected version, to avoid replacing ourselves with a corrupt or
+// otherwise unrunnable binary.
+func verifyBinary(path string, rel Release) error {
+	setState(StateVerifying)
+	if err := checkBinaryArch(path); err != nil {
+		return err
+	}
+	out, err := exec.Command(path, "-version").Output()
+	if err != nil {
+		return fmt.Errorf("verifying %s: %v", rel.Tag, err)
+	}
+	if !strings.Contains(string(out), rel.Tag) {
+		return fmt.Errorf("verifying %s: unexpected version %q", rel.Tag, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
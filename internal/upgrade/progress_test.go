@@ -0,0 +1,32 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package upgrade
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestProgressReader(t *testing.T) {
+	startProgress(Release{Tag: "v0.10.99"})
+
+	data := make([]byte, 12345)
+	pr := newProgressReader(bytes.NewReader(data), int64(len(data)))
+	if _, err := ioutil.ReadAll(pr); err != nil {
+		t.Fatal(err)
+	}
+
+	p := CurrentProgress()
+	if p.State != StateDownloading {
+		t.Errorf("unexpected state %q", p.State)
+	}
+	if p.Release != "v0.10.99" {
+		t.Errorf("unexpected release %q", p.Release)
+	}
+	if p.Bytes != int64(len(data)) || p.Total != int64(len(data)) {
+		t.Errorf("unexpected progress %d/%d", p.Bytes, p.Total)
+	}
+}
@@ -17,6 +17,8 @@ type Release struct {
 	Tag        string  `json:"tag_name"`
 	Prerelease bool    `json:"prerelease"`
 	Assets     []Asset `json:"assets"`
+	Notes      string  `json:"body"`
+	URL        string  `json:"html_url"`
 }
 
 type Asset struct {
@@ -40,14 +42,17 @@ func init() {
 func UpgradeTo(rel Release, archExtra string) error {
 	select {
 	case <-upgradeUnlocked:
+		startProgress(rel)
 		path, err := osext.Executable()
 		if err != nil {
+			failProgress(err)
 			upgradeUnlocked <- true
 			return err
 		}
 		err = upgradeTo(path, rel, archExtra)
 		// If we've failed to upgrade, unlock so that another attempt could be made
 		if err != nil {
+			failProgress(err)
 			upgradeUnlocked <- true
 		}
 		return err
@@ -17,24 +17,20 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"runtime"
 	"strings"
 )
 
 // Upgrade to the given release, saving the previous binary with a ".old" extension.
 func upgradeTo(path string, rel Release, archExtra string) error {
-	osName := runtime.GOOS
-	if osName == "darwin" {
-		// We call the darwin release bundles macosx because that makes more
-		// sense for people downloading them
-		osName = "macosx"
+	expectedRelease, err := releaseAssetPrefix(rel.Tag, archExtra)
+	if err != nil {
+		return err
 	}
-	expectedRelease := fmt.Sprintf("syncthing-%s-%s%s-%s.", osName, runtime.GOARCH, archExtra, rel.Tag)
-	if debug {
+	if debug() {
 		l.Debugf("expected release asset %q", expectedRelease)
 	}
 	for _, asset := range rel.Assets {
-		if debug {
+		if debug() {
 			l.Debugln("considering release", asset)
 		}
 		if strings.HasPrefix(asset.Name, expectedRelease) {
@@ -44,6 +40,13 @@ func upgradeTo(path string, rel Release, archExtra string) error {
 					return err
 				}
 
+				err = verifyBinary(fname, rel)
+				if err != nil {
+					os.Remove(fname)
+					return err
+				}
+
+				setState(StateInstalling)
 				old := path + ".old"
 				err = os.Rename(path, old)
 				if err != nil {
@@ -58,7 +61,7 @@ func upgradeTo(path string, rel Release, archExtra string) error {
 		}
 	}
 
-	return ErrVersionUnknown
+	return fmt.Errorf("%v: found no %s* asset", ErrIncompatibleRelease, expectedRelease)
 }
 
 // Returns the latest release, including prereleases or not depending on the argument
@@ -94,7 +97,7 @@ func LatestRelease(prerelease bool) (Release, error) {
 }
 
 func readTarGZ(url string, dir string) (string, error) {
-	if debug {
+	if debug() {
 		l.Debugf("loading %q", url)
 	}
 
@@ -110,7 +113,7 @@ func readTarGZ(url string, dir string) (string, error) {
 	}
 	defer resp.Body.Close()
 
-	gr, err := gzip.NewReader(resp.Body)
+	gr, err := gzip.NewReader(newProgressReader(resp.Body, resp.ContentLength))
 	if err != nil {
 		return "", err
 	}
@@ -130,7 +133,7 @@ func readTarGZ(url string, dir string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		if debug {
+		if debug() {
 			l.Debugf("considering file %q", hdr.Name)
 		}
 
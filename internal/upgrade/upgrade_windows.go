@@ -17,18 +17,20 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"runtime"
 	"strings"
 )
 
 // Upgrade to the given release, saving the previous binary with a ".old" extension.
 func upgradeTo(path string, rel Release, archExtra string) error {
-	expectedRelease := fmt.Sprintf("syncthing-%s-%s%s-%s.", runtime.GOOS, runtime.GOARCH, archExtra, rel.Tag)
-	if debug {
+	expectedRelease, err := releaseAssetPrefix(rel.Tag, archExtra)
+	if err != nil {
+		return err
+	}
+	if debug() {
 		l.Debugf("expected release asset %q", expectedRelease)
 	}
 	for _, asset := range rel.Assets {
-		if debug {
+		if debug() {
 			l.Debugln("considering release", asset)
 		}
 		if strings.HasPrefix(asset.Name, expectedRelease) {
@@ -38,6 +40,13 @@ func upgradeTo(path string, rel Release, archExtra string) error {
 					return err
 				}
 
+				err = verifyBinary(fname, rel)
+				if err != nil {
+					os.Remove(fname)
+					return err
+				}
+
+				setState(StateInstalling)
 				old := path + ".old"
 
 				os.Remove(old)
@@ -54,7 +63,7 @@ func upgradeTo(path string, rel Release, archExtra string) error {
 		}
 	}
 
-	return ErrVersionUnknown
+	return fmt.Errorf("%v: found no %s* asset", ErrIncompatibleRelease, expectedRelease)
 }
 
 // Returns the latest release, including prereleases or not depending on the argument
@@ -90,7 +99,7 @@ func LatestRelease(prerelease bool) (Release, error) {
 }
 
 func readZip(url, dir string) (string, error) {
-	if debug {
+	if debug() {
 		l.Debugf("loading %q", url)
 	}
 
@@ -106,7 +115,7 @@ func readZip(url, dir string) (string, error) {
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(newProgressReader(resp.Body, resp.ContentLength))
 	if err != nil {
 		return "", err
 	}
@@ -119,7 +128,7 @@ func readZip(url, dir string) (string, error) {
 	// Iterate through the files in the archive.
 	for _, file := range archive.File {
 
-		if debug {
+		if debug() {
 			l.Debugf("considering file %q", file.Name)
 		}
 
@@ -150,8 +159,16 @@ func readZip(url, dir string) (string, error) {
 				return "", err
 			}
 
-			os.Chmod(outfile.Name(), file.Mode())
-			return outfile.Name(), nil
+			// Windows runs only files named .exe, and we run it to verify it
+			name := outfile.Name() + ".exe"
+			err = os.Rename(outfile.Name(), name)
+			if err != nil {
+				os.Remove(outfile.Name())
+				return "", err
+			}
+
+			os.Chmod(name, file.Mode())
+			return name, nil
 		}
 	}
 
@@ -0,0 +1,161 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package upgrade
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Window describes the time of day, and the days of the week, during which
+// automatic upgrades (and the restart that follows) are permitted. It also
+// serves for the sync windows of devices. The zero Window permits upgrades at
+// any time.
+type Window struct {
+	start, end time.Duration // offset from local midnight
+	days       [7]bool       // indexed by time.Weekday
+	limited    bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun":       time.Sunday,
+	"sunday":    time.Sunday,
+	"mon":       time.Monday,
+	"monday":    time.Monday,
+	"tue":       time.Tuesday,
+	"tuesday":   time.Tuesday,
+	"wed":       time.Wednesday,
+	"wednesday": time.Wednesday,
+	"thu":       time.Thursday,
+	"thursday":  time.Thursday,
+	"fri":       time.Friday,
+	"friday":    time.Friday,
+	"sat":       time.Saturday,
+	"saturday":  time.Saturday,
+}
+
+// ParseWindow parses a window given as start and end times on the form
+// "HH:MM" and a comma separated list of weekdays, named in full or by their
+// three letter abbreviations ("mon,tuesday"). Empty start and end times mean
+// the entire day, and an empty list of days means every day. A window whose
+// end is before its start wraps around midnight, and the part after midnight
+// belongs to the day it started on.
+func ParseWindow(start, end, days string) (Window, error) {
+	var w Window
+	var err error
+
+	if start != "" || end != "" {
+		w.limited = true
+		if w.start, err = parseClock(start); err != nil {
+			return Window{}, err
+		}
+		if w.end, err = parseClock(end); err != nil {
+			return Window{}, err
+		}
+	}
+
+	days = strings.TrimSpace(days)
+	if days == "" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+		return w, nil
+	}
+
+	w.limited = true
+	for _, day := range strings.Split(days, ",") {
+		day = strings.ToLower(strings.TrimSpace(day))
+		wd, ok := weekdays[day]
+		if !ok {
+			return Window{}, fmt.Errorf("invalid weekday %q", day)
+		}
+		w.days[wd] = true
+	}
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 || h == 24 && m != 0 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// Contains returns true if upgrades are permitted at the given time.
+func (w Window) Contains(t time.Time) bool {
+	if !w.limited {
+		return true
+	}
+
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	switch {
+	case w.start == w.end:
+		// The entire day
+		return w.days[today]
+	case w.start < w.end:
+		return w.days[today] && offset >= w.start && offset < w.end
+	default:
+		// Wraps around midnight
+		return w.days[today] && offset >= w.start || w.days[yesterday] && offset < w.end
+	}
+}
+
+// Next returns the first time at or after t when upgrades are permitted, or
+// the zero time if the window never opens.
+func (w Window) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+
+	// Window boundaries fall on whole minutes, so stepping a minute at a
+	// time for a week finds the next opening.
+	n := t.Truncate(time.Minute).Add(time.Minute)
+	for i := 0; i < 7*24*60; i++ {
+		if w.Contains(n) {
+			return n
+		}
+		n = n.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// Clamp returns t if the window stays open from now until t. Otherwise it
+// returns the last second before the window closes, or if it is closed now,
+// the time it next opens. The zero time is returned if it never opens.
+func (w Window) Clamp(now, t time.Time) time.Time {
+	if !w.Contains(now) {
+		return w.Next(now)
+	}
+
+	// As in Next, the window can only close on a whole minute.
+	n := now.Truncate(time.Minute).Add(time.Minute)
+	for ; n.Before(t); n = n.Add(time.Minute) {
+		if !w.Contains(n) {
+			if last := n.Add(-time.Second); last.After(now) {
+				return last
+			}
+			return now
+		}
+	}
+	return t
+}
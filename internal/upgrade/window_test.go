@@ -0,0 +1,111 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package upgrade
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowContains(t *testing.T) {
+	// 2014-10-13 is a Monday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2014, 10, 12+day, hour, min, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		start, end, days string
+		t                time.Time
+		r                bool
+	}{
+		{"", "", "", at(1, 12, 0), true},
+		{"02:00", "06:00", "", at(1, 1, 59), false},
+		{"02:00", "06:00", "", at(1, 2, 0), true},
+		{"02:00", "06:00", "", at(1, 5, 59), true},
+		{"02:00", "06:00", "", at(1, 6, 0), false},
+		{"22:00", "04:00", "", at(1, 23, 0), true},
+		{"22:00", "04:00", "", at(1, 3, 0), true},
+		{"22:00", "04:00", "", at(1, 12, 0), false},
+		{"", "", "sat,sun", at(1, 12, 0), false},
+		{"", "", "sat,sun", at(0, 12, 0), true},
+		{"22:00", "04:00", "sun", at(1, 3, 0), true},
+		{"22:00", "04:00", "sun", at(1, 23, 0), false},
+		{"02:00", "06:00", "Monday", at(1, 3, 0), true},
+		{"02:00", "06:00", "tue", at(1, 3, 0), false},
+	}
+
+	for i, tc := range cases {
+		w, err := ParseWindow(tc.start, tc.end, tc.days)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %v", i, err)
+			continue
+		}
+		if r := w.Contains(tc.t); r != tc.r {
+			t.Errorf("%d: Contains(%v) = %v, expected %v", i, tc.t, r, tc.r)
+		}
+	}
+}
+
+func TestWindowNext(t *testing.T) {
+	w, err := ParseWindow("02:00", "06:00", "sat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Monday noon -> Saturday 02:00
+	from := time.Date(2014, 10, 13, 12, 0, 0, 0, time.UTC)
+	expected := time.Date(2014, 10, 18, 2, 0, 0, 0, time.UTC)
+	if n := w.Next(from); !n.Equal(expected) {
+		t.Errorf("Next(%v) = %v, expected %v", from, n, expected)
+	}
+}
+
+func TestWindowClamp(t *testing.T) {
+	w, err := ParseWindow("02:00", "06:00", "mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, hour, min, sec int) time.Time {
+		return time.Date(2014, 10, 12+day, hour, min, sec, 0, time.UTC)
+	}
+
+	cases := []struct {
+		now, t, r time.Time
+	}{
+		// Open throughout
+		{at(1, 3, 0, 0), at(1, 3, 1, 0), at(1, 3, 1, 0)},
+		// Closes before t
+		{at(1, 5, 59, 30), at(1, 6, 0, 30), at(1, 5, 59, 59)},
+		{at(1, 5, 59, 59), at(1, 6, 0, 59), at(1, 5, 59, 59)},
+		// Closed now; opens next Monday
+		{at(1, 6, 0, 30), at(1, 6, 1, 30), at(8, 2, 0, 0)},
+	}
+
+	for i, tc := range cases {
+		if r := w.Clamp(tc.now, tc.t); !r.Equal(tc.r) {
+			t.Errorf("%d: Clamp(%v, %v) = %v, expected %v", i, tc.now, tc.t, r, tc.r)
+		}
+		if r := w.Clamp(tc.now, tc.t); !w.Contains(r) {
+			t.Errorf("%d: Clamp(%v, %v) = %v, outside the window", i, tc.now, tc.t, r)
+		}
+	}
+}
+
+func TestWindowInvalid(t *testing.T) {
+	cases := [][3]string{
+		{"25:00", "06:00", ""},
+		{"02:00", "6", ""},
+		{"02:00", "06:60", ""},
+		{"", "", "someday"},
+		{"", "", "mondayxyz"},
+		{"", "", "satur"},
+	}
+
+	for _, tc := range cases {
+		if _, err := ParseWindow(tc[0], tc[1], tc[2]); err == nil {
+			t.Errorf("ParseWindow(%q, %q, %q) unexpectedly succeeded", tc[0], tc[1], tc[2])
+		}
+	}
+}
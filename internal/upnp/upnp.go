@@ -21,21 +21,17 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/syncthing/syncthing/internal/nat"
 )
 
+// An IGD is an Internet Gateway Device, mapping ports over UPnP.
 type IGD struct {
 	serviceURL string
 	device     string
 	ourIP      string
 }
 
-type Protocol string
-
-const (
-	TCP Protocol = "TCP"
-	UDP          = "UDP"
-)
-
 type upnpService struct {
 	ServiceType string `xml:"serviceType"`
 	ControlURL  string `xml:"controlURL"`
@@ -51,60 +47,117 @@ type upnpRoot struct {
 	Device upnpDevice `xml:"device"`
 }
 
-func Discover() (*IGD, error) {
+func init() {
+	nat.Discoverers["upnp"] = func(timeout time.Duration) []nat.Device {
+		var devices []nat.Device
+		for _, igd := range Discover(timeout) {
+			devices = append(devices, igd)
+		}
+		return devices
+	}
+}
+
+// Discover looks for Internet Gateway Devices on the local network, and
+// returns those answering within the timeout.
+func Discover(timeout time.Duration) []*IGD {
 	ssdp := &net.UDPAddr{IP: []byte{239, 255, 255, 250}, Port: 1900}
 
 	socket, err := net.ListenUDP("udp4", &net.UDPAddr{})
 	if err != nil {
-		return nil, err
+		if debug() {
+			l.Debugln(err)
+		}
+		return nil
 	}
 	defer socket.Close()
 
-	err = socket.SetDeadline(time.Now().Add(3 * time.Second))
+	err = socket.SetDeadline(time.Now().Add(timeout))
 	if err != nil {
-		return nil, err
+		if debug() {
+			l.Debugln(err)
+		}
+		return nil
 	}
 
+	mx := int(timeout / time.Second)
+	if mx < 1 {
+		mx = 1
+	}
 	searchStr := `M-SEARCH * HTTP/1.1
 Host: 239.255.255.250:1900
 St: urn:schemas-upnp-org:device:InternetGatewayDevice:1
 Man: "ssdp:discover"
-Mx: 3
+Mx: %d
 
 `
-	search := []byte(strings.Replace(searchStr, "\n", "\r\n", -1))
+	search := []byte(strings.Replace(fmt.Sprintf(searchStr, mx), "\n", "\r\n", -1))
 
 	_, err = socket.WriteTo(search, ssdp)
 	if err != nil {
-		return nil, err
-	}
+		if debug() {
+			l.Debugln(err)
+		}
+		return nil
+	}
+
+	// Every gateway answering before the deadline is used; each may answer
+	// more than once.
+	var igds []*IGD
+	seen := make(map[string]bool)
+	for {
+		resp := make([]byte, 1500)
+		n, _, err := socket.ReadFrom(resp)
+		if err != nil {
+			break
+		}
 
-	resp := make([]byte, 1500)
-	n, _, err := socket.ReadFrom(resp)
-	if err != nil {
-		return nil, err
-	}
+		if debug() {
+			l.Debugln(string(resp[:n]))
+		}
 
-	if debug {
-		l.Debugln(string(resp[:n]))
+		locURL, err := parseSearchResponse(resp[:n])
+		if err != nil || seen[locURL] {
+			if debug() && err != nil {
+				l.Debugln(err)
+			}
+			continue
+		}
+		seen[locURL] = true
+
+		igd, err := newIGD(locURL)
+		if err != nil {
+			if debug() {
+				l.Debugf("%s: %v", locURL, err)
+			}
+			continue
+		}
+		igds = append(igds, igd)
 	}
+	return igds
+}
 
-	reader := bufio.NewReader(bytes.NewBuffer(resp[:n]))
+// parseSearchResponse returns the location of the description of the
+// gateway answering our search.
+func parseSearchResponse(resp []byte) (string, error) {
+	reader := bufio.NewReader(bytes.NewBuffer(resp))
 	request := &http.Request{}
 	response, err := http.ReadResponse(reader, request)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	if response.Header.Get("St") != "urn:schemas-upnp-org:device:InternetGatewayDevice:1" {
-		return nil, errors.New("no igd")
+		return "", errors.New("no igd")
 	}
 
 	locURL := response.Header.Get("Location")
 	if locURL == "" {
-		return nil, errors.New("no location")
+		return "", errors.New("no location")
 	}
+	return locURL, nil
+}
 
+func newIGD(locURL string) (*IGD, error) {
 	serviceURL, device, err := getServiceURL(locURL)
 	if err != nil {
 		return nil, err
@@ -253,7 +306,7 @@ func soapRequest(url, device, function, message string) error {
 	req.Header.Set("Cache-Control", "no-cache")
 	req.Header.Set("Pragma", "no-cache")
 
-	if debug {
+	if debug() {
 		l.Debugln(req.Header.Get("SOAPAction"))
 		l.Debugln(body)
 	}
@@ -263,7 +316,7 @@ func soapRequest(url, device, function, message string) error {
 		return err
 	}
 
-	if debug {
+	if debug() {
 		resp, _ := ioutil.ReadAll(r.Body)
 		l.Debugln(string(resp))
 	}
@@ -277,7 +330,16 @@ func soapRequest(url, device, function, message string) error {
 	return nil
 }
 
-func (n *IGD) AddPortMapping(protocol Protocol, externalPort, internalPort int, description string, timeout int) error {
+func (n *IGD) String() string {
+	if u, err := url.Parse(n.serviceURL); err == nil {
+		return "UPnP gateway at " + u.Host
+	}
+	return "UPnP gateway at " + n.serviceURL
+}
+
+// AddPortMapping implements nat.Device. The external port mapped is always
+// the one asked for.
+func (n *IGD) AddPortMapping(protocol nat.Protocol, internalPort, externalPort int, description string, duration time.Duration) (int, error) {
 	tpl := `<u:AddPortMapping xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
 	<NewRemoteHost></NewRemoteHost>
 	<NewExternalPort>%d</NewExternalPort>
@@ -290,11 +352,15 @@ func (n *IGD) AddPortMapping(protocol Protocol, externalPort, internalPort int,
 	</u:AddPortMapping>
 	`
 
-	body := fmt.Sprintf(tpl, externalPort, protocol, internalPort, n.ourIP, description, timeout)
-	return soapRequest(n.serviceURL, n.device, "AddPortMapping", body)
+	body := fmt.Sprintf(tpl, externalPort, protocol, internalPort, n.ourIP, description, int(duration/time.Second))
+	if err := soapRequest(n.serviceURL, n.device, "AddPortMapping", body); err != nil {
+		return 0, err
+	}
+	return externalPort, nil
 }
 
-func (n *IGD) DeletePortMapping(protocol Protocol, externalPort int) (err error) {
+// DeletePortMapping implements nat.Device.
+func (n *IGD) DeletePortMapping(protocol nat.Protocol, internalPort, externalPort int) (err error) {
 	tpl := `<u:DeletePortMapping xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
 	<NewRemoteHost></NewRemoteHost>
 	<NewExternalPort>%d</NewExternalPort>
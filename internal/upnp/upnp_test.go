@@ -19,3 +19,16 @@ func TestGetTechnicolorRootURL(t *testing.T) {
 		t.Error("Unexpected action", action)
 	}
 }
+
+func TestParseSearchResponse(t *testing.T) {
+	resp := "HTTP/1.1 200 OK\r\nCache-Control: max-age=120\r\nSt: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\nLocation: http://192.168.1.1:5000/rootDesc.xml\r\n\r\n"
+	loc, err := parseSearchResponse([]byte(resp))
+	if err != nil || loc != "http://192.168.1.1:5000/rootDesc.xml" {
+		t.Errorf("unexpected location %q, %v", loc, err)
+	}
+
+	resp = "HTTP/1.1 200 OK\r\nSt: upnp:rootdevice\r\nLocation: http://192.168.1.1:5000/rootDesc.xml\r\n\r\n"
+	if _, err := parseSearchResponse([]byte(resp)); err == nil {
+		t.Error("accepted a response from something else than a gateway")
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package versioner
+
+import "sync"
+
+var (
+	cleanupGates    = make(map[string]func(name string) bool)
+	cleanupGatesMut sync.RWMutex
+)
+
+// SetCleanupGate makes the versioners of the folder keep every old version
+// of a file for as long as the gate returns false for its name, given
+// relative to the folder. A nil gate lets old versions be removed as usual.
+func SetCleanupGate(folderID string, gate func(name string) bool) {
+	cleanupGatesMut.Lock()
+	if gate == nil {
+		delete(cleanupGates, folderID)
+	} else {
+		cleanupGates[folderID] = gate
+	}
+	cleanupGatesMut.Unlock()
+}
+
+// mayRemoveVersions returns whether old versions of the named file in the
+// folder may be removed.
+func mayRemoveVersions(folderID, name string) bool {
+	cleanupGatesMut.RLock()
+	gate := cleanupGates[folderID]
+	cleanupGatesMut.RUnlock()
+
+	if gate == nil || gate(name) {
+		return true
+	}
+	if debug() {
+		l.Debugf("keeping old versions of %q in folder %q", name, folderID)
+	}
+	return false
+}
@@ -21,6 +21,7 @@ func init() {
 // The type holds our configuration
 type Simple struct {
 	keep       int
+	folderID   string
 	folderPath string
 }
 
@@ -33,10 +34,11 @@ func NewSimple(folderID, folderPath string, params map[string]string) Versioner
 
 	s := Simple{
 		keep:       keep,
+		folderID:   folderID,
 		folderPath: folderPath,
 	}
 
-	if debug {
+	if debug() {
 		l.Debugf("instantiated %#v", s)
 	}
 	return s
@@ -48,7 +50,7 @@ func (v Simple) Archive(filePath string) error {
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			if debug {
+			if debug() {
 				l.Debugln("not archiving nonexistent file", filePath)
 			}
 			return nil
@@ -61,7 +63,7 @@ func (v Simple) Archive(filePath string) error {
 	_, err = os.Stat(versionsDir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			if debug {
+			if debug() {
 				l.Debugln("creating versions dir", versionsDir)
 			}
 			os.MkdirAll(versionsDir, 0755)
@@ -71,7 +73,7 @@ func (v Simple) Archive(filePath string) error {
 		}
 	}
 
-	if debug {
+	if debug() {
 		l.Debugln("archiving", filePath)
 	}
 
@@ -89,7 +91,7 @@ func (v Simple) Archive(filePath string) error {
 
 	ver := file + "~" + fileInfo.ModTime().Format("20060102-150405")
 	dst := filepath.Join(dir, ver)
-	if debug {
+	if debug() {
 		l.Debugln("moving to", dst)
 	}
 	err = osutil.Rename(filePath, dst)
@@ -103,10 +105,10 @@ func (v Simple) Archive(filePath string) error {
 		return nil
 	}
 
-	if len(versions) > v.keep {
+	if len(versions) > v.keep && mayRemoveVersions(v.folderID, filepath.Join(inFolderPath, file)) {
 		sort.Strings(versions)
 		for _, toRemove := range versions[:len(versions)-v.keep] {
-			if debug {
+			if debug() {
 				l.Debugln("cleaning out", toRemove)
 			}
 			err = os.Remove(toRemove)
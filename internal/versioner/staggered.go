@@ -30,6 +30,7 @@ type Interval struct {
 type Staggered struct {
 	versionsPath  string
 	cleanInterval int64
+	folderID      string
 	folderPath    string
 	interval      [4]Interval
 	mutex         *sync.Mutex
@@ -58,6 +59,9 @@ func versionExt(path string) string {
 
 // Rename versions with old version format
 func (v Staggered) renameOld() {
+	if _, err := os.Stat(v.versionsPath); os.IsNotExist(err) {
+		return
+	}
 	err := filepath.Walk(v.versionsPath, func(path string, f os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -83,6 +87,11 @@ func (v Staggered) renameOld() {
 }
 
 // The constructor function takes a map of parameters and creates the type.
+// A version is kept per 30 seconds for the last hour, then one per hour for
+// the last day, one per day for the last 30 days and one per week until they are
+// "maxAge" seconds old, or forever if it's zero. Old versions are removed
+// every "cleanInterval" seconds, as well as when a file is archived. The
+// versions are kept in "versionsPath", or in .stversions in the folder.
 func NewStaggered(folderID, folderPath string, params map[string]string) Versioner {
 	maxAge, err := strconv.ParseInt(params["maxAge"], 10, 0)
 	if err != nil {
@@ -96,12 +105,12 @@ func NewStaggered(folderID, folderPath string, params map[string]string) Version
 	// Use custom path if set, otherwise .stversions in folderPath
 	var versionsDir string
 	if params["versionsPath"] == "" {
-		if debug {
+		if debug() {
 			l.Debugln("using default dir .stversions")
 		}
 		versionsDir = filepath.Join(folderPath, ".stversions")
 	} else {
-		if debug {
+		if debug() {
 			l.Debugln("using dir", params["versionsPath"])
 		}
 		versionsDir = params["versionsPath"]
@@ -111,17 +120,13 @@ func NewStaggered(folderID, folderPath string, params map[string]string) Version
 	s := Staggered{
 		versionsPath:  versionsDir,
 		cleanInterval: cleanInterval,
+		folderID:      folderID,
 		folderPath:    folderPath,
-		interval: [4]Interval{
-			{30, 3600},               // first hour -> 30 sec between versions
-			{3600, 86400},            // next day -> 1 h between versions
-			{86400, 592000},          // next 30 days -> 1 day between versions
-			{604800, maxAge * 86400}, // next year -> 1 week between versions
-		},
-		mutex: &mutex,
+		interval:      staggeredIntervals(maxAge),
+		mutex:         &mutex,
 	}
 
-	if debug {
+	if debug() {
 		l.Debugf("instantiated %#v", s)
 	}
 
@@ -138,27 +143,31 @@ func NewStaggered(folderID, folderPath string, params map[string]string) Version
 	return s
 }
 
+// staggeredIntervals returns the intervals for versions up to maxAge
+// seconds old, or of any age if maxAge is zero.
+func staggeredIntervals(maxAge int64) [4]Interval {
+	return [4]Interval{
+		{30, 3600},       // first hour -> 30 sec between versions
+		{3600, 86400},    // next day -> 1 h between versions
+		{86400, 2592000}, // next 30 days -> 1 day between versions
+		{604800, maxAge}, // until maxAge -> 1 week between versions
+	}
+}
+
 func (v Staggered) clean() {
-	if debug {
+	if debug() {
 		l.Debugln("Versioner clean: Waiting for lock on", v.versionsPath)
 	}
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
-	if debug {
+	if debug() {
 		l.Debugln("Versioner clean: Cleaning", v.versionsPath)
 	}
 
 	_, err := os.Stat(v.versionsPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			if debug {
-				l.Debugln("creating versions dir", v.versionsPath)
-			}
-			os.MkdirAll(v.versionsPath, 0755)
-			osutil.HideFile(v.versionsPath)
-		} else {
-			l.Warnln("Versioner: can't create versions dir", err)
-		}
+	if os.IsNotExist(err) {
+		// Nothing has been archived yet
+		return
 	}
 
 	versionsPerFile := make(map[string][]string)
@@ -202,13 +211,13 @@ func (v Staggered) clean() {
 		}
 
 		if path == v.versionsPath {
-			if debug {
+			if debug() {
 				l.Debugln("Cleaner: versions dir is empty, don't delete", path)
 			}
 			continue
 		}
 
-		if debug {
+		if debug() {
 			l.Debugln("Cleaner: deleting empty directory", path)
 		}
 		err = os.Remove(path)
@@ -216,20 +225,28 @@ func (v Staggered) clean() {
 			l.Warnln("Versioner: can't remove directory", path, err)
 		}
 	}
-	if debug {
+	if debug() {
 		l.Debugln("Cleaner: Finished cleaning", v.versionsPath)
 	}
 }
 
 func (v Staggered) expire(versions []string) {
-	if debug {
+	if debug() {
 		l.Debugln("Versioner: Expiring versions", versions)
 	}
+	if len(versions) > 0 {
+		first := versions[0]
+		name, err := filepath.Rel(v.versionsPath, first[:len(first)-len(versionExt(first))-1])
+		if err == nil && !mayRemoveVersions(v.folderID, name) {
+			return
+		}
+	}
 	var prevAge int64
 	firstFile := true
 	for _, file := range versions {
 		if isFile(file) {
-			versionTime, err := time.Parse(TimeLayout, versionExt(file))
+			// Versions are named by the local modification time
+			versionTime, err := time.ParseInLocation(TimeLayout, versionExt(file), time.Local)
 			if err != nil {
 				l.Infof("Versioner: file name %q is invalid: %v", file, err)
 				continue
@@ -238,7 +255,7 @@ func (v Staggered) expire(versions []string) {
 
 			// If the file is older than the max age of the last interval, remove it
 			if lastIntv := v.interval[len(v.interval)-1]; lastIntv.end > 0 && age > lastIntv.end {
-				if debug {
+				if debug() {
 					l.Debugln("Versioner: File over maximum age -> delete ", file)
 				}
 				err = os.Remove(file)
@@ -264,7 +281,7 @@ func (v Staggered) expire(versions []string) {
 			}
 
 			if prevAge-age < usedInterval.step {
-				if debug {
+				if debug() {
 					l.Debugln("too many files in step -> delete", file)
 				}
 				err = os.Remove(file)
@@ -284,7 +301,7 @@ func (v Staggered) expire(versions []string) {
 // Move away the named file to a version archive. If this function returns
 // nil, the named file does not exist any more (has been archived).
 func (v Staggered) Archive(filePath string) error {
-	if debug {
+	if debug() {
 		l.Debugln("Waiting for lock on ", v.versionsPath)
 	}
 	v.mutex.Lock()
@@ -293,7 +310,7 @@ func (v Staggered) Archive(filePath string) error {
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			if debug {
+			if debug() {
 				l.Debugln("not archiving nonexistent file", filePath)
 			}
 			return nil
@@ -305,7 +322,7 @@ func (v Staggered) Archive(filePath string) error {
 	_, err = os.Stat(v.versionsPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			if debug {
+			if debug() {
 				l.Debugln("creating versions dir", v.versionsPath)
 			}
 			os.MkdirAll(v.versionsPath, 0755)
@@ -315,7 +332,7 @@ func (v Staggered) Archive(filePath string) error {
 		}
 	}
 
-	if debug {
+	if debug() {
 		l.Debugln("archiving", filePath)
 	}
 
@@ -333,7 +350,7 @@ func (v Staggered) Archive(filePath string) error {
 
 	ver := file + "~" + fileInfo.ModTime().Format(TimeLayout)
 	dst := filepath.Join(dir, ver)
-	if debug {
+	if debug() {
 		l.Debugln("moving to", dst)
 	}
 	err = osutil.Rename(filePath, dst)
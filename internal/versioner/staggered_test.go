@@ -0,0 +1,108 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package versioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStaggeredExpire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	ages := []time.Duration{
+		// One per 30 seconds for an hour
+		10 * time.Second,
+		50 * time.Second,
+		30 * time.Minute,
+		// One per hour for a day
+		2 * time.Hour, // removed, as it's too close to the next older one
+		2*time.Hour + 10*time.Minute,
+		5 * time.Hour,
+		// One per day for a month
+		3 * 24 * time.Hour, // removed
+		3*24*time.Hour + 2*time.Hour,
+		10 * 24 * time.Hour,
+		// One per week until the maximum age
+		60 * 24 * time.Hour, // removed
+		62 * 24 * time.Hour,
+		70 * 24 * time.Hour,
+		// Too old
+		400 * 24 * time.Hour, // removed
+	}
+	removed := map[int]bool{3: true, 6: true, 9: true, 12: true}
+
+	var versions, kept []string
+	for i, age := range ages {
+		name := filepath.Join(dir, "file~"+now.Add(-age).Format(TimeLayout))
+		if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, name)
+		if !removed[i] {
+			kept = append(kept, name)
+		}
+	}
+	sort.Strings(versions)
+	sort.Strings(kept)
+
+	v := Staggered{
+		versionsPath: dir,
+		interval:     staggeredIntervals(365 * 86400),
+		mutex:        new(sync.Mutex),
+	}
+	v.expire(versions)
+
+	left, _ := filepath.Glob(filepath.Join(dir, "file~*"))
+	sort.Strings(left)
+	if len(left) != len(kept) {
+		t.Fatalf("Kept %d versions, not %d:\n%v", len(left), len(kept), left)
+	}
+	for i := range left {
+		if left[i] != kept[i] {
+			t.Errorf("Kept %s, not %s", left[i], kept[i])
+		}
+	}
+}
+
+func TestStaggeredArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "folder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	v := NewStaggered("default", dir, map[string]string{"maxAge": "86400", "cleanInterval": "3600"})
+	if _, err := os.Stat(filepath.Join(dir, ".stversions")); !os.IsNotExist(err) {
+		t.Error("Versions directory created before archiving anything")
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "sub", "file")
+	if err := ioutil.WriteFile(file, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Archive(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Error("File not archived")
+	}
+	if versions, _ := filepath.Glob(filepath.Join(dir, ".stversions", "sub", "file~*")); len(versions) != 1 {
+		t.Errorf("Unexpected versions %v", versions)
+	}
+}
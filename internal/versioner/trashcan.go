@@ -0,0 +1,154 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package versioner
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/osutil"
+)
+
+func init() {
+	// Register the constructor for this type of versioner with the name "trashcan"
+	Factories["trashcan"] = NewTrashcan
+}
+
+// Trashcan works like a recycle bin: replaced and deleted files are moved
+// to the same name in .stversions, where only the latest version of each is
+// kept.
+type Trashcan struct {
+	folderID     string
+	folderPath   string
+	cleanoutDays int
+}
+
+// The constructor function takes a map of parameters and creates the type.
+// Files that have been in the trash can for "cleanoutDays" days are removed
+// once an hour; zero, the default, keeps them forever.
+func NewTrashcan(folderID, folderPath string, params map[string]string) Versioner {
+	cleanoutDays, _ := strconv.Atoi(params["cleanoutDays"])
+
+	t := Trashcan{
+		folderID:     folderID,
+		folderPath:   folderPath,
+		cleanoutDays: cleanoutDays,
+	}
+
+	if debug() {
+		l.Debugf("instantiated %#v", t)
+	}
+
+	if cleanoutDays > 0 {
+		go func() {
+			t.cleanout()
+			for _ = range time.Tick(time.Hour) {
+				t.cleanout()
+			}
+		}()
+	}
+
+	return t
+}
+
+// Move away the named file to a version archive. If this function returns
+// nil, the named file does not exist any more (has been archived).
+func (t Trashcan) Archive(filePath string) error {
+	_, err := os.Lstat(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if debug() {
+				l.Debugln("not archiving nonexistent file", filePath)
+			}
+			return nil
+		}
+		return err
+	}
+
+	versionsDir := filepath.Join(t.folderPath, ".stversions")
+	if _, err := os.Stat(versionsDir); os.IsNotExist(err) {
+		if debug() {
+			l.Debugln("creating versions dir", versionsDir)
+		}
+		os.MkdirAll(versionsDir, 0755)
+		osutil.HideFile(versionsDir)
+	}
+
+	inFolderPath, err := filepath.Rel(t.folderPath, filePath)
+	if err != nil {
+		return err
+	}
+
+	dst := filepath.Join(versionsDir, inFolderPath)
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	if debug() {
+		l.Debugln("moving to", dst)
+	}
+	err = osutil.Rename(filePath, dst)
+	if err != nil {
+		return err
+	}
+
+	// The modification time records when the file was put in the trash
+	// can, for the cleanout
+	now := time.Now()
+	os.Chtimes(dst, now, now)
+	return nil
+}
+
+// cleanout removes the files that have been in the trash can for longer
+// than cleanoutDays, and the directories left empty.
+func (t Trashcan) cleanout() {
+	versionsDir := filepath.Join(t.folderPath, ".stversions")
+	if _, err := os.Stat(versionsDir); os.IsNotExist(err) {
+		return
+	}
+	if debug() {
+		l.Debugln("Trashcan: cleaning out", versionsDir)
+	}
+
+	cutoff := time.Now().Add(-time.Duration(t.cleanoutDays) * 24 * time.Hour)
+	var dirs []string
+	err := filepath.Walk(versionsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != versionsDir {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		if !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		if name, err := filepath.Rel(versionsDir, path); err == nil && !mayRemoveVersions(t.folderID, name) {
+			return nil
+		}
+		if debug() {
+			l.Debugln("Trashcan: removing", path)
+		}
+		if err := os.Remove(path); err != nil {
+			l.Warnf("Trashcan: can't remove %q: %v", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		l.Warnln("Trashcan: error scanning versions dir", err)
+		return
+	}
+
+	// Innermost directories first, so that their parents are empty by the
+	// time we get to them. Removing a directory that isn't empty fails.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package versioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrashcanArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trashcan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "sub", "file.txt")
+	os.MkdirAll(filepath.Dir(name), 0755)
+
+	v := NewTrashcan("default", dir, nil)
+	for _, data := range []string{"first", "second"} {
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-48 * time.Hour)
+		os.Chtimes(name, old, old)
+		if err := v.Archive(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Lstat(name); !os.IsNotExist(err) {
+		t.Error("File not moved away")
+	}
+
+	// Only the latest version is kept, under the same name, and dated when
+	// it was moved
+	trashed := filepath.Join(dir, ".stversions", "sub", "file.txt")
+	bs, err := ioutil.ReadFile(trashed)
+	if err != nil || string(bs) != "second" {
+		t.Errorf("Incorrect trashed file: %q, %v", bs, err)
+	}
+	if info, err := os.Stat(trashed); err != nil || time.Since(info.ModTime()) > time.Hour {
+		t.Errorf("Trashed file not dated now: %v, %v", info, err)
+	}
+
+	// A file that doesn't exist is already gone
+	if err := v.Archive(filepath.Join(dir, "nonexistent")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTrashcanCleanout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trashcan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	files := map[string]time.Duration{
+		"recent":                            24 * time.Hour,
+		filepath.Join("old", "file"):        10 * 24 * time.Hour,
+		filepath.Join("mixed", "old"):       10 * 24 * time.Hour,
+		filepath.Join("mixed", "recent"):    time.Hour,
+		filepath.Join("old", "deeper", "x"): 30 * 24 * time.Hour,
+	}
+	for name, age := range files {
+		path := filepath.Join(dir, ".stversions", name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, now.Add(-age), now.Add(-age))
+	}
+
+	Trashcan{folderID: "default", folderPath: dir, cleanoutDays: 7}.cleanout()
+
+	for name, age := range files {
+		_, err := os.Lstat(filepath.Join(dir, ".stversions", name))
+		if age > 7*24*time.Hour && !os.IsNotExist(err) {
+			t.Errorf("%s not removed", name)
+		} else if age < 7*24*time.Hour && err != nil {
+			t.Errorf("%s removed: %v", name, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dir, ".stversions", "old")); !os.IsNotExist(err) {
+		t.Error("Empty directory not removed")
+	}
+	if _, err := os.Lstat(filepath.Join(dir, ".stversions")); err != nil {
+		t.Error("Versions directory removed")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import "strings"
+
+const (
+	// The cluster config option listing the capabilities of the sender,
+	// separated by commas.
+	optionCapabilities = "capabilities"
+
+	// CapabilityAttributes means the device understands AttributesMessage.
+	// Devices without it never see file attributes.
+	CapabilityAttributes = "attributes"
+
+	// CapabilityCompressedIndex means the device wants Index and
+	// IndexUpdate messages compressed, regardless of the compression
+	// setting for other messages.
+	CapabilityCompressedIndex = "compressedIndex"
+
+	// CapabilityUnshare means the device understands UnshareMessage.
+	CapabilityUnshare = "unshare"
+)
+
+func hasCapability(cc ClusterConfigMessage, capability string) bool {
+	for _, c := range strings.Split(cc.GetOption(optionCapabilities), ",") {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAttribute returns the value of the attribute with the given key and
+// whether it was set.
+func (f FileInfo) GetAttribute(key string) (string, bool) {
+	for _, a := range f.Attributes {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetAttribute sets the attribute with the given key to the given value.
+func (f *FileInfo) SetAttribute(key, value string) {
+	// The attribute list may be shared with other copies of the FileInfo,
+	// so it's never modified in place.
+	attrs := make([]Option, 0, len(f.Attributes)+1)
+	for _, a := range f.Attributes {
+		if a.Key != key {
+			attrs = append(attrs, a)
+		}
+	}
+	f.Attributes = append(attrs, Option{key, value})
+}
+
+// RemoveAttribute removes the attribute with the given key, if it is set.
+func (f *FileInfo) RemoveAttribute(key string) {
+	if _, ok := f.GetAttribute(key); !ok {
+		return
+	}
+	var attrs []Option
+	for _, a := range f.Attributes {
+		if a.Key != key {
+			attrs = append(attrs, a)
+		}
+	}
+	f.Attributes = attrs
+}
+
+// indexAttributes returns the attributes of the files in an index message,
+// for the files that have any.
+func indexAttributes(fs []FileInfo) []FileAttributes {
+	var attrs []FileAttributes
+	for _, f := range fs {
+		if len(f.Attributes) > 0 {
+			attrs = append(attrs, FileAttributes{f.Name, f.Version, f.Attributes})
+		}
+	}
+	return attrs
+}
+
+// applyPendingAttributes sets the attributes received in the latest
+// AttributesMessage on the matching files in the index message.
+func (c *rawConnection) applyPendingAttributes(im IndexMessage) {
+	am := c.pendingAttrs
+	c.pendingAttrs = nil
+	if am == nil || am.Folder != im.Folder {
+		return
+	}
+
+	attrs := make(map[string]FileAttributes, len(am.Files))
+	for _, fa := range am.Files {
+		attrs[fa.Name] = fa
+	}
+	for i, f := range im.Files {
+		if fa, ok := attrs[f.Name]; ok && fa.Version == f.Version {
+			im.Files[i].Attributes = fa.Attributes
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type indexModel struct {
+	*TestModel
+	index chan []FileInfo
+}
+
+func (m indexModel) Index(deviceID DeviceID, folder string, files []FileInfo) {
+	m.index <- files
+}
+
+func TestIndexAttributes(t *testing.T) {
+	attrs := []Option{{"some.key", "some value"}}
+	files := []FileInfo{
+		{Name: "a", Version: 1, Attributes: attrs},
+		{Name: "b", Version: 2},
+	}
+
+	for _, capable := range []bool{true, false} {
+		m1 := indexModel{newTestModel(), make(chan []FileInfo, 1)}
+
+		ar, aw := io.Pipe()
+		br, bw := io.Pipe()
+
+		c0 := NewConnection(c0ID, ar, bw, newTestModel(), "name", true).(wireFormatConnection).next.(*rawConnection)
+		c1 := NewConnection(c1ID, br, aw, m1, "name", true).(wireFormatConnection).next.(*rawConnection)
+
+		if capable {
+			c1.ClusterConfig(ClusterConfigMessage{})
+		} else {
+			// An old device, sending no capabilities
+			c1.send(-1, messageTypeClusterConfig, ClusterConfigMessage{})
+		}
+		c0.ClusterConfig(ClusterConfigMessage{})
+		c0.Index("default", files)
+
+		var recv []FileInfo
+		select {
+		case recv = <-m1.index:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for index")
+		}
+
+		if capable && !reflect.DeepEqual(recv[0].Attributes, attrs) {
+			t.Errorf("unexpected attributes %v != %v", recv[0].Attributes, attrs)
+		}
+		if !capable && recv[0].Attributes != nil {
+			t.Errorf("unexpected attributes %v sent to old device", recv[0].Attributes)
+		}
+		if recv[1].Attributes != nil {
+			t.Errorf("unexpected attributes %v", recv[1].Attributes)
+		}
+	}
+}
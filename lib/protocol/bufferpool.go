@@ -0,0 +1,58 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import "sync"
+
+// BufferPool holds block sized buffers for reuse. Buffers are handed out by
+// the model for requests from other devices, and by the connection for
+// responses to our own requests. They are put back by the connection once a
+// response has been sent, and should be put back by the caller of Request
+// once the data is no longer needed.
+var BufferPool = newBufferPool(1<<10, BlockSize)
+
+type bufferPool struct {
+	sizes []int // increasing powers of two
+	pools []sync.Pool
+}
+
+func newBufferPool(min, max int) *bufferPool {
+	p := &bufferPool{}
+	for size := min; size <= max; size *= 2 {
+		p.sizes = append(p.sizes, size)
+	}
+	p.pools = make([]sync.Pool, len(p.sizes))
+	return p
+}
+
+// Get returns a buffer of the given length. Buffers larger than the largest
+// pooled size are allocated as usual.
+func (p *bufferPool) Get(size int) []byte {
+	for i, s := range p.sizes {
+		if size <= s {
+			if bs, ok := p.pools[i].Get().([]byte); ok {
+				return bs[:size]
+			}
+			return make([]byte, size, s)
+		}
+	}
+	return make([]byte, size)
+}
+
+// Put returns a buffer to the pool. It is pooled according to its capacity,
+// so buffers not obtained from Get may also be put back. The buffer must not
+// be used afterwards.
+func (p *bufferPool) Put(bs []byte) {
+	for i := len(p.sizes) - 1; i >= 0; i-- {
+		if cap(bs) >= p.sizes[i] {
+			if i == len(p.sizes)-1 && cap(bs) > 2*p.sizes[i] {
+				// Don't hang on to unusually large buffers
+				return
+			}
+			p.pools[i].Put(bs[:p.sizes[i]])
+			return
+		}
+	}
+}
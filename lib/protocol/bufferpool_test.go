@@ -0,0 +1,40 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import "testing"
+
+func TestBufferPoolSizes(t *testing.T) {
+	p := newBufferPool(1<<10, 128<<10)
+
+	for _, size := range []int{0, 1, 1 << 10, 1<<10 + 1, 100 << 10, 128 << 10, 200 << 10} {
+		bs := p.Get(size)
+		if len(bs) != size {
+			t.Errorf("Get(%d) returned length %d", size, len(bs))
+		}
+		p.Put(bs)
+		bs = p.Get(size)
+		if len(bs) != size {
+			t.Errorf("Get(%d) after Put returned length %d", size, len(bs))
+		}
+	}
+}
+
+func TestUnmarshalResponse(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("a"), []byte("abcd"), make([]byte, 128<<10-3)} {
+		bs := ResponseMessage{data}.MarshalXDR()
+		resp, err := unmarshalResponse(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Data) != string(data) {
+			t.Errorf("Response data of length %d decoded as length %d", len(data), len(resp.Data))
+		}
+	}
+
+	if _, err := unmarshalResponse([]byte{0, 0, 1, 0, 1, 2}); err == nil {
+		t.Error("Expected error for truncated response")
+	}
+}
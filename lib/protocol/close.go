@@ -0,0 +1,27 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// ReasonPaused is the reason given when closing a connection to a device
+// that is paused, so that it can tell being paused from a network failure.
+// The receiver sees it as the error the connection was closed with.
+const ReasonPaused = "paused"
+
+// WriteClose writes a close message with the given reason. It is for
+// refusing a connection that is never handed to NewConnection.
+func WriteClose(w io.Writer, reason string) error {
+	msg := CloseMessage{Reason: reason}.MarshalXDR()
+	bs := make([]byte, 8+len(msg))
+	binary.BigEndian.PutUint32(bs[0:4], encodeHeader(header{msgType: messageTypeClose}))
+	binary.BigEndian.PutUint32(bs[4:8], uint32(len(msg)))
+	copy(bs[8:], msg)
+	_, err := w.Write(bs)
+	return err
+}
@@ -0,0 +1,26 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWriteClose(t *testing.T) {
+	m := newTestModel()
+	r, w := io.Pipe()
+	NewConnection(c1ID, r, ioutil.Discard, m, "name", true)
+
+	go WriteClose(w, ReasonPaused)
+
+	if !m.isClosed() {
+		t.Fatal("Connection should be closed")
+	}
+	if m.closeErr == nil || m.closeErr.Error() != ReasonPaused {
+		t.Errorf("Unexpected close error %v", m.closeErr)
+	}
+}
@@ -15,6 +15,7 @@ type TestModel struct {
 	name     string
 	offset   int64
 	size     int
+	closeErr error
 	closedCh chan bool
 }
 
@@ -39,12 +40,16 @@ func (t *TestModel) Request(deviceID DeviceID, folder, name string, offset int64
 }
 
 func (t *TestModel) Close(deviceID DeviceID, err error) {
+	t.closeErr = err
 	close(t.closedCh)
 }
 
 func (t *TestModel) ClusterConfig(deviceID DeviceID, config ClusterConfigMessage) {
 }
 
+func (t *TestModel) Unshare(deviceID DeviceID, folder string, delete bool) {
+}
+
 func (t *TestModel) isClosed() bool {
 	select {
 	case <-t.closedCh:
@@ -0,0 +1,32 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package protocol implements the Block Exchange Protocol, as described in
+// protocol/PROTOCOL.md. It can be used to build alternative clients and
+// test harnesses that talk to syncthing.
+//
+// A session starts with a TLS connection where each side presents its
+// certificate and offers TLSProtocol. The device ID of the other side is
+// given by PeerDeviceID, after which the connection is wrapped with
+// NewConnection and both sides send their ClusterConfig:
+//
+//	tc := tls.Client(conn, &tls.Config{
+//		Certificates:       []tls.Certificate{cert},
+//		NextProtos:         []string{protocol.TLSProtocol},
+//		InsecureSkipVerify: true,
+//	})
+//	if err := tc.Handshake(); err != nil {
+//		return err
+//	}
+//	id, err := protocol.PeerDeviceID(tc.ConnectionState())
+//	if err != nil {
+//		return err
+//	}
+//	c := protocol.NewConnection(id, tc, tc, model, "name", true)
+//	c.ClusterConfig(protocol.ClusterConfigMessage{...})
+//
+// The exported message types, the Connection and Model interfaces and the
+// device ID functions follow the protocol version and are kept compatible
+// within it. New fields and methods may be added.
+package protocol
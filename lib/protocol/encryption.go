@@ -0,0 +1,322 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// Folders shared with an untrusted device are sent to it encrypted with a
+// key derived from a folder password, so that it can store and pass on the
+// data without being able to read it. The encrypted variant of a FileInfo
+// has an encrypted name, the flags of a plain file and a fixed modification
+// time. Its blocks are the encrypted blocks of the file, each larger by
+// EncryptionOverhead, with the hashes of the plaintext blocks sealed in
+// their place. The real flags and modification time travel encrypted in the
+// AttributeEncrypted attribute, along with the version, which must match the
+// one in the clear.
+//
+// The metadata and the block hashes are bound to the plaintext name, and the
+// hashes to their place in the file, so that an untrusted device can't pass
+// off the metadata or contents of one file or version as another.
+//
+// Names and block hashes are encrypted deterministically, so that devices
+// knowing the password encrypt a file the same way; block data and the
+// metadata attribute use random nonces.
+
+const (
+	// EncryptionOverhead is the number of bytes an encrypted block is
+	// larger than its plaintext; the nonce and the authentication tag.
+	EncryptionOverhead = nonceSize + tagSize
+
+	// AttributeEncrypted is the attribute carrying the encrypted metadata
+	// of an encrypted file.
+	AttributeEncrypted = "encrypted"
+
+	nonceSize = 12
+	tagSize   = 16
+
+	encryptionKeyIterations = 100000
+	encryptedModified       = 946684800 // 2000-01-01T00:00:00Z
+	encryptedNameChunk      = 200       // characters per path component
+	encryptedMetaSize       = 4 + 8 + 8 // flags, modification time, version
+)
+
+var ErrDecryption = errors.New("decryption failed")
+
+var encryptedNameEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// An EncryptionKey encrypts and decrypts the files of a folder for untrusted
+// devices.
+type EncryptionKey struct {
+	aead     cipher.AEAD
+	nonceKey []byte // for deriving the nonces of deterministic encryption
+}
+
+// NewEncryptionKey derives the key for the folder from the password. It is
+// deliberately slow.
+func NewEncryptionKey(folder, password string) *EncryptionKey {
+	master := pbkdf2([]byte(password), []byte("syncthing encryption "+folder), encryptionKeyIterations)
+
+	block, err := aes.NewCipher(subKey(master, "data"))
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return &EncryptionKey{
+		aead:     aead,
+		nonceKey: subKey(master, "nonce"),
+	}
+}
+
+// EncryptName returns the encrypted form of the file name. It is spread
+// over a few directory levels, as "A/BC/DEF...", to keep the number of
+// entries in each directory and the length of each path component down.
+func (k *EncryptionKey) EncryptName(name string) string {
+	ct := k.sealDeterministic("name", []byte(filepath.ToSlash(name)), nil)
+	enc := encryptedNameEncoding.EncodeToString(ct)
+
+	parts := []string{enc[:1], enc[1:3]}
+	for rest := enc[3:]; len(rest) > 0; {
+		n := len(rest)
+		if n > encryptedNameChunk {
+			n = encryptedNameChunk
+		}
+		parts = append(parts, rest[:n])
+		rest = rest[n:]
+	}
+	return filepath.FromSlash(strings.Join(parts, "/"))
+}
+
+// DecryptName returns the file name encrypted by EncryptName.
+func (k *EncryptionKey) DecryptName(name string) (string, error) {
+	ct, err := encryptedNameEncoding.DecodeString(strings.Replace(filepath.ToSlash(name), "/", "", -1))
+	if err != nil {
+		return "", ErrDecryption
+	}
+	bs, err := k.open(ct, nil)
+	if err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(string(bs)), nil
+}
+
+// EncryptFileInfo returns the encrypted variant of the file.
+func (k *EncryptionKey) EncryptFileInfo(f FileInfo) FileInfo {
+	enc := FileInfo{
+		Name:         k.EncryptName(f.Name),
+		Flags:        f.Flags&(FlagDeleted|FlagInvalid) | FlagNoPermBits | 0644,
+		Modified:     encryptedModified,
+		Version:      f.Version,
+		LocalVersion: f.LocalVersion,
+	}
+	if !f.IsDeleted() && !IsDirectory(f.Flags) {
+		enc.Blocks = make([]BlockInfo, len(f.Blocks))
+		for i, b := range f.Blocks {
+			enc.Blocks[i] = BlockInfo{
+				Offset: int64(i) * (BlockSize + EncryptionOverhead),
+				Size:   b.Size + EncryptionOverhead,
+				Hash:   k.sealDeterministic("hash", b.Hash, blockAD(f.Name, i)),
+			}
+		}
+	}
+
+	var meta [encryptedMetaSize]byte
+	binary.BigEndian.PutUint32(meta[:], f.Flags)
+	binary.BigEndian.PutUint64(meta[4:], uint64(f.Modified))
+	binary.BigEndian.PutUint64(meta[12:], f.Version)
+	enc.SetAttribute(AttributeEncrypted, base64.RawStdEncoding.EncodeToString(k.seal(meta[:], nameAD(f.Name))))
+	return enc
+}
+
+// DecryptFileInfo returns the file encrypted by EncryptFileInfo. Files
+// marked invalid by the untrusted device stay invalid. Attributes other
+// than the encrypted metadata are not kept.
+func (k *EncryptionKey) DecryptFileInfo(f FileInfo) (FileInfo, error) {
+	name, err := k.DecryptName(f.Name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	value, ok := f.GetAttribute(AttributeEncrypted)
+	if !ok {
+		return FileInfo{}, ErrDecryption
+	}
+	ct, err := base64.RawStdEncoding.DecodeString(value)
+	if err != nil {
+		return FileInfo{}, ErrDecryption
+	}
+	meta, err := k.open(ct, nameAD(name))
+	if err != nil || len(meta) != encryptedMetaSize || binary.BigEndian.Uint64(meta[12:]) != f.Version {
+		return FileInfo{}, ErrDecryption
+	}
+
+	dec := FileInfo{
+		Name:         name,
+		Flags:        binary.BigEndian.Uint32(meta) | f.Flags&FlagInvalid,
+		Modified:     int64(binary.BigEndian.Uint64(meta[4:])),
+		Version:      f.Version,
+		LocalVersion: f.LocalVersion,
+	}
+	if !IsDeleted(dec.Flags) && !IsDirectory(dec.Flags) {
+		dec.Blocks = make([]BlockInfo, len(f.Blocks))
+		for i, b := range f.Blocks {
+			if b.Size < EncryptionOverhead {
+				return FileInfo{}, ErrDecryption
+			}
+			hash, err := k.open(b.Hash, blockAD(name, i))
+			if err != nil {
+				return FileInfo{}, err
+			}
+			dec.Blocks[i] = BlockInfo{
+				Offset: int64(i) * BlockSize,
+				Size:   b.Size - EncryptionOverhead,
+				Hash:   hash,
+			}
+		}
+	}
+	return dec, nil
+}
+
+// EncryptedDirectory returns the entry of a directory the encrypted names
+// are spread over. It has an empty AttributeEncrypted, as it doesn't
+// decrypt to anything.
+func EncryptedDirectory(name string) FileInfo {
+	f := FileInfo{
+		Name:     name,
+		Flags:    FlagDirectory | 0755,
+		Modified: encryptedModified,
+		Version:  1,
+	}
+	f.SetAttribute(AttributeEncrypted, "")
+	return f
+}
+
+// EncryptBlock returns the encrypted block data. The returned buffer comes
+// from BufferPool.
+func (k *EncryptionKey) EncryptBlock(data []byte) []byte {
+	bs := BufferPool.Get(len(data) + EncryptionOverhead)
+	if _, err := rand.Read(bs[:nonceSize]); err != nil {
+		panic(err)
+	}
+	return k.aead.Seal(bs[:nonceSize], bs[:nonceSize], data, nil)
+}
+
+// DecryptBlock returns the block data encrypted by EncryptBlock. The
+// returned buffer comes from BufferPool.
+func (k *EncryptionKey) DecryptBlock(data []byte) ([]byte, error) {
+	if len(data) < EncryptionOverhead {
+		return nil, ErrDecryption
+	}
+	buf := BufferPool.Get(len(data) - EncryptionOverhead)
+	bs, err := k.aead.Open(buf[:0], data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		BufferPool.Put(buf)
+		return nil, ErrDecryption
+	}
+	return bs, nil
+}
+
+// EncryptedOffset returns the offset of the encrypted block holding the
+// block at the given offset in the plaintext file.
+func EncryptedOffset(offset int64) int64 {
+	return offset + offset/BlockSize*EncryptionOverhead
+}
+
+// PlaintextOffset returns the offset of the plaintext block held by the
+// encrypted block at the given offset.
+func PlaintextOffset(offset int64) int64 {
+	return offset - offset/(BlockSize+EncryptionOverhead)*EncryptionOverhead
+}
+
+// nameAD returns the additional data binding the metadata of a file to its
+// plaintext name.
+func nameAD(name string) []byte {
+	return []byte(filepath.ToSlash(name))
+}
+
+// blockAD returns the additional data binding the hash of a block to the
+// plaintext name of the file and the index of the block in it.
+func blockAD(name string, index int) []byte {
+	ad := make([]byte, 8, 8+len(name))
+	binary.BigEndian.PutUint64(ad, uint64(index))
+	return append(ad, filepath.ToSlash(name)...)
+}
+
+// seal encrypts the data with a random nonce, which is prepended. The
+// additional data is authenticated but not included.
+func (k *EncryptionKey) seal(data, ad []byte) []byte {
+	nonce := make([]byte, nonceSize, nonceSize+len(data)+tagSize)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return k.aead.Seal(nonce, nonce, data, ad)
+}
+
+// sealDeterministic encrypts the data with a nonce derived from it and the
+// additional data, so that the same data always encrypts the same way. The
+// kind separates the nonces of different uses.
+func (k *EncryptionKey) sealDeterministic(kind string, data, ad []byte) []byte {
+	mac := hmac.New(sha256.New, k.nonceKey)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	var adLen [8]byte
+	binary.BigEndian.PutUint64(adLen[:], uint64(len(ad)))
+	mac.Write(adLen[:])
+	mac.Write(ad)
+	mac.Write(data)
+	nonce := mac.Sum(nil)[:nonceSize]
+	return k.aead.Seal(nonce, nonce, data, ad)
+}
+
+// open decrypts data encrypted by seal or sealDeterministic with the same
+// additional data.
+func (k *EncryptionKey) open(ct, ad []byte) ([]byte, error) {
+	if len(ct) < nonceSize+tagSize {
+		return nil, ErrDecryption
+	}
+	bs, err := k.aead.Open(nil, ct[:nonceSize], ct[nonceSize:], ad)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return bs, nil
+}
+
+func subKey(master []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// pbkdf2 is PBKDF2 with HMAC-SHA256 (RFC 2898), returning a single 32 byte
+// block.
+func pbkdf2(password, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	key := append([]byte(nil), u...)
+	for n := 1; n < iterations; n++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for i := range key {
+			key[i] ^= u[i]
+		}
+	}
+	return key
+}
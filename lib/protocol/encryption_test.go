@@ -0,0 +1,188 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// Test vectors for PBKDF2-HMAC-SHA256
+	cases := []struct {
+		iterations int
+		key        string
+	}{
+		{1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+	}
+	for _, tc := range cases {
+		if key := hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), tc.iterations)); key != tc.key {
+			t.Errorf("%d iterations: got %s, expected %s", tc.iterations, key, tc.key)
+		}
+	}
+}
+
+func TestEncryptName(t *testing.T) {
+	key := NewEncryptionKey("default", "secret")
+
+	for _, name := range []string{"a", filepath.Join("dir", "file.txt"), strings.Repeat("x", 1000)} {
+		enc := key.EncryptName(name)
+		if enc != key.EncryptName(name) {
+			t.Errorf("%q: encryption not deterministic", name)
+		}
+		if strings.Contains(enc, name) {
+			t.Errorf("%q: name visible in %q", name, enc)
+		}
+		parts := strings.Split(filepath.ToSlash(enc), "/")
+		if len(parts) < 3 || len(parts[0]) != 1 || len(parts[1]) != 2 {
+			t.Errorf("%q: unexpected layout %q", name, enc)
+		}
+		for _, part := range parts {
+			if len(part) > encryptedNameChunk {
+				t.Errorf("%q: component too long in %q", name, enc)
+			}
+		}
+
+		dec, err := key.DecryptName(enc)
+		if err != nil || dec != name {
+			t.Errorf("%q: decrypted to %q, %v", name, dec, err)
+		}
+		if _, err := NewEncryptionKey("default", "wrong").DecryptName(enc); err != ErrDecryption {
+			t.Errorf("%q: decrypted with the wrong password", name)
+		}
+		if _, err := NewEncryptionKey("other", "secret").DecryptName(enc); err != ErrDecryption {
+			t.Errorf("%q: decrypted with the key for another folder", name)
+		}
+	}
+
+	if _, err := key.DecryptName(filepath.Join("A", "BC")); err != ErrDecryption {
+		t.Error("directory of encrypted names decrypted")
+	}
+}
+
+func TestEncryptFileInfo(t *testing.T) {
+	key := NewEncryptionKey("default", "secret")
+
+	data := make([]byte, BlockSize+10)
+	hash0 := sha256.Sum256(data[:BlockSize])
+	hash1 := sha256.Sum256(data[BlockSize:])
+	files := []FileInfo{
+		{
+			Name:     filepath.Join("dir", "file"),
+			Flags:    0755,
+			Modified: 1234567890,
+			Version:  42,
+			Blocks: []BlockInfo{
+				{Offset: 0, Size: BlockSize, Hash: hash0[:]},
+				{Offset: BlockSize, Size: 10, Hash: hash1[:]},
+			},
+		},
+		{Name: "dir", Flags: FlagDirectory | 0700, Modified: 1000, Version: 43},
+		{Name: "gone", Flags: FlagDeleted, Modified: 2000, Version: 44},
+	}
+
+	for _, f := range files {
+		enc := key.EncryptFileInfo(f)
+		if enc.Name == f.Name || enc.Modified == f.Modified || IsDirectory(enc.Flags) || enc.Flags&0777 != 0644 {
+			t.Errorf("%q: not hidden: %v", f.Name, enc)
+		}
+		if enc.Version != f.Version || enc.IsDeleted() != f.IsDeleted() {
+			t.Errorf("%q: version or deletion lost: %v", f.Name, enc)
+		}
+		if len(enc.Blocks) != len(f.Blocks) {
+			t.Fatalf("%q: unexpected blocks %v", f.Name, enc.Blocks)
+		}
+		for i, b := range enc.Blocks {
+			if b.Size != f.Blocks[i].Size+EncryptionOverhead || b.Offset != EncryptedOffset(f.Blocks[i].Offset) || len(b.Hash) > 64 || bytes.Equal(b.Hash, f.Blocks[i].Hash) {
+				t.Errorf("%q: unexpected block %v", f.Name, b)
+			}
+		}
+
+		dec, err := key.DecryptFileInfo(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(dec, f) {
+			t.Errorf("decrypted to %v, expected %v", dec, f)
+		}
+	}
+
+	// Without the metadata the file can't be decrypted
+	enc := key.EncryptFileInfo(files[0])
+	enc.RemoveAttribute(AttributeEncrypted)
+	if _, err := key.DecryptFileInfo(enc); err != ErrDecryption {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// Nor with a version other than the one sealed in it
+	enc = key.EncryptFileInfo(files[0])
+	enc.Version++
+	if _, err := key.DecryptFileInfo(enc); err != ErrDecryption {
+		t.Errorf("tampered version: unexpected error %v", err)
+	}
+
+	// Nor under the name of another file, taking its metadata or its
+	// block hashes
+	other := files[0]
+	other.Name = "other"
+	encOther := key.EncryptFileInfo(other)
+
+	enc = key.EncryptFileInfo(files[0])
+	value, _ := encOther.GetAttribute(AttributeEncrypted)
+	enc.SetAttribute(AttributeEncrypted, value)
+	if _, err := key.DecryptFileInfo(enc); err != ErrDecryption {
+		t.Errorf("swapped metadata: unexpected error %v", err)
+	}
+
+	enc = key.EncryptFileInfo(files[0])
+	enc.Blocks = encOther.Blocks
+	if _, err := key.DecryptFileInfo(enc); err != ErrDecryption {
+		t.Errorf("swapped blocks: unexpected error %v", err)
+	}
+
+	// Nor with its blocks in another order
+	enc = key.EncryptFileInfo(files[0])
+	enc.Blocks[0].Hash, enc.Blocks[1].Hash = enc.Blocks[1].Hash, enc.Blocks[0].Hash
+	if _, err := key.DecryptFileInfo(enc); err != ErrDecryption {
+		t.Errorf("reordered blocks: unexpected error %v", err)
+	}
+}
+
+func TestEncryptBlock(t *testing.T) {
+	key := NewEncryptionKey("default", "secret")
+
+	data := []byte("some block data")
+	enc := key.EncryptBlock(data)
+	if len(enc) != len(data)+EncryptionOverhead || bytes.Contains(enc, data) {
+		t.Errorf("unexpected encrypted block %x", enc)
+	}
+	dec, err := key.DecryptBlock(enc)
+	if err != nil || !bytes.Equal(dec, data) {
+		t.Errorf("decrypted to %q, %v", dec, err)
+	}
+
+	enc[len(enc)-1] ^= 1
+	if _, err := key.DecryptBlock(enc); err != ErrDecryption {
+		t.Error("modified block decrypted")
+	}
+}
+
+func TestEncryptedOffset(t *testing.T) {
+	for i := int64(0); i < 5; i++ {
+		enc := EncryptedOffset(i * BlockSize)
+		if enc != i*(BlockSize+EncryptionOverhead) {
+			t.Errorf("block %d: unexpected encrypted offset %d", i, enc)
+		}
+		if off := PlaintextOffset(enc); off != i*BlockSize {
+			t.Errorf("block %d: unexpected plaintext offset %d", i, off)
+		}
+	}
+}
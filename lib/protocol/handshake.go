@@ -0,0 +1,24 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// TLSProtocol is the protocol name negotiated by both sides of the TLS
+// connection.
+const TLSProtocol = "bep/1.0"
+
+// PeerDeviceID returns the device ID of the other side of a TLS connection
+// that has completed the handshake. The other side must have presented
+// exactly one certificate.
+func PeerDeviceID(state tls.ConnectionState) (DeviceID, error) {
+	if cl := len(state.PeerCertificates); cl != 1 {
+		return DeviceID{}, fmt.Errorf("got peer certificate list of length %d != 1", cl)
+	}
+	return NewDeviceID(state.PeerCertificates[0].Raw), nil
+}
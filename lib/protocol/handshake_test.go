@@ -0,0 +1,29 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+)
+
+func TestPeerDeviceID(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("certificate")}
+
+	id, err := PeerDeviceID(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != NewDeviceID(cert.Raw) {
+		t.Errorf("unexpected device ID %s", id)
+	}
+
+	for _, certs := range [][]*x509.Certificate{nil, {cert, cert}} {
+		if _, err := PeerDeviceID(tls.ConnectionState{PeerCertificates: certs}); err == nil {
+			t.Errorf("unexpected nil error for %d certificates", len(certs))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import "sync"
+
+// Block hashes are interned in a table of this many shards of this many
+// slots each. Each hash has one possible slot, given by its first bytes,
+// and replaces whatever was there before. This keeps the table small while
+// the hashes that are shared by many files, which are the ones worth
+// interning, tend to stay in it.
+const (
+	internShards = 256
+	internSlots  = 64
+)
+
+// Hashes that weren't interned are moved into shared arrays of this many
+// hashes, so that they don't need an allocation each. The arrays are kept
+// small as the table keeps any of them alive that it refers to.
+const internChunk = 16
+
+var hashes hashTable
+
+type hashTable struct {
+	shards [internShards]hashShard
+}
+
+type hashShard struct {
+	mut   sync.Mutex
+	slots [internSlots][]byte
+}
+
+func (t *hashTable) shard(hash []byte) (*hashShard, int) {
+	return &t.shards[int(hash[0])%internShards], int(hash[1]) % internSlots
+}
+
+// lookup returns the interned copy of the hash, or nil if there is none.
+func (t *hashTable) lookup(hash []byte) []byte {
+	s, i := t.shard(hash)
+	s.mut.Lock()
+	cur := s.slots[i]
+	s.mut.Unlock()
+	if string(cur) == string(hash) {
+		return cur
+	}
+	return nil
+}
+
+// store interns the hash, which must not be modified afterwards.
+func (t *hashTable) store(hash []byte) {
+	s, i := t.shard(hash)
+	s.mut.Lock()
+	s.slots[i] = hash
+	s.mut.Unlock()
+}
+
+// InternBlockHashes makes the block hashes share memory with identical
+// hashes of previously interned blocks where possible. The remaining hashes
+// are moved to arrays shared by several blocks, so that they don't each need
+// an allocation of their own, and are interned in turn. Interned hashes must
+// not be modified.
+func InternBlockHashes(blocks []BlockInfo) {
+	var missing int
+	for i := range blocks {
+		if len(blocks[i].Hash) < 2 {
+			continue
+		}
+		if h := hashes.lookup(blocks[i].Hash); h != nil {
+			blocks[i].Hash = h
+		} else {
+			missing++
+		}
+	}
+	if missing == 0 {
+		return
+	}
+
+	var shared []byte
+	for i := range blocks {
+		h := blocks[i].Hash
+		if len(h) < 2 {
+			continue
+		}
+		if t := hashes.lookup(h); t != nil {
+			// Interned above, or by an earlier block of this file
+			blocks[i].Hash = t
+			continue
+		}
+
+		if cap(shared)-len(shared) < len(h) {
+			// Others may have changed the table since we counted
+			n := missing
+			if n > internChunk {
+				n = internChunk
+			} else if n < 1 {
+				n = 1
+			}
+			shared = make([]byte, 0, n*len(h))
+		}
+		missing--
+		start := len(shared)
+		shared = append(shared, h...)
+		blocks[i].Hash = shared[start:len(shared):len(shared)]
+		hashes.store(blocks[i].Hash)
+	}
+}
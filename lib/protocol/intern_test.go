@@ -0,0 +1,50 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestInternBlockHashes(t *testing.T) {
+	mkBlocks := func(data ...string) []BlockInfo {
+		var bs []BlockInfo
+		for _, d := range data {
+			h := sha256.Sum256([]byte(d))
+			bs = append(bs, BlockInfo{Hash: h[:]})
+		}
+		return bs
+	}
+
+	b1 := mkBlocks("a", "b", "a")
+	b2 := mkBlocks("b", "c")
+	InternBlockHashes(b1)
+	InternBlockHashes(b2)
+
+	for i, bs := range [][]BlockInfo{b1, b2} {
+		for j, b := range bs {
+			if len(b.Hash) != 32 || cap(b.Hash) != 32 {
+				t.Errorf("%d/%d: bad hash slice len %d cap %d", i, j, len(b.Hash), cap(b.Hash))
+			}
+		}
+	}
+
+	want := mkBlocks("a", "b", "a")
+	for i := range b1 {
+		if !bytes.Equal(b1[i].Hash, want[i].Hash) {
+			t.Errorf("hash %d changed by interning", i)
+		}
+	}
+
+	// Identical hashes share memory, within a file and between files
+	if &b1[0].Hash[0] != &b1[2].Hash[0] {
+		t.Error("identical hashes in one file not shared")
+	}
+	if &b1[1].Hash[0] != &b2[0].Hash[0] {
+		t.Error("identical hashes in two files not shared")
+	}
+}
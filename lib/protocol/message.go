@@ -18,6 +18,7 @@ type FileInfo struct {
 	Version      uint64
 	LocalVersion uint64
 	Blocks       []BlockInfo
+	Attributes   []Option // noencode (sent in an AttributesMessage)
 }
 
 func (f FileInfo) String() string {
@@ -43,6 +44,13 @@ func (f FileInfo) IsInvalid() bool {
 	return IsInvalid(f.Flags)
 }
 
+// IsSymlink returns true if the file is a symbolic link. The link target is
+// the contents of the file, described by the block list as for any other
+// file.
+func (f FileInfo) IsSymlink() bool {
+	return IsSymlink(f.Flags)
+}
+
 // Used for unmarshalling a FileInfo structure but skipping the actual block list
 type FileInfoTruncated struct {
 	Name         string // max:8192
@@ -73,6 +81,10 @@ func (f FileInfoTruncated) IsInvalid() bool {
 	return IsInvalid(f.Flags)
 }
 
+func (f FileInfoTruncated) IsSymlink() bool {
+	return IsSymlink(f.Flags)
+}
+
 type FileIntf interface {
 	Size() int64
 	IsDeleted() bool
@@ -132,8 +144,30 @@ type Option struct {
 	Value string // max:1024
 }
 
+// An AttributesMessage carries extended metadata for files in the index
+// message that follows it. It is only sent to devices that announce
+// CapabilityAttributes.
+type AttributesMessage struct {
+	Folder string // max:64
+	Files  []FileAttributes
+}
+
+type FileAttributes struct {
+	Name       string // max:8192
+	Version    uint64
+	Attributes []Option // max:64
+}
+
 type CloseMessage struct {
 	Reason string // max:1024
 }
 
+// An UnshareMessage asks the receiver to stop syncing the folder, and to
+// delete its copy of it if FlagUnshareDelete is set. It is only sent to
+// devices that announce CapabilityUnshare.
+type UnshareMessage struct {
+	Folder string // max:64
+	Flags  uint32
+}
+
 type EmptyMessage struct{}
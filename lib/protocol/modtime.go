@@ -0,0 +1,35 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"strconv"
+	"time"
+)
+
+// AttrModifiedNs holds the sub-second part of the modification time in
+// nanoseconds, when it isn't zero. Devices without CapabilityAttributes see
+// whole seconds only.
+const AttrModifiedNs = "mtime.ns"
+
+// ModTime returns the modification time of the file, with the full precision
+// known.
+func (f FileInfo) ModTime() time.Time {
+	var ns int64
+	if v, ok := f.GetAttribute(AttrModifiedNs); ok {
+		ns, _ = strconv.ParseInt(v, 10, 64)
+	}
+	return time.Unix(f.Modified, ns)
+}
+
+// SetModTime sets the modification time of the file.
+func (f *FileInfo) SetModTime(t time.Time) {
+	f.Modified = t.Unix()
+	if ns := t.Nanosecond(); ns != 0 {
+		f.SetAttribute(AttrModifiedNs, strconv.Itoa(ns))
+	} else {
+		f.RemoveAttribute(AttrModifiedNs)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestModTime(t *testing.T) {
+	other := Option{"other", "value"}
+	f := FileInfo{Attributes: []Option{other}}
+
+	t0 := time.Unix(1415000000, 123456789)
+	f.SetModTime(t0)
+	if f.Modified != 1415000000 {
+		t.Errorf("Incorrect Modified %d", f.Modified)
+	}
+	if mt := f.ModTime(); !mt.Equal(t0) {
+		t.Errorf("Incorrect ModTime %v != %v", mt, t0)
+	}
+	if v, _ := f.GetAttribute("other"); v != "value" {
+		t.Errorf("Lost other attribute; %v", f.Attributes)
+	}
+
+	t1 := time.Unix(1415000001, 0)
+	f.SetModTime(t1)
+	if mt := f.ModTime(); !mt.Equal(t1) {
+		t.Errorf("Incorrect ModTime %v != %v", mt, t1)
+	}
+	if _, ok := f.GetAttribute(AttrModifiedNs); ok {
+		t.Error("Unexpected nanosecond attribute for whole second")
+	}
+	if len(f.Attributes) != 1 {
+		t.Errorf("Incorrect attributes %v", f.Attributes)
+	}
+
+	// A file from a device that only knows seconds
+	f = FileInfo{Modified: 1415000000}
+	if mt := f.ModTime(); !mt.Equal(time.Unix(1415000000, 0)) {
+		t.Errorf("Incorrect ModTime %v", mt)
+	}
+}
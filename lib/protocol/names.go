@@ -0,0 +1,46 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import "strings"
+
+// OptionName is the cluster config option carrying the name the sending
+// device has chosen for itself.
+const OptionName = "name"
+
+// Cluster config options prefixed by optionDeviceName carry the names the
+// sender knows other devices by. The rest of the key is the device ID
+// without dashes, to stay within the maximum key length.
+const (
+	optionDeviceName    = "name:"
+	maxDeviceNameLength = 1024
+)
+
+// DeviceNameOption returns the option announcing the name of the device.
+func DeviceNameOption(id DeviceID, name string) Option {
+	if len(name) > maxDeviceNameLength {
+		name = name[:maxDeviceNameLength]
+	}
+	return Option{
+		Key:   optionDeviceName + strings.Replace(id.String(), "-", "", -1),
+		Value: name,
+	}
+}
+
+// DeviceNames returns the names of other devices announced in the message.
+func (o *ClusterConfigMessage) DeviceNames() map[DeviceID]string {
+	names := make(map[DeviceID]string)
+	for _, option := range o.Options {
+		if !strings.HasPrefix(option.Key, optionDeviceName) || option.Value == "" {
+			continue
+		}
+		id, err := DeviceIDFromString(option.Key[len(optionDeviceName):])
+		if err != nil {
+			continue
+		}
+		names[id] = option.Value
+	}
+	return names
+}
@@ -0,0 +1,31 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeviceNames(t *testing.T) {
+	id, _ := DeviceIDFromString(formatted)
+
+	opt := DeviceNameOption(id, strings.Repeat("x", 2000))
+	if len(opt.Key) > 64 || len(opt.Value) > 1024 {
+		t.Errorf("option exceeds the protocol limits: %d, %d", len(opt.Key), len(opt.Value))
+	}
+
+	cm := ClusterConfigMessage{
+		Options: []Option{
+			{OptionName, "self"},
+			DeviceNameOption(id, "laptop"),
+			{optionDeviceName + "invalid", "other"},
+		},
+	}
+	names := cm.DeviceNames()
+	if len(names) != 1 || names[id] != "laptop" {
+		t.Errorf("unexpected names %v", names)
+	}
+}
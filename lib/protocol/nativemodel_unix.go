@@ -31,3 +31,7 @@ func (m nativeModel) ClusterConfig(deviceID DeviceID, config ClusterConfigMessag
 func (m nativeModel) Close(deviceID DeviceID, err error) {
 	m.next.Close(deviceID, err)
 }
+
+func (m nativeModel) Unshare(deviceID DeviceID, folder string, delete bool) {
+	m.next.Unshare(deviceID, folder, delete)
+}
@@ -6,21 +6,9 @@
 
 package protocol
 
-// Windows uses backslashes as file separator and disallows a bunch of
-// characters in the filename
+// Windows uses backslashes as file separator
 
-import (
-	"path/filepath"
-	"strings"
-)
-
-var disallowedCharacters = string([]rune{
-	'<', '>', ':', '"', '|', '?', '*',
-	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10,
-	11, 12, 13, 14, 15, 16, 17, 18, 19, 20,
-	21, 22, 23, 24, 25, 26, 27, 28, 29, 30,
-	31,
-})
+import "path/filepath"
 
 type nativeModel struct {
 	next Model
@@ -28,15 +16,6 @@ type nativeModel struct {
 
 func (m nativeModel) Index(deviceID DeviceID, folder string, files []FileInfo) {
 	for i, f := range files {
-		if strings.ContainsAny(f.Name, disallowedCharacters) {
-			if f.IsDeleted() {
-				// Don't complain if the file is marked as deleted, since it
-				// can't possibly exist here anyway.
-				continue
-			}
-			files[i].Flags |= FlagInvalid
-			l.Warnf("File name %q contains invalid characters; marked as invalid.", f.Name)
-		}
 		files[i].Name = filepath.FromSlash(f.Name)
 	}
 	m.next.Index(deviceID, folder, files)
@@ -44,16 +23,7 @@ func (m nativeModel) Index(deviceID DeviceID, folder string, files []FileInfo) {
 
 func (m nativeModel) IndexUpdate(deviceID DeviceID, folder string, files []FileInfo) {
 	for i, f := range files {
-		if strings.ContainsAny(f.Name, disallowedCharacters) {
-			if f.IsDeleted() {
-				// Don't complain if the file is marked as deleted, since it
-				// can't possibly exist here anyway.
-				continue
-			}
-			files[i].Flags |= FlagInvalid
-			l.Warnf("File name %q contains invalid characters; marked as invalid.", f.Name)
-		}
-		files[i].Name = filepath.FromSlash(files[i].Name)
+		files[i].Name = filepath.FromSlash(f.Name)
 	}
 	m.next.IndexUpdate(deviceID, folder, files)
 }
@@ -70,3 +40,7 @@ func (m nativeModel) ClusterConfig(deviceID DeviceID, config ClusterConfigMessag
 func (m nativeModel) Close(deviceID DeviceID, err error) {
 	m.next.Close(deviceID, err)
 }
+
+func (m nativeModel) Unshare(deviceID DeviceID, folder string, delete bool) {
+	m.next.Unshare(deviceID, folder, delete)
+}
@@ -0,0 +1,43 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"path"
+	"strings"
+)
+
+// Cluster config options prefixed by optionPathHint suggest where the
+// receiver should put a folder offered to it. The rest of the key is the
+// folder ID and the value a relative, slash separated path.
+const optionPathHint = "path:"
+
+// PathHintOption returns the option suggesting the path for the folder, and
+// false if the folder ID or the path are too long to be announced.
+func PathHintOption(folder, hint string) (Option, bool) {
+	key := optionPathHint + folder
+	if len(key) > 64 || len(hint) > 1024 {
+		return Option{}, false
+	}
+	return Option{Key: key, Value: hint}, true
+}
+
+// PathHints returns the suggested paths for folders announced in the
+// message. Paths that are absolute or lead outside the directory they are
+// relative to are left out.
+func (o *ClusterConfigMessage) PathHints() map[string]string {
+	hints := make(map[string]string)
+	for _, option := range o.Options {
+		if !strings.HasPrefix(option.Key, optionPathHint) {
+			continue
+		}
+		hint := path.Clean(strings.Replace(option.Value, "\\", "/", -1))
+		if hint == "." || hint == ".." || strings.HasPrefix(hint, "../") || strings.HasPrefix(hint, "/") || strings.Contains(hint, ":") {
+			continue
+		}
+		hints[option.Key[len(optionPathHint):]] = hint
+	}
+	return hints
+}
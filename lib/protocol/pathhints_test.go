@@ -0,0 +1,41 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPathHints(t *testing.T) {
+	var cm ClusterConfigMessage
+	for folder, hint := range map[string]string{
+		"photos":  "Shared/Photos/",
+		"docs":    `Work\Documents`,
+		"root":    "/etc",
+		"escape":  "a/../../b",
+		"drive":   "C:/Users",
+		"current": ".",
+	} {
+		opt, ok := PathHintOption(folder, hint)
+		if !ok {
+			t.Fatalf("%q not announced", folder)
+		}
+		cm.Options = append(cm.Options, opt)
+	}
+
+	expected := map[string]string{
+		"photos": "Shared/Photos",
+		"docs":   "Work/Documents",
+	}
+	if hints := cm.PathHints(); !reflect.DeepEqual(hints, expected) {
+		t.Errorf("unexpected hints %v", hints)
+	}
+
+	if _, ok := PathHintOption(strings.Repeat("x", 60), "foo"); ok {
+		t.Error("too long folder ID announced")
+	}
+}
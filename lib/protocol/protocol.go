@@ -5,6 +5,7 @@
 package protocol
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -14,10 +15,16 @@ import (
 	"time"
 
 	lz4 "github.com/bkaradzic/go-lz4"
+	"github.com/calmh/xdr"
 )
 
 const (
 	BlockSize = 128 * 1024
+
+	// Message buffers larger than this are released after use rather than
+	// kept for the next message, so that a single large index message
+	// doesn't keep memory allocated for the life of the connection.
+	maxKeptBufferSize = 1 << 20
 )
 
 const (
@@ -29,6 +36,8 @@ const (
 	messageTypePong          = 5
 	messageTypeIndexUpdate   = 6
 	messageTypeClose         = 7
+	messageTypeAttributes    = 8
+	messageTypeUnshare       = 9
 )
 
 const (
@@ -42,6 +51,7 @@ const (
 	FlagInvalid           = 1 << 13
 	FlagDirectory         = 1 << 14
 	FlagNoPermBits        = 1 << 15
+	FlagSymlink           = 1 << 16
 )
 
 const (
@@ -51,9 +61,15 @@ const (
 	FlagShareBits            = 0x000000ff
 )
 
+const (
+	FlagUnshareDelete uint32 = 1 << 0
+)
+
 var (
 	ErrClusterHash = fmt.Errorf("configuration error: mismatched cluster hash")
 	ErrClosed      = errors.New("connection closed")
+
+	ErrUnshareUnsupported = errors.New("peer does not support unshare requests")
 )
 
 type Model interface {
@@ -67,6 +83,8 @@ type Model interface {
 	ClusterConfig(deviceID DeviceID, config ClusterConfigMessage)
 	// The peer device closed the connection
 	Close(deviceID DeviceID, err error)
+	// The peer device asked us to stop syncing the folder
+	Unshare(deviceID DeviceID, folder string, delete bool)
 }
 
 type Connection interface {
@@ -76,6 +94,7 @@ type Connection interface {
 	IndexUpdate(folder string, files []FileInfo) error
 	Request(folder string, name string, offset int64, size int) ([]byte, error)
 	ClusterConfig(config ClusterConfigMessage)
+	Unshare(folder string, delete bool) error
 	Statistics() Statistics
 }
 
@@ -93,12 +112,18 @@ type rawConnection struct {
 
 	idxMut sync.Mutex // ensures serialization of Index calls
 
+	ccRcvd              chan struct{} // closed when the peer's cluster config has been received
+	peerAttributes      bool          // the peer announced CapabilityAttributes; set before ccRcvd closes
+	peerCompressedIndex bool          // the peer announced CapabilityCompressedIndex; set before ccRcvd closes
+	peerUnshare         bool          // the peer announced CapabilityUnshare; set before ccRcvd closes
+	pendingAttrs        *AttributesMessage
+
 	nextID chan int
 	outbox chan hdrMsg
 	closed chan struct{}
 	once   sync.Once
 
-	compressionThreshold int // compress messages larger than this many bytes
+	compress bool // compress messages other than indexes
 
 	rdbuf0 []byte // used & reused by readMessage
 	rdbuf1 []byte // used & reused by readMessage
@@ -123,25 +148,25 @@ const (
 	pingIdleTime = 60 * time.Second
 )
 
+// No point in compressing messages shorter than this many bytes
+const compressionThreshold = 128
+
 func NewConnection(deviceID DeviceID, reader io.Reader, writer io.Writer, receiver Model, name string, compress bool) Connection {
 	cr := &countingReader{Reader: reader}
 	cw := &countingWriter{Writer: writer}
 
-	compThres := 1<<31 - 1 // compression disabled
-	if compress {
-		compThres = 128 // compress messages that are 128 bytes long or larger
-	}
 	c := rawConnection{
-		id:                   deviceID,
-		name:                 name,
-		receiver:             nativeModel{receiver},
-		state:                stateInitial,
-		cr:                   cr,
-		cw:                   cw,
-		outbox:               make(chan hdrMsg),
-		nextID:               make(chan int),
-		closed:               make(chan struct{}),
-		compressionThreshold: compThres,
+		id:       deviceID,
+		name:     name,
+		receiver: nativeModel{receiver},
+		state:    stateInitial,
+		cr:       cr,
+		cw:       cw,
+		outbox:   make(chan hdrMsg),
+		nextID:   make(chan int),
+		closed:   make(chan struct{}),
+		ccRcvd:   make(chan struct{}),
+		compress: compress,
 	}
 
 	go c.readerLoop()
@@ -162,31 +187,42 @@ func (c *rawConnection) Name() string {
 
 // Index writes the list of file information to the connected peer device
 func (c *rawConnection) Index(folder string, idx []FileInfo) error {
+	return c.sendIndex(messageTypeIndex, folder, idx)
+}
+
+// IndexUpdate writes the list of file information to the connected peer device as an update
+func (c *rawConnection) IndexUpdate(folder string, idx []FileInfo) error {
+	return c.sendIndex(messageTypeIndexUpdate, folder, idx)
+}
+
+func (c *rawConnection) sendIndex(msgType int, folder string, idx []FileInfo) error {
 	select {
 	case <-c.closed:
 		return ErrClosed
 	default:
 	}
-	c.idxMut.Lock()
-	c.send(-1, messageTypeIndex, IndexMessage{folder, idx})
-	c.idxMut.Unlock()
-	return nil
-}
 
-// IndexUpdate writes the list of file information to the connected peer device as an update
-func (c *rawConnection) IndexUpdate(folder string, idx []FileInfo) error {
+	// We need to know whether the peer understands attributes and
+	// compressed indexes before sending anything.
 	select {
+	case <-c.ccRcvd:
 	case <-c.closed:
 		return ErrClosed
-	default:
 	}
+
+	attrs := indexAttributes(idx)
+
 	c.idxMut.Lock()
-	c.send(-1, messageTypeIndexUpdate, IndexMessage{folder, idx})
+	if len(attrs) > 0 && c.peerAttributes {
+		c.send(-1, messageTypeAttributes, AttributesMessage{folder, attrs})
+	}
+	c.send(-1, msgType, IndexMessage{folder, idx})
 	c.idxMut.Unlock()
 	return nil
 }
 
 // Request returns the bytes for the specified block after fetching them from the connected peer.
+// The returned buffer comes from BufferPool and may be put back once used.
 func (c *rawConnection) Request(folder string, name string, offset int64, size int) ([]byte, error) {
 	var id int
 	select {
@@ -217,9 +253,35 @@ func (c *rawConnection) Request(folder string, name string, offset int64, size i
 
 // ClusterConfig send the cluster configuration message to the peer and returns any error
 func (c *rawConnection) ClusterConfig(config ClusterConfigMessage) {
+	options := make([]Option, len(config.Options), len(config.Options)+1)
+	copy(options, config.Options)
+	config.Options = append(options, Option{optionCapabilities, CapabilityAttributes + "," + CapabilityCompressedIndex + "," + CapabilityUnshare})
 	c.send(-1, messageTypeClusterConfig, config)
 }
 
+// Unshare asks the peer to stop syncing the folder, and to delete its copy
+// of it if delete is set. Peers that don't announce CapabilityUnshare are
+// not asked, and ErrUnshareUnsupported is returned.
+func (c *rawConnection) Unshare(folder string, delete bool) error {
+	select {
+	case <-c.ccRcvd:
+	case <-c.closed:
+		return ErrClosed
+	}
+	if !c.peerUnshare {
+		return ErrUnshareUnsupported
+	}
+
+	var flags uint32
+	if delete {
+		flags |= FlagUnshareDelete
+	}
+	if !c.send(-1, messageTypeUnshare, UnshareMessage{folder, flags}) {
+		return ErrClosed
+	}
+	return nil
+}
+
 func (c *rawConnection) ping() bool {
 	var id int
 	select {
@@ -267,6 +329,13 @@ func (c *rawConnection) readerLoop() (err error) {
 			c.handleIndex(msg.(IndexMessage))
 			c.state = stateIdxRcvd
 
+		case messageTypeAttributes:
+			if c.state < stateCCRcvd {
+				return fmt.Errorf("protocol error: attributes message in state %d", c.state)
+			}
+			am := msg.(AttributesMessage)
+			c.pendingAttrs = &am
+
 		case messageTypeIndexUpdate:
 			if c.state < stateIdxRcvd {
 				return fmt.Errorf("protocol error: index update message in state %d", c.state)
@@ -296,9 +365,21 @@ func (c *rawConnection) readerLoop() (err error) {
 			if c.state != stateInitial {
 				return fmt.Errorf("protocol error: cluster config message in state %d", c.state)
 			}
-			go c.receiver.ClusterConfig(c.id, msg.(ClusterConfigMessage))
+			cc := msg.(ClusterConfigMessage)
+			c.peerAttributes = hasCapability(cc, CapabilityAttributes)
+			c.peerCompressedIndex = hasCapability(cc, CapabilityCompressedIndex)
+			c.peerUnshare = hasCapability(cc, CapabilityUnshare)
+			close(c.ccRcvd)
+			go c.receiver.ClusterConfig(c.id, cc)
 			c.state = stateCCRcvd
 
+		case messageTypeUnshare:
+			if c.state < stateCCRcvd {
+				return fmt.Errorf("protocol error: unshare message in state %d", c.state)
+			}
+			um := msg.(UnshareMessage)
+			go c.receiver.Unshare(c.id, um.Folder, um.Flags&FlagUnshareDelete != 0)
+
 		case messageTypeClose:
 			return errors.New(msg.(CloseMessage).Reason)
 
@@ -322,7 +403,7 @@ func (c *rawConnection) readMessage() (hdr header, msg encodable, err error) {
 	hdr = decodeHeader(binary.BigEndian.Uint32(c.rdbuf0[0:4]))
 	msglen := int(binary.BigEndian.Uint32(c.rdbuf0[4:8]))
 
-	if debug {
+	if debug() {
 		l.Debugf("read header %v (msglen=%d)", hdr, msglen)
 	}
 
@@ -336,7 +417,7 @@ func (c *rawConnection) readMessage() (hdr header, msg encodable, err error) {
 		return
 	}
 
-	if debug {
+	if debug() {
 		l.Debugf("read %d bytes", len(c.rdbuf0))
 	}
 
@@ -348,12 +429,12 @@ func (c *rawConnection) readMessage() (hdr header, msg encodable, err error) {
 			return
 		}
 		msgBuf = c.rdbuf1
-		if debug {
+		if debug() {
 			l.Debugf("decompressed to %d bytes", len(msgBuf))
 		}
 	}
 
-	if debug {
+	if debug() {
 		if len(msgBuf) > 1024 {
 			l.Debugf("message data:\n%s", hex.Dump(msgBuf[:1024]))
 		} else {
@@ -374,7 +455,7 @@ func (c *rawConnection) readMessage() (hdr header, msg encodable, err error) {
 
 	case messageTypeResponse:
 		var resp ResponseMessage
-		err = resp.UnmarshalXDR(msgBuf)
+		resp, err = unmarshalResponse(msgBuf)
 		msg = resp
 
 	case messageTypePing, messageTypePong:
@@ -390,24 +471,58 @@ func (c *rawConnection) readMessage() (hdr header, msg encodable, err error) {
 		err = cm.UnmarshalXDR(msgBuf)
 		msg = cm
 
+	case messageTypeAttributes:
+		var am AttributesMessage
+		err = am.UnmarshalXDR(msgBuf)
+		msg = am
+
+	case messageTypeUnshare:
+		var um UnshareMessage
+		err = um.UnmarshalXDR(msgBuf)
+		msg = um
+
 	default:
 		err = fmt.Errorf("protocol error: %s: unknown message type %#x", c.id, hdr.msgType)
 	}
 
+	// The decoded message doesn't refer to the buffers
+	if cap(c.rdbuf0) > maxKeptBufferSize {
+		c.rdbuf0 = nil
+	}
+	if cap(c.rdbuf1) > maxKeptBufferSize {
+		c.rdbuf1 = nil
+	}
+
 	return
 }
 
+// unmarshalResponse decodes a ResponseMessage into a buffer from the pool,
+// as the message buffer is reused for the next message.
+func unmarshalResponse(bs []byte) (ResponseMessage, error) {
+	var resp ResponseMessage
+	if len(bs) < 4 || int(binary.BigEndian.Uint32(bs)) > len(bs)-4 {
+		// Let the regular decoder report the error
+		return resp, resp.UnmarshalXDR(bs)
+	}
+	size := int(binary.BigEndian.Uint32(bs))
+	xr := xdr.NewReader(bytes.NewReader(bs))
+	resp.Data = xr.ReadBytesInto(BufferPool.Get((size + 3) &^ 3))
+	return resp, xr.Error()
+}
+
 func (c *rawConnection) handleIndex(im IndexMessage) {
-	if debug {
+	if debug() {
 		l.Debugf("Index(%v, %v, %d files)", c.id, im.Folder, len(im.Files))
 	}
+	c.applyPendingAttributes(im)
 	c.receiver.Index(c.id, im.Folder, im.Files)
 }
 
 func (c *rawConnection) handleIndexUpdate(im IndexMessage) {
-	if debug {
+	if debug() {
 		l.Debugf("queueing IndexUpdate(%v, %v, %d files)", c.id, im.Folder, len(im.Files))
 	}
+	c.applyPendingAttributes(im)
 	c.receiver.IndexUpdate(c.id, im.Folder, im.Files)
 }
 
@@ -461,6 +576,20 @@ func (c *rawConnection) send(msgID int, msgType int, msg encodable) bool {
 	}
 }
 
+// shouldCompress returns whether messages of the given type are compressed.
+// Indexes are compressed whenever the peer supports it, as they are large
+// and compress well, while other messages follow the compression setting.
+// Indexes are only sent after the peer's cluster config has been received,
+// so peerCompressedIndex is already set when we get here.
+func (c *rawConnection) shouldCompress(msgType int) bool {
+	switch msgType {
+	case messageTypeIndex, messageTypeIndexUpdate:
+		return c.peerCompressedIndex
+	default:
+		return c.compress
+	}
+}
+
 func (c *rawConnection) writerLoop() {
 	var msgBuf = make([]byte, 8) // buffer for wire format message, kept and reused
 	var uncBuf []byte            // buffer for uncompressed message, kept and reused
@@ -473,8 +602,13 @@ func (c *rawConnection) writerLoop() {
 			if hm.msg != nil {
 				// Uncompressed message in uncBuf
 				uncBuf = hm.msg.AppendXDR(uncBuf[:0])
+				if resp, ok := hm.msg.(ResponseMessage); ok {
+					// The data came from the model's Request and is now
+					// copied, so the buffer can be reused
+					BufferPool.Put(resp.Data)
+				}
 
-				if len(uncBuf) >= c.compressionThreshold {
+				if len(uncBuf) >= compressionThreshold && c.shouldCompress(hm.hdr.msgType) {
 					// Use compression for large messages
 					hm.hdr.compression = true
 
@@ -489,7 +623,7 @@ func (c *rawConnection) writerLoop() {
 					binary.BigEndian.PutUint32(msgBuf[4:8], uint32(len(tempBuf)))
 					msgBuf = msgBuf[0 : len(tempBuf)+8]
 
-					if debug {
+					if debug() {
 						l.Debugf("write compressed message; %v (len=%d)", hm.hdr, len(tempBuf))
 					}
 				} else {
@@ -505,12 +639,12 @@ func (c *rawConnection) writerLoop() {
 					msgBuf = msgBuf[0 : len(uncBuf)+8]
 					copy(msgBuf[8:], uncBuf)
 
-					if debug {
+					if debug() {
 						l.Debugf("write uncompressed message; %v (len=%d)", hm.hdr, len(uncBuf))
 					}
 				}
 			} else {
-				if debug {
+				if debug() {
 					l.Debugf("write empty message; %v", hm.hdr)
 				}
 				binary.BigEndian.PutUint32(msgBuf[4:8], 0)
@@ -522,7 +656,7 @@ func (c *rawConnection) writerLoop() {
 			if err == nil {
 				var n int
 				n, err = c.cw.Write(msgBuf)
-				if debug {
+				if debug() {
 					l.Debugf("wrote %d bytes on the wire", n)
 				}
 			}
@@ -530,6 +664,13 @@ func (c *rawConnection) writerLoop() {
 				c.close(err)
 				return
 			}
+
+			if cap(msgBuf) > maxKeptBufferSize {
+				msgBuf = make([]byte, 8)
+			}
+			if cap(uncBuf) > maxKeptBufferSize {
+				uncBuf = nil
+			}
 		case <-c.closed:
 			return
 		}
@@ -572,26 +713,26 @@ func (c *rawConnection) pingerLoop() {
 		select {
 		case <-ticker:
 			if d := time.Since(c.cr.Last()); d < pingIdleTime {
-				if debug {
+				if debug() {
 					l.Debugln(c.id, "ping skipped after rd", d)
 				}
 				continue
 			}
 			if d := time.Since(c.cw.Last()); d < pingIdleTime {
-				if debug {
+				if debug() {
 					l.Debugln(c.id, "ping skipped after wr", d)
 				}
 				continue
 			}
 			go func() {
-				if debug {
+				if debug() {
 					l.Debugln(c.id, "ping ->")
 				}
 				rc <- c.ping()
 			}()
 			select {
 			case ok := <-rc:
-				if debug {
+				if debug() {
 					l.Debugln(c.id, "<- pong")
 				}
 				if !ok {
@@ -638,3 +779,7 @@ func IsDirectory(bits uint32) bool {
 func HasPermissionBits(bits uint32) bool {
 	return bits&FlagNoPermBits == 0
 }
+
+func IsSymlink(bits uint32) bool {
+	return bits&FlagSymlink != 0
+}
@@ -16,6 +16,7 @@ import (
 	"reflect"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"github.com/calmh/xdr"
 )
@@ -257,7 +258,8 @@ func TestMarshalIndexMessage(t *testing.T) {
 	}
 
 	f := func(m1 IndexMessage) bool {
-		for _, f := range m1.Files {
+		for j, f := range m1.Files {
+			m1.Files[j].Attributes = nil
 			for i := range f.Blocks {
 				f.Blocks[i].Offset = 0
 				if len(f.Blocks[i].Hash) == 0 {
@@ -274,6 +276,21 @@ func TestMarshalIndexMessage(t *testing.T) {
 	}
 }
 
+func TestMarshalAttributesMessage(t *testing.T) {
+	var quickCfg = &quick.Config{MaxCountScale: 10}
+	if testing.Short() {
+		quickCfg = nil
+	}
+
+	f := func(m1 AttributesMessage) bool {
+		return testMarshal(t, "attributes", &m1, &AttributesMessage{})
+	}
+
+	if err := quick.Check(f, quickCfg); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestMarshalRequestMessage(t *testing.T) {
 	var quickCfg = &quick.Config{MaxCountScale: 10}
 	if testing.Short() {
@@ -337,6 +354,21 @@ func TestMarshalCloseMessage(t *testing.T) {
 	}
 }
 
+func TestMarshalUnshareMessage(t *testing.T) {
+	var quickCfg = &quick.Config{MaxCountScale: 10}
+	if testing.Short() {
+		quickCfg = nil
+	}
+
+	f := func(m1 UnshareMessage) bool {
+		return testMarshal(t, "unshare", &m1, &UnshareMessage{})
+	}
+
+	if err := quick.Check(f, quickCfg); err != nil {
+		t.Error(err)
+	}
+}
+
 type message interface {
 	EncodeXDR(io.Writer) (int, error)
 	DecodeXDR(io.Reader) error
@@ -381,3 +413,51 @@ func testMarshal(t *testing.T, prefix string, m1, m2 message) bool {
 	}
 	return ok
 }
+
+func TestCompressedIndex(t *testing.T) {
+	var files []FileInfo
+	for i := 0; i < 100; i++ {
+		files = append(files, FileInfo{Name: fmt.Sprintf("some/long/directory/name/file%d", i), Version: uint64(i)})
+	}
+
+	for _, capable := range []bool{true, false} {
+		m1 := indexModel{newTestModel(), make(chan []FileInfo, 1)}
+
+		ar, aw := io.Pipe()
+		br, bw := io.Pipe()
+
+		// Compression is disabled for data, which shouldn't matter for
+		// indexes
+		c0 := NewConnection(c0ID, ar, bw, newTestModel(), "name", false).(wireFormatConnection).next.(*rawConnection)
+		c1 := NewConnection(c1ID, br, aw, m1, "name", false).(wireFormatConnection).next.(*rawConnection)
+
+		if capable {
+			c1.ClusterConfig(ClusterConfigMessage{})
+		} else {
+			c1.send(-1, messageTypeClusterConfig, ClusterConfigMessage{})
+		}
+		c0.ClusterConfig(ClusterConfigMessage{})
+		c0.Index("default", files)
+
+		select {
+		case recv := <-m1.index:
+			if len(recv) != len(files) {
+				t.Fatalf("received %d files, expected %d", len(recv), len(files))
+			}
+			for i := range recv {
+				if recv[i].Name != files[i].Name || recv[i].Version != files[i].Version {
+					t.Errorf("mismatch after transfer: %v != %v", recv[i], files[i])
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for index")
+		}
+
+		if c0.shouldCompress(messageTypeIndex) != capable {
+			t.Errorf("index compression %v with capable=%v", c0.shouldCompress(messageTypeIndex), capable)
+		}
+		if c0.shouldCompress(messageTypeResponse) {
+			t.Error("responses should not be compressed")
+		}
+	}
+}
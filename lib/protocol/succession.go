@@ -0,0 +1,53 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+)
+
+// Cluster config options announcing that the sending device is moving to a
+// new certificate, and thus a new device ID.
+const (
+	OptionSuccessor          = "successor"
+	OptionSuccessorSignature = "successorSignature"
+)
+
+var ErrBadSuccession = errors.New("invalid successor signature")
+
+func successionHash(successor DeviceID) []byte {
+	hash := sha256.Sum256([]byte("syncthing successor " + successor.String()))
+	return hash[:]
+}
+
+// SignSuccessor returns a signature, made with the current device key,
+// proving that the given device ID is the successor of the current one.
+func SignSuccessor(key crypto.Signer, successor DeviceID) ([]byte, error) {
+	return key.Sign(rand.Reader, successionHash(successor), crypto.SHA256)
+}
+
+// VerifySuccessor verifies a signature made by SignSuccessor, given the
+// public key of the current device certificate.
+func VerifySuccessor(key crypto.PublicKey, successor DeviceID, sig []byte) error {
+	hash := successionHash(successor)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash, sig) != nil {
+			return ErrBadSuccession
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hash, sig) {
+			return ErrBadSuccession
+		}
+		return nil
+	}
+	return ErrBadSuccession
+}
@@ -0,0 +1,46 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestSuccessorSignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	successor, _ := DeviceIDFromString(formatted)
+	var other DeviceID
+
+	for _, key := range []crypto.Signer{rsaKey, ecKey} {
+		sig, err := SignSuccessor(key, successor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifySuccessor(key.Public(), successor, sig); err != nil {
+			t.Errorf("%T: unexpected error %v", key, err)
+		}
+		if err := VerifySuccessor(key.Public(), other, sig); err != ErrBadSuccession {
+			t.Errorf("%T: signature for wrong device verified", key)
+		}
+	}
+
+	sig, _ := SignSuccessor(rsaKey, successor)
+	if err := VerifySuccessor(ecKey.Public(), successor, sig); err != ErrBadSuccession {
+		t.Error("signature with wrong key verified")
+	}
+}
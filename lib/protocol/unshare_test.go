@@ -0,0 +1,63 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+type unshareModel struct {
+	*TestModel
+	unshare chan UnshareMessage
+}
+
+func (m unshareModel) Unshare(deviceID DeviceID, folder string, delete bool) {
+	var flags uint32
+	if delete {
+		flags = FlagUnshareDelete
+	}
+	m.unshare <- UnshareMessage{folder, flags}
+}
+
+func TestUnshare(t *testing.T) {
+	for _, capable := range []bool{true, false} {
+		m1 := unshareModel{newTestModel(), make(chan UnshareMessage, 1)}
+
+		ar, aw := io.Pipe()
+		br, bw := io.Pipe()
+
+		c0 := NewConnection(c0ID, ar, bw, newTestModel(), "name", true)
+		c1 := NewConnection(c1ID, br, aw, m1, "name", true).(wireFormatConnection).next.(*rawConnection)
+
+		if capable {
+			c1.ClusterConfig(ClusterConfigMessage{})
+		} else {
+			c1.send(-1, messageTypeClusterConfig, ClusterConfigMessage{})
+		}
+		c0.ClusterConfig(ClusterConfigMessage{})
+
+		err := c0.Unshare("default", true)
+		if !capable {
+			if err != ErrUnshareUnsupported {
+				t.Errorf("unexpected error %v for incapable peer", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case um := <-m1.unshare:
+			if um.Folder != "default" || um.Flags != FlagUnshareDelete {
+				t.Errorf("unexpected unshare request %+v", um)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for unshare request")
+		}
+	}
+}
@@ -23,25 +23,33 @@ func (c wireFormatConnection) Name() string {
 }
 
 func (c wireFormatConnection) Index(folder string, fs []FileInfo) error {
-	var myFs = make([]FileInfo, len(fs))
-	copy(myFs, fs)
-
-	for i := range fs {
-		myFs[i].Name = norm.NFC.String(filepath.ToSlash(myFs[i].Name))
-	}
-
-	return c.next.Index(folder, myFs)
+	return c.next.Index(folder, wireNames(fs))
 }
 
 func (c wireFormatConnection) IndexUpdate(folder string, fs []FileInfo) error {
-	var myFs = make([]FileInfo, len(fs))
-	copy(myFs, fs)
+	return c.next.IndexUpdate(folder, wireNames(fs))
+}
 
+// wireNames returns the files with names in wire format. The slice is only
+// copied if some name needs changing, which is rarely the case except on
+// Windows, to avoid a second copy of each index batch in memory.
+func wireNames(fs []FileInfo) []FileInfo {
+	var myFs []FileInfo
 	for i := range fs {
-		myFs[i].Name = norm.NFC.String(filepath.ToSlash(myFs[i].Name))
+		name := norm.NFC.String(filepath.ToSlash(fs[i].Name))
+		if name == fs[i].Name {
+			continue
+		}
+		if myFs == nil {
+			myFs = make([]FileInfo, len(fs))
+			copy(myFs, fs)
+		}
+		myFs[i].Name = name
 	}
-
-	return c.next.IndexUpdate(folder, myFs)
+	if myFs == nil {
+		return fs
+	}
+	return myFs
 }
 
 func (c wireFormatConnection) Request(folder, name string, offset int64, size int) ([]byte, error) {
@@ -53,6 +61,10 @@ func (c wireFormatConnection) ClusterConfig(config ClusterConfigMessage) {
 	c.next.ClusterConfig(config)
 }
 
+func (c wireFormatConnection) Unshare(folder string, delete bool) error {
+	return c.next.Unshare(folder, delete)
+}
+
 func (c wireFormatConnection) Statistics() Statistics {
 	return c.next.Statistics()
 }
@@ -0,0 +1,36 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWireNames(t *testing.T) {
+	fs := []FileInfo{
+		{Name: "a"},
+		{Name: "b/c"},
+	}
+	if res := wireNames(fs); &res[0] != &fs[0] {
+		t.Error("Index batch copied although no name changed")
+	}
+
+	fs = []FileInfo{
+		{Name: "a"},
+		{Name: filepath.Join("b", "c")},
+		{Name: "e\u0301"}, // decomposed é
+	}
+	res := wireNames(fs)
+	if res[1].Name != "b/c" {
+		t.Errorf("Incorrect wire name %q", res[1].Name)
+	}
+	if res[2].Name != "\u00e9" {
+		t.Errorf("Incorrect wire name %q", res[2].Name)
+	}
+	if fs[1].Name != filepath.Join("b", "c") {
+		t.Error("Original index batch modified")
+	}
+}
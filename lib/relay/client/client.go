@@ -0,0 +1,265 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package client implements the device side of the relay protocol. A
+// ProtocolClient keeps a device joined to a relay and passes on the session
+// invitations it is sent. GetInvitationFromRelay asks a relay for a session
+// with a device joined to it, and JoinSession joins the session an
+// invitation is for.
+//
+// Relays are given as URIs on the form relay://host:port. The optional
+// query parameter "id" is the device ID the relay must present, and "token"
+// the token to join it with.
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	syncthingprotocol "github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/relay/protocol"
+)
+
+var (
+	ErrNotJoined = errors.New("relay: not joined")
+	errTimeout   = errors.New("relay: timeout waiting for reply")
+	errStopped   = errors.New("relay: client stopped")
+)
+
+const (
+	// The relay pings joined devices every minute; the connection is taken
+	// for lost when it has been quiet for longer than this.
+	networkTimeout = 2 * time.Minute
+	messageTimeout = 10 * time.Second
+	maxRejoinDelay = 5 * time.Minute
+)
+
+// A ProtocolClient keeps the device joined to a relay, so that other devices
+// can ask the relay for a session with it.
+type ProtocolClient struct {
+	URI *url.URL
+	// Invitations receives the invitations to the sessions other devices
+	// ask the relay for. They are to be joined with JoinSession.
+	Invitations chan protocol.SessionInvitation
+
+	certs []tls.Certificate
+
+	mut     sync.Mutex       // protects the fields below
+	conn    *tls.Conn        // nil when not joined
+	replies chan interface{} // replies to the outstanding connect request, if any
+
+	wmut   sync.Mutex // serializes writes to conn
+	reqMut sync.Mutex // one connect request at a time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewProtocolClient returns a client for the relay, which presents the
+// certificates when joining and sends the invitations it is sent to the
+// channel.
+func NewProtocolClient(uri *url.URL, certs []tls.Certificate, invitations chan protocol.SessionInvitation) *ProtocolClient {
+	return &ProtocolClient{
+		URI:         uri,
+		Invitations: invitations,
+		certs:       certs,
+		stop:        make(chan struct{}),
+	}
+}
+
+// Serve keeps the client joined to the relay until it is stopped, joining
+// again with a growing delay when the connection is lost or can't be made.
+func (c *ProtocolClient) Serve() {
+	delay := time.Second
+	for {
+		joined, err := c.serve()
+		if c.stopped() {
+			return
+		}
+		if joined {
+			delay = time.Second
+		}
+		if debug() {
+			l.Debugf("%v: %v; joining again in %v", c, err, delay)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-c.stop:
+			return
+		}
+		if delay *= 2; delay > maxRejoinDelay {
+			delay = maxRejoinDelay
+		}
+	}
+}
+
+// Stop leaves the relay, making Serve return.
+func (c *ProtocolClient) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		c.mut.Lock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		c.mut.Unlock()
+	})
+}
+
+// StatusOK returns whether the client is joined to the relay.
+func (c *ProtocolClient) StatusOK() bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.conn != nil
+}
+
+// String returns the address of the relay, leaving out the join token.
+func (c *ProtocolClient) String() string {
+	return "relay://" + c.URI.Host
+}
+
+// Invitation asks the relay for a session with the device, which must be
+// joined to it, over the connection the client is joined with.
+func (c *ProtocolClient) Invitation(id syncthingprotocol.DeviceID, timeout time.Duration) (protocol.SessionInvitation, error) {
+	c.reqMut.Lock()
+	defer c.reqMut.Unlock()
+
+	replies := make(chan interface{}, 1)
+	c.mut.Lock()
+	conn := c.conn
+	c.replies = replies
+	c.mut.Unlock()
+	defer func() {
+		c.mut.Lock()
+		c.replies = nil
+		c.mut.Unlock()
+	}()
+
+	if conn == nil {
+		return protocol.SessionInvitation{}, ErrNotJoined
+	}
+	if err := c.write(conn, protocol.ConnectRequest{ID: id[:]}); err != nil {
+		return protocol.SessionInvitation{}, err
+	}
+
+	select {
+	case reply := <-replies:
+		return invitationFrom(reply, id, conn)
+	case <-time.After(timeout):
+		return protocol.SessionInvitation{}, errTimeout
+	case <-c.stop:
+		return protocol.SessionInvitation{}, errStopped
+	}
+}
+
+// serve joins the relay and serves the connection until it is lost. It
+// returns whether the relay was joined, and why it left.
+func (c *ProtocolClient) serve() (bool, error) {
+	conn, err := dialRelay(c.URI, c.certs, messageTimeout)
+	if err != nil {
+		return false, err
+	}
+	if err := join(conn, c.URI, messageTimeout); err != nil {
+		conn.Close()
+		return false, err
+	}
+
+	c.mut.Lock()
+	if c.stopped() {
+		c.mut.Unlock()
+		conn.Close()
+		return false, errStopped
+	}
+	c.conn = conn
+	c.mut.Unlock()
+	if debug() {
+		l.Debugln(c, "joined")
+	}
+
+	defer func() {
+		c.mut.Lock()
+		c.conn = nil
+		c.mut.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(networkTimeout))
+		message, err := protocol.ReadMessage(conn)
+		if err != nil {
+			return true, err
+		}
+
+		switch msg := message.(type) {
+		case protocol.Ping:
+			if err := c.write(conn, protocol.Pong{}); err != nil {
+				return true, err
+			}
+
+		case protocol.Pong:
+
+		case protocol.SessionInvitation:
+			if !msg.ServerSocket {
+				// The reply to our own connect request
+				c.reply(msg)
+				continue
+			}
+			setAddress(&msg, conn)
+			if debug() {
+				l.Debugf("%v: invitation from %v", c, deviceID(msg.From))
+			}
+			select {
+			case c.Invitations <- msg:
+			case <-c.stop:
+				return true, errStopped
+			}
+
+		case protocol.Response:
+			c.reply(msg)
+
+		default:
+			return true, fmt.Errorf("relay: unexpected %T", message)
+		}
+	}
+}
+
+// reply passes the message to the outstanding connect request, if any.
+func (c *ProtocolClient) reply(msg interface{}) {
+	c.mut.Lock()
+	replies := c.replies
+	c.mut.Unlock()
+	if replies == nil {
+		return
+	}
+	select {
+	case replies <- msg:
+	default:
+	}
+}
+
+func (c *ProtocolClient) write(conn *tls.Conn, msg interface{}) error {
+	c.wmut.Lock()
+	defer c.wmut.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(messageTimeout))
+	return protocol.WriteMessage(conn, msg)
+}
+
+func (c *ProtocolClient) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func deviceID(bs []byte) syncthingprotocol.DeviceID {
+	var id syncthingprotocol.DeviceID
+	copy(id[:], bs)
+	return id
+}
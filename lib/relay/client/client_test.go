@@ -0,0 +1,269 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	syncthingprotocol "github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/relay/protocol"
+)
+
+func newTestCert(t *testing.T) tls.Certificate {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "syncthing"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+}
+
+func certID(cert tls.Certificate) syncthingprotocol.DeviceID {
+	return syncthingprotocol.NewDeviceID(cert.Certificate[0])
+}
+
+// testRelay is a relay doing just enough of what the relay server does to
+// serve the tests: it lets devices join with the token "secret", invites
+// them to sessions at itself and passes the session traffic on.
+type testRelay struct {
+	listener net.Listener
+
+	mut      sync.Mutex
+	joined   map[syncthingprotocol.DeviceID]*tls.Conn
+	sessions map[string]chan net.Conn
+}
+
+func startTestRelay(t *testing.T, cert tls.Certificate) *testRelay {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &testRelay{
+		listener: listener,
+		joined:   make(map[syncthingprotocol.DeviceID]*tls.Conn),
+		sessions: make(map[string]chan net.Conn),
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{protocol.ProtocolName},
+		ClientAuth:   tls.RequestClientCert,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.handle(tls.Server(conn, tlsCfg))
+		}
+	}()
+	return r
+}
+
+func (r *testRelay) uri(query string) *url.URL {
+	return &url.URL{Scheme: "relay", Host: r.listener.Addr().String(), RawQuery: query}
+}
+
+func (r *testRelay) handle(conn *tls.Conn) {
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return
+	}
+	id, _ := syncthingprotocol.PeerDeviceID(conn.ConnectionState())
+	message, err := protocol.ReadMessage(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	switch msg := message.(type) {
+	case protocol.JoinRelayRequest:
+		if msg.Token != "secret" {
+			protocol.WriteMessage(conn, protocol.ResponseForbidden)
+			conn.Close()
+			return
+		}
+		r.mut.Lock()
+		r.joined[id] = conn
+		protocol.WriteMessage(conn, protocol.ResponseSuccess)
+		// Exercise the client's answering of pings
+		protocol.WriteMessage(conn, protocol.Ping{})
+		r.mut.Unlock()
+
+		for {
+			message, err := protocol.ReadMessage(conn)
+			if err != nil {
+				r.mut.Lock()
+				delete(r.joined, id)
+				r.mut.Unlock()
+				return
+			}
+			if msg, ok := message.(protocol.ConnectRequest); ok {
+				r.connect(id, conn, deviceID(msg.ID))
+			}
+		}
+
+	case protocol.JoinSessionRequest:
+		r.mut.Lock()
+		peer, ok := r.sessions[string(msg.Key)]
+		r.mut.Unlock()
+		if !ok {
+			protocol.WriteMessage(conn, protocol.ResponseNotFound)
+			conn.Close()
+			return
+		}
+		protocol.WriteMessage(conn, protocol.ResponseSuccess)
+		select {
+		case peer <- conn:
+		case other := <-peer:
+			go io.Copy(conn, other)
+			go io.Copy(other, conn)
+		}
+	}
+}
+
+func (r *testRelay) connect(from syncthingprotocol.DeviceID, conn *tls.Conn, to syncthingprotocol.DeviceID) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	toConn, ok := r.joined[to]
+	if !ok {
+		protocol.WriteMessage(conn, protocol.ResponseNotFound)
+		return
+	}
+	// Keys unique enough for the tests
+	serverKey, clientKey := append([]byte(nil), from[:]...), append([]byte(nil), to[:]...)
+	peer := make(chan net.Conn)
+	r.sessions[string(serverKey)] = peer
+	r.sessions[string(clientKey)] = peer
+	protocol.WriteMessage(toConn, protocol.SessionInvitation{From: from[:], Key: serverKey, ServerSocket: true})
+	protocol.WriteMessage(conn, protocol.SessionInvitation{From: to[:], Key: clientKey})
+}
+
+func TestRelaySession(t *testing.T) {
+	relayCert, serverCert, clientCert := newTestCert(t), newTestCert(t), newTestCert(t)
+	relay := startTestRelay(t, relayCert)
+	defer relay.listener.Close()
+	uri := relay.uri("token=secret&id=" + certID(relayCert).String())
+
+	invitations := make(chan protocol.SessionInvitation, 1)
+	c := NewProtocolClient(uri, []tls.Certificate{serverCert}, invitations)
+	go c.Serve()
+	defer c.Stop()
+	for i := 0; !c.StatusOK(); i++ {
+		if i == 100 {
+			t.Fatal("client did not join the relay")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// A device not joined to the relay asks for a session with the client
+	inv, err := GetInvitationFromRelay(uri, certID(serverCert), []tls.Certificate{clientCert}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.ServerSocket || len(inv.Address) == 0 || inv.Port == 0 {
+		t.Errorf("unexpected invitation %+v", inv)
+	}
+
+	var serverInv protocol.SessionInvitation
+	select {
+	case serverInv = <-invitations:
+	case <-time.After(time.Second):
+		t.Fatal("no invitation")
+	}
+	if !serverInv.ServerSocket || deviceID(serverInv.From) != certID(clientCert) || len(serverInv.Address) == 0 {
+		t.Errorf("unexpected invitation %+v", serverInv)
+	}
+
+	// Both join the session, and can talk
+	done := make(chan net.Conn)
+	go func() {
+		conn, err := JoinSession(serverInv, []tls.Certificate{serverCert}, time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- conn
+	}()
+	clientConn, err := JoinSession(inv, []tls.Certificate{clientCert}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	serverConn := <-done
+	if serverConn == nil {
+		t.FailNow()
+	}
+	defer serverConn.Close()
+
+	clientConn.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	serverConn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(serverConn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("read %q, %v", buf, err)
+	}
+
+	// Asking through the joined client works the same
+	other := NewProtocolClient(uri, []tls.Certificate{clientCert}, make(chan protocol.SessionInvitation, 1))
+	go other.Serve()
+	defer other.Stop()
+	for i := 0; !other.StatusOK(); i++ {
+		if i == 100 {
+			t.Fatal("client did not join the relay")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if inv, err := other.Invitation(certID(serverCert), time.Second); err != nil || deviceID(inv.From) != certID(serverCert) {
+		t.Errorf("unexpected invitation %+v, %v", inv, err)
+	}
+	if _, err := other.Invitation(certID(relayCert), time.Second); err == nil || err.(ResponseError).Code != protocol.CodeNotFound {
+		t.Errorf("unexpected error %v asking for a device not joined", err)
+	}
+}
+
+func TestRelayRefused(t *testing.T) {
+	relayCert, cert := newTestCert(t), newTestCert(t)
+	relay := startTestRelay(t, relayCert)
+	defer relay.listener.Close()
+	certs := []tls.Certificate{cert}
+
+	_, err := GetInvitationFromRelay(relay.uri("token=wrong"), certID(relayCert), certs, time.Second)
+	if err, ok := err.(ResponseError); !ok || err.Code != protocol.CodeForbidden {
+		t.Errorf("unexpected error %v joining with the wrong token", err)
+	}
+
+	_, err = GetInvitationFromRelay(relay.uri("token=secret&id="+certID(cert).String()), certID(relayCert), certs, time.Second)
+	if err == nil {
+		t.Error("joined a relay presenting the wrong device ID")
+	}
+
+	if _, err := Latency(relay.uri(""), time.Second); err != nil {
+		t.Error(err)
+	}
+	if _, err := Latency(&url.URL{Scheme: "tcp", Host: relay.listener.Addr().String()}, time.Second); err == nil {
+		t.Error("measured the latency of a relay with the wrong scheme")
+	}
+}
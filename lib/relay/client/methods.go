@@ -0,0 +1,226 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	syncthingprotocol "github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/relay/protocol"
+)
+
+// DefaultPort is the port of relays given without one.
+const DefaultPort = 22067
+
+// A ResponseError is a refusal from the relay.
+type ResponseError struct {
+	Code    int32
+	Message string
+}
+
+func (e ResponseError) Error() string {
+	return fmt.Sprintf("relay: %s (code %d)", e.Message, e.Code)
+}
+
+// GetInvitationFromRelay asks the relay for a session with the device, which
+// must be joined to it. The relay is joined for the time it takes; a device
+// already joined to it should ask through its ProtocolClient instead.
+func GetInvitationFromRelay(uri *url.URL, id syncthingprotocol.DeviceID, certs []tls.Certificate, timeout time.Duration) (protocol.SessionInvitation, error) {
+	conn, err := dialRelay(uri, certs, timeout)
+	if err != nil {
+		return protocol.SessionInvitation{}, err
+	}
+	defer conn.Close()
+	if err := join(conn, uri, timeout); err != nil {
+		return protocol.SessionInvitation{}, err
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := protocol.WriteMessage(conn, protocol.ConnectRequest{ID: id[:]}); err != nil {
+		return protocol.SessionInvitation{}, err
+	}
+	for {
+		message, err := protocol.ReadMessage(conn)
+		if err != nil {
+			return protocol.SessionInvitation{}, err
+		}
+		switch msg := message.(type) {
+		case protocol.Ping:
+			if err := protocol.WriteMessage(conn, protocol.Pong{}); err != nil {
+				return protocol.SessionInvitation{}, err
+			}
+		case protocol.SessionInvitation:
+			if msg.ServerSocket {
+				// For a session someone else asked for while we were
+				// joined; not ours to take.
+				continue
+			}
+			return invitationFrom(msg, id, conn)
+		default:
+			return invitationFrom(msg, id, conn)
+		}
+	}
+}
+
+// JoinSession joins the session the invitation is for, and returns the
+// connection to the other device once the relay has let us in. The devices
+// make their TLS connection to each other over it, the side the invitation
+// says is the server side acting as TLS server.
+func JoinSession(invitation protocol.SessionInvitation, certs []tls.Certificate, timeout time.Duration) (net.Conn, error) {
+	addr := net.JoinHostPort(net.IP(invitation.Address).String(), strconv.Itoa(int(invitation.Port)))
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsConfig(certs))
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := protocol.WriteMessage(conn, protocol.JoinSessionRequest{Key: invitation.Key}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	message, err := protocol.ReadMessage(conn)
+	if err == nil {
+		err = responseError(message)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// Latency returns how long it takes to connect to the relay.
+func Latency(uri *url.URL, timeout time.Duration) (time.Duration, error) {
+	addr, err := relayAddress(uri)
+	if err != nil {
+		return 0, err
+	}
+	t0 := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return 0, err
+	}
+	conn.Close()
+	return time.Since(t0), nil
+}
+
+// dialRelay makes a TLS connection to the relay, checking that it speaks the
+// relay protocol and presents the device ID in the URI, if any.
+func dialRelay(uri *url.URL, certs []tls.Certificate, timeout time.Duration) (*tls.Conn, error) {
+	addr, err := relayAddress(uri)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsConfig(certs))
+	if err != nil {
+		return nil, err
+	}
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != protocol.ProtocolName {
+		conn.Close()
+		return nil, fmt.Errorf("relay %s: protocol negotiation failed", uri.Host)
+	}
+	if idStr := uri.Query().Get("id"); idStr != "" {
+		id, err := syncthingprotocol.DeviceIDFromString(idStr)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("relay %s: %v", uri.Host, err)
+		}
+		if remoteID, err := syncthingprotocol.PeerDeviceID(state); err != nil || remoteID != id {
+			conn.Close()
+			return nil, fmt.Errorf("relay %s: unexpected device ID %v", uri.Host, remoteID)
+		}
+	}
+	return conn, nil
+}
+
+// join joins the relay on a connection made by dialRelay.
+func join(conn *tls.Conn, uri *url.URL, timeout time.Duration) error {
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if err := protocol.WriteMessage(conn, protocol.JoinRelayRequest{Token: uri.Query().Get("token")}); err != nil {
+		return err
+	}
+	message, err := protocol.ReadMessage(conn)
+	if err != nil {
+		return err
+	}
+	return responseError(message)
+}
+
+// invitationFrom returns the invitation the relay sent in reply to a connect
+// request for the device.
+func invitationFrom(message interface{}, id syncthingprotocol.DeviceID, conn net.Conn) (protocol.SessionInvitation, error) {
+	switch msg := message.(type) {
+	case protocol.SessionInvitation:
+		if !bytes.Equal(msg.From, id[:]) {
+			return protocol.SessionInvitation{}, fmt.Errorf("relay: invitation from %v, not %v", deviceID(msg.From), id)
+		}
+		setAddress(&msg, conn)
+		return msg, nil
+	case protocol.Response:
+		if err := responseError(msg); err != nil {
+			return protocol.SessionInvitation{}, err
+		}
+	}
+	return protocol.SessionInvitation{}, fmt.Errorf("relay: unexpected %T", message)
+}
+
+func responseError(message interface{}) error {
+	msg, ok := message.(protocol.Response)
+	if !ok {
+		return fmt.Errorf("relay: unexpected %T", message)
+	}
+	if msg.Code != protocol.CodeSuccess {
+		return ResponseError{msg.Code, msg.Message}
+	}
+	return nil
+}
+
+// setAddress points an invitation without an address, which is for a
+// session at the relay it came from, at that relay.
+func setAddress(invitation *protocol.SessionInvitation, conn net.Conn) {
+	if len(invitation.Address) > 0 && !net.IP(invitation.Address).IsUnspecified() {
+		return
+	}
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	ip := addr.IP.To4()
+	if ip == nil {
+		ip = addr.IP
+	}
+	invitation.Address = ip
+	invitation.Port = uint16(addr.Port)
+}
+
+func relayAddress(uri *url.URL) (string, error) {
+	if uri.Scheme != "relay" {
+		return "", fmt.Errorf("relay: unsupported scheme %q", uri.Scheme)
+	}
+	if uri.Port() == "" {
+		return net.JoinHostPort(uri.Hostname(), strconv.Itoa(DefaultPort)), nil
+	}
+	return uri.Host, nil
+}
+
+func tlsConfig(certs []tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates:       certs,
+		NextProtos:         []string{protocol.ProtocolName},
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS12,
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package protocol implements the relay protocol, used by devices that
+// cannot connect to each other directly to exchange traffic through a relay
+// server.
+//
+// A device joins the relay by making a TLS connection offering ProtocolName,
+// presenting its certificate, and sending a JoinRelayRequest. It stays
+// connected, answering the relay's Pings, and is sent a SessionInvitation
+// whenever another device asks to connect to it with a ConnectRequest. The
+// device asking to connect gets an invitation of its own in reply, or a
+// Response with a nonzero code if the device it asked for is not there.
+//
+// Both devices then make another TLS connection to the relay the invitation
+// names, or the one it came from if it names none, and send a
+// JoinSessionRequest with the key from their invitation. Once both have
+// joined, the relay passes the data of the session between the two
+// connections as is, and the devices talk to each other over it as they
+// would over a direct connection. The relay sees only encrypted traffic.
+//
+// All messages are preceded by a header holding a magic number, the message
+// type and the length of the XDR encoded message that follows.
+package protocol
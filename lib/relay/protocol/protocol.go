@@ -0,0 +1,122 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The codes of the Responses sent by the relay.
+const (
+	CodeSuccess       = 0
+	CodeNotFound      = 1
+	CodeAlreadyJoined = 2
+	CodeForbidden     = 3
+	CodeUnexpected    = 100
+)
+
+var (
+	ResponseSuccess       = Response{CodeSuccess, "success"}
+	ResponseNotFound      = Response{CodeNotFound, "not found"}
+	ResponseAlreadyJoined = Response{CodeAlreadyJoined, "already joined"}
+	ResponseForbidden     = Response{CodeForbidden, "forbidden"}
+	ResponseUnexpected    = Response{CodeUnexpected, "unexpected message"}
+)
+
+const maxMessageLength = 1024
+
+var ErrInvalidMagic = errors.New("relay protocol: invalid magic")
+
+type encoder interface {
+	MarshalXDR() []byte
+}
+
+// WriteMessage writes the message, which is one of the message types of
+// this package, with its header.
+func WriteMessage(w io.Writer, message interface{}) error {
+	var msgType int32
+	switch message.(type) {
+	case Ping:
+		msgType = messageTypePing
+	case Pong:
+		msgType = messageTypePong
+	case JoinRelayRequest:
+		msgType = messageTypeJoinRelayRequest
+	case JoinSessionRequest:
+		msgType = messageTypeJoinSessionRequest
+	case Response:
+		msgType = messageTypeResponse
+	case ConnectRequest:
+		msgType = messageTypeConnectRequest
+	case SessionInvitation:
+		msgType = messageTypeSessionInvitation
+	default:
+		return fmt.Errorf("relay protocol: unknown message type %T", message)
+	}
+
+	payload := message.(encoder).MarshalXDR()
+	hdr := header{
+		magic:         magic,
+		messageType:   msgType,
+		messageLength: int32(len(payload)),
+	}
+	_, err := w.Write(append(hdr.MarshalXDR(), payload...))
+	return err
+}
+
+// ReadMessage reads the next message and returns it as one of the message
+// types of this package.
+func ReadMessage(r io.Reader) (interface{}, error) {
+	var hdr header
+	if err := hdr.DecodeXDR(r); err != nil {
+		return nil, err
+	}
+	if hdr.magic != magic {
+		return nil, ErrInvalidMagic
+	}
+	if hdr.messageLength < 0 || hdr.messageLength > maxMessageLength {
+		return nil, fmt.Errorf("relay protocol: message length %d out of range", hdr.messageLength)
+	}
+
+	buf := make([]byte, hdr.messageLength)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	switch hdr.messageType {
+	case messageTypePing:
+		var msg Ping
+		err := msg.UnmarshalXDR(buf)
+		return msg, err
+	case messageTypePong:
+		var msg Pong
+		err := msg.UnmarshalXDR(buf)
+		return msg, err
+	case messageTypeJoinRelayRequest:
+		var msg JoinRelayRequest
+		err := msg.UnmarshalXDR(buf)
+		return msg, err
+	case messageTypeJoinSessionRequest:
+		var msg JoinSessionRequest
+		err := msg.UnmarshalXDR(buf)
+		return msg, err
+	case messageTypeResponse:
+		var msg Response
+		err := msg.UnmarshalXDR(buf)
+		return msg, err
+	case messageTypeConnectRequest:
+		var msg ConnectRequest
+		err := msg.UnmarshalXDR(buf)
+		return msg, err
+	case messageTypeSessionInvitation:
+		var msg SessionInvitation
+		err := msg.UnmarshalXDR(buf)
+		return msg, err
+	}
+
+	return nil, fmt.Errorf("relay protocol: unknown message type %d", hdr.messageType)
+}
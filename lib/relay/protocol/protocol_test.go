@@ -0,0 +1,52 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	messages := []interface{}{
+		Ping{},
+		Pong{},
+		JoinRelayRequest{Token: "secret"},
+		JoinSessionRequest{Key: []byte("0123456789abcdef0123456789abcdef")},
+		ResponseNotFound,
+		ConnectRequest{ID: bytes.Repeat([]byte{0x42}, 32)},
+		SessionInvitation{
+			From:         bytes.Repeat([]byte{0x42}, 32),
+			Key:          []byte("0123456789abcdef0123456789abcdef"),
+			Address:      []byte{192, 0, 2, 1},
+			Port:         22067,
+			ServerSocket: true,
+		},
+	}
+
+	var buf bytes.Buffer
+	for _, msg := range messages {
+		if err := WriteMessage(&buf, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, msg := range messages {
+		res, err := ReadMessage(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(res, msg) {
+			t.Errorf("Message %#v does not match written %#v", res, msg)
+		}
+	}
+}
+
+func TestInvalidMagic(t *testing.T) {
+	bs := header{magic: 0x12345678, messageType: messageTypePing}.MarshalXDR()
+	if _, err := ReadMessage(bytes.NewReader(bs)); err != ErrInvalidMagic {
+		t.Errorf("Unexpected error %v reading message with invalid magic", err)
+	}
+}
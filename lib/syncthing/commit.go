@@ -0,0 +1,84 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+// VerifyConfiguration returns an error if the new configuration has no
+// listen address, invalid TLS options or invalid relay servers. Implements
+// the config.Committer interface.
+func (a *App) VerifyConfiguration(from, to config.Configuration) error {
+	if len(to.Options.ListenAddress) == 0 {
+		return errors.New("no listen address")
+	}
+	if err := ApplyTLSOptions(&tls.Config{}, to.Options.TLSMinVersion, to.Options.TLSCipherSuites); err != nil {
+		return fmt.Errorf("TLS configuration: %v", err)
+	}
+	for _, s := range to.Options.RelayServers {
+		if _, err := parseRelayURI(s); err != nil {
+			return fmt.Errorf("relay server %q: %v", s, err)
+		}
+	}
+	return nil
+}
+
+// CommitConfiguration applies the new rate limits and restarts global
+// discovery if its settings changed. The listening sockets, local discovery,
+// port mapping, relays and TLS are set up when the App starts, so it returns
+// false if their settings changed. Implements the config.Committer interface.
+func (a *App) CommitConfiguration(from, to config.Configuration) bool {
+	fromOpts, toOpts := from.Options, to.Options
+
+	a.writeRateLimit.set(toOpts.MaxSendKbps, toOpts.LimitBurstKiB)
+	a.readRateLimit.set(toOpts.MaxRecvKbps, toOpts.LimitBurstKiB)
+
+	a.mut.Lock()
+	discoverer, extPort := a.discoverer, a.externalPort
+	a.mut.Unlock()
+	if discoverer != nil && (fromOpts.GlobalAnnEnabled != toOpts.GlobalAnnEnabled || fromOpts.GlobalAnnServer != toOpts.GlobalAnnServer) {
+		discoverer.StopGlobal()
+		if toOpts.GlobalAnnEnabled {
+			a.log.Infoln("Starting global discovery announcements")
+			discoverer.StartGlobal(toOpts.GlobalAnnServer, uint16(extPort))
+		} else {
+			a.log.Infoln("Stopped global discovery announcements")
+		}
+	}
+
+	return equalStrings(fromOpts.ListenAddress, toOpts.ListenAddress) &&
+		fromOpts.LocalAnnEnabled == toOpts.LocalAnnEnabled &&
+		fromOpts.LocalAnnPort == toOpts.LocalAnnPort &&
+		fromOpts.LocalAnnMCAddr == toOpts.LocalAnnMCAddr &&
+		fromOpts.UPnPEnabled == toOpts.UPnPEnabled &&
+		fromOpts.UPnPLease == toOpts.UPnPLease &&
+		fromOpts.UPnPRenewal == toOpts.UPnPRenewal &&
+		fromOpts.TLSMinVersion == toOpts.TLSMinVersion &&
+		equalStrings(fromOpts.TLSCipherSuites, toOpts.TLSCipherSuites) &&
+		fromOpts.ResumeOnWakeup == toOpts.ResumeOnWakeup &&
+		fromOpts.RelaysEnabled == toOpts.RelaysEnabled &&
+		equalStrings(fromOpts.RelayServers, toOpts.RelayServers)
+}
+
+func (a *App) String() string {
+	return "connections"
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,314 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/internal/model"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func listenTCP(addr string) (net.Listener, error) {
+	if debugNet() {
+		l.Debugln("listening on", addr)
+	}
+
+	tcaddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenTCP("tcp", tcaddr)
+}
+
+// handleConns verifies the devices we have established connections with and
+// hands the connections to the model.
+func (a *App) handleConns(conns chan *tls.Conn) {
+	m := a.model
+
+next:
+	for conn := range conns {
+		cfg := a.cfgw.Raw()
+		remoteID, err := protocol.PeerDeviceID(conn.ConnectionState())
+		if err != nil {
+			a.log.Infof("Protocol error from %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		remoteCert := conn.ConnectionState().PeerCertificates[0]
+
+		if remoteID == a.myID {
+			a.log.Infof("Connected to myself (%s) - should not happen", remoteID)
+			conn.Close()
+			continue
+		}
+
+		if cfg.IsBlocked(remoteID) {
+			// Blocked devices are rejected without further ado, to not fill
+			// the logs with their connection attempts.
+			if debugNet() {
+				l.Debugf("Rejecting connection from blocked device %s at %s", remoteID, conn.RemoteAddr())
+			}
+			conn.Close()
+			continue
+		}
+
+		if m.ConnectedTo(remoteID) {
+			a.log.Infof("Connected to already connected device (%s)", remoteID)
+			conn.Close()
+			continue
+		}
+
+		for _, deviceCfg := range cfg.Devices {
+			if deviceCfg.DeviceID == remoteID {
+				if deviceCfg.Paused {
+					if debugNet() {
+						l.Debugf("Rejecting connection from paused device %s at %s", remoteID, conn.RemoteAddr())
+					}
+					// Tell the device why, so it doesn't take us for
+					// unreachable and keep dialing.
+					conn.SetWriteDeadline(time.Now().Add(250 * time.Millisecond))
+					protocol.WriteClose(conn, protocol.ReasonPaused)
+					conn.Close()
+					continue next
+				}
+
+				if !inSyncWindow(deviceCfg, time.Now()) {
+					if debugNet() {
+						l.Debugf("Rejecting connection from %s at %s outside its sync window", remoteID, conn.RemoteAddr())
+					}
+					conn.Close()
+					continue next
+				}
+
+				// Verify the name on the certificate. By default we set it to
+				// "syncthing" when generating, but the user may have replaced
+				// the certificate and used another name.
+				certName := deviceCfg.CertName
+				if certName == "" {
+					certName = "syncthing"
+				}
+				err := verifyCertName(remoteCert, certName)
+				if err != nil {
+					// Incorrect certificate name is something the user most
+					// likely wants to know about, since it's an advanced
+					// config. Warn instead of Info.
+					a.log.Warnf("Bad certificate from %s (%v): %v", remoteID, conn.RemoteAddr(), err)
+					conn.Close()
+					continue next
+				}
+
+				// Verify the pinned certificate, if any. Since the device ID is
+				// derived from the same hash this only fails when the pinned
+				// value is stale or mistyped, but we err on the side of
+				// refusing the connection.
+				if fp, ok := certPinned(deviceCfg, remoteCert); !ok {
+					a.log.Warnf("Certificate from %s (%v) does not match the pinned fingerprint; got %s", remoteID, conn.RemoteAddr(), fp)
+					events.Default.Log(events.DeviceRejected, map[string]string{
+						"device":  remoteID.String(),
+						"address": conn.RemoteAddr().String(),
+						"reason":  "certificate fingerprint mismatch",
+					})
+					conn.Close()
+					continue next
+				}
+
+				// The connection is wrapped in limiters, which pass the
+				// data straight through while no rate limit is set.
+				wr := &limitedWriter{conn, a.writeRateLimit}
+				rd := &limitedReader{conn, a.readRateLimit}
+
+				name := fmt.Sprintf("%s-%s", conn.LocalAddr(), conn.RemoteAddr())
+				protoConn := protocol.NewConnection(remoteID, rd, wr, m, name, deviceCfg.Compression)
+
+				a.log.Infof("Established secure connection to %s at %s", remoteID, name)
+				if debugNet() {
+					l.Debugf("cipher suite %04X", conn.ConnectionState().CipherSuite)
+				}
+				events.Default.Log(events.DeviceConnected, map[string]string{
+					"id":   remoteID.String(),
+					"addr": conn.RemoteAddr().String(),
+				})
+
+				m.AddConnection(conn, protoConn)
+				continue next
+			}
+		}
+
+		events.Default.Log(events.DeviceRejected, map[string]string{
+			"device":  remoteID.String(),
+			"address": conn.RemoteAddr().String(),
+		})
+		a.log.Infof("Connection from %s with unknown device ID %s; ignoring", conn.RemoteAddr(), remoteID)
+		conn.Close()
+	}
+}
+
+func (a *App) acceptTLS(conns chan *tls.Conn, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if a.stopped() {
+				return
+			}
+			a.log.Warnln("Accepting connection:", err)
+			continue
+		}
+
+		if debugNet() {
+			l.Debugln("connect from", conn.RemoteAddr())
+		}
+
+		tcpConn := conn.(*net.TCPConn)
+		a.setTCPOptions(tcpConn)
+
+		tc := tls.Server(conn, a.tlsCfg)
+		err = tc.Handshake()
+		if err != nil {
+			a.log.Infoln("TLS handshake:", err)
+			tc.Close()
+			continue
+		}
+
+		conns <- tc
+	}
+}
+
+// dialTLS connects out to the configured devices that aren't connected,
+// through a relay if they can't be reached directly, backing off to the
+// reconnect interval, and tries again at once when the network changes or
+// when kicked through a.redial.
+func (a *App) dialTLS(conns chan *tls.Conn, netChanges <-chan struct{}) {
+	m := a.model
+
+	var delay time.Duration = 1 * time.Second
+	for !a.stopped() {
+		cfg := a.cfgw.Raw()
+	nextDevice:
+		for _, deviceCfg := range cfg.Devices {
+			if deviceCfg.DeviceID == a.myID || deviceCfg.Paused || !inSyncWindow(deviceCfg, time.Now()) {
+				continue
+			}
+
+			if m.ConnectedTo(deviceCfg.DeviceID) {
+				continue
+			}
+
+			if since, ok := m.PausedByRemote(deviceCfg.DeviceID); ok && time.Since(since) < model.PausedRetryInterval {
+				continue
+			}
+
+			var addrs []string
+			for _, addr := range deviceCfg.Addresses {
+				if addr == "dynamic" {
+					if discoverer := a.Discoverer(); discoverer != nil {
+						t := discoverer.Lookup(deviceCfg.DeviceID)
+						if len(t) == 0 {
+							continue
+						}
+						addrs = append(addrs, t...)
+					}
+				} else {
+					addrs = append(addrs, addr)
+				}
+			}
+
+			for _, addr := range addrs {
+				if strings.HasPrefix(addr, "relay://") {
+					// Tried below, when no direct connection can be made
+					continue
+				}
+				host, port, err := net.SplitHostPort(addr)
+				if err != nil && strings.HasPrefix(err.Error(), "missing port") {
+					// addr is on the form "1.2.3.4"
+					addr = net.JoinHostPort(addr, "22000")
+				} else if err == nil && port == "" {
+					// addr is on the form "1.2.3.4:"
+					addr = net.JoinHostPort(host, "22000")
+				}
+				if debugNet() {
+					l.Debugln("dial", deviceCfg.DeviceID, addr)
+				}
+
+				raddr, err := net.ResolveTCPAddr("tcp", addr)
+				if err != nil {
+					if debugNet() {
+						l.Debugln(err)
+					}
+					continue
+				}
+
+				conn, err := net.DialTCP("tcp", nil, raddr)
+				if err != nil {
+					if debugNet() {
+						l.Debugln(err)
+					}
+					continue
+				}
+
+				a.setTCPOptions(conn)
+
+				tc := tls.Client(conn, a.tlsCfg)
+				err = tc.Handshake()
+				if err != nil {
+					a.log.Infoln("TLS handshake:", err)
+					tc.Close()
+					continue
+				}
+
+				conns <- tc
+				continue nextDevice
+			}
+
+			if cfg.Options.RelaysEnabled {
+				if tc := a.dialRelayed(deviceCfg.DeviceID, addrs); tc != nil {
+					conns <- tc
+				}
+			}
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-netChanges:
+			a.log.Infoln("Network changed; reconnecting to devices")
+			if discoverer := a.Discoverer(); discoverer != nil {
+				discoverer.Rediscover()
+			}
+			kick(a.natRenew)
+			delay = 1 * time.Second
+			continue
+		case <-a.redial:
+			delay = 1 * time.Second
+			continue
+		case <-a.stop:
+			return
+		}
+		delay *= 2
+		if maxD := time.Duration(cfg.Options.ReconnectIntervalS) * time.Second; delay > maxD {
+			delay = maxD
+		}
+	}
+}
+
+func (a *App) setTCPOptions(conn *net.TCPConn) {
+	var err error
+	if err = conn.SetLinger(0); err != nil {
+		a.log.Infoln(err)
+	}
+	if err = conn.SetNoDelay(false); err != nil {
+		a.log.Infoln(err)
+	}
+	if err = conn.SetKeepAlivePeriod(60 * time.Second); err != nil {
+		a.log.Infoln(err)
+	}
+	if err = conn.SetKeepAlive(true); err != nil {
+		a.log.Infoln(err)
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import "io"
+
+type limitedReader struct {
+	r     io.Reader
+	limit *rateLimit
+}
+
+func (r *limitedReader) Read(buf []byte) (int, error) {
+	bucket := r.limit.get()
+	if bucket != nil && len(buf) > limitQuantum {
+		// Reading at most a quantum at a time lets the bucket pace the
+		// transfer rather than absorb one large read after the fact.
+		buf = buf[:limitQuantum]
+	}
+	n, err := r.r.Read(buf)
+	if bucket != nil {
+		bucket.Wait(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import (
+	"io"
+	"sync"
+
+	"github.com/juju/ratelimit"
+)
+
+// Limited transfers are paced in pieces of this size, so that they flow
+// evenly instead of alternating between saturating and idling the link.
+const limitQuantum = 8 << 10
+
+// newRateLimit returns a bucket for the given rate that allows bursts of up
+// to burstKiB, but never less than one quantum.
+func newRateLimit(kbps, burstKiB int) *ratelimit.Bucket {
+	burst := int64(burstKiB) << 10
+	if burst < limitQuantum {
+		burst = limitQuantum
+	}
+	return ratelimit.NewBucketWithRate(float64(1000*kbps), burst)
+}
+
+// A rateLimit is the rate limit of the connections in one direction. It
+// may be changed while the connections are using it.
+type rateLimit struct {
+	mut    sync.RWMutex
+	bucket *ratelimit.Bucket // nil for no limit
+}
+
+// set changes the rate; zero or less means no limit.
+func (r *rateLimit) set(kbps, burstKiB int) {
+	var bucket *ratelimit.Bucket
+	if kbps > 0 {
+		bucket = newRateLimit(kbps, burstKiB)
+	}
+	r.mut.Lock()
+	r.bucket = bucket
+	r.mut.Unlock()
+}
+
+func (r *rateLimit) get() *ratelimit.Bucket {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	return r.bucket
+}
+
+type limitedWriter struct {
+	w     io.Writer
+	limit *rateLimit
+}
+
+func (w *limitedWriter) Write(buf []byte) (int, error) {
+	bucket := w.limit.get()
+	if bucket == nil {
+		return w.w.Write(buf)
+	}
+
+	var written int
+	for len(buf) > 0 {
+		chunk := buf
+		if len(chunk) > limitQuantum {
+			chunk = chunk[:limitQuantum]
+		}
+		bucket.Wait(int64(len(chunk)))
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		buf = buf[n:]
+	}
+	return written, nil
+}
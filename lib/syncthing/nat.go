@@ -0,0 +1,131 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/discover"
+	"github.com/syncthing/syncthing/internal/nat"
+	_ "github.com/syncthing/syncthing/internal/pmp"  // NAT-PMP and PCP
+	_ "github.com/syncthing/syncthing/internal/upnp" // UPnP
+)
+
+const natDiscoverTimeout = 3 * time.Second
+
+// setupNAT must be called with a.mut held.
+func (a *App) setupNAT() {
+	cfg := a.cfgw.Raw()
+	if len(a.listeners) == 1 {
+		// Set up incoming port forwarding, if necessary and possible
+		port := listenPort(a.listeners[0])
+		devices := nat.Discover(natDiscoverTimeout)
+		if len(devices) > 0 {
+			var dev nat.Device
+			a.externalPort, dev = a.mapPort(devices, port, 0)
+			if a.externalPort == 0 {
+				a.log.Warnln("Failed to create port mapping")
+			} else {
+				a.log.Infof("Created port mapping on %v - external port %d", dev, a.externalPort)
+			}
+		} else {
+			a.log.Infof("No UPnP, NAT-PMP or PCP gateway detected")
+		}
+		if cfg.Options.UPnPRenewal > 0 {
+			go a.renewNAT(port)
+		}
+	} else {
+		a.log.Warnln("Multiple listening addresses; not attempting port mapping")
+	}
+}
+
+// mapPort maps the port on the first of the gateways that lets us, and
+// returns the external port and the gateway, or zero and nil if none did.
+// The external port given, if any, is asked for first on each gateway.
+func (a *App) mapPort(devices []nat.Device, port, externalPort int) (int, nat.Device) {
+	lease := time.Duration(a.cfgw.Raw().Options.UPnPLease) * time.Minute
+	for _, dev := range devices {
+		if externalPort != 0 {
+			if r, err := dev.AddPortMapping(nat.TCP, port, externalPort, "syncthing", lease); err == nil {
+				return r, dev
+			}
+		}
+
+		// We seed the random number generator with the device ID to get a
+		// repeatable sequence of random external ports.
+		rnd := rand.NewSource(certSeed(a.cert.Certificate[0]))
+		for i := 0; i < 10; i++ {
+			r := 1024 + int(rnd.Int63()%(65535-1024))
+			mapped, err := dev.AddPortMapping(nat.TCP, port, r, "syncthing", lease)
+			if err == nil {
+				return mapped, dev
+			}
+			if debugNet() {
+				l.Debugf("port mapping on %v: %v", dev, err)
+			}
+		}
+	}
+	return 0, nil
+}
+
+func (a *App) renewNAT(port int) {
+	for {
+		select {
+		case <-time.After(time.Duration(a.cfgw.Raw().Options.UPnPRenewal) * time.Minute):
+		case <-a.natRenew:
+		case <-a.stop:
+			return
+		}
+
+		devices := nat.Discover(natDiscoverTimeout)
+		if len(devices) == 0 {
+			continue
+		}
+
+		a.mut.Lock()
+		externalPort := a.externalPort
+		a.mut.Unlock()
+
+		// Just renew the same port that we already have, if the gateway
+		// lets us
+		r, dev := a.mapPort(devices, port, externalPort)
+		if r == 0 {
+			a.log.Warnln("Failed to update port mapping - external port", externalPort)
+			continue
+		}
+		if r == externalPort {
+			a.log.Infof("Renewed port mapping on %v - external port %d", dev, r)
+			continue
+		}
+
+		// Something strange has happened. We didn't have an external port
+		// before? Or perhaps the gateway has changed?
+		a.mut.Lock()
+		a.externalPort = r
+		discoverer := a.discoverer
+		a.mut.Unlock()
+		a.log.Infof("Updated port mapping on %v - external port %d", dev, r)
+		discoverer.StopGlobal()
+		discoverer.StartGlobal(a.cfgw.Raw().Options.GlobalAnnServer, uint16(r))
+	}
+}
+
+func (a *App) discovery(extPort int) *discover.Discoverer {
+	cfg := a.cfgw.Raw()
+	disc := discover.NewDiscoverer(a.myID, a.listenAddresses(), a.log)
+
+	if cfg.Options.LocalAnnEnabled {
+		a.log.Infoln("Starting local discovery announcements")
+		disc.StartLocal(cfg.Options.LocalAnnPort, cfg.Options.LocalAnnMCAddr)
+	}
+
+	if cfg.Options.GlobalAnnEnabled {
+		a.log.Infoln("Starting global discovery announcements")
+		disc.StartGlobal(cfg.Options.GlobalAnnServer, uint16(extPort))
+	}
+
+	return disc
+}
@@ -0,0 +1,279 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/relay/client"
+	relayprotocol "github.com/syncthing/syncthing/lib/relay/protocol"
+)
+
+const (
+	relayTimeout       = 10 * time.Second
+	relayCheckInterval = 5 * time.Minute
+)
+
+// RelayStatus is the state of a configured relay.
+type RelayStatus struct {
+	URI       string
+	LatencyMs int  // -1 when it could not be reached
+	Joined    bool // whether we are joined to it, so that devices can reach us through it
+}
+
+// relayState is a configured relay, as last measured.
+type relayState struct {
+	uri     *url.URL
+	latency time.Duration
+	ok      bool
+}
+
+// RelayStatus returns the state of the configured relays, lowest latency
+// first. It is empty until they have been measured.
+func (a *App) RelayStatus() []RelayStatus {
+	a.mut.Lock()
+	relays, joined := a.relays, a.relayClient
+	a.mut.Unlock()
+
+	res := make([]RelayStatus, len(relays))
+	for i, r := range relays {
+		res[i] = RelayStatus{
+			URI:       r.uri.String(),
+			LatencyMs: -1,
+			Joined:    joined != nil && joined.URI == r.uri && joined.StatusOK(),
+		}
+		if r.ok {
+			res[i].LatencyMs = int(r.latency / time.Millisecond)
+		}
+	}
+	return res
+}
+
+// serveRelays keeps us joined to the reachable relay with the lowest
+// latency, so that devices that can't connect to us directly can ask it for
+// a session with us, and joins the sessions we are invited to. The relays
+// are measured again every relayCheckInterval, and another one is joined
+// if we have lost the one we were joined to.
+func (a *App) serveRelays(conns chan *tls.Conn) {
+	invitations := make(chan relayprotocol.SessionInvitation)
+	go func() {
+		for {
+			select {
+			case inv := <-invitations:
+				go a.acceptRelayed(conns, inv)
+			case <-a.stop:
+				return
+			}
+		}
+	}()
+
+	for _, s := range a.cfgw.Raw().Options.RelayServers {
+		if _, err := parseRelayURI(s); err != nil {
+			a.log.Warnf("Ignoring relay server %q: %v", s, err)
+		}
+	}
+
+	var current *client.ProtocolClient
+	for {
+		relays := a.measureRelays()
+		if len(relays) == 0 || !relays[0].ok {
+			if debugNet() {
+				l.Debugln("no relay reachable")
+			}
+		} else if current == nil || (!current.StatusOK() && current.URI != relays[0].uri) {
+			if current != nil {
+				current.Stop()
+			}
+			current = client.NewProtocolClient(relays[0].uri, a.tlsCfg.Certificates, invitations)
+			a.log.Infof("Joining %v (latency %v)", current, relays[0].latency)
+			go current.Serve()
+			a.mut.Lock()
+			a.relayClient = current
+			a.mut.Unlock()
+		}
+
+		select {
+		case <-time.After(relayCheckInterval):
+		case <-a.stop:
+			if current != nil {
+				current.Stop()
+			}
+			return
+		}
+	}
+}
+
+// measureRelays measures the latency of the configured relays, and returns
+// them lowest latency first, followed by those that could not be reached.
+func (a *App) measureRelays() []relayState {
+	a.mut.Lock()
+	old := a.relays
+	a.mut.Unlock()
+
+	var relays []relayState
+	for _, s := range a.cfgw.Raw().Options.RelayServers {
+		uri, err := parseRelayURI(s)
+		if err != nil {
+			continue
+		}
+		// Keep the URIs we have, as the one joined is compared by identity
+		for _, r := range old {
+			if r.uri.String() == uri.String() {
+				uri = r.uri
+			}
+		}
+
+		latency, err := client.Latency(uri, relayTimeout)
+		if err != nil && debugNet() {
+			l.Debugf("relay %s: %v", uri.Host, err)
+		}
+		relays = append(relays, relayState{uri: uri, latency: latency, ok: err == nil})
+	}
+	sort.Stable(relayStateList(relays))
+
+	a.mut.Lock()
+	a.relays = relays
+	a.mut.Unlock()
+	return relays
+}
+
+// acceptRelayed joins the session we are invited to, if it is from a device
+// we know, and hands the connection over as if it was accepted directly.
+func (a *App) acceptRelayed(conns chan *tls.Conn, inv relayprotocol.SessionInvitation) {
+	var from protocol.DeviceID
+	copy(from[:], inv.From)
+	cfg := a.cfgw.Raw()
+	if cfg.GetDeviceConfiguration(from) == nil || cfg.IsBlocked(from) {
+		if debugNet() {
+			l.Debugln("ignoring relay invitation from unknown device", from)
+		}
+		return
+	}
+
+	tc, err := a.joinRelayed(inv, from)
+	if err != nil {
+		a.log.Infof("Relayed connection from %s: %v", from, err)
+		return
+	}
+	conns <- tc
+}
+
+// dialRelayed asks the relays for a session with the device, first those
+// among its addresses and then the configured ones by latency, and returns
+// the TLS connection to it over the first one that works, or nil.
+func (a *App) dialRelayed(deviceID protocol.DeviceID, addrs []string) *tls.Conn {
+	var uris []*url.URL
+	for _, addr := range addrs {
+		if uri, err := parseRelayURI(addr); err == nil {
+			uris = append(uris, uri)
+		}
+	}
+	a.mut.Lock()
+	for _, r := range a.relays {
+		if r.ok {
+			uris = append(uris, r.uri)
+		}
+	}
+	a.mut.Unlock()
+
+	for _, uri := range uris {
+		if debugNet() {
+			l.Debugln("dial", deviceID, "through relay", uri.Host)
+		}
+		inv, err := a.relayInvitation(uri, deviceID)
+		if err != nil {
+			if debugNet() {
+				l.Debugf("relay %s: %v", uri.Host, err)
+			}
+			continue
+		}
+		tc, err := a.joinRelayed(inv, deviceID)
+		if err != nil {
+			a.log.Infof("Relayed connection to %s: %v", deviceID, err)
+			continue
+		}
+		return tc
+	}
+	return nil
+}
+
+// relayInvitation asks the relay for a session with the device; through the
+// connection we are joined with if it is the relay we are joined to, as the
+// relay would refuse us joining again.
+func (a *App) relayInvitation(uri *url.URL, deviceID protocol.DeviceID) (relayprotocol.SessionInvitation, error) {
+	a.mut.Lock()
+	joined := a.relayClient
+	a.mut.Unlock()
+
+	if joined != nil && joined.URI.Host == uri.Host && joined.StatusOK() {
+		return joined.Invitation(deviceID, relayTimeout)
+	}
+	return client.GetInvitationFromRelay(uri, deviceID, a.tlsCfg.Certificates, relayTimeout)
+}
+
+// joinRelayed joins the session and makes the TLS connection to the device
+// over it.
+func (a *App) joinRelayed(inv relayprotocol.SessionInvitation, deviceID protocol.DeviceID) (*tls.Conn, error) {
+	conn, err := client.JoinSession(inv, a.tlsCfg.Certificates, relayTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var tc *tls.Conn
+	if inv.ServerSocket {
+		tc = tls.Server(conn, a.tlsCfg)
+	} else {
+		tc = tls.Client(conn, a.tlsCfg)
+	}
+	tc.SetDeadline(time.Now().Add(relayTimeout))
+	if err := tc.Handshake(); err != nil {
+		tc.Close()
+		return nil, fmt.Errorf("TLS handshake: %v", err)
+	}
+	tc.SetDeadline(time.Time{})
+
+	// The relay is not trusted to put us in touch with the right device
+	if remoteID, err := protocol.PeerDeviceID(tc.ConnectionState()); err != nil || remoteID != deviceID {
+		tc.Close()
+		return nil, fmt.Errorf("connected to %v instead", remoteID)
+	}
+	return tc, nil
+}
+
+// parseRelayURI parses a relay address, which must be on the form
+// relay://host:port.
+func parseRelayURI(s string) (*url.URL, error) {
+	uri, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if uri.Scheme != "relay" || uri.Host == "" {
+		return nil, errors.New("not on the form relay://host:port")
+	}
+	return uri, nil
+}
+
+type relayStateList []relayState
+
+func (l relayStateList) Len() int {
+	return len(l)
+}
+
+func (l relayStateList) Swap(a, b int) {
+	l[a], l[b] = l[b], l[a]
+}
+
+func (l relayStateList) Less(a, b int) bool {
+	if l[a].ok != l[b].ok {
+		return l[a].ok
+	}
+	return l[a].latency < l[b].latency
+}
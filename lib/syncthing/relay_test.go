@@ -0,0 +1,46 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import (
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseRelayURI(t *testing.T) {
+	cases := []struct {
+		uri string
+		ok  bool
+	}{
+		{"relay://relay.example.com:22067", true},
+		{"relay://relay.example.com", true},
+		{"relay://192.0.2.1:22067?id=AIR6LPZ7K4PTTUXQSMUUCPQ5YWOEDFIIQJUG7772YQXXR5YD6AWQ&token=secret", true},
+		{"tcp://relay.example.com:22067", false},
+		{"relay.example.com:22067", false},
+		{"relay://", false},
+		{"dynamic", false},
+	}
+	for _, tc := range cases {
+		if _, err := parseRelayURI(tc.uri); (err == nil) != tc.ok {
+			t.Errorf("%q: unexpected error %v", tc.uri, err)
+		}
+	}
+}
+
+func TestRelayOrder(t *testing.T) {
+	relays := []relayState{
+		{uri: &url.URL{Host: "down"}},
+		{uri: &url.URL{Host: "slow"}, latency: 200 * time.Millisecond, ok: true},
+		{uri: &url.URL{Host: "fast"}, latency: 20 * time.Millisecond, ok: true},
+	}
+	sort.Stable(relayStateList(relays))
+	for i, host := range []string{"fast", "slow", "down"} {
+		if relays[i].uri.Host != host {
+			t.Errorf("relay %d is %q, expected %q", i, relays[i].uri.Host, host)
+		}
+	}
+}
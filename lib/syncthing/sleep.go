@@ -0,0 +1,63 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import "time"
+
+// A computer that has been asleep shows as the wall clock having run ahead
+// of the time slept here; the monotonic clock, which time.Sleep and the
+// timers go by, stands still while the computer is suspended.
+const (
+	sleepCheckInterval = 10 * time.Second
+	sleepThreshold     = 2 * time.Minute
+)
+
+// detectSleep resumes after each time the computer has been asleep, until
+// the App is stopped.
+func (a *App) detectSleep() {
+	last := time.Now().Round(0)
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-time.After(sleepCheckInterval):
+		}
+
+		// Round(0) strips the monotonic reading, so that the wall clocks
+		// are compared.
+		now := time.Now().Round(0)
+		if slept := now.Sub(last); slept > sleepThreshold {
+			a.resume(slept)
+		}
+		last = now
+	}
+}
+
+// resume picks up after the computer has been asleep. The connections are
+// most likely dead and the port mapping may have lapsed, and the network may
+// not be the one we were on, so the devices are disconnected and dialed
+// again, the port mapping is renewed and we announce ourselves anew.
+func (a *App) resume(slept time.Duration) {
+	a.log.Infof("Woke up from standby (asleep for about %v); reconnecting", slept/time.Minute*time.Minute)
+
+	for _, deviceCfg := range a.cfgw.Raw().Devices {
+		if a.model.ConnectedTo(deviceCfg.DeviceID) {
+			a.model.Disconnect(deviceCfg.DeviceID)
+		}
+	}
+	if discoverer := a.Discoverer(); discoverer != nil {
+		discoverer.Rediscover()
+	}
+	kick(a.natRenew)
+	kick(a.redial)
+}
+
+// kick sends on the channel unless a value is already waiting in it.
+func kick(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,488 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package syncthing runs a sync node: the index database, the folders and
+// the connections to other devices. It is what the syncthing binary is
+// built around, and lets other programs embed a node of their own:
+//
+//	app, err := syncthing.New(syncthing.Config{
+//		DataDir:       dir,
+//		Cert:          cert,
+//		Configuration: &cfg,
+//		ClientName:    "myapp",
+//		ClientVersion: "v1.0",
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	if err := app.Start(); err != nil {
+//		return err
+//	}
+//	defer app.Stop()
+//
+// Conflicts, files that fail to sync and stopped folders can be handled by
+// registering callbacks with app.Model().OnConflict, OnItemError and
+// OnFolderError before starting. The web GUI, upgrades and usage reporting
+// are left to the program.
+package syncthing
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/discover"
+	"github.com/syncthing/syncthing/internal/events"
+	"github.com/syncthing/syncthing/internal/files"
+	"github.com/syncthing/syncthing/internal/logger"
+	"github.com/syncthing/syncthing/internal/model"
+	"github.com/syncthing/syncthing/internal/netwatch"
+	"github.com/syncthing/syncthing/internal/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/relay/client"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/cache"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// Config is what an App needs to know to run.
+type Config struct {
+	// DataDir is where the index database is kept.
+	DataDir string
+	// ConfDir is the former name of DataDir, used when DataDir is empty.
+	ConfDir string
+	// Cert is the certificate that gives the device its ID.
+	Cert tls.Certificate
+	// Configuration is the device configuration. The App refers to it
+	// while running; changes should be made by replacing it through
+	// App.Config, so that they take effect as they would in syncthing
+	// itself.
+	Configuration *config.Configuration
+	// DeviceName is announced to other devices. It defaults to the name
+	// of this device in the configuration, or the host name.
+	DeviceName string
+	// ClientName and ClientVersion are announced to other devices.
+	ClientName    string
+	ClientVersion string
+	// Profile sets the concurrency, database cache and progress sampling
+	// settings. The zero value selects the default profile.
+	Profile config.Profile
+	// Logger receives the messages of this App, its model and, unless it
+	// has a logger of its own, its configuration. Nil means the default
+	// logger. Debug output always goes to the default logger.
+	Logger *logger.Logger
+}
+
+// An App is a running sync node.
+type App struct {
+	myID   protocol.DeviceID
+	cert   tls.Certificate
+	tlsCfg *tls.Config
+	db     *leveldb.DB
+	model  *model.Model
+	log    *logger.Logger
+
+	profile config.Profile
+
+	// The configuration may be replaced at any time, and is read through
+	// cfgw.Raw().
+	cfgw *config.Wrapper
+
+	writeRateLimit *rateLimit
+	readRateLimit  *rateLimit
+
+	mut          sync.Mutex // protects the fields below
+	discoverer   *discover.Discoverer
+	externalPort int
+	listeners    []net.Listener
+	started      bool
+	relays       []relayState           // the configured relays, as last measured
+	relayClient  *client.ProtocolClient // the relay we are joined to, if any
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	redial   chan struct{} // dial the devices that aren't connected at once
+	natRenew chan struct{} // renew the port mapping at once
+}
+
+// New opens the database and prepares the folders in the configuration.
+// Folders that cannot be used are marked as invalid in the configuration.
+func New(c Config) (*App, error) {
+	if c.Configuration == nil {
+		return nil, errors.New("no configuration")
+	}
+	if len(c.Cert.Certificate) == 0 {
+		return nil, errors.New("no certificate")
+	}
+	cfg := c.Configuration
+
+	a := &App{
+		myID: protocol.NewDeviceID(c.Cert.Certificate[0]),
+		cert: c.Cert,
+		log:  c.Logger.Or(),
+		stop: make(chan struct{}),
+
+		writeRateLimit: new(rateLimit),
+		readRateLimit:  new(rateLimit),
+
+		redial:   make(chan struct{}, 1),
+		natRenew: make(chan struct{}, 1),
+	}
+	if c.Logger != nil {
+		cfg.SetLogger(c.Logger)
+	}
+
+	// The TLS configuration is used for both the listening socket and outgoing
+	// connections.
+
+	a.tlsCfg = &tls.Config{
+		Certificates:           []tls.Certificate{c.Cert},
+		NextProtos:             []string{protocol.TLSProtocol},
+		ServerName:             a.myID.String(),
+		ClientAuth:             tls.RequestClientCert,
+		SessionTicketsDisabled: true,
+		InsecureSkipVerify:     true,
+		MinVersion:             tls.VersionTLS12,
+	}
+	if err := ApplyTLSOptions(a.tlsCfg, cfg.Options.TLSMinVersion, cfg.Options.TLSCipherSuites); err != nil {
+		return nil, fmt.Errorf("TLS configuration: %v", err)
+	}
+
+	// The rate limits are used on connections created in the connect and
+	// listen routines, and follow changes to the configuration.
+
+	a.writeRateLimit.set(cfg.Options.MaxSendKbps, cfg.Options.LimitBurstKiB)
+	a.readRateLimit.set(cfg.Options.MaxRecvKbps, cfg.Options.LimitBurstKiB)
+
+	profile := c.Profile
+	if profile == (config.Profile{}) {
+		profile = config.Profiles[config.ProfileDefault]
+	}
+
+	if c.DataDir == "" {
+		c.DataDir = c.ConfDir
+	}
+
+	db, err := leveldb.OpenFile(filepath.Join(c.DataDir, "index"), &opt.Options{
+		CachedOpenFiles: profile.DBOpenFiles,
+		BlockCache:      cache.NewLRUCache(profile.DBCacheMiB << 20),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open database: %v - is another copy of syncthing already running?", err)
+	}
+	a.db = db
+
+	// Remove database entries for folders that no longer exist in the config
+	folderMap := cfg.FolderMap()
+	for _, folder := range files.ListFolders(db) {
+		if _, ok := folderMap[folder]; !ok {
+			a.log.Infof("Cleaning data for dropped folder %q", folder)
+			files.DropFolder(db, folder)
+		}
+	}
+
+	name := c.DeviceName
+	if name == "" {
+		if myCfg := cfg.GetDeviceConfiguration(a.myID); myCfg != nil && myCfg.Name != "" {
+			name = myCfg.Name
+		} else {
+			name, _ = os.Hostname()
+		}
+	}
+
+	a.model = model.NewModel(c.DataDir, cfg, name, c.ClientName, c.ClientVersion, db, c.Logger)
+	a.model.SetProfile(profile)
+	a.profile = profile
+	a.model.SetDeviceID(a.myID)
+
+	a.cfgw = config.Wrap(cfg)
+	a.model.SetConfigWrapper(a.cfgw)
+	a.cfgw.Subscribe(a.model)
+	a.cfgw.Subscribe(a)
+
+nextFolder:
+	for i, folder := range cfg.Folders {
+		if folder.Invalid != "" {
+			continue
+		}
+		// The model and everything below it does file operations relative
+		// to the folder path, so this is enough to handle long paths.
+		path, err := osutil.ExpandTilde(folder.Path)
+		if err != nil {
+			a.log.Warnf("Stopping folder %q - %v", folder.ID, err)
+			cfg.Folders[i].Invalid = err.Error()
+			continue nextFolder
+		}
+		folder.Path = osutil.LongPath(path)
+		if folder.TempDir != "" {
+			tempDir, err := osutil.ExpandTilde(folder.TempDir)
+			if err != nil {
+				a.log.Warnf("Stopping folder %q - %v", folder.ID, err)
+				cfg.Folders[i].Invalid = err.Error()
+				continue nextFolder
+			}
+			folder.TempDir = osutil.LongPath(tempDir)
+		}
+		a.model.AddFolder(folder)
+
+		fi, err := os.Stat(folder.Path)
+		if a.model.CurrentLocalVersion(folder.ID) > 0 {
+			// Safety check. If the cached index contains files but the
+			// folder doesn't exist, we have a problem. We would assume
+			// that all files have been deleted which might not be the case,
+			// so mark it as invalid instead.
+			if err != nil || !fi.IsDir() {
+				a.log.Warnf("Stopping folder %q - path does not exist, but has files in index", folder.ID)
+				cfg.Folders[i].Invalid = "folder path missing"
+				continue nextFolder
+			}
+		} else if os.IsNotExist(err) {
+			// If we don't have any files in the index, and the directory
+			// doesn't exist, try creating it.
+			err = os.MkdirAll(folder.Path, 0700)
+		}
+
+		if err != nil {
+			// If there was another error or we could not create the
+			// path, the folder is invalid.
+			a.log.Warnf("Stopping folder %q - %v", folder.ID, err)
+			cfg.Folders[i].Invalid = err.Error()
+			continue nextFolder
+		}
+	}
+
+	return a, nil
+}
+
+// Start listens for and connects to other devices, starts discovery, port
+// mapping and relaying as configured, and starts synchronizing the folders.
+// The listening sockets are bound when it returns.
+func (a *App) Start() error {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	if a.started {
+		return errors.New("already started")
+	}
+	a.started = true
+
+	cfg := a.cfgw.Raw()
+
+	// Clear out old indexes for other devices. Otherwise we'll start up and
+	// start needing a bunch of files which are nowhere to be found. This
+	// needs to be changed when we correctly do persistent indexes.
+	for _, folderCfg := range cfg.Folders {
+		if folderCfg.Invalid != "" {
+			continue
+		}
+		for _, device := range folderCfg.DeviceIDs() {
+			if device == a.myID {
+				continue
+			}
+			a.model.Index(device, folderCfg.ID, nil)
+		}
+	}
+
+	if len(cfg.Options.ListenAddress) == 0 {
+		return errors.New("no listen address")
+	}
+
+	// A listen address with port zero gets a port picked by the system; the
+	// bound addresses are the ones announced and reported.
+	var conns = make(chan *tls.Conn)
+	for _, addr := range cfg.Options.ListenAddress {
+		listener, err := listenTCP(addr)
+		if err != nil {
+			for _, l := range a.listeners {
+				l.Close()
+			}
+			a.listeners = nil
+			return fmt.Errorf("listen (BEP): %v", err)
+		}
+		a.listeners = append(a.listeners, listener)
+		a.log.Infoln("Listening for connections on", listener.Addr())
+	}
+
+	// The default port we announce, possibly modified by setupNAT next.
+	a.externalPort = listenPort(a.listeners[0])
+
+	// Port mapping over UPnP, NAT-PMP or PCP
+
+	if cfg.Options.UPnPEnabled {
+		a.setupNAT()
+	}
+
+	// Routine to connect out to configured devices
+	a.discoverer = a.discovery(a.externalPort)
+	for _, listener := range a.listeners {
+		go a.acceptTLS(conns, listener)
+	}
+	go a.dialTLS(conns, netwatch.Watch())
+	go a.handleConns(conns)
+	if cfg.Options.RelaysEnabled && len(cfg.Options.RelayServers) > 0 {
+		go a.serveRelays(conns)
+	}
+	go a.sampleTransfers()
+	go a.expireDevices()
+	go a.closeSyncWindows()
+	if cfg.Options.ResumeOnWakeup {
+		go a.detectSleep()
+	}
+
+	for _, folder := range cfg.Folders {
+		if folder.Invalid != "" {
+			continue
+		}
+		if folder.Paused {
+			a.log.Okf("Folder %s is paused", folder.ID)
+			a.model.PauseFolder(folder.ID)
+			continue
+		}
+
+		// Routine to pull blocks from other devices to synchronize the local
+		// folder. Does not run when we are in read only (publish only) mode.
+		if folder.ReadOnly {
+			a.log.Okf("Ready to synchronize %s (read only; no external updates accepted)", folder.ID)
+			a.model.StartFolderRO(folder.ID)
+		} else {
+			a.log.Okf("Ready to synchronize %s (read-write)", folder.ID)
+			a.model.StartFolderRW(folder.ID)
+		}
+	}
+
+	for _, device := range cfg.Devices {
+		if len(device.Name) > 0 {
+			a.log.Infof("Device %s is %q at %v", device.DeviceID, device.Name, device.Addresses)
+		}
+		if _, err := syncWindow(device); err != nil {
+			a.log.Warnf("Device %s: bad sync window, connecting at any time: %v", device.DeviceID, err)
+		}
+	}
+
+	return nil
+}
+
+// Stop closes the listening sockets and the connections to other devices,
+// and stops global discovery announcements and the folders. The database
+// stays open, as folders may still be finishing up, so an App cannot be
+// started again.
+func (a *App) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+
+		a.mut.Lock()
+		for _, listener := range a.listeners {
+			listener.Close()
+		}
+		if a.discoverer != nil {
+			a.discoverer.StopGlobal()
+		}
+		a.mut.Unlock()
+
+		a.model.Stop()
+	})
+}
+
+// ID returns the device ID.
+func (a *App) ID() protocol.DeviceID {
+	return a.myID
+}
+
+// Model returns the model, which holds the state of folders and devices.
+func (a *App) Model() *model.Model {
+	return a.model
+}
+
+// Config returns the wrapper of the configuration, through which changes
+// to it are applied while running.
+func (a *App) Config() *config.Wrapper {
+	return a.cfgw
+}
+
+// Events returns the event logger on which changes in the state of the
+// node are announced.
+func (a *App) Events() *events.Logger {
+	return events.Default
+}
+
+// Discoverer returns the device discoverer, or nil before the App has been
+// started.
+func (a *App) Discoverer() *discover.Discoverer {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	return a.discoverer
+}
+
+// ListenAddresses returns the addresses of the listening sockets, with the
+// ports picked by the system filled in.
+func (a *App) ListenAddresses() []string {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	return a.listenAddresses()
+}
+
+// listenAddresses must be called with a.mut held.
+func (a *App) listenAddresses() []string {
+	var addrs []string
+	for _, listener := range a.listeners {
+		addrs = append(addrs, listener.Addr().String())
+	}
+	return addrs
+}
+
+// listenPort returns the port the listener is bound to.
+func listenPort(listener net.Listener) int {
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
+// sampleTransfers records the transfer rates in the transfer history until
+// the App is stopped.
+func (a *App) sampleTransfers() {
+	intv := time.Duration(a.profile.ProgressS) * time.Second
+	if intv <= 0 {
+		intv = time.Duration(config.Profiles[config.ProfileDefault].ProgressS) * time.Second
+	}
+	t := time.NewTicker(intv)
+	defer t.Stop()
+	a.model.SampleTransfers()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-t.C:
+			a.model.SampleTransfers()
+		}
+	}
+}
+
+func (a *App) expireDevices() {
+	t := time.NewTicker(model.DeviceExpiryInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-t.C:
+			a.model.ExpireDevices(a.myID, time.Now())
+		}
+	}
+}
+
+func (a *App) stopped() bool {
+	select {
+	case <-a.stop:
+		return true
+	default:
+		return false
+	}
+}
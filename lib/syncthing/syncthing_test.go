@@ -0,0 +1,190 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/logger"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func testCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "syncthing"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestStartStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert := testCert(t)
+	myID := protocol.NewDeviceID(cert.Certificate[0])
+
+	cfg := config.New(filepath.Join(dir, "config.xml"), myID)
+	cfg.Options.ListenAddress = []string{"127.0.0.1:0"}
+	cfg.Options.GlobalAnnEnabled = false
+	cfg.Options.LocalAnnEnabled = false
+	cfg.Options.UPnPEnabled = false
+	cfg.Folders = []config.FolderConfiguration{
+		{
+			ID:      "default",
+			Path:    filepath.Join(dir, "folder"),
+			Devices: []config.FolderDeviceConfiguration{{DeviceID: myID}},
+		},
+	}
+
+	var oks []string
+	var mut sync.Mutex
+	log := logger.NewWriter(ioutil.Discard)
+	log.AddHandler(logger.LevelOK, func(_ logger.LogLevel, msg string) {
+		mut.Lock()
+		oks = append(oks, msg)
+		mut.Unlock()
+	})
+
+	app, err := New(Config{
+		ConfDir:       dir,
+		Cert:          cert,
+		Configuration: &cfg,
+		ClientName:    "test",
+		ClientVersion: "v0.0.0",
+		Logger:        log,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.ID() != myID {
+		t.Errorf("ID %v != %v", app.ID(), myID)
+	}
+
+	// The folder directory is created
+	if _, err := os.Stat(filepath.Join(dir, "folder")); err != nil {
+		t.Error(err)
+	}
+	if cfg.Folders[0].Invalid != "" {
+		t.Errorf("folder invalid: %s", cfg.Folders[0].Invalid)
+	}
+
+	if err := app.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Start(); err == nil {
+		t.Error("unexpected nil error starting twice")
+	}
+
+	mut.Lock()
+	if len(oks) != 1 || !strings.HasPrefix(oks[0], "Ready to synchronize default") {
+		t.Errorf("unexpected messages in the given logger: %q", oks)
+	}
+	mut.Unlock()
+
+	// The port picked by the system is reported
+	addrs := app.ListenAddresses()
+	if len(addrs) != 1 || strings.HasSuffix(addrs[0], ":0") {
+		t.Fatalf("unexpected listen addresses %v", addrs)
+	}
+	conn, err := net.Dial("tcp", addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	app.Stop()
+	app.Stop()
+
+	if _, err := net.Dial("tcp", addrs[0]); err == nil {
+		t.Error("still listening after stop")
+	}
+}
+
+func TestNewWithoutConfiguration(t *testing.T) {
+	if _, err := New(Config{Cert: testCert(t)}); err == nil {
+		t.Error("unexpected nil error")
+	}
+}
+
+func TestCommitConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert := testCert(t)
+	cfg := config.New(filepath.Join(dir, "config.xml"), protocol.NewDeviceID(cert.Certificate[0]))
+	cfg.Options.ListenAddress = []string{"127.0.0.1:0"}
+	cfg.Options.GlobalAnnEnabled = false
+	cfg.Options.LocalAnnEnabled = false
+	cfg.Options.UPnPEnabled = false
+
+	app, err := New(Config{
+		DataDir:       dir,
+		Cert:          cert,
+		Configuration: &cfg,
+		Logger:        logger.NewWriter(ioutil.Discard),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Stop()
+
+	// Rate limits are changed live
+	to := app.Config().Raw()
+	to.Options.MaxSendKbps = 100
+	if resp := app.Config().Replace(to); resp.ValidationError != nil || resp.RequiresRestart {
+		t.Fatalf("Unexpected response %+v", resp)
+	}
+	if app.writeRateLimit.get() == nil || app.readRateLimit.get() != nil {
+		t.Error("Rate limits not applied")
+	}
+
+	// There must be a listen address
+	to = app.Config().Raw()
+	to.Options.ListenAddress = nil
+	if resp := app.Config().Replace(to); resp.ValidationError == nil {
+		t.Error("Missing listen address not refused")
+	}
+
+	// Listening elsewhere requires a restart
+	to.Options.ListenAddress = []string{"127.0.0.1:1"}
+	if resp := app.Config().Replace(to); !resp.RequiresRestart {
+		t.Error("Changing the listen address should require a restart")
+	}
+}
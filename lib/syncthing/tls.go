@@ -0,0 +1,104 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ApplyTLSOptions sets the minimum TLS version and the allowed cipher
+// suites, given by their standard names such as
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", on the TLS configuration. Empty
+// values leave the defaults in place.
+func ApplyTLSOptions(tlsCfg *tls.Config, minVersion string, cipherSuites []string) error {
+	if minVersion != "" {
+		v, ok := tlsVersions[minVersion]
+		if !ok {
+			return fmt.Errorf("unsupported TLS version %q", minVersion)
+		}
+		tlsCfg.MinVersion = v
+	}
+
+	if len(cipherSuites) == 0 {
+		return nil
+	}
+	known := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	tlsCfg.CipherSuites = nil
+	for _, name := range cipherSuites {
+		id, ok := known[name]
+		if !ok {
+			return fmt.Errorf("unsupported cipher suite %q", name)
+		}
+		tlsCfg.CipherSuites = append(tlsCfg.CipherSuites, id)
+	}
+	return nil
+}
+
+func certSeed(bs []byte) int64 {
+	hf := sha256.New()
+	hf.Write(bs)
+	id := hf.Sum(nil)
+	return int64(binary.BigEndian.Uint64(id))
+}
+
+// certFingerprint returns the hex encoded SHA-256 hash of the certificate.
+func certFingerprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(hash[:])
+}
+
+// fingerprintMatches compares a certificate fingerprint against one given
+// by the user, which may be in upper case and have colon separators.
+func fingerprintMatches(fingerprint, pinned string) bool {
+	pinned = strings.ToLower(strings.Replace(pinned, ":", "", -1))
+	return subtle.ConstantTimeCompare([]byte(fingerprint), []byte(pinned)) == 1
+}
+
+// certPinned returns the fingerprint of the certificate and whether it is
+// accepted by the certificate pin of the device. Devices without a pin
+// accept any certificate.
+func certPinned(cfg config.DeviceConfiguration, cert *x509.Certificate) (string, bool) {
+	fp := certFingerprint(cert)
+	if cfg.CertFingerprint == "" {
+		return fp, true
+	}
+	return fp, fingerprintMatches(fp, cfg.CertFingerprint)
+}
+
+// verifyCertName checks that the certificate was issued for the given name.
+// Names in the subject alternative name extension are verified as host
+// names; certificates without that extension must have exactly the given
+// name as common name.
+func verifyCertName(cert *x509.Certificate, name string) error {
+	if len(cert.DNSNames) > 0 || len(cert.IPAddresses) > 0 {
+		return cert.VerifyHostname(name)
+	}
+	if cert.Subject.CommonName != name {
+		return fmt.Errorf("certificate is valid for %q, not %q", cert.Subject.CommonName, name)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+func testX509Cert(t *testing.T, cn string, dnsNames []string, ips []net.IP) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestFingerprintMatches(t *testing.T) {
+	cert := testX509Cert(t, "syncthing", nil, nil)
+	other := testX509Cert(t, "syncthing", nil, nil)
+
+	fp := certFingerprint(cert)
+	if len(fp) != 64 {
+		t.Fatalf("fingerprint %q is not a hex encoded SHA-256 hash", fp)
+	}
+	if certFingerprint(cert) != fp {
+		t.Error("fingerprint is not stable")
+	}
+
+	var colons []string
+	for i := 0; i < len(fp); i += 2 {
+		colons = append(colons, fp[i:i+2])
+	}
+
+	cases := []struct {
+		pinned   string
+		expected bool
+	}{
+		{fp, true},
+		{strings.ToUpper(fp), true},
+		{strings.Join(colons, ":"), true},
+		{strings.ToUpper(strings.Join(colons, ":")), true},
+		{certFingerprint(other), false},
+		{fp[:62], false},
+		{fp + "00", false},
+		{"", false},
+	}
+
+	for i, tc := range cases {
+		if res := fingerprintMatches(fp, tc.pinned); res != tc.expected {
+			t.Errorf("%d: match %q against %q = %v, expected %v", i, fp, tc.pinned, res, tc.expected)
+		}
+	}
+}
+
+func TestCertPinned(t *testing.T) {
+	cert := testX509Cert(t, "syncthing", nil, nil)
+	other := testX509Cert(t, "syncthing", nil, nil)
+
+	cases := []struct {
+		pinned   string
+		expected bool
+	}{
+		{"", true},
+		{certFingerprint(cert), true},
+		{strings.ToUpper(certFingerprint(cert)), true},
+		{certFingerprint(other), false},
+		{"not a fingerprint", false},
+	}
+
+	for i, tc := range cases {
+		fp, ok := certPinned(config.DeviceConfiguration{CertFingerprint: tc.pinned}, cert)
+		if ok != tc.expected {
+			t.Errorf("%d: pinned %q accepted %v, expected %v", i, tc.pinned, ok, tc.expected)
+		}
+		if fp != certFingerprint(cert) {
+			t.Errorf("%d: returned fingerprint %q is not that of the certificate", i, fp)
+		}
+	}
+}
+
+func TestVerifyCertName(t *testing.T) {
+	cnOnly := testX509Cert(t, "syncthing", nil, nil)
+	dnsSAN := testX509Cert(t, "syncthing", []string{"device.example.com", "*.example.net"}, nil)
+	ipSAN := testX509Cert(t, "syncthing", nil, []net.IP{net.ParseIP("192.0.2.1")})
+
+	cases := []struct {
+		cert *x509.Certificate
+		name string
+		ok   bool
+	}{
+		// Without subject alternative names the common name must match
+		// exactly.
+		{cnOnly, "syncthing", true},
+		{cnOnly, "SYNCTHING", false},
+		{cnOnly, "other", false},
+		{cnOnly, "", false},
+
+		// With subject alternative names the common name is ignored.
+		{dnsSAN, "device.example.com", true},
+		{dnsSAN, "foo.example.net", true},
+		{dnsSAN, "example.net", false},
+		{dnsSAN, "syncthing", false},
+		{ipSAN, "192.0.2.1", true},
+		{ipSAN, "192.0.2.2", false},
+		{ipSAN, "syncthing", false},
+	}
+
+	for i, tc := range cases {
+		err := verifyCertName(tc.cert, tc.name)
+		if tc.ok && err != nil {
+			t.Errorf("%d: unexpected error verifying %q: %v", i, tc.name, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("%d: unexpected success verifying %q", i, tc.name)
+		}
+	}
+}
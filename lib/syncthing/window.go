@@ -0,0 +1,49 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import (
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+	"github.com/syncthing/syncthing/internal/upgrade"
+)
+
+// syncWindowCheckInterval is how often connections are checked against the
+// sync windows of their devices.
+const syncWindowCheckInterval = time.Minute
+
+// syncWindow returns the window during which the device may be connected to.
+func syncWindow(deviceCfg config.DeviceConfiguration) (upgrade.Window, error) {
+	return upgrade.ParseWindow(deviceCfg.SyncWindowStart, deviceCfg.SyncWindowEnd, deviceCfg.SyncWindowDays)
+}
+
+// inSyncWindow returns whether the device may be connected to at the given
+// time. A window that doesn't parse, which is warned about at startup, puts
+// no limit on the device.
+func inSyncWindow(deviceCfg config.DeviceConfiguration, t time.Time) bool {
+	w, err := syncWindow(deviceCfg)
+	return err != nil || w.Contains(t)
+}
+
+// closeSyncWindows disconnects the devices whose sync window has closed.
+// Dialing and accepting connections respect the windows by themselves.
+func (a *App) closeSyncWindows() {
+	t := time.NewTicker(syncWindowCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case now := <-t.C:
+			for _, deviceCfg := range a.cfgw.Raw().Devices {
+				if a.model.ConnectedTo(deviceCfg.DeviceID) && !inSyncWindow(deviceCfg, now) {
+					a.log.Infof("Disconnecting from %s outside its sync window", deviceCfg.DeviceID)
+					a.model.Disconnect(deviceCfg.DeviceID)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2014 Jakob Borg and Contributors (see the CONTRIBUTORS file).
+// All rights reserved. Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package syncthing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/internal/config"
+)
+
+func TestInSyncWindow(t *testing.T) {
+	at := func(h int) time.Time {
+		return time.Date(2015, 3, 2, h, 30, 0, 0, time.Local) // a Monday
+	}
+
+	cases := []struct {
+		cfg      config.DeviceConfiguration
+		hour     int
+		expected bool
+	}{
+		{config.DeviceConfiguration{}, 12, true},
+		{config.DeviceConfiguration{SyncWindowStart: "02:00", SyncWindowEnd: "06:00"}, 3, true},
+		{config.DeviceConfiguration{SyncWindowStart: "02:00", SyncWindowEnd: "06:00"}, 12, false},
+		{config.DeviceConfiguration{SyncWindowDays: "sat,sun"}, 12, false},
+		{config.DeviceConfiguration{SyncWindowStart: "22:00", SyncWindowEnd: "06:00"}, 23, true},
+		{config.DeviceConfiguration{SyncWindowStart: "bad"}, 12, true},
+	}
+
+	for i, tc := range cases {
+		if res := inSyncWindow(tc.cfg, at(tc.hour)); res != tc.expected {
+			t.Errorf("%d: in window %v, expected %v", i, res, tc.expected)
+		}
+	}
+}